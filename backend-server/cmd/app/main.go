@@ -2,21 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/cmd/config"
 	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/handlers"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/notifier"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository/plugins"
 	scheduler_worker "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/worker"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/scheduler"
@@ -27,6 +38,19 @@ func main() {
 	// 設定の読み込み
 	// ============================================
 	conf := config.LoadConfig()
+	if err := conf.Validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	// ============================================
+	// メトリクスの初期化（/metricsで公開する）
+	// ============================================
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	proxyMetrics, err := metrics.New(registry)
+	if err != nil {
+		log.Fatalf("Failed to initialize metrics: %v", err)
+	}
 
 	// ============================================
 	// 設定とインフラストラクチャの初期化
@@ -40,65 +64,161 @@ func main() {
 	})
 	redisConfig := plugins.RedisClientConfig{
 		ReservedRequestsKey: conf.RedisKeys.ReservedRequestsKey,
+		ReservedKeysKey:     conf.RedisKeys.ReservedKeysKey,
+		SentRequestsKey:     conf.RedisKeys.SentRequestsKey,
 		CacheMetaPattern:    conf.RedisKeys.CacheMetaPattern,
+		CacheIndexPrefix:    conf.RedisKeys.CacheIndexPrefix,
 		ScanCount:           conf.RedisKeys.ScanCount,
 	}
-	repoClient := plugins.NewRedisClient(redisClient, redisConfig)
-
-	// 依存関係の初期化: トランスポートモードに応じてゲートウェイを選択
-	var bpgw gateway_interface.BpGateway
-	switch conf.BPGateway.TransportMode {
-	case "bp_socket":
-		log.Printf("Using bp-socket transport (ipn:%d.%d -> ipn:%d.%d)",
-			conf.BPGateway.BpSocket.LocalNodeNum,
-			conf.BPGateway.BpSocket.LocalServiceNum,
-			conf.BPGateway.BpSocket.RemoteNodeNum,
-			conf.BPGateway.BpSocket.RemoteServiceNum)
-		var err error
-		bpgw, err = gateway.NewBpSocketGateway(
-			conf.BPGateway.BpSocket.LocalNodeNum,
-			conf.BPGateway.BpSocket.LocalServiceNum,
-			conf.BPGateway.BpSocket.RemoteNodeNum,
-			conf.BPGateway.BpSocket.RemoteServiceNum,
-			conf.BPGateway.Timeout,
-		)
-		if err != nil {
-			log.Fatalf("Failed to initialize BpSocketGateway: %v", err)
-		}
-	case "ion_cli":
-		log.Printf("Using ION CLI transport (host=%s, port=%d)", conf.BPGateway.Host, conf.BPGateway.Port)
-		bpgw = gateway.NewIonCLIGateway(conf.BPGateway.Host, conf.BPGateway.Port, conf.BPGateway.Timeout)
-	default:
-		log.Fatalf("Invalid transport mode: %s (use 'ion_cli' or 'bp_socket')", conf.BPGateway.TransportMode)
+	repoClient, err := plugins.NewRepoClient(plugins.ClientConfig{
+		Type:        string(conf.RedisClient.Type),
+		Redis:       redisClient,
+		RedisConfig: redisConfig,
+		BoltPath:    conf.RedisClient.BoltPath,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize the repository: %v", err)
+	}
+	switch conf.RedisClient.Type {
+	case config.RedisTypeMemory:
+		log.Println("Redis type memory: keeping cache metadata and reservations in memory (lost on exit)")
+	case config.RedisTypeBolt:
+		log.Printf("Redis type bolt: keeping cache metadata and reservations in %s", conf.RedisClient.BoltPath)
 	}
 
+	// 依存関係の初期化: トランスポートモードに応じてゲートウェイを選択
 	// デバッグモードの場合はローカルHTTPゲートウェイを使用
+	transportMode := conf.BPGateway.TransportMode
 	if conf.Server.Mode == config.DebugMode {
 		log.Println("Debug mode enabled: Using Local HTTP Gateway")
-		bpgw = gateway.NewLocalGateway(conf.BPGateway.Timeout)
+		transportMode = "local"
+	}
+	log.Printf("Using %s transport", transportMode)
+	gatewayEIDs, err := conf.GatewayEIDs()
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	envelopeAuth, err := conf.BPGateway.Auth.EnvelopeAuth()
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	log.Printf("Gateway EIDs: source=%s, destination=%s, receive=%s, lanes=%v", gatewayEIDs.Source, gatewayEIDs.Destination, gatewayEIDs.Receive, gatewayEIDs.Lanes)
+	// 最近の往復時間を記録し、レスポンスを待つ時間と、予約したページが届く時刻の目安に使う
+	roundTrips := gateway.NewRoundTripTracker(gateway.RoundTripPolicy{
+		Window:     conf.BPGateway.AdaptiveTimeout.Window,
+		MinSamples: conf.BPGateway.AdaptiveTimeout.MinSamples,
+		Factor:     conf.BPGateway.AdaptiveTimeout.Factor,
+		Min:        conf.BPGateway.AdaptiveTimeout.Min,
+		Max:        conf.BPGateway.AdaptiveTimeout.Max,
+	}, proxyMetrics)
+	bpgw, err := gateway.NewGateway(gateway.Config{
+		TransportMode: transportMode,
+		Timeout:       conf.BPGateway.Timeout,
+		Host:          conf.BPGateway.Host,
+		Port:          conf.BPGateway.Port,
+		EIDs:          gatewayEIDs,
+		MockLatency:   conf.BPGateway.MockLatency,
+		MockScenarios: conf.BPGateway.MockScenarios,
+		MaxInFlight:   conf.BPGateway.MaxInFlight,
+		RoundTrips:    roundTrips,
+		Retry: gateway.RetryPolicy{
+			Attempts:  conf.BPGateway.Retry.Attempts,
+			BaseDelay: conf.BPGateway.Retry.BaseDelay,
+			MaxDelay:  conf.BPGateway.Retry.MaxDelay,
+			Jitter:    conf.BPGateway.Retry.Jitter,
+		},
+		BreakerThreshold: conf.BPGateway.CircuitBreaker.FailureThreshold,
+		BreakerCoolDown:  conf.BPGateway.CircuitBreaker.CoolDown,
+		Batch: gateway.BatchPolicy{
+			MaxRequests: conf.BPGateway.Batch.MaxRequests,
+			MaxDelay:    conf.BPGateway.Batch.MaxDelay,
+		},
+		Auth: envelopeAuth,
+	}, proxyMetrics)
+	if err != nil {
+		switch {
+		case errors.Is(err, bpsocket.ErrModuleNotLoaded):
+			log.Println("Hint: the bp kernel module is not loaded (sudo insmod bp.ko) or ION is not running")
+		case errors.Is(err, bpsocket.ErrAddressInUse):
+			log.Printf("Hint: another process is already bound to %s", gatewayEIDs.Source)
+		}
+		log.Fatalf("Failed to initialize the gateway: %v", err)
+	}
+
+	// キャッシュキーを作るときにURLから取り除くトラッキング用のクエリパラメータと、クライアントのno-storeに従うか
+	// （リクエストを受け付ける前に一度だけ設定する）
+	model.StripQueryParams = conf.Cache.StripQueryParams
+	model.HonorRequestNoStore = conf.Cache.HonorClientNoStore
+
+	bprepo := repository.NewBpRepository(repoClient, conf.Cache.Dir, conf.Cache.StaleGrace, conf.Cache.MaxObjectSize, conf.Cache.MaxSize)
+	bprepo.SetCleanupBudget(conf.Cache.CleanupBudget)
+
+	// DTNへの予約キューの長さ（スクレイプのたびにRedisから数える、取得に失敗した場合は-1）
+	err = proxyMetrics.RegisterQueueDepth(func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		reserved, err := bprepo.GetReservedRequests(ctx)
+		if err != nil {
+			log.Printf("[Metrics] Failed to count reserved requests: %v", err)
+			return -1
+		}
+		return float64(len(reserved))
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize metrics: %v", err)
 	}
 
-	bprepo := repository.NewBpRepository(repoClient, conf.Cache.Dir)
+	// キャッシュ保存の通知（/system/notify）
+	// 複数インスタンスで運用する場合は、他のインスタンスが保存したキャッシュもRedisのキースペース通知で受け取る
+	cacheNotifier := notifier.NewCacheNotifier()
+	usesRedis := conf.RedisClient.Type == "" || conf.RedisClient.Type == config.RedisTypeRedis
+	if conf.RedisClient.KeyspaceNotifications && usesRedis {
+		metaKeyPrefix := strings.TrimSuffix(conf.RedisKeys.CacheMetaPattern, "*")
+		go notifier.ListenKeyspaceNotifications(context.Background(), redisClient, conf.RedisClient.DB, metaKeyPrefix, cacheNotifier)
+	}
 
 	// ============================================
 	// ミドルウェアの初期化
 	// ============================================
 
+	// 初期化に失敗してもHTTPのプロキシと/system/setup（証明書の配布）は動かすため、CONNECTだけを無効にして起動する
 	ssl_bump_app, err := module.NewSSLBumpHandler(conf.Middlware.CertPath, conf.Middlware.KeyPath, conf.Middlware.MaxCacheSize)
 	if err != nil {
-		log.Fatalf("Failed to initialize SSLBumpHandler: %v", err)
-		return
+		log.Printf("WARNING: Failed to initialize SSLBumpHandler, HTTPS (CONNECT) requests will be rejected: %v", err)
+		ssl_bump_app = nil
+	}
+	// 転送するドメインの制限（POST /system/admin/domain-filter/reload で再読み込みできる）
+	domainFilter, err := module.NewDomainFilter(conf.Middlware.DomainAllowlist, conf.Middlware.DomainBlocklist)
+	if err != nil {
+		log.Fatalf("Failed to initialize DomainFilter: %v", err)
+	}
+	// SSL Bumpせずにそのまま中継するCONNECTの接続先
+	passthrough, err := module.NewPassthrough(conf.Middlware.ConnectPassthrough, conf.Middlware.PassthroughIdleTimeout)
+	if err != nil {
+		log.Fatalf("Failed to initialize Passthrough: %v", err)
 	}
 	middlwares := middleware.NewMiddlewarePlugins(
 		ssl_bump_app,
+		domainFilter,
+		passthrough,
 	)
 
 	// ============================================
 	// アプリケーション層の初期化
 	// ============================================
 
-	bpsrv := service.NewBpService(bpgw, bprepo, conf.Server.DefaultDir, conf.Server.DefaultFileName)
-	bpHandler := handlers.NewBpHandler(bpsrv, middlwares)
+	// 予約キューでの位置から、予約したページが届く時刻の目安を計算する（ワーカーの数だけ並行してDTNへ送る）
+	// 往復時間が記録されるまではround_trip_estimateを使う
+	deliveryEstimate := model.DeliveryEstimate{RoundTrip: conf.BPGateway.RoundTripEstimate, Observed: roundTrips, Concurrency: conf.Worker.Workers}
+	bpsrv := service.NewBpService(bpgw, bprepo, conf.Server.DefaultDir, conf.Server.DefaultFileName, conf.Cache.StreamThreshold, deliveryEstimate, conf.Server.FetchImages, proxyMetrics)
+	// クライアントへ返すHTMLの加工（登録した順に適用する）
+	if conf.Server.RewriteLinks {
+		bpsrv.AddPostProcessor(service.NewLinkRewriter(), "text/html")
+	}
+	if conf.Server.BannerHTML != "" {
+		bpsrv.AddPostProcessor(service.NewBannerInjector(conf.Server.BannerHTML), "text/html")
+	}
+	bpHandler := handlers.NewBpHandler(bpsrv, middlwares, proxyMetrics, conf.BPGateway.RoundTripEstimate, conf.Server.RequestTimeout, conf.Server.MaxRequestBodySize, conf.Server.ForbidUpgrade, conf.Server.DefaultDir)
 
 	// ============================================
 	// サーバーのセットアップ
@@ -108,45 +228,76 @@ func main() {
 	r := gin.New()
 	r.Use(gin.Recovery())
 
+	// X-Request-IDを決めてcontextに付け、レスポンスで返す（予約とDTNのバンドルにも同じIDを載せる）
+	r.Use(middleware.RequestID())
+
 	// セキュリティヘッダーを追加するミドルウェア
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("X-Content-Type-Options", "nosniff")
 		c.Next()
 	})
 
-	// カスタム Logger: CONNECT メソッドの場合はログを出力しない
-	r.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		if param.Request.Method == http.MethodConnect {
-			// CONNECT のログは捨てる
-			return ""
-		}
-
-		// それ以外はデフォルトとほぼ同じ形式で出す
-		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s  %s\n",
-			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
-			param.StatusCode,
-			param.Latency,
-			param.ClientIP,
-			param.Method,
-			param.Path,
-		)
-	}))
-
-	// 管理用エンドポイント: キャッシュの一括削除
-	r.POST("/system/admin/cache/cleanup", func(c *gin.Context) {
-		ctx := c.Request.Context()
-		err := bprepo.DeleteAllCaches(ctx)
+	// アクセスログ: 1リクエストにつき1行のJSON（結果・キャッシュの扱い・リクエストIDを含む）を標準出力またはファイルに書き込む
+	var accessLog io.Writer = os.Stdout
+	if conf.Server.AccessLogPath != "" {
+		accessLogFile, err := middleware.OpenAccessLogFile(conf.Server.AccessLogPath, conf.Server.AccessLogMaxSize, conf.Server.AccessLogMaxBackups)
 		if err != nil {
-			c.JSON(500, gin.H{
-				"error":   "Failed to cleanup expired cache",
-				"message": err.Error(),
-			})
-			return
+			log.Fatalf("Failed to open access log: %v", err)
 		}
-		c.JSON(200, gin.H{
-			"message": "Expired cache cleanup completed successfully",
-		})
-	})
+		defer accessLogFile.Close()
+		accessLog = accessLogFile
+	}
+	r.Use(middleware.AccessLog(accessLog))
+
+	// 監視・ロードバランサー向けのヘルスチェック（Redis・キャッシュディレクトリ・DTNゲートウェイ）
+	r.GET("/system/health", handlers.NewHealthHandler(bprepo, bpgw).GetHealth)
+
+	// Prometheusのメトリクス（キャッシュのヒット率、予約キューの長さ、レイテンシなど）
+	r.GET("/metrics", gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+
+	// 予約したURLの状態の問い合わせ（プレースホルダーを受け取ったクライアントがポーリングする）
+	statusHandler := handlers.NewStatusHandler(bprepo, cacheNotifier, conf.Server.NotifyTimeout, deliveryEstimate)
+	r.GET("/system/status", statusHandler.GetStatus)
+	// キャッシュに保存された時点でServer-Sent Eventsで通知する（ポーリングの代わり）
+	r.GET("/system/notify", statusHandler.GetNotify)
+
+	// クライアント端末へのCA証明書の配布とインストール手順（秘密鍵は配布しない）
+	caHandler := handlers.NewCAHandler(conf.Middlware.CertPath)
+	r.GET("/system/ca.crt", caHandler.GetCACertPEM)
+	r.GET("/system/ca.der", caHandler.GetCACertDER)
+	r.GET("/system/setup", caHandler.GetSetupPage)
+
+	// クライアントのプロキシ自動設定（PAC）ファイル
+	r.GET("/system/proxy.pac", handlers.NewPACHandler(conf.Server.ProxyAdvertiseAddr, conf.Server.ProxyBypass).GetPAC)
+
+	// 管理用エンドポイント（トークンがなければループバックからのみ）
+	admin := r.Group("/system/admin", middleware.AdminAuth(conf.Server.AdminToken))
+
+	// 予約キューの一覧と予約の取り消し
+	adminHandler := handlers.NewAdminHandler(bprepo)
+	admin.GET("/reservations", adminHandler.ListReservations)
+	admin.DELETE("/reservations", adminHandler.CancelReservation)
+
+	// 送り直しの上限まで転送に失敗した予約（デッドレター）の一覧と、予約キューへの戻し
+	admin.GET("/dead-letters", adminHandler.ListDeadLetters)
+	admin.POST("/dead-letters/requeue", adminHandler.RequeueDeadLetter)
+
+	// URLのリストを事前取得として予約する（ブラウザからの予約より後に処理する）
+	admin.POST("/prefetch", adminHandler.Prefetch)
+
+	// キャッシュの一覧・使用量・確認・URL単位の削除
+	admin.GET("/cache", adminHandler.ListCaches)
+	admin.GET("/cache/stats", adminHandler.GetCacheStats)
+	admin.GET("/cache/entry", adminHandler.GetCacheEntry)
+	admin.DELETE("/cache/entry", adminHandler.PurgeCacheEntry)
+
+	// 転送するドメインの制限の確認と設定ファイルからの再読み込み
+	domainFilterHandler := handlers.NewDomainFilterHandler(domainFilter, config.LoadDomainLists)
+	admin.GET("/domain-filter", domainFilterHandler.GetDomainFilter)
+	admin.POST("/domain-filter/reload", domainFilterHandler.ReloadDomainFilter)
+
+	// 期限切れのキャッシュの削除（スケジューラーと同じく1回に削除する数に上限がある）
+	admin.POST("/cache/cleanup", adminHandler.CleanupExpiredCaches)
 
 	// CONNECTメソッドを処理するミドルウェアを追加
 	// CONNECTメソッドのリクエストは、パスがhost:port形式になる可能性があるため、
@@ -168,12 +319,43 @@ func main() {
 	// Worker Poolの起動（非同期リクエスト処理）
 	// ============================================
 	// プラグイン可能なWorker実装を使用
-	reqHandler := scheduler_worker.NewRequestHandler(bprepo, bpgw, conf.Cache.DefaultTTL)
+	ttlPolicy := model.TTLPolicy{Default: conf.Cache.DefaultTTL}
+	for _, rule := range conf.Cache.TTLRules {
+		ttlPolicy.Rules = append(ttlPolicy.Rules, model.TTLRule{Domain: rule.Domain, ContentType: rule.ContentType, TTL: rule.TTL})
+	}
+	reqHandler := scheduler_worker.NewRequestHandler(bprepo, bpgw, ttlPolicy, conf.Cache.MinTTL, conf.Cache.MaxTTL, conf.Cache.NegativeTTL, conf.Cache.NegativeStatuses, conf.Cache.PrefetchAssetsPerPage, cacheNotifier)
+	reqHandler.SetRetryPolicy(gateway.RetryPolicy{
+		Attempts:  conf.Worker.Retry.Attempts,
+		BaseDelay: conf.Worker.Retry.BaseDelay,
+		MaxDelay:  conf.Worker.Retry.MaxDelay,
+		Jitter:    conf.Worker.Retry.Jitter,
+	})
 	queueWatcher := scheduler_worker.NewQueueWatcher(bprepo, conf.Worker.QueueWatchTimeout)
 	cacheHandler := scheduler_worker.NewCacheHandler(bprepo)
-	responseWatcher := scheduler_worker.NewResponseWatcher(bpgw, bprepo)
-	processor := scheduler.NewRequestProcessor(conf.Worker.Workers, reqHandler, queueWatcher, cacheHandler, responseWatcher, conf.Cache.CleanupInterval) // 5つのworker
+	responseWatcher := scheduler_worker.NewResponseWatcher(bpgw, bprepo, ttlPolicy, cacheNotifier, proxyMetrics)
+	processor := scheduler.NewRequestProcessor(conf.Worker.Workers, reqHandler, queueWatcher, cacheHandler, responseWatcher, conf.Cache.CleanupInterval, proxyMetrics) // 5つのworker
+	if prober, ok := bpgw.(gateway_interface.Prober); ok {
+		processor.SetLinkProbe(prober, conf.BPGateway.Probe.Interval, conf.BPGateway.Probe.Timeout)
+	}
+	// ワーカーが取り出したまま処理中に止まった予約を、processing_timeoutを過ぎたらキューに戻す
+	if conf.Worker.ProcessingTimeout > 0 {
+		processor.SetQueueJanitor(scheduler_worker.NewQueueJanitor(bprepo, conf.Worker.ProcessingTimeout), conf.Worker.JanitorInterval)
+	}
 	ctx := context.Background()
+	// 予約キューをリストで持っていた以前の形式のRedisのキーを、ソート済みセットの予約キューへ移す
+	if redisRepo, ok := repoClient.(*plugins.RedisClient); ok {
+		migrated, err := redisRepo.MigrateLegacyQueue(ctx)
+		if err != nil {
+			log.Fatalf("Failed to migrate the reservation queue: %v", err)
+		}
+		if migrated > 0 {
+			log.Printf("Migrated %d reservations from the legacy list queue", migrated)
+		}
+	}
+	// 前回の起動でDTNへ送ったまま終了したリクエストを、Workerが予約を取り出す前に予約キューに戻す
+	if _, err := scheduler_worker.RecoverSentRequests(ctx, bprepo, conf.Worker.SentRequestMaxAge); err != nil {
+		log.Printf("WARNING: Failed to recover sent requests: %v", err)
+	}
 	processor.Start(ctx)
 
 	// ============================================
@@ -22,18 +22,41 @@ func LoadConfig() Config {
 	// デフォルト設定
 	defaultConfig := Config{
 		BPGateway: BpGateway{
-			TransportMode: "bp_socket", // "ion_cli" or "bp_socket"
-			Host:          "localhost",
-			Port:          8081,
-			Timeout:       5 * time.Second,
-			BpSocket: BpSocketConfig{
-				LocalNodeNum:     149,
-				LocalServiceNum:  1,
-				RemoteNodeNum:    150,
-				RemoteServiceNum: 1,
+			TransportMode:  "bp_socket", // "bp_socket"、"ion_cli"、"local"、"mock"
+			Host:           "localhost",
+			Port:           8081,
+			Timeout:        5 * time.Second,
+			SourceEID:      "ipn:149.1",
+			DestinationEID: "ipn:150.1",
+			ReceiveEID:     "ipn:149.2",
+			PriorityLanes:  map[string]int{"low": 3},
+			Retry: RetryConfig{
+				Attempts:  3,
+				BaseDelay: 500 * time.Millisecond,
+				MaxDelay:  5 * time.Second,
+				Jitter:    0.2,
 			},
+			CircuitBreaker: CircuitBreakerConfig{
+				FailureThreshold: 5,
+				CoolDown:         30 * time.Second,
+			},
+			Probe: ProbeConfig{
+				Timeout: 30 * time.Second,
+			},
+			Batch: BatchConfig{
+				MaxDelay: 50 * time.Millisecond,
+			},
+			AdaptiveTimeout: AdaptiveTimeoutConfig{
+				Window:     100,
+				MinSamples: 10,
+				Min:        30 * time.Second,
+				Max:        time.Hour,
+			},
+			RoundTripEstimate: 2 * time.Minute,
 		},
 		RedisClient: Redis{
+			Type:     RedisTypeRedis,
+			BoltPath: "./tmp/bp_repository.db",
 			Host:     "localhost",
 			Port:     6379,
 			Password: "",
@@ -41,19 +64,41 @@ func LoadConfig() Config {
 		},
 		RedisKeys: RedisKeys{
 			ReservedRequestsKey: "bp:reserved:requests",
+			ReservedKeysKey:     "bp:reserved:keys",
 			PendingRequestsKey:  "bp:pending:requests",
+			SentRequestsKey:     "bp:sent:requests",
 			CacheMetaPattern:    "bp:cache:meta:*",
+			CacheIndexPrefix:    "bp:cache:index:",
 			// ScanCount は省略可能（デフォルト値100が使用される）
 			// ScanCount:           100,
 		},
 		Cache: CacheConfig{
-			Dir:             "./tmp/bp_cache",
-			DefaultTTL:      24 * time.Hour,
-			CleanupInterval: 5 * time.Minute,
+			Dir:                   "./tmp/bp_cache",
+			DefaultTTL:            24 * time.Hour,
+			CleanupInterval:       5 * time.Minute,
+			CleanupBudget:         1000,
+			StreamThreshold:       1 << 20, // 1MiB
+			StaleGrace:            7 * 24 * time.Hour,
+			MaxObjectSize:         256 << 20, // 256MiB
+			MaxSize:               10 << 30,  // 10GiB
+			MinTTL:                time.Minute,
+			MaxTTL:                7 * 24 * time.Hour,
+			NegativeTTL:           5 * time.Minute,
+			NegativeStatuses:      []int{404, 410, 502, 503, 504},
+			PrefetchAssetsPerPage: 20,
 		},
 		Worker: WorkerConfig{
 			Workers:           10,
 			QueueWatchTimeout: 10 * time.Second,
+			SentRequestMaxAge: time.Hour,
+			ProcessingTimeout: 30 * time.Minute,
+			JanitorInterval:   time.Minute,
+			Retry: RetryConfig{
+				Attempts:  4,
+				BaseDelay: time.Minute,
+				MaxDelay:  30 * time.Minute,
+				Jitter:    0.2,
+			},
 		},
 		Middlware: MiddlewareConfig{
 			CertPath:      "./my_crt/bump.crt",
@@ -61,30 +106,64 @@ func LoadConfig() Config {
 			MaxCacheSize:  20,
 			RSABits:       2048,
 			CacheDuration: 24,
+
+			PassthroughIdleTimeout: 5 * time.Minute,
 		},
 		Server: ServerConfig{
 			Port:            8082,
 			Mode:            ProductionMode, // デフォルトはproductionモード
 			DefaultDir:      "pages",        // デフォルトページとプレースホルダーファイルのディレクトリ
 			DefaultFileName: "default.txt",  // デフォルトHTMLファイル名
+			NotifyTimeout:   10 * time.Minute,
+
+			RequestTimeout:     60 * time.Second,
+			MaxRequestBodySize: 4 << 20, // 4MiB
+
+			AccessLogMaxSize:    100 << 20, // 100MiB
+			AccessLogMaxBackups: 5,
 		},
 	}
 
 	// YAMLファイルから設定を読み込む（存在する場合）
+	conf := defaultConfig
 	configPath := getConfigPath()
 	if data, err := os.ReadFile(configPath); err == nil {
 		var yamlConfig yamlConfig
 		if err := yaml.Unmarshal(data, &yamlConfig); err == nil {
 			// YAMLから読み込んだ設定でデフォルト値をマージ
-			return mergeConfig(defaultConfig, yamlConfig.toConfig())
+			conf = mergeConfig(defaultConfig, yamlConfig.toConfig())
+		} else {
+			// YAMLのパースエラーは無視してデフォルト値を使用
+			fmt.Printf("Warning: Failed to parse config file %s: %v, using defaults\n", configPath, err)
 		}
-		// YAMLのパースエラーは無視してデフォルト値を使用
-		fmt.Printf("Warning: Failed to parse config file %s: %v, using defaults\n", configPath, err)
 	}
 
-	return defaultConfig
+	// 管理用トークンは設定ファイルに書かずに環境変数で渡せるようにする（環境変数を優先）
+	if token := os.Getenv(AdminTokenEnv); token != "" {
+		conf.Server.AdminToken = token
+	}
+
+	return conf
 }
 
+// LoadDomainLists 設定ファイルからドメインの許可リストとブロックリストだけを読み直す（再起動せずに反映するため）
+// LoadConfigと異なり、ファイルが読めない・パースできない場合はエラーを返す（誤ってリストを空にしないため）
+func LoadDomainLists() (allow, block []string, err error) {
+	configPath := getConfigPath()
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file %s: %w", configPath, err)
+	}
+	var yamlConfig yamlConfig
+	if err := yaml.Unmarshal(data, &yamlConfig); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
+	}
+	return yamlConfig.Middlware.DomainAllowlist, yamlConfig.Middlware.DomainBlocklist, nil
+}
+
+// AdminTokenEnv 管理用エンドポイントのトークンを指定する環境変数
+const AdminTokenEnv = "BP_ADMIN_TOKEN"
+
 // getConfigPath 設定ファイルのパスを取得
 // 環境変数 CONFIG_PATH が設定されている場合はそれを使用
 // それ以外は config.yaml を探す
@@ -98,37 +177,102 @@ func getConfigPath() string {
 // yamlConfig YAMLファイル用の一時的な構造体（time.Durationを文字列として読み込む）
 type yamlConfig struct {
 	BPGateway struct {
-		TransportMode string `yaml:"transport_mode"`
-		Host          string `yaml:"host"`
-		Port          int    `yaml:"port"`
-		Timeout       string `yaml:"timeout"`
-		BpSocket      struct {
-			LocalNodeNum     uint64 `yaml:"local_node_num"`
-			LocalServiceNum  uint64 `yaml:"local_service_num"`
-			RemoteNodeNum    uint64 `yaml:"remote_node_num"`
-			RemoteServiceNum uint64 `yaml:"remote_service_num"`
-		} `yaml:"bp_socket"`
+		TransportMode     string         `yaml:"transport_mode"`
+		Host              string         `yaml:"host"`
+		Port              int            `yaml:"port"`
+		Timeout           string         `yaml:"timeout"`
+		RoundTripEstimate string         `yaml:"round_trip_estimate"`
+		MockLatency       string         `yaml:"mock_latency"`
+		MockScenarios     string         `yaml:"mock_scenarios"`
+		MaxInFlight       int            `yaml:"max_in_flight"`
+		SourceEID         string         `yaml:"source_eid"`
+		DestinationEID    string         `yaml:"destination_eid"`
+		ReceiveEID        string         `yaml:"receive_eid"`
+		PriorityLanes     map[string]int `yaml:"priority_lanes"`
+		Retry             struct {
+			Attempts  int     `yaml:"attempts"`
+			BaseDelay string  `yaml:"base_delay"`
+			MaxDelay  string  `yaml:"max_delay"`
+			Jitter    float64 `yaml:"jitter"`
+		} `yaml:"retry"`
+		CircuitBreaker struct {
+			FailureThreshold int    `yaml:"failure_threshold"`
+			CoolDown         string `yaml:"cool_down"`
+		} `yaml:"circuit_breaker"`
+		Probe struct {
+			Interval string `yaml:"interval"`
+			Timeout  string `yaml:"timeout"`
+		} `yaml:"probe"`
+		Batch struct {
+			MaxRequests int    `yaml:"max_requests"`
+			MaxDelay    string `yaml:"max_delay"`
+		} `yaml:"batch"`
+		Auth struct {
+			VerifyKeys     []string `yaml:"verify_keys"`
+			RequireMAC     bool     `yaml:"require_mac"`
+			EncryptionKeys []string `yaml:"encryption_keys"`
+		} `yaml:"auth"`
+		AdaptiveTimeout struct {
+			Window     int     `yaml:"window"`
+			MinSamples int     `yaml:"min_samples"`
+			Factor     float64 `yaml:"factor"`
+			Min        string  `yaml:"min"`
+			Max        string  `yaml:"max"`
+		} `yaml:"adaptive_timeout"`
 	} `yaml:"bp_gateway"`
 	RedisClient struct {
+		Type     string `yaml:"type"`
+		BoltPath string `yaml:"bolt_path"`
 		Host     string `yaml:"host"`
 		Port     int    `yaml:"port"`
 		Password string `yaml:"password"`
 		DB       int    `yaml:"db"`
+
+		KeyspaceNotifications bool `yaml:"keyspace_notifications"`
 	} `yaml:"redis_client"`
 	RedisKeys struct {
 		ReservedRequestsKey string `yaml:"reserved_requests_key"`
+		ReservedKeysKey     string `yaml:"reserved_keys_key"`
 		PendingRequestsKey  string `yaml:"pending_requests_key"`
+		SentRequestsKey     string `yaml:"sent_requests_key"`
 		CacheMetaPattern    string `yaml:"cache_meta_pattern"`
+		CacheIndexPrefix    string `yaml:"cache_index_prefix"`
 		ScanCount           int    `yaml:"scan_count"`
 	} `yaml:"redis_keys"`
 	Cache struct {
-		Dir             string `yaml:"dir"`
-		DefaultTTL      string `yaml:"default_ttl"`
-		CleanupInterval string `yaml:"cleanup_interval"`
+		Dir                   string   `yaml:"dir"`
+		DefaultTTL            string   `yaml:"default_ttl"`
+		CleanupInterval       string   `yaml:"cleanup_interval"`
+		CleanupBudget         int      `yaml:"cleanup_budget"`
+		StreamThreshold       int64    `yaml:"stream_threshold"`
+		StaleGrace            string   `yaml:"stale_grace"`
+		MaxObjectSize         int64    `yaml:"max_object_size"`
+		MaxSize               int64    `yaml:"max_size"`
+		MinTTL                string   `yaml:"min_ttl"`
+		MaxTTL                string   `yaml:"max_ttl"`
+		NegativeTTL           string   `yaml:"negative_ttl"`
+		NegativeStatuses      []int    `yaml:"negative_statuses"`
+		StripQueryParams      []string `yaml:"strip_query_params"`
+		HonorClientNoStore    bool     `yaml:"honor_client_no_store"`
+		PrefetchAssetsPerPage int      `yaml:"prefetch_assets_per_page"`
+		TTLRules              []struct {
+			Domain      string `yaml:"domain"`
+			ContentType string `yaml:"content_type"`
+			TTL         string `yaml:"ttl"`
+		} `yaml:"ttl_rules"`
 	} `yaml:"cache"`
 	Worker struct {
 		Workers           int    `yaml:"workers"`
 		QueueWatchTimeout string `yaml:"queue_watch_timeout"`
+		SentRequestMaxAge string `yaml:"sent_request_max_age"`
+		ProcessingTimeout string `yaml:"processing_timeout"`
+		JanitorInterval   string `yaml:"janitor_interval"`
+		Retry             struct {
+			Attempts  int     `yaml:"attempts"`
+			BaseDelay string  `yaml:"base_delay"`
+			MaxDelay  string  `yaml:"max_delay"`
+			Jitter    float64 `yaml:"jitter"`
+		} `yaml:"retry"`
 	} `yaml:"worker"`
 	Middlware struct {
 		CertPath      string `yaml:"cert_path"`
@@ -136,12 +280,36 @@ type yamlConfig struct {
 		MaxCacheSize  int    `yaml:"max_cache_size"`
 		RSABits       int    `yaml:"rsa_bits"`
 		CacheDuration int    `yaml:"cache_duration"`
+
+		DomainAllowlist []string `yaml:"domain_allowlist"`
+		DomainBlocklist []string `yaml:"domain_blocklist"`
+
+		ConnectPassthrough     []string `yaml:"connect_passthrough"`
+		PassthroughIdleTimeout string   `yaml:"passthrough_idle_timeout"`
 	} `yaml:"middleware"`
 	Server struct {
 		Port            int    `yaml:"port"`
 		Mode            string `yaml:"mode"`
 		DefaultDir      string `yaml:"default_dir"`
 		DefaultFileName string `yaml:"default_file_name"`
+		NotifyTimeout   string `yaml:"notify_timeout"`
+
+		RequestTimeout     string `yaml:"request_timeout"`
+		MaxRequestBodySize int64  `yaml:"max_request_body_size"`
+		ForbidUpgrade      bool   `yaml:"forbid_upgrade"`
+
+		RewriteLinks bool   `yaml:"rewrite_links"`
+		BannerHTML   string `yaml:"banner_html"`
+
+		FetchImages bool `yaml:"fetch_images"`
+
+		ProxyAdvertiseAddr string   `yaml:"proxy_advertise_addr"`
+		ProxyBypass        []string `yaml:"proxy_bypass"`
+		AdminToken         string   `yaml:"admin_token"`
+
+		AccessLogPath       string `yaml:"access_log_path"`
+		AccessLogMaxSize    int64  `yaml:"access_log_max_size"`
+		AccessLogMaxBackups int    `yaml:"access_log_max_backups"`
 	} `yaml:"server"`
 }
 
@@ -165,38 +333,108 @@ func (yc yamlConfig) toConfig() Config {
 		mode = ProductionMode
 	}
 
+	ttlRules := make([]TTLRule, 0, len(yc.Cache.TTLRules))
+	for _, rule := range yc.Cache.TTLRules {
+		ttlRules = append(ttlRules, TTLRule{
+			Domain:      rule.Domain,
+			ContentType: rule.ContentType,
+			TTL:         parseDuration(rule.TTL),
+		})
+	}
+
 	return Config{
 		BPGateway: BpGateway{
-			TransportMode: yc.BPGateway.TransportMode,
-			Host:          yc.BPGateway.Host,
-			Port:          yc.BPGateway.Port,
-			Timeout:       parseDuration(yc.BPGateway.Timeout),
-			BpSocket: BpSocketConfig{
-				LocalNodeNum:     yc.BPGateway.BpSocket.LocalNodeNum,
-				LocalServiceNum:  yc.BPGateway.BpSocket.LocalServiceNum,
-				RemoteNodeNum:    yc.BPGateway.BpSocket.RemoteNodeNum,
-				RemoteServiceNum: yc.BPGateway.BpSocket.RemoteServiceNum,
+			TransportMode:     yc.BPGateway.TransportMode,
+			Host:              yc.BPGateway.Host,
+			Port:              yc.BPGateway.Port,
+			Timeout:           parseDuration(yc.BPGateway.Timeout),
+			RoundTripEstimate: parseDuration(yc.BPGateway.RoundTripEstimate),
+			MockLatency:       parseDuration(yc.BPGateway.MockLatency),
+			MockScenarios:     yc.BPGateway.MockScenarios,
+			MaxInFlight:       yc.BPGateway.MaxInFlight,
+			SourceEID:         yc.BPGateway.SourceEID,
+			DestinationEID:    yc.BPGateway.DestinationEID,
+			ReceiveEID:        yc.BPGateway.ReceiveEID,
+			PriorityLanes:     yc.BPGateway.PriorityLanes,
+			Retry: RetryConfig{
+				Attempts:  yc.BPGateway.Retry.Attempts,
+				BaseDelay: parseDuration(yc.BPGateway.Retry.BaseDelay),
+				MaxDelay:  parseDuration(yc.BPGateway.Retry.MaxDelay),
+				Jitter:    yc.BPGateway.Retry.Jitter,
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				FailureThreshold: yc.BPGateway.CircuitBreaker.FailureThreshold,
+				CoolDown:         parseDuration(yc.BPGateway.CircuitBreaker.CoolDown),
+			},
+			Probe: ProbeConfig{
+				Interval: parseDuration(yc.BPGateway.Probe.Interval),
+				Timeout:  parseDuration(yc.BPGateway.Probe.Timeout),
+			},
+			Batch: BatchConfig{
+				MaxRequests: yc.BPGateway.Batch.MaxRequests,
+				MaxDelay:    parseDuration(yc.BPGateway.Batch.MaxDelay),
+			},
+			Auth: AuthConfig{
+				VerifyKeys:     yc.BPGateway.Auth.VerifyKeys,
+				RequireMAC:     yc.BPGateway.Auth.RequireMAC,
+				EncryptionKeys: yc.BPGateway.Auth.EncryptionKeys,
+			},
+			AdaptiveTimeout: AdaptiveTimeoutConfig{
+				Window:     yc.BPGateway.AdaptiveTimeout.Window,
+				MinSamples: yc.BPGateway.AdaptiveTimeout.MinSamples,
+				Factor:     yc.BPGateway.AdaptiveTimeout.Factor,
+				Min:        parseDuration(yc.BPGateway.AdaptiveTimeout.Min),
+				Max:        parseDuration(yc.BPGateway.AdaptiveTimeout.Max),
 			},
 		},
 		RedisClient: Redis{
+			Type:     RedisType(yc.RedisClient.Type),
+			BoltPath: yc.RedisClient.BoltPath,
 			Host:     yc.RedisClient.Host,
 			Port:     yc.RedisClient.Port,
 			Password: yc.RedisClient.Password,
 			DB:       yc.RedisClient.DB,
+
+			KeyspaceNotifications: yc.RedisClient.KeyspaceNotifications,
 		},
 		RedisKeys: RedisKeys{
 			ReservedRequestsKey: yc.RedisKeys.ReservedRequestsKey,
+			ReservedKeysKey:     yc.RedisKeys.ReservedKeysKey,
+			SentRequestsKey:     yc.RedisKeys.SentRequestsKey,
 			CacheMetaPattern:    yc.RedisKeys.CacheMetaPattern,
+			CacheIndexPrefix:    yc.RedisKeys.CacheIndexPrefix,
 			ScanCount:           yc.RedisKeys.ScanCount,
 		},
 		Cache: CacheConfig{
-			Dir:             yc.Cache.Dir,
-			DefaultTTL:      parseDuration(yc.Cache.DefaultTTL),
-			CleanupInterval: parseDuration(yc.Cache.CleanupInterval),
+			Dir:                   yc.Cache.Dir,
+			DefaultTTL:            parseDuration(yc.Cache.DefaultTTL),
+			CleanupInterval:       parseDuration(yc.Cache.CleanupInterval),
+			CleanupBudget:         yc.Cache.CleanupBudget,
+			StreamThreshold:       yc.Cache.StreamThreshold,
+			StaleGrace:            parseDuration(yc.Cache.StaleGrace),
+			MaxObjectSize:         yc.Cache.MaxObjectSize,
+			MaxSize:               yc.Cache.MaxSize,
+			MinTTL:                parseDuration(yc.Cache.MinTTL),
+			MaxTTL:                parseDuration(yc.Cache.MaxTTL),
+			NegativeTTL:           parseDuration(yc.Cache.NegativeTTL),
+			NegativeStatuses:      yc.Cache.NegativeStatuses,
+			StripQueryParams:      yc.Cache.StripQueryParams,
+			TTLRules:              ttlRules,
+			HonorClientNoStore:    yc.Cache.HonorClientNoStore,
+			PrefetchAssetsPerPage: yc.Cache.PrefetchAssetsPerPage,
 		},
 		Worker: WorkerConfig{
 			Workers:           yc.Worker.Workers,
 			QueueWatchTimeout: parseDuration(yc.Worker.QueueWatchTimeout),
+			SentRequestMaxAge: parseDuration(yc.Worker.SentRequestMaxAge),
+			ProcessingTimeout: parseDuration(yc.Worker.ProcessingTimeout),
+			JanitorInterval:   parseDuration(yc.Worker.JanitorInterval),
+			Retry: RetryConfig{
+				Attempts:  yc.Worker.Retry.Attempts,
+				BaseDelay: parseDuration(yc.Worker.Retry.BaseDelay),
+				MaxDelay:  parseDuration(yc.Worker.Retry.MaxDelay),
+				Jitter:    yc.Worker.Retry.Jitter,
+			},
 		},
 		Middlware: MiddlewareConfig{
 			CertPath:      yc.Middlware.CertPath,
@@ -204,12 +442,36 @@ func (yc yamlConfig) toConfig() Config {
 			MaxCacheSize:  yc.Middlware.MaxCacheSize,
 			RSABits:       yc.Middlware.RSABits,
 			CacheDuration: yc.Middlware.CacheDuration,
+
+			DomainAllowlist: yc.Middlware.DomainAllowlist,
+			DomainBlocklist: yc.Middlware.DomainBlocklist,
+
+			ConnectPassthrough:     yc.Middlware.ConnectPassthrough,
+			PassthroughIdleTimeout: parseDuration(yc.Middlware.PassthroughIdleTimeout),
 		},
 		Server: ServerConfig{
 			Port:            yc.Server.Port,
 			Mode:            mode,
 			DefaultDir:      yc.Server.DefaultDir,
 			DefaultFileName: yc.Server.DefaultFileName,
+			NotifyTimeout:   parseDuration(yc.Server.NotifyTimeout),
+
+			RequestTimeout:     parseDuration(yc.Server.RequestTimeout),
+			MaxRequestBodySize: yc.Server.MaxRequestBodySize,
+			ForbidUpgrade:      yc.Server.ForbidUpgrade,
+
+			RewriteLinks: yc.Server.RewriteLinks,
+			BannerHTML:   yc.Server.BannerHTML,
+
+			FetchImages: yc.Server.FetchImages,
+
+			ProxyAdvertiseAddr: yc.Server.ProxyAdvertiseAddr,
+			ProxyBypass:        yc.Server.ProxyBypass,
+			AdminToken:         yc.Server.AdminToken,
+
+			AccessLogPath:       yc.Server.AccessLogPath,
+			AccessLogMaxSize:    yc.Server.AccessLogMaxSize,
+			AccessLogMaxBackups: yc.Server.AccessLogMaxBackups,
 		},
 	}
 }
@@ -232,20 +494,93 @@ func mergeConfig(defaultConfig, yamlConfig Config) Config {
 	if yamlConfig.BPGateway.Timeout != 0 {
 		merged.BPGateway.Timeout = yamlConfig.BPGateway.Timeout
 	}
-	if yamlConfig.BPGateway.BpSocket.LocalNodeNum != 0 {
-		merged.BPGateway.BpSocket.LocalNodeNum = yamlConfig.BPGateway.BpSocket.LocalNodeNum
+	if yamlConfig.BPGateway.RoundTripEstimate != 0 {
+		merged.BPGateway.RoundTripEstimate = yamlConfig.BPGateway.RoundTripEstimate
+	}
+	if yamlConfig.BPGateway.MockLatency != 0 {
+		merged.BPGateway.MockLatency = yamlConfig.BPGateway.MockLatency
+	}
+	if yamlConfig.BPGateway.MockScenarios != "" {
+		merged.BPGateway.MockScenarios = yamlConfig.BPGateway.MockScenarios
+	}
+	if yamlConfig.BPGateway.MaxInFlight != 0 {
+		merged.BPGateway.MaxInFlight = yamlConfig.BPGateway.MaxInFlight
+	}
+	if yamlConfig.BPGateway.SourceEID != "" {
+		merged.BPGateway.SourceEID = yamlConfig.BPGateway.SourceEID
+	}
+	if yamlConfig.BPGateway.DestinationEID != "" {
+		merged.BPGateway.DestinationEID = yamlConfig.BPGateway.DestinationEID
+	}
+	if yamlConfig.BPGateway.ReceiveEID != "" {
+		merged.BPGateway.ReceiveEID = yamlConfig.BPGateway.ReceiveEID
+	}
+	// 空のpriority_lanes（{}）はレーンを使わない
+	if yamlConfig.BPGateway.PriorityLanes != nil {
+		merged.BPGateway.PriorityLanes = yamlConfig.BPGateway.PriorityLanes
+	}
+	if yamlConfig.BPGateway.Retry.Attempts != 0 {
+		merged.BPGateway.Retry.Attempts = yamlConfig.BPGateway.Retry.Attempts
+	}
+	if yamlConfig.BPGateway.Retry.BaseDelay != 0 {
+		merged.BPGateway.Retry.BaseDelay = yamlConfig.BPGateway.Retry.BaseDelay
+	}
+	if yamlConfig.BPGateway.Retry.MaxDelay != 0 {
+		merged.BPGateway.Retry.MaxDelay = yamlConfig.BPGateway.Retry.MaxDelay
+	}
+	if yamlConfig.BPGateway.Retry.Jitter != 0 {
+		merged.BPGateway.Retry.Jitter = yamlConfig.BPGateway.Retry.Jitter
+	}
+	if yamlConfig.BPGateway.CircuitBreaker.FailureThreshold != 0 {
+		merged.BPGateway.CircuitBreaker.FailureThreshold = yamlConfig.BPGateway.CircuitBreaker.FailureThreshold
+	}
+	if yamlConfig.BPGateway.CircuitBreaker.CoolDown != 0 {
+		merged.BPGateway.CircuitBreaker.CoolDown = yamlConfig.BPGateway.CircuitBreaker.CoolDown
 	}
-	if yamlConfig.BPGateway.BpSocket.LocalServiceNum != 0 {
-		merged.BPGateway.BpSocket.LocalServiceNum = yamlConfig.BPGateway.BpSocket.LocalServiceNum
+	if yamlConfig.BPGateway.Probe.Interval != 0 {
+		merged.BPGateway.Probe.Interval = yamlConfig.BPGateway.Probe.Interval
 	}
-	if yamlConfig.BPGateway.BpSocket.RemoteNodeNum != 0 {
-		merged.BPGateway.BpSocket.RemoteNodeNum = yamlConfig.BPGateway.BpSocket.RemoteNodeNum
+	if yamlConfig.BPGateway.Probe.Timeout != 0 {
+		merged.BPGateway.Probe.Timeout = yamlConfig.BPGateway.Probe.Timeout
 	}
-	if yamlConfig.BPGateway.BpSocket.RemoteServiceNum != 0 {
-		merged.BPGateway.BpSocket.RemoteServiceNum = yamlConfig.BPGateway.BpSocket.RemoteServiceNum
+	if yamlConfig.BPGateway.Batch.MaxRequests != 0 {
+		merged.BPGateway.Batch.MaxRequests = yamlConfig.BPGateway.Batch.MaxRequests
+	}
+	if yamlConfig.BPGateway.Batch.MaxDelay != 0 {
+		merged.BPGateway.Batch.MaxDelay = yamlConfig.BPGateway.Batch.MaxDelay
+	}
+	if len(yamlConfig.BPGateway.Auth.VerifyKeys) > 0 {
+		merged.BPGateway.Auth.VerifyKeys = yamlConfig.BPGateway.Auth.VerifyKeys
+	}
+	if yamlConfig.BPGateway.Auth.RequireMAC {
+		merged.BPGateway.Auth.RequireMAC = true
+	}
+	if len(yamlConfig.BPGateway.Auth.EncryptionKeys) > 0 {
+		merged.BPGateway.Auth.EncryptionKeys = yamlConfig.BPGateway.Auth.EncryptionKeys
+	}
+	if yamlConfig.BPGateway.AdaptiveTimeout.Window != 0 {
+		merged.BPGateway.AdaptiveTimeout.Window = yamlConfig.BPGateway.AdaptiveTimeout.Window
+	}
+	if yamlConfig.BPGateway.AdaptiveTimeout.MinSamples != 0 {
+		merged.BPGateway.AdaptiveTimeout.MinSamples = yamlConfig.BPGateway.AdaptiveTimeout.MinSamples
+	}
+	if yamlConfig.BPGateway.AdaptiveTimeout.Factor != 0 {
+		merged.BPGateway.AdaptiveTimeout.Factor = yamlConfig.BPGateway.AdaptiveTimeout.Factor
+	}
+	if yamlConfig.BPGateway.AdaptiveTimeout.Min != 0 {
+		merged.BPGateway.AdaptiveTimeout.Min = yamlConfig.BPGateway.AdaptiveTimeout.Min
+	}
+	if yamlConfig.BPGateway.AdaptiveTimeout.Max != 0 {
+		merged.BPGateway.AdaptiveTimeout.Max = yamlConfig.BPGateway.AdaptiveTimeout.Max
 	}
 
 	// RedisClient
+	if yamlConfig.RedisClient.Type != "" {
+		merged.RedisClient.Type = yamlConfig.RedisClient.Type
+	}
+	if yamlConfig.RedisClient.BoltPath != "" {
+		merged.RedisClient.BoltPath = yamlConfig.RedisClient.BoltPath
+	}
 	if yamlConfig.RedisClient.Host != "" {
 		merged.RedisClient.Host = yamlConfig.RedisClient.Host
 	}
@@ -259,16 +594,29 @@ func mergeConfig(defaultConfig, yamlConfig Config) Config {
 		merged.RedisClient.DB = yamlConfig.RedisClient.DB
 	}
 
+	if yamlConfig.RedisClient.KeyspaceNotifications {
+		merged.RedisClient.KeyspaceNotifications = true
+	}
+
 	// RedisKeys
 	if yamlConfig.RedisKeys.ReservedRequestsKey != "" {
 		merged.RedisKeys.ReservedRequestsKey = yamlConfig.RedisKeys.ReservedRequestsKey
 	}
+	if yamlConfig.RedisKeys.ReservedKeysKey != "" {
+		merged.RedisKeys.ReservedKeysKey = yamlConfig.RedisKeys.ReservedKeysKey
+	}
 	if yamlConfig.RedisKeys.PendingRequestsKey != "" {
 		merged.RedisKeys.PendingRequestsKey = yamlConfig.RedisKeys.PendingRequestsKey
 	}
+	if yamlConfig.RedisKeys.SentRequestsKey != "" {
+		merged.RedisKeys.SentRequestsKey = yamlConfig.RedisKeys.SentRequestsKey
+	}
 	if yamlConfig.RedisKeys.CacheMetaPattern != "" {
 		merged.RedisKeys.CacheMetaPattern = yamlConfig.RedisKeys.CacheMetaPattern
 	}
+	if yamlConfig.RedisKeys.CacheIndexPrefix != "" {
+		merged.RedisKeys.CacheIndexPrefix = yamlConfig.RedisKeys.CacheIndexPrefix
+	}
 	if yamlConfig.RedisKeys.ScanCount != 0 {
 		merged.RedisKeys.ScanCount = yamlConfig.RedisKeys.ScanCount
 	}
@@ -283,6 +631,45 @@ func mergeConfig(defaultConfig, yamlConfig Config) Config {
 	if yamlConfig.Cache.CleanupInterval != 0 {
 		merged.Cache.CleanupInterval = yamlConfig.Cache.CleanupInterval
 	}
+	if yamlConfig.Cache.CleanupBudget != 0 {
+		merged.Cache.CleanupBudget = yamlConfig.Cache.CleanupBudget
+	}
+	if yamlConfig.Cache.StreamThreshold != 0 {
+		merged.Cache.StreamThreshold = yamlConfig.Cache.StreamThreshold
+	}
+	if yamlConfig.Cache.StaleGrace != 0 {
+		merged.Cache.StaleGrace = yamlConfig.Cache.StaleGrace
+	}
+	if yamlConfig.Cache.MaxObjectSize != 0 {
+		merged.Cache.MaxObjectSize = yamlConfig.Cache.MaxObjectSize
+	}
+	if yamlConfig.Cache.MaxSize != 0 {
+		merged.Cache.MaxSize = yamlConfig.Cache.MaxSize
+	}
+	if yamlConfig.Cache.MinTTL != 0 {
+		merged.Cache.MinTTL = yamlConfig.Cache.MinTTL
+	}
+	if yamlConfig.Cache.MaxTTL != 0 {
+		merged.Cache.MaxTTL = yamlConfig.Cache.MaxTTL
+	}
+	if yamlConfig.Cache.NegativeTTL != 0 {
+		merged.Cache.NegativeTTL = yamlConfig.Cache.NegativeTTL
+	}
+	if len(yamlConfig.Cache.NegativeStatuses) > 0 {
+		merged.Cache.NegativeStatuses = yamlConfig.Cache.NegativeStatuses
+	}
+	if len(yamlConfig.Cache.StripQueryParams) > 0 {
+		merged.Cache.StripQueryParams = yamlConfig.Cache.StripQueryParams
+	}
+	if len(yamlConfig.Cache.TTLRules) > 0 {
+		merged.Cache.TTLRules = yamlConfig.Cache.TTLRules
+	}
+	if yamlConfig.Cache.PrefetchAssetsPerPage != 0 {
+		merged.Cache.PrefetchAssetsPerPage = yamlConfig.Cache.PrefetchAssetsPerPage
+	}
+	if yamlConfig.Cache.HonorClientNoStore {
+		merged.Cache.HonorClientNoStore = true
+	}
 
 	// Worker
 	if yamlConfig.Worker.Workers != 0 {
@@ -291,6 +678,27 @@ func mergeConfig(defaultConfig, yamlConfig Config) Config {
 	if yamlConfig.Worker.QueueWatchTimeout != 0 {
 		merged.Worker.QueueWatchTimeout = yamlConfig.Worker.QueueWatchTimeout
 	}
+	if yamlConfig.Worker.SentRequestMaxAge != 0 {
+		merged.Worker.SentRequestMaxAge = yamlConfig.Worker.SentRequestMaxAge
+	}
+	if yamlConfig.Worker.ProcessingTimeout != 0 {
+		merged.Worker.ProcessingTimeout = yamlConfig.Worker.ProcessingTimeout
+	}
+	if yamlConfig.Worker.JanitorInterval != 0 {
+		merged.Worker.JanitorInterval = yamlConfig.Worker.JanitorInterval
+	}
+	if yamlConfig.Worker.Retry.Attempts != 0 {
+		merged.Worker.Retry.Attempts = yamlConfig.Worker.Retry.Attempts
+	}
+	if yamlConfig.Worker.Retry.BaseDelay != 0 {
+		merged.Worker.Retry.BaseDelay = yamlConfig.Worker.Retry.BaseDelay
+	}
+	if yamlConfig.Worker.Retry.MaxDelay != 0 {
+		merged.Worker.Retry.MaxDelay = yamlConfig.Worker.Retry.MaxDelay
+	}
+	if yamlConfig.Worker.Retry.Jitter != 0 {
+		merged.Worker.Retry.Jitter = yamlConfig.Worker.Retry.Jitter
+	}
 
 	// Middleware
 	if yamlConfig.Middlware.CertPath != "" {
@@ -308,6 +716,18 @@ func mergeConfig(defaultConfig, yamlConfig Config) Config {
 	if yamlConfig.Middlware.CacheDuration != 0 {
 		merged.Middlware.CacheDuration = yamlConfig.Middlware.CacheDuration
 	}
+	if len(yamlConfig.Middlware.DomainAllowlist) > 0 {
+		merged.Middlware.DomainAllowlist = yamlConfig.Middlware.DomainAllowlist
+	}
+	if len(yamlConfig.Middlware.DomainBlocklist) > 0 {
+		merged.Middlware.DomainBlocklist = yamlConfig.Middlware.DomainBlocklist
+	}
+	if len(yamlConfig.Middlware.ConnectPassthrough) > 0 {
+		merged.Middlware.ConnectPassthrough = yamlConfig.Middlware.ConnectPassthrough
+	}
+	if yamlConfig.Middlware.PassthroughIdleTimeout != 0 {
+		merged.Middlware.PassthroughIdleTimeout = yamlConfig.Middlware.PassthroughIdleTimeout
+	}
 
 	// Server
 	if yamlConfig.Server.Port != 0 {
@@ -322,6 +742,45 @@ func mergeConfig(defaultConfig, yamlConfig Config) Config {
 	if yamlConfig.Server.DefaultFileName != "" {
 		merged.Server.DefaultFileName = yamlConfig.Server.DefaultFileName
 	}
+	if yamlConfig.Server.NotifyTimeout != 0 {
+		merged.Server.NotifyTimeout = yamlConfig.Server.NotifyTimeout
+	}
+	if yamlConfig.Server.RequestTimeout != 0 {
+		merged.Server.RequestTimeout = yamlConfig.Server.RequestTimeout
+	}
+	if yamlConfig.Server.MaxRequestBodySize != 0 {
+		merged.Server.MaxRequestBodySize = yamlConfig.Server.MaxRequestBodySize
+	}
+	if yamlConfig.Server.ForbidUpgrade {
+		merged.Server.ForbidUpgrade = true
+	}
+	if yamlConfig.Server.RewriteLinks {
+		merged.Server.RewriteLinks = true
+	}
+	if yamlConfig.Server.BannerHTML != "" {
+		merged.Server.BannerHTML = yamlConfig.Server.BannerHTML
+	}
+	if yamlConfig.Server.FetchImages {
+		merged.Server.FetchImages = true
+	}
+	if yamlConfig.Server.ProxyAdvertiseAddr != "" {
+		merged.Server.ProxyAdvertiseAddr = yamlConfig.Server.ProxyAdvertiseAddr
+	}
+	if len(yamlConfig.Server.ProxyBypass) > 0 {
+		merged.Server.ProxyBypass = yamlConfig.Server.ProxyBypass
+	}
+	if yamlConfig.Server.AdminToken != "" {
+		merged.Server.AdminToken = yamlConfig.Server.AdminToken
+	}
+	if yamlConfig.Server.AccessLogPath != "" {
+		merged.Server.AccessLogPath = yamlConfig.Server.AccessLogPath
+	}
+	if yamlConfig.Server.AccessLogMaxSize != 0 {
+		merged.Server.AccessLogMaxSize = yamlConfig.Server.AccessLogMaxSize
+	}
+	if yamlConfig.Server.AccessLogMaxBackups != 0 {
+		merged.Server.AccessLogMaxBackups = yamlConfig.Server.AccessLogMaxBackups
+	}
 
 	return merged
 }
@@ -4,46 +4,206 @@ import "time"
 
 // BpGateway BPゲートウェイの設定
 type BpGateway struct {
-	TransportMode string         `yaml:"transport_mode"` // "http" or "bp"
-	Host          string         `yaml:"host"`           // HTTPモード時のホスト
-	Port          int            `yaml:"port"`           // HTTPモード時のポート
-	Timeout       time.Duration  `yaml:"timeout"`        // タイムアウト
-	BpSocket      BpSocketConfig `yaml:"bp_socket"`      // BPモード時の設定
+	TransportMode string        `yaml:"transport_mode"` // "bp_socket"（別名"bpsocket"）、"ion_cli"（別名"bpfile"）、"local"（DTNを使わず直接HTTP）、"mock"（固定のレスポンス）
+	Host          string        `yaml:"host"`           // ion_cliモード時のホスト
+	Port          int           `yaml:"port"`           // ion_cliモード時のポート
+	Timeout       time.Duration `yaml:"timeout"`        // タイムアウト
+
+	// SourceEID・DestinationEID・ReceiveEID バンドルを送るEID、地上局のEID、ion_cliでレスポンスを受信するEID（ipn:<node>.<service>）
+	// bp_socketは送信元のEIDで受信するため、ReceiveEIDはion_cliでだけ使う。起動時にValidateで形式と重なりを確かめる
+	SourceEID      string `yaml:"source_eid"`
+	DestinationEID string `yaml:"destination_eid"`
+	ReceiveEID     string `yaml:"receive_eid"`
+
+	// PriorityLanes 優先度（high、low）ごとにバンドルを送る、SourceEIDと同じノードのサービス番号
+	// 事前取得（low）をブラウザが待っているリクエスト（high）と別のエンドポイントから送り、事前取得の大量のバンドルの後ろに並ばないようにする
+	// 指定しない優先度はSourceEIDから送る
+	PriorityLanes map[string]int `yaml:"priority_lanes"`
+
+	// MockLatency mockモードでレスポンスを返すまでの時間（DTNの往復時間の代わり）
+	MockLatency time.Duration `yaml:"mock_latency"`
+	// MockScenarios mockモードでURLごとのレスポンス・遅延・失敗・後から届くレスポンスを決めるJSONのファイル（空の場合はすべてのURLに同じHTMLを返す）
+	MockScenarios string `yaml:"mock_scenarios"`
+
+	// MaxInFlight 同時にDTNへ転送する（レスポンスを待つ）リクエストの数の上限（0以下は制限しない）
+	// 上限に達している間、ワーカーは空きができるまで待つ（IONのSDRがあふれないようにする）
+	MaxInFlight int `yaml:"max_in_flight"`
+
+	// Retry バンドルの送信に一時的に失敗したとき（bpsendfileの異常終了、ソケットのENOBUFSなど）の送り直し方
+	Retry RetryConfig `yaml:"retry"`
+
+	// CircuitBreaker 失敗が続いたときにゲートウェイへの転送を止めるサーキットブレーカーの設定
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// Probe 地上局までのリンクを一定間隔で確かめるプローブの設定
+	Probe ProbeConfig `yaml:"probe"`
+
+	// Batch bp_socketモードで、短い間に送るリクエストを1つのバンドルにまとめる設定
+	Batch BatchConfig `yaml:"batch"`
+
+	// Auth bp_socketモードで、地上局（earth）から届くレスポンスのMACの検証と復号の設定
+	Auth AuthConfig `yaml:"auth"`
+
+	// AdaptiveTimeout 最近の往復時間からレスポンスを待つ時間と、レスポンスが届く時刻の目安を決める設定
+	AdaptiveTimeout AdaptiveTimeoutConfig `yaml:"adaptive_timeout"`
+
+	// RoundTripEstimate 予約したリクエストのレスポンスがDTN経由で届くまでの目安（プレースホルダーのRetry-Afterと、予約キューでの位置からの到着予定に使う）
+	RoundTripEstimate time.Duration `yaml:"round_trip_estimate"`
+}
+
+// RetryConfig 送信に失敗したバンドルを、BaseDelayから2倍ずつ（MaxDelayまで）間隔を空けて、最初の送信を含めAttempts回まで送る
+// 送った後のレスポンス待ちのタイムアウトは送り直さない。Jitterは待ち時間をずらす割合（0.2なら±20%）
+type RetryConfig struct {
+	Attempts  int           `yaml:"attempts"`
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	Jitter    float64       `yaml:"jitter"`
 }
 
-// BpSocketConfig BPソケット（dtn-socket）の設定
-type BpSocketConfig struct {
-	LocalNodeNum     uint64 `yaml:"local_node_num"`
-	LocalServiceNum  uint64 `yaml:"local_service_num"`
-	RemoteNodeNum    uint64 `yaml:"remote_node_num"`
-	RemoteServiceNum uint64 `yaml:"remote_service_num"`
+// CircuitBreakerConfig リンク断・送信失敗・タイムアウトがFailureThreshold回続いたら、CoolDownの間ゲートウェイを試さずに失敗させる
+// CoolDownの後は1つのリクエストだけ試し、成功すれば元に戻す（FailureThresholdが0以下は使わない）
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"`
+	CoolDown         time.Duration `yaml:"cool_down"`
+}
+
+// ProbeConfig Intervalごとに地上局へステータスの確認（status://earth）を送り、Timeoutまで応答を待つ（Intervalが0以下は送らない）
+// 結果はリンクの状態のメトリクスに記録し、サーキットブレーカーを使う場合は応答が届けばすぐに閉じ、届かなければ失敗として数える
+type ProbeConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// BatchConfig 最初のリクエストからMaxDelayまで待ち、MaxRequests件まで1つのバンドル（request_batch）にまとめて送る（MaxRequestsが1以下はまとめない）
+// まとめたバンドルもバンドルの大きさの上限は超えない。レスポンスはリクエストごとに届く
+type BatchConfig struct {
+	MaxRequests int           `yaml:"max_requests"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+}
+
+// AuthConfig 地上局がauth.hmac_keyで署名したレスポンスを、VerifyKeysのいずれかで検証する（VerifyKeysが空の場合は検証しない）
+// MACが検証できないレスポンスは捨てる。RequireMACはMACのないレスポンスも捨てる（地上局がすべて署名するようになってから有効にする）
+// EncryptionKeysは地上局のauth.encryption_keysと同じAES-256の鍵（16進数64文字）で、暗号化されたレスポンスを登録順に試して復号する
+type AuthConfig struct {
+	VerifyKeys     []string `yaml:"verify_keys"`
+	RequireMAC     bool     `yaml:"require_mac"`
+	EncryptionKeys []string `yaml:"encryption_keys"`
+}
+
+// AdaptiveTimeoutConfig 最近のWindow件の往復時間を記録し、MinSamples件以上あればp95のFactor倍（Min〜Max）までレスポンスを待つ
+// Factorが0以下はTimeoutのまま待つ。記録のp50はRoundTripEstimateの代わりにレスポンスが届く時刻の目安に使う
+type AdaptiveTimeoutConfig struct {
+	Window     int           `yaml:"window"`
+	MinSamples int           `yaml:"min_samples"`
+	Factor     float64       `yaml:"factor"`
+	Min        time.Duration `yaml:"min"`
+	Max        time.Duration `yaml:"max"`
 }
 
 type Redis struct {
+	// Type キャッシュのメタデータと予約キューを置く場所（"redis"、"memory"、"bolt"）
+	Type RedisType `yaml:"type"`
+
+	// BoltPath typeがboltの場合のデータベースファイル
+	BoltPath string `yaml:"bolt_path"`
+
 	// Redisサーバーの接続情報
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	Password string `yaml:"password"`
 	DB       int    `yaml:"db"`
+
+	// KeyspaceNotifications 他のインスタンスが保存したキャッシュもRedisのキースペース通知で検出する（複数台構成用）
+	KeyspaceNotifications bool `yaml:"keyspace_notifications"`
 }
 
+// RedisType キャッシュのメタデータと予約キューを置く場所
+type RedisType string
+
+const (
+	RedisTypeRedis  RedisType = "redis"  // Redisサーバー（複数台構成・再起動後も予約とキャッシュを引き継ぐ）
+	RedisTypeMemory RedisType = "memory" // プロセスのメモリ（Redisなしで動かすテスト・1台構成のデモ用、終了すると予約とキャッシュのメタデータは失われる）
+	RedisTypeBolt   RedisType = "bolt"   // bboltのファイル（Redisを動かさない1台構成用、再起動後も予約とキャッシュを引き継ぐ）
+)
+
 type RedisKeys struct {
 	// Redis内で使用するキーのパターン
-	ReservedRequestsKey string `yaml:"reserved_requests_key"`
+	ReservedRequestsKey string `yaml:"reserved_requests_key"` // 予約キュー（優先度と予約した時刻をスコアにしたソート済みセット）
+	ReservedKeysKey     string `yaml:"reserved_keys_key"`     // 予約済みのキャッシュキー（同じページを重複して予約しないため）のハッシュ
 	PendingRequestsKey  string `yaml:"pending_requests_key"`
+	SentRequestsKey     string `yaml:"sent_requests_key"` // DTNへ送ったリクエストの記録（再起動後にレスポンスを予約と突き合わせるため）のハッシュ
 	CacheMetaPattern    string `yaml:"cache_meta_pattern"`
-	ScanCount           int    `yaml:"scan_count"` // Redis SCANコマンドのCOUNTパラメータ
+	CacheIndexPrefix    string `yaml:"cache_index_prefix"` // キャッシュの二次インデックス（URL・ドメインからの検索用）のキーの接頭辞
+	ScanCount           int    `yaml:"scan_count"`         // Redis SCANコマンドのCOUNTパラメータ
 }
 
 type CacheConfig struct {
 	Dir             string        `yaml:"dir"`              // キャッシュファイルを保存するディレクトリ
-	DefaultTTL      time.Duration `yaml:"default_ttl"`      // デフォルトのキャッシュTTL
+	DefaultTTL      time.Duration `yaml:"default_ttl"`      // 転送先がCache-Control・Expires・Last-Modifiedで期間を示さない場合のキャッシュTTL
 	CleanupInterval time.Duration `yaml:"cleanup_interval"` // キャッシュクリーンアップの実行間隔
+
+	// CleanupBudget 1回のキャッシュクリーンアップで削除する期限切れキャッシュの上限。残りは次の回に削除する（0以下は上限なし）
+	CleanupBudget int `yaml:"cleanup_budget"`
+
+	// StreamThreshold この大きさ（バイト）以上のキャッシュはメモリに読み込まずにファイルからクライアントへコピーする（0以下はすべてメモリに読み込む）
+	StreamThreshold int64 `yaml:"stream_threshold"`
+	// StaleGrace 有効期限を過ぎたキャッシュを削除せずに保持する期間。この間は期限切れのキャッシュを返しながら更新を予約する（0以下は有効期限で削除する）
+	StaleGrace time.Duration `yaml:"stale_grace"`
+
+	// MaxObjectSize 1件のキャッシュの大きさ（バイト）の上限。超えるレスポンスは保存しない（0以下は上限なし）
+	MaxObjectSize int64 `yaml:"max_object_size"`
+	// MaxSize キャッシュ全体の容量（バイト）の上限。超えると最終アクセスの古いキャッシュから削除する（0以下は上限なし）
+	MaxSize int64 `yaml:"max_size"`
+
+	// MinTTL・MaxTTL 転送先のCache-Control（max-age・s-maxage）・Expires・Last-Modifiedから決めたTTLの下限と上限（MaxTTLが0以下は上限なし）
+	// no-cacheやmax-age=0のレスポンスもMinTTLの間はキャッシュから返す（0以下の場合は保存しない）
+	MinTTL time.Duration `yaml:"min_ttl"`
+	MaxTTL time.Duration `yaml:"max_ttl"`
+
+	// NegativeTTL・NegativeStatuses NegativeStatusesのエラーレスポンス（404・410・一部の5xx）をネガティブキャッシュとして保存する期間
+	// この間は同じURLを予約し直さずにエラーレスポンスを返す（NegativeTTLが0以下は保存しない）
+	NegativeTTL      time.Duration `yaml:"negative_ttl"`
+	NegativeStatuses []int         `yaml:"negative_statuses"`
+
+	// StripQueryParams キャッシュキーを作るときにURLから取り除くクエリパラメータ（utm_sourceなどのトラッキング用、末尾の"*"は前方一致）
+	StripQueryParams []string `yaml:"strip_query_params"`
+
+	// PrefetchAssetsPerPage HTMLをキャッシュしたときに、キャッシュのない同じホストのリソース（CSS・画像など）を事前取得として予約する数の上限
+	// ブラウザがページを表示するときにリソースがプレースホルダーばかりになるのを防ぐ（0以下は予約しない）
+	PrefetchAssetsPerPage int `yaml:"prefetch_assets_per_page"`
+
+	// HonorClientNoStore クライアントがCache-Control: no-storeを送った場合に、キャッシュを使わずに直接転送する
+	// DTNでは直接転送できないことが多いため、デフォルトは無効（キャッシュを返す）
+	HonorClientNoStore bool `yaml:"honor_client_no_store"`
+
+	// TTLRules 転送先がキャッシュの期間を示さない場合に、DefaultTTLの代わりに使うContent-Type（前方一致）・ドメインごとのTTL
+	// ドメインを指定したルールを優先し、どれにも一致しない場合はDefaultTTL
+	TTLRules []TTLRule `yaml:"ttl_rules"`
+}
+
+// TTLRule Content-Type・ドメインごとのキャッシュTTL（Domain・ContentTypeは空の場合すべてに一致する）
+type TTLRule struct {
+	Domain      string        `yaml:"domain"`       // "example.com" または "*.example.com"
+	ContentType string        `yaml:"content_type"` // "text/html"、"image/"など
+	TTL         time.Duration `yaml:"ttl"`
 }
 
 type WorkerConfig struct {
 	Workers           int           `yaml:"workers"`             // Worker Poolのワーカー数
 	QueueWatchTimeout time.Duration `yaml:"queue_watch_timeout"` // キュー監視のタイムアウト
+
+	// SentRequestMaxAge 起動時に引き継ぐ、前回の起動でDTNへ送ったリクエストの記録の期間
+	// これより前に送った記録はレスポンスが届く見込みがないため、ログに残して予約とともに削除する（0以下は削除しない）
+	SentRequestMaxAge time.Duration `yaml:"sent_request_max_age"`
+
+	// ProcessingTimeout Workerが取り出してから処理中のまま（処理中にWorkerやプロセスが止まった予約）キューに戻すまでの時間
+	// DTNの応答を待つ時間や転送の空きを待つ時間より長くする。JanitorIntervalごとに見回る（どちらかが0以下は見回らない）
+	ProcessingTimeout time.Duration `yaml:"processing_timeout"`
+	JanitorInterval   time.Duration `yaml:"janitor_interval"`
+
+	// Retry 転送に失敗した（応答のタイムアウト・送信の失敗など）予約を、BaseDelayから2倍ずつ（MaxDelayまで）待ってキューに戻し、最初の転送を含めAttempts回まで送る
+	// 上限まで送っても失敗した予約はデッドレターに移す（GET /system/admin/dead-letters で調べ、POST /system/admin/dead-letters/requeue でキューに戻せる）
+	Retry RetryConfig `yaml:"retry"`
 }
 
 type MiddlewareConfig struct {
@@ -52,6 +212,16 @@ type MiddlewareConfig struct {
 	MaxCacheSize  int    `yaml:"max_cache_size"` // 証明書キャッシュの最大数
 	RSABits       int    `yaml:"rsa_bits"`       // RSA鍵のビット長
 	CacheDuration int    `yaml:"cache_duration"` // 生成した証明書の有効期間(時間)
+
+	// DomainAllowlist 転送を許可するドメイン（"example.com"または"*.example.com"）。空の場合はすべて許可
+	DomainAllowlist []string `yaml:"domain_allowlist"`
+	// DomainBlocklist 転送しないドメイン。許可リストより優先する（POST /system/admin/domain-filter/reload で再読み込み）
+	DomainBlocklist []string `yaml:"domain_blocklist"`
+
+	// ConnectPassthrough SSL Bumpせずに接続先へそのまま中継するCONNECTのホスト（証明書のピンニングを行うアプリなど）
+	ConnectPassthrough []string `yaml:"connect_passthrough"`
+	// PassthroughIdleTimeout パススルーの接続でどちらの方向にもデータが流れない場合に閉じるまでの時間
+	PassthroughIdleTimeout time.Duration `yaml:"passthrough_idle_timeout"`
 }
 
 // Mode サーバーの動作モード
@@ -67,4 +237,39 @@ type ServerConfig struct {
 	Mode            Mode   `yaml:"mode"`              // サーバーの動作モード
 	DefaultDir      string `yaml:"default_dir"`       // デフォルトページとプレースホルダーファイルのディレクトリ
 	DefaultFileName string `yaml:"default_file_name"` // デフォルトHTMLファイル名
+
+	// RequestTimeout プロキシするリクエストのレスポンスを待つ期限。超えた場合は504を返す（キャッシュミスの予約は続ける）
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// MaxRequestBodySize プロキシするリクエストボディの上限（バイト）。超えた場合は転送も予約もせずに413を返す
+	MaxRequestBodySize int64 `yaml:"max_request_body_size"`
+	// ForbidUpgrade Upgrade（WebSocket、h2cなど）を求めるリクエストを501ではなく403で拒否する（どちらの場合もDTNには送らない）
+	ForbidUpgrade bool `yaml:"forbid_upgrade"`
+
+	// RewriteLinks ?url=の形式で開いたHTMLのページについて、同じオリジンへのリンクもプロキシを通る?url=の形式に書き換える
+	RewriteLinks bool `yaml:"rewrite_links"`
+	// BannerHTML HTMLのページの<body>の直後に挿入するHTML（DTN経由のページであることを示すバナーなど、空の場合は挿入しない）
+	BannerHTML string `yaml:"banner_html"`
+
+	// FetchImages 画像のキャッシュミスもDTNへ予約する（falseの場合は元の画像の大きさのSVGのプレースホルダーを返すだけにする）
+	FetchImages bool `yaml:"fetch_images"`
+
+	// NotifyTimeout /system/notify の接続を保持する最大時間（キャッシュされなければtimeoutイベントを送って閉じる）
+	NotifyTimeout time.Duration `yaml:"notify_timeout"`
+
+	// ProxyAdvertiseAddr /system/proxy.pacでクライアントに案内するこのプロキシのhost:port（空の場合はリクエストのHost）
+	ProxyAdvertiseAddr string `yaml:"proxy_advertise_addr"`
+	// ProxyBypass /system/proxy.pacでプロキシを通さずDIRECTにするホスト（"example.local"はサブドメインも含む、"*"を含む場合はshExpMatchのパターン）
+	// localhostとプライベートアドレス（RFC1918）は指定しなくてもDIRECTになる
+	ProxyBypass []string `yaml:"proxy_bypass"`
+
+	// AdminToken /system/admin のエンドポイントに必要なBearerトークン（環境変数BP_ADMIN_TOKENが優先）
+	// 空の場合、管理用エンドポイントはループバックからの接続だけを受け付ける
+	AdminToken string `yaml:"admin_token"`
+
+	// AccessLogPath アクセスログ（1リクエスト1行のJSON）を書き込むファイル。空の場合は標準出力
+	AccessLogPath string `yaml:"access_log_path"`
+	// AccessLogMaxSize アクセスログのファイルがこの大きさ（バイト）を超えたらローテーションする（0以下はローテーションしない）
+	AccessLogMaxSize int64 `yaml:"access_log_max_size"`
+	// AccessLogMaxBackups ローテーションで残す古いアクセスログ（.1、.2、…）の数
+	AccessLogMaxBackups int `yaml:"access_log_max_backups"`
 }
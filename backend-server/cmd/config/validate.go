@@ -0,0 +1,68 @@
+// validate.go - 起動時に設定の値を確かめる
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+)
+
+// Validate 起動してから失敗しないよう、redis_client.typeと、ゲートウェイのEID（優先度のレーンを含む）の形式と重なりを確かめる
+// デバッグモードはDTNを使わない（localで転送する）ためEIDは確かめない
+func (c Config) Validate() error {
+	switch c.RedisClient.Type {
+	case "", RedisTypeRedis, RedisTypeMemory:
+	case RedisTypeBolt:
+		if c.RedisClient.BoltPath == "" {
+			return fmt.Errorf("redis_client: bolt_path is required for type %s", RedisTypeBolt)
+		}
+	default:
+		return fmt.Errorf("redis_client: unknown type %q (use %s, %s or %s)", c.RedisClient.Type, RedisTypeRedis, RedisTypeMemory, RedisTypeBolt)
+	}
+	if c.Server.Mode == DebugMode {
+		return nil
+	}
+	eids, err := c.GatewayEIDs()
+	if err != nil {
+		return err
+	}
+	if err := eids.Validate(gateway.NormalizeTransportMode(c.BPGateway.TransportMode)); err != nil {
+		return fmt.Errorf("bp_gateway: %w", err)
+	}
+	if _, err := c.BPGateway.Auth.EnvelopeAuth(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GatewayEIDs ゲートウェイが使うEID（priority_lanesのサービス番号をsource_eidと同じノードのEIDにする）
+func (c Config) GatewayEIDs() (gateway.EIDs, error) {
+	lanes, err := gateway.PriorityLanes(c.BPGateway.SourceEID, c.BPGateway.PriorityLanes)
+	if err != nil {
+		return gateway.EIDs{}, fmt.Errorf("bp_gateway: %w", err)
+	}
+	return gateway.EIDs{
+		Source:      c.BPGateway.SourceEID,
+		Destination: c.BPGateway.DestinationEID,
+		Receive:     c.BPGateway.ReceiveEID,
+		Lanes:       lanes,
+	}, nil
+}
+
+// EnvelopeAuth ゲートウェイがレスポンスの検証と復号に使う設定
+// MACの鍵は地上局のauth.hmac_keyと同じく文字列のバイト列、暗号化の鍵は16進数64文字（AES-256の鍵でない場合はエラー）
+func (c AuthConfig) EnvelopeAuth() (gateway.EnvelopeAuth, error) {
+	auth := gateway.EnvelopeAuth{RequireMAC: c.RequireMAC}
+	for _, key := range c.VerifyKeys {
+		auth.VerifyKeys = append(auth.VerifyKeys, []byte(key))
+	}
+	for i, k := range c.EncryptionKeys {
+		key, err := hex.DecodeString(k)
+		if err != nil || len(key) != 32 {
+			return gateway.EnvelopeAuth{}, fmt.Errorf("bp_gateway: auth.encryption_keys[%d] must be 64 hex characters (an AES-256 key)", i)
+		}
+		auth.DecryptionKeys = append(auth.DecryptionKeys, key)
+	}
+	return auth, nil
+}
@@ -0,0 +1,98 @@
+// validate_test.go - 起動時の設定の確認のテスト
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateway  BpGateway
+		mode     Mode
+		redis    RedisType
+		boltPath string
+		wantErr  string // 空の場合はエラーなし
+	}{
+		{name: "defaults", gateway: BpGateway{TransportMode: "bp_socket", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", ReceiveEID: "ipn:149.2"}},
+		{name: "second backend", gateway: BpGateway{TransportMode: "ion_cli", SourceEID: "ipn:151.1", DestinationEID: "ipn:150.1", ReceiveEID: "ipn:151.2"}},
+		{name: "malformed source", gateway: BpGateway{TransportMode: "bp_socket", SourceEID: "149.1", DestinationEID: "ipn:150.1"}, wantErr: "bp_gateway: source EID"},
+		{name: "send and receive collide", gateway: BpGateway{TransportMode: "ion_cli", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", ReceiveEID: "ipn:149.1"}, wantErr: "source and receive are both ipn:149.1"},
+		// 別名のbpfileもion_cliとして確かめる
+		{name: "send and receive collide with an alias", gateway: BpGateway{TransportMode: "bpfile", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", ReceiveEID: "ipn:149.1"}, wantErr: "source and receive are both ipn:149.1"},
+		{name: "sending to itself", gateway: BpGateway{TransportMode: "bp_socket", SourceEID: "ipn:150.1", DestinationEID: "ipn:150.1"}, wantErr: "source and destination"},
+		{name: "low priority lane", gateway: BpGateway{TransportMode: "ion_cli", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", ReceiveEID: "ipn:149.2", PriorityLanes: map[string]int{"low": 3}}},
+		{name: "lane collides with receive", gateway: BpGateway{TransportMode: "ion_cli", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", ReceiveEID: "ipn:149.2", PriorityLanes: map[string]int{"low": 2}}, wantErr: "low priority lane and receive are both ipn:149.2"},
+		{name: "unknown priority", gateway: BpGateway{TransportMode: "bp_socket", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", PriorityLanes: map[string]int{"bulk": 3}}, wantErr: `bp_gateway: priority lane: unknown priority "bulk"`},
+		{name: "encryption key", gateway: BpGateway{TransportMode: "bp_socket", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", Auth: AuthConfig{EncryptionKeys: []string{strings.Repeat("ab", 32)}}}},
+		{name: "short encryption key", gateway: BpGateway{TransportMode: "bp_socket", SourceEID: "ipn:149.1", DestinationEID: "ipn:150.1", Auth: AuthConfig{EncryptionKeys: []string{"abcd"}}}, wantErr: "auth.encryption_keys[0] must be 64 hex characters"},
+		// デバッグモードはDTNを使わない
+		{name: "debug mode", gateway: BpGateway{TransportMode: "bp_socket"}, mode: DebugMode},
+		{name: "memory repository", gateway: BpGateway{TransportMode: "bp_socket"}, mode: DebugMode, redis: RedisTypeMemory},
+		{name: "bolt repository", gateway: BpGateway{TransportMode: "bp_socket"}, mode: DebugMode, redis: RedisTypeBolt, boltPath: "./tmp/bp_repository.db"},
+		{name: "bolt without path", gateway: BpGateway{TransportMode: "bp_socket"}, mode: DebugMode, redis: RedisTypeBolt, wantErr: "redis_client: bolt_path is required"},
+		{name: "unknown repository", gateway: BpGateway{TransportMode: "bp_socket"}, mode: DebugMode, redis: "memcached", wantErr: `redis_client: unknown type "memcached"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := Config{BPGateway: tt.gateway, Server: ServerConfig{Mode: tt.mode}, RedisClient: Redis{Type: tt.redis, BoltPath: tt.boltPath}}
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected a valid config, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "bp_gateway:\n  transport_mode: ion_cli\n  source_eid: \"ipn:151.1\"\n  receive_eid: \"ipn:151.2\"\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+
+	conf := LoadConfig()
+	// 指定しなかったEIDはデフォルトのまま
+	if conf.BPGateway.SourceEID != "ipn:151.1" || conf.BPGateway.ReceiveEID != "ipn:151.2" || conf.BPGateway.DestinationEID != "ipn:150.1" {
+		t.Errorf("Unexpected EIDs: %+v", conf.BPGateway)
+	}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("Expected the loaded config to be valid, got %v", err)
+	}
+	// 優先度lowのレーンは、指定したsource_eidと同じノードから送る
+	if eids, err := conf.GatewayEIDs(); err != nil || eids.SourceFor(gateway_interface.PriorityLow) != "ipn:151.3" {
+		t.Errorf("Expected the low priority lane at ipn:151.3, got %v %v", eids.Lanes, err)
+	}
+}
+
+func TestLoadConfigAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "bp_gateway:\n  auth:\n    verify_keys: [\"next-key\", \"dtn-shared-hmac-key\"]\n    require_mac: true\n    encryption_keys: [\"1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100\"]\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_PATH", path)
+
+	auth, err := LoadConfig().BPGateway.Auth.EnvelopeAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth.VerifyKeys) != 2 || string(auth.VerifyKeys[1]) != "dtn-shared-hmac-key" || !auth.RequireMAC {
+		t.Errorf("Unexpected auth: %q %v", auth.VerifyKeys, auth.RequireMAC)
+	}
+	if len(auth.DecryptionKeys) != 1 || auth.DecryptionKeys[0][0] != 0x1f {
+		t.Errorf("Expected the hex encryption key to be decoded, got %x", auth.DecryptionKeys)
+	}
+}
@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"context"
+	"time"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
 )
@@ -10,8 +11,24 @@ import (
 type BpGateway interface {
 	// ProxyRequest HTTPリクエストを転送先に送信する
 	// ctx: コンテキスト（リクエストのキャンセレーションやタイムアウト制御に使用）
-	ProxyRequest(ctx context.Context, req *model.BpRequest) (*model.BpResponse, error)
+	// opts: 優先度など転送の仕方（ゼロ値で通常のリクエスト）
+	ProxyRequest(ctx context.Context, req *model.BpRequest, opts ProxyOptions) (*model.BpResponse, error)
 
 	// GetUnsolicitedResponseCh Push受信したレスポンスを受け取るチャンネルを返す
 	GetUnsolicitedResponseCh() <-chan *model.BpResponse
 }
+
+// HealthChecker DTNへのリンクの状態を確認できるゲートウェイ（/system/healthで使う）
+// 実装していないゲートウェイは確認せずに正常として扱う
+type HealthChecker interface {
+	// HealthCheck リクエストを送らずに確認できる範囲でリンクが使えるかを返す（使えない場合はエラー）
+	HealthCheck(ctx context.Context) error
+}
+
+// Prober 実際のサイトを取得せずに、地上局までのDTNの往復を確かめられるゲートウェイ
+// 一定間隔のプローブ（scheduler）がリンクの状態とサーキットブレーカーの更新に使う
+type Prober interface {
+	// Probe 地上局にステータスの確認を送って応答を待ち、往復時間を返す（届かない場合はErrTimeoutなどでラップしたエラー）
+	// 待つ時間はctxの期限で決める
+	Probe(ctx context.Context) (time.Duration, error)
+}
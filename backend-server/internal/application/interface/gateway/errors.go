@@ -0,0 +1,34 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ゲートウェイがリクエストを転送できなかった理由
+// BpGateway.ProxyRequestの実装は失敗をこれらのいずれかでラップして返し（fmt.Errorf("%w: ...", ErrSendFailed)）、
+// 呼び出し側はerrors.Isで理由を見分けてステータスコード（503・502・504）を決める
+var (
+	// ErrLinkDown DTNへのリンクが使えない（受信ループが止まった、ION・ソケットが使えないなど）。リンクが回復するまで転送できない
+	ErrLinkDown = errors.New("dtn link is down")
+
+	// ErrSendFailed リクエストのバンドルを送れなかった（大きすぎる、送信コマンド・ソケットの送信が失敗したなど）
+	ErrSendFailed = errors.New("failed to send bundle")
+
+	// ErrTimeout バンドルは送ったが、ゲートウェイの期限までにレスポンスが届かなかった
+	ErrTimeout = errors.New("response timed out")
+
+	// ErrResponseCorrupt 届いたレスポンスを読めなかった（ボディのデコードの失敗など）
+	ErrResponseCorrupt = errors.New("response is corrupt")
+)
+
+// ErrProbeUnsupported ゲートウェイがプローブを送れない（DTNを使わないlocalモードなど）
+var ErrProbeUnsupported = errors.New("gateway does not support probes")
+
+// ErrInFlightLimit 同時に転送できるリクエストの上限に達していて、空きができる前に呼び出し元のctxが終わった（リクエストは送っていない）
+// ワーカーは予約を変えずにキューに戻す
+var ErrInFlightLimit = errors.New("too many requests in flight")
+
+// ErrCircuitOpen 失敗が続いたため、サーキットブレーカーがリンクを試さずに転送を止めている（ErrLinkDownでもある）
+// ワーカーは予約を変えずにキューに戻し、クールダウンの後に送り直す
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", ErrLinkDown)
@@ -0,0 +1,42 @@
+package gateway
+
+import "fmt"
+
+// Priority 転送するリクエストの優先度
+// ゲートウェイは優先度ごとに別の送信元のサービス番号（レーン）で送り、事前取得の大量のバンドルがブラウザの待っているリクエストを遅らせないようにする
+type Priority int
+
+const (
+	// PriorityHigh ブラウザが待っているリクエスト（ProxyOptionsのゼロ値）
+	PriorityHigh Priority = iota
+	// PriorityLow 事前取得など、誰も待っていないリクエスト
+	PriorityLow
+)
+
+// Priorities すべての優先度（高い順）
+var Priorities = []Priority{PriorityHigh, PriorityLow}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// ParsePriority 設定に書く優先度の名前（high、low）を読む
+func ParsePriority(name string) (Priority, error) {
+	for _, p := range Priorities {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q (use high or low)", name)
+}
+
+// ProxyOptions ProxyRequestで転送の仕方を指定する（ゼロ値はブラウザが待っているリクエストとして優先度highで送る）
+type ProxyOptions struct {
+	Priority Priority
+}
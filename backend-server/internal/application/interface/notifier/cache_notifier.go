@@ -0,0 +1,11 @@
+package notifier
+
+// CacheNotifier キャッシュキーのレスポンスがキャッシュに保存されたことを通知するpub/sub
+type CacheNotifier interface {
+	// Publish cacheKeyのレスポンスがキャッシュに保存されたことを購読者に通知する
+	Publish(cacheKey string)
+
+	// Subscribe cacheKeyの通知を受け取るチャネルと、購読を解除する関数を返す
+	// 購読を終えたら必ず解除関数を呼ぶこと
+	Subscribe(cacheKey string) (<-chan struct{}, func())
+}
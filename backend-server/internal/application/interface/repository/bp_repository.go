@@ -15,39 +15,113 @@ type BpRepository interface {
 	// 戻り値: キャッシュされたレスポンスと、キャッシュが存在するかどうか
 	GetResponse(ctx context.Context, key string) (*model.BpResponse, bool, error)
 
+	// GetResponseStream GetResponseと同じくキャッシュからレスポンスを取得するが、ボディを読み込まずにキャッシュファイルを開いて返す
+	// 戻り値のBodyStreamはキャッシュファイル、ContentLengthはファイルの大きさ（呼び出し側がCloseする）
+	GetResponseStream(ctx context.Context, key string) (*model.BpResponse, bool, error)
+
 	// SetResponseWithURL キャッシュにレスポンスを保存する
 	// ctx: コンテキスト（リクエストのキャンセレーションやタイムアウト制御に使用）
 	// req: リクエスト情報（URLベースの階層構造でキャッシュを保存するために使用）
 	// response: 保存するレスポンスデータ
 	// ttl: キャッシュの有効期限
+	// 大きさの上限を超えるレスポンスは保存せずにmodel.ErrCacheTooLargeを返す。キャッシュ全体の容量を超えた場合は最終アクセスの古いキャッシュを削除する
 	SetResponseWithURL(ctx context.Context, req *model.BpRequest, response *model.BpResponse, ttl time.Duration) error
 
-	DeleteExpiredCaches(ctx context.Context) error
+	DeleteExpiredCaches(ctx context.Context) (model.CleanupResult, error)
 
 	DeleteAllCaches(ctx context.Context) error
 
+	// ListCacheEntries キャッシュの一覧を新しい順に返す
+	// domain: 空でなければこのドメイン（model.CacheDomain）のキャッシュだけを返す
+	// 戻り値: offset件目からlimit件までのキャッシュと、絞り込み後の総数
+	ListCacheEntries(ctx context.Context, domain string, offset, limit int) ([]*model.CacheEntry, int, error)
+
+	// GetCacheEntries URLのキャッシュを返す（ヘッダー違いで複数ある場合はすべて）
+	GetCacheEntries(ctx context.Context, url string) ([]*model.CacheEntry, error)
+
+	// GetCacheUsage キャッシュ全体の使用量（大きさの合計と件数）と上限を返す
+	GetCacheUsage(ctx context.Context) (model.CacheUsage, error)
+
+	// ReconcileCacheUsage キャッシュ全体の使用量を、キャッシュディレクトリのファイルと突き合わせて数え直す
+	// メタデータやファイルがなくなったキャッシュはインデックスから外し、どのキャッシュからも参照されていないファイルは削除する
+	ReconcileCacheUsage(ctx context.Context) (model.CacheReconcileResult, error)
+
+	// PurgeCache URLのキャッシュ（メタデータとファイル）をすべて削除する
+	// 戻り値: 削除したキャッシュの数
+	PurgeCache(ctx context.Context, url string) (int, error)
+
 	// ReserveRequest 非同期処理（Worker Pool）で処理するためにリクエストを予約する
 	// Redisキューに追加して、RequestProcessorが非同期で処理する
+	// 同じキャッシュキーのリクエストが予約済み（処理中を含む）の場合はキューに追加しない
+	// req.Priority()の優先度で予約し、予約済みの予約より優先度が高い場合はその予約の優先度を上げる
+	// 事前取得の予約は、同じキャッシュキーのデッドレターがある場合もキューに追加しない（失敗し続けるURLを何度も送らない）
 	// req: 予約するリクエスト
-	ReserveRequest(ctx context.Context, req *model.BpRequest) error
+	// 戻り値: 新しく追加したか（Queued）と、予約した時刻（予約済みの場合はその時刻）
+	ReserveRequest(ctx context.Context, req *model.BpRequest) (model.Reservation, error)
 
 	// GetReservedRequests 予約されたリクエストのリストを取得する
-	// 戻り値: 予約されたリクエストのリスト
+	// 戻り値: 予約されたリクエストのリスト（Workerが取り出す順: 優先度の高い順、同じ優先度では予約した時刻の古い順）
 	GetReservedRequests(ctx context.Context) ([]*model.BpRequest, error)
 
 	// RemoveReservedRequest 予約されたリクエストを削除する
+	// キューの要素（Workerが取り出した後は処理中の予約）と予約済みのキャッシュキーを同時に削除し、同じキャッシュキーを再び予約できるようにする
 	// req: 削除するリクエスト
 	RemoveReservedRequest(ctx context.Context, req *model.BpRequest) error
 
+	// RequeueReservedRequest Workerが取り出した予約を、予約済みのままその優先度の最後に戻す
+	// 予約済みの記録は残るため、戻すまでの間に同じキャッシュキーが予約し直されることはない
+	RequeueReservedRequest(ctx context.Context, req *model.BpRequest) error
+
+	// RetryReservedRequest Workerが取り出した予約を、予約済みのままdelayが過ぎてからその優先度の最後に戻す（転送に失敗した場合の再試行）
+	// 戻すまではBLPopReservedRequestで取り出さない。GetReservedRequestsでは戻す時刻を予約の時刻として並べる
+	RetryReservedRequest(ctx context.Context, req *model.BpRequest, delay time.Duration) error
+
+	// DeadLetterReservedRequest 送り直しの上限に達した予約を削除し、lastErrorとともにデッドレターとして残す（同じキャッシュキーのデッドレターは置き換える）
+	// デッドレターのある予約は、ListDeadLettersで調べてRequeueDeadLetterでキューに戻せる
+	DeadLetterReservedRequest(ctx context.Context, req *model.BpRequest, lastError string) error
+
+	// ListDeadLetters デッドレターを新しい順に返す
+	ListDeadLetters(ctx context.Context) ([]*model.DeadLetter, error)
+
+	// RequeueDeadLetter cacheKeyのデッドレターの予約を、送り直しの回数を0にして予約し直し、デッドレターを削除する
+	// 戻り値: 予約の結果と、デッドレターが存在したかどうか
+	RequeueDeadLetter(ctx context.Context, cacheKey string) (model.Reservation, bool, error)
+
 	// BLPopReservedRequest 予約されたリクエストをブロッキングで取得する
+	// 取得した予約はキューから処理中の予約へ移り、RemoveReservedRequest・RequeueReservedRequestまで残る
+	// （処理を終える前にWorkerやプロセスが止まっても、RequeueStuckRequestsでキューに戻せる）
 	// timeout: タイムアウト時間（0の場合は無期限に待機）
 	// 戻り値: 取得したリクエスト（タイムアウトの場合はnil）
 	BLPopReservedRequest(ctx context.Context, timeout time.Duration) (*model.BpRequest, error)
 
+	// RequeueStuckRequests 取得してからstuckAfterより長く処理中のままの予約を、予約済みのまま取り出したときの優先度の最後に戻す
+	// 戻り値: キューに戻したリクエスト
+	RequeueStuckRequests(ctx context.Context, stuckAfter time.Duration) ([]*model.BpRequest, error)
+
+	// JournalSentRequest DTNへ送るリクエストを送信の記録に追加する（同じRequestIDの記録は置き換える）
+	// バンドルを送る前に記録し、レスポンスを処理したらCompleteSentRequestで削除する
+	JournalSentRequest(ctx context.Context, sent *model.SentRequest) error
+
+	// GetSentRequest RequestIDの送信の記録を返す
+	// 戻り値: 記録と、記録が存在するかどうか
+	GetSentRequest(ctx context.Context, requestID string) (*model.SentRequest, bool, error)
+
+	// ListSentRequests 送信の記録をすべて返す（起動時に、前回の起動で送ったまま終了したリクエストを引き継ぐため）
+	ListSentRequests(ctx context.Context) ([]*model.SentRequest, error)
+
+	// CompleteSentRequest RequestIDの送信の記録を削除する（記録がなければ何もしない）
+	CompleteSentRequest(ctx context.Context, requestID string) error
+
 	// AddPendingRequest 処理中のリクエストとしてマークする
 	// 戻り値: 新規に追加された場合はtrue、既に存在した場合はfalse
 	AddPendingRequest(ctx context.Context, url string) (bool, error)
 
 	// RemovePendingRequest 処理中のリクエストマークを削除する
 	RemovePendingRequest(ctx context.Context, url string) error
+
+	// Ping Redisに接続できるかを確認する
+	Ping(ctx context.Context) error
+
+	// CheckCacheDir キャッシュディレクトリにファイルを書き込めるかを確認する
+	CheckCacheDir(ctx context.Context) error
 }
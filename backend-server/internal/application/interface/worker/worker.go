@@ -18,13 +18,23 @@ type QueueWatcher interface {
 	WatchQueue(ctx context.Context) (*model.BpRequest, error)
 }
 
+// QueueJanitor Workerが取り出したまま処理を終えていない予約を見回るハンドラー
+type QueueJanitor interface {
+	// RequeueStuckRequests 処理中のまま長く残った予約（処理中にWorkerやプロセスが止まったもの）をキューに戻す
+	// 戻り値: キューに戻した予約の数
+	RequeueStuckRequests(ctx context.Context) (int, error)
+}
+
 // CacheHandler キャッシュ操作を行うハンドラー
 type CacheHandler interface {
-	// DeleteExpiredCaches 期限切れのキャッシュを削除する
-	DeleteExpiredCaches(ctx context.Context) error
+	// DeleteExpiredCaches 期限切れのキャッシュを削除する（削除した数はネガティブキャッシュを分けて返す）
+	DeleteExpiredCaches(ctx context.Context) (model.CleanupResult, error)
 
 	// DeleteAllCaches すべてのキャッシュを削除する
 	DeleteAllCaches(ctx context.Context) error
+
+	// ReconcileCacheUsage キャッシュ全体の使用量をキャッシュディレクトリのファイルと突き合わせて数え直す（起動時に実行する）
+	ReconcileCacheUsage(ctx context.Context) (model.CacheReconcileResult, error)
 }
 
 // ResponseWatcher Unsolicited Responseを監視するワーカー
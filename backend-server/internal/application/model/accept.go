@@ -0,0 +1,97 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptRange Acceptヘッダーの1つのメディアレンジ（"text/html;q=0.9"）
+type acceptRange struct {
+	mainType string
+	subType  string
+	q        float64
+}
+
+// PreferredType Acceptヘッダーからoffersのうちクライアントが最も好むメディアタイプを返す（domain層のロジック、RFC 9110 12.5.1）
+// offerごとに最も具体的に一致するレンジのqを使い、qが同じ場合はより具体的なレンジで指定されたもの
+// （"application/json, */*"のapplication/json）を優先する。それも同じ場合は先に渡したofferを優先する
+// Acceptがない場合はすべてを受け入れる（*/*）とみなし、どれも受け入れない（q=0）場合は空を返す
+func (br *BpRequest) PreferredType(offers ...string) string {
+	ranges := parseAccept(http.Header(br.Headers).Values("Accept"))
+	if len(ranges) == 0 {
+		ranges = []acceptRange{{mainType: "*", subType: "*", q: 1}}
+	}
+
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, offer := range offers {
+		q, specificity := matchAccept(ranges, offer)
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
+
+// matchAccept offerに一致するレンジのうち最も具体的なもののqと具体性を返す（一致しない場合は0, -1）
+// 具体性は"*/*"が0、"type/*"が1、構造化構文の接尾辞（application/jsonに対する"application/vnd.api+json"）が2、完全一致が3
+func matchAccept(ranges []acceptRange, offer string) (float64, int) {
+	mainType, subType, _ := strings.Cut(strings.ToLower(offer), "/")
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.mainType == "*" && r.subType == "*":
+			s = 0
+		case r.mainType != mainType:
+			continue
+		case r.subType == "*":
+			s = 1
+		case r.subType == subType:
+			s = 3
+		case strings.HasSuffix(r.subType, "+"+subType):
+			s = 2
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q, specificity
+}
+
+// parseAccept Acceptヘッダーをメディアレンジに分ける（形式が正しくないレンジは無視する）
+func parseAccept(values []string) []acceptRange {
+	var ranges []acceptRange
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			mediaRange, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			mainType, subType, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mediaRange)), "/")
+			if !ok || mainType == "" || subType == "" || (mainType == "*" && subType != "*") {
+				continue
+			}
+			r := acceptRange{mainType: mainType, subType: subType, q: 1}
+			valid := true
+			for _, param := range strings.Split(params, ";") {
+				name, arg, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(strings.TrimSpace(name), "q") {
+					continue
+				}
+				q, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+				if err != nil || q < 0 || q > 1 {
+					valid = false
+					break
+				}
+				r.q = q
+			}
+			if valid {
+				ranges = append(ranges, r)
+			}
+		}
+	}
+	return ranges
+}
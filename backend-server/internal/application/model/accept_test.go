@@ -0,0 +1,47 @@
+// accept_test.go - Acceptヘッダーによるメディアタイプの選択のテスト
+package model
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPreferredType(t *testing.T) {
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	tests := []struct {
+		name   string
+		accept []string
+		offers []string
+		want   string
+	}{
+		{"no accept takes first offer", nil, []string{"text/html", "application/json"}, "text/html"},
+		{"wildcard takes first offer", []string{"*/*"}, []string{"application/json", "text/html"}, "application/json"},
+		{"browser prefers html", []string{browser}, []string{"application/json", "text/html"}, "text/html"},
+		{"json only", []string{"application/json"}, []string{"text/html", "application/json"}, "application/json"},
+		{"higher q wins", []string{"text/html;q=0.5, application/json"}, []string{"text/html", "application/json"}, "application/json"},
+		{"lower q loses", []string{"application/json;q=0.4, text/html;q=0.9"}, []string{"application/json", "text/html"}, "text/html"},
+		// qが同じ場合は明示したタイプを*/*より優先する（axiosなどの既定値）
+		{"explicit beats wildcard", []string{"application/json, text/plain, */*"}, []string{"text/html", "application/json"}, "application/json"},
+		{"type wildcard", []string{"application/*"}, []string{"text/html", "application/json"}, "application/json"},
+		{"structured suffix", []string{"application/vnd.github+json"}, []string{"text/html", "application/json"}, "application/json"},
+		{"most specific range sets q", []string{"application/*;q=0.9, application/json;q=0.1, text/html;q=0.5"}, []string{"application/json", "text/html"}, "text/html"},
+		{"case insensitive", []string{"Application/JSON; Q=1"}, []string{"text/html", "application/json"}, "application/json"},
+		{"several header lines", []string{"text/html;q=0.1", "application/json"}, []string{"text/html", "application/json"}, "application/json"},
+		{"none acceptable", []string{"image/png"}, []string{"text/html", "application/json"}, ""},
+		{"q zero refuses", []string{"application/json;q=0, */*"}, []string{"application/json", "text/html"}, "text/html"},
+		{"invalid q ignored", []string{"application/json;q=abc, text/html"}, []string{"application/json", "text/html"}, "text/html"},
+		{"malformed ranges treated as missing", []string{"json, */html"}, []string{"text/html", "application/json"}, "text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &BpRequest{Method: http.MethodGet, Headers: http.Header{"Accept": tt.accept}}
+			if tt.accept == nil {
+				req.Headers = nil
+			}
+			if got := req.PreferredType(tt.offers...); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
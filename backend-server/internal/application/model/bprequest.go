@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 )
 
 // BpRequest HTTPリクエストに必要な情報を格納する構造体
@@ -30,6 +31,40 @@ type BpRequest struct {
 
 	// ContentLength Content-Lengthヘッダーの値
 	ContentLength int64 `json:"content_length,omitempty"`
+
+	// ReservedAt DTNへの転送を予約した時刻（予約キューに入っている場合のみ）
+	ReservedAt time.Time `json:"reserved_at,omitzero"`
+
+	// RequestID ブラウザからのリクエストのX-Request-ID（予約と一緒に保存し、DTNのバンドルのrequest_idにも使う）
+	// キャッシュキーには含めない
+	RequestID string `json:"request_id,omitempty"`
+
+	// Prefetch 管理者がキャッシュを事前に用意するために予約したリクエストか（ブラウザからのリクエストより後に処理する）
+	Prefetch bool `json:"prefetch,omitempty"`
+
+	// PrefetchDepth Prefetchの場合に、取得したHTMLからたどるリンクの深さ（0はこのURLだけ）
+	PrefetchDepth int `json:"prefetch_depth,omitempty"`
+
+	// Revalidation 期限切れのキャッシュを返した後に、キャッシュを更新するために予約したリクエストか（ブラウザからのリクエストより後、事前取得より先に処理する）
+	Revalidation bool `json:"revalidation,omitempty"`
+
+	// Attempts 転送に失敗して（DTNの応答のタイムアウトなど）、予約キューに戻した回数。キャッシュキーには含めない
+	Attempts int `json:"attempts,omitempty"`
+
+	// ViaURLParam クライアントがプロキシの設定なしで?url=の形式（http://proxy/?url=...）でリクエストしたか
+	// ページのリンクをプロキシを通る形に書き換えるかの判断に使う。予約やキャッシュキーには含めない
+	ViaURLParam bool `json:"-"`
+}
+
+// Priority 予約キューの優先度（事前取得、キャッシュの更新、ブラウザが待っているリクエストの順に高い）
+func (br *BpRequest) Priority() ReservationPriority {
+	switch {
+	case br.Prefetch:
+		return PriorityPrefetch
+	case br.Revalidation:
+		return PriorityRevalidation
+	}
+	return PriorityInteractive
 }
 
 // ParseURL URL文字列を解析してurl.URLを返す
@@ -84,9 +119,14 @@ func (br *BpRequest) SetHeaders(httpReq *http.Request) {
 	}
 }
 
+// HonorRequestNoStore クライアントのCache-Control: no-storeに従い、キャッシュを使わずに直接転送するか
+// DTNでは直接転送できないことが多いため、設定で有効にした場合だけ従う。起動時に設定から一度だけ設定する
+var HonorRequestNoStore bool
+
 // IsCacheable このリクエストがキャッシュ可能かどうかを判定する
 // 以下の場合はキャッシュしない:
 // - GET以外のメソッド（POST, PUT, DELETE, PATCHなど）
+// - クライアントがCache-Control: no-storeを送った場合（HonorRequestNoStoreが有効な場合のみ）
 // - 認証が必要なページ（Authorizationヘッダーがある場合、ユーザーごとにキャッシュを分けるか、キャッシュしない）
 // - セッション情報を含むページ（Cookieにセッション情報がある場合）
 func (br *BpRequest) IsCacheable() bool {
@@ -95,6 +135,10 @@ func (br *BpRequest) IsCacheable() bool {
 		return false
 	}
 
+	if HonorRequestNoStore && br.RequestsNoStore() {
+		return false
+	}
+
 	// 認証ヘッダーがある場合は、ユーザーごとにキャッシュを分ける必要がある
 	// この場合はキャッシュ可能だが、キーに認証情報を含める必要がある
 	// または、認証が必要なページはキャッシュしないという選択肢もある
@@ -137,12 +181,16 @@ func (br *BpRequest) IsUserSpecific() bool {
 	return false
 }
 
+// CacheKeyHeaders キャッシュキーに含めるヘッダー（同じURLでも値が違えば別のキャッシュになる）
+var CacheKeyHeaders = []string{"Accept", "Accept-Language"}
+
 // GenerateCacheKey リクエストからキャッシュキーを生成する
-// メソッド、URL、重要なヘッダーから一意のキーを生成
+// メソッド、正規化したURL（NormalizedURL）、重要なヘッダーから一意のキーを生成
+// 表記が違うだけの同じURL（ホストの大文字、既定のポート、クエリの順序など）は同じキーになり、予約も1つにまとまる
 // ユーザー固有のコンテンツの場合は、認証情報もキーに含める
 func (br *BpRequest) GenerateCacheKey() string {
 	// 基本的なキー: メソッド + URL
-	baseKey := fmt.Sprintf("%s:%s", br.Method, br.URL)
+	baseKey := fmt.Sprintf("%s:%s", br.Method, br.NormalizedURL())
 
 	// 重要なヘッダーをソートして追加
 	var headerParts []string
@@ -154,8 +202,7 @@ func (br *BpRequest) GenerateCacheKey() string {
 	// }
 
 	// その他の重要なヘッダー
-	importantHeaders := []string{"Accept", "Accept-Language"}
-	for _, headerName := range importantHeaders {
+	for _, headerName := range CacheKeyHeaders {
 		if values, ok := br.Headers[headerName]; ok {
 			headerParts = append(headerParts, fmt.Sprintf("%s:%s", headerName, strings.Join(values, ",")))
 		}
@@ -173,9 +220,10 @@ func (br *BpRequest) GenerateCacheKey() string {
 }
 
 // GenerateCachePathInfo レスポンスのContentTypeからキャッシュパス情報を生成する（domain層のロジック）
+// 表記が違うだけの同じURLが同じファイルになるよう、正規化したURLから生成する
 func (br *BpRequest) GenerateCachePathInfo(responseContentType string) (*CachePathInfo, error) {
 	cacheKey := br.GenerateCacheKey()
-	return GenerateCachePathInfo(br.URL, responseContentType, cacheKey)
+	return GenerateCachePathInfo(br.NormalizedURL(), responseContentType, cacheKey)
 }
 
 // bodyReader バイト配列をio.Readerとして扱うためのヘルパー
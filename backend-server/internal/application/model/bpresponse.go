@@ -1,6 +1,9 @@
 package model
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // BpResponse HTTPレスポンスに必要な情報を格納する構造体
 type BpResponse struct {
@@ -13,17 +16,59 @@ type BpResponse struct {
 	// Body レスポンスボディ（バイト配列）
 	Body []byte `json:"body"`
 
+	// BodyStream 大きいキャッシュのボディ（メモリに読み込まずにキャッシュファイルから読む、Bodyは空）
+	// 長さはContentLength。受け取った側がCloseで閉じる
+	BodyStream io.ReadCloser `json:"-"`
+
+	// RequestID 地上局から届いたレスポンスのrequest_id（DTNのゲートウェイが受け取った場合のみ、送信の記録との突き合わせに使う）
+	RequestID string `json:"-"`
+
 	// ContentType Content-Typeヘッダーの値
 	ContentType string `json:"content_type,omitempty"`
 
 	// ContentLength Content-Lengthヘッダーの値
 	ContentLength int64 `json:"content_length,omitempty"`
+
+	// CachedAt キャッシュに保存された時刻（キャッシュから取得した場合のみ、Ageヘッダーの計算に使う）
+	CachedAt time.Time `json:"-"`
+
+	// ExpiresAt キャッシュの有効期限（キャッシュから取得した場合のみ）
+	ExpiresAt time.Time `json:"-"`
+
+	// Negative エラーレスポンスを短い期間だけ保存する（した）ネガティブキャッシュか
+	Negative bool `json:"-"`
+
+	// ReservedAt DTNへ予約してプレースホルダーを返した場合の予約の時刻（既に予約されていた場合はその予約の時刻）
+	ReservedAt time.Time `json:"-"`
+
+	// QueuePosition・QueueLength DTNへ予約した場合の予約キューでの位置（1から、0は処理中）とキューの長さ
+	QueuePosition int `json:"-"`
+	QueueLength   int `json:"-"`
+
+	// EstimatedAt DTNへ予約した場合にレスポンスが届く時刻の目安（分からない場合はゼロ）
+	EstimatedAt time.Time `json:"-"`
 }
 
 // GetBodyReader レスポンスボディをio.Readerとして返す
 func (br *BpResponse) GetBodyReader() io.Reader {
+	if br.BodyStream != nil {
+		return br.BodyStream
+	}
 	if len(br.Body) == 0 {
 		return nil
 	}
 	return &bodyReader{data: br.Body}
 }
+
+// IsStale キャッシュから取得したレスポンスが有効期限を過ぎているか（キャッシュ以外のレスポンスはfalse）
+func (br *BpResponse) IsStale() bool {
+	return !br.ExpiresAt.IsZero() && time.Now().After(br.ExpiresAt)
+}
+
+// Close BodyStreamを閉じる（BodyStreamがない場合は何もしない）
+func (br *BpResponse) Close() error {
+	if br == nil || br.BodyStream == nil {
+		return nil
+	}
+	return br.BodyStream.Close()
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristicFraction Last-Modifiedから新しさを推定するときに使う、最終更新からの経過時間の割合（RFC 9111 4.2.2）
+const heuristicFraction = 10
+
+// FreshnessSource 新しさ（Freshness.Lifetime）を決めた根拠
+type FreshnessSource string
+
+const (
+	// FreshnessNone 転送先が指定せず、推定もできなかった（呼び出し元のデフォルトを使う）
+	FreshnessNone FreshnessSource = ""
+	// FreshnessSMaxAge Cache-Controlのs-maxage（共有キャッシュ向け、max-ageより優先する）
+	FreshnessSMaxAge FreshnessSource = "s-maxage"
+	// FreshnessMaxAge Cache-Controlのmax-age
+	FreshnessMaxAge FreshnessSource = "max-age"
+	// FreshnessNoCache Cache-Controlのno-cache（保存してよいが、使う前に取得し直す必要がある）
+	FreshnessNoCache FreshnessSource = "no-cache"
+	// FreshnessExpires Expiresヘッダー
+	FreshnessExpires FreshnessSource = "expires"
+	// FreshnessHeuristic Last-Modifiedからの推定（最終更新からの経過時間の10%）
+	FreshnessHeuristic FreshnessSource = "heuristic"
+)
+
+// Freshness レスポンスをキャッシュに保存してよいか、保存した時点からどれだけの間新しいとみなせるか
+type Freshness struct {
+	// Storable キャッシュに保存してよいか（no-store・privateの場合はfalse）
+	Storable bool
+
+	// Lifetime 現在から新しいとみなせる残りの時間（転送中・DTNで経過した時間は差し引く、0以上）
+	Lifetime time.Duration
+
+	// Source Lifetimeを決めた根拠（FreshnessNoneの場合、Lifetimeは使わない）
+	Source FreshnessSource
+}
+
+// Freshness レスポンスのCache-Control・Expires・Last-Modifiedから、共有キャッシュとしての保存の可否と新しさを決める（domain層のロジック）
+// 優先順位はno-store・private、no-cache、s-maxage、max-age、Expires、Last-Modifiedによる推定（RFC 9111 4.2.1）
+// nowはキャッシュに保存する時刻で、Date・Ageから求めたレスポンスの経過時間をLifetimeから差し引く
+func (br *BpResponse) Freshness(now time.Time) Freshness {
+	header := http.Header(br.Headers)
+	directives := parseCacheControl(header.Values("Cache-Control"))
+
+	if _, ok := directives["no-store"]; ok {
+		return Freshness{}
+	}
+	if _, ok := directives["private"]; ok {
+		return Freshness{}
+	}
+	// no-cacheは保存してよいが、使う前に取得し直す必要があるため、すぐに期限切れとして扱う（max-ageなどより優先する）
+	if _, ok := directives["no-cache"]; ok {
+		return Freshness{Storable: true, Source: FreshnessNoCache}
+	}
+
+	freshness := Freshness{Storable: true}
+	var lifetime time.Duration
+	switch {
+	case validSeconds(directives, "s-maxage"):
+		lifetime, freshness.Source = seconds(directives["s-maxage"]), FreshnessSMaxAge
+	case validSeconds(directives, "max-age"):
+		lifetime, freshness.Source = seconds(directives["max-age"]), FreshnessMaxAge
+	default:
+		date := responseDate(header, now)
+		if expires := header.Get("Expires"); expires != "" {
+			freshness.Source = FreshnessExpires
+			// 解釈できないExpires（"0"など）は期限切れとして扱う（RFC 9111 5.3）
+			if t, err := http.ParseTime(expires); err == nil {
+				lifetime = t.Sub(date)
+			}
+		} else if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+			freshness.Source = FreshnessHeuristic
+			lifetime = date.Sub(lastModified) / heuristicFraction
+		} else {
+			return freshness
+		}
+	}
+
+	freshness.Lifetime = max(lifetime-br.age(now), 0)
+	return freshness
+}
+
+// WantsRefresh クライアントがキャッシュを使わずに取得し直すよう求めているか（Cache-Control: no-cache・max-age=0、Pragma: no-cache）
+// ブラウザで強制的に再読み込みした場合などに送られる
+func (br *BpRequest) WantsRefresh() bool {
+	header := http.Header(br.Headers)
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	if _, ok := directives["no-cache"]; ok {
+		return true
+	}
+	if value, ok := directives["max-age"]; ok && value == "0" {
+		return true
+	}
+	// Cache-Controlがない場合だけPragmaを見る（RFC 9111 5.4）
+	if len(header.Values("Cache-Control")) == 0 {
+		for _, value := range header.Values("Pragma") {
+			if strings.EqualFold(strings.TrimSpace(value), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequestsNoStore クライアントがレスポンスを保存しないよう求めているか（Cache-Control: no-store）
+func (br *BpRequest) RequestsNoStore() bool {
+	_, ok := parseCacheControl(http.Header(br.Headers).Values("Cache-Control"))["no-store"]
+	return ok
+}
+
+// age レスポンスが転送先で生成されてから経過した時間（Ageヘッダーと、Dateからの経過時間の大きい方）
+func (br *BpResponse) age(now time.Time) time.Duration {
+	header := http.Header(br.Headers)
+	var age time.Duration
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		age = max(now.Sub(date), 0)
+	}
+	if value, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64); err == nil && value > 0 {
+		age = max(age, time.Duration(value)*time.Second)
+	}
+	return age
+}
+
+// responseDate Dateヘッダーの時刻（ない・解釈できない場合はnow）
+func responseDate(header http.Header, now time.Time) time.Time {
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		return date
+	}
+	return now
+}
+
+// parseCacheControl Cache-Controlのディレクティブを小文字の名前から値（引用符は外す、値がない場合は空文字列）へのマップにする
+// 同じディレクティブが複数ある場合は最初のものを使う
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := directives[name]; ok {
+				continue
+			}
+			directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return directives
+}
+
+// validSeconds ディレクティブnameが0以上の秒数を持つか
+func validSeconds(directives map[string]string, name string) bool {
+	value, ok := directives[name]
+	if !ok {
+		return false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	return err == nil && n >= 0
+}
+
+// seconds 秒数のディレクティブの値をtime.Durationにする（validSecondsで確認した値）
+func seconds(value string) time.Duration {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	// 非常に大きい値（2^31秒を超えるなど）はオーバーフローしないよう上限を付ける（RFC 9111 1.2.2）
+	return time.Duration(min(n, 1<<31-1)) * time.Second
+}
@@ -0,0 +1,115 @@
+// cache_control_test.go - レスポンスのCache-Control・Expires・Last-Modifiedからキャッシュの方針を決めることと、クライアントの再読み込みの判定のテスト
+package model
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	httpTime := func(d time.Duration) string { return now.Add(d).Format(http.TimeFormat) }
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		storable bool
+		lifetime time.Duration
+		source   FreshnessSource
+	}{
+		{"no headers", nil, true, 0, FreshnessNone},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, false, 0, FreshnessNone},
+		{"no-store with max-age", http.Header{"Cache-Control": {"max-age=600, no-store"}}, false, 0, FreshnessNone},
+		{"private", http.Header{"Cache-Control": {"private, max-age=600"}}, false, 0, FreshnessNone},
+		{"private with fields", http.Header{"Cache-Control": {`private="Set-Cookie"`}}, false, 0, FreshnessNone},
+		{"uppercase", http.Header{"Cache-Control": {"No-Store"}}, false, 0, FreshnessNone},
+		{"public", http.Header{"Cache-Control": {"public"}}, true, 0, FreshnessNone},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=600"}}, true, 10 * time.Minute, FreshnessMaxAge},
+		{"quoted max-age", http.Header{"Cache-Control": {`max-age="600"`}}, true, 10 * time.Minute, FreshnessMaxAge},
+		{"max-age zero", http.Header{"Cache-Control": {"max-age=0"}}, true, 0, FreshnessMaxAge},
+		{"max-age in second header", http.Header{"Cache-Control": {"public", "max-age=60"}}, true, time.Minute, FreshnessMaxAge},
+		{"first max-age wins", http.Header{"Cache-Control": {"max-age=60, max-age=600"}}, true, time.Minute, FreshnessMaxAge},
+		{"huge max-age", http.Header{"Cache-Control": {"max-age=99999999999999"}}, true, (1<<31 - 1) * time.Second, FreshnessMaxAge},
+		{"invalid max-age", http.Header{"Cache-Control": {"max-age=soon"}}, true, 0, FreshnessNone},
+		{"negative max-age", http.Header{"Cache-Control": {"max-age=-1"}}, true, 0, FreshnessNone},
+		{"s-maxage over max-age", http.Header{"Cache-Control": {"max-age=60, s-maxage=3600"}}, true, time.Hour, FreshnessSMaxAge},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, true, 0, FreshnessNoCache},
+		{"no-cache over max-age", http.Header{"Cache-Control": {"max-age=600, no-cache"}}, true, 0, FreshnessNoCache},
+		{"max-age over expires", http.Header{"Cache-Control": {"max-age=60"}, "Expires": {httpTime(time.Hour)}, "Date": {httpTime(0)}}, true, time.Minute, FreshnessMaxAge},
+		{"expires", http.Header{"Expires": {httpTime(time.Hour)}, "Date": {httpTime(0)}}, true, time.Hour, FreshnessExpires},
+		{"expires without date", http.Header{"Expires": {httpTime(time.Hour)}}, true, time.Hour, FreshnessExpires},
+		{"expires in the past", http.Header{"Expires": {httpTime(-time.Hour)}, "Date": {httpTime(0)}}, true, 0, FreshnessExpires},
+		{"invalid expires", http.Header{"Expires": {"0"}}, true, 0, FreshnessExpires},
+		// 最終更新から10日経っているため、その10%の1日
+		{"heuristic", http.Header{"Last-Modified": {httpTime(-240 * time.Hour)}, "Date": {httpTime(0)}}, true, 24 * time.Hour, FreshnessHeuristic},
+		{"expires over heuristic", http.Header{"Last-Modified": {httpTime(-240 * time.Hour)}, "Expires": {httpTime(time.Hour)}}, true, time.Hour, FreshnessExpires},
+		{"future last-modified", http.Header{"Last-Modified": {httpTime(time.Hour)}}, true, 0, FreshnessNone},
+		// DTNを往復する間に経過した時間を差し引く
+		{"age header", http.Header{"Cache-Control": {"max-age=600"}, "Age": {"120"}}, true, 8 * time.Minute, FreshnessMaxAge},
+		{"old date", http.Header{"Cache-Control": {"max-age=600"}, "Date": {httpTime(-5 * time.Minute)}}, true, 5 * time.Minute, FreshnessMaxAge},
+		{"age over date", http.Header{"Cache-Control": {"max-age=600"}, "Date": {httpTime(-time.Minute)}, "Age": {"300"}}, true, 5 * time.Minute, FreshnessMaxAge},
+		{"older than max-age", http.Header{"Cache-Control": {"max-age=60"}, "Date": {httpTime(-time.Hour)}}, true, 0, FreshnessMaxAge},
+		{"expires after trip", http.Header{"Expires": {httpTime(time.Hour)}, "Date": {httpTime(-30 * time.Minute)}}, true, time.Hour, FreshnessExpires},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &BpResponse{StatusCode: http.StatusOK, Headers: tt.header}
+			got := resp.Freshness(now)
+			if got.Storable != tt.storable || got.Lifetime != tt.lifetime || got.Source != tt.source {
+				t.Errorf("Expected storable=%v lifetime=%v source=%q, got storable=%v lifetime=%v source=%q",
+					tt.storable, tt.lifetime, tt.source, got.Storable, got.Lifetime, got.Source)
+			}
+		})
+	}
+}
+
+func TestWantsRefresh(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{"no headers", nil, false},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, true},
+		{"max-age zero", http.Header{"Cache-Control": {"max-age=0"}}, true},
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"pragma", http.Header{"Pragma": {"no-cache"}}, true},
+		// Cache-Controlがある場合はPragmaを無視する
+		{"pragma with cache-control", http.Header{"Pragma": {"no-cache"}, "Cache-Control": {"max-age=60"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &BpRequest{Method: http.MethodGet, Headers: tt.header}
+			if got := req.WantsRefresh(); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsCacheableWithRequestNoStore(t *testing.T) {
+	saved := HonorRequestNoStore
+	defer func() { HonorRequestNoStore = saved }()
+
+	noStore := &BpRequest{Method: http.MethodGet, Headers: http.Header{"Cache-Control": {"max-age=0, No-Store"}}}
+	if !noStore.RequestsNoStore() {
+		t.Fatal("Expected no-store to be detected")
+	}
+	if (&BpRequest{Method: http.MethodGet, Headers: http.Header{"Cache-Control": {"no-cache"}}}).RequestsNoStore() {
+		t.Error("Expected no-cache not to be treated as no-store")
+	}
+
+	// 設定で有効にした場合だけキャッシュを使わない
+	HonorRequestNoStore = false
+	if !noStore.IsCacheable() {
+		t.Error("Expected no-store to be ignored when disabled")
+	}
+	HonorRequestNoStore = true
+	if noStore.IsCacheable() {
+		t.Error("Expected no-store to bypass the cache when enabled")
+	}
+	if !(&BpRequest{Method: http.MethodGet}).IsCacheable() {
+		t.Error("Expected a plain GET to stay cacheable")
+	}
+}
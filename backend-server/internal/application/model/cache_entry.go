@@ -0,0 +1,89 @@
+package model
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CacheEntry 管理用APIで返すキャッシュ1件の情報（ボディは含まない）
+type CacheEntry struct {
+	// CacheKey キャッシュキー（同じURLでもAccept・Accept-Languageが違えば別のエントリになる）
+	CacheKey string `json:"cache_key"`
+
+	// URL キャッシュしたリクエストのURL
+	URL string `json:"url"`
+
+	// StatusCode HTTPステータスコード
+	StatusCode int `json:"status_code"`
+
+	// ContentType Content-Typeヘッダーの値
+	ContentType string `json:"content_type,omitempty"`
+
+	// Size ボディのバイト数
+	Size int64 `json:"size"`
+
+	// StoredAt キャッシュに保存した時刻
+	StoredAt time.Time `json:"stored_at"`
+
+	// ExpiresAt キャッシュの有効期限
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Negative エラーレスポンスを短い期間だけ保存したネガティブキャッシュか
+	Negative bool `json:"negative,omitempty"`
+}
+
+// ErrCacheTooLarge レスポンスが1件のキャッシュの大きさの上限（またはキャッシュ全体の容量）を超えるため保存しなかった
+var ErrCacheTooLarge = errors.New("response is too large to cache")
+
+// CacheUsage キャッシュ全体の使用量（管理用APIで返す）
+type CacheUsage struct {
+	// Bytes 保存しているキャッシュのボディの大きさの合計
+	Bytes int64 `json:"bytes"`
+
+	// Entries 保存しているキャッシュの数
+	Entries int `json:"entries"`
+
+	// MaxBytes キャッシュ全体の容量の上限（超えると最終アクセスの古いキャッシュから削除する、0は上限なし）
+	MaxBytes int64 `json:"max_bytes"`
+
+	// MaxObjectBytes 1件のキャッシュの大きさの上限（超えるレスポンスは保存しない、0は上限なし）
+	MaxObjectBytes int64 `json:"max_object_bytes"`
+
+	// Evictions 容量を超えたために削除したキャッシュの数（プロセスを起動してから）
+	Evictions int64 `json:"evictions"`
+
+	// EvictedBytes 容量を超えたために削除したキャッシュの大きさの合計（プロセスを起動してから）
+	EvictedBytes int64 `json:"evicted_bytes"`
+}
+
+// CacheReconcileResult キャッシュ全体の使用量をキャッシュディレクトリのファイルと突き合わせて数え直した結果
+type CacheReconcileResult struct {
+	// BytesBefore 数え直す前に記録していた大きさの合計
+	BytesBefore int64 `json:"bytes_before"`
+
+	// Bytes 数え直した大きさの合計
+	Bytes int64 `json:"bytes"`
+
+	// Entries 数え直した後のキャッシュの数
+	Entries int `json:"entries"`
+
+	// Removed メタデータやファイルがなくなっていたため、インデックスから外したキャッシュの数
+	Removed int `json:"removed"`
+
+	// OrphanFiles どのキャッシュからも参照されていないため削除したファイルの数
+	OrphanFiles int `json:"orphan_files"`
+
+	// OrphanBytes 削除したファイルの大きさの合計
+	OrphanBytes int64 `json:"orphan_bytes"`
+}
+
+// CacheDomain キャッシュの一覧をドメインで絞り込むときのドメイン（URLのホスト名を小文字にしたもの、ポートは含まない）
+func CacheDomain(resourceURL string) string {
+	parsedURL, err := url.Parse(resourceURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsedURL.Hostname())
+}
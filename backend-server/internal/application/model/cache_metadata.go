@@ -4,6 +4,9 @@ import "time"
 
 // CacheMetadata キャッシュのメタデータ（Redisに保存）
 type CacheMetadata struct {
+	// URL キャッシュしたリクエストのURL（管理用APIでの表示・二次インデックスの削除に使う）
+	URL string `json:"url,omitempty"`
+
 	// FilePath ファイルシステム上のファイルパス
 	FilePath string `json:"file_path"`
 
@@ -24,6 +27,9 @@ type CacheMetadata struct {
 
 	// ExpiresAt キャッシュ有効期限
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// Negative エラーレスポンス（404など）を短い期間だけ保存したネガティブキャッシュか
+	Negative bool `json:"negative,omitempty"`
 }
 
 // IsExpired キャッシュが有効期限切れかどうかを判定する（domain層のロジック）
@@ -31,3 +37,30 @@ type CacheMetadata struct {
 func (cm *CacheMetadata) IsExpired() bool {
 	return time.Now().After(cm.ExpiresAt)
 }
+
+// IsEvicted 有効期限を過ぎ、さらに期限切れ（stale）のまま保持する猶予graceも過ぎたか（domain層のロジック）
+// graceが0以下の場合とネガティブキャッシュは有効期限切れと同じ（期限切れのエラーレスポンスは返さずに予約し直す）
+func (cm *CacheMetadata) IsEvicted(grace time.Duration) bool {
+	if cm.Negative {
+		grace = 0
+	}
+	return time.Now().After(cm.ExpiresAt.Add(max(grace, 0)))
+}
+
+// CleanupResult 期限切れのキャッシュを削除した結果
+type CleanupResult struct {
+	// Deleted 削除したキャッシュの数（ネガティブキャッシュを含む）
+	Deleted int `json:"deleted"`
+
+	// Negative 削除したキャッシュのうちネガティブキャッシュの数
+	Negative int `json:"negative"`
+
+	// Scanned 期限切れかを調べたメタデータの数
+	Scanned int `json:"scanned"`
+
+	// Remaining 1回に削除する数の上限を超えたため、次の回に残した期限切れのキャッシュの数（調べた範囲のうち）
+	Remaining int `json:"remaining"`
+
+	// Complete すべてのメタデータを調べ終えたか（falseの場合は次の回に続きから調べる）
+	Complete bool `json:"complete"`
+}
@@ -0,0 +1,33 @@
+package model
+
+// CacheStatus レスポンスがどのように用意されたか（キャッシュ、DTNへの予約、直接転送）
+type CacheStatus string
+
+const (
+	// CacheHit 有効なキャッシュを返した
+	CacheHit CacheStatus = "hit"
+
+	// CacheStale 有効期限を過ぎたキャッシュを返した
+	CacheStale CacheStatus = "stale"
+
+	// CacheRevalidating クライアントが取得し直しを求めた（Cache-Control: no-cacheなど）ため、キャッシュを返しながら更新を予約した
+	// DTNでは同期的に取得し直せないため、更新が届くまではキャッシュを返す
+	CacheRevalidating CacheStatus = "revalidating"
+
+	// CacheMissReserved キャッシュがないためDTNへリクエストを予約し、プレースホルダーを返した
+	CacheMissReserved CacheStatus = "miss-reserved"
+
+	// CacheMissPlaceholder キャッシュがなく、予約もしなかった（除外ドメイン、予約の失敗）ためプレースホルダーを返した
+	CacheMissPlaceholder CacheStatus = "miss-placeholder"
+
+	// CacheNegative エラーレスポンス（404など）を短い期間だけ保存したネガティブキャッシュを返した（予約し直さない）
+	CacheNegative CacheStatus = "negative"
+
+	// CacheMissDirect キャッシュを使わずにゲートウェイで転送した（キャッシュ不可のリクエスト、キャッシュの取得エラー）
+	CacheMissDirect CacheStatus = "miss-direct"
+)
+
+// IsHit キャッシュからのレスポンスか（有効期限切れ・更新中を含む）
+func (cs CacheStatus) IsHit() bool {
+	return cs == CacheHit || cs == CacheStale || cs == CacheRevalidating
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// DeadLetter 転送に失敗し続け、送り直しの上限に達した予約（デッドレター）
+// 予約は削除し、管理APIで調べてキューに戻せるように最後のエラーとともに残す
+type DeadLetter struct {
+	// CacheKey 予約のキャッシュキー（キューに戻すときに指定する）
+	CacheKey string `json:"cache_key"`
+
+	// Request 最後に転送したときの予約（Attemptsは送り直した回数）
+	Request *BpRequest `json:"request"`
+
+	// LastError 最後の転送の失敗
+	LastError string `json:"last_error"`
+
+	// FailedAt デッドレターに移した時刻
+	FailedAt time.Time `json:"failed_at"`
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// htmlTagPattern HTMLの開始タグ（タグ名と属性）
+var htmlTagPattern = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)\b([^>]*)>`)
+
+// htmlAttrPattern 開始タグの属性（名前と、引用符あり・なしの値）
+var htmlAttrPattern = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// rewritableLinkAttrs RewriteHTMLLinksが書き換えるリンク先の属性
+var rewritableLinkAttrs = map[string]bool{"href": true, "src": true, "poster": true, "action": true}
+
+// assetLinkRels ページの表示に使うリソースを指す<link>のrel
+var assetLinkRels = []string{"stylesheet", "icon", "preload", "modulepreload"}
+
+// HTMLLink HTMLから取り出したリンク
+type HTMLLink struct {
+	// URL 正規化した絶対URL
+	URL string
+
+	// Asset ページの表示に使うリソース（画像・CSS・スクリプトなど）か（falseの場合は<a>などの別のページ）
+	Asset bool
+}
+
+// ExtractHTMLLinks HTMLのリンクのうち、pageURLと同じホストのhttp・httpsのURLを出現順に返す（domain層のロジック）
+//   - 別のページ: <a>・<area>のhref、<iframe>のsrc
+//   - リソース: <img>・<script>・<source>・<embed>・<track>のsrc、<video>のposter、relがstylesheet・icon・preloadの<link>のhref
+//
+// 相対URLはpageURLから解決し、正規化して重複を除く。フラグメントだけのリンクやpageURL自身は含めない
+// 他のホストへのリンクは含めない（事前取得するリンク先が際限なく広がらないように）
+func ExtractHTMLLinks(pageURL string, body []byte) []HTMLLink {
+	base, err := url.Parse(pageURL)
+	if err != nil || base.Hostname() == "" {
+		return nil
+	}
+	seen := map[string]bool{NormalizeURL(pageURL, StripQueryParams): true}
+
+	var links []HTMLLink
+	for _, tag := range htmlTagPattern.FindAllSubmatch(body, -1) {
+		value, asset, ok := linkAttr(strings.ToLower(string(tag[1])), parseAttrs(tag[2]))
+		if !ok {
+			continue
+		}
+		link, ok := resolveLink(base, value)
+		if !ok || seen[link] {
+			continue
+		}
+		seen[link] = true
+		links = append(links, HTMLLink{URL: link, Asset: asset})
+	}
+	return links
+}
+
+// linkAttr タグのリンク先の属性の値と、リソースへのリンクかを返す（リンクではないタグはfalse）
+func linkAttr(tag string, attrs map[string]string) (value string, asset bool, ok bool) {
+	switch tag {
+	case "a", "area":
+		value, ok = attrs["href"]
+		return value, false, ok
+	case "iframe":
+		value, ok = attrs["src"]
+		return value, false, ok
+	case "img", "script", "source", "embed", "track":
+		value, ok = attrs["src"]
+		return value, true, ok
+	case "video":
+		value, ok = attrs["poster"]
+		return value, true, ok
+	case "link":
+		for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+			for _, assetRel := range assetLinkRels {
+				if rel == assetRel {
+					value, ok = attrs["href"]
+					return value, true, ok
+				}
+			}
+		}
+	}
+	return "", false, false
+}
+
+// parseAttrs 開始タグの属性を小文字の名前から値（文字参照は展開する）へのマップにする（同じ名前は最初のもの）
+func parseAttrs(raw []byte) map[string]string {
+	attrs := make(map[string]string)
+	for _, match := range htmlAttrPattern.FindAllSubmatch(raw, -1) {
+		name := strings.ToLower(string(match[1]))
+		if _, ok := attrs[name]; ok {
+			continue
+		}
+		attrs[name] = strings.TrimSpace(html.UnescapeString(string(match[2]) + string(match[3]) + string(match[4])))
+	}
+	return attrs
+}
+
+// resolveLink リンク先をbaseから解決して正規化する（同じホストのhttp・httpsのURLでない場合はfalse）
+func resolveLink(base *url.URL, value string) (string, bool) {
+	if value == "" || strings.HasPrefix(value, "#") {
+		return "", false
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	target := base.ResolveReference(ref)
+	if scheme := strings.ToLower(target.Scheme); scheme != "http" && scheme != "https" {
+		return "", false
+	}
+	if !strings.EqualFold(target.Hostname(), base.Hostname()) {
+		return "", false
+	}
+	return NormalizeURL(target.String(), StripQueryParams), true
+}
+
+// AssetLinks HTMLが参照する同じホストのリソース（画像・CSS・スクリプトなど）のURLを最大limit件返す
+func AssetLinks(pageURL string, body []byte, limit int) []string {
+	var assets []string
+	for _, link := range ExtractHTMLLinks(pageURL, body) {
+		if len(assets) >= limit {
+			break
+		}
+		if link.Asset {
+			assets = append(assets, link.URL)
+		}
+	}
+	return assets
+}
+
+// RewriteHTMLLinks HTMLの開始タグのリンク先の属性（href・src・poster・action）をpageURLから解決してrewriteに渡し、
+// trueが返った場合は属性の値を置き換えたHTMLを返す（domain層のロジック）
+// 空の値とフラグメントだけのリンクは渡さない。pageURLを解析できない場合はそのまま返す
+func RewriteHTMLLinks(pageURL string, body []byte, rewrite func(target *url.URL) (string, bool)) []byte {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return body
+	}
+	return htmlTagPattern.ReplaceAllFunc(body, func(tag []byte) []byte {
+		m := htmlTagPattern.FindSubmatchIndex(tag)
+		attrs := tag[m[4]:m[5]]
+		changed := false
+		rewritten := htmlAttrPattern.ReplaceAllFunc(attrs, func(attr []byte) []byte {
+			match := htmlAttrPattern.FindSubmatch(attr)
+			if !rewritableLinkAttrs[strings.ToLower(string(match[1]))] {
+				return attr
+			}
+			value := strings.TrimSpace(html.UnescapeString(string(match[2]) + string(match[3]) + string(match[4])))
+			if value == "" || strings.HasPrefix(value, "#") {
+				return attr
+			}
+			ref, err := url.Parse(value)
+			if err != nil {
+				return attr
+			}
+			replaced, ok := rewrite(base.ResolveReference(ref))
+			if !ok {
+				return attr
+			}
+			changed = true
+			return []byte(string(match[1]) + `="` + html.EscapeString(replaced) + `"`)
+		})
+		if !changed {
+			return tag
+		}
+		out := make([]byte, 0, len(tag)+len(rewritten)-len(attrs))
+		out = append(out, tag[:m[4]]...)
+		out = append(out, rewritten...)
+		return append(out, tag[m[5]:]...)
+	})
+}
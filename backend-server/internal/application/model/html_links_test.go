@@ -0,0 +1,94 @@
+// html_links_test.go - HTMLからページへのリンクとリソースへのリンクを取り出すことのテスト
+package model
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractHTMLLinks(t *testing.T) {
+	body := []byte(`<!DOCTYPE html>
+<html><head>
+<link rel="stylesheet" href="/css/site.css">
+<LINK REL="shortcut icon" HREF="/favicon.ico">
+<link rel="preload" as="font" href="/fonts/a.woff2">
+<link rel="canonical" href="https://example.com/article">
+<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+<script src="/js/app.js?v=2"></script>
+<script>var s = "<img src='/not-a-tag.png'>";</script>
+</head><body>
+<a class="nav" href="/about">about</a>
+<img alt="logo" src="img/logo.png">
+<picture><source srcset="/img/hero.webp"><source src="/img/hero.avif"></picture>
+<video poster="/img/poster.jpg" src="https://cdn.example.net/v.mp4"></video>
+<iframe src="/embed/map"></iframe>
+<img src="/css/site.css">
+<img src="data:image/png;base64,AAAA">
+<a href="/article#comments">self</a>
+<area href="/map/1">
+</body></html>`)
+	got := ExtractHTMLLinks("https://example.com/article", body)
+	want := []HTMLLink{
+		{"https://example.com/css/site.css", true},
+		{"https://example.com/favicon.ico", true},
+		{"https://example.com/fonts/a.woff2", true},
+		{"https://example.com/js/app.js?v=2", true},
+		// スクリプト内の文字列も開始タグに見えるものは取り出す（正規表現で読むため）
+		{"https://example.com/not-a-tag.png", true},
+		{"https://example.com/about", false},
+		{"https://example.com/img/logo.png", true},
+		{"https://example.com/img/hero.avif", true},
+		{"https://example.com/img/poster.jpg", true},
+		{"https://example.com/embed/map", false},
+		{"https://example.com/map/1", false},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ExtractHTMLLinks() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestAssetLinks(t *testing.T) {
+	body := []byte(`<a href="/page"><img src="/a.png"><img src="/b.png"><link rel="stylesheet" href="/c.css"><img src="https://other.example/d.png">`)
+	if got := AssetLinks("http://example.com/", body, 10); !slices.Equal(got, []string{"http://example.com/a.png", "http://example.com/b.png", "http://example.com/c.css"}) {
+		t.Errorf("Unexpected assets: %v", got)
+	}
+	if got := AssetLinks("http://example.com/", body, 2); len(got) != 2 {
+		t.Errorf("Expected the limit to apply, got %v", got)
+	}
+	if got := AssetLinks("http://example.com/", body, 0); len(got) != 0 {
+		t.Errorf("Expected no assets with a zero limit, got %v", got)
+	}
+}
+
+func TestRewriteHTMLLinks(t *testing.T) {
+	body := []byte(`<a class="nav" href='/about'>about</a>
+<IMG SRC=img/logo.png alt="logo">
+<form action="/search?q=a&amp;b=1"><input value="/not-a-link"></form>
+<a href="">empty</a><a href="#top">top</a>
+<link rel="stylesheet" href="https://cdn.example.net/site.css">`)
+	got := string(RewriteHTMLLinks("https://example.com/docs/", body, func(target *url.URL) (string, bool) {
+		if target.Host != "example.com" {
+			return "", false
+		}
+		return "/proxy?u=" + target.String() + "&x=\"", true
+	}))
+	for _, want := range []string{
+		`<a class="nav" href="/proxy?u=https://example.com/about&amp;x=&#34;">`,
+		`<IMG SRC="/proxy?u=https://example.com/docs/img/logo.png&amp;x=&#34;" alt="logo">`,
+		// エスケープされた値は解いてから解決し、書き換え後はエスケープして引用符で囲む
+		`action="/proxy?u=https://example.com/search?q=a&amp;b=1&amp;x=&#34;"`,
+		`<input value="/not-a-link">`,
+		`<a href="">empty</a><a href="#top">top</a>`,
+		`href="https://cdn.example.net/site.css"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %s in\n%s", want, got)
+		}
+	}
+
+	if got := RewriteHTMLLinks("://invalid", body, func(*url.URL) (string, bool) { return "x", true }); string(got) != string(body) {
+		t.Errorf("Expected the body unchanged for an invalid page URL")
+	}
+}
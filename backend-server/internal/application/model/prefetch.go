@@ -0,0 +1,41 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MaxPrefetchDepth 事前取得でHTMLからたどるリンクの深さの上限（リンクをたどるほど予約が増えるため）
+const MaxPrefetchDepth = 3
+
+// MaxPrefetchLinks 事前取得で1つのHTMLからたどるリンクの数の上限
+const MaxPrefetchLinks = 50
+
+// PrefetchTarget 事前取得するURLを検証して正規化する（domain層のロジック）
+// httpかhttpsの絶対URLだけを受け付け、キャッシュキーと同じく正規化したURLを返す
+func PrefetchTarget(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	if scheme := strings.ToLower(u.Scheme); scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("URL has no host")
+	}
+	return NormalizeURL(u.String(), StripQueryParams), nil
+}
+
+// PrefetchLinks 事前取得でたどるリンク（HTMLが参照する同じホストのページとリソース、ExtractHTMLLinksを参照）を最大MaxPrefetchLinks件返す
+func PrefetchLinks(pageURL string, body []byte) []string {
+	var links []string
+	for _, link := range ExtractHTMLLinks(pageURL, body) {
+		if len(links) >= MaxPrefetchLinks {
+			break
+		}
+		links = append(links, link.URL)
+	}
+	return links
+}
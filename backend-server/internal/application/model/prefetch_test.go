@@ -0,0 +1,79 @@
+// prefetch_test.go - 事前取得するURLの検証とHTMLからたどるリンクのテスト
+package model
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPrefetchTarget(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"https://Example.com:443/a/./b?b=2&a=1#top", "https://example.com/a/b?a=1&b=2", false},
+		{"  http://example.com  ", "http://example.com/", false},
+		{"ftp://example.com/file", "", true},
+		{"example.com/page", "", true},
+		{"/relative", "", true},
+		{"http:///path", "", true},
+		{"http://exa mple.com/", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := PrefetchTarget(tt.raw)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("PrefetchTarget(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("PrefetchTarget(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestPrefetchLinks(t *testing.T) {
+	body := []byte(`<html><head>
+<link rel="stylesheet" href="/style.css">
+<script src='app.js'></script>
+</head><body>
+<a href="https://example.com/news/1">1</a>
+<a HREF=/news/2>2</a>
+<a href="/news/1#comments">1 again</a>
+<a href="https://EXAMPLE.com:443/news/3?b=2&amp;a=1">3</a>
+<a href="#top">top</a>
+<a href="/articles/">self</a>
+<a href="https://other.example/page">other host</a>
+<a href="mailto:news@example.com">mail</a>
+<a href="javascript:void(0)">js</a>
+<img src="../images/logo.png">
+<a data-href="/not-a-link">x</a>
+</body></html>`)
+	got := PrefetchLinks("https://example.com/articles/", body)
+	want := []string{
+		"https://example.com/style.css",
+		"https://example.com/articles/app.js",
+		"https://example.com/news/1",
+		"https://example.com/news/2",
+		"https://example.com/news/3?a=1&b=2",
+		"https://example.com/images/logo.png",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("PrefetchLinks() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestPrefetchLinksLimit(t *testing.T) {
+	var b strings.Builder
+	for i := range MaxPrefetchLinks + 10 {
+		b.WriteString(`<a href="/page/` + strings.Repeat("x", i+1) + `">`)
+	}
+	if got := PrefetchLinks("http://example.com/", []byte(b.String())); len(got) != MaxPrefetchLinks {
+		t.Errorf("Expected %d links, got %d", MaxPrefetchLinks, len(got))
+	}
+	if got := PrefetchLinks("not a url", []byte(`<a href="/a">`)); got != nil {
+		t.Errorf("Expected no links for an invalid page URL, got %v", got)
+	}
+}
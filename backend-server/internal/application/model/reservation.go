@@ -0,0 +1,114 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReservationPriority 予約キューの優先度
+// Workerは取り出せる予約のうち最も優先度の高いものから、同じ優先度では予約した時刻の古いものから取り出す
+type ReservationPriority int
+
+const (
+	// PriorityInteractive ブラウザがプレースホルダーを表示して待っているリクエスト
+	PriorityInteractive ReservationPriority = iota
+	// PriorityRevalidation 期限切れのキャッシュの更新（ブラウザは期限切れのキャッシュを受け取っていて待っていない）
+	PriorityRevalidation
+	// PriorityPrefetch 事前取得（管理者の事前取得と、保存したページのリソース）
+	PriorityPrefetch
+)
+
+// ReservationPriorities すべての優先度（取り出す順）
+var ReservationPriorities = []ReservationPriority{PriorityInteractive, PriorityRevalidation, PriorityPrefetch}
+
+func (p ReservationPriority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityRevalidation:
+		return "revalidation"
+	case PriorityPrefetch:
+		return "prefetch"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// Reservation Worker Poolへのリクエストの予約の結果
+// 同じキャッシュキーのリクエストは一度だけ予約するため、既に予約されている場合はその予約の時刻を返す
+type Reservation struct {
+	// Queued 今回新しくキューに追加したか（falseの場合は同じキャッシュキーのリクエストが既に予約・処理中）
+	Queued bool
+
+	// ReservedAt キューに追加した時刻（Queuedがfalseの場合は既にある予約の時刻）
+	ReservedAt time.Time
+
+	// Position 予約キューでの位置（Workerが取り出す順、1から）。0はキューから取り出されて処理中、または位置が分からない
+	// 後から予約した優先度の高い予約が先に取り出されるため、待っている間に位置が後ろになることもある
+	Position int
+
+	// QueueLength 予約キューにある予約の数（すべての優先度の合計）
+	QueueLength int
+}
+
+// FindQueuePosition Workerが取り出す順に並んだ予約キューから、cacheKeyの予約の位置（1から）と予約を返す
+// キューにない場合は0とnilを返す
+func FindQueuePosition(queue []*BpRequest, cacheKey string) (int, *BpRequest) {
+	for i, req := range queue {
+		if req.GenerateCacheKey() == cacheKey {
+			return i + 1, req
+		}
+	}
+	return 0, nil
+}
+
+// RoundTripSource 最近のDTNの往復時間から求めた、1つのリクエストのレスポンスが届くまでの時間の目安（ゲートウェイが記録する）
+type RoundTripSource interface {
+	// EstimatedRoundTrip 往復時間の目安（記録が足りない場合はfalse）
+	EstimatedRoundTrip() (time.Duration, bool)
+}
+
+// DeliveryEstimate 予約したリクエストのレスポンスがDTN経由で届く時刻の目安の計算（domain層のロジック）
+type DeliveryEstimate struct {
+	// RoundTrip 1つのリクエストのレスポンスがDTN経由で届くまでの平均的な時間（0以下は目安を出さない）
+	// Observedが目安を返す間は、Observedの値を使う
+	RoundTrip time.Duration
+
+	// Observed 最近の往復時間から求めた目安（nilの場合や記録が足りない間はRoundTripを使う）
+	Observed RoundTripSource
+
+	// Concurrency 同時にDTNへ送るリクエストの数（Worker Poolのワーカー数、0以下は1とみなす）
+	Concurrency int
+}
+
+// EstimatedAt 予約キューのposition番目（1から）の予約のレスポンスが届く時刻の目安
+// Workerはキューの先頭からConcurrency件ずつ並行して送り、それぞれRoundTripで届くとみなす
+// positionが0（Workerが取り出して処理中）の場合は予約した時刻からRoundTrip後（過ぎている場合はnow）
+// RoundTripが0以下の場合はゼロを返す
+func (e DeliveryEstimate) EstimatedAt(now, reservedAt time.Time, position int) time.Time {
+	roundTrip := e.roundTrip()
+	if roundTrip <= 0 {
+		return time.Time{}
+	}
+	if position <= 0 {
+		if reservedAt.IsZero() {
+			return now.Add(roundTrip)
+		}
+		if at := reservedAt.Add(roundTrip); at.After(now) {
+			return at
+		}
+		return now
+	}
+	concurrency := max(e.Concurrency, 1)
+	rounds := (position + concurrency - 1) / concurrency
+	return now.Add(time.Duration(rounds) * roundTrip)
+}
+
+// roundTrip 目安に使う往復時間（Observedの目安があればその値、なければRoundTrip）
+func (e DeliveryEstimate) roundTrip() time.Duration {
+	if e.Observed != nil {
+		if d, ok := e.Observed.EstimatedRoundTrip(); ok && d > 0 {
+			return d
+		}
+	}
+	return e.RoundTrip
+}
@@ -0,0 +1,64 @@
+// reservation_test.go - 予約キューでの位置と、レスポンスが届く時刻の目安の計算のテスト
+package model
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFindQueuePosition(t *testing.T) {
+	queue := []*BpRequest{
+		{Method: http.MethodGet, URL: "https://example.com/a"},
+		{Method: http.MethodGet, URL: "https://example.com/b"},
+		{Method: http.MethodGet, URL: "https://example.com/c"},
+	}
+	target := &BpRequest{Method: http.MethodGet, URL: "https://example.com/c"}
+	if position, req := FindQueuePosition(queue, target.GenerateCacheKey()); position != 3 || req != queue[2] {
+		t.Errorf("Expected position 3, got %d (%v)", position, req)
+	}
+	missing := &BpRequest{Method: http.MethodGet, URL: "https://example.com/d"}
+	if position, req := FindQueuePosition(queue, missing.GenerateCacheKey()); position != 0 || req != nil {
+		t.Errorf("Expected no position, got %d (%v)", position, req)
+	}
+}
+
+// fixedRoundTrip 決まった往復時間の目安を返すRoundTripSource
+type fixedRoundTrip struct {
+	d  time.Duration
+	ok bool
+}
+
+func (f fixedRoundTrip) EstimatedRoundTrip() (time.Duration, bool) { return f.d, f.ok }
+
+func TestDeliveryEstimate(t *testing.T) {
+	now := time.Date(2025, 11, 1, 2, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		estimate   DeliveryEstimate
+		reservedAt time.Time
+		position   int
+		want       time.Time
+	}{
+		{"disabled", DeliveryEstimate{Concurrency: 4}, now, 1, time.Time{}},
+		{"first round", DeliveryEstimate{RoundTrip: 10 * time.Minute, Concurrency: 4}, now, 4, now.Add(10 * time.Minute)},
+		{"second round", DeliveryEstimate{RoundTrip: 10 * time.Minute, Concurrency: 4}, now, 5, now.Add(20 * time.Minute)},
+		{"position 17 of 4 workers", DeliveryEstimate{RoundTrip: 10 * time.Minute, Concurrency: 4}, now, 17, now.Add(50 * time.Minute)},
+		{"no concurrency", DeliveryEstimate{RoundTrip: 10 * time.Minute}, now, 3, now.Add(30 * time.Minute)},
+		// キューから取り出されて転送中の予約は、予約した時刻から往復時間後
+		{"in flight", DeliveryEstimate{RoundTrip: 10 * time.Minute, Concurrency: 4}, now.Add(-4 * time.Minute), 0, now.Add(6 * time.Minute)},
+		{"in flight overdue", DeliveryEstimate{RoundTrip: 10 * time.Minute, Concurrency: 4}, now.Add(-time.Hour), 0, now},
+		{"in flight without reserved time", DeliveryEstimate{RoundTrip: 10 * time.Minute}, time.Time{}, 0, now.Add(10 * time.Minute)},
+		// ゲートウェイが記録した往復時間があれば、設定の目安より優先する
+		{"observed round trip", DeliveryEstimate{RoundTrip: 10 * time.Minute, Observed: fixedRoundTrip{3 * time.Minute, true}, Concurrency: 4}, now, 5, now.Add(6 * time.Minute)},
+		{"observed without enough samples", DeliveryEstimate{RoundTrip: 10 * time.Minute, Observed: fixedRoundTrip{}, Concurrency: 4}, now, 5, now.Add(20 * time.Minute)},
+		{"observed without a configured estimate", DeliveryEstimate{Observed: fixedRoundTrip{3 * time.Minute, true}}, now, 1, now.Add(3 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.estimate.EstimatedAt(now, tt.reservedAt, tt.position); !got.Equal(tt.want) {
+				t.Errorf("EstimatedAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
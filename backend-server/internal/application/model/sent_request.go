@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// SentRequest DTNへ送ったリクエストの記録（送信の記録）
+// バンドルを送ってからレスポンスを処理するまでの間にバックエンドが再起動しても、届いたレスポンスがどの予約のものかを分かるようにする
+type SentRequest struct {
+	// RequestID バンドルのrequest_id（地上局はレスポンスに同じIDを付けて返す）
+	RequestID string `json:"request_id"`
+
+	// Request 予約キューから取り出したままのリクエスト（キャッシュキー、予約の削除・キューへの戻しに使う）
+	Request *BpRequest `json:"request"`
+
+	// SentAt 送った時刻
+	SentAt time.Time `json:"sent_at"`
+}
+
+// Age nowの時点で送ってから経った時間
+func (s *SentRequest) Age(now time.Time) time.Duration {
+	return now.Sub(s.SentAt)
+}
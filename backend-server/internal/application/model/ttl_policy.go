@@ -0,0 +1,78 @@
+package model
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TTLRule コンテンツの種類・ドメインごとのキャッシュの期間
+type TTLRule struct {
+	// Domain 対象のドメイン（"*.example.com"はサブドメインも含む、空の場合はすべてのドメイン）
+	Domain string
+
+	// ContentType 対象のContent-Typeの前方一致（"text/html"、"image/"など、空の場合はすべての種類）
+	ContentType string
+
+	// TTL キャッシュの期間
+	TTL time.Duration
+}
+
+// TTLPolicy 転送先がキャッシュの期間を示さない（Cache-Control・Expires・Last-Modifiedがない）場合のTTLを決める
+// ニュースのHTMLは短く、フォントや画像は長くするなど、コンテンツの種類とドメインでTTLを変える
+type TTLPolicy struct {
+	// Default どのルールにも一致しない場合のTTL
+	Default time.Duration
+
+	// Rules TTLのルール（Resolveを参照）
+	Rules []TTLRule
+}
+
+// Resolve resourceURLのレスポンス（Content-TypeがcontentType）のTTLを返す（domain層のロジック）
+// ドメインを指定したルールはContent-Typeだけのルールより優先し、同じ場合はContent-Typeも指定したルールを優先する
+// 優先度が同じルールは先に書いたものを使い、一致するルールがない場合はDefault
+func (p TTLPolicy) Resolve(resourceURL, contentType string) time.Duration {
+	host := CacheDomain(resourceURL)
+	mediaType := strings.ToLower(contentType)
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		mediaType = parsed
+	}
+
+	ttl, best := p.Default, 0
+	for _, rule := range p.Rules {
+		score := 1
+		if rule.Domain != "" {
+			if !matchTTLDomain(strings.ToLower(rule.Domain), host) {
+				continue
+			}
+			score += 2
+		}
+		if rule.ContentType != "" {
+			if mediaType == "" || !strings.HasPrefix(mediaType, strings.ToLower(rule.ContentType)) {
+				continue
+			}
+			score++
+		}
+		if score > best {
+			ttl, best = rule.TTL, score
+		}
+	}
+	return ttl
+}
+
+// ResponseContentType レスポンスのContent-Type（ContentTypeがなければヘッダーのContent-Type）
+func (br *BpResponse) ResponseContentType() string {
+	if br.ContentType != "" {
+		return br.ContentType
+	}
+	return http.Header(br.Headers).Get("Content-Type")
+}
+
+// matchTTLDomain hostがpatternに一致するか（"*.example.com"はexample.comとそのサブドメインに一致する）
+func matchTTLDomain(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
@@ -0,0 +1,77 @@
+// ttl_policy_test.go - Content-Type・ドメインごとのTTLのポリシーのテスト
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLPolicyResolve(t *testing.T) {
+	policy := TTLPolicy{
+		Default: 24 * time.Hour,
+		Rules: []TTLRule{
+			{ContentType: "text/html", TTL: time.Hour},
+			{ContentType: "image/", TTL: 7 * 24 * time.Hour},
+			{ContentType: "font/", TTL: 30 * 24 * time.Hour},
+			{ContentType: "application/javascript", TTL: 12 * time.Hour},
+			{ContentType: "image/svg", TTL: 2 * time.Hour},
+			{Domain: "*.news.example", ContentType: "text/html", TTL: 10 * time.Minute},
+			{Domain: "static.example.com", TTL: 48 * time.Hour},
+			{Domain: "cdn.example.com", TTL: 3 * time.Hour},
+			{Domain: "cdn.example.com", TTL: 4 * time.Hour},
+		},
+	}
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		want        time.Duration
+	}{
+		{"html", "https://example.com/", "text/html", time.Hour},
+		{"html with parameters", "https://example.com/", "text/html; charset=UTF-8", time.Hour},
+		{"uppercase type", "https://example.com/", "Text/HTML", time.Hour},
+		{"image prefix", "https://example.com/a.png", "image/png", 7 * 24 * time.Hour},
+		{"first matching rule wins", "https://example.com/a.svg", "image/svg+xml", 7 * 24 * time.Hour},
+		{"font", "https://example.com/a.woff2", "font/woff2", 30 * 24 * time.Hour},
+		{"javascript", "https://example.com/a.js", "application/javascript", 12 * time.Hour},
+		{"unlisted type", "https://example.com/a.json", "application/json", 24 * time.Hour},
+		{"empty type", "https://example.com/a", "", 24 * time.Hour},
+		{"invalid type", "https://example.com/a", "text/html;;=", time.Hour},
+
+		// ドメインを指定したルールが優先される
+		{"wildcard subdomain", "https://www.news.example/", "text/html", 10 * time.Minute},
+		{"wildcard apex", "https://NEWS.example:8443/", "text/html", 10 * time.Minute},
+		{"wildcard other type", "https://www.news.example/a.png", "image/png", 7 * 24 * time.Hour},
+		{"wildcard not a suffix", "https://fakenews.example/", "text/html", time.Hour},
+		{"domain without type", "https://static.example.com/a.png", "image/png", 48 * time.Hour},
+		{"domain without type no content type", "https://static.example.com/a", "", 48 * time.Hour},
+		{"exact domain only", "https://www.static.example.com/a.png", "image/png", 7 * 24 * time.Hour},
+		{"first domain rule wins", "https://cdn.example.com/a.css", "text/css", 3 * time.Hour},
+		{"relative url", "/index.html", "text/html", time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Resolve(tt.url, tt.contentType); got != tt.want {
+				t.Errorf("Resolve(%q, %q) = %v, want %v", tt.url, tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTTLPolicyWithoutRules(t *testing.T) {
+	policy := TTLPolicy{Default: time.Hour}
+	if got := policy.Resolve("https://example.com/", "text/html"); got != time.Hour {
+		t.Errorf("Expected the default TTL, got %v", got)
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	resp := &BpResponse{Headers: map[string][]string{"Content-Type": {"image/png"}}}
+	if got := resp.ResponseContentType(); got != "image/png" {
+		t.Errorf("Expected the Content-Type header, got %q", got)
+	}
+	resp.ContentType = "text/html"
+	if got := resp.ResponseContentType(); got != "text/html" {
+		t.Errorf("Expected ContentType to take precedence, got %q", got)
+	}
+}
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// StripQueryParams キャッシュキーを作るときにURLから取り除くクエリパラメータ（utm_sourceなどのトラッキング用）
+// 末尾が"*"のものは前方一致（"utm_*"）、それ以外は完全一致。起動時に設定から一度だけ設定する
+var StripQueryParams []string
+
+// defaultPorts スキームごとの省略できるポート
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizedURL キャッシュキーに使う正規化したURL（StripQueryParamsのパラメータを取り除く）
+func (br *BpRequest) NormalizedURL() string {
+	return NormalizeURL(br.URL, StripQueryParams)
+}
+
+// NormalizeURL 同じリソースを指すURLが同じ文字列になるよう正規化する（domain層のロジック、RFC 3986 6.2.2）
+//   - スキームとホストを小文字にし、既定のポート（httpの80、httpsの443）を取り除く
+//   - 英数字と"-._~"のパーセントエンコーディングを元の文字に戻し、それ以外は16進数を大文字にそろえる
+//   - パスの"."・".."を解決する（空のパスは"/"）
+//   - クエリパラメータを名前順に並べ（同じ名前の順序は保つ）、stripParamsに一致するものを取り除く
+//   - フラグメントを取り除く（サーバーには送られない）
+//
+// 末尾の"/"はサーバーによって別のリソースを指すため、そのまま残す
+// 解析できないURLや相対URLはそのまま返す
+func NormalizeURL(raw string, stripParams []string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Opaque != "" || u.Scheme == "" || u.Host == "" {
+		return raw
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		// IPv6アドレス
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[scheme] {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme)
+	b.WriteString("://")
+	if u.User != nil {
+		b.WriteString(u.User.String())
+		b.WriteByte('@')
+	}
+	b.WriteString(host)
+
+	path := removeDotSegments(normalizePercentEncoding(u.EscapedPath()))
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+
+	if query := normalizeQuery(u.RawQuery, stripParams); query != "" {
+		b.WriteByte('?')
+		b.WriteString(query)
+	}
+	return b.String()
+}
+
+// normalizeQuery クエリのパラメータを名前順に並べ、stripParamsに一致するものと空のパラメータを取り除く
+func normalizeQuery(rawQuery string, stripParams []string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	type param struct {
+		name string
+		raw  string
+	}
+	var params []param
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		part = normalizePercentEncoding(part)
+		rawName, _, _ := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		if matchesParam(name, stripParams) {
+			continue
+		}
+		params = append(params, param{name: name, raw: part})
+	}
+	// 同じ名前のパラメータ（a=1&a=2）は順序に意味があることがあるため、安定ソートで元の順序を保つ
+	slices.SortStableFunc(params, func(a, b param) int { return strings.Compare(a.name, b.name) })
+
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+// matchesParam クエリパラメータの名前がpatternsのいずれかに一致するか（末尾の"*"は前方一致）
+func matchesParam(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePercentEncoding 非予約文字（英数字と"-._~"）のパーセントエンコーディングを元の文字に戻し、
+// それ以外のパーセントエンコーディングは16進数を大文字にそろえる（"%2f"→"%2F"）
+// 正しくない"%"はそのまま残す
+func normalizePercentEncoding(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	const upperHex = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(upperHex[c>>4])
+			b.WriteByte(upperHex[c&15])
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// removeDotSegments パスの"."・".."のセグメントを解決する（RFC 3986 5.2.4）
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+	var out []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				// "/a/."は"/a/"になる
+				out = append(out, "")
+			}
+		case "..":
+			// 先頭の空のセグメント（ルート）は残す
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+	return strings.Join(out, "/")
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,199 @@
+// url_normalize_test.go - キャッシュキーを作る前のURLの正規化のテスト
+package model
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tracking := []string{"utm_*", "fbclid", "gclid"}
+
+	tests := []struct {
+		name  string
+		raw   string
+		strip []string
+		want  string
+	}{
+		// スキームとホスト
+		{"already canonical", "http://example.com/path", nil, "http://example.com/path"},
+		{"uppercase host", "http://Example.COM/path", nil, "http://example.com/path"},
+		{"uppercase scheme", "HTTPS://example.com/path", nil, "https://example.com/path"},
+		{"path case kept", "http://example.com/Path/To", nil, "http://example.com/Path/To"},
+		{"userinfo kept", "http://User:pw@Example.com/", nil, "http://User:pw@example.com/"},
+
+		// ポート
+		{"http default port", "http://example.com:80/path", nil, "http://example.com/path"},
+		{"https default port", "https://example.com:443/path", nil, "https://example.com/path"},
+		{"http port on https", "https://example.com:80/path", nil, "https://example.com:80/path"},
+		{"https port on http", "http://example.com:443/path", nil, "http://example.com:443/path"},
+		{"other port", "http://Example.com:8080/path", nil, "http://example.com:8080/path"},
+		{"empty port", "http://example.com:/path", nil, "http://example.com/path"},
+		{"ipv4", "http://192.168.0.1:80/", nil, "http://192.168.0.1/"},
+		{"ipv6 default port", "http://[2001:DB8::1]:80/", nil, "http://[2001:db8::1]/"},
+		{"ipv6 other port", "http://[2001:db8::1]:8080/", nil, "http://[2001:db8::1]:8080/"},
+		{"ipv6 without port", "https://[::1]/a", nil, "https://[::1]/a"},
+
+		// パス
+		{"empty path", "http://example.com", nil, "http://example.com/"},
+		{"empty path with query", "http://example.com?a=1", nil, "http://example.com/?a=1"},
+		{"trailing slash kept", "http://example.com/path/", nil, "http://example.com/path/"},
+		{"double slash kept", "http://example.com//a//b", nil, "http://example.com//a//b"},
+		{"dot segment", "http://example.com/a/./b", nil, "http://example.com/a/b"},
+		{"dot-dot segment", "http://example.com/a/b/../c", nil, "http://example.com/a/c"},
+		{"several dot-dots", "http://example.com/a/b/c/../../d", nil, "http://example.com/a/d"},
+		{"trailing dot", "http://example.com/a/.", nil, "http://example.com/a/"},
+		{"trailing dot-dot", "http://example.com/a/b/..", nil, "http://example.com/a/"},
+		{"dot-dot above root", "http://example.com/../../a", nil, "http://example.com/a"},
+		{"only dot-dot", "http://example.com/..", nil, "http://example.com/"},
+		{"dots in names kept", "http://example.com/a.b/..c/.d", nil, "http://example.com/a.b/..c/.d"},
+		{"encoded dot segment", "http://example.com/a/%2E%2E/b", nil, "http://example.com/b"},
+
+		// パーセントエンコーディング
+		{"unreserved letter", "http://example.com/%7Euser/%61bc", nil, "http://example.com/~user/abc"},
+		{"unreserved lowercase hex", "http://example.com/%7euser", nil, "http://example.com/~user"},
+		{"unreserved symbols", "http://example.com/%2D%2E%5F%7E", nil, "http://example.com/-._~"},
+		{"reserved slash kept", "http://example.com/a%2Fb", nil, "http://example.com/a%2Fb"},
+		{"reserved uppercased", "http://example.com/a%2fb", nil, "http://example.com/a%2Fb"},
+		{"space kept", "http://example.com/a%20b", nil, "http://example.com/a%20b"},
+		{"utf-8 uppercased", "http://example.com/%e3%81%82", nil, "http://example.com/%E3%81%82"},
+		{"unicode path", "http://example.com/あ", nil, "http://example.com/%E3%81%82"},
+		{"query unreserved", "http://example.com/?q=%41%2b", nil, "http://example.com/?q=A%2B"},
+
+		// クエリ
+		{"sorted query", "http://example.com/?b=2&a=1", nil, "http://example.com/?a=1&b=2"},
+		{"sorted by name", "http://example.com/?b=1&a=2&c=0", nil, "http://example.com/?a=2&b=1&c=0"},
+		{"repeated names keep order", "http://example.com/?a=2&b=0&a=1", nil, "http://example.com/?a=2&a=1&b=0"},
+		{"empty query", "http://example.com/path?", nil, "http://example.com/path"},
+		{"empty parameters dropped", "http://example.com/?&a=1&&b=2&", nil, "http://example.com/?a=1&b=2"},
+		{"parameter without value", "http://example.com/?debug&a=1", nil, "http://example.com/?a=1&debug"},
+		{"empty value kept", "http://example.com/?b=&a=", nil, "http://example.com/?a=&b="},
+		{"plus kept", "http://example.com/?q=a+b", nil, "http://example.com/?q=a+b"},
+		{"encoded name sorted by decoded", "http://example.com/?%62=2&a=1", nil, "http://example.com/?a=1&b=2"},
+
+		// トラッキング用のパラメータ
+		{"utm stripped", "http://example.com/?utm_source=x&id=1&utm_medium=y", tracking, "http://example.com/?id=1"},
+		{"fbclid stripped", "http://example.com/page?fbclid=abc", tracking, "http://example.com/page"},
+		{"gclid stripped", "http://example.com/page?gclid=abc&q=1", tracking, "http://example.com/page?q=1"},
+		{"exact match only", "http://example.com/?fbclid_extra=1", tracking, "http://example.com/?fbclid_extra=1"},
+		{"prefix is case sensitive", "http://example.com/?UTM_source=1", tracking, "http://example.com/?UTM_source=1"},
+		{"encoded tracking name", "http://example.com/?utm%5Fsource=x", tracking, "http://example.com/"},
+		{"nothing stripped without list", "http://example.com/?utm_source=x", nil, "http://example.com/?utm_source=x"},
+		{"wildcard strips everything", "http://example.com/?a=1&b=2", []string{"*"}, "http://example.com/"},
+
+		// フラグメント
+		{"fragment dropped", "http://example.com/page#section", nil, "http://example.com/page"},
+		{"fragment after query", "http://example.com/page?b=1&a=2#top", nil, "http://example.com/page?a=2&b=1"},
+
+		// 組み合わせ
+		{"everything", "HTTP://WWW.Example.com:80/a/./b/../%7Ec?utm_campaign=z&b=2&a=1#frag", tracking, "http://www.example.com/a/~c?a=1&b=2"},
+
+		// 正規化できないURLはそのまま
+		{"relative", "/path/../a", nil, "/path/../a"},
+		{"no host", "http:///path", nil, "http:///path"},
+		{"opaque", "mailto:User@Example.com", nil, "mailto:User@Example.com"},
+		{"invalid", "http://exa mple.com/%zz", nil, "http://exa mple.com/%zz"},
+		{"empty", "", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeURL(tt.raw, tt.strip); got != tt.want {
+				t.Errorf("NormalizeURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+			// 正規化したURLをもう一度正規化しても変わらない
+			if got := NormalizeURL(tt.want, tt.strip); got != tt.want {
+				t.Errorf("NormalizeURL is not idempotent for %q: got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNormalizePercentEncoding(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"%41%42%43", "ABC"},
+		{"%3a%2F", "%3A%2F"},
+		{"%", "%"},
+		{"%4", "%4"},
+		{"a%", "a%"},
+		{"%zz", "%zz"},
+		{"%4g%41", "%4gA"},
+		{"100%25", "100%25"},
+		{"%%41", "%A"},
+	}
+	for _, tt := range tests {
+		if got := normalizePercentEncoding(tt.in); got != tt.want {
+			t.Errorf("normalizePercentEncoding(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveDotSegments(t *testing.T) {
+	// RFC 3986 5.2.4・5.4の例
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/a/b/c/./../../g", "/a/g"},
+		{"/b/c/d;p/../../g", "/b/g"},
+		{"/b/c/d;p/./g", "/b/c/d;p/g"},
+		{"/b/c/d;p/g/", "/b/c/d;p/g/"},
+		{"/b/c/d;p/..", "/b/c/"},
+		{"/b/c/d;p/../..", "/b/"},
+		{"/b/c/d;p/../../..", "/"},
+		{"/b/c/d;p/g.", "/b/c/d;p/g."},
+		{"/b/c/d;p/.g", "/b/c/d;p/.g"},
+		{"/b/c/d;p/g..", "/b/c/d;p/g.."},
+		{"/b/c/d;p/./../g", "/b/c/g"},
+		{"/b/c/d;p/./g/.", "/b/c/d;p/g/"},
+		{"/b/c/d;p/g/./h", "/b/c/d;p/g/h"},
+		{"/b/c/d;p/g;x=1/../y", "/b/c/d;p/y"},
+		{"/", "/"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := removeDotSegments(tt.in); got != tt.want {
+			t.Errorf("removeDotSegments(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCacheKeyUsesNormalizedURL(t *testing.T) {
+	saved := StripQueryParams
+	StripQueryParams = []string{"utm_*", "fbclid"}
+	defer func() { StripQueryParams = saved }()
+
+	key := func(rawURL string, header http.Header) string {
+		return (&BpRequest{Method: http.MethodGet, URL: rawURL, Headers: header}).GenerateCacheKey()
+	}
+	base := key("http://example.com/path?a=1&b=2", nil)
+	for _, rawURL := range []string{
+		"http://Example.com:80/path?b=2&a=1",
+		"HTTP://example.com/./path?a=1&b=2#top",
+		"http://example.com/%70ath?a=1&b=2&utm_source=news&fbclid=x",
+	} {
+		if got := key(rawURL, nil); got != base {
+			t.Errorf("Expected %s to share the cache key of http://example.com/path?a=1&b=2", rawURL)
+		}
+	}
+
+	// 別のリソースを指すURLやヘッダーの違いは別のキーのまま
+	for _, rawURL := range []string{
+		"http://example.com/path/?a=1&b=2",
+		"https://example.com/path?a=1&b=2",
+		"http://example.com:8080/path?a=1&b=2",
+		"http://example.com/Path?a=1&b=2",
+		"http://example.com/path?a=1&b=3",
+	} {
+		if got := key(rawURL, nil); got == base {
+			t.Errorf("Expected %s to have its own cache key", rawURL)
+		}
+	}
+	if key("http://example.com/path?a=1&b=2", http.Header{"Accept-Language": {"ja"}}) == base {
+		t.Error("Expected Accept-Language to still change the cache key")
+	}
+}
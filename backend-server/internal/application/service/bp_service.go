@@ -2,14 +2,18 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/utils"
 )
 
@@ -18,6 +22,15 @@ type BpService struct {
 	bprepository    repository.BpRepository
 	defaultDir      string
 	defaultFileName string
+	// streamThreshold この大きさ（バイト）以上のキャッシュはメモリに読み込まずにファイルから返す（0以下はすべてメモリに読み込む）
+	streamThreshold int64
+	// deliveryEstimate 予約したリクエストのレスポンスが届く時刻の目安の計算（プレースホルダー・JSONの状態に表示する）
+	deliveryEstimate model.DeliveryEstimate
+	// fetchImages 画像のキャッシュミスもDTNへ予約するか（falseの場合はプレースホルダーのSVGを返すだけにする）
+	fetchImages bool
+	metrics     *metrics.Metrics
+	// postProcessors クライアントへ返す前にレスポンスを加工する処理（AddPostProcessorで登録した順）
+	postProcessors []postProcessor
 }
 
 func NewBpService(
@@ -25,72 +38,140 @@ func NewBpService(
 	bprepository repository.BpRepository,
 	defaultDir string,
 	defaultFileName string,
+	streamThreshold int64,
+	deliveryEstimate model.DeliveryEstimate,
+	fetchImages bool,
+	metrics *metrics.Metrics,
 ) *BpService {
 	return &BpService{
-		bpgateway:       bpgateway,
-		bprepository:    bprepository,
-		defaultDir:      defaultDir,
-		defaultFileName: defaultFileName,
+		bpgateway:        bpgateway,
+		bprepository:     bprepository,
+		defaultDir:       defaultDir,
+		defaultFileName:  defaultFileName,
+		streamThreshold:  streamThreshold,
+		deliveryEstimate: deliveryEstimate,
+		fetchImages:      fetchImages,
+		metrics:          metrics,
 	}
 }
 
 // ProxyRequest HTTPリクエストを転送する（キャッシュ可能な場合はキャッシュもチェック）
 func (bs *BpService) ProxyRequest(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, error) {
+	resp, _, err := bs.ProxyRequestWithStatus(ctx, breq)
+	return resp, err
+}
+
+// ProxyRequestWithStatus ProxyRequestと同じくリクエストを転送し、レスポンスがどのように用意されたかも返す
+func (bs *BpService) ProxyRequestWithStatus(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
+	resp, status, err := bs.proxyRequestWithStatus(ctx, breq)
+	bs.metrics.IncCacheResult(string(status))
+	if err != nil {
+		return resp, status, err
+	}
+	return bs.postProcess(ctx, breq, resp), status, nil
+}
+
+func (bs *BpService) proxyRequestWithStatus(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
 	// キャッシュ不可の場合は直接転送
 	if !breq.IsCacheable() {
-		log.Printf("[BpService] リクエストはキャッシュ不可: Method=%s, URL=%s", breq.Method, breq.URL)
-		return bs.bpgateway.ProxyRequest(ctx, breq)
+		log.Printf("[BpService] リクエストはキャッシュ不可: Method=%s, URL=%s, RequestID=%s", breq.Method, breq.URL, breq.RequestID)
+		return bs.proxyDirect(ctx, breq)
 	}
 
-	log.Printf("[BpService] リクエストはキャッシュ可能: URL=%s", breq.URL)
+	// キャッシュに関するログには正規化したURL（キャッシュキーの元）を出す
+	canonicalURL := breq.NormalizedURL()
+	log.Printf("[BpService] リクエストはキャッシュ可能: URL=%s, RequestID=%s", canonicalURL, breq.RequestID)
 
 	// キャッシュ可能な場合はキャッシュから取得
 	cacheKey := breq.GenerateCacheKey()
-	cachedResp, found, err := bs.bprepository.GetResponse(ctx, cacheKey)
+	cachedResp, found, err := bs.getCachedResponse(ctx, cacheKey)
 	// found == false の場合はキャッシュミス（エラーではない）
 	if err != nil {
-		log.Printf("[BpService] キャッシュ取得エラー: %v", err)
+		log.Printf("[BpService] キャッシュ取得エラー (RequestID=%s): %v", breq.RequestID, err)
 		// キャッシュ取得エラー: Gateway層で直接転送
-		return bs.bpgateway.ProxyRequest(ctx, breq)
+		return bs.proxyDirect(ctx, breq)
+	}
+
+	if found && cachedResp.Negative {
+		// ネガティブキャッシュ（404などのエラーレスポンス）の間は予約し直さない
+		// クライアントが強制的に再読み込みした（Cache-Control: no-cache）場合だけ無視して予約し直す
+		if !breq.WantsRefresh() {
+			log.Printf("[BpService] ネガティブキャッシュを返します: URL=%s, Status=%d, RequestID=%s", canonicalURL, cachedResp.StatusCode, breq.RequestID)
+			return cachedResp, model.CacheNegative, nil
+		}
+		log.Printf("[BpService] クライアントの要求によりネガティブキャッシュを使わずに予約し直します: URL=%s, RequestID=%s", canonicalURL, breq.RequestID)
+		cachedResp.Close()
+		found = false
 	}
 
 	if found {
-		log.Printf("[BpService] キャッシュヒット: URL=%s", breq.URL)
+		// 有効期限を過ぎても保持しているキャッシュは、プレースホルダーの代わりにそのまま返し、裏で更新を予約する
+		if cachedResp.IsStale() {
+			log.Printf("[BpService] 期限切れのキャッシュを返します: URL=%s, RequestID=%s", canonicalURL, breq.RequestID)
+			bs.revalidate(ctx, breq)
+			return cachedResp, model.CacheStale, nil
+		}
+		// クライアントが強制的に再読み込みした（Cache-Control: no-cache・max-age=0、Pragma: no-cache）場合は、
+		// その場で取得し直せないためキャッシュを返し、裏で更新を予約する
+		if breq.WantsRefresh() {
+			log.Printf("[BpService] クライアントの要求によりキャッシュを返しながら更新を予約します: URL=%s, RequestID=%s", canonicalURL, breq.RequestID)
+			bs.revalidate(ctx, breq)
+			return cachedResp, model.CacheRevalidating, nil
+		}
+		log.Printf("[BpService] キャッシュヒット: URL=%s, RequestID=%s", canonicalURL, breq.RequestID)
 		// キャッシュヒット: キャッシュされたレスポンスを返す
-		return cachedResp, nil
+		return cachedResp, model.CacheHit, nil
 	}
 
-	log.Printf("[BpService] キャッシュミス: URL=%s, リクエストを予約します", breq.URL)
-
-	// リクエストの種類に応じたプレースホルダーを取得
-	placeholderBody, contentType, err := utils.GetPlaceholderContent(breq.URL, bs.defaultDir)
+	log.Printf("[BpService] キャッシュミス: URL=%s, RequestID=%s, リクエストを予約します", canonicalURL, breq.RequestID)
 
-	// 画像または特定のドメインの場合は予約しない
-	// isImage := strings.HasPrefix(contentType, "image/")
+	// 画像（fetchImagesがfalseの場合）または特定のドメインの場合は予約しない
+	isImage := strings.HasPrefix(utils.InferContentType(breq.URL), "image/")
 	isIgnoredDomain := strings.Contains(breq.URL, "firefox.com") || strings.Contains(breq.URL, "mozilla.com")
 
-	if isIgnoredDomain {
-		log.Printf("[BpService] 画像または除外ドメインのリクエストのため予約をスキップします: URL=%s", breq.URL)
+	status := model.CacheMissPlaceholder
+	var reservation model.Reservation
+	if (isImage && !bs.fetchImages) || isIgnoredDomain {
+		log.Printf("[BpService] 画像または除外ドメインのリクエストのため予約をスキップします: URL=%s, RequestID=%s", canonicalURL, breq.RequestID)
 	} else {
 		// キャッシュミス: Worker Poolにリクエストを予約してデフォルトページを返す
 		if bs.bprepository != nil {
-			err := bs.bprepository.ReserveRequest(ctx, breq)
+			reserved, err := bs.bprepository.ReserveRequest(ctx, breq)
 			if err != nil {
-				log.Printf("[BpService] ReserveRequest エラー: %v", err)
+				log.Printf("[BpService] ReserveRequest エラー (RequestID=%s): %v", breq.RequestID, err)
 			} else {
-				log.Printf("[BpService] ReserveRequest 成功: URL=%s", breq.URL)
+				if reserved.Queued {
+					log.Printf("[BpService] ReserveRequest 成功: URL=%s, Position=%d/%d, RequestID=%s", canonicalURL, reserved.Position, reserved.QueueLength, breq.RequestID)
+				} else {
+					// 同じページが予約済みのため、新しくバンドルは送らずにその予約を待つ
+					log.Printf("[BpService] 既に予約されています（%s から）: URL=%s, Position=%d/%d, RequestID=%s", reserved.ReservedAt.Format(time.RFC3339), canonicalURL, reserved.Position, reserved.QueueLength, breq.RequestID)
+				}
+				status = model.CacheMissReserved
+				reservation = reserved
 			}
 		}
 	}
 
+	// リクエストの種類に応じたプレースホルダーを取得（画像は予約したかを文言に表示する）
+	placeholderBody, contentType, err := utils.GetPlaceholderContent(breq.URL, bs.defaultDir, status == model.CacheMissReserved)
 	if err == nil && placeholderBody != nil {
-		return &model.BpResponse{
+		// ハンドラーはHeadersだけをクライアントに渡すため、Content-Typeもヘッダーに入れる（SVGを画像として表示させる）
+		return bs.withReservation(&model.BpResponse{
 			StatusCode:    200,
-			Headers:       make(map[string][]string),
+			Headers:       map[string][]string{"Content-Type": {contentType}},
 			Body:          placeholderBody,
 			ContentType:   contentType,
 			ContentLength: int64(len(placeholderBody)),
-		}, nil
+		}, status, reservation), status, nil
+	}
+
+	// APIのクライアント（Acceptでapplication/jsonを好む、またはJSONのURL）にはHTMLのデフォルトページの代わりにJSONの状態を返す
+	if wantsJSONStatus(breq) {
+		resp, err := newJSONStatusResponse(breq, status, reservation, bs.estimatedAt(reservation))
+		if err != nil {
+			return nil, status, fmt.Errorf("failed to encode queued status: %w", err)
+		}
+		return resp, status, nil
 	}
 
 	// プレースホルダーが生成されなかった場合（HTMLなど）はデフォルトページを読み込む
@@ -99,22 +180,89 @@ func (bs *BpService) ProxyRequest(ctx context.Context, breq *model.BpRequest) (*
 	if err != nil {
 		// デフォルトページの読み込みに失敗した場合は503 Service Unavailableを返す
 		// DTN環境では直接転送は期待できないため、フォールバックとしてエラーを返す
-		log.Printf("[BpService] Failed to load default page: %v", err)
+		log.Printf("[BpService] Failed to load default page (RequestID=%s): %v", breq.RequestID, err)
 		body := []byte("503 Service Unavailable: Failed to load default page and direct proxy is unavailable in DTN environment.")
-		return &model.BpResponse{
+		return bs.withReservation(&model.BpResponse{
 			StatusCode:    http.StatusServiceUnavailable,
 			Headers:       make(map[string][]string),
 			Body:          body,
 			ContentType:   "text/plain; charset=utf-8",
 			ContentLength: int64(len(body)),
-		}, nil
+		}, status, reservation), status, nil
 	}
 
-	return &model.BpResponse{
+	return bs.withReservation(&model.BpResponse{
 		StatusCode:    200,
 		Headers:       breq.Headers,
 		Body:          htmlBytes,
 		ContentType:   "text/html; charset=utf-8",
 		ContentLength: int64(len(htmlBytes)),
-	}, nil
+	}, status, reservation), status, nil
+}
+
+// withReservation DTNへ予約した場合に、予約の時刻・予約キューでの位置・レスポンスが届く時刻の目安をレスポンスに加える
+func (bs *BpService) withReservation(resp *model.BpResponse, status model.CacheStatus, reservation model.Reservation) *model.BpResponse {
+	if status != model.CacheMissReserved {
+		return resp
+	}
+	resp.ReservedAt = reservation.ReservedAt
+	resp.QueuePosition = reservation.Position
+	resp.QueueLength = reservation.QueueLength
+	resp.EstimatedAt = bs.estimatedAt(reservation)
+	return resp
+}
+
+// estimatedAt 予約したリクエストのレスポンスが届く時刻の目安（分からない場合はゼロ）
+func (bs *BpService) estimatedAt(reservation model.Reservation) time.Time {
+	return bs.deliveryEstimate.EstimatedAt(time.Now(), reservation.ReservedAt, reservation.Position)
+}
+
+// revalidate 期限切れ（またはクライアントが取得し直しを求めた）のキャッシュを更新するため、キャッシュミスと同じくWorker Poolにリクエストを予約する
+// 同じページの更新を予約済み（処理中）の場合はキューに追加されないため、期限切れのキャッシュへのアクセスが続いても予約は積み重ならない
+// クライアントにはキャッシュを返していて待っていないため、ブラウザからの予約より低い再検証の優先度で予約する
+// 予約に失敗しても期限切れのキャッシュは返せるため、ログに残すだけにする
+func (bs *BpService) revalidate(ctx context.Context, breq *model.BpRequest) {
+	revalidation := *breq
+	revalidation.Revalidation = true
+	reservation, err := bs.bprepository.ReserveRequest(ctx, &revalidation)
+	if err != nil {
+		log.Printf("[BpService] 更新の予約に失敗しました (RequestID=%s): %v", breq.RequestID, err)
+		return
+	}
+	if !reservation.Queued {
+		log.Printf("[BpService] 更新は予約済みです（%s から）: URL=%s, RequestID=%s", reservation.ReservedAt.Format(time.RFC3339), breq.URL, breq.RequestID)
+		return
+	}
+	log.Printf("[BpService] キャッシュの更新を予約しました: URL=%s, RequestID=%s", breq.URL, breq.RequestID)
+}
+
+// getCachedResponse キャッシュからレスポンスを取得する
+// ボディがstreamThreshold以上のキャッシュはファイルを開いたまま返し（BodyStream）、それより小さいものはメモリに読み込む
+func (bs *BpService) getCachedResponse(ctx context.Context, cacheKey string) (*model.BpResponse, bool, error) {
+	if bs.streamThreshold <= 0 {
+		return bs.bprepository.GetResponse(ctx, cacheKey)
+	}
+	resp, found, err := bs.bprepository.GetResponseStream(ctx, cacheKey)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if resp.ContentLength >= bs.streamThreshold {
+		return resp, true, nil
+	}
+
+	defer resp.BodyStream.Close()
+	body, err := io.ReadAll(resp.BodyStream)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache file: %w", err)
+	}
+	resp.Body = body
+	resp.BodyStream = nil
+	return resp, true, nil
+}
+
+// proxyDirect キャッシュを使わずにGateway層で転送する（ブラウザが待っているため優先度highで送る）
+// 失敗した理由（gateway.ErrLinkDownなど）をハンドラーが見分けられるよう、Gateway層のエラーはそのまま返す
+func (bs *BpService) proxyDirect(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
+	resp, err := bs.bpgateway.ProxyRequest(ctx, breq, gateway.ProxyOptions{Priority: gateway.PriorityHigh})
+	return resp, model.CacheMissDirect, err
 }
@@ -0,0 +1,151 @@
+// bp_service_test.go - キャッシュの確認・予約・直接転送の振り分け（ProxyRequest）のテスト（メモリ上のリポジトリとゲートウェイを使う）
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+)
+
+const testDefaultPage = "<html><body>queued</body></html>"
+
+func newTestService(t *testing.T, repo *fakes.Repository, gw *fakes.Gateway) *BpService {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(testDefaultPage), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return NewBpService(gw, repo, dir, "index.html", 0, model.DeliveryEstimate{}, true, nil)
+}
+
+func proxy(t *testing.T, svc *BpService, req *model.BpRequest) (*model.BpResponse, model.CacheStatus) {
+	t.Helper()
+	resp, status, err := svc.ProxyRequestWithStatus(context.Background(), req)
+	if err != nil {
+		t.Fatalf("ProxyRequestWithStatus failed: %v", err)
+	}
+	return resp, status
+}
+
+func TestProxyRequestMissThenHit(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	svc := newTestService(t, repo, gw)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page"}
+
+	resp, status := proxy(t, svc, req)
+	if status != model.CacheMissReserved || string(resp.Body) != testDefaultPage {
+		t.Fatalf("Expected the default page with miss-reserved, got %s %q", status, resp.Body)
+	}
+	if resp.QueuePosition != 1 || resp.QueueLength != 1 || resp.ReservedAt.IsZero() {
+		t.Errorf("Expected the reservation to be reported, got position=%d/%d reservedAt=%v", resp.QueuePosition, resp.QueueLength, resp.ReservedAt)
+	}
+
+	// 同じページへのアクセスは同じ予約を待つ
+	if _, status := proxy(t, svc, req); status != model.CacheMissReserved {
+		t.Errorf("Expected miss-reserved again, got %s", status)
+	}
+	if queue, _ := repo.GetReservedRequests(context.Background()); len(queue) != 1 {
+		t.Errorf("Expected a single reservation, got %d", len(queue))
+	}
+	if len(gw.Requests()) != 0 {
+		t.Errorf("Expected no request to be sent over the gateway, got %d", len(gw.Requests()))
+	}
+
+	stored := &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("page"), ContentType: "text/html", ContentLength: 4}
+	if err := repo.SetResponseWithURL(context.Background(), req, stored, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	resp, status = proxy(t, svc, req)
+	if status != model.CacheHit || string(resp.Body) != "page" {
+		t.Errorf("Expected a cache hit, got %s %q", status, resp.Body)
+	}
+}
+
+func TestProxyRequestDirect(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.RespondBody("https://example.com/form", "text/plain", "posted")
+	svc := newTestService(t, repo, gw)
+
+	resp, status := proxy(t, svc, &model.BpRequest{Method: http.MethodPost, URL: "https://example.com/form", Body: []byte("a=1")})
+	if status != model.CacheMissDirect || string(resp.Body) != "posted" {
+		t.Fatalf("Expected the gateway response, got %s %q", status, resp.Body)
+	}
+	requests := gw.Requests()
+	if len(requests) != 1 || requests[0].Method != http.MethodPost || string(requests[0].Body) != "a=1" {
+		t.Errorf("Expected the POST to be forwarded, got %+v", requests)
+	}
+	if queue, _ := repo.GetReservedRequests(context.Background()); len(queue) != 0 {
+		t.Errorf("Expected no reservation, got %d", len(queue))
+	}
+}
+
+func TestProxyRequestGatewayError(t *testing.T) {
+	gw := fakes.NewGateway()
+	gw.Fail("https://example.com/form", fmt.Errorf("%w: no response", gateway.ErrTimeout))
+	svc := newTestService(t, fakes.NewRepository(0), gw)
+
+	_, status, err := svc.ProxyRequestWithStatus(context.Background(), &model.BpRequest{Method: http.MethodPost, URL: "https://example.com/form"})
+	if !errors.Is(err, gateway.ErrTimeout) || status != model.CacheMissDirect {
+		t.Errorf("Expected the gateway error to be returned unchanged, got %s %v", status, err)
+	}
+
+	gw.SetLinkDown(errors.New("ion is not running"))
+	if _, _, err := svc.ProxyRequestWithStatus(context.Background(), &model.BpRequest{Method: http.MethodPost, URL: "https://example.com/other"}); !errors.Is(err, gateway.ErrLinkDown) {
+		t.Errorf("Expected ErrLinkDown, got %v", err)
+	}
+}
+
+func TestProxyRequestStaleRevalidates(t *testing.T) {
+	repo := fakes.NewRepository(time.Hour)
+	svc := newTestService(t, repo, fakes.NewGateway())
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/news"}
+	// 有効期限を1分過ぎたキャッシュ
+	if err := repo.SetResponseWithURL(context.Background(), req, &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("old")}, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, status := proxy(t, svc, req)
+	if status != model.CacheStale || string(resp.Body) != "old" {
+		t.Fatalf("Expected the stale cache, got %s %q", status, resp.Body)
+	}
+	if !repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected a refresh to be reserved")
+	}
+	// 更新の予約はブラウザからの予約より低い再検証の優先度になる
+	if queue, _ := repo.GetReservedRequests(context.Background()); len(queue) != 1 || queue[0].Priority() != model.PriorityRevalidation {
+		t.Errorf("Expected a revalidation reservation, got %+v", queue)
+	}
+}
+
+func TestProxyRequestNegativeCache(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	svc := newTestService(t, repo, fakes.NewGateway())
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/missing"}
+	if err := repo.SetResponseWithURL(context.Background(), req, &model.BpResponse{StatusCode: http.StatusNotFound, Negative: true}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, status := proxy(t, svc, req)
+	if status != model.CacheNegative || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected the negative cache, got %s %d", status, resp.StatusCode)
+	}
+	if repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected no reservation while the negative cache is fresh")
+	}
+
+	// クライアントが再読み込みを求めた場合は予約し直す
+	refresh := *req
+	refresh.Headers = map[string][]string{"Cache-Control": {"no-cache"}}
+	if _, status := proxy(t, svc, &refresh); status != model.CacheMissReserved {
+		t.Errorf("Expected miss-reserved on refresh, got %s", status)
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// proxyURLParamPath ?url=の形式でプロキシを通すときのパス（handlers.proxyTargetURL）
+const proxyURLParamPath = "/?url="
+
+// NewLinkRewriter HTMLのページと同じオリジンへのリンクを、プロキシを通る?url=の形式に書き換える処理
+// プロキシの設定なしで?url=の形式で開いたページ（BpRequest.ViaURLParam）だけを書き換え、
+// 書き換えないとページ内のリンクをたどったときにプロキシを通らず、DTNの先のサーバーへ直接アクセスしてしまう
+// 他のオリジンへのリンクとhttp・https以外のリンク（mailto:など）は書き換えない
+func NewLinkRewriter() ResponsePostProcessor {
+	return ResponsePostProcessorFunc(func(_ context.Context, breq *model.BpRequest, resp *model.BpResponse) error {
+		if !breq.ViaURLParam {
+			return nil
+		}
+		page, err := url.Parse(breq.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body = model.RewriteHTMLLinks(breq.URL, resp.Body, func(target *url.URL) (string, bool) {
+			if !sameOrigin(page, target) {
+				return "", false
+			}
+			link := *target
+			link.Fragment, link.RawFragment = "", ""
+			rewritten := proxyURLParamPath + url.QueryEscape(link.String())
+			if target.Fragment != "" {
+				rewritten += "#" + target.EscapedFragment()
+			}
+			return rewritten, true
+		})
+		return nil
+	})
+}
+
+// sameOrigin 2つのURLのオリジン（スキーム・ホスト・ポート）が同じか（ポートを省略した場合はスキームの既定のポート）
+func sameOrigin(a, b *url.URL) bool {
+	if !isHTTPScheme(a.Scheme) || !strings.EqualFold(a.Scheme, b.Scheme) {
+		return false
+	}
+	return strings.EqualFold(a.Hostname(), b.Hostname()) && originPort(a) == originPort(b)
+}
+
+func isHTTPScheme(scheme string) bool {
+	return strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https")
+}
+
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return "443"
+	}
+	return "80"
+}
+
+// bodyTagPattern HTMLの<body>の開始タグ
+var bodyTagPattern = regexp.MustCompile(`(?i)<body\b[^>]*>`)
+
+// NewBannerInjector HTMLの<body>の開始タグの直後にbannerを挿入する処理（DTN経由のページであることを示すバナーなど）
+// <body>がないHTMLは先頭に挿入する。bannerが空の場合は何もしない
+func NewBannerInjector(banner string) ResponsePostProcessor {
+	return ResponsePostProcessorFunc(func(_ context.Context, _ *model.BpRequest, resp *model.BpResponse) error {
+		if banner == "" {
+			return nil
+		}
+		loc := bodyTagPattern.FindIndex(resp.Body)
+		if loc == nil {
+			resp.Body = append([]byte(banner), resp.Body...)
+			return nil
+		}
+		var body bytes.Buffer
+		body.Grow(len(resp.Body) + len(banner))
+		body.Write(resp.Body[:loc[1]])
+		body.WriteString(banner)
+		body.Write(resp.Body[loc[1]:])
+		resp.Body = body.Bytes()
+		return nil
+	})
+}
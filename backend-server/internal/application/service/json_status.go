@@ -0,0 +1,93 @@
+package service
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/utils"
+)
+
+// statusPollPath ページの準備ができたかを問い合わせるエンドポイント（handlers.statusHandler.GetStatus）
+const statusPollPath = "/system/status"
+
+// JSONの状態のstatus
+const (
+	jsonStatusQueued      = "queued"
+	jsonStatusUnavailable = "unavailable"
+)
+
+// QueuedStatus キャッシュミスのときにAPIのクライアントへ返すJSONの状態
+// HTMLのプレースホルダーを200で返すと、JSONとして解析するクライアントが壊れるため、代わりに202で返す
+type QueuedStatus struct {
+	// Status "queued"（DTNへ予約した）、"unavailable"（予約しなかった、除外ドメイン・予約の失敗）
+	Status string `json:"status"`
+
+	URL string `json:"url"`
+
+	// QueuedAt 予約した時刻（既に予約されていた場合はその予約の時刻、queuedの場合のみ）
+	QueuedAt *time.Time `json:"queued_at,omitempty"`
+
+	// Position・QueueLength 予約キューでの位置（1から、0は処理中）とキューの長さ（queuedで分かる場合のみ）
+	Position    int `json:"position,omitempty"`
+	QueueLength int `json:"queue_length,omitempty"`
+
+	// EstimatedAt レスポンスが届く時刻の目安（queuedで分かる場合のみ）
+	EstimatedAt *time.Time `json:"estimated_at,omitempty"`
+
+	// Poll キャッシュされたかを問い合わせるURL（元のリクエストと同じAccept・Accept-Languageを付けて問い合わせる）
+	Poll string `json:"poll"`
+}
+
+// wantsJSONStatus キャッシュミスのときに、HTMLのデフォルトページの代わりにJSONの状態を返すか
+// Acceptでapplication/jsonをtext/htmlより好む場合と、どちらも同じ程度（Acceptがない、*/*など）で転送先のURLがJSONと推定される場合
+func wantsJSONStatus(breq *model.BpRequest) bool {
+	offers := []string{"text/html", "application/json"}
+	if mediaType, _, err := mime.ParseMediaType(utils.InferContentType(breq.URL)); err == nil && mediaType == "application/json" {
+		offers = []string{"application/json", "text/html"}
+	}
+	return breq.PreferredType(offers...) == "application/json"
+}
+
+// newJSONStatusResponse キャッシュミスのときに返すJSONの状態のレスポンス
+// 予約した場合は202 Accepted（予約キューでの位置とestimatedAtがあれば届く時刻の目安も含める）、予約しなかった場合は503 Service Unavailable
+func newJSONStatusResponse(breq *model.BpRequest, status model.CacheStatus, reservation model.Reservation, estimatedAt time.Time) (*model.BpResponse, error) {
+	queued := QueuedStatus{
+		Status: jsonStatusUnavailable,
+		URL:    breq.URL,
+		Poll:   statusPollPath + "?url=" + url.QueryEscape(breq.URL),
+	}
+	code := http.StatusServiceUnavailable
+	reservedAt := reservation.ReservedAt
+	if status == model.CacheMissReserved {
+		queued.Status = jsonStatusQueued
+		code = http.StatusAccepted
+		if reservedAt.IsZero() {
+			reservedAt = time.Now()
+		}
+		queued.QueuedAt = &reservedAt
+		queued.Position = reservation.Position
+		queued.QueueLength = reservation.QueueLength
+		if !estimatedAt.IsZero() {
+			queued.EstimatedAt = &estimatedAt
+		}
+	}
+
+	body, err := json.Marshal(queued)
+	if err != nil {
+		return nil, err
+	}
+	// ハンドラーはHeadersだけをクライアントに渡すため、Content-Typeもヘッダーに入れる
+	const contentType = "application/json; charset=utf-8"
+	return &model.BpResponse{
+		StatusCode:    code,
+		Headers:       map[string][]string{"Content-Type": {contentType}},
+		Body:          body,
+		ContentType:   contentType,
+		ContentLength: int64(len(body)),
+		ReservedAt:    reservedAt,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// ResponsePostProcessor クライアントへ返す前のレスポンスを加工する処理（リンクの書き換え、バナーの挿入など）
+// キャッシュヒット・プレースホルダー・直接転送のどのレスポンスにも適用する。キャッシュに保存した内容は変えない
+// respはこの処理のためのコピーで、エラーを返した場合（panicした場合も）は加工前のレスポンスを使う
+type ResponsePostProcessor interface {
+	Process(ctx context.Context, breq *model.BpRequest, resp *model.BpResponse) error
+}
+
+// ResponsePostProcessorFunc 関数をResponsePostProcessorとして使う
+type ResponsePostProcessorFunc func(ctx context.Context, breq *model.BpRequest, resp *model.BpResponse) error
+
+func (f ResponsePostProcessorFunc) Process(ctx context.Context, breq *model.BpRequest, resp *model.BpResponse) error {
+	return f(ctx, breq, resp)
+}
+
+// postProcessor 登録した処理と、適用するContent-Type
+type postProcessor struct {
+	processor ResponsePostProcessor
+	// contentTypes 適用するメディアタイプ（"text/html"、"image/"のような前方一致、空はすべて）
+	contentTypes []string
+}
+
+// AddPostProcessor レスポンスを加工する処理を登録する（登録した順に適用する）
+// contentTypesを指定した場合は、Content-Typeがそのいずれかで始まるレスポンスにだけ適用する
+// 起動時に登録する（リクエストの処理中に登録することは想定しない）
+func (bs *BpService) AddPostProcessor(processor ResponsePostProcessor, contentTypes ...string) {
+	bs.postProcessors = append(bs.postProcessors, postProcessor{processor: processor, contentTypes: contentTypes})
+}
+
+// postProcess 登録した処理を順に適用する
+// ファイルから返す大きいキャッシュ（BodyStream）と圧縮されたボディは加工しない
+func (bs *BpService) postProcess(ctx context.Context, breq *model.BpRequest, resp *model.BpResponse) *model.BpResponse {
+	if len(bs.postProcessors) == 0 || resp == nil || resp.BodyStream != nil {
+		return resp
+	}
+	if encoding := http.Header(resp.Headers).Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return resp
+	}
+
+	for i, p := range bs.postProcessors {
+		if !p.matches(resp) {
+			continue
+		}
+		processed := copyResponse(resp)
+		if err := runPostProcessor(ctx, p.processor, breq, processed); err != nil {
+			log.Printf("[BpService] レスポンスの加工に失敗したため加工前のレスポンスを使います (processor=%d, RequestID=%s): %v", i, breq.RequestID, err)
+			continue
+		}
+		if !bytes.Equal(processed.Body, resp.Body) {
+			markBodyModified(processed)
+		}
+		resp = processed
+	}
+	return resp
+}
+
+// matches レスポンスのContent-Typeが適用するメディアタイプに当てはまるか
+func (p postProcessor) matches(resp *model.BpResponse) bool {
+	if len(p.contentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.ResponseContentType())
+	if err != nil {
+		return false
+	}
+	for _, contentType := range p.contentTypes {
+		if strings.HasPrefix(mediaType, strings.ToLower(contentType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPostProcessor 処理を1つ実行する（panicはエラーとして返し、他のリクエストに影響させない）
+func runPostProcessor(ctx context.Context, processor ResponsePostProcessor, breq *model.BpRequest, resp *model.BpResponse) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("post processor panicked: %v", r)
+		}
+	}()
+	return processor.Process(ctx, breq, resp)
+}
+
+// copyResponse 処理に渡すレスポンスのコピー（ヘッダーとボディも複製し、失敗したときに加工前のレスポンスが変わらないようにする）
+func copyResponse(resp *model.BpResponse) *model.BpResponse {
+	copied := *resp
+	copied.Headers = http.Header(resp.Headers).Clone()
+	copied.Body = bytes.Clone(resp.Body)
+	return &copied
+}
+
+// markBodyModified ボディを変えたレスポンスの長さと検証子を合わせる
+// 元のContent-Lengthヘッダーは使わず、強いETagはバイト単位で一致しなくなるため弱いETagにする
+func markBodyModified(resp *model.BpResponse) {
+	resp.ContentLength = int64(len(resp.Body))
+	header := http.Header(resp.Headers)
+	if header == nil {
+		return
+	}
+	header.Del("Content-Length")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
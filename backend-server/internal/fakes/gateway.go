@@ -0,0 +1,163 @@
+package fakes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// HandlerFunc Gatewayがリクエストに返すレスポンスを決める関数
+type HandlerFunc func(ctx context.Context, req *model.BpRequest) (*model.BpResponse, error)
+
+// Gateway 返すレスポンスをURLごとに決められるBpGateway
+// 受け取ったリクエストを記録し、エラーや遅延（DTNの往復時間）も再現できる
+// レスポンスを決めていないURLには404を返す
+type Gateway struct {
+	mu       sync.Mutex
+	handlers map[string]HandlerFunc
+	requests []*model.BpRequest
+	options  []gateway.ProxyOptions
+	latency  time.Duration
+	linkErr  error
+
+	unsolicited chan *model.BpResponse
+}
+
+var (
+	_ gateway.BpGateway     = (*Gateway)(nil)
+	_ gateway.HealthChecker = (*Gateway)(nil)
+)
+
+// NewGateway レスポンスを決めていないGatewayを作る
+func NewGateway() *Gateway {
+	return &Gateway{
+		handlers:    make(map[string]HandlerFunc),
+		unsolicited: make(chan *model.BpResponse, 16),
+	}
+}
+
+// Handle urlへのリクエストをhandlerで処理する
+func (g *Gateway) Handle(url string, handler HandlerFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handlers[url] = handler
+}
+
+// Respond urlへのリクエストにrespのコピーを返す
+func (g *Gateway) Respond(url string, resp *model.BpResponse) {
+	g.Handle(url, func(context.Context, *model.BpRequest) (*model.BpResponse, error) {
+		return copyResponse(resp), nil
+	})
+}
+
+// RespondBody urlへのリクエストにcontentTypeのbodyを200で返す
+func (g *Gateway) RespondBody(url, contentType, body string) {
+	g.Respond(url, &model.BpResponse{
+		StatusCode:    http.StatusOK,
+		Headers:       map[string][]string{"Content-Type": {contentType}},
+		Body:          []byte(body),
+		ContentType:   contentType,
+		ContentLength: int64(len(body)),
+	})
+}
+
+// Fail urlへのリクエストでerrを返す（gateway.ErrSendFailedなどでラップしたもの）
+func (g *Gateway) Fail(url string, err error) {
+	g.Handle(url, func(context.Context, *model.BpRequest) (*model.BpResponse, error) {
+		return nil, err
+	})
+}
+
+// SetLatency レスポンスを返すまでの時間（DTNの往復時間）。待っている間にctxが終わった場合はエラーを返す
+func (g *Gateway) SetLatency(latency time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.latency = latency
+}
+
+// SetLinkDown errがnilでなければ、リンクが使えない状態にする（ProxyRequestとHealthCheckがgateway.ErrLinkDownを返す）
+func (g *Gateway) SetLinkDown(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.linkErr = err
+}
+
+// Requests これまでに受け取ったリクエストのコピー（受け取った順）
+func (g *Gateway) Requests() []*model.BpRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	requests := make([]*model.BpRequest, 0, len(g.requests))
+	for _, req := range g.requests {
+		copied := *req
+		requests = append(requests, &copied)
+	}
+	return requests
+}
+
+// Options これまでに受け取ったリクエストのProxyOptions（Requestsと同じ順）
+func (g *Gateway) Options() []gateway.ProxyOptions {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]gateway.ProxyOptions(nil), g.options...)
+}
+
+// Push リクエストなしで届いたレスポンス（Push受信）としてrespを送る
+func (g *Gateway) Push(resp *model.BpResponse) {
+	g.unsolicited <- resp
+}
+
+func (g *Gateway) ProxyRequest(ctx context.Context, req *model.BpRequest, opts gateway.ProxyOptions) (*model.BpResponse, error) {
+	g.mu.Lock()
+	recorded := *req
+	recorded.Body = bytes.Clone(req.Body)
+	g.requests = append(g.requests, &recorded)
+	g.options = append(g.options, opts)
+	handler, found := g.handlers[req.URL]
+	latency := g.latency
+	linkErr := g.linkErr
+	g.mu.Unlock()
+
+	if linkErr != nil {
+		return nil, fmt.Errorf("%w: %v", gateway.ErrLinkDown, linkErr)
+	}
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+		}
+	}
+	if !found {
+		return &model.BpResponse{StatusCode: http.StatusNotFound, Headers: make(map[string][]string)}, nil
+	}
+	return handler(ctx, req)
+}
+
+func (g *Gateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
+	return g.unsolicited
+}
+
+func (g *Gateway) HealthCheck(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.linkErr != nil {
+		return fmt.Errorf("%w: %v", gateway.ErrLinkDown, g.linkErr)
+	}
+	return nil
+}
+
+// copyResponse 呼び出し側が変更しても、決めたレスポンスが変わらないようにするコピー
+func copyResponse(resp *model.BpResponse) *model.BpResponse {
+	copied := *resp
+	copied.Headers = http.Header(resp.Headers).Clone()
+	copied.Body = bytes.Clone(resp.Body)
+	return &copied
+}
@@ -0,0 +1,607 @@
+// Package fakes Redis・キャッシュディレクトリ・IONなしでService層・Worker・ハンドラーをテストするための、
+// メモリ上のBpRepositoryとBpGatewayの実装
+package fakes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// Repository メモリ上のBpRepository
+// キャッシュはメタデータとボディのバイト列をマップに、予約はWorkerが取り出す順のスライスに持つ
+// 予約キューはRedisの実装（ソート済みセット）と同じく、優先度の高い順、同じ優先度では先に予約したものから取り出す
+// 取り出した予約はRemoveReservedRequest・RequeueReservedRequestまで予約済み（処理中）として残り、同じキャッシュキーは予約し直せない
+// 処理中の予約はRequeueStuckRequestsで、取り出してからの時間によってキューに戻せる
+// RetryReservedRequestで戻した予約は、待つ時間が過ぎた時刻で予約キューに入り、その時刻まで取り出さない
+type Repository struct {
+	mu sync.Mutex
+
+	// staleGrace 有効期限を過ぎたキャッシュを期限切れのまま保持する期間（BpRepositoryのstaleGraceと同じ）
+	staleGrace time.Duration
+
+	caches map[string]*cachedItem
+	// queue Workerが取り出す順の予約（送り直しを待っている予約も、戻す時刻の要素として含む）
+	queue []queuedRequest
+	// reserved キャッシュキーごとの予約（キューにあるものと、取り出されて処理中のもの）
+	reserved map[string]*model.BpRequest
+	// processing 取り出されて処理中の予約（取り出した順）
+	processing []claimedRequest
+	// deadLetters キャッシュキーごとのデッドレター
+	deadLetters map[string]*model.DeadLetter
+	pending     map[string]bool
+	// sent RequestIDごとの送信の記録
+	sent map[string]*model.SentRequest
+	// queued 予約を追加したときに閉じて、BLPopReservedRequestで待っているWorkerを起こす
+	queued chan struct{}
+	// openStreams GetResponseStreamで返して、まだ閉じられていないボディの数
+	openStreams int
+}
+
+// queuedRequest 予約キューの予約と、優先度・時刻（予約した時刻、送り直しを待っている予約は戻す時刻）
+type queuedRequest struct {
+	req      *model.BpRequest
+	priority model.ReservationPriority
+	at       time.Time
+}
+
+// claimedRequest 取り出されて処理中の予約と、取り出す前の優先度・取り出した時刻
+type claimedRequest struct {
+	req       *model.BpRequest
+	priority  model.ReservationPriority
+	claimedAt time.Time
+}
+
+// cachedItem 保存したキャッシュ1件
+type cachedItem struct {
+	metadata model.CacheMetadata
+	body     []byte
+}
+
+var _ repository.BpRepository = (*Repository)(nil)
+
+// NewRepository 空のRepositoryを作る
+// staleGraceは有効期限を過ぎたキャッシュを期限切れのまま返す期間（0以下は期限切れのキャッシュを返さない）
+func NewRepository(staleGrace time.Duration) *Repository {
+	return &Repository{
+		staleGrace:  staleGrace,
+		caches:      make(map[string]*cachedItem),
+		reserved:    make(map[string]*model.BpRequest),
+		deadLetters: make(map[string]*model.DeadLetter),
+		pending:     make(map[string]bool),
+		sent:        make(map[string]*model.SentRequest),
+		queued:      make(chan struct{}),
+	}
+}
+
+func (r *Repository) GetResponse(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, found := r.validItem(key)
+	if !found {
+		return nil, false, nil
+	}
+	resp := item.response()
+	resp.Body = bytes.Clone(item.body)
+	return resp, true, nil
+}
+
+func (r *Repository) GetResponseStream(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, found := r.validItem(key)
+	if !found {
+		return nil, false, nil
+	}
+	resp := item.response()
+	resp.BodyStream = &streamBody{Reader: bytes.NewReader(bytes.Clone(item.body)), repo: r}
+	resp.ContentLength = int64(len(item.body))
+	r.openStreams++
+	return resp, true, nil
+}
+
+// streamBody GetResponseStreamで返すボディ（キャッシュファイルの代わりに、閉じられたことをRepositoryに記録する）
+type streamBody struct {
+	io.Reader
+	repo   *Repository
+	closed sync.Once
+}
+
+func (b *streamBody) Close() error {
+	b.closed.Do(func() {
+		b.repo.mu.Lock()
+		defer b.repo.mu.Unlock()
+		b.repo.openStreams--
+	})
+	return nil
+}
+
+// validItem 有効期限内、または期限切れでも保持している間のキャッシュ（保持する期間も過ぎたものは削除する）
+func (r *Repository) validItem(key string) (*cachedItem, bool) {
+	item, found := r.caches[key]
+	if !found {
+		return nil, false
+	}
+	if item.metadata.IsEvicted(r.staleGrace) {
+		delete(r.caches, key)
+		return nil, false
+	}
+	return item, true
+}
+
+// response ボディ以外のレスポンス（呼び出し側が変更してもキャッシュは変わらない）
+func (item *cachedItem) response() *model.BpResponse {
+	return &model.BpResponse{
+		StatusCode:    item.metadata.StatusCode,
+		Headers:       http.Header(item.metadata.Headers).Clone(),
+		ContentType:   item.metadata.ContentType,
+		ContentLength: item.metadata.ContentLength,
+		CachedAt:      item.metadata.CreatedAt,
+		ExpiresAt:     item.metadata.ExpiresAt,
+		Negative:      item.metadata.Negative,
+	}
+}
+
+func (r *Repository) SetResponseWithURL(ctx context.Context, req *model.BpRequest, response *model.BpResponse, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.caches[req.GenerateCacheKey()] = &cachedItem{
+		metadata: model.CacheMetadata{
+			URL:           req.NormalizedURL(),
+			StatusCode:    response.StatusCode,
+			Headers:       http.Header(response.Headers).Clone(),
+			ContentType:   response.ContentType,
+			ContentLength: response.ContentLength,
+			CreatedAt:     now,
+			ExpiresAt:     now.Add(ttl),
+			Negative:      response.Negative,
+		},
+		body: bytes.Clone(response.Body),
+	}
+	return nil
+}
+
+func (r *Repository) DeleteExpiredCaches(ctx context.Context) (model.CleanupResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := model.CleanupResult{Complete: true}
+	for key, item := range r.caches {
+		result.Scanned++
+		if !item.metadata.IsEvicted(r.staleGrace) {
+			continue
+		}
+		delete(r.caches, key)
+		result.Deleted++
+		if item.metadata.Negative {
+			result.Negative++
+		}
+	}
+	return result, nil
+}
+
+func (r *Repository) DeleteAllCaches(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.caches)
+	return nil
+}
+
+func (r *Repository) ListCacheEntries(ctx context.Context, domain string, offset, limit int) ([]*model.CacheEntry, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var entries []*model.CacheEntry
+	for key := range r.caches {
+		item, found := r.validItem(key)
+		if !found || (domain != "" && model.CacheDomain(item.metadata.URL) != domain) {
+			continue
+		}
+		entries = append(entries, item.entry(key))
+	}
+	slices.SortFunc(entries, func(a, b *model.CacheEntry) int { return b.StoredAt.Compare(a.StoredAt) })
+
+	total := len(entries)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(offset+limit, total)
+	}
+	return entries[offset:end], total, nil
+}
+
+func (r *Repository) GetCacheEntries(ctx context.Context, url string) ([]*model.CacheEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var entries []*model.CacheEntry
+	for _, key := range r.cacheKeysByURL(url) {
+		entries = append(entries, r.caches[key].entry(key))
+	}
+	return entries, nil
+}
+
+func (r *Repository) GetCacheUsage(ctx context.Context) (model.CacheUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var usage model.CacheUsage
+	for _, item := range r.caches {
+		usage.Bytes += int64(len(item.body))
+		usage.Entries++
+	}
+	return usage, nil
+}
+
+// ReconcileCacheUsage キャッシュをメモリに置くため、数え直すものはない
+func (r *Repository) ReconcileCacheUsage(ctx context.Context) (model.CacheReconcileResult, error) {
+	usage, err := r.GetCacheUsage(ctx)
+	return model.CacheReconcileResult{BytesBefore: usage.Bytes, Bytes: usage.Bytes, Entries: usage.Entries}, err
+}
+
+func (r *Repository) PurgeCache(ctx context.Context, url string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := r.cacheKeysByURL(url)
+	for _, key := range keys {
+		delete(r.caches, key)
+	}
+	return len(keys), nil
+}
+
+// cacheKeysByURL URLのキャッシュのキャッシュキー（BpRepositoryと同じく正規化したURLで探す）
+func (r *Repository) cacheKeysByURL(url string) []string {
+	normalized := model.NormalizeURL(url, model.StripQueryParams)
+	var keys []string
+	for key := range r.caches {
+		if item, found := r.validItem(key); found && item.metadata.URL == normalized {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (item *cachedItem) entry(key string) *model.CacheEntry {
+	return &model.CacheEntry{
+		CacheKey:    key,
+		URL:         item.metadata.URL,
+		StatusCode:  item.metadata.StatusCode,
+		ContentType: item.metadata.ContentType,
+		Size:        int64(len(item.body)),
+		StoredAt:    item.metadata.CreatedAt,
+		ExpiresAt:   item.metadata.ExpiresAt,
+		Negative:    item.metadata.Negative,
+	}
+}
+
+func (r *Repository) ReserveRequest(ctx context.Context, req *model.BpRequest) (model.Reservation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := req.GenerateCacheKey()
+	// 事前取得の予約は、デッドレターのあるURLを予約しない（BpRepositoryと同じ）
+	if _, dead := r.deadLetters[key]; dead && req.Prefetch {
+		return model.Reservation{}, nil
+	}
+	return r.reserve(req), nil
+}
+
+// reserve reqを予約キューに追加する（r.muを持って呼ぶ）
+// 既にある予約より高い優先度で予約した場合は、BpRepositoryと同じく予約した時刻のまま優先度を上げる
+func (r *Repository) reserve(req *model.BpRequest) model.Reservation {
+	key := req.GenerateCacheKey()
+	reservation := model.Reservation{}
+	if existing, found := r.reserved[key]; found {
+		reservation.ReservedAt = existing.ReservedAt
+		if i := r.indexOfQueued(key); i >= 0 && r.queue[i].priority > req.Priority() {
+			q := r.queue[i]
+			r.queue = slices.Delete(r.queue, i, i+1)
+			q.priority = req.Priority()
+			r.enqueue(q)
+		}
+	} else {
+		reserved := *req
+		reserved.ReservedAt = time.Now().UTC()
+		r.reserved[key] = &reserved
+		r.enqueue(queuedRequest{req: &reserved, priority: req.Priority(), at: time.Now()})
+		close(r.queued)
+		r.queued = make(chan struct{})
+		reservation = model.Reservation{Queued: true, ReservedAt: reserved.ReservedAt}
+	}
+
+	// 通常の優先度でない予約は待っているクライアントがいないため、位置を調べない（BpRepositoryと同じ）
+	if req.Priority() == model.PriorityInteractive {
+		queue := r.reservedRequests()
+		reservation.Position, _ = model.FindQueuePosition(queue, key)
+		reservation.QueueLength = len(queue)
+	}
+	return reservation
+}
+
+// enqueue qを予約キューの順序（優先度の高い順、同じ優先度では時刻の古い順）の位置に追加する（同じ順序の予約の後に入る）（r.muを持って呼ぶ）
+func (r *Repository) enqueue(q queuedRequest) {
+	i := slices.IndexFunc(r.queue, func(queued queuedRequest) bool {
+		return queued.priority > q.priority || (queued.priority == q.priority && queued.at.After(q.at))
+	})
+	if i < 0 {
+		i = len(r.queue)
+	}
+	r.queue = slices.Insert(r.queue, i, q)
+}
+
+// indexOfQueued 予約キューでcacheKeyの予約の位置（ない場合は-1）（r.muを持って呼ぶ）
+func (r *Repository) indexOfQueued(cacheKey string) int {
+	return slices.IndexFunc(r.queue, func(q queuedRequest) bool { return q.req.GenerateCacheKey() == cacheKey })
+}
+
+func (r *Repository) GetReservedRequests(ctx context.Context) ([]*model.BpRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reservedRequests(), nil
+}
+
+// reservedRequests 予約キューにある予約のコピー（予約キューの順序、送り直しを待っている予約も含む）
+func (r *Repository) reservedRequests() []*model.BpRequest {
+	requests := make([]*model.BpRequest, 0, len(r.queue))
+	for _, q := range r.queue {
+		copied := *q.req
+		requests = append(requests, &copied)
+	}
+	return requests
+}
+
+func (r *Repository) RemoveReservedRequest(ctx context.Context, req *model.BpRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeReserved(req.GenerateCacheKey())
+	return nil
+}
+
+// removeReserved cacheKeyの予約を予約キューと処理中の予約から削除する（r.muを持って呼ぶ）
+func (r *Repository) removeReserved(cacheKey string) {
+	delete(r.reserved, cacheKey)
+	r.removeQueued(cacheKey)
+}
+
+// removeQueued cacheKeyの予約を予約キューと処理中の予約から削除する（予約済みの記録は残す）（r.muを持って呼ぶ）
+func (r *Repository) removeQueued(cacheKey string) {
+	r.queue = slices.DeleteFunc(r.queue, func(q queuedRequest) bool { return q.req.GenerateCacheKey() == cacheKey })
+	r.processing = slices.DeleteFunc(r.processing, func(c claimedRequest) bool { return c.req.GenerateCacheKey() == cacheKey })
+}
+
+// RequeueReservedRequest 予約済みのまま、reqをその優先度の最後（最も後に取り出す位置）に戻す
+func (r *Repository) RequeueReservedRequest(ctx context.Context, req *model.BpRequest) error {
+	return r.RetryReservedRequest(ctx, req, 0)
+}
+
+// RetryReservedRequest 予約済みのまま、delayが過ぎた時刻でreqをその優先度のキューに戻す（0以下はすぐに取り出せる）
+func (r *Repository) RetryReservedRequest(ctx context.Context, req *model.BpRequest, delay time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requeued := *req
+	r.removeQueued(req.GenerateCacheKey())
+	r.reserved[req.GenerateCacheKey()] = &requeued
+	r.enqueue(queuedRequest{req: &requeued, priority: req.Priority(), at: time.Now().Add(max(delay, 0))})
+	close(r.queued)
+	r.queued = make(chan struct{})
+	return nil
+}
+
+// DeadLetterReservedRequest 予約を削除し、lastErrorとともにデッドレターとして残す
+func (r *Repository) DeadLetterReservedRequest(ctx context.Context, req *model.BpRequest, lastError string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := req.GenerateCacheKey()
+	r.removeReserved(key)
+	copied := *req
+	r.deadLetters[key] = &model.DeadLetter{CacheKey: key, Request: &copied, LastError: lastError, FailedAt: time.Now().UTC()}
+	return nil
+}
+
+// ListDeadLetters デッドレターのコピーを新しい順に返す
+func (r *Repository) ListDeadLetters(ctx context.Context) ([]*model.DeadLetter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deadLetters := make([]*model.DeadLetter, 0, len(r.deadLetters))
+	for _, deadLetter := range r.deadLetters {
+		copied := *deadLetter
+		req := *deadLetter.Request
+		copied.Request = &req
+		deadLetters = append(deadLetters, &copied)
+	}
+	slices.SortFunc(deadLetters, func(a, b *model.DeadLetter) int { return b.FailedAt.Compare(a.FailedAt) })
+	return deadLetters, nil
+}
+
+// RequeueDeadLetter デッドレターの予約を、送り直しの回数を0にして予約し直す
+func (r *Repository) RequeueDeadLetter(ctx context.Context, cacheKey string) (model.Reservation, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deadLetter, found := r.deadLetters[cacheKey]
+	if !found {
+		return model.Reservation{}, false, nil
+	}
+	delete(r.deadLetters, cacheKey)
+	req := *deadLetter.Request
+	req.Attempts = 0
+	return r.reserve(&req), true, nil
+}
+
+// BLPopReservedRequest 予約を1つ取り出す。キューが空の場合は予約が追加されるかtimeoutまで待つ（0の場合は無期限）
+// タイムアウトの場合はnil、ctxが終わった場合はctx.Err()を返す
+func (r *Repository) BLPopReservedRequest(ctx context.Context, timeout time.Duration) (*model.BpRequest, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		r.mu.Lock()
+		req, next := r.pop()
+		queued := r.queued
+		r.mu.Unlock()
+		if req != nil {
+			return req, nil
+		}
+		// 送り直しを待っている予約は、戻す時刻に起きて取り出す
+		var ready <-chan time.Time
+		if !next.IsZero() {
+			ready = time.After(time.Until(next))
+		}
+
+		select {
+		case <-queued:
+		case <-ready:
+		case <-expired:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pop 時刻を過ぎた予約のうち予約キューの順序で最初の予約を取り出し、処理中の予約にする
+// 取り出せる予約がない場合は、次に時刻を過ぎる予約の時刻（ない場合はゼロ）を返す
+func (r *Repository) pop() (*model.BpRequest, time.Time) {
+	now := time.Now()
+	var next time.Time
+	for i, q := range r.queue {
+		if q.at.After(now) {
+			if next.IsZero() || q.at.Before(next) {
+				next = q.at
+			}
+			continue
+		}
+		r.queue = slices.Delete(r.queue, i, i+1)
+		r.processing = append(r.processing, claimedRequest{req: q.req, priority: q.priority, claimedAt: now})
+		req := *q.req
+		return &req, time.Time{}
+	}
+	return nil, next
+}
+
+// RequeueStuckRequests 取り出してからstuckAfterより長く処理中の予約を、取り出す前の優先度の最後に戻す
+func (r *Repository) RequeueStuckRequests(ctx context.Context, stuckAfter time.Duration) ([]*model.BpRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	cutoff := now.Add(-stuckAfter)
+	var requeued []*model.BpRequest
+	r.processing = slices.DeleteFunc(r.processing, func(c claimedRequest) bool {
+		if !c.claimedAt.Before(cutoff) {
+			return false
+		}
+		r.enqueue(queuedRequest{req: c.req, priority: c.priority, at: now})
+		copied := *c.req
+		requeued = append(requeued, &copied)
+		return true
+	})
+	if len(requeued) > 0 {
+		close(r.queued)
+		r.queued = make(chan struct{})
+	}
+	return requeued, nil
+}
+
+// ProcessingRequests 取り出されて処理中の予約のコピー（取り出した順）
+func (r *Repository) ProcessingRequests() []*model.BpRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requests := make([]*model.BpRequest, 0, len(r.processing))
+	for _, c := range r.processing {
+		copied := *c.req
+		requests = append(requests, &copied)
+	}
+	return requests
+}
+
+func (r *Repository) JournalSentRequest(ctx context.Context, sent *model.SentRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent[sent.RequestID] = copySentRequest(sent)
+	return nil
+}
+
+func (r *Repository) GetSentRequest(ctx context.Context, requestID string) (*model.SentRequest, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sent, found := r.sent[requestID]
+	if !found {
+		return nil, false, nil
+	}
+	return copySentRequest(sent), true, nil
+}
+
+func (r *Repository) ListSentRequests(ctx context.Context) ([]*model.SentRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sent := make([]*model.SentRequest, 0, len(r.sent))
+	for _, entry := range r.sent {
+		sent = append(sent, copySentRequest(entry))
+	}
+	return sent, nil
+}
+
+func (r *Repository) CompleteSentRequest(ctx context.Context, requestID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sent, requestID)
+	return nil
+}
+
+// copySentRequest 呼び出し側が変更しても記録が変わらないコピー
+func copySentRequest(sent *model.SentRequest) *model.SentRequest {
+	copied := *sent
+	if sent.Request != nil {
+		req := *sent.Request
+		copied.Request = &req
+	}
+	return &copied
+}
+
+func (r *Repository) AddPendingRequest(ctx context.Context, url string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[url] {
+		return false, nil
+	}
+	r.pending[url] = true
+	return true, nil
+}
+
+func (r *Repository) RemovePendingRequest(ctx context.Context, url string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, url)
+	return nil
+}
+
+func (r *Repository) Ping(ctx context.Context) error { return nil }
+
+func (r *Repository) CheckCacheDir(ctx context.Context) error { return nil }
+
+// IsReserved cacheKeyの予約がある（キューにある、または取り出されて処理中）か
+func (r *Repository) IsReserved(cacheKey string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, found := r.reserved[cacheKey]
+	return found
+}
+
+// OpenStreams GetResponseStreamで返したボディのうち、まだ閉じられていないものの数
+func (r *Repository) OpenStreams() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.openStreams
+}
+
+// IsPending urlに送信待ちの印があるか
+func (r *Repository) IsPending(url string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pending[url]
+}
@@ -0,0 +1,121 @@
+// repository_test.go - メモリ上のリポジトリの予約キュー（BLPopの順序・待機・重複の防止）とキャッシュのテスト
+package fakes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+func TestRepositoryQueueOrder(t *testing.T) {
+	repo := NewRepository(0)
+	ctx := context.Background()
+	for _, req := range []*model.BpRequest{
+		{Method: http.MethodGet, URL: "https://example.com/prefetch", Prefetch: true},
+		{Method: http.MethodGet, URL: "https://example.com/a"},
+		{Method: http.MethodGet, URL: "https://example.com/stale", Revalidation: true},
+		{Method: http.MethodGet, URL: "https://example.com/b"},
+	} {
+		if _, err := repo.ReserveRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 同じキャッシュキーは予約済みのためキューに追加しない（位置は優先度の順のキューでの位置）
+	reservation, _ := repo.ReserveRequest(ctx, &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/b"})
+	if reservation.Queued || reservation.Position != 2 || reservation.QueueLength != 4 || reservation.ReservedAt.IsZero() {
+		t.Errorf("Expected the existing reservation at 2/4, got %+v", reservation)
+	}
+	// 事前取得の予約と同じページをブラウザが求めると、予約した時刻のまま通常の優先度に上げる
+	reservation, _ = repo.ReserveRequest(ctx, &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/prefetch"})
+	if reservation.Queued || reservation.Position != 1 || reservation.QueueLength != 4 {
+		t.Errorf("Expected the promoted reservation at 1/4, got %+v", reservation)
+	}
+
+	// Redisの実装と同じく優先度の高い順、同じ優先度では先に予約したものから取り出す
+	for _, want := range []string{"https://example.com/prefetch", "https://example.com/a", "https://example.com/b", "https://example.com/stale"} {
+		req, err := repo.BLPopReservedRequest(ctx, time.Second)
+		if err != nil || req == nil || req.URL != want {
+			t.Fatalf("Expected %s, got %v %v", want, req, err)
+		}
+		// 取り出した予約は処理中として残る
+		if !repo.IsReserved(req.GenerateCacheKey()) {
+			t.Errorf("Expected %s to stay reserved while processing", want)
+		}
+	}
+	if req, err := repo.BLPopReservedRequest(ctx, 10*time.Millisecond); req != nil || err != nil {
+		t.Errorf("Expected a timeout, got %v %v", req, err)
+	}
+}
+
+func TestRepositoryBLPopWaits(t *testing.T) {
+	repo := NewRepository(0)
+	popped := make(chan *model.BpRequest)
+	go func() {
+		req, _ := repo.BLPopReservedRequest(context.Background(), 0)
+		popped <- req
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := repo.ReserveRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req := <-popped:
+		if req == nil || req.URL != "https://example.com/" {
+			t.Errorf("Expected the new reservation, got %v", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiting BLPop to wake up")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.BLPopReservedRequest(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRepositoryCache(t *testing.T) {
+	repo := NewRepository(time.Hour)
+	ctx := context.Background()
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://Example.com:443/a"}
+	resp := &model.BpResponse{StatusCode: http.StatusOK, Headers: map[string][]string{"Etag": {`"1"`}}, Body: []byte("body")}
+	if err := repo.SetResponseWithURL(ctx, req, resp, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// 保存した後に元のレスポンスを変えても、キャッシュは変わらない
+	resp.Body[0] = 'X'
+	resp.Headers["Etag"][0] = "changed"
+
+	cached, found, _ := repo.GetResponse(ctx, req.GenerateCacheKey())
+	if !found || string(cached.Body) != "body" || http.Header(cached.Headers).Get("Etag") != `"1"` || !cached.IsStale() {
+		t.Fatalf("Expected the stale copy, got found=%v %+v", found, cached)
+	}
+
+	// ストリームのボディは閉じるまで開いたままとして数える
+	streamed, found, _ := repo.GetResponseStream(ctx, req.GenerateCacheKey())
+	if !found || streamed.ContentLength != 4 || repo.OpenStreams() != 1 {
+		t.Fatalf("Expected one open stream of 4 bytes, got found=%v, %d open", found, repo.OpenStreams())
+	}
+	streamed.Close()
+	streamed.Close()
+	if n := repo.OpenStreams(); n != 0 {
+		t.Errorf("Expected the stream to be closed once, got %d open", n)
+	}
+
+	entries, _ := repo.GetCacheEntries(ctx, "https://example.com/a")
+	if len(entries) != 1 || entries[0].Size != 4 {
+		t.Fatalf("Expected one entry for the normalized URL, got %+v", entries)
+	}
+	if n, _ := repo.PurgeCache(ctx, "https://example.com/a"); n != 1 {
+		t.Errorf("Expected 1 purged entry, got %d", n)
+	}
+	if _, found, _ := repo.GetResponse(ctx, req.GenerateCacheKey()); found {
+		t.Error("Expected the cache to be purged")
+	}
+}
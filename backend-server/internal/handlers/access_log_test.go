@@ -0,0 +1,91 @@
+// access_log_test.go - ハンドラーがアクセスログに載せる結果・キャッシュの扱い・転送先のテスト
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+func TestAccessLogFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  model.CacheStatus
+		code    int
+		body    string
+		cache   string
+		outcome string
+	}{
+		{"hit", model.CacheHit, http.StatusOK, "cached page", "hit", "hit"},
+		{"reserved", model.CacheMissReserved, http.StatusAccepted, "placeholder", "reserved", "miss-reserved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeProxyService{
+				resp:   &model.BpResponse{StatusCode: tt.code, Body: []byte(tt.body), CachedAt: time.Now()},
+				status: tt.status,
+			}
+			h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+
+			var out bytes.Buffer
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(middleware.RequestID(), middleware.AccessLog(&out))
+			r.NoRoute(h.GetContent)
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/news?page=2", nil)
+			req.RemoteAddr = "192.168.1.30:40000"
+			req.Header.Set("X-Request-ID", "req-"+tt.name)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry middleware.AccessLogEntry
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("Expected one JSON line, got %q: %v", out.String(), err)
+			}
+			if entry.URL != "http://example.com/news?page=2" {
+				t.Errorf("Expected the full target URL, got %q", entry.URL)
+			}
+			if entry.Method != http.MethodGet || entry.Status != tt.code || entry.Bytes != len(tt.body) {
+				t.Errorf("Expected GET %d with %d bytes, got %s %d with %d bytes", tt.code, len(tt.body), entry.Method, entry.Status, entry.Bytes)
+			}
+			if entry.Cache != tt.cache || entry.Outcome != tt.outcome {
+				t.Errorf("Expected cache %q and outcome %q, got %q and %q", tt.cache, tt.outcome, entry.Cache, entry.Outcome)
+			}
+			if entry.ClientIP != "192.168.1.30" || entry.RequestID != "req-"+tt.name {
+				t.Errorf("Expected the client IP and request ID, got %q and %q", entry.ClientIP, entry.RequestID)
+			}
+			if entry.DurationMS < 0 || entry.Time.IsZero() {
+				t.Errorf("Expected a timestamp and duration, got %v and %v", entry.Time, entry.DurationMS)
+			}
+		})
+	}
+}
+
+func TestAccessLogBlocked(t *testing.T) {
+	// Service層まで届かなかったリクエストはキャッシュの扱いを載せない
+	svc := &fakeProxyService{resp: &model.BpResponse{StatusCode: http.StatusOK}, status: model.CacheHit}
+	filter := newTestDomainFilter(t, nil, []string{"blocked.example"})
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, filter, nil), nil, 0, 0, 0, false, "")
+
+	var out bytes.Buffer
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.AccessLog(&out))
+	r.NoRoute(h.GetContent)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://blocked.example/", nil))
+
+	var entry middleware.AccessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON line, got %q: %v", out.String(), err)
+	}
+	if entry.Outcome != outcomeBlocked || entry.Cache != "" || entry.Status != http.StatusForbidden {
+		t.Errorf("Expected a blocked entry without cache disposition, got %+v", entry)
+	}
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+const (
+	// defaultAdminPageLimit 一覧の1ページあたりの既定の件数
+	defaultAdminPageLimit = 50
+	// maxAdminPageLimit 一覧の1ページあたりの最大件数
+	maxAdminPageLimit = 500
+)
+
+// Reservation 予約キューに入っているリクエスト
+type Reservation struct {
+	// Position キュー内の位置（0が次にWorkerが取り出すリクエスト）
+	Position int    `json:"position"`
+	URL      string `json:"url"`
+	Method   string `json:"method"`
+	CacheKey string `json:"cache_key"`
+
+	// QueuedAt 予約した時刻（予約時刻を記録する前に入ったリクエストでは省略）
+	QueuedAt *time.Time `json:"queued_at,omitempty"`
+
+	// UserSpecific 認証情報やセッションを含むユーザー固有のリクエストか
+	UserSpecific bool `json:"user_specific"`
+
+	// Prefetch 事前取得（POST /system/admin/prefetch）の予約か（通常の予約より後に処理する）
+	Prefetch bool `json:"prefetch,omitempty"`
+
+	// Priority 予約キューの優先度（interactive・revalidation・prefetch、この順に取り出す）
+	Priority string `json:"priority"`
+}
+
+// ReservationList 予約キューの一覧（ページ単位）
+type ReservationList struct {
+	Total        int           `json:"total"`
+	Offset       int           `json:"offset"`
+	Limit        int           `json:"limit"`
+	Reservations []Reservation `json:"reservations"`
+}
+
+// CacheList キャッシュの一覧（ページ単位）
+type CacheList struct {
+	Total   int                 `json:"total"`
+	Offset  int                 `json:"offset"`
+	Limit   int                 `json:"limit"`
+	Domain  string              `json:"domain,omitempty"`
+	Entries []*model.CacheEntry `json:"entries"`
+}
+
+type adminHandler struct {
+	bprepo repository.BpRepository
+}
+
+func NewAdminHandler(bprepo repository.BpRepository) *adminHandler {
+	return &adminHandler{bprepo: bprepo}
+}
+
+// ListReservations 予約キューに入っているリクエストを、Workerが取り出す順（優先度の高い順、同じ優先度では予約した順）に返す
+// 送り直しを待っている予約も、戻す時刻の位置に含める
+// GET /system/admin/reservations?offset=0&limit=50
+// キューは読み取るだけで取り出さないため、WorkerのBLPopによる処理には影響しない
+func (ah *adminHandler) ListReservations(c *gin.Context) {
+	offset, limit, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	reserved, err := ah.bprepo.GetReservedRequests(c.Request.Context())
+	if err != nil {
+		log.Printf("[AdminHandler] GetReservedRequests error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to read reservation queue"})
+		return
+	}
+
+	list := ReservationList{
+		Total:        len(reserved),
+		Offset:       offset,
+		Limit:        limit,
+		Reservations: []Reservation{},
+	}
+	end := min(offset+limit, len(reserved))
+	for i := offset; i < end; i++ {
+		req := reserved[i]
+		r := Reservation{
+			Position:     i,
+			URL:          req.URL,
+			Method:       req.Method,
+			CacheKey:     req.GenerateCacheKey(),
+			UserSpecific: req.IsUserSpecific(),
+			Prefetch:     req.Prefetch,
+			Priority:     req.Priority().String(),
+		}
+		if !req.ReservedAt.IsZero() {
+			queuedAt := req.ReservedAt
+			r.QueuedAt = &queuedAt
+		}
+		list.Reservations = append(list.Reservations, r)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CancelReservation 指定したURLの予約をキューから削除する
+// DELETE /system/admin/reservations?url=...
+// 同じURLの予約が複数ある場合（ヘッダー違いなど）はすべて削除する
+func (ah *adminHandler) CancelReservation(c *gin.Context) {
+	targetURL := c.Query("url")
+	if targetURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url parameter is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	reserved, err := ah.bprepo.GetReservedRequests(ctx)
+	if err != nil {
+		log.Printf("[AdminHandler] GetReservedRequests error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to read reservation queue"})
+		return
+	}
+
+	removed := 0
+	for _, req := range reserved {
+		if req.URL != targetURL {
+			continue
+		}
+		// 一覧の取得後にWorkerが取り出した場合は何も削除されない（エラーにはならない）
+		if err := ah.bprepo.RemoveReservedRequest(ctx, req); err != nil {
+			log.Printf("[AdminHandler] RemoveReservedRequest error (URL: %s): %v", targetURL, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to remove reservation", "removed": removed})
+			return
+		}
+		removed++
+	}
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No reservation for the URL", "url": targetURL})
+		return
+	}
+
+	// 再び予約できるよう処理中のマークも外す
+	if err := ah.bprepo.RemovePendingRequest(ctx, targetURL); err != nil {
+		log.Printf("[AdminHandler] RemovePendingRequest error (URL: %s): %v", targetURL, err)
+	}
+
+	log.Printf("[AdminHandler] Cancelled %d reservation(s): %s", removed, targetURL)
+	c.JSON(http.StatusOK, gin.H{"url": targetURL, "removed": removed})
+}
+
+// ListCaches キャッシュの一覧を新しい順に返す
+// GET /system/admin/cache?domain=example.com&offset=0&limit=50
+func (ah *adminHandler) ListCaches(c *gin.Context) {
+	offset, limit, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+	domain := strings.ToLower(c.Query("domain"))
+
+	entries, total, err := ah.bprepo.ListCacheEntries(c.Request.Context(), domain, offset, limit)
+	if err != nil {
+		log.Printf("[AdminHandler] ListCacheEntries error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to list caches"})
+		return
+	}
+	if entries == nil {
+		entries = []*model.CacheEntry{}
+	}
+
+	c.JSON(http.StatusOK, CacheList{
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Domain:  domain,
+		Entries: entries,
+	})
+}
+
+// GetCacheStats キャッシュ全体の使用量（大きさの合計と件数）と容量の上限を返す
+// GET /system/admin/cache/stats
+func (ah *adminHandler) GetCacheStats(c *gin.Context) {
+	usage, err := ah.bprepo.GetCacheUsage(c.Request.Context())
+	if err != nil {
+		log.Printf("[AdminHandler] GetCacheUsage error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to read cache usage"})
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// GetCacheEntry URLのキャッシュのメタデータを返す（ヘッダー違いで複数ある場合はすべて）
+// GET /system/admin/cache/entry?url=...
+func (ah *adminHandler) GetCacheEntry(c *gin.Context) {
+	targetURL := c.Query("url")
+	if targetURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url parameter is required"})
+		return
+	}
+
+	entries, err := ah.bprepo.GetCacheEntries(c.Request.Context(), targetURL)
+	if err != nil {
+		log.Printf("[AdminHandler] GetCacheEntries error (URL: %s): %v", targetURL, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to look up cache"})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No cache for the URL", "url": targetURL})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": targetURL, "entries": entries})
+}
+
+// PurgeCacheEntry URLのキャッシュ（メタデータとファイル）を削除する
+// DELETE /system/admin/cache/entry?url=...
+func (ah *adminHandler) PurgeCacheEntry(c *gin.Context) {
+	targetURL := c.Query("url")
+	if targetURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url parameter is required"})
+		return
+	}
+
+	purged, err := ah.bprepo.PurgeCache(c.Request.Context(), targetURL)
+	if err != nil {
+		log.Printf("[AdminHandler] PurgeCache error (URL: %s): %v", targetURL, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to purge cache", "purged": purged})
+		return
+	}
+	if purged == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No cache for the URL", "url": targetURL})
+		return
+	}
+
+	log.Printf("[AdminHandler] Purged %d cache entries: %s", purged, targetURL)
+	c.JSON(http.StatusOK, gin.H{"url": targetURL, "purged": purged})
+}
+
+// CleanupExpiredCaches 期限切れのキャッシュ（メタデータとファイル）を削除する
+// POST /system/admin/cache/cleanup
+// スケジューラーと同じく1回に削除する数には上限があり、残りはcompleteがfalseの結果で返す（もう一度呼ぶと続きを削除する）
+func (ah *adminHandler) CleanupExpiredCaches(c *gin.Context) {
+	result, err := ah.bprepo.DeleteExpiredCaches(c.Request.Context())
+	if err != nil {
+		log.Printf("[AdminHandler] DeleteExpiredCaches error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to cleanup expired cache", "result": result})
+		return
+	}
+
+	log.Printf("[AdminHandler] Cleaned up %d expired caches (negative: %d, scanned: %d, remaining: %d, complete: %v)",
+		result.Deleted, result.Negative, result.Scanned, result.Remaining, result.Complete)
+	c.JSON(http.StatusOK, result)
+}
+
+// pageQuery offset・limitパラメータを読む（不正な場合は400を返してokをfalseにする）
+func pageQuery(c *gin.Context) (offset, limit int, ok bool) {
+	offset, limit = 0, defaultAdminPageLimit
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return 0, 0, false
+		}
+		offset = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return 0, 0, false
+		}
+		limit = min(n, maxAdminPageLimit)
+	}
+	return offset, limit, true
+}
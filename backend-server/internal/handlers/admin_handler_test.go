@@ -0,0 +1,414 @@
+// admin_handler_test.go - 予約キュー・キャッシュの管理用エンドポイントのテスト
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+)
+
+// queuePages https://example.com/page0から順にn件のページのGETのリクエスト
+func queuePages(n int) []*model.BpRequest {
+	pages := make([]*model.BpRequest, n)
+	for i := range pages {
+		pages[i] = &model.BpRequest{Method: http.MethodGet, URL: fmt.Sprintf("https://example.com/page%d", i)}
+	}
+	return pages
+}
+
+// newQueueRepository pagesを順に予約し、それぞれに送信待ちの印を付けたリポジトリ
+func newQueueRepository(t *testing.T, pages []*model.BpRequest) *fakes.Repository {
+	t.Helper()
+	repo := fakes.NewRepository(0)
+	ctx := context.Background()
+	for _, req := range pages {
+		if _, err := repo.ReserveRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.AddPendingRequest(ctx, req.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// reservedURLs 予約キューにある予約のURL（取り出す順）
+func reservedURLs(t *testing.T, repo *fakes.Repository) []string {
+	t.Helper()
+	reserved, err := repo.GetReservedRequests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := make([]string, 0, len(reserved))
+	for _, req := range reserved {
+		urls = append(urls, req.URL)
+	}
+	return urls
+}
+
+func newAdminRouter(repo repository.BpRepository) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewAdminHandler(repo)
+	r.GET("/system/admin/reservations", h.ListReservations)
+	r.DELETE("/system/admin/reservations", h.CancelReservation)
+	return r
+}
+
+func listReservations(t *testing.T, r *gin.Engine, query string) ReservationList {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/admin/reservations"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var list ReservationList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode list: %v", err)
+	}
+	return list
+}
+
+func TestListReservations(t *testing.T) {
+	pages := queuePages(3)
+	pages[1].Headers = map[string][]string{"Authorization": {"Bearer x"}}
+	repo := newQueueRepository(t, pages)
+	r := newAdminRouter(repo)
+	queue, err := repo.GetReservedRequests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := listReservations(t, r, "")
+	if list.Total != 3 || len(list.Reservations) != 3 || list.Limit != defaultAdminPageLimit {
+		t.Fatalf("Expected all 3 reservations with the default limit, got %+v", list)
+	}
+	for i, res := range list.Reservations {
+		want := queue[i]
+		if res.Position != i || res.URL != want.URL || res.Method != http.MethodGet || res.CacheKey != want.GenerateCacheKey() {
+			t.Errorf("Unexpected reservation %d: %+v", i, res)
+		}
+		if res.QueuedAt == nil || !res.QueuedAt.Equal(want.ReservedAt) {
+			t.Errorf("Expected queued_at %v for reservation %d, got %v", want.ReservedAt, i, res.QueuedAt)
+		}
+		if res.UserSpecific != (i == 1) {
+			t.Errorf("Expected user_specific=%v for reservation %d", i == 1, i)
+		}
+	}
+
+	// 一覧を取得してもキューは消費されない
+	if n := len(reservedURLs(t, repo)); n != 3 {
+		t.Errorf("Expected listing to leave the queue intact, %d left", n)
+	}
+	if req, _ := repo.BLPopReservedRequest(context.Background(), time.Millisecond); req == nil || req.URL != "https://example.com/page0" {
+		t.Errorf("Expected the worker to still pop the head of the queue, got %+v", req)
+	}
+}
+
+func TestListReservationsPaging(t *testing.T) {
+	r := newAdminRouter(newQueueRepository(t, queuePages(5)))
+
+	list := listReservations(t, r, "?offset=2&limit=2")
+	if list.Total != 5 || list.Offset != 2 || list.Limit != 2 || len(list.Reservations) != 2 {
+		t.Fatalf("Unexpected page %+v", list)
+	}
+	if list.Reservations[0].Position != 2 || list.Reservations[1].URL != "https://example.com/page3" {
+		t.Errorf("Expected positions 2 and 3, got %+v", list.Reservations)
+	}
+
+	if list := listReservations(t, r, "?offset=4&limit=10"); len(list.Reservations) != 1 {
+		t.Errorf("Expected 1 reservation on the last page, got %+v", list.Reservations)
+	}
+	if list := listReservations(t, r, "?offset=10"); list.Total != 5 || len(list.Reservations) != 0 {
+		t.Errorf("Expected an empty page past the end, got %+v", list)
+	}
+	if list := listReservations(t, r, "?limit=100000"); list.Limit != maxAdminPageLimit {
+		t.Errorf("Expected limit to be capped at %d, got %d", maxAdminPageLimit, list.Limit)
+	}
+
+	for _, query := range []string{"?offset=-1", "?limit=0", "?limit=abc"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/admin/reservations"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestCancelReservation(t *testing.T) {
+	// 同じURLのヘッダー違いの予約
+	pages := append(queuePages(3), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page1", Headers: map[string][]string{"Accept-Language": {"en"}}})
+	repo := newQueueRepository(t, pages)
+	r := newAdminRouter(repo)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/system/admin/reservations?url="+url.QueryEscape("https://example.com/page1"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Removed int `json:"removed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.Removed != 2 {
+		t.Errorf("Expected 2 removed, got %s", rec.Body.String())
+	}
+
+	urls := reservedURLs(t, repo)
+	if want := []string{"https://example.com/page0", "https://example.com/page2"}; !slices.Equal(urls, want) {
+		t.Errorf("Expected queue %v, got %v", want, urls)
+	}
+	if repo.IsPending("https://example.com/page1") {
+		t.Errorf("Expected the pending mark to be cleared")
+	}
+	if !repo.IsPending("https://example.com/page0") {
+		t.Errorf("Expected other pending marks to be kept")
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/system/admin/reservations?url="+url.QueryEscape("https://example.com/page1"), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a URL that is not queued, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/system/admin/reservations", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without url, got %d", rec.Code)
+	}
+}
+
+// memoryCacheRepository キャッシュの一覧をメモリ上に持つリポジトリ（新しい順に並べておく）
+type memoryCacheRepository struct {
+	repository.BpRepository
+	entries []*model.CacheEntry
+
+	// cleanupBudget DeleteExpiredCachesの1回で削除する数の上限（0は上限なし）
+	cleanupBudget int
+	cleanupErr    error
+}
+
+func (r *memoryCacheRepository) ListCacheEntries(ctx context.Context, domain string, offset, limit int) ([]*model.CacheEntry, int, error) {
+	var matched []*model.CacheEntry
+	for _, e := range r.entries {
+		if domain == "" || model.CacheDomain(e.URL) == domain {
+			matched = append(matched, e)
+		}
+	}
+	if offset >= len(matched) {
+		return nil, len(matched), nil
+	}
+	return matched[offset:min(offset+limit, len(matched))], len(matched), nil
+}
+
+func (r *memoryCacheRepository) GetCacheEntries(ctx context.Context, url string) ([]*model.CacheEntry, error) {
+	var matched []*model.CacheEntry
+	for _, e := range r.entries {
+		if e.URL == url {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+func (r *memoryCacheRepository) PurgeCache(ctx context.Context, url string) (int, error) {
+	before := len(r.entries)
+	r.entries = slices.DeleteFunc(r.entries, func(e *model.CacheEntry) bool { return e.URL == url })
+	return before - len(r.entries), nil
+}
+
+// GetCacheUsage 一覧のキャッシュの大きさの合計と件数を返す
+func (r *memoryCacheRepository) GetCacheUsage(ctx context.Context) (model.CacheUsage, error) {
+	usage := model.CacheUsage{Entries: len(r.entries), MaxBytes: 1000, MaxObjectBytes: 500, Evictions: 2, EvictedBytes: 700}
+	for _, e := range r.entries {
+		usage.Bytes += e.Size
+	}
+	return usage, nil
+}
+
+// DeleteExpiredCaches ExpiresAtを過ぎたキャッシュを、cleanupBudget件まで古い順に削除する
+func (r *memoryCacheRepository) DeleteExpiredCaches(ctx context.Context) (model.CleanupResult, error) {
+	if r.cleanupErr != nil {
+		return model.CleanupResult{}, r.cleanupErr
+	}
+	var result model.CleanupResult
+	now := time.Now()
+	kept := r.entries[:0]
+	for _, e := range r.entries {
+		result.Scanned++
+		switch {
+		case !e.ExpiresAt.Before(now):
+			kept = append(kept, e)
+		case r.cleanupBudget > 0 && result.Deleted >= r.cleanupBudget:
+			kept = append(kept, e)
+			result.Remaining++
+		default:
+			result.Deleted++
+		}
+	}
+	r.entries = kept
+	result.Complete = result.Remaining == 0
+	return result, nil
+}
+
+func newCacheAdminRouter(repo repository.BpRepository) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewAdminHandler(repo)
+	r.GET("/system/admin/cache", h.ListCaches)
+	r.GET("/system/admin/cache/stats", h.GetCacheStats)
+	r.GET("/system/admin/cache/entry", h.GetCacheEntry)
+	r.DELETE("/system/admin/cache/entry", h.PurgeCacheEntry)
+	r.POST("/system/admin/cache/cleanup", h.CleanupExpiredCaches)
+	return r
+}
+
+func newMemoryCacheRepository() *memoryCacheRepository {
+	storedAt := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+	repo := &memoryCacheRepository{}
+	for i, u := range []string{"https://example.com/a", "https://example.org/b", "https://example.com/c"} {
+		repo.entries = append(repo.entries, &model.CacheEntry{
+			CacheKey:    fmt.Sprintf("key%d", i),
+			URL:         u,
+			StatusCode:  http.StatusOK,
+			ContentType: "text/html",
+			Size:        int64(100 * (i + 1)),
+			StoredAt:    storedAt,
+			ExpiresAt:   storedAt.Add(24 * time.Hour),
+		})
+	}
+	return repo
+}
+
+func TestListCaches(t *testing.T) {
+	r := newCacheAdminRouter(newMemoryCacheRepository())
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/admin/cache?domain=Example.com&limit=1&offset=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var list CacheList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode list: %v", err)
+	}
+	if list.Total != 2 || list.Domain != "example.com" || len(list.Entries) != 1 {
+		t.Fatalf("Expected the second of 2 example.com entries, got %+v", list)
+	}
+	e := list.Entries[0]
+	if e.URL != "https://example.com/c" || e.Size != 300 || e.ContentType != "text/html" || e.ExpiresAt.IsZero() {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
+
+func TestGetAndPurgeCacheEntry(t *testing.T) {
+	repo := newMemoryCacheRepository()
+	r := newCacheAdminRouter(repo)
+	entryURL := "/system/admin/cache/entry?url=" + url.QueryEscape("https://example.org/b")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, entryURL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var got struct {
+		Entries []model.CacheEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || len(got.Entries) != 1 || got.Entries[0].CacheKey != "key1" {
+		t.Fatalf("Expected the example.org entry, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, entryURL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(repo.entries) != 2 {
+		t.Errorf("Expected 2 entries left, got %d", len(repo.entries))
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, entryURL, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s after purge, got %d", method, rec.Code)
+		}
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, "/system/admin/cache/entry", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s without url, got %d", method, rec.Code)
+		}
+	}
+}
+
+func TestGetCacheStats(t *testing.T) {
+	repo := newMemoryCacheRepository()
+	r := newCacheAdminRouter(repo)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/admin/cache/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var usage map[string]int64
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	want := map[string]int64{"bytes": 600, "entries": 3, "max_bytes": 1000, "max_object_bytes": 500, "evictions": 2, "evicted_bytes": 700}
+	for key, value := range want {
+		if usage[key] != value {
+			t.Errorf("Expected %s=%d, got %s", key, value, rec.Body.String())
+		}
+	}
+}
+
+func TestCleanupExpiredCaches(t *testing.T) {
+	repo := newMemoryCacheRepository()
+	repo.entries[1].ExpiresAt = time.Now().Add(time.Hour)
+	repo.cleanupBudget = 1
+	r := newCacheAdminRouter(repo)
+
+	cleanup := func() model.CleanupResult {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/system/admin/cache/cleanup", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var result model.CleanupResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to decode cleanup result: %v", err)
+		}
+		return result
+	}
+
+	// 上限で残ったキャッシュは、もう一度呼ぶと削除する
+	if result := cleanup(); result != (model.CleanupResult{Deleted: 1, Scanned: 3, Remaining: 1}) {
+		t.Errorf("Expected 1 deleted and 1 remaining, got %+v", result)
+	}
+	if result := cleanup(); result != (model.CleanupResult{Deleted: 1, Scanned: 2, Complete: true}) {
+		t.Errorf("Expected the rest to be deleted, got %+v", result)
+	}
+	// 期限内のキャッシュは削除しない（すべてを削除するエンドポイントではない）
+	if len(repo.entries) != 1 || repo.entries[0].URL != "https://example.org/b" {
+		t.Errorf("Expected only the fresh cache to be kept, got %+v", repo.entries)
+	}
+
+	repo.cleanupErr = errors.New("redis: connection refused")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/system/admin/cache/cleanup", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 on error, got %d", rec.Code)
+	}
+}
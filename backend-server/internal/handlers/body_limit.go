@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// errBodyTooLarge リクエストボディが上限を超えた
+var errBodyTooLarge = errors.New("request body too large")
+
+// bodyTooLarge 413で返すJSON（上限をバイト数で伝える）
+func bodyTooLarge(limit int64) gin.H {
+	return gin.H{
+		"error":   fmt.Sprintf("request body exceeds the limit of %d bytes", limit),
+		"limit":   limit,
+		"message": "Large uploads cannot be sent over DTN",
+	}
+}
+
+// readRequestBody 通常のプロキシリクエストのボディを上限まで読み込む
+// Content-Lengthで上限を超えるとわかる場合は読まずにerrBodyTooLargeを返す
+func (bh *bpHandler) readRequestBody(c *gin.Context) ([]byte, error) {
+	r := c.Request
+	if r.Body == nil {
+		return nil, nil
+	}
+	defer r.Body.Close()
+	if bh.maxBodySize <= 0 {
+		return io.ReadAll(r.Body)
+	}
+	if r.ContentLength > bh.maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, r.Body, bh.maxBodySize))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, errBodyTooLarge
+	}
+	return body, err
+}
+
+// readBumpedRequestBody CONNECTトンネル内のリクエストのボディを上限まで読み込む
+func (bh *bpHandler) readBumpedRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	if bh.maxBodySize <= 0 {
+		return io.ReadAll(req.Body)
+	}
+	if req.ContentLength > bh.maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, bh.maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > bh.maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+// writeBumpedBodyTooLarge CONNECTトンネル内のリクエストに413を返す
+// 残りのボディを読み捨てずに済むよう、接続は閉じる
+func (bh *bpHandler) writeBumpedBodyTooLarge(req *http.Request, w *bufio.Writer) bool {
+	log.Printf("[BpHandler] Request body too large: Method=%s, URL=%s, limit=%d, RequestID=%s", req.Method, req.URL, bh.maxBodySize, requestid.FromContext(req.Context()))
+	body, _ := json.Marshal(bodyTooLarge(bh.maxBodySize))
+	req.Close = true
+	writeBumpedResponse(req, w, http.StatusRequestEntityTooLarge, http.Header{"Content-Type": {"application/json; charset=utf-8"}}, body)
+	return false
+}
@@ -0,0 +1,130 @@
+// body_limit_test.go - リクエストボディの上限（413）のテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+const testBodyLimit = 1024
+
+func newLimitedHandler() (*bpHandler, *recordingGateway) {
+	gw := &recordingGateway{}
+	return NewBpHandler(service.NewBpService(gw, unavailableRepository{}, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, testBodyLimit, false, ""), gw
+}
+
+// onlyReader Content-Lengthを知らせないボディ（chunked）
+type onlyReader struct{ io.Reader }
+
+func checkTooLarge(t *testing.T, code int, body []byte) {
+	t.Helper()
+	if code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d", code)
+	}
+	var payload struct {
+		Error string `json:"error"`
+		Limit int64  `json:"limit"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Expected a JSON error, got %q", body)
+	}
+	if payload.Limit != testBodyLimit || !strings.Contains(payload.Error, "1024") {
+		t.Errorf("Expected the error to name the limit, got %+v", payload)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    io.Reader
+		tooBig  bool
+		wantLen int
+	}{
+		{"content-length over", bytes.NewReader(make([]byte, testBodyLimit+1)), true, 0},
+		{"chunked over", onlyReader{bytes.NewReader(make([]byte, 4*testBodyLimit))}, true, 0},
+		{"at limit", bytes.NewReader(make([]byte, testBodyLimit)), false, testBodyLimit},
+		{"chunked under", onlyReader{bytes.NewReader(make([]byte, testBodyLimit-1))}, false, testBodyLimit - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, gw := newLimitedHandler()
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.NoRoute(h.GetContent)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com/upload", tt.body))
+
+			if tt.tooBig {
+				checkTooLarge(t, rec.Code, rec.Body.Bytes())
+				if gw.last != nil {
+					t.Error("Expected the oversized request not to be forwarded")
+				}
+				return
+			}
+			if rec.Code != http.StatusOK || gw.last == nil {
+				t.Fatalf("Expected the request to be forwarded, got %d", rec.Code)
+			}
+			if len(gw.last.Body) != tt.wantLen {
+				t.Errorf("Expected a %d byte body, got %d", tt.wantLen, len(gw.last.Body))
+			}
+		})
+	}
+}
+
+func TestRequestBodyLimitInTunnel(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   io.Reader
+		tooBig bool
+	}{
+		{"content-length over", bytes.NewReader(make([]byte, testBodyLimit+1)), true},
+		{"chunked over", onlyReader{bytes.NewReader(make([]byte, 4*testBodyLimit))}, true},
+		{"at limit", bytes.NewReader(make([]byte, testBodyLimit)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, gw := newLimitedHandler()
+			// トンネルから読んだのと同じ形にするため、一度書き出して読み直す
+			out, _ := http.NewRequest(http.MethodPost, "https://example.com/upload", tt.body)
+			var raw bytes.Buffer
+			out.Write(&raw)
+			req, err := http.ReadRequest(bufio.NewReader(&raw))
+			if err != nil {
+				t.Fatalf("ReadRequest failed: %v", err)
+			}
+
+			var buf bytes.Buffer
+			keepAlive := h.serveBumpedRequest(req, bufio.NewWriter(&buf))
+			resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+
+			if tt.tooBig {
+				checkTooLarge(t, resp.StatusCode, body)
+				if keepAlive || !resp.Close {
+					t.Error("Expected the tunnel to be closed after a 413")
+				}
+				if gw.last != nil {
+					t.Error("Expected the oversized request not to be forwarded")
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusOK || gw.last == nil || len(gw.last.Body) != testBodyLimit {
+				t.Errorf("Expected the request to be forwarded, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
@@ -3,28 +3,60 @@ package handlers
 import (
 	"bufio"
 	"context"
-	"fmt"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"strings"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
-	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/headers"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
 )
 
+// proxyService bpHandlerが使うService層の操作（service.BpService、テストでは偽物に差し替える）
+type proxyService interface {
+	ProxyRequestWithStatus(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error)
+}
+
 type bpHandler struct {
-	bpService  *service.BpService
+	bpService  proxyService
 	middleware *middleware.MiddlewarePlugins
+	metrics    *metrics.Metrics
+
+	// retryAfter プレースホルダーを返したときにRetry-Afterで伝える、DTN経由でレスポンスが届くまでの目安
+	retryAfter time.Duration
+
+	// requestTimeout Service層の処理を待つ期限（0以下は無制限）。超えた場合は504を返す
+	requestTimeout time.Duration
+
+	// maxBodySize リクエストボディの上限（バイト、0以下は無制限）。超えた場合は転送も予約もせずに413を返す
+	maxBodySize int64
+
+	// forbidUpgrade Upgrade（WebSocketなど）を求めるリクエストを501ではなく403で拒否する
+	forbidUpgrade bool
+
+	// errorPages 502・503・504で返すページ（デフォルトページのディレクトリのテンプレートで差し替えられる）
+	errorPages *errorPages
 }
 
-func NewBpHandler(bpService *service.BpService, middlware *middleware.MiddlewarePlugins) *bpHandler {
+// NewBpHandler pageDirはエラーページのテンプレート（error_502.htmlなど）を置くディレクトリ（空の場合は組み込みのページのみ）
+func NewBpHandler(bpService proxyService, middlware *middleware.MiddlewarePlugins, metrics *metrics.Metrics, retryAfter, requestTimeout time.Duration, maxBodySize int64, forbidUpgrade bool, pageDir string) *bpHandler {
 	return &bpHandler{
-		bpService:  bpService,
-		middleware: middlware,
+		bpService:      bpService,
+		middleware:     middlware,
+		metrics:        metrics,
+		retryAfter:     retryAfter,
+		requestTimeout: requestTimeout,
+		maxBodySize:    maxBodySize,
+		forbidUpgrade:  forbidUpgrade,
+		errorPages:     loadErrorPages(pageDir),
 	}
 }
 
@@ -43,10 +75,29 @@ func (bh *bpHandler) GetContent(c *gin.Context) {
 		return
 	}
 
+	// リクエスト数と所要時間を結果ごとに記録する（CONNECTはトンネル内のリクエストごとに記録する）
+	// アクセスログにも同じ結果と、Service層がレスポンスを用意した方法を載せる
+	start := time.Now()
+	outcome := outcomeError
+	cache := ""
+	defer func() {
+		bh.metrics.ObserveRequest(r.Method, outcome, time.Since(start))
+		middleware.SetAccessOutcome(c, outcome, cache)
+	}()
+
+	// RequestIDミドルウェアを通っていない場合（テストなど）もIDを決めてレスポンスで返す
+	reqID := requestid.FromContext(r.Context())
+	if reqID == "" {
+		reqID = requestid.FromHeader(r.Header.Get(requestid.Header))
+		r = r.WithContext(requestid.NewContext(r.Context(), reqID))
+		c.Request = r
+		w.Header().Set(requestid.Header, reqID)
+	}
+
 	// 転送されてくるHTTPリクエストを処理（GET、POST、PUT、DELETE、PATCHなどすべてのメソッドに対応）
-	// 転送先URLをクエリパラメータから取得
-	targetURL := r.URL.Query().Get("url")
+	targetURL := proxyTargetURL(r)
 	if targetURL == "" {
+		outcome = outcomeBadRequest
 		http.Error(w, "url parameter is required", http.StatusBadRequest)
 		return
 	}
@@ -54,63 +105,256 @@ func (bh *bpHandler) GetContent(c *gin.Context) {
 	// URLの検証
 	parsedURL, err := url.Parse(targetURL)
 	if err != nil {
+		outcome = outcomeBadRequest
 		http.Error(w, "Invalid URL", http.StatusBadRequest)
 		return
 	}
+	middleware.SetAccessTarget(c, parsedURL.String())
 
-	// リクエストボディを読み込む
-	var bodyBytes []byte
-	if r.Body != nil {
-		bodyBytes, err = io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-			return
-		}
-		r.Body.Close()
+	// ブロックしたドメインへのリクエストは予約も転送もしない
+	if allowed, reason := bh.checkDomain(parsedURL.Host); !allowed {
+		outcome = outcomeBlocked
+		writeBlockedPage(w, parsedURL.Hostname(), reason)
+		return
+	}
+
+	// WebSocketなどのプロトコルの切り替えはDTNで中継できないため、予約せずに拒否する
+	if protocol := upgradeProtocol(r.Header); protocol != "" {
+		log.Printf("[BpHandler] Rejected upgrade request: Method=%s, URL=%s, Upgrade=%s, RequestID=%s", r.Method, targetURL, protocol, reqID)
+		outcome = outcomeUpgrade
+		c.Header("Cache-Control", "no-store")
+		c.JSON(bh.upgradeRejection(protocol))
+		return
+	}
+
+	// リクエストボディを読み込む（上限を超える場合はバンドルに詰め込まずに413を返す）
+	bodyBytes, err := bh.readRequestBody(c)
+	if errors.Is(err, errBodyTooLarge) {
+		log.Printf("[BpHandler] Request body too large: Method=%s, URL=%s, limit=%d, RequestID=%s", r.Method, targetURL, bh.maxBodySize, reqID)
+		outcome = outcomeTooLarge
+		c.JSON(http.StatusRequestEntityTooLarge, bodyTooLarge(bh.maxBodySize))
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		return
 	}
 
 	breq := model.BpRequest{
 		Method:        r.Method,
 		URL:           parsedURL.String(),
-		Headers:       r.Header,
+		Headers:       headers.Outbound(r.Header, r.RemoteAddr),
 		Body:          bodyBytes,
 		ContentType:   r.Header.Get("Content-Type"),
 		ContentLength: r.ContentLength,
+		RequestID:     reqID,
+		// プロキシの設定なしで?url=の形式で開いた場合、ページのリンクもこの形式に書き換えられる
+		ViaURLParam: !r.URL.IsAbs() && r.URL.Query().Get("url") != "",
 	}
 
-	log.Printf("[BpHandler] Received request: Method=%s, URL=%s", breq.Method, breq.URL)
+	log.Printf("[BpHandler] Received request: Method=%s, URL=%s, RequestID=%s", breq.Method, breq.URL, breq.RequestID)
 
 	// Service層でリクエストを転送（キャッシュ可能な場合はキャッシュもチェック）
-	// リクエストのcontextを取得して伝播（キャンセレーションやタイムアウト制御のため）
+	// リクエストのcontextを取得して伝播（キャンセレーションのため）し、ハンドラーの期限を付ける
 	ctx := r.Context()
-	resp, err := bh.bpService.ProxyRequest(ctx, &breq)
+	resp, status, err := bh.proxyWithDeadline(ctx, &breq)
+	if errors.Is(err, errRequestTimeout) {
+		outcome = outcomeTimeout
+		bh.writeErrorPage(w, r.Header.Get("Accept"), bh.timeoutPage(&breq))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to proxy request", http.StatusBadGateway)
+		log.Printf("[BpHandler] Proxy request failed (RequestID=%s): %v", breq.RequestID, err)
+		bh.writeErrorPage(w, r.Header.Get("Accept"), bh.gatewayErrorPage(err, &breq))
+		return
+	}
+	// 大きいキャッシュはファイルを開いたまま返されるため、書き終えたら閉じる
+	defer resp.Close()
+	outcome = string(status)
+	cache = cacheDisposition(status)
+
+	// クライアントが持っているものとキャッシュが同じ場合はボディを返さない
+	// （CONNECTのトンネル内のリクエストと同じく、プレースホルダーへの差し込みより先に判定する）
+	if notModified(r.Method, r.Header, resp, status) {
+		for key, values := range notModifiedHeader(resp) {
+			w.Header()[key] = values
+		}
+		bh.setCacheHeaders(w.Header(), resp, status)
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	resp = bh.withPlaceholderRefresh(resp, status, &breq)
+	// キャッシュしたレスポンスはRangeで求められた範囲だけを返せる（範囲はエンコードしていないボディに対して適用する）
+	resp = withRange(r.Method, r.Header, resp, status)
+	resp = withCompression(resp, r.Header)
 
-	// レスポンスヘッダーをコピー
-	for key, values := range resp.Headers {
+	// レスポンスヘッダーをコピー（キャッシュや転送先のホップ・バイ・ホップヘッダーはクライアントに渡さない）
+	for key, values := range headers.StripHopByHop(resp.Headers) {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	bh.setCacheHeaders(w.Header(), resp, status)
+	if resp.BodyStream != nil {
+		// ファイルから少しずつコピーするため、長さを先に伝える（キャッシュしたヘッダーのContent-Lengthは使わない）
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
 
 	// ステータスコードを設定
 	w.WriteHeader(resp.StatusCode)
 
-	// レスポンスボディをコピー
-	_, err = io.Copy(w, resp.GetBodyReader())
+	// レスポンスボディをコピー（ヘッダーは送信済みのため、失敗した場合は接続を切るしかない）
+	if body := resp.GetBodyReader(); body != nil {
+		if _, err := io.Copy(w, body); err != nil {
+			log.Printf("[BpHandler] Failed to copy response body (RequestID=%s): %v", breq.RequestID, err)
+		}
+	}
+}
+
+// メトリクスに記録するリクエストの結果（Service層が応答した場合はmodel.CacheStatusの値）
+const (
+	outcomeBadRequest = "bad-request"
+	outcomeBlocked    = "blocked"
+	outcomeTooLarge   = "too-large"
+	outcomeUpgrade    = "upgrade-rejected"
+	outcomeTimeout    = "timeout"
+	outcomeError      = "error"
+)
+
+// setCacheHeaders レスポンスがどのように用意されたかをヘッダーで伝える
+// X-Cache: HIT/STALE/REVALIDATING/NEGATIVE/MISS（STALEにはWarningも付ける）、X-Bp-Queue-Status: model.CacheStatusの値、
+// キャッシュの場合はAge、DTNへ予約した場合はRetry-After（レスポンスが届くまでの目安）
+func (bh *bpHandler) setCacheHeaders(h http.Header, resp *model.BpResponse, status model.CacheStatus) {
+	switch status {
+	case model.CacheHit:
+		h.Set("X-Cache", "HIT")
+	case model.CacheStale:
+		h.Set("X-Cache", "STALE")
+		// 更新を予約した期限切れのキャッシュであることを伝える（RFC 7234 5.5.1）
+		h.Set("Warning", `110 - "Response is Stale"`)
+	case model.CacheRevalidating:
+		// クライアントの要求で更新を予約したキャッシュ
+		h.Set("X-Cache", "REVALIDATING")
+	case model.CacheNegative:
+		h.Set("X-Cache", "NEGATIVE")
+	default:
+		h.Set("X-Cache", "MISS")
+	}
+	h.Set("X-Bp-Queue-Status", string(status))
+
+	if (status.IsHit() || status == model.CacheNegative) && !resp.CachedAt.IsZero() {
+		age := max(time.Since(resp.CachedAt), 0)
+		h.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	}
+	if status == model.CacheMissReserved && bh.retryAfter > 0 {
+		h.Set("Retry-After", formatSeconds(bh.retryAfter))
+	}
+}
+
+// cacheDisposition アクセスログに載せるキャッシュの扱い（hit・stale・revalidating・negative・reserved・placeholder・direct）
+func cacheDisposition(status model.CacheStatus) string {
+	switch status {
+	case model.CacheHit, model.CacheStale, model.CacheRevalidating, model.CacheNegative:
+		return string(status)
+	case model.CacheMissReserved:
+		return "reserved"
+	case model.CacheMissPlaceholder:
+		return "placeholder"
+	case model.CacheMissDirect:
+		return "direct"
+	default:
+		return ""
+	}
+}
+
+// formatSeconds Retry-Afterなどに使う秒数（切り上げ）
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// proxyTargetURL リクエストから転送先URLを取得する（取得できない場合は空）
+// 1. 標準的なHTTPプロキシのリクエスト（GET http://example.com/page HTTP/1.1）はリクエストURIをそのまま使う
+// 2. 従来の ?url= クエリパラメータ
+// 3. origin-form（GET /page）でHostヘッダーがこのサーバー以外を指す場合（透過プロキシ）はHostから再構築する
+func proxyTargetURL(r *http.Request) string {
+	if r.URL.IsAbs() {
+		return r.URL.String()
+	}
+	if targetURL := r.URL.Query().Get("url"); targetURL != "" {
+		return targetURL
+	}
+	if r.Host == "" || isSelfHost(r) {
+		return ""
+	}
+	return (&url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}).String()
+}
+
+// bumpedTargetURL CONNECTトンネル（SSL Bump）内のリクエストから転送先URLを組み立てる
+// トンネル内はTLSのため、スキームは常にhttps（絶対形式でhttp://と書かれていても、地上局がhttpで取得してリダイレクトをキャッシュしないようにする）
+// パスはクライアントが送ったエスケープのまま使う（%2Fなどを復号すると別のURLになる）
+func bumpedTargetURL(req *http.Request) string {
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+	if host == "" {
+		host = "unknown"
+	}
+	return (&url.URL{Scheme: "https", Host: host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}).String()
+}
+
+// isSelfHost Hostヘッダーがリクエストを受け付けたこのサーバー自身を指しているか
+// （自分自身への転送でループしないようにする）
+func isSelfHost(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	localHost, localPort, err := net.SplitHostPort(local.String())
 	if err != nil {
-		http.Error(w, "Failed to copy response body", http.StatusInternalServerError)
-		return
+		return false
+	}
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, "80"
 	}
+	if port != localPort {
+		return false
+	}
+	if host == "localhost" || host == localHost {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified() || ip.Equal(net.ParseIP(localHost)))
 }
 
 // handleCONNECT CONNECTメソッドのリクエストを処理（HTTPトンネリング）
 func (bh *bpHandler) handleCONNECT(c *gin.Context) {
 	w := c.Writer
 
+	// ブロックしたドメインはTLSハンドシェイクを始める前に拒否する
+	// （ブラウザはCONNECTのエラーのボディを表示しないが、トンネル内のリクエストもserveBumpedRequestで拒否する）
+	if allowed, reason := bh.checkDomain(c.Request.Host); !allowed {
+		writeBlockedPage(w, c.Request.Host, reason)
+		c.Abort()
+		return
+	}
+
+	// 証明書のピンニングなどでSSL Bumpできない接続先は、復号せずにそのまま中継する
+	if bh.middleware != nil && bh.middleware.Passthrough.Match(c.Request.Host) {
+		bh.handlePassthrough(c, bh.middleware.Passthrough)
+		return
+	}
+
+	// SSL Bumpの初期化に失敗して起動した場合はHTTPSを復号できない
+	if bh.middleware == nil || bh.middleware.SSLBumpHandler == nil {
+		log.Printf("[BpHandler] CONNECT rejected: SSL bump is not available")
+		page := bh.newErrorPage(http.StatusServiceUnavailable, reasonInterceptionUnavailable, "https://"+c.Request.Host, requestid.FromContext(c.Request.Context()))
+		bh.writeErrorPage(w, c.Request.Header.Get("Accept"), page)
+		c.Abort()
+		return
+	}
+
 	// Hijackして双方向のストリーム転送を開始（socket?）
 	// 注意: Hijackする前にヘッダーを書き込んではいけない
 	hijacker, ok := w.(http.Hijacker)
@@ -157,98 +401,122 @@ func (bh *bpHandler) handleCONNECT(c *gin.Context) {
 	// 復号化されたリクエストの処理
 	// ============================================
 
-	// TLS接続からHTTPリクエストを読み込む
+	// ブラウザはkeep-aliveで1つのトンネルに複数のリクエストを送るため、
+	// EOF、Connection: close、またはアイドルタイムアウトまでリクエストを読み続ける
 	// 注意: http.ReadRequestはbufio.Readerを要求するためラップする
 	bufReader := bufio.NewReader(tlsConn)
-	req, err := http.ReadRequest(bufReader)
-	if err != nil {
-		log.Printf("[BpHandler] Failed to read HTTP request from TLS connection: %v", err)
-		return // Hijack後はc.Abort()を呼ばない
-	}
-
-	// リクエストボディを読み込む
-	var bodyBytes []byte
-	if req.Body != nil {
-		bodyBytes, err = io.ReadAll(req.Body)
+	bufWriter := bufio.NewWriter(tlsConn)
+	for {
+		// 次のリクエストを待つ間だけアイドルタイムアウトを設定する
+		tlsConn.SetReadDeadline(time.Now().Add(connectIdleTimeout))
+		req, err := http.ReadRequest(bufReader)
 		if err != nil {
-			log.Printf("[BpHandler] Failed to read request body: %v", err)
+			var netErr net.Error
+			if err != io.EOF && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				log.Printf("[BpHandler] Failed to read HTTP request from TLS connection: %v", err)
+			}
 			return // Hijack後はc.Abort()を呼ばない
 		}
-		req.Body.Close()
+		tlsConn.SetReadDeadline(time.Now().Add(connectRequestTimeout))
+
+		// トンネル内のリクエストにはクライアントのアドレスがないため、CONNECTの接続元を引き継ぐ（X-Forwarded-For）
+		req.RemoteAddr = c.Request.RemoteAddr
+		if !bh.serveBumpedRequest(req, bufWriter) {
+			return
+		}
+	}
+}
+
+// connectIdleTimeout CONNECTトンネルで次のリクエストを待つ時間（超えた場合は接続を閉じる）
+const connectIdleTimeout = 30 * time.Second
+
+// connectRequestTimeout CONNECTトンネル内の1リクエストを読み終えるまでの時間（レスポンスを待つ期限はrequestTimeout）
+const connectRequestTimeout = 60 * time.Second
+
+// serveBumpedRequest 復号化したリクエストを転送し、レスポンスをクライアント（TLS接続）に書き込む
+// 同じ接続で次のリクエストを読める場合はtrueを返す
+func (bh *bpHandler) serveBumpedRequest(req *http.Request, w *bufio.Writer) bool {
+	start := time.Now()
+	outcome := outcomeError
+	defer func() { bh.metrics.ObserveRequest(req.Method, outcome, time.Since(start)) }()
+
+	// トンネル内のリクエストはGinのミドルウェアを通らないため、ここでIDを決める（writeBumpedResponseがレスポンスで返す）
+	req = req.WithContext(requestid.NewContext(req.Context(), requestid.FromHeader(req.Header.Get(requestid.Header))))
+
+	// wss://などはCONNECTの後、トンネル内のリクエストでプロトコルの切り替えを求める
+	if protocol := upgradeProtocol(req.Header); protocol != "" {
+		outcome = outcomeUpgrade
+		return bh.writeBumpedUpgradeRejected(req, protocol, w)
+	}
+
+	// リクエストボディを読み込む（上限を超える場合はバンドルに詰め込まずに413を返す）
+	bodyBytes, err := bh.readBumpedRequestBody(req)
+	if errors.Is(err, errBodyTooLarge) {
+		outcome = outcomeTooLarge
+		return bh.writeBumpedBodyTooLarge(req, w)
+	}
+	if err != nil {
+		log.Printf("[BpHandler] Failed to read request body: %v", err)
+		return false
 	}
 
 	// BpRequestを作成
 	bpReq := &model.BpRequest{
 		Method:        req.Method,
-		URL:           req.URL.String(),
-		Headers:       req.Header,
+		URL:           bumpedTargetURL(req),
+		Headers:       headers.Outbound(req.Header, req.RemoteAddr),
 		Body:          bodyBytes,
 		ContentType:   req.Header.Get("Content-Type"),
 		ContentLength: req.ContentLength,
+		RequestID:     requestid.FromContext(req.Context()),
 	}
 
-	// スキームが欠落している場合（サーバーリクエストで一般的）、完全なURLを再構築する
-	if req.URL.Scheme == "" {
-		scheme := "https"
-		host := req.Host
-		if host == "" {
-			host = "unknown"
-		}
-		bpReq.URL = fmt.Sprintf("%s://%s%s", scheme, host, req.URL.Path)
-		if req.URL.RawQuery != "" {
-			bpReq.URL += "?" + req.URL.RawQuery
+	log.Printf("[BpHandler] Decrypted request: Method=%s, URL=%s, RequestID=%s", bpReq.Method, bpReq.URL, bpReq.RequestID)
+
+	// CONNECTしたホストと異なるHostへのリクエストもトンネル内で送れるため、リクエストごとに判定する
+	if u, err := url.Parse(bpReq.URL); err == nil {
+		if allowed, reason := bh.checkDomain(u.Host); !allowed {
+			outcome = outcomeBlocked
+			body := renderBlockedPage(u.Hostname(), reason)
+			return writeBumpedResponse(req, w, http.StatusForbidden, blockedHeader(), body)
 		}
 	}
 
-	log.Printf("[BpHandler] Decrypted request: Method=%s, URL=%s", bpReq.Method, bpReq.URL)
-
 	// 取得したリクエストをService層で転送
-	// contextは元のリクエストのものを使用できないため（Hijack済み）、新しいcontextを作成
-	ctx := context.Background()
-	resp, err := bh.bpService.ProxyRequest(ctx, bpReq)
+	// contextは元のリクエストのものを使用できないため（Hijack済み）、リクエストごとに新しいcontextを作成してハンドラーの期限を付ける
+	resp, status, err := bh.proxyWithDeadline(context.Background(), bpReq)
+	if errors.Is(err, errRequestTimeout) {
+		outcome = outcomeTimeout
+		return bh.writeBumpedErrorPage(req, w, bh.timeoutPage(bpReq))
+	}
 	if err != nil {
-		log.Printf("[BpHandler] Proxy request failed: %v", err)
-		// エラーレスポンスをTLS接続に書き込む
-		resp := &http.Response{
-			StatusCode: http.StatusBadGateway,
-			ProtoMajor: 1,
-			ProtoMinor: 1,
-			Body:       io.NopCloser(strings.NewReader("Bad Gateway")),
-		}
-		resp.Write(tlsConn)
-		return // Hijack後はc.Abort()を呼ばない
+		log.Printf("[BpHandler] Proxy request failed (RequestID=%s): %v", bpReq.RequestID, err)
+		// エラーページをTLS接続に書き込む
+		return bh.writeBumpedErrorPage(req, w, bh.gatewayErrorPage(err, bpReq))
 	}
-
-	// GetBodyReader()がnilを返す可能性を考慮
-	bodyReader := resp.GetBodyReader()
-	if bodyReader == nil {
-		bodyReader = strings.NewReader("")
+	defer resp.Close()
+	outcome = string(status)
+
+	// クライアントが持っているものとキャッシュが同じ場合はボディを返さない（GetContentと同じく、プレースホルダーへの差し込みより先に判定する）
+	if notModified(req.Method, req.Header, resp, status) {
+		header := notModifiedHeader(resp)
+		bh.setCacheHeaders(header, resp, status)
+		return writeBumpedResponse(req, w, http.StatusNotModified, header, nil)
 	}
-	bodyCloser := io.NopCloser(bodyReader)
-	defer bodyCloser.Close()
 
-	// レスポンスをクライアント（TLS接続）に書き込む
-	// http.Responseを構築してWriteメソッドで書き込む
-	httpResp := &http.Response{
-		StatusCode:    resp.StatusCode,
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Header:        make(http.Header),
-		Body:          bodyCloser,
-		ContentLength: resp.ContentLength,
-	}
-	// ヘッダーをコピー
-	for key, values := range resp.Headers {
-		for _, value := range values {
-			httpResp.Header.Add(key, value)
-		}
-	}
+	resp = bh.withPlaceholderRefresh(resp, status, bpReq)
+	resp = withRange(req.Method, req.Header, resp, status)
+	resp = withCompression(resp, req.Header)
 
-	// レスポンスを書き込む
-	if err := httpResp.Write(tlsConn); err != nil {
-		log.Printf("[BpHandler] Failed to write response: %v", err)
-		return // Hijack後はc.Abort()を呼ばない
+	// ボディはすべて読み込み済みのため、Content-Lengthはキャッシュしたヘッダーではなく実際の長さを使う
+	// （keep-aliveでは長さが違うと次のレスポンスの境界がずれる）
+	header := http.Header(resp.Headers).Clone()
+	if header == nil {
+		header = make(http.Header)
 	}
-
-	// Hijack後はc.Abort()を呼ばない（main.goで既に呼ばれている）
+	bh.setCacheHeaders(header, resp, status)
+	if resp.BodyStream != nil {
+		return writeBumped(w, newBumpedResponse(req, resp.StatusCode, header, resp.BodyStream, resp.ContentLength))
+	}
+	return writeBumpedResponse(req, w, resp.StatusCode, header, resp.Body)
 }
@@ -0,0 +1,424 @@
+// bp_handler_test.go - プロキシリクエストの解釈とCONNECTトンネル（SSL Bump）でのkeep-aliveのテスト
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/headers"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
+)
+
+// echoGateway リクエストのメソッド・URL・ボディをそのまま返すゲートウェイ
+type echoGateway struct{}
+
+func (echoGateway) ProxyRequest(ctx context.Context, req *model.BpRequest, opts gateway.ProxyOptions) (*model.BpResponse, error) {
+	body := []byte(fmt.Sprintf("%s %s %s", req.Method, req.URL, req.Body))
+	return &model.BpResponse{
+		StatusCode: http.StatusOK,
+		// 実際の長さと異なるContent-Lengthが届いても、レスポンスの境界は崩れない
+		Headers:       map[string][]string{"Content-Length": {"1"}},
+		Body:          body,
+		ContentLength: 1,
+	}, nil
+}
+
+func (echoGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse { return nil }
+
+// hitRepository GETリクエストを常にキャッシュヒットとして返すリポジトリ
+type hitRepository struct {
+	repository.BpRepository
+}
+
+func (hitRepository) GetResponse(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	body := []byte("cached " + key)
+	return &model.BpResponse{StatusCode: http.StatusOK, Body: body, ContentLength: int64(len(body))}, true, nil
+}
+
+// recordingGateway 転送されたリクエストを記録するゲートウェイ
+type recordingGateway struct {
+	last *model.BpRequest
+}
+
+func (g *recordingGateway) ProxyRequest(ctx context.Context, req *model.BpRequest, opts gateway.ProxyOptions) (*model.BpResponse, error) {
+	g.last = req
+	return &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("ok"), ContentLength: 2}, nil
+}
+
+func (g *recordingGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse { return nil }
+
+// unavailableRepository キャッシュの取得に常に失敗するリポジトリ（GETもゲートウェイへ直接転送される）
+type unavailableRepository struct {
+	repository.BpRepository
+}
+
+func (unavailableRepository) GetResponse(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	return nil, false, errors.New("redis unavailable")
+}
+
+// serveProxyRequest main.goと同じくNoRouteでGetContentを呼ぶルーターでreqを処理し、ゲートウェイに届いたリクエストを返す
+func serveProxyRequest(t *testing.T, req *http.Request) (*httptest.ResponseRecorder, *model.BpRequest) {
+	t.Helper()
+	gw := &recordingGateway{}
+	h := NewBpHandler(service.NewBpService(gw, unavailableRepository{}, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec, gw.last
+}
+
+func TestGetContentAbsoluteForm(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/page?q=1", nil)
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	req.Header.Set("Connection", "keep-alive, X-Hop")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-Hop", "secret")
+	req.Header.Set("Accept", "text/html")
+
+	rec, got := serveProxyRequest(t, req)
+	if rec.Code != http.StatusOK || got == nil {
+		t.Fatalf("Expected the request to be proxied, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got.URL != "http://example.com/page?q=1" {
+		t.Errorf("Expected the absolute request URI, got %q", got.URL)
+	}
+	for _, name := range []string{"Proxy-Connection", "Connection", "Keep-Alive", "X-Hop"} {
+		if _, ok := got.Headers[name]; ok {
+			t.Errorf("Expected hop-by-hop header %s to be stripped", name)
+		}
+	}
+	if got.Headers["Accept"][0] != "text/html" {
+		t.Errorf("Expected end-to-end headers to be kept, got %v", got.Headers)
+	}
+	if via := http.Header(got.Headers).Get("Via"); via != headers.ViaValue {
+		t.Errorf("Expected Via %q, got %q", headers.ViaValue, via)
+	}
+	if xff := http.Header(got.Headers).Get("X-Forwarded-For"); xff != "192.0.2.1" {
+		t.Errorf("Expected X-Forwarded-For with the client address, got %q", xff)
+	}
+}
+
+func TestGetContentStripsResponseHopByHop(t *testing.T) {
+	svc := &fakeProxyService{
+		resp: &model.BpResponse{
+			StatusCode: http.StatusOK,
+			Headers: map[string][]string{
+				"Connection":        {"keep-alive, X-Origin-Hop"},
+				"Keep-Alive":        {"timeout=5"},
+				"Transfer-Encoding": {"chunked"},
+				"Upgrade":           {"h2c"},
+				"X-Origin-Hop":      {"1"},
+				"Etag":              {`"v1"`},
+			},
+			Body: []byte("cached"),
+		},
+		status: model.CacheHit,
+	}
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade", "X-Origin-Hop"} {
+		if v := rec.Header().Get(name); v != "" {
+			t.Errorf("Expected %s not to be sent to the client, got %q", name, v)
+		}
+	}
+	if rec.Header().Get("Etag") != `"v1"` || rec.Body.String() != "cached" {
+		t.Errorf("Expected end-to-end headers and body, got %v %q", rec.Header(), rec.Body.String())
+	}
+}
+
+func TestGetContentURLParameter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?url="+url.QueryEscape("https://example.org/a?b=c"), nil)
+	rec, got := serveProxyRequest(t, req)
+	if rec.Code != http.StatusOK || got == nil {
+		t.Fatalf("Expected the request to be proxied, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got.URL != "https://example.org/a?b=c" {
+		t.Errorf("Expected the url parameter, got %q", got.URL)
+	}
+}
+
+func TestGetContentOriginFormWithHost(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/docs/index.html?lang=ja", nil)
+	req.Host = "example.net"
+	rec, got := serveProxyRequest(t, req)
+	if rec.Code != http.StatusOK || got == nil {
+		t.Fatalf("Expected the request to be proxied, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got.URL != "http://example.net/docs/index.html?lang=ja" {
+		t.Errorf("Expected the URL to be rebuilt from Host, got %q", got.URL)
+	}
+}
+
+func TestGetContentRejectsRequestsToSelf(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Host = "localhost:8082"
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8082}
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+
+	rec, got := serveProxyRequest(t, req)
+	if rec.Code != http.StatusBadRequest || got != nil {
+		t.Errorf("Expected 400 without proxying, got %d", rec.Code)
+	}
+}
+
+// writeTestCA 自己署名のCA証明書と秘密鍵をdirに書き出し、CA証明書を返す
+func writeTestCA(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+
+	crtPath := filepath.Join(dir, "ca.crt")
+	keyPath := filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(crtPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return cert, crtPath, keyPath
+}
+
+// newTestProxy main.goと同じくCONNECTをbpHandlerで処理するプロキシサーバーを起動し、CA証明書を返す
+func newTestProxy(t *testing.T) (*httptest.Server, *x509.Certificate) {
+	t.Helper()
+	return newTestProxyWithFilter(t, nil)
+}
+
+// newTestProxyWithFilter ドメインの制限を指定してnewTestProxyと同じプロキシサーバーを起動する
+func newTestProxyWithFilter(t *testing.T, filter *module.DomainFilter) (*httptest.Server, *x509.Certificate) {
+	t.Helper()
+	return newTestProxyWithService(t, service.NewBpService(echoGateway{}, hitRepository{}, "", "", 0, model.DeliveryEstimate{}, true, nil), filter)
+}
+
+// newTestProxyWithService Service層とドメインの制限を指定してnewTestProxyと同じプロキシサーバーを起動する
+func newTestProxyWithService(t *testing.T, svc proxyService, filter *module.DomainFilter) (*httptest.Server, *x509.Certificate) {
+	t.Helper()
+	caCert, crtPath, keyPath := writeTestCA(t, t.TempDir())
+	bump, err := module.NewSSLBumpHandler(crtPath, keyPath, 10)
+	if err != nil {
+		t.Fatalf("NewSSLBumpHandler failed: %v", err)
+	}
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(bump, filter, nil), nil, 0, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if c.Request.Method == http.MethodConnect {
+			h.GetContent(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv, caCert
+}
+
+// dialTunnel プロキシにCONNECTしてexample.comとのTLS接続を確立する
+func dialTunnel(t *testing.T, proxyAddr string, caCert *x509.Certificate) *tls.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read CONNECT response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for CONNECT, got %d", resp.StatusCode)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: "example.com"})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	return tlsConn
+}
+
+func TestCONNECTServesMultipleRequestsPerTunnel(t *testing.T) {
+	srv, caCert := newTestProxy(t)
+	tlsConn := dialTunnel(t, srv.Listener.Addr().String(), caCert)
+	reader := bufio.NewReader(tlsConn)
+
+	requests := []struct {
+		method, path, body, want string
+	}{
+		{http.MethodGet, "/index.html", "", "cached "},
+		{http.MethodPost, "/api/submit", "hello", "POST https://example.com/api/submit hello"},
+		{http.MethodGet, "/style.css?v=2", "", "cached "},
+	}
+	for i, tc := range requests {
+		req, err := http.NewRequest(tc.method, "https://example.com"+tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		if err := req.Write(tlsConn); err != nil {
+			t.Fatalf("Request %d: write failed: %v", i+1, err)
+		}
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Fatalf("Request %d: failed to read response: %v", i+1, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Request %d: failed to read body: %v", i+1, err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), tc.want) {
+			t.Errorf("Request %d: expected 200 with %q, got %d with %q", i+1, tc.want, resp.StatusCode, body)
+		}
+		if resp.ContentLength != int64(len(body)) {
+			t.Errorf("Request %d: Content-Length %d does not match body length %d", i+1, resp.ContentLength, len(body))
+		}
+		if resp.Close {
+			t.Errorf("Request %d: expected the tunnel to stay open", i+1)
+		}
+	}
+}
+
+func TestCONNECTClosesTunnelOnConnectionClose(t *testing.T) {
+	srv, caCert := newTestProxy(t)
+	tlsConn := dialTunnel(t, srv.Listener.Addr().String(), caCert)
+	reader := bufio.NewReader(tlsConn)
+
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/bye", strings.NewReader("x"))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Close = true
+	if err := req.Write(tlsConn); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !resp.Close {
+		t.Error("Expected Connection: close in the response")
+	}
+
+	if _, err := reader.ReadByte(); err == nil {
+		t.Error("Expected the tunnel to be closed after Connection: close")
+	}
+}
+
+// fakeProxyService 指定したレスポンスとキャッシュ状態を返すService層
+type fakeProxyService struct {
+	resp   *model.BpResponse
+	status model.CacheStatus
+}
+
+func (s *fakeProxyService) ProxyRequestWithStatus(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
+	return s.resp, s.status, nil
+}
+
+func TestGetContentCacheHeaders(t *testing.T) {
+	cachedAt := time.Now().Add(-90 * time.Second)
+	tests := []struct {
+		name       string
+		status     model.CacheStatus
+		cachedAt   time.Time
+		xCache     string
+		age        string
+		retryAfter string
+	}{
+		{"hit", model.CacheHit, cachedAt, "HIT", "90", ""},
+		{"stale", model.CacheStale, cachedAt, "STALE", "90", ""},
+		{"miss-reserved", model.CacheMissReserved, time.Time{}, "MISS", "", "120"},
+		{"miss-placeholder", model.CacheMissPlaceholder, time.Time{}, "MISS", "", ""},
+		{"miss-direct", model.CacheMissDirect, time.Time{}, "MISS", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeProxyService{
+				resp:   &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("body"), CachedAt: tt.cachedAt},
+				status: tt.status,
+			}
+			h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 2*time.Minute, 0, 0, false, "")
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.NoRoute(h.GetContent)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("X-Cache"); got != tt.xCache {
+				t.Errorf("Expected X-Cache %q, got %q", tt.xCache, got)
+			}
+			if got := rec.Header().Get("X-Bp-Queue-Status"); got != string(tt.status) {
+				t.Errorf("Expected X-Bp-Queue-Status %q, got %q", tt.status, got)
+			}
+			if got := rec.Header().Get("Age"); got != tt.age {
+				t.Errorf("Expected Age %q, got %q", tt.age, got)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tt.retryAfter, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/headers"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// newBumpedResponse CONNECTトンネル（SSL Bump）内でreqに返すレスポンスを作る
+// 同じ接続で次のリクエストを読むため、レスポンスの境界（フレーミング）を次のように決める
+//   - StatusとStatusCodeを揃え、HTTP/1.1で返す
+//   - contentLengthが0以上の場合は実際のボディの長さとしてContent-Lengthにする（キャッシュしたヘッダーのContent-Lengthは使わない）
+//   - contentLengthが負（長さがわからない）の場合はchunkedで送る（HTTP/1.0のクライアントには送った後に接続を閉じる）
+//   - 接続を続ける場合はConnection: keep-alive、閉じる場合はConnection: close
+//
+// headerのホップ・バイ・ホップヘッダー（Transfer-Encoding、Connectionなど）とContent-Lengthは取り除く
+func newBumpedResponse(req *http.Request, statusCode int, header http.Header, body io.Reader, contentLength int64) *http.Response {
+	h := headers.StripHopByHop(header)
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Del("Content-Length")
+	if id := requestid.FromContext(req.Context()); id != "" {
+		h.Set(requestid.Header, id)
+	}
+
+	text := http.StatusText(statusCode)
+	if text == "" {
+		text = fmt.Sprintf("status code %d", statusCode)
+	}
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, text),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     h,
+		Request:    req, // HEADリクエストではボディを書き込まない
+		Close:      req.Close,
+	}
+
+	switch {
+	case body == nil || contentLength == 0:
+		resp.Body = http.NoBody
+		resp.ContentLength = 0
+	case contentLength > 0:
+		resp.Body = io.NopCloser(io.LimitReader(body, contentLength))
+		resp.ContentLength = contentLength
+	case req.ProtoAtLeast(1, 1):
+		resp.Body = io.NopCloser(body)
+		resp.ContentLength = -1
+		resp.TransferEncoding = []string{"chunked"}
+	default:
+		// HTTP/1.0はchunkedを解釈できないため、接続を閉じてボディの終わりを伝える
+		resp.Body = io.NopCloser(body)
+		resp.ContentLength = -1
+		resp.Close = true
+	}
+
+	if !resp.Close {
+		h.Set("Connection", "keep-alive")
+	}
+	return resp
+}
+
+// writeBumpedResponse reqへのレスポンス（ボディは読み込み済み）をクライアント（TLS接続）に書き込む
+// 同じ接続で次のリクエストを読める場合はtrueを返す
+func writeBumpedResponse(req *http.Request, w *bufio.Writer, statusCode int, header http.Header, body []byte) bool {
+	return writeBumped(w, newBumpedResponse(req, statusCode, header, bytes.NewReader(body), int64(len(body))))
+}
+
+// writeBumped newBumpedResponseで作ったレスポンスを書き込む
+func writeBumped(w *bufio.Writer, resp *http.Response) bool {
+	if err := resp.Write(w); err != nil {
+		log.Printf("[BpHandler] Failed to write response: %v", err)
+		return false
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("[BpHandler] Failed to write response: %v", err)
+		return false
+	}
+	return !resp.Close
+}
@@ -0,0 +1,139 @@
+// bumped_response_test.go - CONNECTトンネル内のレスポンスのフレーミング（Status・Content-Length・chunked・Connection）のテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// writeAndRead レスポンスを書き込み、同じ接続の続きとしてもう1つレスポンスを書いてから両方を読み直す
+// 1つ目のフレーミングが間違っていると、2つ目のレスポンスを正しく読めない
+func writeAndRead(t *testing.T, req *http.Request, resp *http.Response) (*http.Response, []byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeBumped(w, resp)
+	raw := buf.String()
+	next := httptest.NewRequest(http.MethodGet, "https://example.com/next", nil)
+	writeBumpedResponse(next, w, http.StatusOK, nil, []byte("next"))
+
+	r := bufio.NewReader(&buf)
+	got, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("invalid response: %v\n%s", err, raw)
+	}
+	body, err := io.ReadAll(got.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if got.Close {
+		return got, body, raw
+	}
+	second, err := http.ReadResponse(r, next)
+	if err != nil {
+		t.Fatalf("second response misframed: %v\n%s", err, raw)
+	}
+	if b, _ := io.ReadAll(second.Body); string(b) != "next" {
+		t.Fatalf("second response misframed: body %q\n%s", b, raw)
+	}
+	return got, body, raw
+}
+
+func TestBumpedResponseKnownLength(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	// キャッシュしたヘッダーの古いContent-Lengthやホップ・バイ・ホップヘッダーは使わない
+	stale := http.Header{"Content-Length": {"999"}, "Transfer-Encoding": {"chunked"}, "Content-Type": {"text/plain"}}
+	got, body, raw := writeAndRead(t, req, newBumpedResponse(req, http.StatusOK, stale, strings.NewReader("hello"), 5))
+
+	if !strings.HasPrefix(raw, "HTTP/1.1 200 OK\r\n") {
+		t.Errorf("expected status line with text, got %q", strings.SplitN(raw, "\r\n", 2)[0])
+	}
+	if got.ContentLength != 5 || string(body) != "hello" || len(got.TransferEncoding) != 0 {
+		t.Errorf("expected Content-Length 5 with body hello, got %d %q %v", got.ContentLength, body, got.TransferEncoding)
+	}
+	if got.Header.Get("Connection") != "keep-alive" || got.Close {
+		t.Errorf("expected Connection: keep-alive, got %q", got.Header.Get("Connection"))
+	}
+}
+
+func TestBumpedResponseEmptyBody(t *testing.T) {
+	for _, code := range []int{http.StatusOK, http.StatusNotModified, http.StatusNoContent} {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		got, body, raw := writeAndRead(t, req, newBumpedResponse(req, code, nil, nil, 0))
+		if got.StatusCode != code || len(body) != 0 {
+			t.Errorf("%d: expected empty body, got %d %q", code, got.StatusCode, body)
+		}
+		hasLength := strings.Contains(raw, "Content-Length: 0\r\n")
+		if code == http.StatusOK && !hasLength {
+			t.Errorf("200: expected Content-Length: 0, got %q", raw)
+		}
+		if code != http.StatusOK && (hasLength || strings.Contains(raw, "Transfer-Encoding")) {
+			t.Errorf("%d: expected no body framing headers, got %q", code, raw)
+		}
+	}
+}
+
+func TestBumpedResponseUnknownLength(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	got, body, _ := writeAndRead(t, req, newBumpedResponse(req, http.StatusOK, nil, strings.NewReader("streamed body"), -1))
+	if len(got.TransferEncoding) != 1 || got.TransferEncoding[0] != "chunked" || string(body) != "streamed body" {
+		t.Errorf("expected chunked body, got %v %q", got.TransferEncoding, body)
+	}
+	if got.Close {
+		t.Error("expected the connection to stay open with chunked encoding")
+	}
+
+	// HTTP/1.0はchunkedを解釈できないため、接続を閉じてボディの終わりを伝える
+	old := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	old.Proto, old.ProtoMajor, old.ProtoMinor = "HTTP/1.0", 1, 0
+	resp := newBumpedResponse(old, http.StatusOK, nil, strings.NewReader("until close"), -1)
+	var buf bytes.Buffer
+	if writeBumped(bufio.NewWriter(&buf), resp) {
+		t.Error("expected the connection to be closed for HTTP/1.0 with unknown length")
+	}
+	got, err := http.ReadResponse(bufio.NewReader(&buf), old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(got.Body); string(b) != "until close" || len(got.TransferEncoding) != 0 || !got.Close {
+		t.Errorf("expected close-delimited body, got %q %v close=%v", b, got.TransferEncoding, got.Close)
+	}
+}
+
+func TestBumpedResponseHead(t *testing.T) {
+	req := httptest.NewRequest(http.MethodHead, "https://example.com/", nil)
+	got, body, _ := writeAndRead(t, req, newBumpedResponse(req, http.StatusOK, nil, strings.NewReader("hello"), 5))
+	if got.ContentLength != 5 || len(body) != 0 {
+		t.Errorf("expected Content-Length 5 without body for HEAD, got %d %q", got.ContentLength, body)
+	}
+}
+
+func TestBumpedResponseClose(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	req.Close = true
+	var buf bytes.Buffer
+	if writeBumpedResponse(req, bufio.NewWriter(&buf), http.StatusOK, nil, []byte("bye")) {
+		t.Error("expected writeBumpedResponse to report the connection as closed")
+	}
+	raw := buf.String()
+	got, err := http.ReadResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Close || !strings.Contains(raw, "Connection: close\r\n") || strings.Contains(raw, "keep-alive") {
+		t.Errorf("expected Connection: close, got %q", raw)
+	}
+}
+
+func TestBumpedResponseUnknownStatusText(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	got, _, raw := writeAndRead(t, req, newBumpedResponse(req, 599, nil, nil, 0))
+	if got.StatusCode != 599 || !strings.HasPrefix(raw, "HTTP/1.1 599 status code 599\r\n") {
+		t.Errorf("expected status text for unknown code, got %q", strings.SplitN(raw, "\r\n", 2)[0])
+	}
+}
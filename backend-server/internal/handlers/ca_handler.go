@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// caCertContentType CA証明書の配布に使うContent-Type（ブラウザ・Androidが証明書のインストールとして扱う）
+const caCertContentType = "application/x-x509-ca-cert"
+
+// caHandler SSL Bumpに使うCA証明書をクライアント端末に配布する
+// SSL Bumpの初期化に失敗していても配布できるよう、SSLBumpHandlerではなく証明書ファイルを直接読む
+type caHandler struct {
+	certPath string
+}
+
+// NewCAHandler certPathはCA証明書のファイル（Middlware.CertPath）
+func NewCAHandler(certPath string) *caHandler {
+	return &caHandler{certPath: certPath}
+}
+
+// loadCACert 証明書ファイルからCA証明書を読む
+// ファイルに秘密鍵が一緒に入っていても、CERTIFICATEブロック以外は読み飛ばす（秘密鍵は決して返さない）
+func (ch *caHandler) loadCACert() (*x509.Certificate, error) {
+	data, err := os.ReadFile(ch.certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA cert: %w", err)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no CERTIFICATE block in CA cert file")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA cert: %w", err)
+		}
+		return cert, nil
+	}
+}
+
+// GetCACertPEM CA証明書をPEM形式で返す
+// GET /system/ca.crt
+func (ch *caHandler) GetCACertPEM(c *gin.Context) {
+	cert, ok := ch.caCertOrError(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="bp-proxy-ca.crt"`)
+	c.Data(http.StatusOK, caCertContentType, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// GetCACertDER CA証明書をDER形式で返す（AndroidはDER形式のみインストールできる）
+// GET /system/ca.der
+func (ch *caHandler) GetCACertDER(c *gin.Context) {
+	cert, ok := ch.caCertOrError(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="bp-proxy-ca.der"`)
+	c.Data(http.StatusOK, caCertContentType, cert.Raw)
+}
+
+// GetSetupPage CA証明書のダウンロードリンクとインストール手順のページを返す
+// GET /system/setup
+func (ch *caHandler) GetSetupPage(c *gin.Context) {
+	cert, ok := ch.caCertOrError(c)
+	if !ok {
+		return
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	hexPairs := make([]string, len(sum))
+	for i, b := range sum {
+		hexPairs[i] = fmt.Sprintf("%02X", b)
+	}
+
+	var buf bytes.Buffer
+	err := setupPageTemplate.Execute(&buf, map[string]any{
+		"Subject":     cert.Subject.String(),
+		"NotAfter":    cert.NotAfter.Format("2006-01-02"),
+		"Fingerprint": strings.Join(hexPairs, ":"),
+	})
+	if err != nil {
+		log.Printf("[CAHandler] Failed to render setup page: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to render setup page")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// caCertOrError CA証明書を読み、読めない場合は503を返してokをfalseにする
+func (ch *caHandler) caCertOrError(c *gin.Context) (*x509.Certificate, bool) {
+	cert, err := ch.loadCACert()
+	if err != nil {
+		log.Printf("[CAHandler] %v", err)
+		c.String(http.StatusServiceUnavailable, "CA certificate is not available")
+		return nil, false
+	}
+	return cert, true
+}
+
+var setupPageTemplate = template.Must(template.New("setup").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>プロキシの証明書のインストール</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.6; }
+        code { word-break: break-all; }
+        .download a { display: inline-block; margin: 0.5rem 1rem 0.5rem 0; padding: 0.5rem 1rem; border: 1px solid #667eea; border-radius: 4px; text-decoration: none; }
+    </style>
+</head>
+<body>
+    <h1>プロキシの証明書のインストール</h1>
+    <p>HTTPSのページをDTN経由で閲覧するには、この端末にプロキシのCA証明書をインストールして信頼する必要があります。</p>
+    <div class="download">
+        <a href="/system/ca.crt">ca.crt（PEM形式）</a>
+        <a href="/system/ca.der">ca.der（Android用、DER形式）</a>
+    </div>
+    <p>発行者: <code>{{.Subject}}</code><br>
+    有効期限: {{.NotAfter}}<br>
+    SHA-256フィンガープリント: <code>{{.Fingerprint}}</code></p>
+    <p>インストールする前に、フィンガープリントが管理者から案内された値と一致することを確認してください。</p>
+
+    <h2>macOS</h2>
+    <ol>
+        <li>ca.crtをダウンロードして開き、キーチェーンアクセスの「システム」に追加します。</li>
+        <li>追加した証明書を開き、「信頼」を「常に信頼」に変更します。</li>
+    </ol>
+    <h2>iOS / iPadOS</h2>
+    <ol>
+        <li>Safariでca.crtを開き、プロファイルをダウンロードします。</li>
+        <li>設定 &gt; 一般 &gt; VPNとデバイス管理 からプロファイルをインストールします。</li>
+        <li>設定 &gt; 一般 &gt; 情報 &gt; 証明書信頼設定 で証明書を有効にします。</li>
+    </ol>
+    <h2>Android</h2>
+    <ol>
+        <li>ca.derをダウンロードします。</li>
+        <li>設定 &gt; セキュリティ &gt; 暗号化と認証情報 &gt; 証明書のインストール &gt; CA証明書 から選択します。</li>
+    </ol>
+    <h2>Windows</h2>
+    <ol>
+        <li>ca.crtをダウンロードして開き、「証明書のインストール」を選びます。</li>
+        <li>「ローカル コンピューター」を選び、「信頼されたルート証明機関」に配置します。</li>
+    </ol>
+    <h2>Linux</h2>
+    <ol>
+        <li>ca.crtを /usr/local/share/ca-certificates/ にコピーし、<code>sudo update-ca-certificates</code> を実行します。</li>
+        <li>Firefoxは独自の証明書ストアを使うため、設定 &gt; プライバシーとセキュリティ &gt; 証明書を表示 からも読み込みます。</li>
+    </ol>
+</body>
+</html>
+`))
@@ -0,0 +1,132 @@
+// ca_handler_test.go - CA証明書の配布エンドポイントのテスト
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
+)
+
+func serveCA(t *testing.T, certPath, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewCAHandler(certPath)
+	r.GET("/system/ca.crt", h.GetCACertPEM)
+	r.GET("/system/ca.der", h.GetCACertDER)
+	r.GET("/system/setup", h.GetSetupPage)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestCACertMatchesBumpHandler(t *testing.T) {
+	_, crtPath, keyPath := writeTestCA(t, t.TempDir())
+	bump, err := module.NewSSLBumpHandler(crtPath, keyPath, 10)
+	if err != nil {
+		t.Fatalf("NewSSLBumpHandler failed: %v", err)
+	}
+	loaded := bump.CACertificate()
+
+	rec := serveCA(t, crtPath, "/system/ca.crt")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-x509-ca-cert" {
+		t.Errorf("Expected application/x-x509-ca-cert, got %q", ct)
+	}
+	block, rest := pem.Decode(rec.Body.Bytes())
+	if block == nil || block.Type != "CERTIFICATE" || len(strings.TrimSpace(string(rest))) != 0 {
+		t.Fatalf("Expected a single CERTIFICATE block, got:\n%s", rec.Body.String())
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+	if !cert.Equal(loaded) {
+		t.Errorf("Expected the served PEM to be the bump handler's CA (%s), got %s", loaded.Subject, cert.Subject)
+	}
+
+	rec = serveCA(t, crtPath, "/system/ca.der")
+	if ct := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || ct != "application/x-x509-ca-cert" {
+		t.Fatalf("Expected 200 application/x-x509-ca-cert, got %d %q", rec.Code, ct)
+	}
+	cert, err = x509.ParseCertificate(rec.Body.Bytes())
+	if err != nil || !cert.Equal(loaded) {
+		t.Errorf("Expected the served DER to be the bump handler's CA (err=%v)", err)
+	}
+}
+
+func TestCACertNeverExposesPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	_, crtPath, keyPath := writeTestCA(t, dir)
+	// 秘密鍵を証明書と同じファイルに入れている構成（鍵が先頭）
+	keyPEM, _ := os.ReadFile(keyPath)
+	crtPEM, _ := os.ReadFile(crtPath)
+	bundle := filepath.Join(dir, "bundle.pem")
+	if err := os.WriteFile(bundle, append(keyPEM, crtPEM...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/system/ca.crt", "/system/ca.der", "/system/setup"} {
+		rec := serveCA(t, bundle, path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d", path, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "PRIVATE KEY") {
+			t.Errorf("Expected %s not to contain the private key", path)
+		}
+	}
+}
+
+func TestSetupPage(t *testing.T) {
+	_, crtPath, _ := writeTestCA(t, t.TempDir())
+	rec := serveCA(t, crtPath, "/system/setup")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected 200 text/html, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`href="/system/ca.crt"`, `href="/system/ca.der"`, "CN=Test CA", "SHA-256"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected setup page to contain %q", want)
+		}
+	}
+}
+
+func TestCAEndpointsWithoutBump(t *testing.T) {
+	// SSL Bumpの初期化に失敗した（鍵がない）場合でも証明書は配布できる
+	_, crtPath, keyPath := writeTestCA(t, t.TempDir())
+	os.Remove(keyPath)
+	if _, err := module.NewSSLBumpHandler(crtPath, keyPath, 10); err == nil {
+		t.Fatal("Expected NewSSLBumpHandler to fail without the key")
+	}
+	if rec := serveCA(t, crtPath, "/system/ca.crt"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+
+	// 証明書自体がない場合は503
+	if rec := serveCA(t, filepath.Join(t.TempDir(), "missing.crt"), "/system/ca.crt"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a certificate, got %d", rec.Code)
+	}
+}
+
+func TestCONNECTWithoutBump(t *testing.T) {
+	h := NewBpHandler(&fakeProxyService{}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodConnect, "http://example.com:443", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for CONNECT without SSL bump, got %d", rec.Code)
+	}
+}
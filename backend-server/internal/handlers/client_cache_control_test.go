@@ -0,0 +1,91 @@
+// client_cache_control_test.go - クライアントのCache-Control・Pragmaに従ってキャッシュの更新を予約する（または直接転送する）ことのテスト
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+func TestClientCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       http.Header
+		honorNoStore bool
+		xCache       string
+		status       string
+		body         string
+		reserved     int
+		direct       bool
+	}{
+		{"no directives", nil, false, "HIT", "hit", "old news", 0, false},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, false, "REVALIDATING", "revalidating", "old news", 1, false},
+		{"max-age zero", http.Header{"Cache-Control": {"max-age=0"}}, false, "REVALIDATING", "revalidating", "old news", 1, false},
+		{"pragma", http.Header{"Pragma": {"no-cache"}}, false, "REVALIDATING", "revalidating", "old news", 1, false},
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, false, "HIT", "hit", "old news", 0, false},
+		// no-storeは設定で有効にした場合だけキャッシュを使わずに直接転送する
+		{"no-store ignored", http.Header{"Cache-Control": {"no-store"}}, false, "HIT", "hit", "old news", 0, false},
+		{"no-store honored", http.Header{"Cache-Control": {"no-store"}}, true, "MISS", "miss-direct", "ok", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := model.HonorRequestNoStore
+			model.HonorRequestNoStore = tt.honorNoStore
+			defer func() { model.HonorRequestNoStore = saved }()
+
+			gw := &recordingGateway{}
+			repo := fakes.NewRepository(0)
+			news := &model.BpRequest{Method: http.MethodGet, URL: "http://example.com/news.html"}
+			if err := repo.SetResponseWithURL(context.Background(), news, &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("old news")}, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			h := NewBpHandler(service.NewBpService(gw, repo, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.NoRoute(h.GetContent)
+
+			// 何度再読み込みしても更新の予約は1つだけ
+			for range 3 {
+				req := httptest.NewRequest(http.MethodGet, "http://example.com/news.html", nil)
+				for key, values := range tt.header {
+					req.Header[key] = values
+				}
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected 200, got %d", rec.Code)
+				}
+				if got := rec.Header().Get("X-Cache"); got != tt.xCache {
+					t.Errorf("Expected X-Cache %s, got %q", tt.xCache, got)
+				}
+				if got := rec.Header().Get("X-Bp-Queue-Status"); got != tt.status {
+					t.Errorf("Expected status %s, got %q", tt.status, got)
+				}
+				if rec.Body.String() != tt.body {
+					t.Errorf("Expected body %q, got %q", tt.body, rec.Body.String())
+				}
+				if tt.status == "revalidating" && rec.Header().Get("Age") == "" {
+					t.Error("Expected Age on a revalidating response")
+				}
+			}
+			reserved, _ := repo.GetReservedRequests(context.Background())
+			if len(reserved) != tt.reserved {
+				t.Fatalf("Expected %d reservations, got %d", tt.reserved, len(reserved))
+			}
+			if tt.reserved > 0 && !reserved[0].WantsRefresh() {
+				t.Error("Expected the reservation to carry the client's refresh request")
+			}
+			if got := gw.last != nil; got != tt.direct {
+				t.Errorf("Expected direct=%v, got %v", tt.direct, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// coalesce_test.go - 同じページへの同時のキャッシュミスを1つの予約にまとめることのテスト
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+func TestConcurrentMissesReserveOnce(t *testing.T) {
+	const clients = 50
+	repo := fakes.NewRepository(0)
+	h := NewBpHandler(service.NewBpService(echoGateway{}, repo, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+
+	var wg sync.WaitGroup
+	statuses := make([]string, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/popular.html", nil))
+			statuses[i] = rec.Header().Get("X-Bp-Queue-Status")
+		}()
+	}
+	wg.Wait()
+
+	if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != 1 {
+		t.Fatalf("Expected exactly 1 queue entry, got %d", len(reserved))
+	}
+	for i, status := range statuses {
+		if status != string(model.CacheMissReserved) {
+			t.Errorf("Expected client %d to wait for the reservation, got %q", i, status)
+		}
+	}
+
+	// 予約済みの間は最初の予約の時刻を返す
+	req := &model.BpRequest{Method: http.MethodGet, URL: "http://example.com/popular.html", Headers: map[string][]string{}}
+	again, err := repo.ReserveRequest(context.Background(), req)
+	if err != nil || again.Queued {
+		t.Fatalf("Expected the URL to be already queued, got %+v (%v)", again, err)
+	}
+	if again.ReservedAt.IsZero() || time.Since(again.ReservedAt) > time.Minute {
+		t.Errorf("Expected the time of the first reservation, got %v", again.ReservedAt)
+	}
+
+	// ワーカーが取り出しても、処理を終えて予約を削除するまでは予約し直さない
+	popped, err := repo.BLPopReservedRequest(context.Background(), time.Second)
+	if err != nil || popped == nil {
+		t.Fatalf("Failed to pop the reservation: %v", err)
+	}
+	if got, _ := repo.ReserveRequest(context.Background(), req); got.Queued {
+		t.Error("Expected a reservation being processed not to be queued again")
+	}
+	if err := repo.RemoveReservedRequest(context.Background(), popped); err != nil {
+		t.Fatalf("RemoveReservedRequest failed: %v", err)
+	}
+	if got, _ := repo.ReserveRequest(context.Background(), req); !got.Queued {
+		t.Error("Expected the URL to be queued again after the worker finished")
+	}
+	if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != 1 {
+		t.Errorf("Expected 1 queue entry after reserving again, got %d", len(reserved))
+	}
+}
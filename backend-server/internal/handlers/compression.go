@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// minCompressSize これより小さいボディは圧縮しない（gzipのヘッダーの分だけ大きくなることがある）
+const minCompressSize = 256
+
+// compressibleTypes 圧縮するメディアタイプ（text/*と+json・+xmlの構造化構文は別に判定する）
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/xml":           true,
+	"application/xhtml+xml":     true,
+	"image/svg+xml":             true,
+	"text/javascript":           true,
+}
+
+// isCompressible メディアタイプが圧縮に向いているか（画像・動画・圧縮済みの形式は圧縮しない）
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// acceptsGzip Accept-Encodingでgzipを受け付けているか（q=0は拒否）
+func acceptsGzip(header http.Header) bool {
+	accepted := false
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+				continue
+			}
+			q := 1.0
+			if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+			if coding == "*" {
+				// gzipが明示されていればそちらを優先する
+				accepted = accepted || q > 0
+				continue
+			}
+			return q > 0
+		}
+	}
+	return accepted
+}
+
+// withCompression クライアントがgzipを受け付け、圧縮に向いたレスポンスの場合はボディをgzipで圧縮したコピーを返す
+// Content-EncodingとVaryを設定し、元のContent-Lengthは取り除く（圧縮後の長さは書き込み時に決まる）
+// すでにエンコードされているボディ（キャッシュしたgzipなど）や画像はそのまま返す
+func withCompression(resp *model.BpResponse, header http.Header) *model.BpResponse {
+	if resp == nil || len(resp.Body) < minCompressSize {
+		return resp
+	}
+	if resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return resp
+	}
+	respHeader := http.Header(resp.Headers)
+	if encoding := respHeader.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return resp
+	}
+	contentType := respHeader.Get("Content-Type")
+	if contentType == "" {
+		contentType = resp.ContentType
+	}
+	if !isCompressible(contentType) {
+		return resp
+	}
+
+	// 圧縮するかどうかはAccept-Encodingで変わるため、共有キャッシュに伝える
+	compressed := *resp
+	compressed.Headers = respHeader.Clone()
+	if compressed.Headers == nil {
+		compressed.Headers = make(map[string][]string)
+	}
+	addVary(http.Header(compressed.Headers), "Accept-Encoding")
+	if !acceptsGzip(header) {
+		return &compressed
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(resp.Body); err != nil {
+		log.Printf("[BpHandler] Failed to gzip response: %v", err)
+		return resp
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("[BpHandler] Failed to gzip response: %v", err)
+		return resp
+	}
+
+	h := http.Header(compressed.Headers)
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	// 圧縮したボディからContent-Typeを推測されないよう、明示する
+	h.Set("Content-Type", contentType)
+	compressed.Body = buf.Bytes()
+	compressed.ContentLength = int64(buf.Len())
+	return &compressed
+}
+
+// addVary Varyヘッダーにnameがなければ追加する
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,168 @@
+// compression_test.go - クライアントへのレスポンスのgzip圧縮のテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+var compressibleBody = []byte(strings.Repeat("<p>DTN経由で届いたページ</p>\n", 100))
+
+func cachedResponse(contentType string, body []byte, extra map[string][]string) *model.BpResponse {
+	headers := map[string][]string{
+		"Content-Type":   {contentType},
+		"Content-Length": {"999"},
+	}
+	for key, values := range extra {
+		headers[key] = values
+	}
+	return &model.BpResponse{StatusCode: http.StatusOK, Headers: headers, Body: body, ContentType: contentType, ContentLength: int64(len(body))}
+}
+
+func serveCompressed(t *testing.T, resp *model.BpResponse, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := NewBpHandler(&fakeProxyService{resp: resp, status: model.CacheHit}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/page", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip.NewReader failed: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	return out
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, contentType := range []string{"text/html; charset=utf-8", "application/json", "application/ld+json", "image/svg+xml"} {
+		rec := serveCompressed(t, cachedResponse(contentType, compressibleBody, nil), "gzip, deflate, br")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", contentType, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("%s: expected Content-Encoding gzip, got %q", contentType, got)
+		}
+		if got := rec.Header().Get("Content-Length"); got == "999" {
+			t.Errorf("%s: expected the original Content-Length to be removed", contentType)
+		}
+		if got := rec.Header().Get("Content-Type"); got != contentType {
+			t.Errorf("%s: expected Content-Type to be kept, got %q", contentType, got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: expected Vary: Accept-Encoding, got %q", contentType, got)
+		}
+		if rec.Body.Len() >= len(compressibleBody) {
+			t.Errorf("%s: expected the body to shrink, got %d bytes", contentType, rec.Body.Len())
+		}
+		if got := gunzip(t, rec.Body.Bytes()); !bytes.Equal(got, compressibleBody) {
+			t.Errorf("%s: decompressed body does not match the cached body", contentType)
+		}
+	}
+}
+
+func TestCompressionSkipped(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1024)...)
+	gzipped := func() []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(compressibleBody)
+		zw.Close()
+		return buf.Bytes()
+	}()
+	tests := []struct {
+		name           string
+		resp           *model.BpResponse
+		acceptEncoding string
+	}{
+		{"image", cachedResponse("image/png", png, nil), "gzip"},
+		{"already encoded", cachedResponse("text/html", gzipped, map[string][]string{"Content-Encoding": {"gzip"}}), "gzip"},
+		{"not accepted", cachedResponse("text/html", compressibleBody, nil), ""},
+		{"gzip refused", cachedResponse("text/html", compressibleBody, nil), "gzip;q=0, br"},
+		{"small body", cachedResponse("text/html", []byte("<p>short</p>"), nil), "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveCompressed(t, tt.resp, tt.acceptEncoding)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", rec.Code)
+			}
+			if got, want := rec.Header().Get("Content-Encoding"), http.Header(tt.resp.Headers).Get("Content-Encoding"); got != want {
+				t.Errorf("Expected Content-Encoding %q, got %q", want, got)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tt.resp.Body) {
+				t.Errorf("Expected the body to be served unchanged")
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"gzip":               true,
+		"GZIP;q=0.5":         true,
+		"deflate, br":        false,
+		"gzip;q=0":           false,
+		"*":                  true,
+		"*;q=0":              false,
+		"*, gzip;q=0":        false,
+		"br;q=1, gzip;q=0.1": true,
+	}
+	for value, want := range tests {
+		if got := acceptsGzip(http.Header{"Accept-Encoding": {value}}); got != want {
+			t.Errorf("acceptsGzip(%q): expected %v, got %v", value, want, got)
+		}
+	}
+}
+
+func TestCompressionInTunnel(t *testing.T) {
+	h := NewBpHandler(&fakeProxyService{resp: cachedResponse("application/json", compressibleBody, nil), status: model.CacheHit}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/api/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	var buf bytes.Buffer
+	if !h.serveBumpedRequest(req, bufio.NewWriter(&buf)) {
+		t.Fatal("Expected the tunnel to stay open")
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected Content-Encoding gzip, got %v", resp.Header)
+	}
+	// keep-aliveで次のレスポンスの境界がずれないよう、Content-Lengthは圧縮後の長さ
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("Content-Length %d does not match the compressed length %d", resp.ContentLength, len(body))
+	}
+	if got := gunzip(t, body); !bytes.Equal(got, compressibleBody) {
+		t.Error("Decompressed body does not match the cached body")
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// notModifiedHeaders 304 Not Modifiedでも返すヘッダー（RFC 9110 15.4.5）
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// notModified キャッシュから返すレスポンスに対して、クライアントの条件付きリクエスト（If-None-Match / If-Modified-Since）が一致するか
+// 一致する場合はボディを返さずに304を返せる
+// 弱いETag（W/）は強い比較では一致しないため、バリデーターがない場合と同じく通常のレスポンスを返す
+func notModified(method string, header http.Header, resp *model.BpResponse, status model.CacheStatus) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+	if !status.IsHit() || resp == nil || resp.StatusCode != http.StatusOK {
+		return false
+	}
+	cached := http.Header(resp.Headers)
+
+	// If-None-Matchがある場合はIf-Modified-Sinceを無視する（RFC 9110 13.2.2）
+	if inm := header.Values("If-None-Match"); len(inm) > 0 {
+		etag := cached.Get("ETag")
+		if !isStrongETag(etag) {
+			return false
+		}
+		for _, value := range inm {
+			for _, tag := range strings.Split(value, ",") {
+				tag = strings.TrimSpace(tag)
+				if tag == "*" || tag == etag {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	ims := header.Get("If-Modified-Since")
+	lastModified := cached.Get("Last-Modified")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// isStrongETag 強いETag（"xyz"）か
+func isStrongETag(etag string) bool {
+	return len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"'
+}
+
+// notModifiedHeader 304 Not Modifiedで返すヘッダーをキャッシュしたレスポンスから取り出す
+func notModifiedHeader(resp *model.BpResponse) http.Header {
+	cached := http.Header(resp.Headers)
+	h := make(http.Header)
+	for _, name := range notModifiedHeaders {
+		if values := cached.Values(name); len(values) > 0 {
+			h[name] = values
+		}
+	}
+	return h
+}
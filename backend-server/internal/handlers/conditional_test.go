@@ -0,0 +1,181 @@
+// conditional_test.go - キャッシュに対する条件付きリクエスト（If-None-Match / If-Modified-Since）のテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+var testLastModified = time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// cachedWithValidators ETagとLast-Modifiedを持つキャッシュ済みのレスポンス
+func cachedWithValidators(etag string) *model.BpResponse {
+	headers := map[string][]string{
+		"Last-Modified": {testLastModified.Format(http.TimeFormat)},
+		"Cache-Control": {"max-age=3600"},
+		"Content-Type":  {"text/css"},
+	}
+	if etag != "" {
+		headers["Etag"] = []string{etag}
+	}
+	return &model.BpResponse{
+		StatusCode:    http.StatusOK,
+		Headers:       headers,
+		Body:          []byte("body { color: red; }"),
+		ContentType:   "text/css",
+		ContentLength: 20,
+		CachedAt:      time.Now(),
+	}
+}
+
+func serveConditional(t *testing.T, resp *model.BpResponse, status model.CacheStatus, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	h := NewBpHandler(&fakeProxyService{resp: resp, status: status}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/style.css", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConditionalRequests(t *testing.T) {
+	imsAfter := testLastModified.Add(time.Hour).Format(http.TimeFormat)
+	imsBefore := testLastModified.Add(-time.Hour).Format(http.TimeFormat)
+	tests := []struct {
+		name   string
+		etag   string
+		status model.CacheStatus
+		header http.Header
+		want   int
+	}{
+		{"etag match", `"v1"`, model.CacheHit, http.Header{"If-None-Match": {`"v1"`}}, http.StatusNotModified},
+		{"etag in list", `"v1"`, model.CacheHit, http.Header{"If-None-Match": {`"v0", "v1"`}}, http.StatusNotModified},
+		{"etag wildcard", `"v1"`, model.CacheStale, http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{"etag mismatch", `"v1"`, model.CacheHit, http.Header{"If-None-Match": {`"v2"`}}, http.StatusOK},
+		{"weak cached etag", `W/"v1"`, model.CacheHit, http.Header{"If-None-Match": {`W/"v1"`}}, http.StatusOK},
+		{"weak client etag", `"v1"`, model.CacheHit, http.Header{"If-None-Match": {`W/"v1"`}}, http.StatusOK},
+		// If-None-Matchが一致しない場合はIf-Modified-Sinceを見ない
+		{"etag mismatch ignores ims", `"v1"`, model.CacheHit, http.Header{"If-None-Match": {`"v2"`}, "If-Modified-Since": {imsAfter}}, http.StatusOK},
+		{"not modified since", "", model.CacheHit, http.Header{"If-Modified-Since": {imsAfter}}, http.StatusNotModified},
+		{"same time", "", model.CacheHit, http.Header{"If-Modified-Since": {testLastModified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{"modified since", "", model.CacheHit, http.Header{"If-Modified-Since": {imsBefore}}, http.StatusOK},
+		{"invalid ims", "", model.CacheHit, http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+		{"no validators", `"v1"`, model.CacheHit, nil, http.StatusOK},
+		{"cache miss", `"v1"`, model.CacheMissDirect, http.Header{"If-None-Match": {`"v1"`}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveConditional(t, cachedWithValidators(tt.etag), tt.status, tt.header)
+			if rec.Code != tt.want {
+				t.Fatalf("Expected %d, got %d", tt.want, rec.Code)
+			}
+			if tt.want == http.StatusNotModified {
+				if rec.Body.Len() != 0 {
+					t.Errorf("Expected an empty body for 304, got %q", rec.Body.String())
+				}
+				if rec.Header().Get("Cache-Control") != "max-age=3600" || rec.Header().Get("X-Cache") == "" {
+					t.Errorf("Expected validator and cache headers on 304, got %v", rec.Header())
+				}
+				if rec.Header().Get("Content-Type") != "" {
+					t.Errorf("Expected no Content-Type on 304, got %q", rec.Header().Get("Content-Type"))
+				}
+			} else if rec.Body.String() != "body { color: red; }" {
+				t.Errorf("Expected the full cached body, got %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestConditionalRequestInTunnel(t *testing.T) {
+	h := NewBpHandler(&fakeProxyService{resp: cachedWithValidators(`"v1"`), status: model.CacheHit}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/style.css", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+
+	var buf bytes.Buffer
+	if !h.serveBumpedRequest(req, bufio.NewWriter(&buf)) {
+		t.Fatal("Expected the tunnel to stay open after a 304")
+	}
+	raw := buf.String()
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("Expected 304, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Etag") != `"v1"` {
+		t.Errorf("Expected the ETag on 304, got %v", resp.Header)
+	}
+	// 304にボディが書き込まれていると、同じ接続の次のレスポンスの境界がずれる
+	if !strings.HasSuffix(raw, "\r\n\r\n") {
+		t.Errorf("Expected nothing after the 304 headers, got %q", raw)
+	}
+}
+
+// TestConditionalRequestSameOnBothPaths 同じ条件付きリクエストに、プロキシとして受けた場合とCONNECTのトンネル内で受けた場合で同じ応答を返す
+// （304の判定はプレースホルダーへの差し込みより先に行い、プレースホルダーは304にしない）
+func TestConditionalRequestSameOnBothPaths(t *testing.T) {
+	placeholder := &model.BpResponse{
+		StatusCode:  http.StatusOK,
+		Headers:     map[string][]string{"Content-Type": {"text/html; charset=utf-8"}, "Etag": {`"v1"`}},
+		Body:        []byte("<html><head></head><body>loading</body></html>"),
+		ContentType: "text/html; charset=utf-8",
+	}
+	tests := []struct {
+		name       string
+		resp       *model.BpResponse
+		status     model.CacheStatus
+		wantStatus int
+		wantScript bool
+	}{
+		{"cache hit", cachedWithValidators(`"v1"`), model.CacheHit, http.StatusNotModified, false},
+		{"placeholder", placeholder, model.CacheMissPlaceholder, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"If-None-Match": {`"v1"`}}
+
+			// プロキシとして受けたリクエスト
+			rec := serveConditional(t, tt.resp, tt.status, header)
+
+			// CONNECTのトンネル内のリクエスト
+			h := NewBpHandler(&fakeProxyService{resp: tt.resp, status: tt.status}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+			req := httptest.NewRequest(http.MethodGet, "https://example.com/style.css", nil)
+			req.Header = header.Clone()
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			h.serveBumpedRequest(req, w)
+			w.Flush()
+			bumped, err := http.ReadResponse(bufio.NewReader(&buf), req)
+			if err != nil {
+				t.Fatalf("Failed to read the tunnel response: %v", err)
+			}
+			defer bumped.Body.Close()
+			var bumpedBody bytes.Buffer
+			bumpedBody.ReadFrom(bumped.Body)
+
+			if rec.Code != tt.wantStatus || bumped.StatusCode != tt.wantStatus {
+				t.Fatalf("Expected %d on both paths, got %d (proxy) and %d (tunnel)", tt.wantStatus, rec.Code, bumped.StatusCode)
+			}
+			for path, body := range map[string]string{"proxy": rec.Body.String(), "tunnel": bumpedBody.String()} {
+				if got := strings.Contains(body, "bp-placeholder-status"); got != tt.wantScript {
+					t.Errorf("%s: expected the refresh script injected=%v, got body %q", path, tt.wantScript, body)
+				}
+			}
+		})
+	}
+}
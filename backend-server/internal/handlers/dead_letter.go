@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// DeadLetterList デッドレター（送り直しの上限まで転送に失敗した予約）の一覧（ページ単位、新しい順）
+type DeadLetterList struct {
+	Total       int                 `json:"total"`
+	Offset      int                 `json:"offset"`
+	Limit       int                 `json:"limit"`
+	DeadLetters []*model.DeadLetter `json:"dead_letters"`
+}
+
+// ListDeadLetters 送り直しの上限まで転送に失敗した予約を、最後のエラーとともに新しい順に返す
+// GET /system/admin/dead-letters?offset=0&limit=50
+func (ah *adminHandler) ListDeadLetters(c *gin.Context) {
+	offset, limit, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	deadLetters, err := ah.bprepo.ListDeadLetters(c.Request.Context())
+	if err != nil {
+		log.Printf("[AdminHandler] ListDeadLetters error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+
+	list := DeadLetterList{
+		Total:       len(deadLetters),
+		Offset:      offset,
+		Limit:       limit,
+		DeadLetters: []*model.DeadLetter{},
+	}
+	if offset < len(deadLetters) {
+		list.DeadLetters = append(list.DeadLetters, deadLetters[offset:min(offset+limit, len(deadLetters))]...)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// RequeueDeadLetter デッドレターの予約を、送り直した回数を0に戻して予約キューに戻す
+// POST /system/admin/dead-letters/requeue?cache_key=...
+// 同じキャッシュキーの予約が既にある場合は、デッドレターを削除して既にある予約を残す（queuedがfalse）
+func (ah *adminHandler) RequeueDeadLetter(c *gin.Context) {
+	cacheKey := c.Query("cache_key")
+	if cacheKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cache_key parameter is required"})
+		return
+	}
+
+	reservation, found, err := ah.bprepo.RequeueDeadLetter(c.Request.Context(), cacheKey)
+	if err != nil {
+		log.Printf("[AdminHandler] RequeueDeadLetter error (CacheKey: %s): %v", cacheKey, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to requeue dead letter"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No dead letter for the cache key", "cache_key": cacheKey})
+		return
+	}
+
+	log.Printf("[AdminHandler] Requeued dead letter: %s (queued: %v)", cacheKey, reservation.Queued)
+	c.JSON(http.StatusOK, gin.H{"cache_key": cacheKey, "queued": reservation.Queued, "position": reservation.Position})
+}
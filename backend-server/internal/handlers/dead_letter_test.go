@@ -0,0 +1,74 @@
+// dead_letter_test.go - デッドレターの一覧と予約キューへの戻しの管理用エンドポイントのテスト
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+)
+
+func TestDeadLetters(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	ctx := context.Background()
+	for _, u := range []string{"https://example.com/a", "https://example.com/b"} {
+		req := &model.BpRequest{Method: http.MethodGet, URL: u, Prefetch: true, Attempts: 3}
+		if _, err := repo.ReserveRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.DeadLetterReservedRequest(ctx, req, "no response"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := NewAdminHandler(repo)
+	r.GET("/system/admin/dead-letters", h.ListDeadLetters)
+	r.POST("/system/admin/dead-letters/requeue", h.RequeueDeadLetter)
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/system/admin/dead-letters?limit=1")
+	var list DeadLetterList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected a dead letter list, got %d %s", rec.Code, rec.Body)
+	}
+	if list.Total != 2 || len(list.DeadLetters) != 1 || list.DeadLetters[0].LastError != "no response" || list.DeadLetters[0].Request.Attempts != 3 {
+		t.Fatalf("Unexpected dead letters %+v", list)
+	}
+
+	// キューに戻すと送り直した回数を0にして予約し、デッドレターから消える
+	cacheKey := list.DeadLetters[0].CacheKey
+	rec = serve(http.MethodPost, "/system/admin/dead-letters/requeue?cache_key="+cacheKey)
+	var requeued struct {
+		Queued bool `json:"queued"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &requeued); err != nil || rec.Code != http.StatusOK || !requeued.Queued {
+		t.Fatalf("Expected the dead letter to be requeued, got %d %s", rec.Code, rec.Body)
+	}
+	if queue, _ := repo.GetReservedRequests(ctx); len(queue) != 1 || queue[0].GenerateCacheKey() != cacheKey || queue[0].Attempts != 0 {
+		t.Errorf("Expected the request to be queued with no attempts, got %+v", queue)
+	}
+	if deadLetters, _ := repo.ListDeadLetters(ctx); len(deadLetters) != 1 || deadLetters[0].CacheKey == cacheKey {
+		t.Errorf("Expected one dead letter left, got %+v", deadLetters)
+	}
+
+	if rec := serve(http.MethodPost, "/system/admin/dead-letters/requeue?cache_key="+cacheKey); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a requeued dead letter, got %d", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/system/admin/dead-letters/requeue"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without cache_key, got %d", rec.Code)
+	}
+	if rec := serve(http.MethodGet, "/system/admin/dead-letters?offset=-1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative offset, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
+)
+
+// checkDomain hostへの転送がドメインの許可リスト・ブロックリストで許可されているかを判定する
+func (bh *bpHandler) checkDomain(host string) (allowed bool, reason string) {
+	if bh.middleware == nil {
+		return true, ""
+	}
+	allowed, reason = bh.middleware.DomainFilter.Check(host)
+	if !allowed {
+		log.Printf("[BpHandler] Blocked request: %s", reason)
+	}
+	return allowed, reason
+}
+
+// blockedHeader ブロックしたリクエストに返すレスポンスのヘッダー
+func blockedHeader() http.Header {
+	return http.Header{
+		"Content-Type":  {"text/html; charset=utf-8"},
+		"Cache-Control": {"no-store"},
+	}
+}
+
+// writeBlockedPage ブロックしたリクエストに403のページを返す
+func writeBlockedPage(w http.ResponseWriter, host, reason string) {
+	for key, values := range blockedHeader() {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(renderBlockedPage(host, reason))
+}
+
+// renderBlockedPage ブロックしたリクエストに返す403のページ
+func renderBlockedPage(host, reason string) []byte {
+	var buf bytes.Buffer
+	if err := blockedPageTemplate.Execute(&buf, map[string]string{"Host": host, "Reason": reason}); err != nil {
+		log.Printf("[BpHandler] Failed to render blocked page: %v", err)
+		return []byte("Forbidden: " + template.HTMLEscapeString(reason))
+	}
+	return buf.Bytes()
+}
+
+var blockedPageTemplate = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>このサイトはDTN経由で閲覧できません</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.6; }
+        code { word-break: break-all; }
+    </style>
+</head>
+<body>
+    <h1>このサイトはDTN経由で閲覧できません</h1>
+    <p><code>{{.Host}}</code> へのリクエストは、プロキシの管理者が設定したドメインの制限によりブロックされました。</p>
+    <p>DTNの帯域は限られているため、データ量の大きいサイトや利用が制限されているサイトは転送していません。このリクエストは予約も転送もされていません。</p>
+    <p>理由: <code>{{.Reason}}</code></p>
+    <p>閲覧が必要な場合は管理者に問い合わせてください。</p>
+</body>
+</html>
+`))
+
+// DomainLists ドメインの許可リストとブロックリスト
+type DomainLists struct {
+	Allowlist []string `json:"allowlist"`
+	Blocklist []string `json:"blocklist"`
+}
+
+type domainFilterHandler struct {
+	filter *module.DomainFilter
+	// load 設定ファイルからリストを読み直す（config.LoadDomainLists）
+	load func() (allow, block []string, err error)
+}
+
+func NewDomainFilterHandler(filter *module.DomainFilter, load func() (allow, block []string, err error)) *domainFilterHandler {
+	return &domainFilterHandler{filter: filter, load: load}
+}
+
+// GetDomainFilter 現在の許可リストとブロックリストを返す
+// GET /system/admin/domain-filter
+func (dh *domainFilterHandler) GetDomainFilter(c *gin.Context) {
+	allow, block := dh.filter.Lists()
+	c.JSON(http.StatusOK, DomainLists{Allowlist: allow, Blocklist: block})
+}
+
+// ReloadDomainFilter 設定ファイルからリストを読み直し、再起動せずに反映する
+// POST /system/admin/domain-filter/reload
+// 読み込みに失敗した場合や不正なパターンがある場合は、現在のリストを使い続ける
+func (dh *domainFilterHandler) ReloadDomainFilter(c *gin.Context) {
+	allow, block, err := dh.load()
+	if err != nil {
+		log.Printf("[AdminHandler] Failed to reload domain filter: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload domain filter", "message": err.Error()})
+		return
+	}
+	if err := dh.filter.Update(allow, block); err != nil {
+		log.Printf("[AdminHandler] Failed to reload domain filter: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain filter", "message": err.Error()})
+		return
+	}
+
+	allow, block = dh.filter.Lists()
+	log.Printf("[AdminHandler] Reloaded domain filter: %d allowlist, %d blocklist patterns", len(allow), len(block))
+	c.JSON(http.StatusOK, DomainLists{Allowlist: allow, Blocklist: block})
+}
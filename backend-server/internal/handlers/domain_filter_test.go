@@ -0,0 +1,181 @@
+// domain_filter_test.go - ドメインの制限によるブロックと再読み込みのテスト
+package handlers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
+)
+
+func newTestDomainFilter(t *testing.T, allow, block []string) *module.DomainFilter {
+	t.Helper()
+	df, err := module.NewDomainFilter(allow, block)
+	if err != nil {
+		t.Fatalf("NewDomainFilter failed: %v", err)
+	}
+	return df
+}
+
+func TestGetContentBlockedDomain(t *testing.T) {
+	filter := newTestDomainFilter(t, nil, []string{"*.huge.example"})
+	gw := &recordingGateway{}
+	h := NewBpHandler(service.NewBpService(gw, unavailableRepository{}, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, filter, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://cdn.huge.example/video.mp4", strings.NewReader("x")))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected an HTML page, got %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "cdn.huge.example") {
+		t.Errorf("Expected the page to name the blocked host, got:\n%s", rec.Body.String())
+	}
+	if gw.last != nil {
+		t.Errorf("Expected the blocked request not to be forwarded, got %s", gw.last.URL)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader("x")))
+	if rec.Code != http.StatusOK || gw.last == nil {
+		t.Errorf("Expected other hosts to be forwarded, got %d", rec.Code)
+	}
+}
+
+func TestCONNECTBlockedBeforeHandshake(t *testing.T) {
+	srv, _ := newTestProxyWithFilter(t, newTestDomainFilter(t, []string{"*.example.com"}, nil))
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT example.org:443 HTTP/1.1\r\nHost: example.org:443\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatalf("Failed to read CONNECT response: %v", err)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a CONNECT outside the allowlist, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "example.org") {
+		t.Errorf("Expected the explanatory page, got:\n%s", body)
+	}
+
+	// トンネルを確立していないため、TLSのClientHelloはHTTPとして解釈される（ハンドシェイクは行われない）
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: "example.org", InsecureSkipVerify: true})
+	tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := tlsConn.Handshake(); err == nil {
+		t.Error("Expected no TLS handshake after a blocked CONNECT")
+	}
+}
+
+func TestCONNECTBlocksHostInsideTunnel(t *testing.T) {
+	srv, caCert := newTestProxyWithFilter(t, newTestDomainFilter(t, nil, []string{"blocked.example.net"}))
+	tlsConn := dialTunnel(t, srv.Listener.Addr().String(), caCert)
+	reader := bufio.NewReader(tlsConn)
+
+	// example.comへのトンネル内で、ブロックしたホストへのリクエストを送る
+	req, _ := http.NewRequest(http.MethodPost, "https://blocked.example.net/upload", strings.NewReader("x"))
+	if err := req.Write(tlsConn); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for the blocked host, got %d", resp.StatusCode)
+	}
+
+	// 同じトンネルで許可されたホストへのリクエストは転送される
+	req, _ = http.NewRequest(http.MethodPost, "https://example.com/ok", strings.NewReader("y"))
+	if err := req.Write(tlsConn); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	resp, err = http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for the allowed host, got %d", resp.StatusCode)
+	}
+}
+
+func TestReloadDomainFilter(t *testing.T) {
+	filter := newTestDomainFilter(t, nil, []string{"old.example"})
+	var loaded DomainLists
+	var loadErr error
+	h := NewDomainFilterHandler(filter, func() ([]string, []string, error) {
+		return loaded.Allowlist, loaded.Blocklist, loadErr
+	})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/system/admin/domain-filter", h.GetDomainFilter)
+	r.POST("/system/admin/domain-filter/reload", h.ReloadDomainFilter)
+
+	reload := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/system/admin/domain-filter/reload", nil))
+		return rec
+	}
+
+	loaded = DomainLists{Blocklist: []string{"*.New.Example"}}
+	if rec := reload(); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if allowed, _ := filter.Check("old.example"); !allowed {
+		t.Error("Expected the old blocklist to be replaced")
+	}
+	if allowed, _ := filter.Check("www.new.example"); allowed {
+		t.Error("Expected the reloaded blocklist to take effect")
+	}
+
+	// 読み込みに失敗した場合・不正なパターンの場合はリストを変えない
+	loadErr = errors.New("config file is missing")
+	if rec := reload(); rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when loading fails, got %d", rec.Code)
+	}
+	loadErr = nil
+	loaded = DomainLists{Blocklist: []string{"bad*pattern"}}
+	if rec := reload(); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid pattern, got %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/admin/domain-filter", nil))
+	var got DomainLists
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(got, DomainLists{Allowlist: []string{}, Blocklist: []string{"*.new.example"}}) {
+		t.Errorf("Expected the last valid lists, got %+v", got)
+	}
+}
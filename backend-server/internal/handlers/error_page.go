@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/utils"
+)
+
+// エラーページで伝える、何が起きたか（ErrorPage.Reason）
+const (
+	// reasonGatewayUnreachable DTNゲートウェイ（Service層）でリクエストを転送できなかった（以下の理由に当てはまらない場合）
+	reasonGatewayUnreachable = "gateway-unreachable"
+	// reasonLinkDown DTNへのリンクが使えない（gateway.ErrLinkDown）
+	reasonLinkDown = "link-down"
+	// reasonSendFailed リクエストのバンドルを送れなかった（gateway.ErrSendFailed）
+	reasonSendFailed = "send-failed"
+	// reasonGatewayTimeout バンドルは送ったが、ゲートウェイの期限までにレスポンスが届かなかった（gateway.ErrTimeout）
+	reasonGatewayTimeout = "gateway-timeout"
+	// reasonResponseCorrupt 届いたレスポンスを読めなかった（gateway.ErrResponseCorrupt）
+	reasonResponseCorrupt = "response-corrupt"
+	// reasonQueued 期限までにレスポンスが届かなかったが、DTNへの転送を予約した
+	reasonQueued = "queued"
+	// reasonTimeout 期限までにレスポンスが届かず、キャッシュできないため予約もしていない
+	reasonTimeout = "timeout"
+	// reasonInterceptionUnavailable SSL Bumpが使えないためHTTPSを中継できない
+	reasonInterceptionUnavailable = "interception-unavailable"
+	// reasonDirectUnreachable パススルーの接続先に直接接続できなかった
+	reasonDirectUnreachable = "direct-unreachable"
+)
+
+// ErrorPage プロキシが返す502・503・504の内容（JSONのボディ、HTMLのテンプレートに渡す値）
+type ErrorPage struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+	// Reason 何が起きたか（gateway-unreachable、link-down、send-failed、gateway-timeout、response-corrupt、
+	// queued、timeout、interception-unavailable、direct-unreachable）
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	// Guidance いつ・どうすれば再試行できるか
+	Guidance  string `json:"guidance"`
+	URL       string `json:"url"`
+	RequestID string `json:"request_id,omitempty"`
+	// Queued キャッシュ可能なリクエストで、DTNへの転送を予約した（またはキャッシュから返せるようになる）か
+	Queued bool `json:"queued"`
+	// TimeoutSeconds ハンドラーが待った時間（期限を過ぎた場合のみ）
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// RetryAfterSeconds レスポンスが届くまでの目安（予約した場合のみ、Retry-Afterと同じ）
+	RetryAfterSeconds int `json:"retry_after_seconds,omitempty"`
+	// StatusURL 予約したリクエストの状態を問い合わせるURL（予約した場合のみ）
+	StatusURL string `json:"status_url,omitempty"`
+}
+
+// errorMessages Reasonごとの説明
+var errorMessages = map[string]string{
+	reasonGatewayUnreachable:      "The request could not be relayed. This proxy forwards requests over a delay-tolerant network (DTN), and the DTN gateway is unreachable or failed to send it.",
+	reasonLinkDown:                "The request could not be relayed because the link to the delay-tolerant network (DTN) is down.",
+	reasonSendFailed:              "The request could not be relayed. This proxy forwards requests over a delay-tolerant network (DTN), and sending the request bundle failed.",
+	reasonGatewayTimeout:          "The request was sent over the delay-tolerant network (DTN), but no response arrived before the gateway gave up waiting.",
+	reasonResponseCorrupt:         "A response arrived over the delay-tolerant network (DTN), but it was corrupt and could not be read.",
+	reasonQueued:                  "The response did not arrive in time. Requests are relayed over a delay-tolerant network (DTN), so responses may take minutes to arrive.",
+	reasonTimeout:                 "The response did not arrive in time. Requests are relayed over a delay-tolerant network (DTN), so responses may take minutes to arrive.",
+	reasonInterceptionUnavailable: "HTTPS requests cannot be relayed because HTTPS interception (SSL bump) is not available on this proxy.",
+	reasonDirectUnreachable:       "This host is configured for passthrough, which needs a direct connection instead of the DTN, and the host could not be reached.",
+}
+
+// newErrorPage エラーページの内容を作る
+func (bh *bpHandler) newErrorPage(status int, reason, targetURL, requestID string) ErrorPage {
+	page := ErrorPage{
+		Status:    status,
+		Error:     strings.ToLower(http.StatusText(status)),
+		Reason:    reason,
+		Message:   errorMessages[reason],
+		URL:       targetURL,
+		RequestID: requestID,
+	}
+
+	switch reason {
+	case reasonGatewayUnreachable, reasonSendFailed:
+		page.Guidance = "Try again in a few minutes. If it keeps failing, the DTN link may be down (see /system/health)."
+	case reasonLinkDown:
+		page.Guidance = "Try again after the DTN link recovers (see /system/health)."
+	case reasonGatewayTimeout:
+		page.Guidance = "This request cannot be cached, so it was not queued. Send it again later."
+	case reasonResponseCorrupt:
+		page.Guidance = "Send the request again. If it keeps failing, ask the administrator to check the ground station."
+	case reasonQueued, reasonTimeout:
+		page.TimeoutSeconds = int(bh.requestTimeout / time.Second)
+		if reason == reasonTimeout {
+			page.Guidance = "This request cannot be cached, so it was not queued. Send it again later."
+			break
+		}
+		page.Queued = true
+		page.StatusURL = "/system/status?url=" + url.QueryEscape(targetURL)
+		page.Guidance = "The request has been queued for the DTN. Reload later; the response will be served from the cache once it arrives."
+		if bh.retryAfter > 0 {
+			page.RetryAfterSeconds = int((bh.retryAfter + time.Second - 1) / time.Second)
+			page.Guidance = fmt.Sprintf("The request has been queued for the DTN. Reload in about %d seconds; the response will be served from the cache once it arrives.", page.RetryAfterSeconds)
+		}
+	case reasonInterceptionUnavailable:
+		page.Guidance = "Ask the administrator to check the proxy's CA certificate (see /system/setup)."
+	case reasonDirectUnreachable:
+		page.Guidance = "Try again when a direct connection to the host is available."
+	}
+	return page
+}
+
+// gatewayErrorPage Service層（Gateway層）が転送に失敗した理由に応じたエラーページ
+// 理由ごとの数をメトリクスに記録する
+func (bh *bpHandler) gatewayErrorPage(err error, breq *model.BpRequest) ErrorPage {
+	status, reason := gatewayFailure(err)
+	bh.metrics.IncGatewayError(reason)
+	return bh.newErrorPage(status, reason, breq.URL, breq.RequestID)
+}
+
+// gatewayFailure Gateway層のエラーからステータスコードと理由を決める
+// リンクが使えない場合は503、期限までにレスポンスが届かなかった場合は504、それ以外は502
+func gatewayFailure(err error) (int, string) {
+	switch {
+	case errors.Is(err, gateway.ErrLinkDown):
+		return http.StatusServiceUnavailable, reasonLinkDown
+	case errors.Is(err, gateway.ErrTimeout):
+		return http.StatusGatewayTimeout, reasonGatewayTimeout
+	case errors.Is(err, gateway.ErrSendFailed):
+		return http.StatusBadGateway, reasonSendFailed
+	case errors.Is(err, gateway.ErrResponseCorrupt):
+		return http.StatusBadGateway, reasonResponseCorrupt
+	default:
+		return http.StatusBadGateway, reasonGatewayUnreachable
+	}
+}
+
+// timeoutPage 期限までにレスポンスを用意できなかったリクエストの504
+// キャッシュ可能なリクエストは期限を過ぎてもService層が予約まで続けている
+func (bh *bpHandler) timeoutPage(breq *model.BpRequest) ErrorPage {
+	reason := reasonTimeout
+	if breq.IsCacheable() {
+		reason = reasonQueued
+	}
+	return bh.newErrorPage(http.StatusGatewayTimeout, reason, breq.URL, breq.RequestID)
+}
+
+// writeErrorPage エラーページをクライアントに返す（HTMLかJSONかはAcceptで決める）
+func (bh *bpHandler) writeErrorPage(w http.ResponseWriter, accept string, page ErrorPage) {
+	header, body := bh.errorPages.render(accept, page)
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(page.Status)
+	w.Write(body)
+}
+
+// writeBumpedErrorPage CONNECTトンネル内のリクエストにエラーページを返す
+// 同じ接続で次のリクエストを読める場合はtrueを返す
+func (bh *bpHandler) writeBumpedErrorPage(req *http.Request, w *bufio.Writer, page ErrorPage) bool {
+	header, body := bh.errorPages.render(req.Header.Get("Accept"), page)
+	return writeBumpedResponse(req, w, page.Status, header, body)
+}
+
+// errorPageStatuses デフォルトページのディレクトリのテンプレートで差し替えられるステータスコード
+var errorPageStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// errorPages エラーページのHTMLのテンプレート
+type errorPages struct {
+	templates map[int]*template.Template
+}
+
+// loadErrorPages dirのerror_<status>.html（なければerror.html）をステータスコードごとのテンプレートとして読み込む
+// テンプレートにはErrorPageを渡す。dirが空の場合、ファイルがない場合、パースに失敗した場合は組み込みのページを使う
+func loadErrorPages(dir string) *errorPages {
+	ep := &errorPages{templates: make(map[int]*template.Template)}
+	if dir == "" {
+		return ep
+	}
+	dirPath := utils.ResolvePageDir(dir)
+	for _, status := range errorPageStatuses {
+		for _, name := range []string{fmt.Sprintf("error_%d.html", status), "error.html"} {
+			path := filepath.Join(dirPath, name)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			tmpl, err := template.New(name).Parse(string(data))
+			if err != nil {
+				log.Printf("[BpHandler] Failed to parse error page %s, using the built-in page: %v", path, err)
+				break
+			}
+			ep.templates[status] = tmpl
+			break
+		}
+	}
+	return ep
+}
+
+// render pageのヘッダーとボディを作る
+// クライアントがJSONよりHTMLを求める場合（ブラウザ）はHTML、それ以外（Accept: application/json、Acceptなし）はJSONを返す
+func (ep *errorPages) render(accept string, page ErrorPage) (http.Header, []byte) {
+	header := http.Header{"Cache-Control": {"no-store"}}
+	if page.RetryAfterSeconds > 0 {
+		header.Set("Retry-After", strconv.Itoa(page.RetryAfterSeconds))
+	}
+
+	if prefersHTML(accept) {
+		body, err := ep.renderHTML(page)
+		if err == nil {
+			header.Set("Content-Type", "text/html; charset=utf-8")
+			return header, body
+		}
+		log.Printf("[BpHandler] Failed to render error page: %v", err)
+	}
+	body, _ := json.Marshal(page)
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	return header, body
+}
+
+// renderHTML ステータスコードのテンプレート（なければ組み込みのページ）でHTMLを作る
+// 差し替えたテンプレートの実行に失敗した場合は組み込みのページを使う
+func (ep *errorPages) renderHTML(page ErrorPage) ([]byte, error) {
+	var buf bytes.Buffer
+	if tmpl, ok := ep.templates[page.Status]; ok {
+		err := tmpl.Execute(&buf, page)
+		if err == nil {
+			return buf.Bytes(), nil
+		}
+		log.Printf("[BpHandler] Failed to render error page %s, using the built-in page: %v", tmpl.Name(), err)
+		buf.Reset()
+	}
+	if err := errorPageTemplate.Execute(&buf, page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// prefersHTML AcceptでJSONよりHTMLを優先しているか（q=0は拒否）
+func prefersHTML(accept string) bool {
+	htmlQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch {
+		case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+			htmlQ = max(htmlQ, q)
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return htmlQ > 0 && htmlQ >= jsonQ
+}
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Status}} {{if eq .Reason "queued" "timeout"}}レスポンスの到着を待っています{{else}}リクエストを転送できませんでした{{end}}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.6; color: #222; }
+        h1 { font-size: 1.4rem; }
+        code { word-break: break-all; }
+        .note { background: #f4f4f8; border-left: 4px solid #667eea; padding: 0.5rem 1rem; }
+        .meta { color: #666; font-size: 0.85rem; }
+    </style>
+</head>
+<body>
+    {{if eq .Reason "queued" "timeout"}}
+    <h1>レスポンスの到着を待っています</h1>
+    <p><code>{{.URL}}</code> へのレスポンスが {{.TimeoutSeconds}} 秒以内に届きませんでした。</p>
+    {{else}}
+    <h1>リクエストを転送できませんでした</h1>
+    <p><code>{{.URL}}</code> へのリクエストを転送できませんでした。</p>
+    {{end}}
+    <p>このプロキシはDTN（遅延耐性ネットワーク）経由でリクエストを転送するため、レスポンスが届くまでに数分以上かかることがあります。</p>
+    <div class="note">
+    {{if eq .Reason "queued"}}<p>リクエストはDTNへの転送を予約しました。届いたらキャッシュから表示できるので、{{if .RetryAfterSeconds}}約 {{.RetryAfterSeconds}} 秒後に{{else}}しばらくしてから{{end}}再読み込みしてください。</p>
+    <p>状態の確認: <a href="{{.StatusURL}}">{{.StatusURL}}</a></p>
+    {{else if eq .Reason "timeout"}}<p>このリクエストはキャッシュできないため予約されていません。時間をおいてもう一度送信してください。</p>
+    {{else if eq .Reason "link-down"}}<p>DTNのリンクが停止しているため転送できません。リンクが回復してからもう一度試してください（<a href="/system/health">/system/health</a>）。</p>
+    {{else if eq .Reason "send-failed"}}<p>リクエストのバンドルを送信できませんでした。数分後にもう一度試してください。続く場合はDTNのリンクが停止している可能性があります（<a href="/system/health">/system/health</a>）。</p>
+    {{else if eq .Reason "gateway-timeout"}}<p>リクエストはDTNへ送信しましたが、レスポンスが期限までに届きませんでした。このリクエストはキャッシュできないため予約されていません。時間をおいてもう一度送信してください。</p>
+    {{else if eq .Reason "response-corrupt"}}<p>DTN経由で届いたレスポンスが壊れていたため表示できません。もう一度送信してください。続く場合は管理者に地上局の確認を依頼してください。</p>
+    {{else if eq .Reason "gateway-unreachable"}}<p>DTNゲートウェイに接続できないか、送信に失敗しました。数分後にもう一度試してください。続く場合はDTNのリンクが停止している可能性があります（<a href="/system/health">/system/health</a>）。</p>
+    {{else if eq .Reason "interception-unavailable"}}<p>このプロキシではHTTPSの中継（SSL Bump）が使えません。管理者にプロキシのCA証明書の設定を確認してもらってください（<a href="/system/setup">/system/setup</a>）。</p>
+    {{else if eq .Reason "direct-unreachable"}}<p>このホストはDTNを通さずに直接接続する設定ですが、接続できませんでした。直接の経路が使えるときにもう一度試してください。</p>
+    {{else}}<p>{{.Guidance}}</p>
+    {{end}}
+    </div>
+    <p class="meta">{{.Status}} {{.Error}} ・ 理由: <code>{{.Reason}}</code>{{if .RequestID}} ・ リクエストID: <code>{{.RequestID}}</code>{{end}}</p>
+</body>
+</html>
+`))
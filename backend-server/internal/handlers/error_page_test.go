@@ -0,0 +1,167 @@
+// error_page_test.go - 502・503・504のエラーページ（HTML・JSONの切り替えとテンプレート）のテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// failingProxyService ゲートウェイに届かなかったものとしてエラーを返すService層
+type failingProxyService struct{}
+
+func (failingProxyService) ProxyRequestWithStatus(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
+	return nil, "", errors.New("dial unix /tmp/bp.sock: connect: no such file or directory")
+}
+
+func serveFailing(t *testing.T, pageDir, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := NewBpHandler(failingProxyService{}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 2*time.Minute, 0, 0, false, pageDir)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/page?q=<b>", nil)
+	req.Header.Set(requestid.Header, "req-502")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPrefersHTML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/problem+json", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"application/json, text/html;q=0.5", false},
+		{"text/html;q=0", false},
+		{"text/html, application/json", true},
+	}
+	for _, tt := range tests {
+		if got := prefersHTML(tt.accept); got != tt.want {
+			t.Errorf("prefersHTML(%q): expected %v, got %v", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestBadGatewayJSON(t *testing.T) {
+	rec := serveFailing(t, "", "application/json")
+	if rec.Code != http.StatusBadGateway || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("Expected a 502 JSON body, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var got ErrorPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Expected a JSON body, got %q", rec.Body.String())
+	}
+	if got.Status != http.StatusBadGateway || got.Reason != reasonGatewayUnreachable || got.RequestID != "req-502" ||
+		got.URL != "http://example.com/page?q=<b>" || !strings.Contains(got.Message, "DTN") || got.Guidance == "" {
+		t.Errorf("Unexpected error body: %+v", got)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected the error not to be cached, got %q", rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestBadGatewayHTML(t *testing.T) {
+	rec := serveFailing(t, "", "text/html,application/xhtml+xml")
+	if rec.Code != http.StatusBadGateway || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected a 502 HTML page, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"http://example.com/page?q=&lt;b&gt;", "req-502", "DTN", "/system/health"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the page to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "<b>") {
+		t.Error("Expected the URL to be escaped")
+	}
+}
+
+func TestErrorPageTemplatesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("error_502.html", `<p>{{.Status}}|{{.Reason}}|{{.URL}}|{{.RequestID}}|{{.Guidance}}</p>`)
+	write("error.html", `<p>generic {{.Status}} {{.Reason}} retry={{.RetryAfterSeconds}}</p>`)
+	write("error_503.html", `<p>{{.Broken</p>`)
+	ep := loadErrorPages(dir)
+
+	h := NewBpHandler(failingProxyService{}, nil, nil, 2*time.Minute, time.Minute, 0, false, dir)
+	accept := "text/html"
+	render := func(page ErrorPage) string {
+		_, body := ep.render(accept, page)
+		return string(body)
+	}
+
+	page := h.newErrorPage(http.StatusBadGateway, reasonGatewayUnreachable, "http://example.com/<x>", "req-1")
+	if got, want := render(page), "<p>502|gateway-unreachable|http://example.com/&lt;x&gt;|req-1|"+page.Guidance+"</p>"; got != want {
+		t.Errorf("Expected the 502 template with escaped values:\n%s\ngot:\n%s", want, got)
+	}
+
+	// error_504.htmlがない場合はerror.htmlを使う
+	queued := h.timeoutPage(&model.BpRequest{Method: http.MethodGet, URL: "http://example.com/"})
+	if got := render(queued); got != "<p>generic 504 queued retry=120</p>" {
+		t.Errorf("Expected the generic template for 504, got %q", got)
+	}
+
+	// パースできないテンプレートは組み込みのページに戻す
+	unavailable := h.newErrorPage(http.StatusServiceUnavailable, reasonInterceptionUnavailable, "https://example.com", "")
+	if got := render(unavailable); !strings.Contains(got, "<!DOCTYPE html>") || !strings.Contains(got, "/system/setup") {
+		t.Errorf("Expected the built-in page for a broken template, got:\n%s", got)
+	}
+
+	// JSONを求めるクライアントにはテンプレートを使わない
+	accept = "application/json"
+	if got := render(page); !strings.HasPrefix(got, "{") {
+		t.Errorf("Expected JSON, got %q", got)
+	}
+}
+
+func TestBadGatewayInTunnel(t *testing.T) {
+	h := NewBpHandler(failingProxyService{}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/secure", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set(requestid.Header, "req-tunnel")
+
+	var buf bytes.Buffer
+	if !h.serveBumpedRequest(req, bufio.NewWriter(&buf)) {
+		t.Fatal("Expected the tunnel to stay open after a 502")
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadGateway || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected a 502 HTML page, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "https://example.com/secure") || !strings.Contains(string(body), "req-tunnel") {
+		t.Errorf("Expected the page to show the URL and request ID, got:\n%s", body)
+	}
+}
@@ -0,0 +1,100 @@
+// gateway_error_test.go - ゲートウェイが転送に失敗した理由（リンクの停止・送信の失敗・タイムアウト・壊れたレスポンス）ごとのエラーページのテスト
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+// erroringGateway ProxyRequestで常にerrを返すゲートウェイ
+type erroringGateway struct {
+	gateway.BpGateway
+	err error
+}
+
+func (g erroringGateway) ProxyRequest(ctx context.Context, req *model.BpRequest, opts gateway.ProxyOptions) (*model.BpResponse, error) {
+	return nil, g.err
+}
+
+func TestGatewayFailureReasons(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantReason string
+	}{
+		{"link down", fmt.Errorf("%w: bpsocket unreachable", gateway.ErrLinkDown), http.StatusServiceUnavailable, reasonLinkDown},
+		{"send failed", fmt.Errorf("%w: write: broken pipe", gateway.ErrSendFailed), http.StatusBadGateway, reasonSendFailed},
+		{"timeout", fmt.Errorf("%w: no response within 30s", gateway.ErrTimeout), http.StatusGatewayTimeout, reasonGatewayTimeout},
+		{"corrupt", fmt.Errorf("%w: malformed HTTP response", gateway.ErrResponseCorrupt), http.StatusBadGateway, reasonResponseCorrupt},
+		{"wrapped twice", fmt.Errorf("direct: %w", fmt.Errorf("%w: x", gateway.ErrTimeout)), http.StatusGatewayTimeout, reasonGatewayTimeout},
+		{"unclassified", errors.New("unexpected"), http.StatusBadGateway, reasonGatewayUnreachable},
+	}
+
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// POSTはキャッシュしないため、ゲートウェイへ直接転送する
+			svc := service.NewBpService(erroringGateway{err: tt.err}, fakes.NewRepository(0), "", "", 0, model.DeliveryEstimate{}, true, nil)
+			h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), m, 0, 0, 0, false, "")
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.NoRoute(h.GetContent)
+
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/form", strings.NewReader("a=1"))
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			var page ErrorPage
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Expected a JSON body, got %q", rec.Body.String())
+			}
+			if page.Reason != tt.wantReason || page.Status != tt.wantStatus || page.Message == "" || page.Guidance == "" {
+				t.Errorf("Unexpected error body: %+v", page)
+			}
+
+			// HTMLのページにも理由ごとの説明を表示する
+			req = httptest.NewRequest(http.MethodPost, "http://example.com/form", strings.NewReader("a=1"))
+			req.Header.Set("Accept", "text/html")
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if body := rec.Body.String(); !strings.Contains(body, "<code>"+tt.wantReason+"</code>") {
+				t.Errorf("Expected the reason in the HTML page, got:\n%s", body)
+			}
+		})
+	}
+
+	// 1件ごとにJSONとHTMLの2回リクエストした
+	want := map[string]float64{}
+	for _, tt := range tests {
+		want[tt.wantReason] += 2
+	}
+	for reason, count := range want {
+		if got := gatheredValue(t, reg, "bp_proxy_gateway_errors_total", map[string]string{"reason": reason}); got != count {
+			t.Errorf("Expected %v gateway errors for %s, got %v", count, reason, got)
+		}
+	}
+}
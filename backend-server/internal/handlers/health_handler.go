@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+)
+
+// healthCheckTimeout 1つのコンポーネントの確認を待つ時間
+const healthCheckTimeout = 2 * time.Second
+
+// コンポーネントとサーバー全体の状態
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded" // 重要でないコンポーネントが使えない（プロキシは動く）
+	HealthDown     = "down"
+)
+
+// ComponentHealth 1つのコンポーネントの状態
+type ComponentHealth struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Critical 使えない場合にサーバー全体をdown（503）にするか
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// Health /system/healthのレスポンス
+type Health struct {
+	Status     string            `json:"status"`
+	CheckedAt  time.Time         `json:"checked_at"`
+	Components []ComponentHealth `json:"components"`
+}
+
+type healthHandler struct {
+	bprepo repository.BpRepository
+	bpgw   gateway.BpGateway
+}
+
+func NewHealthHandler(bprepo repository.BpRepository, bpgw gateway.BpGateway) *healthHandler {
+	return &healthHandler{bprepo: bprepo, bpgw: bpgw}
+}
+
+// GetHealth Redis・キャッシュディレクトリ・DTNゲートウェイの状態を返す
+// GET /system/health
+// Redisかゲートウェイが使えない場合は503、キャッシュディレクトリだけが使えない場合はdegradedで200
+// （キャッシュに保存できなくても、リクエストの転送は続けられるため）
+func (hh *healthHandler) GetHealth(c *gin.Context) {
+	ctx := c.Request.Context()
+	health := Health{
+		Status:    HealthOK,
+		CheckedAt: time.Now().UTC(),
+		Components: []ComponentHealth{
+			checkComponent(ctx, "redis", true, hh.bprepo.Ping),
+			checkComponent(ctx, "cache_dir", false, hh.bprepo.CheckCacheDir),
+			hh.checkGateway(ctx),
+		},
+	}
+
+	code := http.StatusOK
+	for _, component := range health.Components {
+		if component.Status == HealthOK {
+			continue
+		}
+		if component.Critical {
+			health.Status = HealthDown
+			code = http.StatusServiceUnavailable
+		} else if health.Status == HealthOK {
+			health.Status = HealthDegraded
+		}
+	}
+	if health.Status != HealthOK {
+		log.Printf("[HealthHandler] Status=%s: %+v", health.Status, health.Components)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, health)
+}
+
+// checkGateway ゲートウェイのリンクの状態を確認する（HealthCheckerを実装していなければ確認しない）
+func (hh *healthHandler) checkGateway(ctx context.Context) ComponentHealth {
+	checker, ok := hh.bpgw.(gateway.HealthChecker)
+	if !ok {
+		return ComponentHealth{Name: "gateway", Status: HealthOK, Critical: true, Detail: "link state is not reported by this gateway"}
+	}
+	return checkComponent(ctx, "gateway", true, checker.HealthCheck)
+}
+
+// checkComponent checkを期限付きで実行し、結果と所要時間を返す
+func checkComponent(ctx context.Context, name string, critical bool, check func(context.Context) error) ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	component := ComponentHealth{
+		Name:      name,
+		Status:    HealthOK,
+		Critical:  critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		component.Status = HealthDown
+		component.Error = err.Error()
+	}
+	return component
+}
@@ -0,0 +1,137 @@
+// health_handler_test.go - /system/healthのコンポーネントごとの状態と全体の状態のテスト
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+)
+
+// fakeHealthRepository RedisのPingとキャッシュディレクトリの確認の結果を返すリポジトリ
+type fakeHealthRepository struct {
+	repository.BpRepository
+	pingErr     error
+	cacheDirErr error
+}
+
+func (r *fakeHealthRepository) Ping(ctx context.Context) error {
+	return r.pingErr
+}
+
+func (r *fakeHealthRepository) CheckCacheDir(ctx context.Context) error {
+	return r.cacheDirErr
+}
+
+// fakeHealthGateway リンクの状態を返すゲートウェイ
+type fakeHealthGateway struct {
+	gateway.BpGateway
+	err error
+}
+
+func (g *fakeHealthGateway) HealthCheck(ctx context.Context) error {
+	return g.err
+}
+
+func serveHealth(t *testing.T, repo repository.BpRepository, gw gateway.BpGateway) (int, Health) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/system/health", NewHealthHandler(repo, gw).GetHealth)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/health", nil))
+
+	var health Health
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatalf("invalid JSON: %v (%s)", err, rec.Body.String())
+	}
+	return rec.Code, health
+}
+
+func component(t *testing.T, health Health, name string) ComponentHealth {
+	t.Helper()
+	for _, c := range health.Components {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("component %q not found in %+v", name, health.Components)
+	return ComponentHealth{}
+}
+
+func TestHealthOK(t *testing.T) {
+	code, health := serveHealth(t, &fakeHealthRepository{}, &fakeHealthGateway{})
+	if code != http.StatusOK || health.Status != HealthOK {
+		t.Fatalf("expected 200 ok, got %d %s", code, health.Status)
+	}
+	if len(health.Components) != 3 {
+		t.Fatalf("expected 3 components, got %+v", health.Components)
+	}
+	for _, c := range health.Components {
+		if c.Status != HealthOK || c.Error != "" {
+			t.Errorf("expected %s to be ok, got %+v", c.Name, c)
+		}
+		if c.LatencyMs < 0 {
+			t.Errorf("expected non-negative latency for %s, got %v", c.Name, c.LatencyMs)
+		}
+	}
+	if health.CheckedAt.IsZero() {
+		t.Error("expected checked_at to be set")
+	}
+}
+
+func TestHealthDegradedWhenCacheDirNotWritable(t *testing.T) {
+	repo := &fakeHealthRepository{cacheDirErr: errors.New("cache directory is not writable: read-only file system")}
+	code, health := serveHealth(t, repo, &fakeHealthGateway{})
+	if code != http.StatusOK || health.Status != HealthDegraded {
+		t.Fatalf("expected 200 degraded, got %d %s", code, health.Status)
+	}
+	cacheDir := component(t, health, "cache_dir")
+	if cacheDir.Status != HealthDown || cacheDir.Critical || cacheDir.Error == "" {
+		t.Errorf("expected non-critical cache_dir down with error, got %+v", cacheDir)
+	}
+	if redis := component(t, health, "redis"); redis.Status != HealthOK {
+		t.Errorf("expected redis ok, got %+v", redis)
+	}
+}
+
+func TestHealthDownWhenRedisUnreachable(t *testing.T) {
+	repo := &fakeHealthRepository{pingErr: errors.New("redis ping failed: connection refused")}
+	code, health := serveHealth(t, repo, &fakeHealthGateway{})
+	if code != http.StatusServiceUnavailable || health.Status != HealthDown {
+		t.Fatalf("expected 503 down, got %d %s", code, health.Status)
+	}
+	redis := component(t, health, "redis")
+	if redis.Status != HealthDown || !redis.Critical || redis.Error != "redis ping failed: connection refused" {
+		t.Errorf("expected critical redis down with error, got %+v", redis)
+	}
+}
+
+func TestHealthDownWhenGatewayLinkDown(t *testing.T) {
+	gw := &fakeHealthGateway{err: errors.New("bp socket is not receiving")}
+	code, health := serveHealth(t, &fakeHealthRepository{}, gw)
+	if code != http.StatusServiceUnavailable || health.Status != HealthDown {
+		t.Fatalf("expected 503 down, got %d %s", code, health.Status)
+	}
+	if g := component(t, health, "gateway"); g.Status != HealthDown || !g.Critical {
+		t.Errorf("expected critical gateway down, got %+v", g)
+	}
+}
+
+// ゲートウェイがHealthCheckerを実装していない場合は確認せずにokとする
+func TestHealthGatewayWithoutChecker(t *testing.T) {
+	type plainGateway struct{ gateway.BpGateway }
+	code, health := serveHealth(t, &fakeHealthRepository{}, plainGateway{})
+	if code != http.StatusOK || health.Status != HealthOK {
+		t.Fatalf("expected 200 ok, got %d %s", code, health.Status)
+	}
+	if g := component(t, health, "gateway"); g.Status != HealthOK || g.Detail == "" {
+		t.Errorf("expected gateway ok with detail, got %+v", g)
+	}
+}
@@ -0,0 +1,56 @@
+// image_placeholder_test.go - 画像のキャッシュミスのときに返すSVGのプレースホルダーと、画像を予約するかの設定のテスト
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/utils"
+)
+
+func TestImagePlaceholder(t *testing.T) {
+	tests := []struct {
+		name         string
+		fetchImages  bool
+		wantReserved int
+		wantLabel    string
+	}{
+		{"images not fetched", false, 0, utils.ImagePlaceholderNotQueued},
+		{"images fetched", true, 1, utils.ImagePlaceholderQueued},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := fakes.NewRepository(0)
+			svc := service.NewBpService(echoGateway{}, repo, t.TempDir(), "index.html", 0, model.DeliveryEstimate{}, tt.fetchImages, nil)
+			h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.NoRoute(h.GetContent)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/images/photo_640x480.jpg", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "image/svg+xml" {
+				t.Errorf("Expected Content-Type image/svg+xml, got %q", got)
+			}
+			body := rec.Body.String()
+			if !strings.HasPrefix(body, "<svg ") || !strings.Contains(body, `width="640" height="480"`) || !strings.Contains(body, tt.wantLabel) {
+				t.Errorf("Expected a 640x480 SVG placeholder with %q, got %s", tt.wantLabel, body)
+			}
+			if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != tt.wantReserved {
+				t.Errorf("Expected %d reservation(s), got %d", tt.wantReserved, len(reserved))
+			}
+		})
+	}
+}
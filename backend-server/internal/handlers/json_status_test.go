@@ -0,0 +1,169 @@
+// json_status_test.go - APIのクライアントへHTMLのプレースホルダーの代わりにJSONの状態を返すことのテスト
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+// newJSONStatusRouter キャッシュが空のリポジトリと、デフォルトページを置いたService層を使うルーター
+func newJSONStatusRouter(t *testing.T) (*gin.Engine, *fakes.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(testPlaceholderHTML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	repo := fakes.NewRepository(0)
+	svc := service.NewBpService(echoGateway{}, repo, dir, "index.html", 0, model.DeliveryEstimate{}, true, nil)
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 2*time.Minute, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	return r, repo
+}
+
+func serveJSONStatus(r *gin.Engine, target, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJSONStatusNegotiation(t *testing.T) {
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	tests := []struct {
+		name     string
+		target   string
+		accept   string
+		wantJSON bool
+	}{
+		{"accept json", "http://example.com/api/items", "application/json", true},
+		{"accept json with wildcard", "http://example.com/api/items", "application/json, text/plain, */*", true},
+		{"json preferred by q", "http://example.com/api/items", "text/html;q=0.5, application/json", true},
+		{"json url without accept", "http://example.com/data/items.json", "", true},
+		{"json url with wildcard", "http://example.com/data/items.json?page=2", "*/*", true},
+		{"browser on page", "http://example.com/page", browser, false},
+		// ブラウザでJSONのURLを開いた場合は人向けのプレースホルダーを返す
+		{"browser on json url", "http://example.com/data/items.json", browser, false},
+		{"wildcard on page", "http://example.com/page", "*/*", false},
+		{"html preferred by q", "http://example.com/api/items", "application/json;q=0.5, text/html", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newJSONStatusRouter(t)
+			rec := serveJSONStatus(r, tt.target, tt.accept)
+
+			isJSON := strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json")
+			if isJSON != tt.wantJSON {
+				t.Fatalf("Expected JSON=%v, got status %d, Content-Type %q", tt.wantJSON, rec.Code, rec.Header().Get("Content-Type"))
+			}
+			wantCode := http.StatusOK
+			if tt.wantJSON {
+				wantCode = http.StatusAccepted
+			}
+			if rec.Code != wantCode {
+				t.Errorf("Expected %d, got %d", wantCode, rec.Code)
+			}
+			if !tt.wantJSON && !strings.Contains(rec.Body.String(), "<h1>wait</h1>") {
+				t.Errorf("Expected the HTML default page, got:\n%s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestJSONStatusPayload(t *testing.T) {
+	r, repo := newJSONStatusRouter(t)
+	target := "http://example.com/api/items?q=a b&page=2"
+	before := time.Now().Truncate(time.Second)
+
+	rec := serveJSONStatus(r, strings.ReplaceAll(target, " ", "%20"), "application/json")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("Expected JSON content type, got %q", got)
+	}
+	if rec.Header().Get("Retry-After") != "120" || rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected Retry-After and X-Cache headers, got %v", rec.Header())
+	}
+	if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != 1 {
+		t.Errorf("Expected the request to be reserved, got %d reservation(s)", len(reserved))
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("Expected a JSON body, got %s (%v)", rec.Body.String(), err)
+	}
+	// 到着予定は往復時間の目安を設定していないため含めない
+	if len(payload) != 6 || payload["position"] != 1.0 || payload["queue_length"] != 1.0 {
+		t.Errorf("Expected exactly status, url, queued_at, position 1, queue_length 1 and poll, got %v", payload)
+	}
+	wantURL := "http://example.com/api/items?q=a%20b&page=2"
+	if payload["status"] != "queued" || payload["url"] != wantURL {
+		t.Errorf("Expected queued status for %s, got %v", wantURL, payload)
+	}
+	if want := "/system/status?url=http%3A%2F%2Fexample.com%2Fapi%2Fitems%3Fq%3Da%2520b%26page%3D2"; payload["poll"] != want {
+		t.Errorf("Expected poll %s, got %v", want, payload["poll"])
+	}
+	queuedAt, err := time.Parse(time.RFC3339Nano, payload["queued_at"].(string))
+	if err != nil || queuedAt.Before(before) {
+		t.Errorf("Expected queued_at to be the reservation time, got %v (%v)", payload["queued_at"], err)
+	}
+
+	// 既に予約されている場合は、その予約の時刻を返す
+	again := serveJSONStatus(r, strings.ReplaceAll(target, " ", "%20"), "application/json")
+	var second map[string]any
+	if err := json.Unmarshal(again.Body.Bytes(), &second); err != nil || again.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 JSON again, got %d %s", again.Code, again.Body.String())
+	}
+	if second["queued_at"] != payload["queued_at"] {
+		t.Errorf("Expected the existing reservation time %v, got %v", payload["queued_at"], second["queued_at"])
+	}
+	if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != 1 {
+		t.Errorf("Expected no second reservation, got %d", len(reserved))
+	}
+}
+
+func TestJSONStatusNotQueued(t *testing.T) {
+	r, repo := newJSONStatusRouter(t)
+
+	// 除外ドメインは予約しないため、queuedとは返さない
+	rec := serveJSONStatus(r, "http://www.mozilla.com/api/items", "application/json")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("Expected a JSON body, got %s (%v)", rec.Body.String(), err)
+	}
+	if payload["status"] != "unavailable" {
+		t.Errorf("Expected unavailable status, got %v", payload)
+	}
+	if _, ok := payload["queued_at"]; ok {
+		t.Errorf("Expected no queued_at without a reservation, got %v", payload)
+	}
+	if rec.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected no Retry-After without a reservation, got %q", rec.Header().Get("Retry-After"))
+	}
+	if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != 0 {
+		t.Errorf("Expected no reservation, got %d", len(reserved))
+	}
+}
@@ -0,0 +1,124 @@
+// metrics_test.go - ハンドラー・Service層・ゲートウェイのメトリクスのテスト
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
+)
+
+// gatheredValue regから集めたnameのメトリクスのうちlabelsに一致するものの値を返す
+// カウンターは値、ヒストグラムは観測数を返す
+func gatheredValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range family.GetMetric() {
+			for _, pair := range m.GetLabel() {
+				if want, ok := labels[pair.GetName()]; ok && want != pair.GetValue() {
+					continue next
+				}
+			}
+			if m.GetHistogram() != nil {
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestProxyMetrics(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("posted"))
+	}))
+	defer origin.Close()
+
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	// /cachedだけをキャッシュヒットにする
+	repo := fakes.NewRepository(0)
+	cached := &model.BpRequest{Method: http.MethodGet, URL: "http://example.com/cached"}
+	if err := repo.SetResponseWithURL(context.Background(), cached, &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("cached"), ContentLength: 6}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := module.NewDomainFilter(nil, []string{"blocked.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewBpService(gateway.NewLocalGateway(5*time.Second, m), repo, "", "", 0, model.DeliveryEstimate{}, true, m)
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, filter, nil), m, 0, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "http://example.com/cached", nil),
+		httptest.NewRequest(http.MethodGet, "http://example.com/cached", nil),
+		httptest.NewRequest(http.MethodGet, "http://example.com/new", nil),
+		httptest.NewRequest(http.MethodPost, origin.URL+"/form", strings.NewReader("a=1")),
+		httptest.NewRequest(http.MethodGet, "http://blocked.example/", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	requests := []struct {
+		method, outcome string
+		want            float64
+	}{
+		{http.MethodGet, string(model.CacheHit), 2},
+		{http.MethodGet, string(model.CacheMissReserved), 1},
+		{http.MethodPost, string(model.CacheMissDirect), 1},
+		{http.MethodGet, outcomeBlocked, 1},
+	}
+	for _, tt := range requests {
+		labels := map[string]string{"method": tt.method, "outcome": tt.outcome}
+		if got := gatheredValue(t, reg, "bp_proxy_http_requests_total", labels); got != tt.want {
+			t.Errorf("expected %v %s %s requests, got %v", tt.want, tt.method, tt.outcome, got)
+		}
+		if got := gatheredValue(t, reg, "bp_proxy_http_request_duration_seconds", labels); got != tt.want {
+			t.Errorf("expected %v latency observations for %s %s, got %v", tt.want, tt.method, tt.outcome, got)
+		}
+	}
+
+	// ブロックしたリクエストはService層に届かない
+	for status, want := range map[model.CacheStatus]float64{model.CacheHit: 2, model.CacheMissReserved: 1, model.CacheMissDirect: 1} {
+		if got := gatheredValue(t, reg, "bp_proxy_cache_results_total", map[string]string{"status": string(status)}); got != want {
+			t.Errorf("expected %v %s cache results, got %v", want, status, got)
+		}
+	}
+	if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != 1 {
+		t.Errorf("expected 1 reservation, got %d", len(reserved))
+	}
+
+	// 直接転送したリクエストだけがゲートウェイを通る（ローカルゲートウェイはバンドルを送らない）
+	if got := gatheredValue(t, reg, "bp_proxy_gateway_round_trip_seconds", map[string]string{"transport": "local", "result": "ok"}); got != 1 {
+		t.Errorf("expected 1 gateway round trip, got %v", got)
+	}
+	if got := gatheredValue(t, reg, "bp_proxy_gateway_bundles_sent_total", nil); got != 0 {
+		t.Errorf("expected no bundles sent by the local gateway, got %v", got)
+	}
+}
@@ -0,0 +1,107 @@
+// mock_gateway_flow_test.go - mockモードのゲートウェイで、プレースホルダー・遅れて届くレスポンス・キャッシュヒットまでを通しで確かめるテスト
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/worker"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+// mockScenariosPath ゲートウェイのテストと共有するmockモードの振る舞い
+const mockScenariosPath = "../infrastructure/gateway/testdata/mock_scenarios.json"
+
+func TestMockGatewayDelayedDeliveryFlow(t *testing.T) {
+	gw, err := gateway.NewGateway(gateway.Config{TransportMode: "mock", MockScenarios: mockScenariosPath}, nil)
+	if err != nil {
+		t.Fatalf("NewGateway failed: %v", err)
+	}
+	t.Cleanup(func() { gw.(interface{ Close() error }).Close() })
+
+	repo := fakes.NewRepository(0)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(testPlaceholderHTML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewBpService(gw, repo, dir, "index.html", 0, model.DeliveryEstimate{}, true, nil)
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 2*time.Minute, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+
+	ttlPolicy := model.TTLPolicy{Default: time.Hour}
+	rh := worker.NewRequestHandler(repo, gw, ttlPolicy, 0, 0, 0, nil, 0, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.NewResponseWatcher(gw, repo, ttlPolicy, nil, nil).Start(ctx)
+
+	const target = "http://example.com/late"
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// 1. キャッシュがないため予約し、プレースホルダーを返す
+	rec := get()
+	if rec.Header().Get("X-Bp-Queue-Status") != string(model.CacheMissReserved) || !strings.Contains(rec.Body.String(), "<h1>wait</h1>") {
+		t.Fatalf("Expected the placeholder for a new reservation, got %q %q", rec.Header().Get("X-Bp-Queue-Status"), rec.Body.String())
+	}
+
+	// 2. ワーカーが送るが、レスポンスは期限までに届かず予約をキューに戻す
+	reserved, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || reserved == nil {
+		t.Fatalf("Expected a reservation, got %v %v", reserved, err)
+	}
+	if err := rh.HandleRequest(ctx, reserved, 1); !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Fatalf("Expected the request to time out, got %v", err)
+	}
+	key := reserved.GenerateCacheKey()
+	if !repo.IsReserved(key) {
+		t.Fatal("Expected the reservation to be kept after the timeout")
+	}
+
+	// 3. 遅れて届いたレスポンスをResponseWatcherがキャッシュに保存する
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, found, _ := repo.GetResponse(ctx, key); found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the late response to be cached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 4. 次のアクセスはキャッシュから返す
+	rec = get()
+	if rec.Header().Get("X-Cache") != "HIT" || !strings.Contains(rec.Body.String(), "<h1>late</h1>") {
+		t.Fatalf("Expected a cache hit with the late response, got %q %q", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+
+	// キューに戻した予約は、キャッシュがあるため送らずに削除する
+	requeued, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || requeued == nil {
+		t.Fatalf("Expected the requeued reservation, got %v %v", requeued, err)
+	}
+	if err := rh.HandleRequest(ctx, requeued, 1); err != nil {
+		t.Fatalf("HandleRequest failed: %v", err)
+	}
+	if repo.IsReserved(key) {
+		t.Error("Expected the reservation to be removed once the page is cached")
+	}
+}
@@ -0,0 +1,82 @@
+// negative_test.go - ネガティブキャッシュ（短い期間だけ保存したエラーレスポンス）を予約し直さずに返すことのテスト
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+func TestNegativeCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		expired  bool
+		header   http.Header
+		code     int
+		xCache   string
+		status   string
+		reserved bool
+	}{
+		{"served", false, nil, http.StatusNotFound, "NEGATIVE", "negative", false},
+		// 予約した場合のプレースホルダーのステータスは確認しない（0）
+		// 強制的に再読み込みした場合はネガティブキャッシュを使わずに予約し直す
+		{"no-cache", false, http.Header{"Cache-Control": {"no-cache"}}, 0, "MISS", "miss-reserved", true},
+		{"max-age=0", false, http.Header{"Cache-Control": {"max-age=0"}}, 0, "MISS", "miss-reserved", true},
+		{"pragma", false, http.Header{"Pragma": {"no-cache"}}, 0, "MISS", "miss-reserved", true},
+		{"expired", true, nil, 0, "MISS", "miss-reserved", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 期限切れのネガティブキャッシュは保持しないため、expiredの場合はキャッシュがない
+			repo := fakes.NewRepository(0)
+			if !tt.expired {
+				missing := &model.BpRequest{Method: http.MethodGet, URL: "http://example.com/missing.html"}
+				negative := &model.BpResponse{StatusCode: http.StatusNotFound, Body: []byte("no such page"), ContentType: "text/plain", Negative: true}
+				if err := repo.SetResponseWithURL(context.Background(), missing, negative, 4*time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h := NewBpHandler(service.NewBpService(&recordingGateway{}, repo, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.NoRoute(h.GetContent)
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/missing.html", nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if tt.code != 0 && rec.Code != tt.code {
+				t.Errorf("Expected status %d, got %d", tt.code, rec.Code)
+			}
+			if got := rec.Header().Get("X-Cache"); got != tt.xCache {
+				t.Errorf("Expected X-Cache %s, got %q", tt.xCache, got)
+			}
+			if got := rec.Header().Get("X-Bp-Queue-Status"); got != tt.status {
+				t.Errorf("Expected status %s, got %q", tt.status, got)
+			}
+			reserved, _ := repo.GetReservedRequests(context.Background())
+			if got := len(reserved) > 0; got != tt.reserved {
+				t.Errorf("Expected reserved=%v, got %d reservations", tt.reserved, len(reserved))
+			}
+			if tt.xCache == "NEGATIVE" {
+				if rec.Body.String() != "no such page" {
+					t.Errorf("Expected the cached error body, got %q", rec.Body.String())
+				}
+				if rec.Header().Get("Age") == "" {
+					t.Error("Expected Age on a negative entry")
+				}
+			}
+		})
+	}
+}
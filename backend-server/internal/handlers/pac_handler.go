@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pacContentType PACファイルのContent-Type
+const pacContentType = "application/x-ns-proxy-autoconfig"
+
+// privateNetworks プロキシを通さないIPv4アドレスの範囲（ループバックとRFC1918）
+var privateNetworks = []struct{ network, mask string }{
+	{"127.0.0.0", "255.0.0.0"},
+	{"10.0.0.0", "255.0.0.0"},
+	{"172.16.0.0", "255.240.0.0"},
+	{"192.168.0.0", "255.255.0.0"},
+}
+
+// pacHandler クライアントのプロキシ自動設定（PAC）ファイルを生成する
+type pacHandler struct {
+	advertiseAddr string
+	bypass        []string
+}
+
+// NewPACHandler advertiseAddrはクライアントに案内するこのプロキシのhost:port（空の場合はリクエストのHost）
+// bypassはプロキシを通さずDIRECTにするホスト（localhostとプライベートアドレスは指定しなくてもDIRECT）
+func NewPACHandler(advertiseAddr string, bypass []string) *pacHandler {
+	return &pacHandler{advertiseAddr: advertiseAddr, bypass: bypass}
+}
+
+// GetPAC http://とhttps://の通信をこのプロキシへ向けるPACファイルを返す
+// GET /system/proxy.pac
+func (ph *pacHandler) GetPAC(c *gin.Context) {
+	addr := ph.advertiseAddr
+	if addr == "" {
+		addr = c.Request.Host
+	}
+	if addr == "" {
+		c.String(http.StatusInternalServerError, "proxy address is unknown (set server.proxy_advertise_addr)")
+		return
+	}
+	// PROXYにはポートが必要（Hostヘッダーは80番ポートの場合に省略される）
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "80")
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, pacContentType, []byte(renderPAC(addr, ph.bypass)))
+}
+
+// renderPAC PACファイルのJavaScriptを生成する
+// 文字列はjsQuoteで埋め込み、設定値がスクリプトを壊さないようにする
+// isInNetはホスト名をDNSで解決してしまうため、IPv4アドレスのリテラルの場合だけ使う（DTN環境ではDNSが使えないことがある）
+func renderPAC(proxyAddr string, bypass []string) string {
+	var b strings.Builder
+	b.WriteString("// BP proxy auto-config (generated by /system/proxy.pac)\n")
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("    host = host.toLowerCase();\n")
+	b.WriteString("\n    // localhost\n")
+	b.WriteString("    if (isPlainHostName(host) || host === \"localhost\" || dnsDomainIs(host, \".localhost\") || host === \"::1\" || host === \"[::1]\") {\n")
+	b.WriteString("        return \"DIRECT\";\n    }\n")
+
+	b.WriteString("\n    // ループバックとプライベートアドレス（RFC1918）\n")
+	b.WriteString("    if (/^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host)) {\n")
+	for _, n := range privateNetworks {
+		fmt.Fprintf(&b, "        if (isInNet(host, %s, %s)) return \"DIRECT\";\n", jsQuote(n.network), jsQuote(n.mask))
+	}
+	b.WriteString("    }\n")
+
+	if len(bypass) > 0 {
+		b.WriteString("\n    // 設定したバイパスリスト\n")
+		for _, entry := range bypass {
+			entry = strings.ToLower(strings.TrimSpace(entry))
+			switch {
+			case entry == "":
+				continue
+			case strings.ContainsAny(entry, "*?"):
+				fmt.Fprintf(&b, "    if (shExpMatch(host, %s)) return \"DIRECT\";\n", jsQuote(entry))
+			default:
+				entry = strings.TrimPrefix(entry, ".")
+				fmt.Fprintf(&b, "    if (host === %s || dnsDomainIs(host, %s)) return \"DIRECT\";\n", jsQuote(entry), jsQuote("."+entry))
+			}
+		}
+	}
+
+	b.WriteString("\n    // HTTPとHTTPSはDTN経由のプロキシへ\n")
+	b.WriteString("    if (url.substring(0, 5) === \"http:\" || url.substring(0, 6) === \"https:\") {\n")
+	fmt.Fprintf(&b, "        return %s;\n", jsQuote("PROXY "+proxyAddr))
+	b.WriteString("    }\n")
+	b.WriteString("    return \"DIRECT\";\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// jsQuote JavaScriptの文字列リテラルにする
+func jsQuote(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
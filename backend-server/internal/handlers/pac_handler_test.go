@@ -0,0 +1,89 @@
+// pac_handler_test.go - プロキシ自動設定（PAC）ファイルのテスト
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func servePAC(t *testing.T, h *pacHandler, host string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/system/proxy.pac", h.GetPAC)
+	req := httptest.NewRequest(http.MethodGet, "/system/proxy.pac", nil)
+	req.Host = host
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("Expected application/x-ns-proxy-autoconfig, got %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestPACDefaultsToRequestHost(t *testing.T) {
+	pac := servePAC(t, NewPACHandler("", nil), "192.168.10.5:8082")
+
+	for _, want := range []string{
+		"function FindProxyForURL(url, host) {",
+		`return "PROXY 192.168.10.5:8082";`,
+		`url.substring(0, 5) === "http:" || url.substring(0, 6) === "https:"`,
+		`host === "localhost"`,
+		`isInNet(host, "127.0.0.0", "255.0.0.0")`,
+		`isInNet(host, "10.0.0.0", "255.0.0.0")`,
+		`isInNet(host, "172.16.0.0", "255.240.0.0")`,
+		`isInNet(host, "192.168.0.0", "255.255.0.0")`,
+	} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("Expected PAC to contain %q, got:\n%s", want, pac)
+		}
+	}
+	if strings.Contains(pac, "バイパスリスト") {
+		t.Errorf("Expected no bypass section without a bypass list, got:\n%s", pac)
+	}
+	// DIRECTの判定がPROXYより先にある
+	if strings.Index(pac, `isInNet(host, "10.0.0.0"`) > strings.Index(pac, "PROXY") {
+		t.Errorf("Expected private ranges to be checked before returning PROXY")
+	}
+	if strings.Count(pac, "{") != strings.Count(pac, "}") {
+		t.Errorf("Expected balanced braces, got:\n%s", pac)
+	}
+
+	// ポートを省略したHost（80番ポート）
+	if pac := servePAC(t, NewPACHandler("", nil), "proxy.habitat"); !strings.Contains(pac, `"PROXY proxy.habitat:80"`) {
+		t.Errorf("Expected port 80 to be added, got:\n%s", pac)
+	}
+}
+
+func TestPACAdvertiseAddrAndBypass(t *testing.T) {
+	h := NewPACHandler("proxy.habitat.lan:3128", []string{"Habitat.local", ".ops.example", "*.lan", " ", `evil"); alert(1); ("`})
+	pac := servePAC(t, h, "10.0.0.1:8082")
+
+	for _, want := range []string{
+		`return "PROXY proxy.habitat.lan:3128";`,
+		`if (host === "habitat.local" || dnsDomainIs(host, ".habitat.local")) return "DIRECT";`,
+		`if (host === "ops.example" || dnsDomainIs(host, ".ops.example")) return "DIRECT";`,
+		`if (shExpMatch(host, "*.lan")) return "DIRECT";`,
+		`"evil\"); alert(1); (\""`,
+	} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("Expected PAC to contain %q, got:\n%s", want, pac)
+		}
+	}
+	if strings.Contains(pac, "10.0.0.1:8082") {
+		t.Errorf("Expected the advertised address to override the request Host, got:\n%s", pac)
+	}
+	if strings.Contains(pac, `host === ""`) {
+		t.Errorf("Expected blank bypass entries to be skipped, got:\n%s", pac)
+	}
+	if strings.Index(pac, "*.lan") > strings.Index(pac, "PROXY") {
+		t.Errorf("Expected the bypass list to be checked before returning PROXY")
+	}
+}
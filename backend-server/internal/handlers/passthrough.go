@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// handlePassthrough パススルーの接続先へのCONNECTを、SSL Bumpせずに接続先とのTCP接続へそのまま中継する
+// 暗号化されたバイト列を中継するだけのため、キャッシュも予約もせず、DTNも通らない
+// 接続先に直接接続できない場合（直接の経路がない場合など）は502を返す
+func (bh *bpHandler) handlePassthrough(c *gin.Context, passthrough *module.Passthrough) {
+	host := c.Request.Host
+	w := c.Writer
+
+	// トンネルを確立する前に接続を試し、失敗した場合はCONNECTのエラーとして返す
+	upstream, err := passthrough.Dial(host)
+	if err != nil {
+		log.Printf("[BpHandler] Passthrough %s: direct connection failed: %v", host, err)
+		page := bh.newErrorPage(http.StatusBadGateway, reasonDirectUnreachable, "https://"+host, requestid.FromContext(c.Request.Context()))
+		bh.writeErrorPage(w, c.Request.Header.Get("Accept"), page)
+		c.Abort()
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		c.Abort()
+		return
+	}
+	clientConn, brw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, "Failed to hijack connection", http.StatusInternalServerError)
+		c.Abort()
+		return
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return // Hijack後はc.Abort()を呼ばない
+	}
+	log.Printf("[BpHandler] Passthrough %s: splicing without SSL bump", host)
+
+	// Hijackする前にクライアントが送ったデータ（バッファに残っている分）を先に転送する
+	var buffered int64
+	if n := brw.Reader.Buffered(); n > 0 {
+		data, _ := brw.Reader.Peek(n)
+		if _, err := upstream.Write(data); err != nil {
+			log.Printf("[BpHandler] Passthrough %s: failed to forward buffered data: %v", host, err)
+			return
+		}
+		buffered = int64(n)
+	}
+
+	start := time.Now()
+	sent, received := passthrough.Splice(clientConn, upstream)
+	sent += buffered
+	bh.metrics.ObservePassthrough(sent, received)
+	log.Printf("[BpHandler] Passthrough %s closed: %d bytes sent, %d bytes received in %v", host, sent, received, time.Since(start).Round(time.Millisecond))
+}
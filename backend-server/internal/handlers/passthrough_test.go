@@ -0,0 +1,141 @@
+// passthrough_test.go - SSL Bumpせずに接続先へ中継するCONNECT（パススルー）のテスト
+package handlers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware/module"
+)
+
+// newPassthroughProxy patternsをパススルーするプロキシサーバーを起動する（SSL Bumpは使えない状態）
+func newPassthroughProxy(t *testing.T, svc proxyService, m *metrics.Metrics, patterns ...string) *httptest.Server {
+	t.Helper()
+	pt, err := module.NewPassthrough(patterns, time.Minute)
+	if err != nil {
+		t.Fatalf("NewPassthrough failed: %v", err)
+	}
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, pt), m, 0, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// connectTo プロキシにtargetへのCONNECTを送り、レスポンスと接続を返す
+func connectTo(t *testing.T, proxyAddr, target string) (*http.Response, net.Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatalf("Failed to read CONNECT response: %v", err)
+	}
+	return resp, conn
+}
+
+func TestCONNECTPassthrough(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pinned secret"))
+	}))
+	defer origin.Close()
+
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	svc := &recordingProxyService{}
+	srv := newPassthroughProxy(t, svc, m, "127.0.0.1")
+
+	resp, conn := connectTo(t, srv.Listener.Addr().String(), origin.Listener.Addr().String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for a passthrough CONNECT, got %d", resp.StatusCode)
+	}
+
+	// 接続先の証明書でハンドシェイクできる＝プロキシは復号できない
+	roots := x509.NewCertPool()
+	roots.AddCert(origin.Certificate())
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("TLS handshake with the origin failed: %v", err)
+	}
+	if !tlsConn.ConnectionState().PeerCertificates[0].Equal(origin.Certificate()) {
+		t.Fatal("Expected the origin's certificate, not a bumped one")
+	}
+
+	fmt.Fprintf(tlsConn, "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: close\r\n\r\n")
+	got, err := http.ReadResponse(bufio.NewReader(tlsConn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response through the tunnel: %v", err)
+	}
+	body, _ := io.ReadAll(got.Body)
+	if string(body) != "pinned secret" {
+		t.Errorf("Expected the origin's response, got %q", body)
+	}
+	tlsConn.Close()
+
+	// 平文のリクエストはService層に届かない（キャッシュも予約もしない）
+	if svc.last != nil {
+		t.Errorf("Expected the proxy never to see the plaintext request, got %+v", svc.last)
+	}
+
+	// トンネルが閉じた後に接続数とバイト数を記録する
+	deadline := time.Now().Add(5 * time.Second)
+	for gatheredValue(t, reg, "bp_proxy_passthrough_connections_total", nil) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the passthrough connection to be recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, direction := range []string{"sent", "received"} {
+		if got := gatheredValue(t, reg, "bp_proxy_passthrough_bytes_total", map[string]string{"direction": direction}); got == 0 {
+			t.Errorf("Expected %s bytes to be counted", direction)
+		}
+	}
+}
+
+func TestCONNECTPassthroughUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	closedAddr := ln.Addr().String()
+	ln.Close()
+
+	srv := newPassthroughProxy(t, &recordingProxyService{}, nil, "127.0.0.1")
+	resp, _ := connectTo(t, srv.Listener.Addr().String(), closedAddr)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected 502 when the target cannot be reached directly, got %d", resp.StatusCode)
+	}
+}
+
+// パススルーしない接続先は従来どおりSSL Bumpする（このプロキシではSSL Bumpが使えないため503）
+func TestCONNECTNotPassthrough(t *testing.T) {
+	srv := newPassthroughProxy(t, &recordingProxyService{}, nil, "*.bank.example")
+	resp, _ := connectTo(t, srv.Listener.Addr().String(), "example.com:443")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without SSL bump, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+const (
+	// placeholderPollInterval プレースホルダーのページが/system/statusに問い合わせる間隔
+	placeholderPollInterval = 5 * time.Second
+	// placeholderRefreshInterval スクリプトが動かない場合（JavaScript無効、HTTPSのページで/system/statusに届かないなど）のmeta refreshの間隔
+	placeholderRefreshInterval = 60 * time.Second
+	// placeholderTimeFormat プレースホルダーに表示する時刻の形式
+	placeholderTimeFormat = "2006-01-02 15:04:05 MST"
+)
+
+// isPlaceholder statusがプレースホルダーを返したことを表すか
+func isPlaceholder(status model.CacheStatus) bool {
+	return status == model.CacheMissReserved || status == model.CacheMissPlaceholder
+}
+
+// isHTMLResponse レスポンスがHTMLか（ContentTypeがなければヘッダーのContent-Typeを見る）
+func isHTMLResponse(resp *model.BpResponse) bool {
+	contentType := resp.ContentType
+	if contentType == "" {
+		contentType = http.Header(resp.Headers).Get("Content-Type")
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/html"
+}
+
+// withPlaceholderRefresh HTMLのプレースホルダーに、ページが届いたら自動で再読み込みするスクリプトと
+// 受付時刻・待ち順・到着予定時刻の表示を差し込んだレスポンスを返す
+// breqは元のリクエスト（/system/statusでキャッシュキーを一致させるためAccept・Accept-Languageを引き継ぐ）
+// プレースホルダー以外、またはHTML以外のレスポンスはそのまま返す
+func (bh *bpHandler) withPlaceholderRefresh(resp *model.BpResponse, status model.CacheStatus, breq *model.BpRequest) *model.BpResponse {
+	if !isPlaceholder(status) || resp.StatusCode != http.StatusOK || !isHTMLResponse(resp) {
+		return resp
+	}
+
+	// 同じページが既に予約されていた場合は、その予約の時刻を受付時刻として表示する
+	queuedAt := resp.ReservedAt
+	if queuedAt.IsZero() {
+		queuedAt = time.Now()
+	}
+	// Service層が予約キューでの位置から目安を計算していなければ、受付時刻からRetry-Afterの時間後とする
+	estimatedAt := resp.EstimatedAt
+	if estimatedAt.IsZero() && bh.retryAfter > 0 {
+		estimatedAt = queuedAt.Add(bh.retryAfter)
+	}
+	queue := placeholderQueue{queuedAt: queuedAt, estimatedAt: estimatedAt, position: resp.QueuePosition, length: resp.QueueLength}
+	body, err := injectPlaceholderRefresh(resp.Body, breq, queue)
+	if err != nil {
+		log.Printf("[BpHandler] Failed to inject refresh script into placeholder: %v", err)
+		return resp
+	}
+
+	// 元のレスポンスは書き換えずに、ボディを差し替えたコピーを返す
+	injected := *resp
+	injected.Body = body
+	injected.ContentLength = int64(len(body))
+	return &injected
+}
+
+// placeholderQueue プレースホルダーに表示する予約の状態
+type placeholderQueue struct {
+	queuedAt time.Time
+	// estimatedAt 到着予定時刻（ゼロの場合は表示しない）
+	estimatedAt time.Time
+	// position・length 予約キューでの位置（1から、0は転送中）とキューの長さ（lengthが0の場合は分からないため表示しない）
+	position int
+	length   int
+}
+
+// injectPlaceholderRefresh プレースホルダーのHTMLに次のものを差し込む
+//   - </head>の前: meta refresh（スクリプトが動かない場合のフォールバック）
+//   - </body>の前: 受付時刻・待ち順・到着予定時刻の表示と、/system/statusをポーリングしてstate=cachedで再読み込みするスクリプト
+//     （ポーリングのたびに待ち順と到着予定時刻を最新の値に更新する）
+func injectPlaceholderRefresh(body []byte, breq *model.BpRequest, queue placeholderQueue) ([]byte, error) {
+	// 元のリクエストと同じキャッシュキーになるよう、問い合わせにも同じヘッダーを付ける
+	headers := make(map[string]string)
+	for _, name := range model.CacheKeyHeaders {
+		if values := http.Header(breq.Headers).Values(name); len(values) > 0 {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+
+	// json.Marshalは<、>、&をエスケープするため、<script>内の文字列リテラルとしてそのまま埋め込める
+	urlJSON, err := json.Marshal(breq.URL)
+	if err != nil {
+		return nil, err
+	}
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := fmt.Sprintf("<meta http-equiv=\"refresh\" content=\"%d\">\n", int(placeholderRefreshInterval/time.Second))
+
+	var info strings.Builder
+	info.WriteString("<div id=\"bp-placeholder-status\" style=\"position:fixed;bottom:1rem;left:0;right:0;text-align:center;font-size:0.9rem;opacity:0.8\">\n")
+	fmt.Fprintf(&info, "<div>リクエスト: %s</div>\n", html.EscapeString(breq.URL))
+	fmt.Fprintf(&info, "<div>受付時刻: %s</div>\n", html.EscapeString(queue.queuedAt.Format(placeholderTimeFormat)))
+	switch {
+	case queue.length > 0 && queue.position > 0:
+		fmt.Fprintf(&info, "<div id=\"bp-placeholder-position\">待ち順: %d / %d</div>\n", queue.position, queue.length)
+	case queue.length > 0:
+		info.WriteString("<div id=\"bp-placeholder-position\">待ち順: DTNへ転送中</div>\n")
+	}
+	if !queue.estimatedAt.IsZero() {
+		fmt.Fprintf(&info, "<div id=\"bp-placeholder-eta\">到着予定: %s 頃</div>\n", html.EscapeString(queue.estimatedAt.Format(placeholderTimeFormat)))
+	}
+	info.WriteString("</div>\n")
+
+	script := fmt.Sprintf(`<script>
+(function() {
+    var target = %s;
+    var headers = %s;
+    function show(id, text) {
+        var el = document.getElementById(id);
+        if (el) { el.textContent = text; }
+    }
+    function poll() {
+        fetch("/system/status?url=" + encodeURIComponent(target), { headers: headers, cache: "no-store" })
+            .then(function(r) { return r.ok ? r.json() : null; })
+            .then(function(s) {
+                if (s && s.state === "cached") {
+                    location.reload();
+                    return;
+                }
+                if (s && s.state === "queued") {
+                    show("bp-placeholder-position", s.position ? "待ち順: " + s.position + " / " + s.queue_length : "待ち順: DTNへ転送中");
+                    if (s.estimated_at) {
+                        show("bp-placeholder-eta", "到着予定: " + new Date(s.estimated_at).toLocaleString() + " 頃");
+                    }
+                }
+                setTimeout(poll, %d);
+            })
+            .catch(function() { setTimeout(poll, %d); });
+    }
+    setTimeout(poll, %d);
+})();
+</script>
+`, urlJSON, headersJSON, placeholderPollInterval.Milliseconds(), placeholderPollInterval.Milliseconds(), placeholderPollInterval.Milliseconds())
+
+	out := insertBefore(body, "</head>", meta)
+	out = insertBefore(out, "</body>", info.String()+script)
+	return out, nil
+}
+
+// insertBefore 最後に現れるtag（大文字小文字を区別しない）の直前にsnippetを挿入する（tagがなければ末尾に追加する）
+func insertBefore(body []byte, tag, snippet string) []byte {
+	i := bytes.LastIndex(bytes.ToLower(body), []byte(tag))
+	if i < 0 {
+		i = len(body)
+	}
+	out := make([]byte, 0, len(body)+len(snippet))
+	out = append(out, body[:i]...)
+	out = append(out, snippet...)
+	return append(out, body[i:]...)
+}
@@ -0,0 +1,109 @@
+// placeholder_test.go - プレースホルダーへの自動更新スクリプトの差し込みのテスト
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+const testPlaceholderHTML = "<!DOCTYPE html><html><head><title>wait</title></head><body><h1>wait</h1></body></html>"
+
+func servePlaceholder(t *testing.T, resp *model.BpResponse, status model.CacheStatus, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	h := NewBpHandler(&fakeProxyService{resp: resp, status: status}, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 2*time.Minute, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	return rec
+}
+
+func TestPlaceholderRefreshInjected(t *testing.T) {
+	// スクリプトやHTMLを壊しかねない文字を含むURL
+	target := `http://example.com/search?q=</script><b>"x"&y=1`
+	req := httptest.NewRequest(http.MethodGet, "/?url="+url.QueryEscape(target), nil)
+	req.Header.Set("Accept-Language", "ja")
+	resp := &model.BpResponse{StatusCode: http.StatusOK, Body: []byte(testPlaceholderHTML), ContentType: "text/html; charset=utf-8"}
+
+	body := servePlaceholder(t, resp, model.CacheMissReserved, req).Body.String()
+
+	wantURL := `"http://example.com/search?q=\u003c/script\u003e\u003cb\u003e\"x\"\u0026y=1"`
+	if !strings.Contains(body, "var target = "+wantURL+";") {
+		t.Errorf("Expected script to reference the escaped URL %s, got:\n%s", wantURL, body)
+	}
+	if strings.Contains(body, "</script><b>") {
+		t.Errorf("Expected the URL not to appear unescaped, got:\n%s", body)
+	}
+	if !strings.Contains(body, `var headers = {"Accept-Language":"ja"};`) {
+		t.Errorf("Expected the cache key headers to be forwarded to /system/status, got:\n%s", body)
+	}
+	if !strings.Contains(body, `fetch("/system/status?url=" + encodeURIComponent(target)`) {
+		t.Errorf("Expected the script to poll /system/status, got:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;/script&gt;&lt;b&gt;&#34;x&#34;&amp;y=1") {
+		t.Errorf("Expected the displayed URL to be HTML-escaped, got:\n%s", body)
+	}
+	if !strings.Contains(body, "受付時刻: ") || !strings.Contains(body, "到着予定: ") {
+		t.Errorf("Expected queued-at and estimated delivery times, got:\n%s", body)
+	}
+
+	head := strings.Index(body, "</head>")
+	meta := strings.Index(body, `<meta http-equiv="refresh" content="60">`)
+	if meta < 0 || meta > head {
+		t.Errorf("Expected meta refresh inside <head>, got:\n%s", body)
+	}
+	if script := strings.Index(body, "<script>"); script < 0 || script > strings.Index(body, "</body>") {
+		t.Errorf("Expected the script before </body>, got:\n%s", body)
+	}
+
+	if string(resp.Body) != testPlaceholderHTML {
+		t.Errorf("Expected the service's response to be left untouched")
+	}
+}
+
+func TestPlaceholderRefreshSkipped(t *testing.T) {
+	tests := []struct {
+		name   string
+		resp   *model.BpResponse
+		status model.CacheStatus
+	}{
+		{"non-html placeholder", &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("/* css */"), ContentType: "text/css; charset=utf-8"}, model.CacheMissReserved},
+		{"cache hit", &model.BpResponse{StatusCode: http.StatusOK, Body: []byte(testPlaceholderHTML), ContentType: "text/html"}, model.CacheHit},
+		{"direct", &model.BpResponse{StatusCode: http.StatusOK, Body: []byte(testPlaceholderHTML), ContentType: "text/html"}, model.CacheMissDirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := servePlaceholder(t, tt.resp, tt.status, httptest.NewRequest(http.MethodGet, "http://example.com/style.css", nil))
+			if got := rec.Body.String(); got != string(tt.resp.Body) {
+				t.Errorf("Expected body to be untouched, got:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestPlaceholderShowsExistingReservation(t *testing.T) {
+	// 同じページが既に予約されていた場合は、その予約の時刻と到着予定を表示する
+	reservedAt := time.Now().Add(-30 * time.Minute)
+	resp := &model.BpResponse{StatusCode: http.StatusOK, Body: []byte(testPlaceholderHTML), ContentType: "text/html", ReservedAt: reservedAt}
+
+	body := servePlaceholder(t, resp, model.CacheMissReserved, httptest.NewRequest(http.MethodGet, "http://example.com/", nil)).Body.String()
+
+	if want := "受付時刻: " + reservedAt.Format(placeholderTimeFormat); !strings.Contains(body, want) {
+		t.Errorf("Expected %q, got:\n%s", want, body)
+	}
+	if want := "到着予定: " + reservedAt.Add(2*time.Minute).Format(placeholderTimeFormat); !strings.Contains(body, want) {
+		t.Errorf("Expected %q, got:\n%s", want, body)
+	}
+}
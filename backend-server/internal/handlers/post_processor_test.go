@@ -0,0 +1,199 @@
+// post_processor_test.go - クライアントへ返す前にレスポンスを加工する処理（順序・Content-Typeによる絞り込み・失敗したときの扱い、リンクの書き換え、バナーの挿入）のテスト
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+const testCachedPage = `<html><body class="page"><a href="/next">next</a></body></html>`
+
+// htmlHitRepository GETリクエストを常にETag付きのHTMLのキャッシュヒットとして返すリポジトリ
+type htmlHitRepository struct {
+	repository.BpRepository
+	body string
+}
+
+func (r htmlHitRepository) GetResponse(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	return &model.BpResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string][]string{
+			"Content-Type":   {"text/html; charset=utf-8"},
+			"Content-Length": {strconv.Itoa(len(r.body))},
+			"Etag":           {`"v1"`},
+		},
+		Body:          []byte(r.body),
+		ContentLength: int64(len(r.body)),
+	}, true, nil
+}
+
+// appendProcessor ボディの末尾に文字列を付け足す処理
+func appendProcessor(s string) service.ResponsePostProcessor {
+	return service.ResponsePostProcessorFunc(func(ctx context.Context, breq *model.BpRequest, resp *model.BpResponse) error {
+		resp.Body = append(resp.Body, s...)
+		return nil
+	})
+}
+
+func servePostProcessed(svc *service.BpService, target string) *httptest.ResponseRecorder {
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestPostProcessorsRunInOrder(t *testing.T) {
+	svc := service.NewBpService(echoGateway{}, htmlHitRepository{body: testCachedPage}, "", "", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(appendProcessor("A"))
+	svc.AddPostProcessor(appendProcessor("B"), "text/html")
+	svc.AddPostProcessor(appendProcessor("C"))
+
+	rec := servePostProcessed(svc, "http://example.com/page")
+	want := testCachedPage + "ABC"
+	if rec.Body.String() != want {
+		t.Fatalf("Expected body %q, got %q", want, rec.Body.String())
+	}
+	// 加工前の長さのContent-Lengthは返さず、強いETagは弱いETagにする
+	if got := rec.Header().Get("Content-Length"); got == strconv.Itoa(len(testCachedPage)) {
+		t.Errorf("Expected no stale Content-Length, got %q", got)
+	}
+	if got := rec.Header().Get("ETag"); got != `W/"v1"` {
+		t.Errorf("Expected weak ETag, got %q", got)
+	}
+}
+
+func TestPostProcessorContentTypeFilter(t *testing.T) {
+	svc := service.NewBpService(echoGateway{}, htmlHitRepository{body: testCachedPage}, "", "", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(appendProcessor("[image]"), "image/")
+	svc.AddPostProcessor(appendProcessor("[json]"), "application/json")
+	svc.AddPostProcessor(appendProcessor("[html]"), "image/", "TEXT/HTML")
+
+	rec := servePostProcessed(svc, "http://example.com/page")
+	if want := testCachedPage + "[html]"; rec.Body.String() != want {
+		t.Errorf("Expected body %q, got %q", want, rec.Body.String())
+	}
+
+	// Content-Typeのないレスポンス（直接転送）には、絞り込んだ処理は適用しない
+	svc = service.NewBpService(echoGateway{}, hitRepository{}, "", "", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(appendProcessor("[html]"), "text/html")
+	rec = servePostProcessed(svc, "http://example.com/page")
+	if strings.Contains(rec.Body.String(), "[html]") {
+		t.Errorf("Expected the filtered processor to be skipped, got %q", rec.Body.String())
+	}
+}
+
+func TestFailingPostProcessorKeepsResponse(t *testing.T) {
+	svc := service.NewBpService(echoGateway{}, htmlHitRepository{body: testCachedPage}, "", "", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(appendProcessor("A"))
+	svc.AddPostProcessor(service.ResponsePostProcessorFunc(func(ctx context.Context, breq *model.BpRequest, resp *model.BpResponse) error {
+		resp.Body = []byte("broken")
+		resp.Headers["Etag"] = []string{"broken"}
+		return errors.New("failed")
+	}))
+	svc.AddPostProcessor(service.ResponsePostProcessorFunc(func(ctx context.Context, breq *model.BpRequest, resp *model.BpResponse) error {
+		resp.StatusCode = http.StatusTeapot
+		panic("boom")
+	}))
+	svc.AddPostProcessor(appendProcessor("B"))
+
+	rec := servePostProcessed(svc, "http://example.com/page")
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+	if want := testCachedPage + "AB"; rec.Body.String() != want {
+		t.Errorf("Expected body %q, got %q", want, rec.Body.String())
+	}
+	if got := rec.Header().Get("ETag"); got != `W/"v1"` {
+		t.Errorf("Expected ETag from before the failing processor, got %q", got)
+	}
+}
+
+func TestLinkRewriter(t *testing.T) {
+	page := `<html><body>
+<a href="/about">about</a>
+<a href="next?page=2#top">next</a>
+<a href="https://example.com:443/index.html">index</a>
+<img src="//example.com/logo.png">
+<a href="https://other.example.net/">other</a>
+<a href="http://example.com/insecure">insecure</a>
+<a href="mailto:admin@example.com">mail</a>
+<a href="#section">section</a>
+</body></html>`
+	svc := service.NewBpService(echoGateway{}, htmlHitRepository{body: page}, "", "", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(service.NewLinkRewriter(), "text/html")
+
+	rec := servePostProcessed(svc, "/?url="+"https%3A%2F%2Fexample.com%2Fdocs%2Fguide")
+	body := rec.Body.String()
+	for _, want := range []string{
+		`href="/?url=https%3A%2F%2Fexample.com%2Fabout"`,
+		`href="/?url=https%3A%2F%2Fexample.com%2Fdocs%2Fnext%3Fpage%3D2#top"`,
+		`href="/?url=https%3A%2F%2Fexample.com%3A443%2Findex.html"`,
+		`src="/?url=https%3A%2F%2Fexample.com%2Flogo.png"`,
+		// 他のオリジン（ホスト・スキームが異なる）とhttp以外のリンク、ページ内のリンクは書き換えない
+		`href="https://other.example.net/"`,
+		`href="http://example.com/insecure"`,
+		`href="mailto:admin@example.com"`,
+		`href="#section"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %s in\n%s", want, body)
+		}
+	}
+
+	// プロキシとして使った場合（リクエストURIが絶対URL）はリンクを書き換えない
+	rec = servePostProcessed(svc, "https://example.com/docs/guide")
+	if rec.Body.String() != page {
+		t.Errorf("Expected the page unchanged, got\n%s", rec.Body.String())
+	}
+}
+
+func TestBannerInjector(t *testing.T) {
+	const banner = `<div id="dtn-banner">via DTN</div>`
+	svc := service.NewBpService(echoGateway{}, htmlHitRepository{body: testCachedPage}, "", "", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(service.NewBannerInjector(banner), "text/html")
+
+	rec := servePostProcessed(svc, "http://example.com/page")
+	want := `<html><body class="page">` + banner + `<a href="/next">next</a></body></html>`
+	if rec.Body.String() != want {
+		t.Errorf("Expected body %q, got %q", want, rec.Body.String())
+	}
+
+	// <body>のない断片には先頭に挿入する
+	svc = service.NewBpService(echoGateway{}, htmlHitRepository{body: "<p>fragment</p>"}, "", "", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(service.NewBannerInjector(banner), "text/html")
+	if rec := servePostProcessed(svc, "http://example.com/fragment"); rec.Body.String() != banner+"<p>fragment</p>" {
+		t.Errorf("Expected the banner to be prepended, got %q", rec.Body.String())
+	}
+}
+
+func TestBannerInjectedIntoPlaceholder(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(testPlaceholderHTML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewBpService(echoGateway{}, fakes.NewRepository(0), dir, "index.html", 0, model.DeliveryEstimate{}, true, nil)
+	svc.AddPostProcessor(service.NewBannerInjector(`<div id="dtn-banner"></div>`), "text/html")
+
+	rec := servePostProcessed(svc, "http://example.com/page")
+	if !strings.Contains(rec.Body.String(), `<body><div id="dtn-banner"></div><h1>wait</h1>`) {
+		t.Errorf("Expected the banner in the placeholder, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// maxPrefetchURLs 1回の事前取得で受け付けるURLの数の上限
+const maxPrefetchURLs = 500
+
+// 事前取得したURLの扱い
+const (
+	prefetchQueued        = "queued"
+	prefetchAlreadyQueued = "already-queued"
+	prefetchAlreadyCached = "already-cached"
+	prefetchInvalid       = "invalid"
+	prefetchError         = "error"
+)
+
+// PrefetchTarget 事前取得するURL（"https://example.com/" または {"url": "https://example.com/", "depth": 1}）
+type PrefetchTarget struct {
+	URL string `json:"url"`
+	// Depth 取得したHTMLからたどるリンクの深さ（0はこのURLだけ、最大model.MaxPrefetchDepth）
+	Depth int `json:"depth,omitempty"`
+}
+
+func (pt *PrefetchTarget) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*pt = PrefetchTarget{}
+		return json.Unmarshal(data, &pt.URL)
+	}
+	type target PrefetchTarget
+	return json.Unmarshal(data, (*target)(pt))
+}
+
+// PrefetchResult 事前取得したURLごとの結果
+type PrefetchResult struct {
+	URL string `json:"url"`
+	// NormalizedURL キャッシュキーに使う正規化したURL（invalidの場合は省略）
+	NormalizedURL string `json:"normalized_url,omitempty"`
+	Depth         int    `json:"depth"`
+	// Disposition queued・already-queued・already-cached・invalid・error
+	Disposition string `json:"disposition"`
+	// QueuedAt 予約した時刻（already-queuedの場合は既にある予約の時刻）
+	QueuedAt *time.Time `json:"queued_at,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// PrefetchResponse 事前取得の結果
+type PrefetchResponse struct {
+	Queued  int              `json:"queued"`
+	Results []PrefetchResult `json:"results"`
+}
+
+// Prefetch URLのリストを事前取得として予約し、キャッシュを用意しておく
+// POST /system/admin/prefetch
+// ボディはURLの配列（["https://example.com/", {"url": "https://example.com/news/", "depth": 1}]）
+// 有効なキャッシュがあるURLは予約しない。予約はブラウザからのリクエストより後に処理する（低優先度のキュー）
+// リクエストのAccept・Accept-Languageは予約にも付ける（ブラウザと同じ値にすると、ブラウザからのリクエストと同じキャッシュになる）
+func (ah *adminHandler) Prefetch(c *gin.Context) {
+	var targets []PrefetchTarget
+	if err := json.NewDecoder(c.Request.Body).Decode(&targets); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Body must be a JSON array of URLs"})
+		return
+	}
+	if len(targets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No URLs to prefetch"})
+		return
+	}
+	if len(targets) > maxPrefetchURLs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Too many URLs (max %d)", maxPrefetchURLs)})
+		return
+	}
+
+	header := make(map[string][]string)
+	for _, name := range model.CacheKeyHeaders {
+		if values := c.Request.Header.Values(name); len(values) > 0 {
+			header[name] = values
+		}
+	}
+
+	resp := PrefetchResponse{Results: make([]PrefetchResult, 0, len(targets))}
+	for _, target := range targets {
+		result := ah.prefetch(c, target, header)
+		if result.Disposition == prefetchQueued {
+			resp.Queued++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	log.Printf("[AdminHandler] Prefetch: %d URL(s), %d queued", len(targets), resp.Queued)
+	c.JSON(http.StatusOK, resp)
+}
+
+// prefetch 1つのURLを検証し、有効なキャッシュがなければ事前取得として予約する
+func (ah *adminHandler) prefetch(c *gin.Context, target PrefetchTarget, header map[string][]string) PrefetchResult {
+	result := PrefetchResult{URL: target.URL, Depth: target.Depth}
+	if target.Depth < 0 || target.Depth > model.MaxPrefetchDepth {
+		result.Disposition = prefetchInvalid
+		result.Error = fmt.Sprintf("depth must be between 0 and %d", model.MaxPrefetchDepth)
+		return result
+	}
+	normalized, err := model.PrefetchTarget(target.URL)
+	if err != nil {
+		result.Disposition = prefetchInvalid
+		result.Error = err.Error()
+		return result
+	}
+	result.NormalizedURL = normalized
+
+	ctx := c.Request.Context()
+	req := &model.BpRequest{
+		Method:        http.MethodGet,
+		URL:           normalized,
+		Headers:       header,
+		Prefetch:      true,
+		PrefetchDepth: target.Depth,
+	}
+
+	// 有効なキャッシュがあれば予約しない（期限切れ・ネガティブキャッシュは取得し直す）
+	cached, found, err := ah.bprepo.GetResponseStream(ctx, req.GenerateCacheKey())
+	if err != nil {
+		log.Printf("[AdminHandler] GetResponseStream error (URL: %s): %v", normalized, err)
+		result.Disposition = prefetchError
+		result.Error = "Failed to look up cache"
+		return result
+	}
+	if found {
+		cached.Close()
+		if !cached.IsStale() && !cached.Negative {
+			result.Disposition = prefetchAlreadyCached
+			return result
+		}
+	}
+
+	reservation, err := ah.bprepo.ReserveRequest(ctx, req)
+	if err != nil {
+		log.Printf("[AdminHandler] ReserveRequest error (URL: %s): %v", normalized, err)
+		result.Disposition = prefetchError
+		result.Error = "Failed to reserve request"
+		return result
+	}
+	result.Disposition = prefetchQueued
+	if !reservation.Queued {
+		result.Disposition = prefetchAlreadyQueued
+	}
+	if !reservation.ReservedAt.IsZero() {
+		queuedAt := reservation.ReservedAt
+		result.QueuedAt = &queuedAt
+	}
+	return result
+}
@@ -0,0 +1,193 @@
+// prefetch_test.go - URLのリストを事前取得として予約する管理用エンドポイントのテスト
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+func postPrefetch(t *testing.T, repo *fakes.Repository, body string, header http.Header) (*httptest.ResponseRecorder, PrefetchResponse) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/system/admin/prefetch", NewAdminHandler(repo).Prefetch)
+
+	req := httptest.NewRequest(http.MethodPost, "/system/admin/prefetch", strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var resp PrefetchResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return rec, resp
+}
+
+func TestPrefetchDispositions(t *testing.T) {
+	saved := model.StripQueryParams
+	model.StripQueryParams = []string{"utm_*"}
+	defer func() { model.StripQueryParams = saved }()
+
+	// 期限切れのキャッシュも保持している間は返す
+	repo := fakes.NewRepository(time.Hour)
+	cache := func(u string, resp *model.BpResponse, ttl time.Duration) {
+		if err := repo.SetResponseWithURL(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: u}, resp, ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache("https://example.com/cached", &model.BpResponse{StatusCode: http.StatusOK}, time.Hour)
+	cache("https://example.com/stale", &model.BpResponse{StatusCode: http.StatusOK}, -time.Minute)
+	cache("https://example.com/missing", &model.BpResponse{StatusCode: http.StatusNotFound, Negative: true}, time.Minute)
+
+	body := `[
+		"https://Example.com/news?utm_source=mail&id=1",
+		{"url": "https://example.com/reading/", "depth": 2},
+		"https://example.com/news?id=1",
+		"https://example.com/cached",
+		"https://example.com/stale",
+		"https://example.com/missing",
+		"ftp://example.com/file",
+		"not a url",
+		{"url": "https://example.com/deep", "depth": 9}
+	]`
+	rec, resp := postPrefetch(t, repo, body, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	want := []struct {
+		disposition string
+		normalized  string
+	}{
+		{"queued", "https://example.com/news?id=1"},
+		{"queued", "https://example.com/reading/"},
+		// 正規化すると1つ目と同じURL
+		{"already-queued", "https://example.com/news?id=1"},
+		{"already-cached", "https://example.com/cached"},
+		// 期限切れとネガティブキャッシュは取得し直す
+		{"queued", "https://example.com/stale"},
+		{"queued", "https://example.com/missing"},
+		{"invalid", ""},
+		{"invalid", ""},
+		{"invalid", ""},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("Expected %d results, got %d", len(want), len(resp.Results))
+	}
+	for i, w := range want {
+		got := resp.Results[i]
+		if got.Disposition != w.disposition || got.NormalizedURL != w.normalized {
+			t.Errorf("Result %d: expected %s %q, got %s %q (%s)", i, w.disposition, w.normalized, got.Disposition, got.NormalizedURL, got.Error)
+		}
+		if got.Disposition == "invalid" && got.Error == "" {
+			t.Errorf("Result %d: expected a reason for the invalid URL", i)
+		}
+		if (got.Disposition == "queued" || got.Disposition == "already-queued") && got.QueuedAt == nil {
+			t.Errorf("Result %d: expected queued_at", i)
+		}
+	}
+	if resp.Queued != 4 {
+		t.Errorf("Expected 4 queued, got %d", resp.Queued)
+	}
+
+	// 事前取得の予約はすべて低優先度のキューに入る
+	reserved, err := repo.GetReservedRequests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reserved) != 4 {
+		t.Fatalf("Expected 4 reservations, got %d", len(reserved))
+	}
+	for _, req := range reserved {
+		if !req.Prefetch || req.Priority() != model.PriorityPrefetch {
+			t.Errorf("Expected %s to be marked as prefetch", req.URL)
+		}
+		if req.URL == "https://example.com/reading/" && req.PrefetchDepth != 2 {
+			t.Errorf("Expected depth 2 for the reading list, got %d", req.PrefetchDepth)
+		}
+	}
+}
+
+func TestPrefetchYieldsToBrowserRequests(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	if rec, _ := postPrefetch(t, repo, `["https://example.com/a", "https://example.com/b"]`, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	h := NewBpHandler(service.NewBpService(&recordingGateway{}, repo, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	for _, u := range []string{"http://example.com/browsing", "https://example.com/b"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, u, nil))
+	}
+
+	// ブラウザからの予約を事前取得より先に取り出す
+	// 事前取得と同じURLは、事前取得で予約した時刻のまま通常のキューへ移す（Redisの実装と同じ）
+	var order []string
+	for {
+		req, err := repo.BLPopReservedRequest(context.Background(), 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req == nil {
+			break
+		}
+		order = append(order, req.URL)
+	}
+	want := []string{"https://example.com/b", "http://example.com/browsing", "https://example.com/a"}
+	if strings.Join(order, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected order %v, got %v", want, order)
+	}
+}
+
+func TestPrefetchUsesCacheKeyHeaders(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	header := http.Header{"Accept-Language": {"ja"}, "Authorization": {"Bearer admin"}}
+	if rec, _ := postPrefetch(t, repo, `["https://example.com/"]`, header); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	reserved, _ := repo.GetReservedRequests(context.Background())
+	if len(reserved) != 1 {
+		t.Fatalf("Expected 1 reservation, got %d", len(reserved))
+	}
+	// ブラウザと同じキャッシュキーになるヘッダーだけを付ける（管理用のトークンなどは付けない）
+	if got := reserved[0].Headers["Accept-Language"]; len(got) != 1 || got[0] != "ja" {
+		t.Errorf("Expected Accept-Language to be kept, got %v", got)
+	}
+	if _, ok := reserved[0].Headers["Authorization"]; ok {
+		t.Error("Expected Authorization not to be copied into the reservation")
+	}
+}
+
+func TestPrefetchBadRequest(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(`"https://example.com/",`, maxPrefetchURLs+1), ",") + "]"
+	for name, body := range map[string]string{
+		"not json": "https://example.com/",
+		"object":   `{"url": "https://example.com/"}`,
+		"empty":    `[]`,
+		"bad item": `[42]`,
+		"too many": tooMany,
+	} {
+		if rec, _ := postPrefetch(t, repo, body, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// queue_position_test.go - プレースホルダー・JSONの状態・/system/statusで予約キューでの位置と到着予定を返すことのテスト
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+// testDeliveryEstimate 2つずつ並行して送り、10分で届くとみなす
+var testDeliveryEstimate = model.DeliveryEstimate{RoundTrip: 10 * time.Minute, Concurrency: 2}
+
+// newQueueingRepository 他のページの予約がn件入っているリポジトリ
+func newQueueingRepository(t *testing.T, n int) *fakes.Repository {
+	t.Helper()
+	repo := fakes.NewRepository(0)
+	for i := 0; i < n; i++ {
+		if _, err := repo.ReserveRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: fmt.Sprintf("https://example.com/other/%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+// drain ワーカーがキューの先頭からn件を取り出したことを模擬する
+func drain(t *testing.T, repo *fakes.Repository, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if req, err := repo.BLPopReservedRequest(context.Background(), time.Millisecond); err != nil || req == nil {
+			t.Fatalf("Failed to pop reservation %d: %v", i, err)
+		}
+	}
+}
+
+func newQueueingRouter(t *testing.T, repo *fakes.Repository) *gin.Engine {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(testPlaceholderHTML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	svc := service.NewBpService(echoGateway{}, repo, dir, "index.html", 0, testDeliveryEstimate, true, nil)
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 2*time.Minute, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/system/status", NewStatusHandler(repo, nil, time.Minute, testDeliveryEstimate).GetStatus)
+	r.NoRoute(h.GetContent)
+	return r
+}
+
+// assertAround atが現在からdだけ後（秒単位で切り捨てた表示を含めて前後1秒以内）か
+func assertAround(t *testing.T, name string, at time.Time, before time.Time, d time.Duration) {
+	t.Helper()
+	if at.Before(before.Add(d).Add(-time.Second)) || at.After(time.Now().Add(d).Add(time.Second)) {
+		t.Errorf("Expected %s about %v from now, got %v", name, d, at)
+	}
+}
+
+func TestPlaceholderShowsQueuePosition(t *testing.T) {
+	repo := newQueueingRepository(t, 4)
+	r := newQueueingRouter(t, repo)
+
+	before := time.Now()
+	rec := serveJSONStatus(r, "http://example.com/page", "text/html")
+	body := rec.Body.String()
+	if !regexp.MustCompile(`待ち順: 5 / 5`).MatchString(body) {
+		t.Fatalf("Expected position 5 of 5, got:\n%s", body)
+	}
+	// 5番目は2件ずつ送る3回目で届く
+	m := regexp.MustCompile(`到着予定: (.+?) 頃`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("Expected an estimated delivery time, got:\n%s", body)
+	}
+	eta, err := time.ParseInLocation(placeholderTimeFormat, m[1], time.Local)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", m[1], err)
+	}
+	assertAround(t, "estimated delivery", eta, before, 30*time.Minute)
+}
+
+func TestJSONStatusIncludesQueuePosition(t *testing.T) {
+	repo := newQueueingRepository(t, 2)
+	r := newQueueingRouter(t, repo)
+
+	before := time.Now()
+	rec := serveJSONStatus(r, "http://example.com/api/items", "application/json")
+	var status service.QueuedStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Expected a JSON body, got %s (%v)", rec.Body.String(), err)
+	}
+	if status.Position != 3 || status.QueueLength != 3 || status.EstimatedAt == nil {
+		t.Fatalf("Expected position 3 of 3 with an estimate, got %s", rec.Body.String())
+	}
+	assertAround(t, "estimated_at", *status.EstimatedAt, before, 20*time.Minute)
+}
+
+func TestStatusPositionUpdatesAsQueueDrains(t *testing.T) {
+	repo := newQueueingRepository(t, 4)
+	r := newQueueingRouter(t, repo)
+	target := "http://example.com/page"
+	serveJSONStatus(r, target, "")
+
+	poll := func() URLStatus {
+		rec := serveJSONStatus(r, "/system/status?url="+url.QueryEscape(target), "")
+		var status URLStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to decode status %s: %v", rec.Body.String(), err)
+		}
+		return status
+	}
+
+	before := time.Now()
+	status := poll()
+	if status.State != "queued" || status.Position != 5 || status.QueueLength != 5 || status.EstimatedAt == nil {
+		t.Fatalf("Expected queued at position 5 of 5, got %+v", status)
+	}
+	assertAround(t, "estimated_at", *status.EstimatedAt, before, 30*time.Minute)
+
+	// ワーカーが3件を取り出すと、2番目になり最初の回で届く
+	drain(t, repo, 3)
+	before = time.Now()
+	status = poll()
+	if status.Position != 2 || status.QueueLength != 2 || status.EstimatedAt == nil {
+		t.Fatalf("Expected position 2 of 2, got %+v", status)
+	}
+	assertAround(t, "estimated_at", *status.EstimatedAt, before, 10*time.Minute)
+
+	// キューから取り出された後は位置を返さない
+	drain(t, repo, 2)
+	if status := poll(); status.State != "unknown" || status.Position != 0 || status.EstimatedAt != nil {
+		t.Errorf("Expected no position after the queue drained, got %+v", status)
+	}
+}
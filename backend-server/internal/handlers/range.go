@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// errRangeNotSatisfiable 範囲がボディの外にある、または複数の範囲を求めるRange（416で返す）
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// errRangeIgnored 解釈できないRange（Rangeがない場合と同じくボディ全体を返す）
+var errRangeIgnored = errors.New("range ignored")
+
+// withRange キャッシュから返すレスポンスに対して、クライアントのRangeリクエストを適用したコピーを返す
+// キャッシュした200のレスポンスにはAccept-Ranges: bytesを付け、GETのRangeが一つの範囲であれば206でその範囲だけを返す
+// 範囲がボディの外にある場合や複数の範囲を求める場合は416を返す
+// If-Rangeがキャッシュと一致しない場合や、キャッシュしていないレスポンスはそのまま（ボディ全体を）返す
+func withRange(method string, header http.Header, resp *model.BpResponse, status model.CacheStatus) *model.BpResponse {
+	if method != http.MethodGet && method != http.MethodHead {
+		return resp
+	}
+	if !status.IsHit() || resp == nil || resp.StatusCode != http.StatusOK {
+		return resp
+	}
+
+	ranged := *resp
+	ranged.Headers = http.Header(resp.Headers).Clone()
+	if ranged.Headers == nil {
+		ranged.Headers = make(map[string][]string)
+	}
+	h := http.Header(ranged.Headers)
+	h.Set("Accept-Ranges", "bytes")
+
+	value := header.Get("Range")
+	if method != http.MethodGet || value == "" || !ifRangeMatches(header.Get("If-Range"), http.Header(resp.Headers)) {
+		return &ranged
+	}
+
+	size := int64(len(resp.Body))
+	if resp.BodyStream != nil {
+		size = resp.ContentLength
+	}
+	start, length, err := parseRange(value, size)
+	if errors.Is(err, errRangeIgnored) {
+		return &ranged
+	}
+	if err != nil {
+		// 416ではボディを返さないため、キャッシュファイルは呼び出し元が元のレスポンスごと閉じる
+		return &model.BpResponse{
+			StatusCode: http.StatusRequestedRangeNotSatisfiable,
+			Headers: map[string][]string{
+				"Accept-Ranges": {"bytes"},
+				"Content-Range": {fmt.Sprintf("bytes */%d", size)},
+			},
+			CachedAt:  resp.CachedAt,
+			ExpiresAt: resp.ExpiresAt,
+		}
+	}
+
+	ranged.StatusCode = http.StatusPartialContent
+	ranged.ContentLength = length
+	h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	if resp.BodyStream != nil {
+		ranged.BodyStream = rangeStream(resp.BodyStream, start, length)
+	} else {
+		ranged.Body = resp.Body[start : start+length]
+	}
+	return &ranged
+}
+
+// parseRange "bytes=start-end"・"bytes=start-"・"bytes=-suffix"の一つの範囲を解釈し、開始位置と長さを返す（RFC 9110 14.1.2）
+// 単位がbytes以外の場合や書式が正しくない場合はerrRangeIgnored、範囲がボディの外にある場合や複数の範囲の場合はerrRangeNotSatisfiable
+func parseRange(value string, size int64) (start, length int64, err error) {
+	unit, spec, ok := strings.Cut(value, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return 0, 0, errRangeIgnored
+	}
+	if strings.Contains(spec, ",") {
+		// 複数の範囲（multipart/byteranges）には対応しない
+		return 0, 0, errRangeNotSatisfiable
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeIgnored
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+	if first == "" {
+		// 末尾からsuffixバイト
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, errRangeIgnored
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeIgnored
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errRangeIgnored
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, nil
+}
+
+// ifRangeMatches If-Rangeがない場合、またはキャッシュしたレスポンスの強いETag・Last-Modifiedと一致する場合にRangeを適用する
+// 一致しない場合はクライアントが持っている部分が古いため、ボディ全体を返す（RFC 9110 13.1.5）
+func ifRangeMatches(ifRange string, cached http.Header) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		etag := cached.Get("ETag")
+		return isStrongETag(ifRange) && isStrongETag(etag) && ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(cached.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return date.Equal(lastModified)
+}
+
+// rangedBody キャッシュファイルの一部分だけを読み、Closeでファイルを閉じるボディ
+type rangedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// rangeStream キャッシュファイルのstartからlengthバイトだけを読むボディを返す
+// ファイル（io.ReaderAt）は読み始める位置から直接読み、それ以外は先頭を読み飛ばす
+func rangeStream(stream io.ReadCloser, start, length int64) io.ReadCloser {
+	if ra, ok := stream.(io.ReaderAt); ok {
+		return rangedBody{Reader: io.NewSectionReader(ra, start, length), Closer: stream}
+	}
+	if _, err := io.CopyN(io.Discard, stream, start); err != nil {
+		// 読み飛ばせない場合はボディが短くなり、書き込み時に接続が切れる
+		log.Printf("[BpHandler] Failed to skip to range start %d: %v", start, err)
+	}
+	return rangedBody{Reader: io.LimitReader(stream, length), Closer: stream}
+}
@@ -0,0 +1,169 @@
+// range_test.go - キャッシュに対するRangeリクエスト（206 / 416 / If-Range）のテスト
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+func TestRangeRequests(t *testing.T) {
+	// キャッシュしたボディは"body { color: red; }"（20バイト）
+	tests := []struct {
+		name         string
+		status       model.CacheStatus
+		header       http.Header
+		want         int
+		body         string
+		contentRange string
+	}{
+		{"no range", model.CacheHit, nil, http.StatusOK, "body { color: red; }", ""},
+		{"closed range", model.CacheHit, http.Header{"Range": {"bytes=0-3"}}, http.StatusPartialContent, "body", "bytes 0-3/20"},
+		{"open-ended", model.CacheHit, http.Header{"Range": {"bytes=15-"}}, http.StatusPartialContent, "ed; }", "bytes 15-19/20"},
+		{"suffix", model.CacheStale, http.Header{"Range": {"bytes=-2"}}, http.StatusPartialContent, " }", "bytes 18-19/20"},
+		{"suffix longer than body", model.CacheHit, http.Header{"Range": {"bytes=-100"}}, http.StatusPartialContent, "body { color: red; }", "bytes 0-19/20"},
+		{"end past body", model.CacheHit, http.Header{"Range": {"bytes=10-100"}}, http.StatusPartialContent, "or: red; }", "bytes 10-19/20"},
+		{"start past body", model.CacheHit, http.Header{"Range": {"bytes=20-"}}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{"empty suffix", model.CacheHit, http.Header{"Range": {"bytes=-0"}}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{"multiple ranges", model.CacheHit, http.Header{"Range": {"bytes=0-1,4-5"}}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{"other unit", model.CacheHit, http.Header{"Range": {"items=0-1"}}, http.StatusOK, "body { color: red; }", ""},
+		{"malformed", model.CacheHit, http.Header{"Range": {"bytes=5-1"}}, http.StatusOK, "body { color: red; }", ""},
+		{"if-range etag", model.CacheHit, http.Header{"Range": {"bytes=0-3"}, "If-Range": {`"v1"`}}, http.StatusPartialContent, "body", "bytes 0-3/20"},
+		{"if-range etag mismatch", model.CacheHit, http.Header{"Range": {"bytes=0-3"}, "If-Range": {`"v2"`}}, http.StatusOK, "body { color: red; }", ""},
+		{"if-range weak etag", model.CacheHit, http.Header{"Range": {"bytes=0-3"}, "If-Range": {`W/"v1"`}}, http.StatusOK, "body { color: red; }", ""},
+		{"if-range date", model.CacheHit, http.Header{"Range": {"bytes=0-3"}, "If-Range": {testLastModified.Format(http.TimeFormat)}}, http.StatusPartialContent, "body", "bytes 0-3/20"},
+		{"if-range older date", model.CacheHit, http.Header{"Range": {"bytes=0-3"}, "If-Range": {testLastModified.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusOK, "body { color: red; }", ""},
+		{"cache miss", model.CacheMissDirect, http.Header{"Range": {"bytes=0-3"}}, http.StatusOK, "body { color: red; }", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveConditional(t, cachedWithValidators(`"v1"`), tt.status, tt.header)
+			if rec.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+			if got := rec.Body.String(); got != tt.body {
+				t.Errorf("Expected body %q, got %q", tt.body, got)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Expected Content-Range %q, got %q", tt.contentRange, got)
+			}
+			if rec.Code == http.StatusPartialContent {
+				if got, want := rec.Header().Get("Content-Length"), len(tt.body); got != strconv.Itoa(want) {
+					t.Errorf("Expected Content-Length %d, got %q", want, got)
+				}
+			}
+			// キャッシュしたレスポンスだけが範囲に対応する
+			wantAccept := ""
+			if tt.status.IsHit() {
+				wantAccept = "bytes"
+			}
+			if got := rec.Header().Get("Accept-Ranges"); got != wantAccept {
+				t.Errorf("Expected Accept-Ranges %q, got %q", wantAccept, got)
+			}
+		})
+	}
+}
+
+func TestRangeStreamedCache(t *testing.T) {
+	repo := newStreamRepository(t, 4096)
+	h := newStreamingHandler(repo)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/video.mp4", nil)
+	req.Header.Set("Range", "bytes=1000-")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("Expected 206, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 1000-4095/4096" {
+		t.Errorf("Expected Content-Range for the rest of the file, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "3096" {
+		t.Errorf("Expected Content-Length 3096, got %q", got)
+	}
+	if rec.Body.Len() != 3096 {
+		t.Errorf("Expected 3096 bytes, got %d", rec.Body.Len())
+	}
+	waitClosed(t, repo)
+
+	// 範囲外の場合もキャッシュファイルを閉じる
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/video.mp4", nil)
+	req.Header.Set("Range", "bytes=4096-")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Body.Len() != 0 {
+		t.Fatalf("Expected an empty 416, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes */4096" {
+		t.Errorf("Expected the full length in Content-Range, got %q", got)
+	}
+	waitClosed(t, repo)
+}
+
+func TestRangeStream(t *testing.T) {
+	content := []byte("0123456789")
+	path := filepath.Join(t.TempDir(), "cache.bin")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streams := map[string]io.ReadCloser{
+		// ファイルは読み始める位置から直接読む
+		"file": file,
+		// io.ReaderAtでない場合は先頭を読み飛ばす
+		"reader": io.NopCloser(bytes.NewReader(content)),
+	}
+	for name, stream := range streams {
+		t.Run(name, func(t *testing.T) {
+			body := rangeStream(stream, 3, 4)
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "3456" {
+				t.Errorf("Expected %q, got %q", "3456", got)
+			}
+			if err := body.Close(); err != nil {
+				t.Errorf("Expected the stream to close, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRangeNotForwarded(t *testing.T) {
+	// キャッシュしていない場合はリソース全体を取得する
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/video.mp4", nil)
+	req.Header.Set("Range", "bytes=0-99")
+	req.Header.Set("If-Range", `"v1"`)
+
+	rec, got := serveProxyRequest(t, req)
+	if rec.Code != http.StatusOK || got == nil {
+		t.Fatalf("Expected the whole resource to be proxied, got %d", rec.Code)
+	}
+	if _, ok := got.Headers["Range"]; ok {
+		t.Error("Expected Range not to be forwarded")
+	}
+	if _, ok := got.Headers["If-Range"]; ok {
+		t.Error("Expected If-Range not to be forwarded")
+	}
+	if rec.Header().Get("Content-Range") != "" {
+		t.Errorf("Expected no Content-Range, got %q", rec.Header().Get("Content-Range"))
+	}
+}
@@ -0,0 +1,103 @@
+// request_id_test.go - X-Request-IDがハンドラー→Service層→予約→バンドルまで引き継がれることのテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+func TestRequestIDPropagatesToBundle(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	h := NewBpHandler(service.NewBpService(echoGateway{}, repo, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.RequestID())
+	r.NoRoute(h.GetContent)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/page", nil)
+	req.Header.Set(requestid.Header, "browser-123")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(requestid.Header); got != "browser-123" {
+		t.Errorf("expected the request ID echoed in the response, got %q", got)
+	}
+	if reserved, _ := repo.GetReservedRequests(context.Background()); len(reserved) != 1 {
+		t.Fatalf("expected 1 reservation, got %d", len(reserved))
+	}
+
+	// ワーカーが予約を取り出してゲートウェイに渡すまで、IDが残る
+	reserved, err := repo.BLPopReservedRequest(context.Background(), time.Second)
+	if err != nil || reserved == nil {
+		t.Fatalf("failed to pop reservation: %v", err)
+	}
+	if reserved.RequestID != "browser-123" {
+		t.Errorf("expected the reservation to keep the request ID, got %q", reserved.RequestID)
+	}
+	if reserved.GenerateCacheKey() != (&model.BpRequest{Method: http.MethodGet, URL: "http://example.com/page", Headers: reserved.Headers}).GenerateCacheKey() {
+		t.Error("expected the request ID not to change the cache key")
+	}
+
+	data, err := json.Marshal(gateway.NewDTNJsonRequest(reserved.RequestID, reserved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil || bundle.RequestID != "browser-123" {
+		t.Errorf("expected the request ID in the bundle, got %s (%v)", data, err)
+	}
+}
+
+// recordingProxyService 受け取ったリクエストを記録するService層
+type recordingProxyService struct {
+	last *model.BpRequest
+}
+
+func (s *recordingProxyService) ProxyRequestWithStatus(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
+	s.last = breq
+	return &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("ok")}, model.CacheMissDirect, nil
+}
+
+// ミドルウェアを通らない場合（CONNECTトンネル内など）もIDを決めて渡し、レスポンスで返す
+func TestRequestIDWithoutMiddleware(t *testing.T) {
+	svc := &recordingProxyService{}
+	h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if id := rec.Header().Get(requestid.Header); id == "" || svc.last == nil || svc.last.RequestID != id {
+		t.Errorf("expected a generated ID passed to the service and echoed, got header %q, request %+v", id, svc.last)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/app.js", nil)
+	req.Header.Set(requestid.Header, "tunnel-7")
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	h.serveBumpedRequest(req, w)
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if got := resp.Header.Get(requestid.Header); got != "tunnel-7" || svc.last.RequestID != "tunnel-7" {
+		t.Errorf("expected tunnel request ID to be kept and echoed, got header %q, request %q", got, svc.last.RequestID)
+	}
+}
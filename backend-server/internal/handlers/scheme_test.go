@@ -0,0 +1,144 @@
+// scheme_test.go - 転送先URLのスキーム（CONNECTトンネル内はhttps、通常のプロキシは送られたまま）がバンドルまで残ることのテスト
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	gateway_impl "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// capturingGateway 転送されたリクエストをチャンネルで渡すゲートウェイ（CONNECTトンネル内のリクエストは別のgoroutineで処理される）
+type capturingGateway struct {
+	requests chan *model.BpRequest
+}
+
+func (g *capturingGateway) ProxyRequest(ctx context.Context, req *model.BpRequest, opts gateway.ProxyOptions) (*model.BpResponse, error) {
+	g.requests <- req
+	return &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("ok"), ContentLength: 2}, nil
+}
+
+func (g *capturingGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse { return nil }
+
+// dtnRequestContract 地上局と共有するhttpsのURLのリクエストのバンドル（testdata/dtn/request_https.json）
+func dtnRequestContract(t *testing.T) gateway_impl.DTNJsonRequest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", "dtn", "request_https.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	var contract gateway_impl.DTNJsonRequest
+	if err := json.Unmarshal(data, &contract); err != nil {
+		t.Fatal(err)
+	}
+	return contract
+}
+
+// bundleURL breqを送るバンドルのJSONに書かれるURL（地上局が取得するURL）
+func bundleURL(t *testing.T, breq *model.BpRequest) string {
+	t.Helper()
+	data, err := json.Marshal(gateway_impl.NewDTNJsonRequest(breq.RequestID, breq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	return bundle.URL
+}
+
+func TestBumpedTargetURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string // リクエスト行のリクエストURI
+		host   string
+		want   string
+	}{
+		{name: "origin-form", target: "/a?b=c", host: "example.com", want: "https://example.com/a?b=c"},
+		{name: "non-default port", target: "/", host: "example.com:8443", want: "https://example.com:8443/"},
+		{name: "escaped path", target: "/files/a%2Fb%20c", host: "example.com", want: "https://example.com/files/a%2Fb%20c"},
+		{name: "absolute-form http", target: "http://example.com/a", host: "example.com", want: "https://example.com/a"},
+		{name: "absolute-form other host", target: "https://cdn.example.com/x.js", host: "example.com", want: "https://cdn.example.com/x.js"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Host = tt.host
+			if got := bumpedTargetURL(req); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// CONNECTトンネル内のリクエストは、地上局がhttpsで取得するURLでバンドルに書かれる
+func TestBumpedRequestKeepsHTTPSInBundle(t *testing.T) {
+	contract := dtnRequestContract(t)
+	gw := &capturingGateway{requests: make(chan *model.BpRequest, 1)}
+	srv, caCert := newTestProxyWithService(t, service.NewBpService(gw, unavailableRepository{}, "", "", 0, model.DeliveryEstimate{}, true, nil), nil)
+	tlsConn := dialTunnel(t, srv.Listener.Addr().String(), caCert)
+
+	req, err := http.NewRequest(contract.Method, contract.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set(requestid.Header, contract.RequestID)
+	if err := req.Write(tlsConn); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(tlsConn), req)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var got *model.BpRequest
+	select {
+	case got = <-gw.requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the request to reach the gateway")
+	}
+	if got.URL != contract.URL {
+		t.Errorf("Expected the gateway to receive %q, got %q", contract.URL, got.URL)
+	}
+	if got.RequestID != contract.RequestID {
+		t.Errorf("Expected RequestID %q, got %q", contract.RequestID, got.RequestID)
+	}
+	if url := bundleURL(t, got); url != contract.URL {
+		t.Errorf("Expected the bundle to carry %q, got %q", contract.URL, url)
+	}
+}
+
+// 通常のプロキシのリクエストは、クライアントが送ったスキームのままバンドルに書かれる
+func TestProxiedRequestKeepsSchemeInBundle(t *testing.T) {
+	contract := dtnRequestContract(t)
+	for _, target := range []string{contract.URL, "http://example.com/a?b=c"} {
+		rec, got := serveProxyRequest(t, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK || got == nil {
+			t.Fatalf("%s: expected the request to be proxied, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+		if got.URL != target {
+			t.Errorf("Expected the gateway to receive %q, got %q", target, got.URL)
+		}
+		if url := bundleURL(t, got); url != target {
+			t.Errorf("Expected the bundle to carry %q, got %q", target, url)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// stale_test.go - 期限切れのキャッシュを返しながら更新を予約する（stale-while-revalidate）ことのテスト
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		evicted  bool
+		xCache   string
+		status   string
+		body     string
+		warning  bool
+		reserved int
+	}{
+		{"fresh", time.Hour, false, "HIT", "hit", "old news", false, 0},
+		// 何度アクセスされても更新の予約は1つだけ
+		{"stale", -time.Minute, false, "STALE", "stale", "old news", true, 1},
+		{"evicted", 0, true, "MISS", "miss-reserved", "", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 期限切れのキャッシュは1時間まで保持する
+			repo := fakes.NewRepository(time.Hour)
+			if !tt.evicted {
+				news := &model.BpRequest{Method: http.MethodGet, URL: "http://example.com/news.html"}
+				if err := repo.SetResponseWithURL(context.Background(), news, &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("old news")}, tt.ttl); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h := NewBpHandler(service.NewBpService(&recordingGateway{}, repo, "", "", 0, model.DeliveryEstimate{}, true, nil), middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.NoRoute(h.GetContent)
+
+			for range 3 {
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/news.html", nil))
+				if got := rec.Header().Get("X-Cache"); got != tt.xCache {
+					t.Errorf("Expected X-Cache %s, got %q", tt.xCache, got)
+				}
+				if got := rec.Header().Get("X-Bp-Queue-Status"); got != tt.status {
+					t.Errorf("Expected status %s, got %q", tt.status, got)
+				}
+				if tt.body != "" && rec.Body.String() != tt.body {
+					t.Errorf("Expected the cached body, got %q", rec.Body.String())
+				}
+				if got := rec.Header().Get("Warning") != ""; got != tt.warning {
+					t.Errorf("Expected Warning=%v, got %q", tt.warning, rec.Header().Get("Warning"))
+				}
+			}
+			reserved, _ := repo.GetReservedRequests(context.Background())
+			if len(reserved) != tt.reserved {
+				t.Fatalf("Expected %d reservations, got %d", tt.reserved, len(reserved))
+			}
+			if tt.warning && reserved[0].URL != "http://example.com/news.html" {
+				t.Errorf("Expected the stale URL to be reserved, got %s", reserved[0].URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/notifier"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// URLStatus 問い合わせたURLの状態
+type URLStatus struct {
+	// State "cached"（キャッシュあり）、"queued"（DTNへの転送待ち）、"unknown"（どちらもなし）
+	State string `json:"state"`
+
+	URL      string `json:"url"`
+	CacheKey string `json:"cache_key"`
+
+	// QueuedAt 予約した時刻（予約キューに入っている場合のみ）
+	QueuedAt *time.Time `json:"queued_at,omitempty"`
+
+	// Position・QueueLength 予約キューでの位置（Workerが取り出す順、1から）とキューの長さ（予約キューに入っている場合のみ）
+	// Workerがキューを処理するにつれて変わるため、問い合わせるたびに最新の値を返す
+	Position    int `json:"position,omitempty"`
+	QueueLength int `json:"queue_length,omitempty"`
+
+	// EstimatedAt レスポンスが届く時刻の目安（予約キューに入っている場合のみ）
+	EstimatedAt *time.Time `json:"estimated_at,omitempty"`
+
+	// CacheExpiresAt キャッシュの有効期限（キャッシュがある場合のみ）
+	CacheExpiresAt *time.Time `json:"cache_expires_at,omitempty"`
+}
+
+const (
+	urlStateCached  = "cached"
+	urlStateQueued  = "queued"
+	urlStateUnknown = "unknown"
+)
+
+// notifyHeartbeatInterval SSE接続を維持するためのコメント行を送る間隔（プロキシのアイドルタイムアウト対策）
+const notifyHeartbeatInterval = 15 * time.Second
+
+type statusHandler struct {
+	bprepo        repository.BpRepository
+	notifier      notifier.CacheNotifier
+	notifyTimeout time.Duration
+	// deliveryEstimate 予約キューでの位置からレスポンスが届く時刻の目安を計算する
+	deliveryEstimate model.DeliveryEstimate
+}
+
+// NewStatusHandler notifyTimeoutは/system/notifyの接続を打ち切るまでの時間
+// deliveryEstimateは予約キューでの位置からレスポンスが届く時刻の目安を計算する（プレースホルダーと同じ値を使う）
+func NewStatusHandler(bprepo repository.BpRepository, notifier notifier.CacheNotifier, notifyTimeout time.Duration, deliveryEstimate model.DeliveryEstimate) *statusHandler {
+	return &statusHandler{
+		bprepo:           bprepo,
+		notifier:         notifier,
+		notifyTimeout:    notifyTimeout,
+		deliveryEstimate: deliveryEstimate,
+	}
+}
+
+// GetStatus プレースホルダーを受け取ったクライアントが、ページの準備ができたかを問い合わせる
+// GET /system/status?url=...
+// キャッシュキーにはAccept・Accept-Languageが含まれるため、元のリクエストと同じヘッダーを付けて問い合わせる
+func (sh *statusHandler) GetStatus(c *gin.Context) {
+	targetURL, cacheKey, ok := statusQuery(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	status := URLStatus{State: urlStateUnknown, URL: targetURL, CacheKey: cacheKey}
+
+	cached, found, err := sh.bprepo.GetResponse(ctx, cacheKey)
+	if err != nil {
+		log.Printf("[StatusHandler] GetResponse error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to look up cache"})
+		return
+	}
+	if found {
+		status.State = urlStateCached
+		if !cached.ExpiresAt.IsZero() {
+			status.CacheExpiresAt = &cached.ExpiresAt
+		}
+	}
+
+	reserved, err := sh.bprepo.GetReservedRequests(ctx)
+	if err != nil {
+		log.Printf("[StatusHandler] GetReservedRequests error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to look up reservation queue"})
+		return
+	}
+	if position, req := model.FindQueuePosition(reserved, cacheKey); req != nil {
+		if !found {
+			status.State = urlStateQueued
+			status.Position = position
+			status.QueueLength = len(reserved)
+			if estimatedAt := sh.deliveryEstimate.EstimatedAt(time.Now(), req.ReservedAt, position); !estimatedAt.IsZero() {
+				status.EstimatedAt = &estimatedAt
+			}
+		}
+		if !req.ReservedAt.IsZero() {
+			queuedAt := req.ReservedAt
+			status.QueuedAt = &queuedAt
+		}
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// GetNotify 予約したURLのレスポンスがキャッシュに保存されたことをServer-Sent Eventsで通知する
+// GET /system/notify?url=...
+// 保存されると "event: cached"（データはURLStatusのJSON）を送って終了し、
+// notifyTimeoutまでに保存されなければ "event: timeout" を送って終了する
+func (sh *statusHandler) GetNotify(c *gin.Context) {
+	targetURL, cacheKey, ok := statusQuery(c)
+	if !ok {
+		return
+	}
+	if sh.notifier == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Notification is not available"})
+		return
+	}
+
+	// 確認と購読の間に保存された通知を取りこぼさないよう、先に購読してからキャッシュを確認する
+	notified, unsubscribe := sh.notifier.Subscribe(cacheKey)
+	defer unsubscribe()
+
+	ctx := c.Request.Context()
+	cached, found, err := sh.bprepo.GetResponse(ctx, cacheKey)
+	if err != nil {
+		log.Printf("[StatusHandler] GetResponse error: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to look up cache"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if found {
+		sh.writeCachedEvent(c, targetURL, cacheKey, cached)
+		return
+	}
+	c.Writer.Flush()
+
+	timeout := time.NewTimer(sh.notifyTimeout)
+	defer timeout.Stop()
+	heartbeat := time.NewTicker(notifyHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// クライアントが切断した
+			return
+		case <-timeout.C:
+			writeEvent(c, "timeout", URLStatus{State: urlStateUnknown, URL: targetURL, CacheKey: cacheKey})
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		case <-notified:
+			cached, found, err := sh.bprepo.GetResponse(ctx, cacheKey)
+			if err != nil || !found {
+				// 通知後に期限切れ・削除された場合はレスポンスなしで通知する
+				log.Printf("[StatusHandler] Cached response for %s is not readable after notification: found=%v err=%v", cacheKey, found, err)
+				cached = nil
+			}
+			sh.writeCachedEvent(c, targetURL, cacheKey, cached)
+			return
+		}
+	}
+}
+
+func (sh *statusHandler) writeCachedEvent(c *gin.Context, targetURL, cacheKey string, cached *model.BpResponse) {
+	status := URLStatus{State: urlStateCached, URL: targetURL, CacheKey: cacheKey}
+	if cached != nil && !cached.ExpiresAt.IsZero() {
+		status.CacheExpiresAt = &cached.ExpiresAt
+	}
+	writeEvent(c, "cached", status)
+}
+
+// writeEvent SSEのイベントを1つ書き込んでフラッシュする
+func writeEvent(c *gin.Context, event string, status URLStatus) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("[StatusHandler] Failed to encode %s event: %v", event, err)
+		return
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, data)
+	c.Writer.Flush()
+}
+
+// statusQuery urlパラメータと、元のリクエストと同じヘッダーから生成したキャッシュキーを返す
+// パラメータが不正な場合は400を返してokをfalseにする
+func statusQuery(c *gin.Context) (targetURL, cacheKey string, ok bool) {
+	targetURL = c.Query("url")
+	if targetURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url parameter is required"})
+		return "", "", false
+	}
+	if _, err := url.Parse(targetURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		return "", "", false
+	}
+
+	// GenerateCacheKeyが参照するヘッダーだけを引き継ぐ
+	breq := &model.BpRequest{
+		Method:  http.MethodGet,
+		URL:     targetURL,
+		Headers: make(map[string][]string),
+	}
+	for _, name := range model.CacheKeyHeaders {
+		if values := c.Request.Header.Values(name); len(values) > 0 {
+			breq.Headers[name] = values
+		}
+	}
+	return targetURL, breq.GenerateCacheKey(), true
+}
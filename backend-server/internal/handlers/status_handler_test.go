@@ -0,0 +1,239 @@
+// status_handler_test.go - 予約したURLの状態の問い合わせのテスト
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/notifier"
+)
+
+// cacheResponse ワーカーによるreqのレスポンスのキャッシュの保存を模擬し、保存したキャッシュの有効期限を返す
+func cacheResponse(t *testing.T, repo *fakes.Repository, req *model.BpRequest, ttl time.Duration) time.Time {
+	t.Helper()
+	if err := repo.SetResponseWithURL(context.Background(), req, &model.BpResponse{StatusCode: http.StatusOK}, ttl); err != nil {
+		t.Fatal(err)
+	}
+	cached, _, err := repo.GetResponse(context.Background(), req.GenerateCacheKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cached.ExpiresAt
+}
+
+func getStatus(t *testing.T, repo repository.BpRepository, targetURL string, header http.Header) URLStatus {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/system/status", NewStatusHandler(repo, nil, time.Minute, model.DeliveryEstimate{}).GetStatus)
+
+	req := httptest.NewRequest(http.MethodGet, "/system/status?url="+url.QueryEscape(targetURL), nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var status URLStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	return status
+}
+
+func TestStatusCached(t *testing.T) {
+	header := http.Header{"Accept-Language": {"ja"}}
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/a", Headers: header}
+	repo := fakes.NewRepository(0)
+	expires := cacheResponse(t, repo, req, time.Hour)
+
+	status := getStatus(t, repo, req.URL, header)
+	if status.State != "cached" || status.CacheKey != req.GenerateCacheKey() {
+		t.Fatalf("Expected cached state for %s, got %+v", req.GenerateCacheKey(), status)
+	}
+	if status.CacheExpiresAt == nil || !status.CacheExpiresAt.Equal(expires) {
+		t.Errorf("Expected cache_expires_at %v, got %v", expires, status.CacheExpiresAt)
+	}
+
+	// ヘッダーが違うとキャッシュキーも違う
+	if status := getStatus(t, repo, req.URL, nil); status.State != "unknown" {
+		t.Errorf("Expected unknown without the Accept-Language header, got %q", status.State)
+	}
+}
+
+func TestStatusQueued(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	var queuedAt time.Time
+	for _, req := range []*model.BpRequest{
+		{Method: http.MethodGet, URL: "https://example.com/other"},
+		{Method: http.MethodGet, URL: "https://example.com/b", Headers: map[string][]string{"User-Agent": {"test"}}},
+	} {
+		reservation, err := repo.ReserveRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queuedAt = reservation.ReservedAt
+	}
+
+	status := getStatus(t, repo, "https://example.com/b", nil)
+	if status.State != "queued" {
+		t.Fatalf("Expected queued state, got %+v", status)
+	}
+	if status.QueuedAt == nil || !status.QueuedAt.Equal(queuedAt) {
+		t.Errorf("Expected queued_at %v, got %v", queuedAt, status.QueuedAt)
+	}
+	if status.CacheExpiresAt != nil {
+		t.Errorf("Expected no cache_expires_at, got %v", status.CacheExpiresAt)
+	}
+}
+
+func TestStatusUnknown(t *testing.T) {
+	status := getStatus(t, fakes.NewRepository(0), "https://example.com/c", nil)
+	if status.State != "unknown" || status.QueuedAt != nil || status.CacheExpiresAt != nil {
+		t.Errorf("Expected unknown state without timestamps, got %+v", status)
+	}
+}
+
+func TestStatusRequiresURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/system/status", NewStatusHandler(fakes.NewRepository(0), nil, time.Minute, model.DeliveryEstimate{}).GetStatus)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/system/status", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+}
+
+// sseEvent 受信したServer-Sent Eventsのイベント
+type sseEvent struct {
+	name string
+	data string
+}
+
+// openNotify /system/notifyに接続し、ヘッダーを受信した（購読が完了した）時点で返す
+func openNotify(t *testing.T, repo repository.BpRepository, n *notifier.CacheNotifier, timeout time.Duration, targetURL string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/system/notify", NewStatusHandler(repo, n, timeout, model.DeliveryEstimate{}).GetNotify)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/system/notify?url=" + url.QueryEscape(targetURL))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readEvent 次のイベントを読む
+func readEvent(t *testing.T, br *bufio.Reader) sseEvent {
+	t.Helper()
+	ev, err := nextEvent(br)
+	if err != nil {
+		t.Fatalf("Failed to read event: %v", err)
+	}
+	return ev
+}
+
+// nextEvent 次のイベントを読む（コメント行は読み飛ばす）
+func nextEvent(br *bufio.Reader) (sseEvent, error) {
+	var ev sseEvent
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return ev, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.name != "":
+			return ev, nil
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestNotifyAfterCacheWrite(t *testing.T) {
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/notify"}
+	cacheKey := req.GenerateCacheKey()
+	repo := fakes.NewRepository(0)
+	n := notifier.NewCacheNotifier()
+	_, br := openNotify(t, repo, n, time.Minute, req.URL)
+
+	events := make(chan sseEvent, 1)
+	go func() {
+		// 接続が閉じられた場合は空のイベントを渡す
+		ev, _ := nextEvent(br)
+		events <- ev
+	}()
+
+	select {
+	case ev := <-events:
+		t.Fatalf("Expected no event before the cache write, got %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	expires := cacheResponse(t, repo, req, time.Hour)
+	n.Publish(cacheKey)
+
+	select {
+	case ev := <-events:
+		if ev.name != "cached" {
+			t.Fatalf("Expected cached event, got %+v", ev)
+		}
+		var status URLStatus
+		if err := json.Unmarshal([]byte(ev.data), &status); err != nil {
+			t.Fatalf("Failed to decode event data %q: %v", ev.data, err)
+		}
+		if status.State != "cached" || status.URL != req.URL || status.CacheKey != cacheKey {
+			t.Errorf("Unexpected status %+v", status)
+		}
+		if status.CacheExpiresAt == nil || !status.CacheExpiresAt.Equal(expires) {
+			t.Errorf("Expected cache_expires_at %v, got %v", expires, status.CacheExpiresAt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the cached event")
+	}
+}
+
+func TestNotifyAlreadyCached(t *testing.T) {
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/ready"}
+	repo := fakes.NewRepository(0)
+	cacheResponse(t, repo, req, time.Hour)
+
+	_, br := openNotify(t, repo, notifier.NewCacheNotifier(), time.Minute, req.URL)
+	if ev := readEvent(t, br); ev.name != "cached" {
+		t.Errorf("Expected cached event immediately, got %+v", ev)
+	}
+}
+
+func TestNotifyTimeout(t *testing.T) {
+	_, br := openNotify(t, fakes.NewRepository(0), notifier.NewCacheNotifier(), 50*time.Millisecond, "https://example.com/slow")
+	if ev := readEvent(t, br); ev.name != "timeout" {
+		t.Errorf("Expected timeout event, got %+v", ev)
+	}
+}
@@ -0,0 +1,172 @@
+// streaming_test.go - 大きいキャッシュをメモリに読み込まずにクライアントへコピーすることのテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/service"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+// testStreamThreshold テストで使うメモリに読み込むキャッシュの上限
+const testStreamThreshold = 1024
+
+// waitClosed repoが返したキャッシュのボディがすべて閉じられるまで待つ
+func waitClosed(t *testing.T, repo *fakes.Repository) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for repo.OpenStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the cache file to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// streamBody テストで使うsizeバイトのキャッシュのボディ
+func streamBody(size int) []byte {
+	return bytes.Repeat([]byte("x"), size)
+}
+
+// newStreamRepository http・httpsのexample.com/video.mp4にsizeバイトの動画をキャッシュしたリポジトリ
+func newStreamRepository(t *testing.T, size int) *fakes.Repository {
+	t.Helper()
+	repo := fakes.NewRepository(0)
+	resp := &model.BpResponse{
+		StatusCode: http.StatusOK,
+		// キャッシュしたヘッダーのContent-Lengthは使わない
+		Headers:       map[string][]string{"Content-Type": {"video/mp4"}, "Content-Length": {"1"}},
+		Body:          streamBody(size),
+		ContentType:   "video/mp4",
+		ContentLength: int64(size),
+	}
+	for _, u := range []string{"http://example.com/video.mp4", "https://example.com/video.mp4"} {
+		if err := repo.SetResponseWithURL(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: u}, resp, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func newStreamingHandler(repo *fakes.Repository) *bpHandler {
+	svc := service.NewBpService(echoGateway{}, repo, "", "", testStreamThreshold, model.DeliveryEstimate{}, true, nil)
+	return NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+}
+
+func TestCacheStreamThreshold(t *testing.T) {
+	tests := []struct {
+		size   int
+		stream bool
+	}{
+		{testStreamThreshold - 1, false},
+		{testStreamThreshold, true},
+		{testStreamThreshold * 10, true},
+	}
+	for _, tt := range tests {
+		repo := newStreamRepository(t, tt.size)
+		svc := service.NewBpService(echoGateway{}, repo, "", "", testStreamThreshold, model.DeliveryEstimate{}, true, nil)
+		resp, status, err := svc.ProxyRequestWithStatus(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "http://example.com/video.mp4"})
+		if err != nil || status != model.CacheHit {
+			t.Fatalf("%d bytes: expected a cache hit, got %s (%v)", tt.size, status, err)
+		}
+		if got := resp.BodyStream != nil; got != tt.stream {
+			t.Errorf("%d bytes: expected stream=%v, got %v", tt.size, tt.stream, got)
+		}
+		if !tt.stream && (len(resp.Body) != tt.size || repo.OpenStreams() != 0) {
+			t.Errorf("%d bytes: expected the body in memory and the file closed, got %d bytes", tt.size, len(resp.Body))
+		}
+		resp.Close()
+	}
+}
+
+func TestStreamLargeCachedResponse(t *testing.T) {
+	const size = 1 << 20
+	repo := newStreamRepository(t, size)
+	h := newStreamingHandler(repo)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	proxyURL, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://example.com/video.mp4")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Length") != strconv.Itoa(size) || resp.ContentLength != size {
+		t.Errorf("Expected Content-Length %d, got %q", size, resp.Header.Get("Content-Length"))
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || !bytes.Equal(body, streamBody(size)) {
+		t.Fatalf("Expected the whole cached body, got %d bytes (%v)", len(body), err)
+	}
+	waitClosed(t, repo)
+}
+
+// クライアントが途中で切断した場合もキャッシュファイルを閉じる
+func TestStreamClientDisconnect(t *testing.T) {
+	repo := newStreamRepository(t, 32<<20)
+	h := newStreamingHandler(repo)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn.Write([]byte("GET http://example.com/video.mp4 HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 4096)); err != nil {
+		t.Fatalf("Failed to read the beginning of the body: %v", err)
+	}
+	conn.Close()
+
+	waitClosed(t, repo)
+}
+
+func TestStreamLargeCachedResponseInTunnel(t *testing.T) {
+	const size = 64 << 10
+	repo := newStreamRepository(t, size)
+	h := newStreamingHandler(repo)
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/video.mp4", nil)
+
+	var buf bytes.Buffer
+	if !h.serveBumpedRequest(req, bufio.NewWriter(&buf)) {
+		t.Fatal("Expected the tunnel to stay open after a streamed response")
+	}
+	// 次のリクエストのレスポンスと区切れるよう、Content-Lengthは実際の長さ
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ContentLength != size || !bytes.Equal(body, streamBody(size)) {
+		t.Errorf("Expected %d bytes with matching Content-Length, got %d (Content-Length %d)", size, len(body), resp.ContentLength)
+	}
+	if repo.OpenStreams() != 0 {
+		t.Error("Expected the cache file to be closed after the response")
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// errRequestTimeout ハンドラーの期限までにService層の処理が終わらなかった
+var errRequestTimeout = errors.New("request timed out")
+
+// proxyResult Service層の処理結果
+type proxyResult struct {
+	resp   *model.BpResponse
+	status model.CacheStatus
+	err    error
+}
+
+// proxyWithDeadline ハンドラーの期限（requestTimeout）を付けてService層でリクエストを転送する
+// 期限を過ぎた場合はerrRequestTimeoutを返すが、Service層の処理は期限とは切り離したcontextで最後まで続ける
+// （キャッシュミスの予約が期限で打ち切られないようにするため。ゲートウェイには独自のタイムアウトがある）
+// クライアントが切断した場合はctxのエラーを返す
+func (bh *bpHandler) proxyWithDeadline(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
+	if bh.requestTimeout <= 0 {
+		return bh.bpService.ProxyRequestWithStatus(ctx, breq)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, bh.requestTimeout)
+	defer cancel()
+
+	done := make(chan proxyResult, 1)
+	go func() {
+		resp, status, err := bh.bpService.ProxyRequestWithStatus(context.WithoutCancel(ctx), breq)
+		done <- proxyResult{resp: resp, status: status, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.resp, res.status, res.err
+	case <-waitCtx.Done():
+		// 期限の後に用意されたレスポンスは使わないため、キャッシュファイルを開いていれば閉じる
+		go func() { (<-done).resp.Close() }()
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		log.Printf("[BpHandler] Request timed out after %s: Method=%s, URL=%s, RequestID=%s", bh.requestTimeout, breq.Method, breq.URL, breq.RequestID)
+		return nil, "", errRequestTimeout
+	}
+}
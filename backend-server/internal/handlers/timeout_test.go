@@ -0,0 +1,144 @@
+// timeout_test.go - ハンドラーの期限（504）と期限後も予約を続けることのテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+// slowProxyService delayだけ待ってから予約したものとしてプレースホルダーを返すService層
+// 予約の時点でcontextが有効だったかをreservedに送る
+type slowProxyService struct {
+	delay    time.Duration
+	reserved chan error
+}
+
+func (s *slowProxyService) ProxyRequestWithStatus(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, model.CacheStatus, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+	}
+	s.reserved <- ctx.Err()
+	return &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("placeholder")}, model.CacheMissReserved, nil
+}
+
+func newSlowHandler(delay time.Duration) (*bpHandler, *slowProxyService) {
+	svc := &slowProxyService{delay: delay, reserved: make(chan error, 1)}
+	return NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 2*time.Minute, 50*time.Millisecond, 0, false, ""), svc
+}
+
+func serveSlow(t *testing.T, h *bpHandler, method, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(h.GetContent)
+	req := httptest.NewRequest(method, "http://example.com/slow", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitReserved 期限の後もService層が最後まで動き、有効なcontextで予約したことを確認する
+func waitReserved(t *testing.T, svc *slowProxyService) {
+	t.Helper()
+	select {
+	case err := <-svc.reserved:
+		if err != nil {
+			t.Errorf("Expected the reservation to run with a live context, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the service call to finish after the deadline")
+	}
+}
+
+func TestRequestTimeoutJSON(t *testing.T) {
+	h, svc := newSlowHandler(300 * time.Millisecond)
+	start := time.Now()
+	rec := serveSlow(t, h, http.MethodGet, "application/json")
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Expected the handler to give up at the deadline, took %s", elapsed)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d", rec.Code)
+	}
+	var got ErrorPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Expected a JSON body, got %q", rec.Body.String())
+	}
+	if !got.Queued || got.StatusURL != "/system/status?url=http%3A%2F%2Fexample.com%2Fslow" || !strings.Contains(got.Message, "DTN") {
+		t.Errorf("Unexpected timeout body: %+v", got)
+	}
+	if rec.Header().Get("Retry-After") != "120" {
+		t.Errorf("Expected Retry-After for a queued request, got %q", rec.Header().Get("Retry-After"))
+	}
+	waitReserved(t, svc)
+}
+
+func TestRequestTimeoutHTML(t *testing.T) {
+	h, svc := newSlowHandler(300 * time.Millisecond)
+	rec := serveSlow(t, h, http.MethodGet, "text/html,application/xhtml+xml")
+	if rec.Code != http.StatusGatewayTimeout || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected a 504 HTML page, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "DTN") || !strings.Contains(body, "予約しました") {
+		t.Errorf("Expected the page to explain the DTN delay and the reservation, got:\n%s", body)
+	}
+	waitReserved(t, svc)
+}
+
+func TestRequestTimeoutNotQueued(t *testing.T) {
+	h, svc := newSlowHandler(300 * time.Millisecond)
+	rec := serveSlow(t, h, http.MethodPost, "")
+	var got ErrorPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected a 504 JSON body, got %d %q", rec.Code, rec.Body.String())
+	}
+	if got.Queued || got.StatusURL != "" || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected a POST not to be reported as queued, got %+v", got)
+	}
+	waitReserved(t, svc)
+}
+
+func TestRequestWithinDeadline(t *testing.T) {
+	h, svc := newSlowHandler(time.Millisecond)
+	rec := serveSlow(t, h, http.MethodGet, "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "placeholder" {
+		t.Errorf("Expected the response within the deadline, got %d %q", rec.Code, rec.Body.String())
+	}
+	waitReserved(t, svc)
+}
+
+func TestRequestTimeoutInTunnel(t *testing.T) {
+	h, svc := newSlowHandler(300 * time.Millisecond)
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/slow", nil)
+
+	var buf bytes.Buffer
+	if !h.serveBumpedRequest(req, bufio.NewWriter(&buf)) {
+		t.Fatal("Expected the tunnel to stay open after a 504")
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var got ErrorPage
+	if resp.StatusCode != http.StatusGatewayTimeout || json.Unmarshal(body, &got) != nil || !got.Queued {
+		t.Errorf("Expected a 504 JSON body for a queued request, got %d %q", resp.StatusCode, body)
+	}
+	waitReserved(t, svc)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// upgradeProtocol リクエストがUpgradeヘッダーでプロトコルの切り替え（WebSocket、h2cなど）を求めている場合は、そのプロトコルを返す
+// 転送するヘッダーからはUpgradeを取り除くため、取り除く前のヘッダーで判定する
+func upgradeProtocol(h http.Header) string {
+	return strings.TrimSpace(strings.Join(h.Values("Upgrade"), ", "))
+}
+
+// upgradeRejection Upgradeを求めるリクエストに返すステータスコードとJSON
+// DTNでは対話的なソケットを中継できないため、予約も転送もせずに拒否する
+// 既定は501、forbidUpgradeの場合は403（5xxを再試行するクライアントでもすぐに諦めるように）
+func (bh *bpHandler) upgradeRejection(protocol string) (int, gin.H) {
+	if bh.forbidUpgrade {
+		return http.StatusForbidden, gin.H{
+			"error":    "upgrade requests are refused by this proxy",
+			"protocol": protocol,
+			"message":  "Interactive protocols such as WebSocket cannot be relayed over DTN",
+		}
+	}
+	return http.StatusNotImplemented, gin.H{
+		"error":    "protocol upgrade is not supported",
+		"protocol": protocol,
+		"message":  "Interactive protocols such as WebSocket cannot be relayed over DTN",
+	}
+}
+
+// writeBumpedUpgradeRejected CONNECTトンネル内のUpgradeリクエスト（wss://など）を拒否する
+// クライアントが切り替え後のプロトコルで話し始めている可能性があるため、接続は閉じる
+func (bh *bpHandler) writeBumpedUpgradeRejected(req *http.Request, protocol string, w *bufio.Writer) bool {
+	log.Printf("[BpHandler] Rejected upgrade request: Method=%s, URL=%s, Upgrade=%s, RequestID=%s", req.Method, req.URL, protocol, requestid.FromContext(req.Context()))
+	code, payload := bh.upgradeRejection(protocol)
+	body, _ := json.Marshal(payload)
+	req.Close = true
+	writeBumpedResponse(req, w, code, http.Header{"Content-Type": {"application/json; charset=utf-8"}, "Cache-Control": {"no-store"}}, body)
+	return false
+}
@@ -0,0 +1,105 @@
+// upgrade_test.go - WebSocketなどのUpgradeリクエストを予約せずに拒否することのテスト
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/middleware"
+)
+
+var upgradeCases = []struct {
+	name     string
+	header   map[string]string
+	protocol string
+}{
+	{"websocket", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ==", "Sec-WebSocket-Version": "13"}, "websocket"},
+	{"h2c", map[string]string{"Connection": "Upgrade, HTTP2-Settings", "Upgrade": "h2c", "HTTP2-Settings": "AAMAAABkAAQAAP__"}, "h2c"},
+	{"plain", map[string]string{"Connection": "keep-alive"}, ""},
+}
+
+func TestGetContentUpgrade(t *testing.T) {
+	for _, forbid := range []bool{false, true} {
+		for _, tt := range upgradeCases {
+			name := tt.name
+			if forbid {
+				name += "-forbidden"
+			}
+			t.Run(name, func(t *testing.T) {
+				svc := &recordingProxyService{}
+				h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, forbid, "")
+				gin.SetMode(gin.TestMode)
+				r := gin.New()
+				r.NoRoute(h.GetContent)
+				req := httptest.NewRequest(http.MethodGet, "http://example.com/socket", nil)
+				for k, v := range tt.header {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				if tt.protocol == "" {
+					if rec.Code != http.StatusOK || svc.last == nil {
+						t.Fatalf("expected plain request to be proxied, got %d", rec.Code)
+					}
+					return
+				}
+				want := http.StatusNotImplemented
+				if forbid {
+					want = http.StatusForbidden
+				}
+				if rec.Code != want {
+					t.Fatalf("expected %d, got %d: %s", want, rec.Code, rec.Body.String())
+				}
+				if svc.last != nil {
+					t.Error("expected upgrade request not to reach the service (no reservation)")
+				}
+				var body map[string]any
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["protocol"] != tt.protocol || body["message"] == "" {
+					t.Errorf("expected JSON naming protocol %q, got %s", tt.protocol, rec.Body.String())
+				}
+				if rec.Header().Get("Cache-Control") != "no-store" {
+					t.Errorf("expected Cache-Control: no-store, got %q", rec.Header().Get("Cache-Control"))
+				}
+			})
+		}
+	}
+}
+
+// CONNECTでSSL Bumpした後のトンネル内のリクエスト（wss://など）
+func TestBumpedRequestUpgrade(t *testing.T) {
+	for _, tt := range upgradeCases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &recordingProxyService{}
+			h := NewBpHandler(svc, middleware.NewMiddlewarePlugins(nil, nil, nil), nil, 0, 0, 0, false, "")
+			req := httptest.NewRequest(http.MethodGet, "https://example.com/socket", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			var buf bytes.Buffer
+			keepAlive := h.serveBumpedRequest(req, bufio.NewWriter(&buf))
+			resp, err := http.ReadResponse(bufio.NewReader(&buf), req)
+			if err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+
+			if tt.protocol == "" {
+				if resp.StatusCode != http.StatusOK || svc.last == nil || !keepAlive {
+					t.Fatalf("expected plain request to be proxied on a kept-alive connection, got %d (keep-alive %v)", resp.StatusCode, keepAlive)
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusNotImplemented || svc.last != nil {
+				t.Fatalf("expected 501 without reaching the service, got %d", resp.StatusCode)
+			}
+			if keepAlive || !resp.Close {
+				t.Error("expected the tunnel to be closed after rejecting an upgrade")
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package headers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ViaValue このプロキシがViaヘッダーに付ける値
+const ViaValue = "1.1 bp-proxy"
+
+// hopByHop 転送先に渡さないホップ・バイ・ホップヘッダー（RFC 7230 6.1 / RFC 9110 7.6.1）
+var hopByHop = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// StripHopByHop ホップ・バイ・ホップヘッダーとConnectionヘッダーで指定されたヘッダーを除いたコピーを返す
+// クライアントから転送先へのリクエストと、キャッシュ・転送先からクライアントへのレスポンスの両方に使う
+func StripHopByHop(header http.Header) http.Header {
+	stripped := header.Clone()
+	if stripped == nil {
+		return nil
+	}
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				stripped.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHop {
+		stripped.Del(name)
+	}
+	return stripped
+}
+
+// rangeHeaders 転送先に渡さないRangeリクエストのヘッダー
+// プロキシはリソース全体を取得してキャッシュし、Rangeはキャッシュから切り出して返すため、一部分だけを取得しない
+var rangeHeaders = []string{"Range", "If-Range"}
+
+// Outbound クライアントのリクエストヘッダーから転送先に送るヘッダーを作る
+// ホップ・バイ・ホップヘッダーとRange・If-Rangeを取り除き、ViaとX-Forwarded-For（remoteAddrのIPを末尾に追加）を付ける
+func Outbound(header http.Header, remoteAddr string) http.Header {
+	out := StripHopByHop(header)
+	if out == nil {
+		out = make(http.Header)
+	}
+	for _, name := range rangeHeaders {
+		out.Del(name)
+	}
+	out.Add("Via", ViaValue)
+	if ip := clientIP(remoteAddr); ip != "" {
+		if prior := out.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		out.Set("X-Forwarded-For", ip)
+	}
+	return out
+}
+
+// clientIP remoteAddr（host:port）からIPを取り出す
+func clientIP(remoteAddr string) string {
+	if remoteAddr == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,104 @@
+// headers_test.go - ホップ・バイ・ホップヘッダー・Rangeの除去とVia・X-Forwarded-Forの付与のテスト
+package headers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestStripHopByHop(t *testing.T) {
+	in := http.Header{
+		"Connection":          {"keep-alive, X-Session-Hop", "x-other-hop"},
+		"Proxy-Connection":    {"keep-alive"},
+		"Keep-Alive":          {"timeout=5"},
+		"Proxy-Authenticate":  {"Basic"},
+		"Proxy-Authorization": {"Basic Zm9vOmJhcg=="},
+		"Te":                  {"trailers"},
+		"Trailer":             {"Expires"},
+		"Transfer-Encoding":   {"chunked"},
+		"Upgrade":             {"websocket"},
+		"X-Session-Hop":       {"1"},
+		"X-Other-Hop":         {"2"},
+
+		"Accept":          {"text/html"},
+		"Accept-Language": {"ja"},
+		"Authorization":   {"Bearer abc"},
+		"Cache-Control":   {"no-cache"},
+		"Content-Length":  {"42"},
+		"Content-Type":    {"text/html"},
+		"Cookie":          {"a=b"},
+		"Etag":            {`"v1"`},
+		"Set-Cookie":      {"a=b"},
+	}
+	stripped := []string{
+		"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+		"Te", "Trailer", "Transfer-Encoding", "Upgrade", "X-Session-Hop", "X-Other-Hop",
+	}
+	preserved := []string{
+		"Accept", "Accept-Language", "Authorization", "Cache-Control", "Content-Length",
+		"Content-Type", "Cookie", "Etag", "Set-Cookie",
+	}
+
+	out := StripHopByHop(in)
+	for _, name := range stripped {
+		if _, ok := out[name]; ok {
+			t.Errorf("Expected %s to be stripped", name)
+		}
+	}
+	for _, name := range preserved {
+		if !reflect.DeepEqual(out[name], in[name]) {
+			t.Errorf("Expected %s to be preserved, got %v", name, out[name])
+		}
+	}
+	if len(out) != len(preserved) {
+		t.Errorf("Expected exactly %d headers, got %v", len(preserved), out)
+	}
+	// 元のヘッダーは書き換えない
+	if in.Get("Connection") == "" || in.Get("X-Session-Hop") == "" {
+		t.Error("Expected the input header to be left untouched")
+	}
+
+	if StripHopByHop(nil) != nil {
+		t.Error("Expected nil for a nil header")
+	}
+}
+
+func TestOutbound(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     http.Header
+		remoteAddr string
+		via        []string
+		xff        string
+	}{
+		{"new", http.Header{"Accept": {"*/*"}}, "192.168.1.20:53211", []string{ViaValue}, "192.168.1.20"},
+		{"ipv6", http.Header{}, "[fd00::20]:53211", []string{ViaValue}, "fd00::20"},
+		{"append", http.Header{"Via": {"1.1 tablet-gw"}, "X-Forwarded-For": {"10.0.0.5, 10.0.0.6"}}, "192.168.1.1:8000", []string{"1.1 tablet-gw", ViaValue}, "10.0.0.5, 10.0.0.6, 192.168.1.1"},
+		{"no remote addr", http.Header{}, "", []string{ViaValue}, ""},
+		{"nil header", nil, "127.0.0.1:1", []string{ViaValue}, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := Outbound(tt.header, tt.remoteAddr)
+			if got := out.Values("Via"); !reflect.DeepEqual(got, tt.via) {
+				t.Errorf("Expected Via %v, got %v", tt.via, got)
+			}
+			if got := out.Get("X-Forwarded-For"); got != tt.xff {
+				t.Errorf("Expected X-Forwarded-For %q, got %q", tt.xff, got)
+			}
+		})
+	}
+
+	// ホップ・バイ・ホップヘッダーも取り除く
+	out := Outbound(http.Header{"Connection": {"close"}, "Accept": {"*/*"}}, "192.168.1.20:1")
+	if out.Get("Connection") != "" || out.Get("Accept") != "*/*" {
+		t.Errorf("Expected hop-by-hop headers to be stripped, got %v", out)
+	}
+
+	// 一部分だけを取得しないよう、Rangeも取り除く
+	out = Outbound(http.Header{"Range": {"bytes=0-99"}, "If-Range": {`"v1"`}}, "192.168.1.20:1")
+	if out.Get("Range") != "" || out.Get("If-Range") != "" {
+		t.Errorf("Expected Range headers to be stripped, got %v", out)
+	}
+}
@@ -0,0 +1,153 @@
+// batch.go - 短い間に送る複数のリクエストを1つのバンドル（request_batch）にまとめて送る
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+)
+
+// BatchPolicy リクエストのバンドルのまとめ方
+// リクエストのJSONは数百バイトのため、1つずつ送るとバンドルごとのIONのオーバーヘッドのほうが大きくなる
+type BatchPolicy struct {
+	MaxRequests int           // 1つのバンドルにまとめるリクエストの数の上限（1以下はまとめない）
+	MaxDelay    time.Duration // 最初のリクエストからまとめて送るまで待つ時間の上限
+}
+
+// Enabled リクエストをまとめて送るか
+func (p BatchPolicy) Enabled() bool {
+	return p.MaxRequests > 1
+}
+
+// batchEnvelopeSize リクエストを含まないエンベロープ（{"version":1,"type":"request_batch","requests":[]}）のバイト数
+var batchEnvelopeSize = len(mustMarshal(DTNJsonRequestBatch{Version: protocolVersion, Type: requestBatchType, Requests: []json.RawMessage{}}))
+
+func mustMarshal(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// requestBatcher 優先度（レーン）ごとにリクエストを集め、MaxRequests件になるか、最初のリクエストからMaxDelayが過ぎたら1つのバンドルで送る
+// まとめたバンドルがmaxSizeを超える場合は、超える前に集めた分を先に送る
+// addはまとめたバンドルを送り終えるまで待ち、送信の結果（失敗した場合は同じバンドルのすべてのリクエストに同じエラー）を返す
+type requestBatcher struct {
+	policy  BatchPolicy
+	maxSize int
+	// send 1つのバンドルをpriorityのレーンで送る
+	send func(ctx context.Context, priority gateway_interface.Priority, data []byte) error
+	// sendTimeout まとめたバンドルを送るのを待つ時間（送り終えるまでの期限は、待っているリクエストではなくバッチが持つ）
+	sendTimeout time.Duration
+
+	mu      sync.Mutex
+	pending map[gateway_interface.Priority]*pendingBatch
+}
+
+// pendingBatch 送るのを待っているリクエスト
+type pendingBatch struct {
+	priority gateway_interface.Priority
+	requests []json.RawMessage
+	size     int // エンベロープを含めたバイト数
+	timer    *time.Timer
+	done     chan struct{}
+	err      error
+}
+
+func newRequestBatcher(policy BatchPolicy, maxSize int, sendTimeout time.Duration, send func(context.Context, gateway_interface.Priority, []byte) error) *requestBatcher {
+	return &requestBatcher{
+		policy:      policy,
+		maxSize:     maxSize,
+		send:        send,
+		sendTimeout: sendTimeout,
+		pending:     make(map[gateway_interface.Priority]*pendingBatch),
+	}
+}
+
+// add リクエストのJSONをpriorityのバッチに加え、そのバッチを送り終えるまで待つ
+// 待っている間にctxが終わった場合はctxのエラーを返す（バッチからは取り除かないため、リクエストは送られることがある）
+func (b *requestBatcher) add(ctx context.Context, priority gateway_interface.Priority, req []byte) error {
+	if len(req) > b.maxSize {
+		return fmt.Errorf("bundle size %d exceeds max %d", len(req), b.maxSize)
+	}
+
+	b.mu.Lock()
+	var full []*pendingBatch
+	batch := b.pending[priority]
+	if batch != nil && batch.size+len(req)+1 > b.maxSize {
+		// 加えると大きさの上限を超える場合は、集めた分を先に送る
+		full = append(full, b.detach(batch))
+		batch = nil
+	}
+	if batch == nil {
+		batch = &pendingBatch{priority: priority, size: batchEnvelopeSize - 1, done: make(chan struct{})}
+		b.pending[priority] = batch
+		flushing := batch
+		batch.timer = time.AfterFunc(b.policy.MaxDelay, func() { b.flushOnTimer(flushing) })
+	}
+	batch.requests = append(batch.requests, req)
+	batch.size += len(req) + 1 // 区切りのカンマ（最初のリクエストの分はsizeの初期値で引いている）
+	if len(batch.requests) >= b.policy.MaxRequests {
+		full = append(full, b.detach(batch))
+	}
+	b.mu.Unlock()
+
+	for _, f := range full {
+		go b.sendBatch(f)
+	}
+
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// detach batchを集めている途中のバッチから外す（b.muを持って呼ぶ）
+func (b *requestBatcher) detach(batch *pendingBatch) *pendingBatch {
+	batch.timer.Stop()
+	if b.pending[batch.priority] == batch {
+		delete(b.pending, batch.priority)
+	}
+	return batch
+}
+
+// flushOnTimer MaxDelayが過ぎたバッチを送る（件数や大きさで既に送った場合は何もしない）
+func (b *requestBatcher) flushOnTimer(batch *pendingBatch) {
+	b.mu.Lock()
+	if b.pending[batch.priority] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, batch.priority)
+	b.mu.Unlock()
+	b.sendBatch(batch)
+}
+
+// sendBatch バッチを1つのバンドルで送り、待っているリクエストに結果を知らせる
+// 1件だけのバッチは、まとめずにそのまま送る（エンベロープを読めない地上局にも届くように）
+func (b *requestBatcher) sendBatch(batch *pendingBatch) {
+	defer close(batch.done)
+
+	data := batch.requests[0]
+	if len(batch.requests) > 1 {
+		var err error
+		data, err = json.Marshal(DTNJsonRequestBatch{Version: protocolVersion, Type: requestBatchType, Requests: batch.requests})
+		if err != nil {
+			batch.err = fmt.Errorf("JSON marshal error: %w", err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.sendTimeout)
+	defer cancel()
+	log.Printf("[BpSocket] Sending batch: %d requests, size=%d bytes, priority=%s", len(batch.requests), len(data), batch.priority)
+	batch.err = b.send(ctx, batch.priority, data)
+}
@@ -0,0 +1,188 @@
+// batch_test.go - 複数のリクエストを1つのバンドルにまとめて送ることのテスト
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// recordingSender requestBatcherが送ったバンドルを記録する
+type recordingSender struct {
+	mu      sync.Mutex
+	bundles [][]byte
+	sent    chan struct{}
+}
+
+func newRecordingSender() *recordingSender {
+	return &recordingSender{sent: make(chan struct{}, 100)}
+}
+
+func (s *recordingSender) send(ctx context.Context, priority gateway_interface.Priority, data []byte) error {
+	s.mu.Lock()
+	s.bundles = append(s.bundles, data)
+	s.mu.Unlock()
+	s.sent <- struct{}{}
+	return nil
+}
+
+func (s *recordingSender) Bundles() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.bundles...)
+}
+
+// addAll reqsを並行してbにaddし、すべてのaddが戻るまで待ってエラーを返す
+func addAll(b *requestBatcher, reqs ...string) []error {
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = b.add(context.Background(), gateway_interface.PriorityHigh, []byte(req))
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// batchRequests まとめたバンドルに含まれるリクエストの数（まとめていないバンドルは1）
+func batchRequests(t *testing.T, data []byte) int {
+	t.Helper()
+	var batch DTNJsonRequestBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		t.Fatalf("invalid bundle %s: %v", data, err)
+	}
+	if batch.Type != requestBatchType {
+		return 1
+	}
+	if batch.Version != protocolVersion {
+		t.Errorf("Expected version %d, got %d", protocolVersion, batch.Version)
+	}
+	return len(batch.Requests)
+}
+
+func TestRequestBatcherFlushOnCount(t *testing.T) {
+	sender := newRecordingSender()
+	b := newRequestBatcher(BatchPolicy{MaxRequests: 3, MaxDelay: time.Hour}, maxBundleSize, time.Second, sender.send)
+
+	for _, err := range addAll(b, `{"url":"a"}`, `{"url":"b"}`, `{"url":"c"}`) {
+		if err != nil {
+			t.Fatalf("Expected the batch to be sent, got %v", err)
+		}
+	}
+	bundles := sender.Bundles()
+	if len(bundles) != 1 || batchRequests(t, bundles[0]) != 3 {
+		t.Fatalf("Expected one bundle with 3 requests, got %q", bundles)
+	}
+	if len(bundles[0]) > maxBundleSize {
+		t.Errorf("Expected the batch within %d bytes, got %d", maxBundleSize, len(bundles[0]))
+	}
+}
+
+func TestRequestBatcherFlushOnTimer(t *testing.T) {
+	sender := newRecordingSender()
+	b := newRequestBatcher(BatchPolicy{MaxRequests: 10, MaxDelay: 20 * time.Millisecond}, maxBundleSize, time.Second, sender.send)
+
+	start := time.Now()
+	for _, err := range addAll(b, `{"url":"a"}`, `{"url":"b"}`) {
+		if err != nil {
+			t.Fatalf("Expected the batch to be sent, got %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the batch to wait for MaxDelay, sent after %v", elapsed)
+	}
+	bundles := sender.Bundles()
+	if len(bundles) != 1 || batchRequests(t, bundles[0]) != 2 {
+		t.Fatalf("Expected one bundle with 2 requests, got %q", bundles)
+	}
+
+	// 1件だけの場合は、まとめずにそのまま送る
+	if err := b.add(context.Background(), gateway_interface.PriorityHigh, []byte(`{"url":"c"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if bundles := sender.Bundles(); len(bundles) != 2 || string(bundles[1]) != `{"url":"c"}` {
+		t.Errorf("Expected the single request to be sent as is, got %q", bundles)
+	}
+}
+
+func TestRequestBatcherSizeLimit(t *testing.T) {
+	sender := newRecordingSender()
+	req := fmt.Sprintf(`{"url":"%s"}`, strings.Repeat("a", 100))
+	// 2件までしか入らない大きさ
+	maxSize := batchEnvelopeSize + 2*len(req) + 1
+	b := newRequestBatcher(BatchPolicy{MaxRequests: 10, MaxDelay: 20 * time.Millisecond}, maxSize, time.Second, sender.send)
+
+	for _, err := range addAll(b, req, req, req, req, req) {
+		if err != nil {
+			t.Fatalf("Expected the requests to be sent, got %v", err)
+		}
+	}
+	total := 0
+	for _, bundle := range sender.Bundles() {
+		if len(bundle) > maxSize {
+			t.Errorf("Expected each bundle within %d bytes, got %d", maxSize, len(bundle))
+		}
+		total += batchRequests(t, bundle)
+	}
+	if total != 5 || len(sender.Bundles()) != 3 {
+		t.Errorf("Expected 5 requests in 3 bundles, got %d in %d", total, len(sender.Bundles()))
+	}
+}
+
+// sniffingConn 送ったバンドルをbundlesにも渡す
+type sniffingConn struct {
+	*loopbackConn
+	bundles chan []byte
+}
+
+func (c *sniffingConn) Send(ctx context.Context, data []byte) error {
+	c.bundles <- data
+	return c.loopbackConn.Send(ctx, data)
+}
+
+func TestBpSocketGatewayBatchesRequests(t *testing.T) {
+	origin := newOrigin(t)
+	loopback := newLoopbackConn()
+	runEarth(t, loopback, nil)
+	bundles := make(chan []byte, 10)
+	g := newBpSocketGateway(&sniffingConn{loopbackConn: loopback, bundles: bundles}, 5*time.Second, nil)
+	t.Cleanup(func() { g.Close() })
+	g.SetBatchPolicy(BatchPolicy{MaxRequests: 3, MaxDelay: time.Hour})
+
+	var wg sync.WaitGroup
+	for i := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url := fmt.Sprintf("%s/page%d", origin.URL, i)
+			resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: url}, gateway_interface.ProxyOptions{})
+			if err != nil {
+				t.Errorf("%s: %v", url, err)
+				return
+			}
+			// レスポンスはリクエストごとに届く
+			if resp.StatusCode != http.StatusOK || http.Header(resp.Headers).Get("X-Original-URL") != url {
+				t.Errorf("%s: unexpected response %d %v", url, resp.StatusCode, resp.Headers)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(bundles) != 1 {
+		t.Fatalf("Expected the 3 requests in one bundle, got %d bundles", len(bundles))
+	}
+	if n := batchRequests(t, <-bundles); n != 3 {
+		t.Errorf("Expected 3 requests in the bundle, got %d", n)
+	}
+}
@@ -4,31 +4,66 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 )
 
 const maxBundleSize = 4 * 1024 * 1024
 
+// bundleConn バンドルを送受信する接続（bpsocket.Connection、テストではプロセス内のループバック）
+type bundleConn interface {
+	Send(ctx context.Context, data []byte) error
+	Recv(buf []byte) (int, *bpsocket.SockaddrBP, error)
+	Reconnect(ctx context.Context) error
+	Close() error
+	LocalAddr() *bpsocket.SockaddrBP
+}
+
 type BpSocketGateway struct {
-	conn                  *bpsocket.Connection
+	conn                  bundleConn
+	remote                *bpsocket.SockaddrBP
 	timeout               time.Duration
 	responseChs           sync.Map
 	UnsolicitedResponseCh chan *model.BpResponse
 	stopCh                chan struct{}
 	wg                    sync.WaitGroup
+
+	// receiving 受信ループが動いているか（再接続に失敗して止まった場合はfalse）
+	receiving atomic.Bool
+
+	// retry 送信キューの空き待ちなど、一時的な送信の失敗の送り直し方（SetRetryPolicyで設定するまでは送り直さない）
+	retry sendRetrier
+
+	// lanes 優先度ごとに送信だけに使う接続（OpenPriorityLaneで開いていない優先度はconnで送る）
+	lanes map[gateway_interface.Priority]bundleConn
+
+	// roundTrips レスポンスが届くまでの時間を記録し、待つ時間を決める（SetRoundTripTrackerで設定するまではnilで、timeoutだけ待つ）
+	roundTrips *RoundTripTracker
+
+	// batcher リクエストを1つのバンドルにまとめて送る（SetBatchPolicyでまとめる設定にするまではnilで、1つずつ送る）
+	batcher *requestBatcher
+
+	// opener 受信したバンドルのエンベロープを検証する（受信ループが読むため、SetEnvelopeAuthはatomicに入れ替える）
+	opener atomic.Pointer[envelopeOpener]
+
+	metrics *metrics.Metrics
 }
 
 func NewBpSocketGateway(
 	localNodeNum, localSvcNum,
 	remoteNodeNum, remoteSvcNum uint64,
 	timeout time.Duration,
+	metrics *metrics.Metrics,
 ) (*BpSocketGateway, error) {
 	if runtime.GOOS != "linux" {
 		return nil, fmt.Errorf("bp-socket is only supported on Linux (current OS: %s)", runtime.GOOS)
@@ -39,18 +74,27 @@ func NewBpSocketGateway(
 		return nil, fmt.Errorf("BP connection failed: %w", err)
 	}
 
+	g := newBpSocketGateway(conn, timeout, metrics)
+	g.remote = bpsocket.NewSockaddrBP(remoteNodeNum, remoteSvcNum)
+	log.Printf("[BpSocket] Gateway started: %s -> ipn:%d.%d",
+		conn.LocalAddr().String(), remoteNodeNum, remoteSvcNum)
+
+	return g, nil
+}
+
+// newBpSocketGateway connで送受信するゲートウェイを作り、受信ループを始める
+func newBpSocketGateway(conn bundleConn, timeout time.Duration, metrics *metrics.Metrics) *BpSocketGateway {
 	g := &BpSocketGateway{
 		conn:                  conn,
 		timeout:               timeout,
 		UnsolicitedResponseCh: make(chan *model.BpResponse, 100),
 		stopCh:                make(chan struct{}),
+		retry:                 newSendRetrier(RetryPolicy{}),
+		lanes:                 make(map[gateway_interface.Priority]bundleConn),
+		metrics:               metrics,
 	}
-
 	g.start()
-	log.Printf("[BpSocket] Gateway started: %s -> ipn:%d.%d",
-		conn.LocalAddr().String(), remoteNodeNum, remoteSvcNum)
-
-	return g, nil
+	return g
 }
 
 func (g *BpSocketGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
@@ -58,6 +102,7 @@ func (g *BpSocketGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
 }
 
 func (g *BpSocketGateway) start() {
+	g.receiving.Store(true)
 	g.wg.Add(1)
 	go g.receiveLoop()
 }
@@ -68,10 +113,81 @@ func (g *BpSocketGateway) Close() error {
 	if err := g.conn.Close(); err != nil {
 		log.Printf("[BpSocket] Error closing connection: %v", err)
 	}
+	for priority, lane := range g.lanes {
+		if err := lane.Close(); err != nil {
+			log.Printf("[BpSocket] Error closing %s priority lane: %v", priority, err)
+		}
+	}
 	g.wg.Wait()
 	return nil
 }
 
+// SetRoundTripTracker レスポンスが届くまでの時間をtrackerに記録し、待つ時間をtrackerで決める（ProxyRequestを呼ぶ前に設定する）
+func (g *BpSocketGateway) SetRoundTripTracker(tracker *RoundTripTracker) {
+	g.roundTrips = tracker
+}
+
+// SetBatchPolicy 短い間に送るリクエストを1つのバンドルにまとめる設定にする（ProxyRequestを呼ぶ前に設定する）
+// まとめたバンドルも、大きさの上限（maxBundleSize）は超えない
+func (g *BpSocketGateway) SetBatchPolicy(policy BatchPolicy) {
+	if !policy.Enabled() {
+		g.batcher = nil
+		return
+	}
+	g.batcher = newRequestBatcher(policy, maxBundleSize, g.timeout, g.sendBatchBundle)
+}
+
+// SetRetryPolicy バンドルの送信に一時的に失敗したとき（ENOBUFSなど）の送り直し方を設定する（ProxyRequestを呼ぶ前に設定する）
+func (g *BpSocketGateway) SetRetryPolicy(policy RetryPolicy) {
+	g.retry.policy = policy
+}
+
+// SetEnvelopeAuth 地上局から届くバンドルのMACを検証し、暗号化されたバンドルを復号する設定にする
+// 設定するまでは、MACを検証せずにエンベロープからレスポンスを取り出し、暗号化されたバンドルは捨てる
+func (g *BpSocketGateway) SetEnvelopeAuth(auth EnvelopeAuth) error {
+	opener, err := newEnvelopeOpener(auth)
+	if err != nil {
+		return err
+	}
+	g.opener.Store(opener)
+	return nil
+}
+
+// OpenPriorityLane priorityのリクエストをeidから送る接続を開く（ProxyRequestを呼ぶ前に開く）
+// レスポンスは地上局がrequest_idで返すため、レーンの接続では受信しない
+func (g *BpSocketGateway) OpenPriorityLane(priority gateway_interface.Priority, eid string) error {
+	local, err := bpsocket.ParseEID(eid)
+	if err != nil {
+		return fmt.Errorf("%s priority lane: %w", priority, err)
+	}
+	if g.remote == nil {
+		return fmt.Errorf("%s priority lane: no destination to send to", priority)
+	}
+	conn, err := bpsocket.NewConnection(uint64(local.NodeNum), uint64(local.SvcNum), uint64(g.remote.NodeNum), uint64(g.remote.SvcNum))
+	if err != nil {
+		return fmt.Errorf("%s priority lane %s: BP connection failed: %w", priority, eid, err)
+	}
+	g.addLane(priority, conn)
+	return nil
+}
+
+// addLane priorityのリクエストをconnで送る（既に開いたレーンは閉じて置き換える）
+func (g *BpSocketGateway) addLane(priority gateway_interface.Priority, conn bundleConn) {
+	if old, ok := g.lanes[priority]; ok {
+		old.Close()
+	}
+	g.lanes[priority] = conn
+	log.Printf("[BpSocket] %s priority lane: %s", priority, conn.LocalAddr().String())
+}
+
+// sendConn priorityのリクエストを送る接続
+func (g *BpSocketGateway) sendConn(priority gateway_interface.Priority) bundleConn {
+	if lane, ok := g.lanes[priority]; ok {
+		return lane
+	}
+	return g.conn
+}
+
 func (g *BpSocketGateway) receiveLoop() {
 	defer g.wg.Done()
 
@@ -94,6 +210,11 @@ func (g *BpSocketGateway) receiveLoop() {
 			default:
 			}
 
+			// シグナルによる中断やタイムアウトは障害ではないため、再接続の判定に数えない
+			if errors.Is(err, bpsocket.ErrInterrupted) || errors.Is(err, bpsocket.ErrTimeout) {
+				continue
+			}
+
 			consecutiveErrors++
 			log.Printf("[BpSocket] Recv error (%d): %v", consecutiveErrors, err)
 
@@ -104,6 +225,7 @@ func (g *BpSocketGateway) receiveLoop() {
 				if err := g.conn.Reconnect(ctx); err != nil {
 					cancel()
 					log.Printf("[BpSocket] Reconnect failed: %v, stopping receive loop", err)
+					g.receiving.Store(false)
 					return
 				}
 				cancel()
@@ -124,8 +246,16 @@ func (g *BpSocketGateway) receiveLoop() {
 
 		log.Printf("[BpSocket] Received %d bytes from %s", n, fromAddr.String())
 
+		payload, err := g.openBundle(buf[:n])
+		if err != nil {
+			if !errors.Is(err, errControlBundle) {
+				log.Printf("[BpSocket] Dropped bundle from %s: %v", fromAddr.String(), err)
+			}
+			continue
+		}
+
 		var dtnResp DTNJsonResponse
-		if err := json.Unmarshal(buf[:n], &dtnResp); err != nil {
+		if err := json.Unmarshal(payload, &dtnResp); err != nil {
 			log.Printf("[BpSocket] JSON unmarshal error: %v", err)
 			continue
 		}
@@ -139,11 +269,28 @@ func (g *BpSocketGateway) receiveLoop() {
 	}
 }
 
+// openBundle 受信したバンドルのエンベロープを検証し、レスポンスのJSONを返す
+func (g *BpSocketGateway) openBundle(data []byte) ([]byte, error) {
+	opener := g.opener.Load()
+	if opener == nil {
+		opener = &envelopeOpener{}
+	}
+	return opener.open(data)
+}
+
+// HealthCheck 受信ループが動いているかを返す（再接続に失敗して止まった場合はレスポンスを受け取れない）
+func (g *BpSocketGateway) HealthCheck(ctx context.Context) error {
+	if !g.receiving.Load() {
+		return fmt.Errorf("%w: bp-socket receive loop stopped after reconnect failure", gateway_interface.ErrLinkDown)
+	}
+	return nil
+}
+
 func (g *BpSocketGateway) dispatchResponse(dtnResp *DTNJsonResponse) {
-	if ch, ok := g.responseChs.Load(dtnResp.RequestID); ok {
+	if ch, ok := lookupResponseCh(&g.responseChs, dtnResp); ok {
 		log.Printf("[BpSocket] Dispatching response for ID: %s", dtnResp.RequestID)
 		select {
-		case ch.(chan *DTNJsonResponse) <- dtnResp:
+		case ch <- dtnResp:
 		default:
 			log.Printf("[BpSocket] Channel blocked for ID: %s", dtnResp.RequestID)
 		}
@@ -164,29 +311,41 @@ func (g *BpSocketGateway) dispatchResponse(dtnResp *DTNJsonResponse) {
 	}
 }
 
-func (g *BpSocketGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, error) {
-	reqID := generateID()
+func (g *BpSocketGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (resp *model.BpResponse, err error) {
+	start := time.Now()
+	defer func() { observeRoundTrip(g.metrics, transportBpSocket, start, err) }()
+	return g.roundTrip(ctx, breq, opts.Priority)
+}
 
-	respCh := make(chan *DTNJsonResponse, 1)
-	g.responseChs.Store(reqID, respCh)
-	defer g.responseChs.Delete(reqID)
+// Probe 地上局にprobeURLを送り、応答が届くまでの往復時間を返す（転送したリクエストのメトリクスには含めない）
+func (g *BpSocketGateway) Probe(ctx context.Context) (time.Duration, error) {
+	return probeRoundTrip(ctx, g.roundTrip)
+}
 
-	if err := g.sendBundle(ctx, reqID, breq); err != nil {
-		return nil, fmt.Errorf("bundle送信失敗: %w", err)
+// roundTrip breqをpriorityのレーンでバンドルとして送り、レスポンスを待つ
+func (g *BpSocketGateway) roundTrip(ctx context.Context, breq *model.BpRequest, priority gateway_interface.Priority) (*model.BpResponse, error) {
+	// 受信ループが止まっている場合は、送ってもレスポンスを受け取れない
+	if err := g.HealthCheck(ctx); err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, g.timeout)
-	defer cancel()
+	respCh := make(chan *DTNJsonResponse, 1)
+	reqID := registerResponseCh(&g.responseChs, breq, respCh)
+	defer g.responseChs.Delete(reqID)
 
-	select {
-	case dtnResp := <-respCh:
-		return ConvertToBpResponse(dtnResp)
-	case <-ctx.Done():
-		return nil, fmt.Errorf("request timeout or cancelled: %w", ctx.Err())
+	err := g.retry.do(ctx, "[BpSocket]", func() error { return g.sendBundle(ctx, reqID, breq, priority) })
+	if err != nil {
+		return nil, sendFailure(ctx, err)
 	}
+	// まとめて送る場合は、まとめたバンドルを送ったときに数える
+	if g.batcher == nil {
+		g.metrics.IncBundlesSent(transportBpSocket)
+	}
+
+	return awaitRoundTrip(ctx, respCh, g.timeout, g.roundTrips)
 }
 
-func (g *BpSocketGateway) sendBundle(ctx context.Context, reqID string, breq *model.BpRequest) error {
+func (g *BpSocketGateway) sendBundle(ctx context.Context, reqID string, breq *model.BpRequest, priority gateway_interface.Priority) error {
 	dtnReq := NewDTNJsonRequest(reqID, breq)
 
 	jsonData, err := json.Marshal(dtnReq)
@@ -198,10 +357,40 @@ func (g *BpSocketGateway) sendBundle(ctx context.Context, reqID string, breq *mo
 		return fmt.Errorf("bundle size %d exceeds max %d", len(jsonData), maxBundleSize)
 	}
 
-	log.Printf("[BpSocket] Sending bundle: ID=%s, size=%d bytes", reqID, len(jsonData))
+	if g.batcher != nil {
+		log.Printf("[BpSocket] Batching request: ID=%s, size=%d bytes, priority=%s", reqID, len(jsonData), priority)
+		return g.batcher.add(ctx, priority, jsonData)
+	}
+
+	conn := g.sendConn(priority)
+	log.Printf("[BpSocket] Sending bundle: ID=%s, size=%d bytes, priority=%s (%s)", reqID, len(jsonData), priority, conn.LocalAddr().String())
+	return g.send(ctx, conn, jsonData)
+}
+
+// sendBatchBundle まとめたリクエストのバンドルをpriorityのレーンで送る
+func (g *BpSocketGateway) sendBatchBundle(ctx context.Context, priority gateway_interface.Priority, data []byte) error {
+	if err := g.send(ctx, g.sendConn(priority), data); err != nil {
+		return err
+	}
+	g.metrics.IncBundlesSent(transportBpSocket)
+	return nil
+}
 
-	if err := g.conn.Send(ctx, jsonData); err != nil {
-		return fmt.Errorf("socket send error: %w", err)
+// send connで1つのバンドルを送る（送り直せる失敗には印を付ける）
+func (g *BpSocketGateway) send(ctx context.Context, conn bundleConn, jsonData []byte) error {
+	if err := conn.Send(ctx, jsonData); err != nil {
+		err = fmt.Errorf("socket send error: %w", err)
+		if isTransientSocketError(err) {
+			return transientSend(err)
+		}
+		return err
 	}
 	return nil
 }
+
+// isTransientSocketError 送信キューの空き待ち・タイムアウト・シグナルによる中断など、送り直せば送れる可能性がある失敗か
+func isTransientSocketError(err error) bool {
+	return errors.Is(err, bpsocket.ErrNoBufferSpace) ||
+		errors.Is(err, bpsocket.ErrTimeout) ||
+		errors.Is(err, bpsocket.ErrInterrupted)
+}
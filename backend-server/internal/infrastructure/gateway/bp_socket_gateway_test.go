@@ -3,10 +3,15 @@ package gateway
 
 import (
 	"encoding/json"
+	"errors"
+	"regexp"
+	"runtime"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
 )
 
 func TestDTNJsonSerialization(t *testing.T) {
@@ -86,6 +91,11 @@ func TestGenerateID(t *testing.T) {
 	if len(id1) == 0 {
 		t.Error("Generated ID should not be empty")
 	}
+
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuidV4.MatchString(id1) {
+		t.Errorf("Expected a UUIDv4, got %q", id1)
+	}
 }
 
 func TestResponseChannelMapping(t *testing.T) {
@@ -93,7 +103,7 @@ func TestResponseChannelMapping(t *testing.T) {
 }
 
 func TestBpSocketGatewayLinuxOnly(t *testing.T) {
-	_, err := NewBpSocketGateway(149, 1, 150, 1, 30*time.Second)
+	_, err := NewBpSocketGateway(149, 1, 150, 1, 30*time.Second, nil)
 
 	// Linux以外のプラットフォームでは失敗する（bp-socketはLinux専用）
 	if err != nil {
@@ -101,6 +111,22 @@ func TestBpSocketGatewayLinuxOnly(t *testing.T) {
 	}
 }
 
+func TestSyscallErrorClassification(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("errno classification is Linux-only")
+	}
+	var err error = &bpsocket.SyscallError{Op: "bind", Errno: syscall.EADDRINUSE}
+	if !errors.Is(err, bpsocket.ErrAddressInUse) {
+		t.Errorf("Expected ErrAddressInUse, got %v", err)
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("Expected the original errno to match, got %v", err)
+	}
+	if errors.Is(err, bpsocket.ErrModuleNotLoaded) {
+		t.Errorf("Did not expect ErrModuleNotLoaded, got %v", err)
+	}
+}
+
 func TestProtocolVersionCheck(t *testing.T) {
 	req := &model.BpRequest{
 		Method: "POST",
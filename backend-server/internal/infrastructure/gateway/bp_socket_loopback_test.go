@@ -0,0 +1,415 @@
+// bp_socket_loopback_test.go - BP-Socketゲートウェイと地上局（earth）の処理を1つのプロセスでつないだ、送信から受信までのテスト
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+)
+
+// loopbackConn AF_BPソケットの代わりに、送ったバンドルをoutboxへ、受け取るバンドルをinboxから渡す接続
+type loopbackConn struct {
+	outbox    chan []byte
+	inbox     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newLoopbackConn() *loopbackConn {
+	return &loopbackConn{
+		outbox: make(chan []byte, 64),
+		inbox:  make(chan []byte, 64),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *loopbackConn) Send(ctx context.Context, data []byte) error {
+	select {
+	case c.outbox <- bytes.Clone(data):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+		return errors.New("connection closed")
+	}
+}
+
+func (c *loopbackConn) Recv(buf []byte) (int, *bpsocket.SockaddrBP, error) {
+	select {
+	case data := <-c.inbox:
+		return copy(buf, data), bpsocket.NewSockaddrBP(2, 1), nil
+	case <-c.closed:
+		return 0, nil, errors.New("connection closed")
+	}
+}
+
+func (c *loopbackConn) Reconnect(ctx context.Context) error { return nil }
+
+func (c *loopbackConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *loopbackConn) LocalAddr() *bpsocket.SockaddrBP { return bpsocket.NewSockaddrBP(1, 1) }
+
+// earthResponse 地上局が返すレスポンスのバンドル（earth/cmd/app.BpResponseと同じJSON）
+type earthResponse struct {
+	Version       int                 `json:"version"`
+	RequestID     string              `json:"request_id"`
+	StatusCode    int                 `json:"status_code"`
+	Headers       map[string][]string `json:"headers"`
+	Body          string              `json:"body"`
+	ContentType   string              `json:"content_type,omitempty"`
+	ContentLength int64               `json:"content_length,omitempty"`
+}
+
+// runEarth 地上局と同じく、届いたリクエストのバンドルを転送先へHTTPで送り、レスポンスをバンドルで返す
+// delayはリクエストごとに返すまで待つ時間（バンドルの順序が入れ替わる場合の再現）
+func runEarth(t *testing.T, conn *loopbackConn, delay func(*DTNJsonRequest) time.Duration) {
+	t.Helper()
+	var wg sync.WaitGroup
+	t.Cleanup(wg.Wait)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			var data []byte
+			select {
+			case data = <-conn.outbox:
+			case <-conn.closed:
+				return
+			}
+			for _, data := range splitRequestBatch(data) {
+				var req DTNJsonRequest
+				if err := json.Unmarshal(data, &req); err != nil {
+					t.Errorf("earth: invalid request bundle: %v", err)
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if delay != nil {
+						time.Sleep(delay(&req))
+					}
+					resp, err := fetchForEarth(&req)
+					if err != nil {
+						t.Errorf("earth: fetch failed: %v", err)
+						return
+					}
+					bundle, _ := json.Marshal(resp)
+					select {
+					case conn.inbox <- bundle:
+					case <-conn.closed:
+					}
+				}()
+			}
+		}
+	}()
+}
+
+// splitRequestBatch 地上局のrecvステージと同じように、まとめたリクエストのバンドルを1つずつのリクエストに分ける
+func splitRequestBatch(data []byte) [][]byte {
+	var batch DTNJsonRequestBatch
+	if err := json.Unmarshal(data, &batch); err != nil || batch.Type != requestBatchType {
+		return [][]byte{data}
+	}
+	requests := make([][]byte, len(batch.Requests))
+	for i, req := range batch.Requests {
+		requests[i] = req
+	}
+	return requests
+}
+
+func fetchForEarth(req *DTNJsonRequest) (*earthResponse, error) {
+	// 地上局と同じく、プローブには取得せずにすぐ応答する
+	if req.URL == probeURL {
+		return &earthResponse{
+			Version:    protocolVersion,
+			RequestID:  req.RequestID,
+			StatusCode: http.StatusOK,
+			Headers:    map[string][]string{"X-Original-URL": {probeURL}},
+		}, nil
+	}
+	body, err := base64.StdEncoding.DecodeString(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(req.Method, req.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.Headers {
+		for _, value := range values {
+			httpReq.Header.Add(key, value)
+		}
+	}
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// 地上局と同じく、どのURLのレスポンスかをX-Original-URLで伝える（タイムアウト後に届いた場合のキャッシュ用）
+	headers := httpResp.Header.Clone()
+	headers["X-Original-URL"] = []string{req.URL}
+	return &earthResponse{
+		Version:       protocolVersion,
+		RequestID:     req.RequestID,
+		StatusCode:    httpResp.StatusCode,
+		Headers:       headers,
+		Body:          base64.StdEncoding.EncodeToString(respBody),
+		ContentType:   httpResp.Header.Get("Content-Type"),
+		ContentLength: int64(len(respBody)),
+	}, nil
+}
+
+// newLoopbackGateway ループバックの接続で地上局とつないだゲートウェイ
+func newLoopbackGateway(t *testing.T, timeout time.Duration, delay func(*DTNJsonRequest) time.Duration) (*BpSocketGateway, *loopbackConn) {
+	t.Helper()
+	conn := newLoopbackConn()
+	// 地上局の処理の終了を待つ前に、ゲートウェイを閉じて接続を切る（Cleanupは登録の逆順に実行される）
+	runEarth(t, conn, delay)
+	g := newBpSocketGateway(conn, timeout, nil)
+	t.Cleanup(func() { g.Close() })
+	return g, conn
+}
+
+// newOrigin メソッド・ヘッダー・ボディをそのまま返す転送先
+func newOrigin(t *testing.T) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Echo-Lang", r.Header.Get("Accept-Language"))
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func TestBpSocketGatewayLoopback(t *testing.T) {
+	origin := newOrigin(t)
+	g, _ := newLoopbackGateway(t, 5*time.Second, nil)
+
+	tests := []struct {
+		name   string
+		req    *model.BpRequest
+		status int
+		body   string
+	}{
+		{"get", &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/page", Headers: map[string][]string{"Accept-Language": {"ja"}}, RequestID: "req-get"}, http.StatusOK, "GET /page "},
+		{"post", &model.BpRequest{Method: http.MethodPost, URL: origin.URL + "/form", Body: []byte("a=1&b=\x00\xff")}, http.StatusOK, "POST /form a=1&b=\x00\xff"},
+		{"not found", &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/missing"}, http.StatusNotFound, "GET /missing "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := g.ProxyRequest(context.Background(), tt.req, gateway_interface.ProxyOptions{})
+			if err != nil {
+				t.Fatalf("ProxyRequest failed: %v", err)
+			}
+			if resp.StatusCode != tt.status || string(resp.Body) != tt.body {
+				t.Errorf("Expected %d %q, got %d %q", tt.status, tt.body, resp.StatusCode, resp.Body)
+			}
+			if resp.ContentType != "text/plain; charset=utf-8" || resp.ContentLength != int64(len(tt.body)) {
+				t.Errorf("Unexpected content type/length: %q %d", resp.ContentType, resp.ContentLength)
+			}
+			if lang := http.Header(resp.Headers).Get("X-Echo-Lang"); len(tt.req.Headers) > 0 && lang != "ja" {
+				t.Errorf("Expected the request headers to reach the origin, got %q", lang)
+			}
+		})
+	}
+}
+
+func TestBpSocketGatewayLoopbackConcurrent(t *testing.T) {
+	origin := newOrigin(t)
+	const n = 20
+	// 番号の大きいリクエストほど先にレスポンスが届く（送った順とは入れ替わる）
+	delay := func(req *DTNJsonRequest) time.Duration {
+		var i int
+		fmt.Sscanf(req.URL[len(origin.URL):], "/item/%d", &i)
+		return time.Duration(n-i) * 2 * time.Millisecond
+	}
+	g, _ := newLoopbackGateway(t, 5*time.Second, delay)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/item/%d", i)
+			resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + path}, gateway_interface.ProxyOptions{})
+			if err != nil {
+				t.Errorf("ProxyRequest %d failed: %v", i, err)
+				return
+			}
+			if want := "GET " + path + " "; string(resp.Body) != want {
+				t.Errorf("Expected %q, got %q", want, resp.Body)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestBpSocketGatewayLoopbackUnsolicited(t *testing.T) {
+	g, conn := newLoopbackGateway(t, 5*time.Second, nil)
+
+	// 待っているリクエストのないレスポンス（地上局が事前取得で送ったものなど）
+	bundle, _ := json.Marshal(earthResponse{RequestID: "pushed", StatusCode: http.StatusOK, Body: base64.StdEncoding.EncodeToString([]byte("pushed"))})
+	conn.inbox <- bundle
+
+	select {
+	case resp := <-g.GetUnsolicitedResponseCh():
+		if string(resp.Body) != "pushed" {
+			t.Errorf("Expected the pushed body, got %q", resp.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected an unsolicited response")
+	}
+}
+
+func TestBpSocketGatewayLoopbackTimeout(t *testing.T) {
+	origin := newOrigin(t)
+	g, _ := newLoopbackGateway(t, 20*time.Millisecond, func(*DTNJsonRequest) time.Duration { return 200 * time.Millisecond })
+
+	_, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/slow"}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+
+	// タイムアウトしたリクエストの待ち受けは残さない
+	g.responseChs.Range(func(key, _ any) bool {
+		t.Errorf("Expected no waiter after the timeout, got %v", key)
+		return true
+	})
+
+	// 後から届いたレスポンスは、待っているリクエストのないレスポンスとしてキャッシュに回す
+	select {
+	case resp := <-g.GetUnsolicitedResponseCh():
+		if url := http.Header(resp.Headers).Get("X-Original-URL"); url != origin.URL+"/slow" || string(resp.Body) != "GET /slow " {
+			t.Errorf("Expected the late response for /slow, got %q %q", url, resp.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the late response to be handed over as unsolicited")
+	}
+}
+
+func TestBpSocketGatewayLoopbackPrefetch(t *testing.T) {
+	origin := newOrigin(t)
+	conn := newLoopbackConn()
+	g := newBpSocketGateway(conn, 5*time.Second, nil)
+	t.Cleanup(func() { g.Close() })
+
+	done := make(chan *model.BpResponse, 1)
+	go func() {
+		resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/page"}, gateway_interface.ProxyOptions{})
+		if err != nil {
+			t.Errorf("ProxyRequest failed: %v", err)
+		}
+		done <- resp
+	}()
+
+	// 地上局と同じく、ページより先に再帰的に取得したリンクのレスポンスを元のrequest_idで返す
+	var req DTNJsonRequest
+	if err := json.Unmarshal(<-conn.outbox, &req); err != nil {
+		t.Fatal(err)
+	}
+	links := []string{"/a", "/b", "/c"}
+	for _, path := range append(links, "/page") {
+		resp, err := fetchForEarth(&DTNJsonRequest{RequestID: req.RequestID, Method: http.MethodGet, URL: origin.URL + path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle, _ := json.Marshal(resp)
+		conn.inbox <- bundle
+	}
+
+	if resp := <-done; resp == nil || string(resp.Body) != "GET /page " {
+		t.Fatalf("Expected the page response, got %+v", resp)
+	}
+	for _, path := range links {
+		select {
+		case resp := <-g.GetUnsolicitedResponseCh():
+			if url := http.Header(resp.Headers).Get("X-Original-URL"); url != origin.URL+path {
+				t.Errorf("Expected the prefetched response for %s, got %s", path, url)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the prefetched response for %s", path)
+		}
+	}
+}
+
+// laneConn 地上局へ送るだけのレーンの接続（送ったバンドルはmainのloopbackConnから地上局に届く）
+type laneConn struct {
+	*loopbackConn
+	addr *bpsocket.SockaddrBP
+
+	mu    sync.Mutex
+	sends int
+}
+
+func (c *laneConn) Send(ctx context.Context, data []byte) error {
+	c.mu.Lock()
+	c.sends++
+	c.mu.Unlock()
+	return c.loopbackConn.Send(ctx, data)
+}
+
+func (c *laneConn) Sends() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sends
+}
+
+// Close レーンを閉じてもmainの接続は閉じない
+func (c *laneConn) Close() error { return nil }
+
+func (c *laneConn) LocalAddr() *bpsocket.SockaddrBP { return c.addr }
+
+func TestBpSocketGatewayPriorityLanes(t *testing.T) {
+	origin := newOrigin(t)
+	conn := newLoopbackConn()
+	runEarth(t, conn, nil)
+	g := newBpSocketGateway(conn, 5*time.Second, nil)
+	t.Cleanup(func() { g.Close() })
+	low := &laneConn{loopbackConn: conn, addr: bpsocket.NewSockaddrBP(1, 3)}
+	g.addLane(gateway_interface.PriorityLow, low)
+
+	// lowはレーンから送り、レスポンスはmainの接続で受信する
+	resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/prefetch"}, gateway_interface.ProxyOptions{Priority: gateway_interface.PriorityLow})
+	if err != nil || string(resp.Body) != "GET /prefetch " {
+		t.Fatalf("Expected the low priority response, got %+v %v", resp, err)
+	}
+	if low.Sends() != 1 {
+		t.Errorf("Expected the low priority bundle on the lane, got %d sends", low.Sends())
+	}
+
+	// ProxyOptionsのゼロ値（high）はレーンを使わない
+	if _, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/page"}, gateway_interface.ProxyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if low.Sends() != 1 {
+		t.Errorf("Expected the high priority bundle not to use the low lane, got %d sends", low.Sends())
+	}
+	if g.sendConn(gateway_interface.PriorityHigh) != bundleConn(conn) {
+		t.Error("Expected high priority to be sent on the main connection")
+	}
+}
@@ -3,6 +3,8 @@ package bpsocket
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"unsafe"
 )
 
@@ -41,6 +43,27 @@ func NewSockaddrBP(nodeNum, svcNum uint64) *SockaddrBP {
 	}
 }
 
+// ParseEID エンドポイントIDの文字列（"ipn:150.1"）からアドレスを作成
+func ParseEID(eid string) (*SockaddrBP, error) {
+	rest, ok := strings.CutPrefix(eid, "ipn:")
+	if !ok {
+		return nil, fmt.Errorf("%w %q: unsupported scheme (expected ipn:<node>.<service>)", ErrInvalidEID, eid)
+	}
+	node, svc, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, fmt.Errorf("%w %q: expected ipn:<node>.<service>", ErrInvalidEID, eid)
+	}
+	nodeNum, err := strconv.ParseUint(node, 10, 32)
+	if err != nil || nodeNum == 0 {
+		return nil, fmt.Errorf("%w %q: bad node number", ErrInvalidEID, eid)
+	}
+	svcNum, err := strconv.ParseUint(svc, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: bad service number", ErrInvalidEID, eid)
+	}
+	return NewSockaddrBP(nodeNum, svcNum), nil
+}
+
 func (sa *SockaddrBP) String() string {
 	return fmt.Sprintf("ipn:%d.%d", sa.NodeNum, sa.SvcNum)
 }
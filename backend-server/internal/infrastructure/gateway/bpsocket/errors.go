@@ -0,0 +1,53 @@
+// errors.go - bp-socket操作で返すエラー定義
+package bpsocket
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// ErrTimeout 送受信がタイムアウトした（実際の障害ではない）
+var ErrTimeout = errors.New("bpsocket: operation timed out")
+
+// ErrModuleNotLoaded AF_BPのカーネルモジュールがロードされていない（ソケットを作成できない）
+var ErrModuleNotLoaded = errors.New("bpsocket: bp kernel module not loaded")
+
+// ErrAddressInUse 別のプロセスが同じEIDにバインドしている
+var ErrAddressInUse = errors.New("bpsocket: address already in use")
+
+// ErrInterrupted シグナルでシステムコールが中断された（再試行すればよい一時的なエラー）
+var ErrInterrupted = errors.New("bpsocket: interrupted system call")
+
+// ErrNoBufferSpace 送信キューに空きがない（ENOBUFS、時間を空けて送り直せばよい一時的なエラー）
+var ErrNoBufferSpace = errors.New("bpsocket: no buffer space available")
+
+// ErrInvalidEID エンドポイントIDの形式が正しくない（ipn:<node>.<service>でない）
+var ErrInvalidEID = errors.New("bpsocket: invalid EID")
+
+// SyscallError 失敗したシステムコールとerrno
+// errors.IsでErrModuleNotLoadedなどの分類済みのエラーと、元のerrno（syscall.EADDRINUSEなど）の両方に一致する
+type SyscallError struct {
+	Op    string        // システムコール名（"bind"、"sendto"など）
+	Errno syscall.Errno // カーネルが返したerrno
+}
+
+func (e *SyscallError) Error() string {
+	return fmt.Sprintf("%s syscall error: %v", e.Op, e.Errno)
+}
+
+func (e *SyscallError) Unwrap() []error {
+	if kind := classifyErrno(e.Errno); kind != nil {
+		return []error{kind, e.Errno}
+	}
+	return []error{e.Errno}
+}
+
+// newSyscallError システムコールのエラーをSyscallErrorに変換する（errno以外のエラーはそのまま返す）
+func newSyscallError(op string, err error) error {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	return &SyscallError{Op: op, Errno: errno}
+}
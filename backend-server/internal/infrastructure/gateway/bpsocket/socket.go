@@ -14,7 +14,7 @@ type BpSocket struct {
 func NewBpSocket(localNodeNum, localSvcNum uint64) (*BpSocket, error) {
 	fd, err := syscall.Socket(AF_BP, SOCK_DGRAM, BP_PROTO)
 	if err != nil {
-		return nil, fmt.Errorf("socket creation failed: %w", err)
+		return nil, fmt.Errorf("socket creation failed: %w", newSyscallError("socket", err))
 	}
 
 	localAddr := NewSockaddrBP(localNodeNum, localSvcNum)
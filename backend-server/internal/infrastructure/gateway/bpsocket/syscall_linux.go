@@ -5,11 +5,29 @@
 package bpsocket
 
 import (
-	"fmt"
 	"syscall"
 	"unsafe"
 )
 
+// classifyErrno errnoを呼び出し側が判断に使う分類済みのエラーに対応付ける（該当しない場合はnil）
+func classifyErrno(errno syscall.Errno) error {
+	switch errno {
+	case syscall.EAFNOSUPPORT, syscall.EPROTONOSUPPORT, syscall.ENODEV:
+		// AF_BPが登録されていない、またはカーネルモジュールがアンロードされた
+		return ErrModuleNotLoaded
+	case syscall.EADDRINUSE:
+		return ErrAddressInUse
+	case syscall.EINTR:
+		return ErrInterrupted
+	case syscall.ENOBUFS:
+		return ErrNoBufferSpace
+	case syscall.EAGAIN, syscall.ETIMEDOUT:
+		// EWOULDBLOCKはEAGAINと同じ値
+		return ErrTimeout
+	}
+	return nil
+}
+
 func closeFd(fd int) error {
 	return syscall.Close(fd)
 }
@@ -23,7 +41,7 @@ func bind(fd int, addr *SockaddrBP) error {
 		uintptr(unsafe.Sizeof(*addr)),
 	)
 	if errno != 0 {
-		return fmt.Errorf("bind syscall error: %v", errno)
+		return newSyscallError("bind", errno)
 	}
 	return nil
 }
@@ -40,7 +58,7 @@ func sendto(fd int, data []byte, remoteAddr *SockaddrBP) error {
 		uintptr(unsafe.Sizeof(*remoteAddr)),
 	)
 	if errno != 0 {
-		return fmt.Errorf("sendto syscall error: %v", errno)
+		return newSyscallError("sendto", errno)
 	}
 	return nil
 }
@@ -59,7 +77,7 @@ func recvfrom(fd int, buf []byte) (int, *SockaddrBP, error) {
 		uintptr(unsafe.Pointer(&fromLen)),
 	)
 	if errno != 0 {
-		return 0, nil, fmt.Errorf("recvfrom syscall error: %v", errno)
+		return 0, nil, newSyscallError("recvfrom", errno)
 	}
 
 	return int(n), &fromAddr, nil
@@ -9,6 +9,10 @@ import (
 	"syscall"
 )
 
+func classifyErrno(errno syscall.Errno) error {
+	return nil
+}
+
 func closeFd(fd int) error {
 	return syscall.Close(syscall.Handle(fd))
 }
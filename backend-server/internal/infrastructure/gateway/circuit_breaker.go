@@ -0,0 +1,201 @@
+// circuit_breaker.go - DTNへのリンクが使えない間、ゲートウェイを試さずにすぐ失敗させるサーキットブレーカー
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// サーキットブレーカーの状態（メトリクスのラベルと同じ）
+const (
+	circuitClosed   = "closed"    // 通常どおり転送する
+	circuitOpen     = "open"      // クールダウンが終わるまで転送せずにErrCircuitOpenを返す
+	circuitHalfOpen = "half_open" // 1つのリクエストだけ試しに転送し、結果で閉じるか開き直すかを決める
+)
+
+// CircuitBreaker ゲートウェイの失敗（リンク断・送信失敗・タイムアウト）がthreshold回続いたら開き、
+// coolDownの間は転送せずにErrCircuitOpenを返す。クールダウンの後は1つのリクエストだけ試し、成功すれば閉じる
+// リンクが切れている間、キャッシュミスのたびにワーカーがタイムアウトまで待たされないようにする
+type CircuitBreaker struct {
+	gw        gateway_interface.BpGateway
+	threshold int
+	coolDown  time.Duration
+	metrics   *metrics.Metrics
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int       // 閉じている間に続いた失敗の回数
+	openedAt time.Time // 最後に開いた時刻
+	probing  bool      // 半開で試しのリクエストを転送中
+	lastErr  error     // 開いた原因の失敗
+}
+
+var (
+	_ gateway_interface.BpGateway     = (*CircuitBreaker)(nil)
+	_ gateway_interface.HealthChecker = (*CircuitBreaker)(nil)
+	_ gateway_interface.Prober        = (*CircuitBreaker)(nil)
+)
+
+// NewCircuitBreaker gwをサーキットブレーカーで包む（thresholdは開くまでに続いた失敗の回数、1未満は1とみなす）
+func NewCircuitBreaker(gw gateway_interface.BpGateway, threshold int, coolDown time.Duration, metrics *metrics.Metrics) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		gw:        gw,
+		threshold: max(threshold, 1),
+		coolDown:  coolDown,
+		metrics:   metrics,
+		now:       time.Now,
+		state:     circuitClosed,
+	}
+	metrics.SetCircuitState(circuitClosed, false)
+	return cb
+}
+
+func (cb *CircuitBreaker) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (*model.BpResponse, error) {
+	probe, err := cb.allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cb.gw.ProxyRequest(ctx, breq, opts)
+	cb.record(probe, err)
+	return resp, err
+}
+
+// Probe 包んだゲートウェイでプローブを送り、結果で状態を更新する
+// 開いている間もクールダウンを待たずに送り、応答が届けばすぐに閉じる（届かなければ失敗として数える）
+func (cb *CircuitBreaker) Probe(ctx context.Context) (time.Duration, error) {
+	prober, ok := cb.gw.(gateway_interface.Prober)
+	if !ok {
+		return 0, gateway_interface.ErrProbeUnsupported
+	}
+	rtt, err := prober.Probe(ctx)
+	cb.record(false, err)
+	return rtt, err
+}
+
+// allow 転送してよいかを返す（半開で試しのリクエストになる場合はprobeがtrue）
+func (cb *CircuitBreaker) allow() (probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitClosed:
+		return false, nil
+	case circuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.coolDown {
+			return false, cb.openError()
+		}
+		cb.setState(circuitHalfOpen)
+	}
+	// 半開では、試しのリクエストの結果が出るまで他のリクエストは転送しない
+	if cb.probing {
+		return false, cb.openError()
+	}
+	cb.probing = true
+	return true, nil
+}
+
+// record 転送の結果から状態を更新する
+func (cb *CircuitBreaker) record(probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if probe {
+		cb.probing = false
+	}
+	switch {
+	case isLinkFailure(err):
+		cb.lastErr = err
+		if probe || cb.state == circuitHalfOpen {
+			cb.open()
+			return
+		}
+		cb.failures++
+		if cb.state == circuitClosed && cb.failures >= cb.threshold {
+			cb.open()
+		}
+	case err != nil && !errors.Is(err, gateway_interface.ErrResponseCorrupt):
+		// 呼び出し元のキャンセルなど、リンクの状態が分からない失敗は数えない（半開なら次のリクエストで試し直す）
+	default:
+		// レスポンスが届いた（読めなくてもリンクは使えている）
+		cb.failures = 0
+		if cb.state != circuitClosed {
+			log.Printf("[CircuitBreaker] リンクが回復したため閉じます")
+			cb.setState(circuitClosed)
+		}
+	}
+}
+
+// open ブレーカーを開き、クールダウンを始める
+func (cb *CircuitBreaker) open() {
+	cb.openedAt = cb.now()
+	cb.failures = 0
+	log.Printf("[CircuitBreaker] 失敗が続いたため%vの間転送を止めます (state: %s -> %s): %v", cb.coolDown, cb.state, circuitOpen, cb.lastErr)
+	cb.setState(circuitOpen)
+}
+
+func (cb *CircuitBreaker) setState(state string) {
+	if cb.state == state {
+		return
+	}
+	if state == circuitHalfOpen {
+		log.Printf("[CircuitBreaker] クールダウンが終わったため、1つのリクエストでリンクを確認します")
+	}
+	cb.state = state
+	cb.metrics.SetCircuitState(state, true)
+}
+
+// openError 転送を止めている間に返すエラー（再び試す時刻と開いた原因を含める）
+func (cb *CircuitBreaker) openError() error {
+	return fmt.Errorf("%w (retry after %s, last error: %v)", gateway_interface.ErrCircuitOpen, cb.openedAt.Add(cb.coolDown).Format(time.RFC3339), cb.lastErr)
+}
+
+// isLinkFailure リンクが使えないことを示す失敗か（リンク断・送信失敗・タイムアウト）
+func isLinkFailure(err error) bool {
+	return errors.Is(err, gateway_interface.ErrLinkDown) ||
+		errors.Is(err, gateway_interface.ErrSendFailed) ||
+		errors.Is(err, gateway_interface.ErrTimeout)
+}
+
+// State 現在の状態（closed、open、half_open）
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// HealthCheck 開いている間はErrCircuitOpenを返し、それ以外は包んだゲートウェイで確認する
+func (cb *CircuitBreaker) HealthCheck(ctx context.Context) error {
+	cb.mu.Lock()
+	if cb.state == circuitOpen {
+		err := cb.openError()
+		cb.mu.Unlock()
+		return err
+	}
+	cb.mu.Unlock()
+
+	if checker, ok := cb.gw.(gateway_interface.HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	return nil
+}
+
+func (cb *CircuitBreaker) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
+	return cb.gw.GetUnsolicitedResponseCh()
+}
+
+// Close 包んだゲートウェイを閉じる（閉じる必要のないゲートウェイでは何もしない）
+func (cb *CircuitBreaker) Close() error {
+	if closer, ok := cb.gw.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// circuit_breaker_test.go - 失敗が続いたゲートウェイへの転送を止めて、クールダウンの後に1つのリクエストで試し直すことのテスト
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// failingGateway 決めた順にエラーを返す（nilは200のレスポンス）ゲートウェイ。使い切った後は成功する
+// releaseを設定すると、閉じられるまでレスポンスを返さない
+type failingGateway struct {
+	gateway_interface.BpGateway
+
+	mu      sync.Mutex
+	script  []error
+	calls   int
+	release chan struct{}
+}
+
+func (g *failingGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (*model.BpResponse, error) {
+	g.mu.Lock()
+	g.calls++
+	var err error
+	if len(g.script) > 0 {
+		err, g.script = g.script[0], g.script[1:]
+	}
+	release := g.release
+	g.mu.Unlock()
+
+	if release != nil {
+		<-release
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.BpResponse{StatusCode: http.StatusOK, Headers: map[string][]string{}}, nil
+}
+
+func (g *failingGateway) Calls() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+// breakerClock テストで進める時計
+type breakerClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *breakerClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *breakerClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestBreaker(gw gateway_interface.BpGateway, threshold int, m *metrics.Metrics) (*CircuitBreaker, *breakerClock) {
+	clock := &breakerClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	cb := NewCircuitBreaker(gw, threshold, time.Minute, m)
+	cb.now = clock.Now
+	return cb, clock
+}
+
+func proxy(cb *CircuitBreaker) error {
+	_, err := cb.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/"}, gateway_interface.ProxyOptions{})
+	return err
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	timeout := fmt.Errorf("%w: no response", gateway_interface.ErrTimeout)
+	sendFailed := fmt.Errorf("%w: bpsendfile failed", gateway_interface.ErrSendFailed)
+	linkDown := fmt.Errorf("%w: receiver stopped", gateway_interface.ErrLinkDown)
+	// 成功すると数え直すため、4回目の失敗で初めて3回続く
+	gw := &failingGateway{script: []error{timeout, sendFailed, nil, timeout, linkDown, timeout}}
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, clock := newTestBreaker(gw, 3, m)
+
+	for i := 0; i < 6; i++ {
+		proxy(cb)
+		if want := map[bool]string{true: circuitOpen, false: circuitClosed}[i == 5]; cb.State() != want {
+			t.Fatalf("Expected %s after call %d, got %s", want, i+1, cb.State())
+		}
+	}
+
+	// 開いている間は転送せずにすぐ失敗する
+	err = proxy(cb)
+	if !errors.Is(err, gateway_interface.ErrCircuitOpen) || !errors.Is(err, gateway_interface.ErrLinkDown) {
+		t.Errorf("Expected ErrCircuitOpen wrapping ErrLinkDown, got %v", err)
+	}
+	if gw.Calls() != 6 {
+		t.Errorf("Expected no call while open, got %d calls", gw.Calls())
+	}
+	if err := cb.HealthCheck(context.Background()); !errors.Is(err, gateway_interface.ErrCircuitOpen) {
+		t.Errorf("Expected the health check to report the open breaker, got %v", err)
+	}
+
+	// クールダウンの途中ではまだ試さない
+	clock.Advance(30 * time.Second)
+	if err := proxy(cb); !errors.Is(err, gateway_interface.ErrCircuitOpen) {
+		t.Errorf("Expected the breaker to stay open during the cool-down, got %v", err)
+	}
+
+	// クールダウンの後の試しのリクエストが成功すると閉じる
+	clock.Advance(30 * time.Second)
+	if err := proxy(cb); err != nil {
+		t.Fatalf("Expected the probe to be forwarded, got %v", err)
+	}
+	if cb.State() != circuitClosed {
+		t.Errorf("Expected closed after a successful probe, got %s", cb.State())
+	}
+	if err := cb.HealthCheck(context.Background()); err != nil {
+		t.Errorf("Expected a healthy gateway after closing, got %v", err)
+	}
+
+	if counts := circuitTransitions(t, reg); counts[circuitOpen] != 1 || counts[circuitHalfOpen] != 1 || counts[circuitClosed] != 1 {
+		t.Errorf("Expected one transition to each state, got %v", counts)
+	}
+}
+
+// circuitTransitions 移った状態ごとのgateway_circuit_transitions_total
+func circuitTransitions(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "bp_proxy_gateway_circuit_transitions_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			counts[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	timeout := fmt.Errorf("%w: no response", gateway_interface.ErrTimeout)
+	gw := &failingGateway{script: []error{timeout, timeout}}
+	cb, clock := newTestBreaker(gw, 1, nil)
+
+	proxy(cb)
+	if cb.State() != circuitOpen {
+		t.Fatalf("Expected open after the first failure, got %s", cb.State())
+	}
+
+	// 試しのリクエストが失敗すると、クールダウンをやり直す
+	clock.Advance(time.Minute)
+	if err := proxy(cb); !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Fatalf("Expected the probe to be forwarded and fail, got %v", err)
+	}
+	if cb.State() != circuitOpen {
+		t.Fatalf("Expected open after a failed probe, got %s", cb.State())
+	}
+	if err := proxy(cb); !errors.Is(err, gateway_interface.ErrCircuitOpen) {
+		t.Errorf("Expected a new cool-down after the failed probe, got %v", err)
+	}
+
+	// 試しのリクエストを転送している間は、他のリクエストを転送しない
+	clock.Advance(time.Minute)
+	gw.mu.Lock()
+	gw.release = make(chan struct{})
+	gw.mu.Unlock()
+	probeDone := make(chan error, 1)
+	go func() { probeDone <- proxy(cb) }()
+	for gw.Calls() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if cb.State() != circuitHalfOpen {
+		t.Errorf("Expected half_open during the probe, got %s", cb.State())
+	}
+	if err := proxy(cb); !errors.Is(err, gateway_interface.ErrCircuitOpen) {
+		t.Errorf("Expected other requests to fail fast during the probe, got %v", err)
+	}
+	close(gw.release)
+	if err := <-probeDone; err != nil {
+		t.Fatalf("Expected the probe to succeed, got %v", err)
+	}
+	if cb.State() != circuitClosed || gw.Calls() != 3 {
+		t.Errorf("Expected closed after 3 calls, got %s after %d calls", cb.State(), gw.Calls())
+	}
+}
+
+func TestCircuitBreakerFailureKinds(t *testing.T) {
+	cancelled := fmt.Errorf("request cancelled: %w", context.Canceled)
+	corrupt := fmt.Errorf("%w: bad base64", gateway_interface.ErrResponseCorrupt)
+	timeout := fmt.Errorf("%w: no response", gateway_interface.ErrTimeout)
+	tests := []struct {
+		name   string
+		script []error
+		want   string
+	}{
+		// 呼び出し元のキャンセルは数えず、続いた失敗の回数も数え直さない
+		{"cancellation is ignored", []error{timeout, cancelled, timeout}, circuitOpen},
+		// 読めないレスポンスでも届いたならリンクは使えている
+		{"corrupt response resets", []error{timeout, corrupt, timeout}, circuitClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb, _ := newTestBreaker(&failingGateway{script: tt.script}, 2, nil)
+			for range tt.script {
+				proxy(cb)
+			}
+			if cb.State() != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, cb.State())
+			}
+		})
+	}
+}
+
+func TestNewGatewayCircuitBreaker(t *testing.T) {
+	g, err := NewGateway(Config{TransportMode: "mock", BreakerThreshold: 3, BreakerCoolDown: time.Minute}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, ok := g.(*CircuitBreaker)
+	if !ok {
+		t.Fatalf("Expected the gateway to be wrapped in a circuit breaker, got %T", g)
+	}
+	if _, ok := cb.gw.(*MockGateway); !ok || cb.threshold != 3 || cb.coolDown != time.Minute {
+		t.Errorf("Unexpected breaker: %T %d %v", cb.gw, cb.threshold, cb.coolDown)
+	}
+
+	if g, _ := NewGateway(Config{TransportMode: "mock"}, nil); fmt.Sprintf("%T", g) != "*gateway.MockGateway" {
+		t.Errorf("Expected no breaker without a threshold, got %T", g)
+	}
+}
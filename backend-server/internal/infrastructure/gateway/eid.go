@@ -0,0 +1,111 @@
+// eid.go - ゲートウェイがバンドルを送受信するエンドポイントID（EID）
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+)
+
+// EIDs バックエンドのゲートウェイが使うエンドポイントID（ipn:<node>.<service>）
+type EIDs struct {
+	Source      string // バンドルを送るEID（bp_socketではレスポンスもこのEIDで受信する）
+	Destination string // 地上局（earth）のEID
+	Receive     string // ion_cliでbprecvfileがレスポンスを受信するEID（bp_socketでは使わない）
+
+	// Lanes 優先度ごとにバンドルを送るEID（レーン）。ない優先度はSourceから送る
+	// 地上局はrequest_idでレスポンスを返すため、レーンは送信にだけ使い、レスポンスはSource（ion_cliではReceive）で受信する
+	Lanes map[gateway_interface.Priority]string
+}
+
+// SourceFor priorityのリクエストを送るEID
+func (e EIDs) SourceFor(priority gateway_interface.Priority) string {
+	if lane, ok := e.Lanes[priority]; ok {
+		return lane
+	}
+	return e.Source
+}
+
+// PriorityLanes 優先度の名前（high、low）ごとのサービス番号から、sourceと同じノードのレーンのEIDを作る
+func PriorityLanes(source string, services map[string]int) (map[gateway_interface.Priority]string, error) {
+	if len(services) == 0 {
+		return nil, nil
+	}
+	addr, err := bpsocket.ParseEID(source)
+	if err != nil {
+		return nil, fmt.Errorf("source EID: %w", err)
+	}
+	lanes := make(map[gateway_interface.Priority]string, len(services))
+	for name, svc := range services {
+		priority, err := gateway_interface.ParsePriority(name)
+		if err != nil {
+			return nil, fmt.Errorf("priority lane: %w", err)
+		}
+		if svc <= 0 {
+			return nil, fmt.Errorf("%w: priority lane %s has bad service number %d", bpsocket.ErrInvalidEID, name, svc)
+		}
+		lanes[priority] = "ipn:" + strconv.FormatUint(uint64(addr.NodeNum), 10) + "." + strconv.Itoa(svc)
+	}
+	return lanes, nil
+}
+
+// DefaultEIDs 設定で指定しない場合のEID
+var DefaultEIDs = EIDs{Source: "ipn:149.1", Destination: "ipn:150.1", Receive: "ipn:149.2"}
+
+// Validate EIDの形式と、transportModeで使うEIDが重なっていないかを確かめる
+// 地上局と同じEIDから送ったり受信したりするとバンドルが自分に戻るため、送信元・受信と送信先は別のEIDにする
+// ion_cliはbpsendfileとbprecvfileが別のプロセスでEIDを使うため、送信元と受信も別のEIDにする
+func (e EIDs) Validate(transportMode string) error {
+	for _, eid := range []struct{ name, value string }{
+		{"source", e.Source},
+		{"destination", e.Destination},
+		{"receive", e.Receive},
+	} {
+		if eid.name == "receive" && transportMode != transportIonCLI && eid.value == "" {
+			continue
+		}
+		if _, err := bpsocket.ParseEID(eid.value); err != nil {
+			return fmt.Errorf("%s EID: %w", eid.name, err)
+		}
+	}
+
+	if e.Source == e.Destination {
+		return fmt.Errorf("%w: source and destination are both %s", bpsocket.ErrInvalidEID, e.Source)
+	}
+	if transportMode == transportIonCLI {
+		if e.Receive == e.Destination {
+			return fmt.Errorf("%w: receive and destination are both %s", bpsocket.ErrInvalidEID, e.Receive)
+		}
+		if e.Source == e.Receive {
+			return fmt.Errorf("%w: source and receive are both %s (bpsendfile and bprecvfile cannot share an endpoint)", bpsocket.ErrInvalidEID, e.Source)
+		}
+	}
+	return e.validateLanes(transportMode)
+}
+
+// validateLanes レーンのEIDが地上局・受信・他のレーンと重なっていないかを確かめる（Sourceと同じレーンは別に開かないため許す）
+func (e EIDs) validateLanes(transportMode string) error {
+	seen := make(map[string]gateway_interface.Priority, len(e.Lanes))
+	for _, priority := range gateway_interface.Priorities {
+		lane, ok := e.Lanes[priority]
+		if !ok {
+			continue
+		}
+		if _, err := bpsocket.ParseEID(lane); err != nil {
+			return fmt.Errorf("%s priority lane EID: %w", priority, err)
+		}
+		if lane == e.Destination {
+			return fmt.Errorf("%w: %s priority lane and destination are both %s", bpsocket.ErrInvalidEID, priority, lane)
+		}
+		if transportMode == transportIonCLI && lane == e.Receive {
+			return fmt.Errorf("%w: %s priority lane and receive are both %s", bpsocket.ErrInvalidEID, priority, lane)
+		}
+		if other, ok := seen[lane]; ok && lane != e.Source {
+			return fmt.Errorf("%w: %s and %s priority lanes are both %s", bpsocket.ErrInvalidEID, other, priority, lane)
+		}
+		seen[lane] = priority
+	}
+	return nil
+}
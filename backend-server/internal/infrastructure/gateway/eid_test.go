@@ -0,0 +1,115 @@
+// eid_test.go - ゲートウェイのEIDの形式と重なりの確認のテスト
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+)
+
+func TestEIDsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		eids    EIDs
+		wantErr string // 空の場合はエラーなし
+	}{
+		{name: "defaults for ion_cli", mode: transportIonCLI, eids: DefaultEIDs},
+		{name: "defaults for bp_socket", mode: transportBpSocket, eids: DefaultEIDs},
+		{name: "second backend", mode: transportIonCLI, eids: EIDs{Source: "ipn:151.1", Destination: "ipn:150.1", Receive: "ipn:151.2"}},
+		// bp_socketは送信元のEIDで受信するため、受信のEIDは使わない
+		{name: "bp_socket without a receive EID", mode: transportBpSocket, eids: EIDs{Source: "ipn:149.1", Destination: "ipn:150.1"}},
+		{name: "bp_socket receiving at the source", mode: transportBpSocket, eids: EIDs{Source: "ipn:149.1", Destination: "ipn:150.1", Receive: "ipn:149.1"}},
+		{name: "missing source", mode: transportBpSocket, eids: EIDs{Destination: "ipn:150.1"}, wantErr: "source EID"},
+		{name: "ion_cli without a receive EID", mode: transportIonCLI, eids: EIDs{Source: "ipn:149.1", Destination: "ipn:150.1"}, wantErr: "receive EID"},
+		{name: "dtn scheme", mode: transportIonCLI, eids: EIDs{Source: "dtn://backend/proxy", Destination: "ipn:150.1", Receive: "ipn:149.2"}, wantErr: "unsupported scheme"},
+		{name: "missing service", mode: transportIonCLI, eids: EIDs{Source: "ipn:149", Destination: "ipn:150.1", Receive: "ipn:149.2"}, wantErr: "expected ipn:<node>.<service>"},
+		{name: "node zero", mode: transportIonCLI, eids: EIDs{Source: "ipn:0.1", Destination: "ipn:150.1", Receive: "ipn:149.2"}, wantErr: "bad node number"},
+		{name: "source is the earth station", mode: transportBpSocket, eids: EIDs{Source: "ipn:150.1", Destination: "ipn:150.1"}, wantErr: "source and destination"},
+		{name: "receive is the earth station", mode: transportIonCLI, eids: EIDs{Source: "ipn:149.1", Destination: "ipn:150.1", Receive: "ipn:150.1"}, wantErr: "receive and destination"},
+		{name: "ion_cli send and receive collide", mode: transportIonCLI, eids: EIDs{Source: "ipn:149.1", Destination: "ipn:150.1", Receive: "ipn:149.1"}, wantErr: "source and receive"},
+		{name: "low priority lane", mode: transportIonCLI, eids: withLowLane(DefaultEIDs, "ipn:149.3")},
+		// sourceと同じレーンは、レーンを分けずにsourceから送る
+		{name: "lane at the source", mode: transportBpSocket, eids: withLowLane(DefaultEIDs, "ipn:149.1")},
+		{name: "bad lane", mode: transportBpSocket, eids: withLowLane(DefaultEIDs, "ipn:149"), wantErr: "low priority lane EID"},
+		{name: "lane is the earth station", mode: transportBpSocket, eids: withLowLane(DefaultEIDs, "ipn:150.1"), wantErr: "low priority lane and destination"},
+		{name: "ion_cli lane and receive collide", mode: transportIonCLI, eids: withLowLane(DefaultEIDs, "ipn:149.2"), wantErr: "low priority lane and receive"},
+		{name: "lanes collide", mode: transportBpSocket, eids: EIDs{Source: "ipn:149.1", Destination: "ipn:150.1", Lanes: map[gateway_interface.Priority]string{gateway_interface.PriorityHigh: "ipn:149.3", gateway_interface.PriorityLow: "ipn:149.3"}}, wantErr: "high and low priority lanes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.eids.Validate(tt.mode)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected valid EIDs, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, bpsocket.ErrInvalidEID) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected ErrInvalidEID containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// withLowLane eidsに、優先度lowのリクエストを送るレーンを付ける
+func withLowLane(eids EIDs, lane string) EIDs {
+	eids.Lanes = map[gateway_interface.Priority]string{gateway_interface.PriorityLow: lane}
+	return eids
+}
+
+func TestPriorityLanes(t *testing.T) {
+	lanes, err := PriorityLanes("ipn:149.1", map[string]int{"low": 3, "high": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eids := DefaultEIDs
+	eids.Lanes = lanes
+	if got := eids.SourceFor(gateway_interface.PriorityLow); got != "ipn:149.3" {
+		t.Errorf("Expected the low priority lane at ipn:149.3, got %s", got)
+	}
+	// ProxyOptionsのゼロ値はhighのレーンで送る
+	if got := eids.SourceFor(gateway_interface.ProxyOptions{}.Priority); got != "ipn:149.4" {
+		t.Errorf("Expected the default options to use the high priority lane ipn:149.4, got %s", got)
+	}
+
+	// レーンのない優先度はsourceから送る
+	if got := DefaultEIDs.SourceFor(gateway_interface.PriorityLow); got != DefaultEIDs.Source {
+		t.Errorf("Expected the source without lanes, got %s", got)
+	}
+	if lanes, err := PriorityLanes("ipn:149.1", nil); lanes != nil || err != nil {
+		t.Errorf("Expected no lanes, got %v %v", lanes, err)
+	}
+
+	for _, tt := range []struct {
+		source   string
+		services map[string]int
+		wantErr  string
+	}{
+		{"ipn:149.1", map[string]int{"urgent": 3}, `unknown priority "urgent"`},
+		{"ipn:149.1", map[string]int{"low": 0}, "bad service number 0"},
+		{"ipn:149", map[string]int{"low": 3}, "source EID"},
+	} {
+		if _, err := PriorityLanes(tt.source, tt.services); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s %v: expected an error containing %q, got %v", tt.source, tt.services, tt.wantErr, err)
+		}
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range gateway_interface.Priorities {
+		if got, err := gateway_interface.ParsePriority(p.String()); got != p || err != nil {
+			t.Errorf("Expected %s to round-trip, got %v %v", p, got, err)
+		}
+	}
+	if gateway_interface.PriorityHigh != (gateway_interface.ProxyOptions{}).Priority {
+		t.Error("Expected the zero ProxyOptions to be high priority")
+	}
+	if got := fmt.Sprint(gateway_interface.Priority(7)); got != "priority(7)" {
+		t.Errorf("Unexpected name for an unknown priority: %s", got)
+	}
+}
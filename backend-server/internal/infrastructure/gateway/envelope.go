@@ -0,0 +1,219 @@
+// envelope.go - 地上局（earth）のbpsocketがレスポンスに付けるエンベロープの解釈、MACの検証と復号
+// 形式はearth/bpsocket/envelope.go・encryption.goと同じ（/testdata/dtn/response_signed.bin・response_encrypted.binで両方のテストが確認する）
+// 先頭が0xB5以外のバンドルはエンベロープのない従来のJSONとして扱う
+package gateway
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	envelopeMagic            = 0xB5
+	envelopeVersion          = 1 // 平文のペイロード
+	envelopeVersionEncrypted = 2 // AES-GCMで暗号化されたペイロード
+	envelopeHeaderSize       = 4
+	envelopeSeqSize          = 8
+	envelopeNonceSize        = 12
+	envelopeMACSize          = 32
+)
+
+// エンベロープの種別（earthと同じ値）
+const (
+	envelopeTypeData  byte = 0
+	envelopeTypeAck   byte = 1
+	envelopeTypeChunk byte = 2
+	envelopeTypePing  byte = 3
+	envelopeTypePong  byte = 4
+)
+
+// エンベロープのフラグ（earthと同じ値）
+const (
+	flagGzip    byte = 1 << 0
+	flagDeflate byte = 1 << 1
+	flagSeq     byte = 1 << 2
+	flagMAC     byte = 1 << 4
+)
+
+var (
+	// errControlBundle 地上局のキープアライブなどの制御バンドルで、レスポンスではない
+	errControlBundle = errors.New("control bundle")
+	// errMACMissing MACの検証が必須だが、MACのないバンドルを受け取った
+	errMACMissing = errors.New("bundle has no MAC")
+	// errMACMismatch どの鍵でもMACを検証できない（鍵の不一致または改ざん）
+	errMACMismatch = errors.New("bundle MAC mismatch")
+	// errNoDecryptionKey 暗号化されたバンドルを受け取ったが、復号の鍵を設定していない
+	errNoDecryptionKey = errors.New("encrypted bundle received but no decryption key is configured")
+	// errDecryptFailed どの鍵でも復号できない（鍵の不一致または改ざん）
+	errDecryptFailed = errors.New("failed to decrypt bundle")
+)
+
+// EnvelopeAuth 地上局から届くバンドルの検証と復号の設定（earthのauth.hmac_key・encryption_keysと同じ鍵を設定する）
+type EnvelopeAuth struct {
+	// VerifyKeys MACの検証に使う鍵（鍵のローテーション中は新旧両方、空の場合は検証しない）
+	// MACが付いていて検証できないバンドルは、RequireMACに関係なく捨てる
+	VerifyKeys [][]byte
+	// RequireMAC MACのないバンドル（従来のJSONを含む）も捨てる（地上局がすべて署名するようになってから有効にする）
+	RequireMAC bool
+	// DecryptionKeys AES-GCMで暗号化されたバンドルを復号するAES-256の鍵（登録順に試す、鍵のローテーション中は新旧両方）
+	// 平文のバンドルはそのまま受け付けるため、地上局が暗号化を有効にする前から設定しておける
+	DecryptionKeys [][]byte
+}
+
+// envelope デコード済みのエンベロープ
+type envelope struct {
+	Type    byte
+	Flags   byte
+	Seq     uint64
+	Nonce   []byte // version 2のみ
+	MAC     []byte // Flags&flagMACが立っている場合のみ
+	Payload []byte
+}
+
+// signedHeader MACの対象となるヘッダー（macフィールドを除く）
+func (e *envelope) signedHeader() []byte {
+	version := byte(envelopeVersion)
+	if e.Nonce != nil {
+		version = envelopeVersionEncrypted
+	}
+	header := []byte{envelopeMagic, version, e.Type, e.Flags}
+	if e.Flags&flagSeq != 0 {
+		header = binary.BigEndian.AppendUint64(header, e.Seq)
+	}
+	return append(header, e.Nonce...)
+}
+
+// decodeEnvelope バイト列からエンベロープを復元する（返すPayloadはdataを参照する）
+func decodeEnvelope(data []byte) (*envelope, error) {
+	if len(data) < envelopeHeaderSize {
+		return nil, fmt.Errorf("envelope too short: %d bytes", len(data))
+	}
+	version := data[1]
+	if version != envelopeVersion && version != envelopeVersionEncrypted {
+		return nil, fmt.Errorf("unsupported envelope version: %d", version)
+	}
+	e := &envelope{Type: data[2], Flags: data[3]}
+	offset := envelopeHeaderSize
+	if e.Flags&flagSeq != 0 {
+		if len(data) < offset+envelopeSeqSize {
+			return nil, fmt.Errorf("envelope too short for sequence number: %d bytes", len(data))
+		}
+		e.Seq = binary.BigEndian.Uint64(data[offset:])
+		offset += envelopeSeqSize
+	}
+	if version == envelopeVersionEncrypted {
+		if len(data) < offset+envelopeNonceSize {
+			return nil, fmt.Errorf("envelope too short for nonce: %d bytes", len(data))
+		}
+		e.Nonce = data[offset : offset+envelopeNonceSize]
+		offset += envelopeNonceSize
+	}
+	if e.Flags&flagMAC != 0 {
+		if len(data) < offset+envelopeMACSize {
+			return nil, fmt.Errorf("envelope too short for MAC: %d bytes", len(data))
+		}
+		e.MAC = data[offset : offset+envelopeMACSize]
+		offset += envelopeMACSize
+	}
+	e.Payload = data[offset:]
+	return e, nil
+}
+
+// envelopeOpener 受信したバンドルからレスポンスのJSONを取り出す
+type envelopeOpener struct {
+	auth  EnvelopeAuth
+	aeads []cipher.AEAD
+}
+
+// newEnvelopeOpener authの鍵でバンドルを検証・復号する（AES-256の鍵でない復号の鍵はエラー）
+func newEnvelopeOpener(auth EnvelopeAuth) (*envelopeOpener, error) {
+	o := &envelopeOpener{auth: auth}
+	for i, key := range auth.DecryptionKeys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("decryption key %d: AES-256 requires a 32-byte key, got %d bytes", i, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("decryption key %d: %w", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("decryption key %d: %w", i, err)
+		}
+		o.aeads = append(o.aeads, aead)
+	}
+	return o, nil
+}
+
+// decrypt 暗号化されたペイロードを復号する（鍵のローテーション中は登録順に試す）
+func (o *envelopeOpener) decrypt(e *envelope) ([]byte, error) {
+	if len(o.aeads) == 0 {
+		return nil, errNoDecryptionKey
+	}
+	for _, aead := range o.aeads {
+		if plaintext, err := aead.Open(nil, e.Nonce, e.Payload, e.signedHeader()); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, errDecryptFailed
+}
+
+// verify エンベロープのMACをいずれかの鍵で検証する
+func (o *envelopeOpener) verify(e *envelope) error {
+	if e.MAC == nil {
+		if o.auth.RequireMAC {
+			return errMACMissing
+		}
+		return nil
+	}
+	if len(o.auth.VerifyKeys) == 0 {
+		return nil
+	}
+	for _, key := range o.auth.VerifyKeys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(e.signedHeader())
+		mac.Write(e.Payload)
+		if hmac.Equal(mac.Sum(nil), e.MAC) {
+			return nil
+		}
+	}
+	return errMACMismatch
+}
+
+// open バンドルを検証し、レスポンスのJSONを返す
+// 制御バンドル（ACK・ping・pong）はerrControlBundle、検証できないバンドルや対応していない形式はエラーを返す
+func (o *envelopeOpener) open(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != envelopeMagic {
+		if o.auth.RequireMAC {
+			return nil, errMACMissing
+		}
+		return data, nil
+	}
+	e, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.verify(e); err != nil {
+		return nil, err
+	}
+	switch e.Type {
+	case envelopeTypeData:
+	case envelopeTypeAck, envelopeTypePing, envelopeTypePong:
+		return nil, errControlBundle
+	default:
+		return nil, fmt.Errorf("unsupported envelope type: %d", e.Type)
+	}
+	if e.Flags&(flagGzip|flagDeflate) != 0 {
+		return nil, errors.New("compressed bundles are not supported")
+	}
+	if e.Nonce != nil {
+		// MACは暗号文に対して付けるため、検証してから復号する
+		return o.decrypt(e)
+	}
+	return e.Payload, nil
+}
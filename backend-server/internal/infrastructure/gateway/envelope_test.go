@@ -0,0 +1,201 @@
+// envelope_test.go - 地上局のエンベロープの解釈、MACの検証と復号のテスト
+// testdata/dtn/response_signed.bin・response_encrypted.binは地上局のbpsocketがresponse.jsonに署名・暗号化したバンドル（earth側のテストでも読む）
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// contractHMACKey response_signed.binの署名に使った鍵
+const contractHMACKey = "dtn-shared-hmac-key"
+
+// contractEncryptionKey response_encrypted.binの暗号化に使ったAES-256の鍵
+var contractEncryptionKey, _ = hex.DecodeString("1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100")
+
+// nextEncryptionKey 鍵のローテーションで追加した新しい鍵
+var nextEncryptionKey = bytes.Repeat([]byte{0x42}, 32)
+
+func mustOpener(t *testing.T, auth EnvelopeAuth) *envelopeOpener {
+	t.Helper()
+	o, err := newEnvelopeOpener(auth)
+	if err != nil {
+		t.Fatalf("newEnvelopeOpener failed: %v", err)
+	}
+	return o
+}
+
+// tamper バンドルの最後のバイトを書き換えたコピー（ペイロードの改ざん）
+func tamper(data []byte) []byte {
+	forged := bytes.Clone(data)
+	forged[len(forged)-1] ^= 0xFF
+	return forged
+}
+
+func TestEnvelopeOpenerSignedContract(t *testing.T) {
+	signed := contractFile(t, "response_signed.bin")
+	want := contractFile(t, "response.json")
+
+	tests := []struct {
+		name    string
+		auth    EnvelopeAuth
+		data    []byte
+		wantErr error
+	}{
+		{"verified", EnvelopeAuth{VerifyKeys: [][]byte{[]byte(contractHMACKey)}, RequireMAC: true}, signed, nil},
+		{"rotation", EnvelopeAuth{VerifyKeys: [][]byte{[]byte("next-key"), []byte(contractHMACKey)}}, signed, nil},
+		{"no keys", EnvelopeAuth{}, signed, nil},
+		{"wrong key", EnvelopeAuth{VerifyKeys: [][]byte{[]byte("other-key")}}, signed, errMACMismatch},
+		{"tampered", EnvelopeAuth{VerifyKeys: [][]byte{[]byte(contractHMACKey)}}, tamper(signed), errMACMismatch},
+		{"legacy", EnvelopeAuth{VerifyKeys: [][]byte{[]byte(contractHMACKey)}}, want, nil},
+		{"legacy required", EnvelopeAuth{VerifyKeys: [][]byte{[]byte(contractHMACKey)}, RequireMAC: true}, want, errMACMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := mustOpener(t, tt.auth).open(tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open failed: %v", err)
+			}
+			if !bytes.Equal(payload, want) {
+				t.Errorf("Expected the payload of testdata/dtn/response.json, got %q", payload)
+			}
+		})
+	}
+}
+
+func TestEnvelopeOpenerEncryptedContract(t *testing.T) {
+	encrypted := contractFile(t, "response_encrypted.bin")
+	want := contractFile(t, "response.json")
+
+	tests := []struct {
+		name    string
+		keys    [][]byte
+		data    []byte
+		wantErr error
+	}{
+		{"decrypted", [][]byte{contractEncryptionKey}, encrypted, nil},
+		{"rotation", [][]byte{nextEncryptionKey, contractEncryptionKey}, encrypted, nil},
+		{"no keys", nil, encrypted, errNoDecryptionKey},
+		{"wrong key", [][]byte{nextEncryptionKey}, encrypted, errDecryptFailed},
+		{"tampered", [][]byte{contractEncryptionKey}, tamper(encrypted), errDecryptFailed},
+		{"plaintext", [][]byte{contractEncryptionKey}, want, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := mustOpener(t, EnvelopeAuth{DecryptionKeys: tt.keys}).open(tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open failed: %v", err)
+			}
+			if !bytes.Equal(payload, want) {
+				t.Errorf("Expected the payload of testdata/dtn/response.json, got %q", payload)
+			}
+		})
+	}
+}
+
+func TestNewEnvelopeOpenerRejectsShortKey(t *testing.T) {
+	if _, err := newEnvelopeOpener(EnvelopeAuth{DecryptionKeys: [][]byte{[]byte("too-short")}}); err == nil {
+		t.Error("Expected an error for a key that is not 32 bytes")
+	}
+}
+
+func TestEnvelopeOpenerRejectsUnsupported(t *testing.T) {
+	opener := mustOpener(t, EnvelopeAuth{})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", []byte{envelopeMagic, envelopeVersion}},
+		{"unknown version", []byte{envelopeMagic, 9, envelopeTypeData, 0}},
+		{"chunk", []byte{envelopeMagic, envelopeVersion, envelopeTypeChunk, 0, '{', '}'}},
+		{"compressed", []byte{envelopeMagic, envelopeVersion, envelopeTypeData, flagGzip, 0x1f, 0x8b}},
+		{"missing MAC", []byte{envelopeMagic, envelopeVersion, envelopeTypeData, flagMAC, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := opener.open(tt.data); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	// キープアライブのpingはレスポンスではない
+	if _, err := opener.open([]byte{envelopeMagic, envelopeVersion, envelopeTypePing, 0}); !errors.Is(err, errControlBundle) {
+		t.Errorf("Expected errControlBundle for a ping, got %v", err)
+	}
+}
+
+// TestBpSocketGatewayEnvelopeResponse 地上局が署名・暗号化したレスポンスを受信ループで検証・復号し、改ざんされたバンドルは捨てる
+func TestBpSocketGatewayEnvelopeResponse(t *testing.T) {
+	signed := contractFile(t, "response_signed.bin")
+	encrypted := contractFile(t, "response_encrypted.bin")
+	const reqID = "6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b"
+
+	tests := []struct {
+		name     string
+		auth     EnvelopeAuth
+		bundles  [][]byte
+		wantBody string
+		wantErr  error
+	}{
+		{"verified", EnvelopeAuth{VerifyKeys: [][]byte{[]byte(contractHMACKey)}, RequireMAC: true}, [][]byte{tamper(signed), signed}, "<h1>hi</h1>", nil},
+		{"wrong key", EnvelopeAuth{VerifyKeys: [][]byte{[]byte("other-key")}}, [][]byte{signed}, "", gateway_interface.ErrTimeout},
+		{"encrypted", EnvelopeAuth{DecryptionKeys: [][]byte{nextEncryptionKey, contractEncryptionKey}}, [][]byte{tamper(encrypted), encrypted}, "<h1>hi</h1>", nil},
+		{"no decryption key", EnvelopeAuth{}, [][]byte{encrypted}, "", gateway_interface.ErrTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLoopbackConn()
+			g := newBpSocketGateway(conn, 200*time.Millisecond, nil)
+			t.Cleanup(func() { g.Close() })
+			if err := g.SetEnvelopeAuth(tt.auth); err != nil {
+				t.Fatal(err)
+			}
+
+			// 地上局の代わりに、リクエストが届いたら用意したバンドルを順に返す
+			go func() {
+				select {
+				case <-conn.outbox:
+				case <-conn.closed:
+					return
+				}
+				for _, bundle := range tt.bundles {
+					conn.inbox <- bundle
+				}
+			}()
+
+			resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: "GET", URL: "https://example.com/page", RequestID: reqID}, gateway_interface.ProxyOptions{})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProxyRequest failed: %v", err)
+			}
+			if string(resp.Body) != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, resp.Body)
+			}
+		})
+	}
+}
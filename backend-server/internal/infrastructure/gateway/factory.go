@@ -0,0 +1,137 @@
+// factory.go - 設定のトランスポートモードからゲートウェイを作る
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// ErrUnknownTransport 設定のtransport_modeが対応していない値
+var ErrUnknownTransport = errors.New("unknown transport mode")
+
+// Config ゲートウェイの設定（設定ファイルのbp_gatewayから必要なものだけ）
+type Config struct {
+	TransportMode string        // "bp_socket"（別名"bpsocket"）、"ion_cli"（別名"bpfile"）、"local"、"mock"
+	Timeout       time.Duration // DTN経由のレスポンスを待つ時間（localでは転送先のHTTPのタイムアウト）
+
+	// Host・Port ion_cliモード時のホストとポート
+	Host string
+	Port int
+
+	// EIDs bp_socket・ion_cliモードで使う自分と地上局のエンドポイント（優先度ごとに送信元を分けるレーンを含む）
+	EIDs EIDs
+
+	// MockLatency mockモードでレスポンスを返すまでの時間
+	MockLatency time.Duration
+	// MockScenarios mockモードのURLごとの振る舞いを書いたJSONのファイル（空の場合はすべてのURLにURLを書いたHTMLを返す）
+	MockScenarios string
+
+	// Retry bp_socket・ion_cliモードで、バンドルの送信に一時的に失敗したときの送り直し方
+	Retry RetryPolicy
+
+	// BreakerThreshold・BreakerCoolDown 失敗がBreakerThreshold回続いたらBreakerCoolDownの間転送を止める（0以下はサーキットブレーカーを使わない）
+	BreakerThreshold int
+	BreakerCoolDown  time.Duration
+
+	// Batch bp_socketモードで、短い間に送るリクエストを1つのバンドルにまとめる設定
+	Batch BatchPolicy
+
+	// Auth bp_socketモードで、地上局から届くバンドルのMACの検証と復号の設定（地上局のauth.hmac_key・encryption_keysと同じ鍵）
+	Auth EnvelopeAuth
+
+	// MaxInFlight 同時に転送する（レスポンスを待つ）リクエストの数の上限（0以下は制限しない）
+	MaxInFlight int
+
+	// RoundTrips bp_socket・ion_cliモードで、レスポンスが届くまでの時間を記録して待つ時間を決める（nilは記録せずTimeoutだけ待つ）
+	// レスポンスが届く時刻の目安（model.DeliveryEstimate）にも同じものを渡す
+	RoundTrips *RoundTripTracker
+}
+
+// NewGateway conf.TransportModeのゲートウェイを作る
+// 対応していないモードや、モードに必要なもの（bp_socketのLinux、ion_cliのIONのコマンド、正しいEID）がない場合は、起動時に分かるようエラーを返す
+// MaxInFlightが1以上の場合は同時に転送する数を制限し、BreakerThresholdが1以上の場合はさらにサーキットブレーカーで包んで返す
+// （ブレーカーが開いている間は、枠の空きを待たずに失敗させる）
+func NewGateway(conf Config, metrics *metrics.Metrics) (gateway_interface.BpGateway, error) {
+	g, err := newGateway(conf, execRunner{}, metrics)
+	if err != nil {
+		return nil, err
+	}
+	if conf.MaxInFlight > 0 {
+		g = NewInFlightLimiter(g, conf.MaxInFlight, metrics)
+	}
+	if conf.BreakerThreshold <= 0 {
+		return g, nil
+	}
+	return NewCircuitBreaker(g, conf.BreakerThreshold, conf.BreakerCoolDown, metrics), nil
+}
+
+func newGateway(conf Config, runner commandRunner, metrics *metrics.Metrics) (gateway_interface.BpGateway, error) {
+	switch NormalizeTransportMode(conf.TransportMode) {
+	case transportBpSocket:
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("transport mode %s is only supported on Linux (current OS: %s)", transportBpSocket, runtime.GOOS)
+		}
+		if err := conf.EIDs.Validate(transportBpSocket); err != nil {
+			return nil, fmt.Errorf("transport mode %s: %w", transportBpSocket, err)
+		}
+		// Validateで形式を確かめているため、ここでは失敗しない
+		local, _ := bpsocket.ParseEID(conf.EIDs.Source)
+		remote, _ := bpsocket.ParseEID(conf.EIDs.Destination)
+		g, err := NewBpSocketGateway(uint64(local.NodeNum), uint64(local.SvcNum), uint64(remote.NodeNum), uint64(remote.SvcNum), conf.Timeout, metrics)
+		if err != nil {
+			// 失敗した場合に*BpSocketGatewayのnilをインターフェースとして返さない
+			return nil, err
+		}
+		g.SetRetryPolicy(conf.Retry)
+		g.SetRoundTripTracker(conf.RoundTrips)
+		g.SetBatchPolicy(conf.Batch)
+		if err := g.SetEnvelopeAuth(conf.Auth); err != nil {
+			g.Close()
+			return nil, fmt.Errorf("transport mode %s: %w", transportBpSocket, err)
+		}
+		for priority, lane := range conf.EIDs.Lanes {
+			if lane == conf.EIDs.Source {
+				continue
+			}
+			if err := g.OpenPriorityLane(priority, lane); err != nil {
+				g.Close()
+				return nil, err
+			}
+		}
+		return g, nil
+	case transportIonCLI:
+		if err := conf.EIDs.Validate(transportIonCLI); err != nil {
+			return nil, fmt.Errorf("transport mode %s: %w", transportIonCLI, err)
+		}
+		for _, name := range []string{"bpsendfile", "bprecvfile"} {
+			if err := runner.LookPath(name); err != nil {
+				return nil, fmt.Errorf("transport mode %s requires the ION command %s: %w", transportIonCLI, name, err)
+			}
+		}
+		g := openIonCLIGateway(runner, conf.EIDs, conf.Host, conf.Port, conf.Timeout, metrics)
+		g.SetRetryPolicy(conf.Retry)
+		g.SetRoundTripTracker(conf.RoundTrips)
+		return g, nil
+	case transportLocal:
+		return NewLocalGateway(conf.Timeout, metrics), nil
+	case transportMock:
+		g := NewMockGateway(conf.MockLatency, metrics)
+		if conf.MockScenarios != "" {
+			scenarios, err := LoadMockScenarios(conf.MockScenarios)
+			if err != nil {
+				return nil, fmt.Errorf("transport mode %s: %w", transportMock, err)
+			}
+			g.SetScenarios(scenarios)
+		}
+		return g, nil
+	default:
+		return nil, fmt.Errorf("%w: %q (use %s, %s, %s or %s)", ErrUnknownTransport, conf.TransportMode,
+			transportBpSocket, transportIonCLI, transportLocal, transportMock)
+	}
+}
@@ -0,0 +1,138 @@
+// factory_test.go - 設定のトランスポートモードからゲートウェイを作ることのテスト
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+)
+
+// missingCommandRunner IONのコマンドがインストールされていない環境
+type missingCommandRunner struct {
+	*scriptedRunner
+}
+
+func (missingCommandRunner) LookPath(name string) error { return exec.ErrNotFound }
+
+func TestNewGateway(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    Config
+		runner  commandRunner
+		want    string // 作られるゲートウェイの型
+		wantErr string // エラーメッセージに含まれる文字列
+	}{
+		{name: "local", conf: Config{TransportMode: "local", Timeout: time.Second}, want: "*gateway.LocalGateway"},
+		{name: "mock", conf: Config{TransportMode: "mock"}, want: "*gateway.MockGateway"},
+		{name: "mock with scenarios", conf: Config{TransportMode: "mock", MockScenarios: "testdata/mock_scenarios.json"}, want: "*gateway.MockGateway"},
+		{name: "mock with missing scenarios", conf: Config{TransportMode: "mock", MockScenarios: "testdata/missing.json"}, wantErr: "transport mode mock: failed to read mock scenarios"},
+		{name: "ion_cli", conf: Config{TransportMode: "ion_cli", EIDs: DefaultEIDs, Host: "localhost", Port: 8081, Timeout: time.Second}, runner: newScriptedRunner(), want: "*gateway.IonCLIGateway"},
+		{name: "ion_cli without ION", conf: Config{TransportMode: "ion_cli", EIDs: DefaultEIDs}, runner: missingCommandRunner{newScriptedRunner()}, wantErr: "requires the ION command bpsendfile"},
+		{name: "ion_cli without EIDs", conf: Config{TransportMode: "ion_cli"}, wantErr: "source EID"},
+		{name: "ion_cli with a malformed EID", conf: Config{TransportMode: "ion_cli", EIDs: EIDs{Source: "ipn:149.1", Destination: "ipn:150", Receive: "ipn:149.2"}}, wantErr: `destination EID: bpsocket: invalid EID "ipn:150"`},
+		{name: "ion_cli receiving at the source EID", conf: Config{TransportMode: "ion_cli", EIDs: EIDs{Source: "ipn:149.1", Destination: "ipn:150.1", Receive: "ipn:149.1"}}, wantErr: "source and receive are both ipn:149.1"},
+		{name: "bp_socket without endpoints", conf: Config{TransportMode: "bp_socket"}, wantErr: "bp_socket"},
+		// bpfile・bpsocketはion_cli・bp_socketの別名
+		{name: "bpfile alias", conf: Config{TransportMode: "bpfile", EIDs: DefaultEIDs, Timeout: time.Second}, runner: newScriptedRunner(), want: "*gateway.IonCLIGateway"},
+		{name: "bpsocket alias without endpoints", conf: Config{TransportMode: "bpsocket"}, wantErr: "transport mode bp_socket"},
+		{name: "unknown", conf: Config{TransportMode: "http"}, wantErr: `unknown transport mode: "http"`},
+		{name: "empty", conf: Config{}, wantErr: "unknown transport mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := tt.runner
+			if runner == nil {
+				runner = newScriptedRunner()
+			}
+			g, err := newGateway(tt.conf, runner, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				if g != nil {
+					t.Errorf("Expected no gateway on error, got %T", g)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newGateway failed: %v", err)
+			}
+			if closer, ok := g.(interface{ Close() error }); ok {
+				t.Cleanup(func() { closer.Close() })
+			}
+			if got := fmt.Sprintf("%T", g); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewGatewayUnknownTransport(t *testing.T) {
+	for _, mode := range []string{"udp", "BP_SOCKET", "bp-file"} {
+		if _, err := NewGateway(Config{TransportMode: mode}, nil); !errors.Is(err, ErrUnknownTransport) {
+			t.Errorf("%s: expected ErrUnknownTransport, got %v", mode, err)
+		}
+	}
+}
+
+func TestNewGatewayBpSocket(t *testing.T) {
+	g, err := NewGateway(Config{TransportMode: "bp_socket", EIDs: DefaultEIDs, Timeout: time.Second}, nil)
+	if runtime.GOOS != "linux" {
+		if err == nil || !strings.Contains(err.Error(), "only supported on Linux") {
+			t.Errorf("Expected bp_socket to be rejected on %s, got %v", runtime.GOOS, err)
+		}
+		return
+	}
+	if err != nil {
+		// bpカーネルモジュールがない環境では、ソケットを開けないことを起動時に返す
+		if g != nil {
+			t.Errorf("Expected no gateway on error, got %T", g)
+		}
+		t.Skipf("bp-socket is not available: %v", err)
+	}
+	g.(*BpSocketGateway).Close()
+}
+
+func TestMockGateway(t *testing.T) {
+	g := NewMockGateway(10*time.Millisecond, nil)
+	resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/<page>"}, gateway_interface.ProxyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(resp.Body), "https://example.com/&lt;page&gt;") {
+		t.Errorf("Unexpected mock response: %d %q", resp.StatusCode, resp.Body)
+	}
+
+	// 待っている間に呼び出し元がキャンセルした場合は戻る
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewMockGateway(time.Hour, nil).ProxyRequest(ctx, &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/"}, gateway_interface.ProxyOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation, got %v", err)
+	}
+}
+
+func TestNewGatewayBpSocketInvalidEID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("bp_socket is rejected before the EIDs on " + runtime.GOOS)
+	}
+	for _, eids := range []EIDs{
+		{Source: "dtn://backend/proxy", Destination: "ipn:150.1"},
+		{Source: "ipn:149.x", Destination: "ipn:150.1"},
+		{Source: "ipn:150.1", Destination: "ipn:150.1"},
+	} {
+		g, err := NewGateway(Config{TransportMode: "bp_socket", EIDs: eids, Timeout: time.Second}, nil)
+		if !errors.Is(err, bpsocket.ErrInvalidEID) || g != nil {
+			t.Errorf("%+v: expected ErrInvalidEID before opening the socket, got %v", eids, err)
+		}
+	}
+}
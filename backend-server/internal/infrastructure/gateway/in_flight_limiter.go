@@ -0,0 +1,135 @@
+// in_flight_limiter.go - DTNへ同時に送るリクエスト（レスポンスを待っているもの）の数を制限する
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// InFlightLimiter 包んだゲートウェイに同時に転送するリクエストをlimit件までにする
+// 上限に達している間は、空きができるか呼び出し元のctxが終わるまで待たせる（ctxが終わった場合はErrInFlightLimitを返し、転送しない）
+// ワーカーを増やしたときに、IONのSDRがあふれるほどのバンドルを一度に送らないようにする
+type InFlightLimiter struct {
+	gw      gateway_interface.BpGateway
+	slots   chan struct{}
+	metrics *metrics.Metrics
+
+	mu      sync.Mutex
+	waiting int // 空きを待っているリクエストの数
+}
+
+// InFlightStats 転送中と、空きを待っているリクエストの数
+type InFlightStats struct {
+	Limit    int `json:"limit"`
+	InFlight int `json:"in_flight"`
+	Waiting  int `json:"waiting"`
+}
+
+var (
+	_ gateway_interface.BpGateway     = (*InFlightLimiter)(nil)
+	_ gateway_interface.HealthChecker = (*InFlightLimiter)(nil)
+	_ gateway_interface.Prober        = (*InFlightLimiter)(nil)
+)
+
+// NewInFlightLimiter gwに同時に転送するリクエストをlimit件（1未満は1とみなす）までにする
+func NewInFlightLimiter(gw gateway_interface.BpGateway, limit int, metrics *metrics.Metrics) *InFlightLimiter {
+	l := &InFlightLimiter{
+		gw:      gw,
+		slots:   make(chan struct{}, max(limit, 1)),
+		metrics: metrics,
+	}
+	metrics.SetGatewayInFlight(0, 0)
+	return l
+}
+
+func (l *InFlightLimiter) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (*model.BpResponse, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.gw.ProxyRequest(ctx, breq, opts)
+}
+
+// acquire 転送の枠を1つ取る（空きがなければ、空きができるかctxが終わるまで待つ）
+func (l *InFlightLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	select {
+	case l.slots <- struct{}{}:
+		l.report()
+		l.mu.Unlock()
+		return nil
+	default:
+	}
+	l.waiting++
+	l.report()
+	l.mu.Unlock()
+
+	var err error
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		err = fmt.Errorf("%w (limit %d): %w", gateway_interface.ErrInFlightLimit, cap(l.slots), ctx.Err())
+	}
+
+	l.mu.Lock()
+	l.waiting--
+	l.report()
+	l.mu.Unlock()
+	return err
+}
+
+// release 転送の枠を返す
+func (l *InFlightLimiter) release() {
+	l.mu.Lock()
+	<-l.slots
+	l.report()
+	l.mu.Unlock()
+}
+
+// report 現在の数をメトリクスに記録する（l.muを持って呼ぶ）
+func (l *InFlightLimiter) report() {
+	l.metrics.SetGatewayInFlight(len(l.slots), l.waiting)
+}
+
+// Stats 上限と、転送中・空きを待っているリクエストの数
+func (l *InFlightLimiter) Stats() InFlightStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return InFlightStats{Limit: cap(l.slots), InFlight: len(l.slots), Waiting: l.waiting}
+}
+
+// Probe 包んだゲートウェイでプローブを送る
+// プローブは小さく、リクエストで枠が埋まっている間もリンクの状態を確かめられるよう、枠を使わない
+func (l *InFlightLimiter) Probe(ctx context.Context) (time.Duration, error) {
+	prober, ok := l.gw.(gateway_interface.Prober)
+	if !ok {
+		return 0, gateway_interface.ErrProbeUnsupported
+	}
+	return prober.Probe(ctx)
+}
+
+// HealthCheck 包んだゲートウェイで確認する（確認できないゲートウェイは正常として扱う）
+func (l *InFlightLimiter) HealthCheck(ctx context.Context) error {
+	if checker, ok := l.gw.(gateway_interface.HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	return nil
+}
+
+func (l *InFlightLimiter) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
+	return l.gw.GetUnsolicitedResponseCh()
+}
+
+// Close 包んだゲートウェイを閉じる（閉じる必要のないゲートウェイでは何もしない）
+func (l *InFlightLimiter) Close() error {
+	if closer, ok := l.gw.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// in_flight_limiter_test.go - 同時に転送するリクエストの数を制限することのテスト
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// slowGateway latencyだけ待ってからレスポンスを返し、同時に転送中だったリクエストの数の最大を記録するゲートウェイ
+type slowGateway struct {
+	gateway_interface.BpGateway
+	latency time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	calls    int
+}
+
+func (g *slowGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (*model.BpResponse, error) {
+	g.mu.Lock()
+	g.inFlight++
+	g.calls++
+	g.peak = max(g.peak, g.inFlight)
+	latency := g.latency
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.inFlight--
+		g.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(latency):
+		return &model.BpResponse{StatusCode: http.StatusOK, Headers: map[string][]string{}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (g *slowGateway) SetLatency(latency time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.latency = latency
+}
+
+func (g *slowGateway) Peak() (peak, calls int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.peak, g.calls
+}
+
+func TestInFlightLimiterNeverExceedsLimit(t *testing.T) {
+	slow := &slowGateway{latency: 5 * time.Millisecond}
+	l := NewInFlightLimiter(slow, 3, nil)
+
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: fmt.Sprintf("https://example.com/%d", i)}, gateway_interface.ProxyOptions{}); err != nil {
+				t.Errorf("request %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	peak, calls := slow.Peak()
+	if calls != 30 {
+		t.Errorf("Expected all 30 requests to be sent, got %d", calls)
+	}
+	if peak > 3 {
+		t.Errorf("Expected at most 3 requests in flight, got %d", peak)
+	}
+	if peak < 2 {
+		t.Errorf("Expected the requests to be sent concurrently, got a peak of %d", peak)
+	}
+	if stats := l.Stats(); stats != (InFlightStats{Limit: 3}) {
+		t.Errorf("Expected no request in flight after all finished, got %+v", stats)
+	}
+}
+
+func TestInFlightLimiterWaitsUntilContextExpires(t *testing.T) {
+	slow := &slowGateway{latency: time.Hour}
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewInFlightLimiter(slow, 1, m)
+
+	busy, stopBusy := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = l.ProxyRequest(busy, &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/slow"}, gateway_interface.ProxyOptions{})
+	}()
+	for l.Stats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 枠が空かないまま期限が切れたリクエストは、転送せずにErrInFlightLimitを返す
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.ProxyRequest(ctx, &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/waiting"}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrInFlightLimit) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected ErrInFlightLimit with the deadline, got %v", err)
+	}
+	if _, calls := slow.Peak(); calls != 1 {
+		t.Errorf("Expected the waiting request not to be sent, got %d calls", calls)
+	}
+	if got := gaugeValue(t, reg, "bp_proxy_gateway_in_flight_requests"); got != 1 {
+		t.Errorf("Expected 1 request in flight in the metrics, got %v", got)
+	}
+	if got := gaugeValue(t, reg, "bp_proxy_gateway_in_flight_waiting_requests"); got != 0 {
+		t.Errorf("Expected no waiting request in the metrics after the deadline, got %v", got)
+	}
+
+	// 転送中のリクエストが終われば、待っているリクエストが送られる
+	waiting := make(chan error)
+	go func() {
+		_, err := l.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/next"}, gateway_interface.ProxyOptions{})
+		waiting <- err
+	}()
+	for l.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	slow.SetLatency(0)
+	stopBusy()
+	<-done
+	if err := <-waiting; err != nil {
+		t.Errorf("Expected the waiting request to be sent after the slot freed, got %v", err)
+	}
+}
+
+// gaugeValue regに登録されたゲージnameの値
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("gauge %s is not registered", name)
+	return 0
+}
+
+func TestNewGatewayInFlightLimit(t *testing.T) {
+	g, err := NewGateway(Config{TransportMode: "mock", MaxInFlight: 4, BreakerThreshold: 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ブレーカーが開いている間は、枠の空きを待たずに失敗させる
+	cb, ok := g.(*CircuitBreaker)
+	if !ok {
+		t.Fatalf("Expected the gateway to be wrapped in a circuit breaker, got %T", g)
+	}
+	l, ok := cb.gw.(*InFlightLimiter)
+	if !ok {
+		t.Fatalf("Expected the breaker to wrap the in-flight limiter, got %T", cb.gw)
+	}
+	if _, ok := l.gw.(*MockGateway); !ok || l.Stats().Limit != 4 {
+		t.Errorf("Unexpected limiter: %T %+v", l.gw, l.Stats())
+	}
+}
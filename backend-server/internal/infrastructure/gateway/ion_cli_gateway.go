@@ -4,8 +4,8 @@ package gateway
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -13,25 +13,108 @@ import (
 	"sync"
 	"time"
 
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 )
 
+// ionRecvFile bprecvfileが受信したバンドルを書き出すファイル名
+const ionRecvFile = "testfile1"
+
+// commandRunner 外部コマンド（bpsendfile・bprecvfile）を実行する（テストではコマンドの代わりの処理）
+type commandRunner interface {
+	// Run nameをdirで実行して、出力を返す
+	Run(ctx context.Context, dir, name string, args ...string) ([]byte, error)
+	// LookPath nameのコマンドが使えるかを返す
+	LookPath(name string) error
+}
+
+// execRunner commandRunnerの実装（os/execでコマンドを実行する）
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
+
+func (execRunner) LookPath(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
 type IonCLIGateway struct {
 	Host                  string
 	Port                  int
 	Timeout               time.Duration
 	responseChs           sync.Map
 	UnsolicitedResponseCh chan *model.BpResponse
+	metrics               *metrics.Metrics
+
+	runner commandRunner
+	// eids bpsendfileで送る送信元・送信先と、bprecvfileで受信するEID
+	eids EIDs
+	// sendDir・recvDir 送るリクエストのファイルを書くディレクトリと、bprecvfileを実行するディレクトリ（ゲートウェイごとに別）
+	// 他のプロセスやカレントディレクトリに残ったファイルを、受信したレスポンスとして読まないようにする
+	sendDir string
+	recvDir string
+
+	// tempDir openIonCLIGatewayが作った作業ディレクトリ（Closeで削除する）
+	tempDir string
+
+	// retry bpsendfileが失敗したときの送り直し方（SetRetryPolicyで設定するまでは送り直さない）
+	retry sendRetrier
+
+	// roundTrips レスポンスが届くまでの時間を記録し、待つ時間を決める（SetRoundTripTrackerで設定するまではnilで、Timeoutだけ待つ）
+	roundTrips *RoundTripTracker
+
+	stop    context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewIonCLIGateway eidsで送受信するゲートウェイを作る（eidsはEIDs.Validateで確かめておく）
+func NewIonCLIGateway(eids EIDs, host string, port int, timeout time.Duration, metrics *metrics.Metrics) *IonCLIGateway {
+	return openIonCLIGateway(execRunner{}, eids, host, port, timeout, metrics)
 }
 
-func NewIonCLIGateway(host string, port int, timeout time.Duration) *IonCLIGateway {
+// openIonCLIGateway 一時ディレクトリを作業ディレクトリにして、runnerでコマンドを実行するゲートウェイを作る
+func openIonCLIGateway(runner commandRunner, eids EIDs, host string, port int, timeout time.Duration, metrics *metrics.Metrics) *IonCLIGateway {
+	workDir, err := os.MkdirTemp("", "ion-cli-gateway-")
+	if err != nil {
+		log.Printf("[IonCLI] Failed to create work directory, using the current directory: %v", err)
+		workDir = "."
+	}
+	g := newIonCLIGateway(runner, workDir, eids, timeout, metrics)
+	if workDir != "." {
+		g.tempDir = workDir
+	}
+	g.Host = host
+	g.Port = port
+	return g
+}
+
+// newIonCLIGateway workDirの下で、runnerでコマンドを実行するゲートウェイを作り、受信ループを始める
+func newIonCLIGateway(runner commandRunner, workDir string, eids EIDs, timeout time.Duration, metrics *metrics.Metrics) *IonCLIGateway {
+	ctx, stop := context.WithCancel(context.Background())
 	g := &IonCLIGateway{
-		Host:                  host,
-		Port:                  port,
 		Timeout:               timeout,
 		UnsolicitedResponseCh: make(chan *model.BpResponse, 100),
+		metrics:               metrics,
+		runner:                runner,
+		eids:                  eids,
+		sendDir:               filepath.Join(workDir, "request"),
+		recvDir:               filepath.Join(workDir, "recv"),
+		retry:                 newSendRetrier(RetryPolicy{}),
+		stop:                  stop,
+		stopped:               make(chan struct{}),
+	}
+	log.Printf("[IonCLI] Gateway started: %s -> %s (receiving at %s)", eids.Source, eids.Destination, eids.Receive)
+	for _, priority := range gateway_interface.Priorities {
+		if lane, ok := eids.Lanes[priority]; ok {
+			log.Printf("[IonCLI] %s priority lane: %s", priority, lane)
+		}
 	}
-	g.startReceiver()
+	g.startReceiver(ctx)
 	return g
 }
 
@@ -39,39 +122,71 @@ func (g *IonCLIGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
 	return g.UnsolicitedResponseCh
 }
 
-func (g *IonCLIGateway) startReceiver() {
-	go func() {
-		recvEID := "ipn:149.2"
-		targetFile := "testfile1"
+// Close 受信ループを止めて（実行中のbprecvfileも終了させる）、作業ディレクトリを削除する
+func (g *IonCLIGateway) Close() error {
+	g.stop()
+	<-g.stopped
+	if g.tempDir != "" {
+		return os.RemoveAll(g.tempDir)
+	}
+	return nil
+}
 
-		for {
-			if _, err := os.Stat(targetFile); err == nil {
-				_ = os.Remove(targetFile)
-			}
+// SetRetryPolicy bpsendfileが失敗したときの送り直し方を設定する（ProxyRequestを呼ぶ前に設定する）
+func (g *IonCLIGateway) SetRetryPolicy(policy RetryPolicy) {
+	g.retry.policy = policy
+}
 
-			log.Printf("[IonCLI] Waiting for response at %s...", recvEID)
+// SetRoundTripTracker レスポンスが届くまでの時間をtrackerに記録し、待つ時間をtrackerで決める（ProxyRequestを呼ぶ前に設定する）
+func (g *IonCLIGateway) SetRoundTripTracker(tracker *RoundTripTracker) {
+	g.roundTrips = tracker
+}
 
-			cmdRecv := exec.Command("bprecvfile", recvEID, "1")
-			if err := cmdRecv.Run(); err != nil {
-				log.Printf("[IonCLI] bprecvfile error: %v", err)
-				time.Sleep(1 * time.Second)
-				continue
-			}
+// HealthCheck IONのbpsendfile・bprecvfileコマンドが使えるかを返す
+func (g *IonCLIGateway) HealthCheck(ctx context.Context) error {
+	for _, name := range []string{"bpsendfile", "bprecvfile"} {
+		if err := g.runner.LookPath(name); err != nil {
+			return fmt.Errorf("%w: ION command %s is not available: %w", gateway_interface.ErrLinkDown, name, err)
+		}
+	}
+	return nil
+}
+
+// startReceiver bprecvfileでバンドルを1つずつ受信し、RequestIDで待っているリクエストに渡す
+// 受信するのはこのループだけで、受信したファイルはゲートウェイのrecvDirに書かれるため、同時に送ったリクエストのレスポンスが混ざらない
+func (g *IonCLIGateway) startReceiver(ctx context.Context) {
+	go func() {
+		defer close(g.stopped)
+		if err := os.MkdirAll(g.recvDir, 0755); err != nil {
+			log.Printf("[IonCLI] Failed to create receive directory %s: %v", g.recvDir, err)
+		}
+		targetFile := filepath.Join(g.recvDir, ionRecvFile)
 
-			if _, err := os.Stat(targetFile); os.IsNotExist(err) {
-				log.Printf("[IonCLI] file %s not found", targetFile)
+		for ctx.Err() == nil {
+			// 前回の受信で読めなかったファイルは残さない
+			_ = os.Remove(targetFile)
+
+			log.Printf("[IonCLI] Waiting for response at %s...", g.eids.Receive)
+
+			if output, err := g.runner.Run(ctx, g.recvDir, "bprecvfile", g.eids.Receive, "1"); err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				log.Printf("[IonCLI] bprecvfile error: %v, output: %s", err, string(output))
+				select {
+				case <-time.After(1 * time.Second):
+				case <-ctx.Done():
+				}
 				continue
 			}
 
-			fileContent, err := ioutil.ReadFile(targetFile)
+			fileContent, err := os.ReadFile(targetFile)
 			if err != nil {
 				log.Printf("[IonCLI] read error: %v", err)
-				_ = os.Remove(targetFile)
 				continue
 			}
-
-			log.Printf("[IonCLI] Received: %s", string(fileContent))
 			_ = os.Remove(targetFile)
+			log.Printf("[IonCLI] Received %d bytes", len(fileContent))
 
 			var dtnResp DTNJsonResponse
 			if err := json.Unmarshal(fileContent, &dtnResp); err != nil {
@@ -81,14 +196,17 @@ func (g *IonCLIGateway) startReceiver() {
 
 			g.dispatchResponse(&dtnResp)
 		}
+		// 止めたときに実行中だったbprecvfileが途中まで書いたファイルは残さない
+		_ = os.Remove(targetFile)
+		log.Println("[IonCLI] Receive loop stopped")
 	}()
 }
 
 func (g *IonCLIGateway) dispatchResponse(dtnResp *DTNJsonResponse) {
-	if ch, ok := g.responseChs.Load(dtnResp.RequestID); ok {
+	if ch, ok := lookupResponseCh(&g.responseChs, dtnResp); ok {
 		log.Printf("[IonCLI] Dispatching response for ID: %s", dtnResp.RequestID)
 		select {
-		case ch.(chan *DTNJsonResponse) <- dtnResp:
+		case ch <- dtnResp:
 		default:
 			log.Printf("[IonCLI] Channel blocked for ID: %s", dtnResp.RequestID)
 		}
@@ -109,59 +227,74 @@ func (g *IonCLIGateway) dispatchResponse(dtnResp *DTNJsonResponse) {
 	}
 }
 
-func (g *IonCLIGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, error) {
-	reqID := generateID()
-
-	respCh := make(chan *DTNJsonResponse, 1)
-	g.responseChs.Store(reqID, respCh)
-	defer func() {
-		g.responseChs.Delete(reqID)
-		close(respCh) // sendBundleでエラーが発生した場合でもチャネルを閉じる
-	}()
-
-	if err := g.sendBundle(reqID, breq); err != nil {
-		return nil, fmt.Errorf("bundle送信失敗: %w", err)
-	}
-
-	ctx, cancel := context.WithTimeout(ctx, g.Timeout)
-	defer cancel()
+func (g *IonCLIGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (resp *model.BpResponse, err error) {
+	start := time.Now()
+	defer func() { observeRoundTrip(g.metrics, transportIonCLI, start, err) }()
+	return g.roundTrip(ctx, breq, opts.Priority)
+}
 
-	select {
-	case dtnResp := <-respCh:
-		return ConvertToBpResponse(dtnResp)
-	case <-ctx.Done():
-		return nil, fmt.Errorf("request timeout or cancelled: %w", ctx.Err())
-	}
+// Probe 地上局にprobeURLをbpsendfileで送り、bprecvfileで応答を受け取るまでの往復時間を返す（転送したリクエストのメトリクスには含めない）
+func (g *IonCLIGateway) Probe(ctx context.Context) (time.Duration, error) {
+	return probeRoundTrip(ctx, g.roundTrip)
 }
 
-func (g *IonCLIGateway) sendBundle(reqID string, breq *model.BpRequest) error {
-	requestDir := "./request"
+// roundTrip breqをpriorityのレーンのEIDからバンドルとして送り、レスポンスを待つ
+func (g *IonCLIGateway) roundTrip(ctx context.Context, breq *model.BpRequest, priority gateway_interface.Priority) (*model.BpResponse, error) {
+
+	// respChは閉じない（受信ループが削除の直前に取り出したチャネルへ送ってもpanicしないように）
+	respCh := make(chan *DTNJsonResponse, 1)
+	reqID := registerResponseCh(&g.responseChs, breq, respCh)
+	defer g.responseChs.Delete(reqID)
 
-	if _, err := os.Stat(requestDir); os.IsNotExist(err) {
-		if err := os.Mkdir(requestDir, 0755); err != nil {
-			return fmt.Errorf("dir creation error: %w", err)
+	err := g.retry.do(ctx, "[IonCLI]", func() error { return g.sendBundle(ctx, reqID, breq, priority) })
+	if err != nil {
+		// IONのコマンドが使えない場合はリンクが使えないものとして扱う
+		if healthErr := g.HealthCheck(ctx); healthErr != nil {
+			return nil, fmt.Errorf("%w (%w)", healthErr, err)
 		}
+		return nil, sendFailure(ctx, err)
 	}
+	g.metrics.IncBundlesSent(transportIonCLI)
 
-	filename := fmt.Sprintf("req_%s.txt", reqID)
-	filePath := filepath.Join(requestDir, filename)
+	return awaitRoundTrip(ctx, respCh, g.Timeout, g.roundTrips)
+}
 
-	dtnReq := NewDTNJsonRequest(reqID, breq)
+// sendBundle リクエストをファイルに書いて、priorityのレーンのEIDからbpsendfileで送る（送り終えたファイルは削除する）
+// ファイル名はRequestIDではなく一時ファイルの名前にする（クライアントが付けたX-Request-IDをパスに使わない）
+func (g *IonCLIGateway) sendBundle(ctx context.Context, reqID string, breq *model.BpRequest, priority gateway_interface.Priority) error {
+	if err := os.MkdirAll(g.sendDir, 0755); err != nil {
+		return fmt.Errorf("dir creation error: %w", err)
+	}
 
-	jsonData, err := json.Marshal(dtnReq)
+	jsonData, err := json.Marshal(NewDTNJsonRequest(reqID, breq))
 	if err != nil {
 		return fmt.Errorf("JSON marshal error: %w", err)
 	}
 
-	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
+	file, err := os.CreateTemp(g.sendDir, "req_*.json")
+	if err != nil {
+		return fmt.Errorf("file create error: %w", err)
+	}
+	filePath := file.Name()
+	defer os.Remove(filePath)
+	_, err = file.Write(jsonData)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		return fmt.Errorf("file write error: %w", err)
 	}
-	log.Printf("[IonCLI] Created file: %s (ID: %s)", filePath, reqID)
+	source := g.eids.SourceFor(priority)
+	log.Printf("[IonCLI] Created file: %s (ID: %s, priority: %s, source: %s)", filePath, reqID, priority, source)
 
-	cmdSend := exec.Command("bpsendfile", "ipn:149.1", "ipn:150.1", filePath)
-	output, err := cmdSend.CombinedOutput()
+	output, err := g.runner.Run(ctx, g.sendDir, "bpsendfile", source, g.eids.Destination, filepath.Base(filePath))
 	if err != nil {
-		return fmt.Errorf("bpsendfile error: %v, output: %s", err, string(output))
+		err = fmt.Errorf("bpsendfile error: %w, output: %s", err, string(output))
+		// コマンドがない場合や止められた場合を除き、bpsendfileの失敗（ION側の一時的な混雑など）は送り直す
+		if ctx.Err() == nil && !errors.Is(err, exec.ErrNotFound) {
+			return transientSend(err)
+		}
+		return err
 	}
 	log.Printf("[IonCLI] bpsendfile output: %s", string(output))
 
@@ -0,0 +1,213 @@
+// ion_cli_gateway_test.go - ION CLIゲートウェイで同時に送ったリクエストのレスポンスが混ざらないこと、レスポンスが届かない場合に期限で戻ることのテスト（bpsendfile・bprecvfileの代わりの処理を使う）
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// scriptedRunner bpsendfileで送られたリクエストに、転送先の代わりにURLとボディを返すレスポンスを作り、bprecvfileで1つずつ書き出す
+type scriptedRunner struct {
+	responses chan []byte
+
+	mu   sync.Mutex
+	sent int
+}
+
+func newScriptedRunner() *scriptedRunner {
+	return &scriptedRunner{responses: make(chan []byte, 64)}
+}
+
+func (r *scriptedRunner) Run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	switch name {
+	case "bpsendfile":
+		data, err := os.ReadFile(filepath.Join(dir, args[2]))
+		if err != nil {
+			return nil, err
+		}
+		var req DTNJsonRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		r.sent++
+		// 送った順と逆の順に届くよう、後のリクエストほど早く返す
+		delay := time.Duration(20-r.sent) * 3 * time.Millisecond
+		r.mu.Unlock()
+
+		go func() {
+			time.Sleep(delay)
+			body, _ := base64.StdEncoding.DecodeString(req.Body)
+			resp, _ := json.Marshal(DTNJsonResponse{
+				Version:    protocolVersion,
+				RequestID:  req.RequestID,
+				StatusCode: http.StatusOK,
+				Body:       base64.StdEncoding.EncodeToString([]byte(req.URL + " " + string(body))),
+			})
+			r.responses <- resp
+		}()
+		return []byte("sent"), nil
+
+	case "bprecvfile":
+		select {
+		case resp := <-r.responses:
+			// bprecvfileと同じく、カレントディレクトリにtestfile1として書き出す
+			return nil, os.WriteFile(filepath.Join(dir, ionRecvFile), resp, 0o644)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("unexpected command %s", name)
+}
+
+func (r *scriptedRunner) LookPath(name string) error { return nil }
+
+func TestIonCLIGatewayConcurrentRequests(t *testing.T) {
+	workDir := t.TempDir()
+	g := newIonCLIGateway(newScriptedRunner(), workDir, DefaultEIDs, 5*time.Second, nil)
+	defer g.Close()
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := &model.BpRequest{
+				Method: http.MethodPost,
+				URL:    fmt.Sprintf("https://example.com/item/%d", i),
+				Body:   []byte(fmt.Sprintf("body-%d", i)),
+				// パスとして使えない文字を含むX-Request-IDでも、リクエストのファイルは作業ディレクトリの中に作る
+				RequestID: fmt.Sprintf("../req/%d", i),
+			}
+			resp, err := g.ProxyRequest(context.Background(), req, gateway_interface.ProxyOptions{})
+			if err != nil {
+				t.Errorf("ProxyRequest %d failed: %v", i, err)
+				return
+			}
+			if want := fmt.Sprintf("https://example.com/item/%d body-%d", i, i); string(resp.Body) != want {
+				t.Errorf("Expected %q, got %q", want, resp.Body)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 送り終えたリクエストのファイルは残さない
+	files, err := os.ReadDir(g.sendDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no leftover request files, got %d", len(files))
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "req")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file outside the send directory, got %v", err)
+	}
+}
+
+// silentRunner バンドルは送れるが、レスポンスが届かない（bprecvfileがファイルを書かずに待ち続ける）
+// hangSendの場合はbpsendfileも終わらない。どちらも止められたときは途中まで書いたファイルを残す
+type silentRunner struct {
+	hangSend bool
+}
+
+func (r silentRunner) Run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	if name == "bpsendfile" && !r.hangSend {
+		return []byte("sent"), nil
+	}
+	if name == "bprecvfile" {
+		if err := os.WriteFile(filepath.Join(dir, ionRecvFile), []byte(`{"request_id":`), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (silentRunner) LookPath(name string) error { return nil }
+
+func TestIonCLIGatewayDeadline(t *testing.T) {
+	for _, hangSend := range []bool{false, true} {
+		t.Run(fmt.Sprintf("hangSend=%v", hangSend), func(t *testing.T) {
+			workDir := t.TempDir()
+			// ゲートウェイのタイムアウトより先に、呼び出し元の期限が来る
+			g := newIonCLIGateway(silentRunner{hangSend: hangSend}, workDir, DefaultEIDs, time.Hour, nil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := g.ProxyRequest(ctx, &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/lost"}, gateway_interface.ProxyOptions{})
+			if !errors.Is(err, gateway_interface.ErrTimeout) {
+				t.Errorf("Expected ErrTimeout, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Expected to return at the deadline, took %v", elapsed)
+			}
+
+			g.responseChs.Range(func(key, _ any) bool {
+				t.Errorf("Expected no waiter after the deadline, got %v", key)
+				return true
+			})
+			g.Close()
+			for _, dir := range []string{g.sendDir, g.recvDir} {
+				files, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(files) != 0 {
+					t.Errorf("Expected no leftover files in %s, got %d", dir, len(files))
+				}
+			}
+		})
+	}
+}
+
+// sourceRecordingRunner bpsendfileで送ったEID（送信元）を記録する
+type sourceRecordingRunner struct {
+	*scriptedRunner
+
+	mu      sync.Mutex
+	sources []string
+}
+
+func (r *sourceRecordingRunner) Run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	if name == "bpsendfile" {
+		r.mu.Lock()
+		r.sources = append(r.sources, args[0])
+		r.mu.Unlock()
+	}
+	return r.scriptedRunner.Run(ctx, dir, name, args...)
+}
+
+func TestIonCLIGatewayPriorityLanes(t *testing.T) {
+	runner := &sourceRecordingRunner{scriptedRunner: newScriptedRunner()}
+	eids := DefaultEIDs
+	eids.Lanes = map[gateway_interface.Priority]string{gateway_interface.PriorityLow: "ipn:149.3"}
+	g := newIonCLIGateway(runner, t.TempDir(), eids, 5*time.Second, nil)
+	defer g.Close()
+
+	for _, opts := range []gateway_interface.ProxyOptions{{}, {Priority: gateway_interface.PriorityLow}, {Priority: gateway_interface.PriorityHigh}} {
+		if _, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/" + opts.Priority.String()}, opts); err != nil {
+			t.Fatalf("%s priority: %v", opts.Priority, err)
+		}
+	}
+
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	// レーンのないhighはsourceから、lowはレーンから送る
+	if want := []string{"ipn:149.1", "ipn:149.3", "ipn:149.1"}; fmt.Sprint(runner.sources) != fmt.Sprint(want) {
+		t.Errorf("Expected bundles from %v, got %v", want, runner.sources)
+	}
+}
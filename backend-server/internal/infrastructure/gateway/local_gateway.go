@@ -1,30 +1,46 @@
-// local_gateway.go - BP経由せず直接HTTPリクエストを送信するゲートウェイ（テスト用）
+// local_gateway.go - BP経由せず直接HTTPリクエストを送信するゲートウェイ（インターネットに直接つながる開発環境・ベンチ用）
 package gateway
 
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 )
 
+// LocalGateway BpRequestをそのままHTTPで転送し、地上局（earth）と同じくリダイレクトをたどったレスポンスを返す
+// ボディの圧縮はHTTPクライアントに任せ、展開したボディを返す（キャッシュやHTMLの書き換えは展開したボディを前提にする）
 type LocalGateway struct {
-	client *http.Client
+	client  *http.Client
+	metrics *metrics.Metrics
 }
 
-func NewLocalGateway(timeout time.Duration) *LocalGateway {
+func NewLocalGateway(timeout time.Duration, metrics *metrics.Metrics) *LocalGateway {
+	return NewLocalGatewayWithClient(&http.Client{Timeout: timeout}, metrics)
+}
+
+// NewLocalGatewayWithClient clientで転送するLocalGatewayを作る（プロキシやTLSの設定を変える場合）
+func NewLocalGatewayWithClient(client *http.Client, metrics *metrics.Metrics) *LocalGateway {
 	return &LocalGateway{
-		client: &http.Client{
-			Timeout: timeout,
-		},
+		client:  client,
+		metrics: metrics,
 	}
 }
 
-func (g *LocalGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest) (*model.BpResponse, error) {
+// ProxyRequest DTNを使わないためバンドルの送信数は記録せず、往復時間だけを記録する
+// ProxyRequest optsは使わない（DTNを通らないため優先度の違いがない）
+func (g *LocalGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (resp *model.BpResponse, err error) {
+	start := time.Now()
+	defer func() { observeRoundTrip(g.metrics, transportLocal, start, err) }()
+
 	targetURL := breq.URL
 
 	httpReq, err := http.NewRequestWithContext(ctx, breq.Method, targetURL, bytes.NewReader(breq.Body))
@@ -33,27 +49,45 @@ func (g *LocalGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest)
 	}
 
 	breq.SetHeaders(httpReq)
+	// ブラウザのAccept-Encodingを送ると、HTTPクライアントが圧縮されたボディを展開しない
+	httpReq.Header.Del("Accept-Encoding")
 
 	httpResp, err := g.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to forward HTTP request: %w", err)
+		// 転送先が期限までに応答しなかった場合と、接続・送信できなかった場合を分ける
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil, fmt.Errorf("%w: %w", gateway_interface.ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("%w: failed to forward HTTP request: %w", gateway_interface.ErrSendFailed, err)
 	}
 	defer httpResp.Body.Close()
 
 	bodyBytes, err := io.ReadAll(httpResp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("%w: failed to read response body: %w", gateway_interface.ErrResponseCorrupt, err)
 	}
 
+	// 展開したボディの長さ（圧縮されていた場合はContent-Lengthがなく、ContentLengthは-1になる）
+	// HEADはボディがないため、転送先のContent-Lengthをそのまま使う
+	contentLength := int64(len(bodyBytes))
+	if breq.Method == http.MethodHead {
+		contentLength = httpResp.ContentLength
+	}
 	return &model.BpResponse{
 		StatusCode:    httpResp.StatusCode,
 		Headers:       httpResp.Header,
 		Body:          bodyBytes,
 		ContentType:   httpResp.Header.Get("Content-Type"),
-		ContentLength: httpResp.ContentLength,
+		ContentLength: contentLength,
 	}, nil
 }
 
+// HealthCheck ローカルゲートウェイはDTNを使わないため常に正常
+func (g *LocalGateway) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
 func (g *LocalGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
 	// LocalGatewayではPush受信をサポートしないため、nilチャンネルを返す
 	return nil
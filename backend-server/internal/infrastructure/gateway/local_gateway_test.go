@@ -0,0 +1,122 @@
+// local_gateway_test.go - DTNを使わずに直接HTTPで転送するゲートウェイのテスト
+package gateway
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// newLocalOrigin LocalGatewayの転送先（パスごとに、エコー・エラー・リダイレクト・gzip・遅延を返す）
+func newLocalOrigin(t *testing.T) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case "/moved":
+			http.Redirect(w, r, "/echo", http.StatusFound)
+		case "/gzip":
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				http.Error(w, "expected gzip", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			io.WriteString(zw, "<p>compressed</p>")
+			zw.Close()
+		case "/slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		default:
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Echo-Header", r.Header.Get("X-Test"))
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+		}
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+func TestLocalGatewayProxyRequest(t *testing.T) {
+	origin := newLocalOrigin(t)
+	g := NewLocalGateway(5*time.Second, nil)
+
+	tests := []struct {
+		name   string
+		req    *model.BpRequest
+		status int
+		body   string
+		header string
+	}{
+		{"get", &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/echo", Headers: map[string][]string{"X-Test": {"1"}}}, http.StatusOK, "GET /echo ", "1"},
+		{"post", &model.BpRequest{Method: http.MethodPost, URL: origin.URL + "/form", Body: []byte("a=1"), ContentType: "application/x-www-form-urlencoded"}, http.StatusOK, "POST /form a=1", ""},
+		{"error status", &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/error"}, http.StatusInternalServerError, "boom\n", ""},
+		{"redirect", &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/moved"}, http.StatusOK, "GET /echo ", ""},
+		// ブラウザのAccept-Encodingに関わらず、展開したボディを返す
+		{"gzip", &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/gzip", Headers: map[string][]string{"Accept-Encoding": {"br"}}}, http.StatusOK, "<p>compressed</p>", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := g.ProxyRequest(context.Background(), tt.req, gateway_interface.ProxyOptions{})
+			if err != nil {
+				t.Fatalf("ProxyRequest failed: %v", err)
+			}
+			if resp.StatusCode != tt.status || string(resp.Body) != tt.body {
+				t.Errorf("Expected %d %q, got %d %q", tt.status, tt.body, resp.StatusCode, resp.Body)
+			}
+			if resp.ContentLength != int64(len(tt.body)) {
+				t.Errorf("Expected content length %d, got %d", len(tt.body), resp.ContentLength)
+			}
+			if got := http.Header(resp.Headers).Get("X-Echo-Header"); got != tt.header {
+				t.Errorf("Expected the request headers to be forwarded, got %q", got)
+			}
+			if got := http.Header(resp.Headers).Get("Content-Encoding"); got != "" {
+				t.Errorf("Expected no Content-Encoding on the decoded body, got %q", got)
+			}
+		})
+	}
+}
+
+func TestLocalGatewayTimeout(t *testing.T) {
+	origin := newLocalOrigin(t)
+
+	// HTTPクライアントのタイムアウト
+	_, err := NewLocalGateway(20*time.Millisecond, nil).ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/slow"}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout from the client timeout, got %v", err)
+	}
+
+	// 呼び出し元の期限
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = NewLocalGatewayWithClient(&http.Client{}, nil).ProxyRequest(ctx, &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/slow"}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout from the caller deadline, got %v", err)
+	}
+}
+
+func TestLocalGatewayUnreachable(t *testing.T) {
+	origin := newLocalOrigin(t)
+	url := origin.URL
+	origin.Close()
+
+	_, err := NewLocalGateway(time.Second, nil).ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: url}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrSendFailed) {
+		t.Errorf("Expected ErrSendFailed, got %v", err)
+	}
+}
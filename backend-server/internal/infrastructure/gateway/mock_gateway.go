@@ -0,0 +1,163 @@
+// mock_gateway.go - DTNにも転送先にも送らず、決めたとおりのレスポンスを返すゲートウェイ（負荷試験・デモ・結合テスト用）
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// mockUnsolicitedBuffer 後から届けるレスポンスを、ResponseWatcherが受け取るまでためておく数
+const mockUnsolicitedBuffer = 100
+
+// MockGateway どのリクエストにも、latencyだけ待ってからURLを書いたHTMLを200で返す
+// SetScenariosで、URLごとのレスポンス・遅延・確率的な失敗・後から届くレスポンスを決められる
+// 予約キューやWorker・キャッシュの動きを、ネットワークなしで確かめるために使う
+type MockGateway struct {
+	latency   time.Duration
+	metrics   *metrics.Metrics
+	scenarios *MockScenarios
+	random    func() float64 // 失敗させるかを決める乱数（テストで差し替える）
+
+	unsolicited chan *model.BpResponse
+	done        chan struct{}
+	closeOnce   sync.Once
+	deliveries  sync.WaitGroup
+}
+
+var (
+	_ gateway_interface.BpGateway     = (*MockGateway)(nil)
+	_ gateway_interface.HealthChecker = (*MockGateway)(nil)
+	_ gateway_interface.Prober        = (*MockGateway)(nil)
+)
+
+func NewMockGateway(latency time.Duration, metrics *metrics.Metrics) *MockGateway {
+	return &MockGateway{
+		latency:     latency,
+		metrics:     metrics,
+		random:      rand.Float64,
+		unsolicited: make(chan *model.BpResponse, mockUnsolicitedBuffer),
+		done:        make(chan struct{}),
+	}
+}
+
+// SetScenarios URLごとの振る舞いを決める（nilはすべてのURLにURLを書いたHTMLを返す）
+func (g *MockGateway) SetScenarios(scenarios *MockScenarios) {
+	g.scenarios = scenarios
+}
+
+func (g *MockGateway) ProxyRequest(ctx context.Context, breq *model.BpRequest, opts gateway_interface.ProxyOptions) (resp *model.BpResponse, err error) {
+	start := time.Now()
+	defer func() { observeRoundTrip(g.metrics, transportMock, start, err) }()
+
+	route := g.scenarios.route(breq.URL)
+	latency := g.latency
+	if route != nil && route.Latency > 0 {
+		latency = time.Duration(route.Latency)
+	}
+	if latency > 0 {
+		if err := sleepContext(ctx, latency); err != nil {
+			return nil, fmt.Errorf("request cancelled: %w", err)
+		}
+	}
+
+	if route != nil && route.FailureRate > 0 && g.random() < route.FailureRate {
+		return nil, route.failure(breq.URL)
+	}
+
+	resp = g.response(breq, route)
+	if route != nil && route.DeliverAfter > 0 {
+		g.deliverLater(breq.URL, resp, time.Duration(route.DeliverAfter)-time.Since(start))
+		return nil, fmt.Errorf("%w: mock response for %s will arrive in %v", gateway_interface.ErrTimeout, breq.URL, time.Duration(route.DeliverAfter))
+	}
+	return resp, nil
+}
+
+// response routeのレスポンス（routeがnilの場合やBodyを省略した場合はURLを書いたHTML）
+func (g *MockGateway) response(breq *model.BpRequest, route *MockRoute) *model.BpResponse {
+	if route == nil {
+		route = &MockRoute{}
+	}
+	body := route.Body
+	fallbackType := "text/plain; charset=utf-8"
+	if body == "" {
+		body = fmt.Sprintf("<!DOCTYPE html>\n<html><body><p>mock response for %s %s</p></body></html>\n", html.EscapeString(breq.Method), html.EscapeString(breq.URL))
+		fallbackType = "text/html; charset=utf-8"
+	}
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header, contentType := route.header(fallbackType)
+	return &model.BpResponse{
+		RequestID:     breq.RequestID,
+		StatusCode:    status,
+		Headers:       header,
+		Body:          []byte(body),
+		ContentType:   contentType,
+		ContentLength: int64(len(body)),
+	}
+}
+
+// deliverLater delayの後にrespを待っているリクエストのないレスポンスとして届ける
+// 地上局と同じように、どのリクエストのレスポンスかをX-Original-URLとRequestIDで示す
+func (g *MockGateway) deliverLater(url string, resp *model.BpResponse, delay time.Duration) {
+	http.Header(resp.Headers).Set("X-Original-URL", url)
+	g.deliveries.Add(1)
+	go func() {
+		defer g.deliveries.Done()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-g.done:
+			return
+		}
+		select {
+		case g.unsolicited <- resp:
+			log.Printf("[MockGateway] Delivered a late response: %s (RequestID: %s)", url, resp.RequestID)
+		case <-g.done:
+		}
+	}()
+}
+
+// Probe latencyだけ待ってから、往復時間としてlatencyを返す
+func (g *MockGateway) Probe(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if g.latency > 0 {
+		if err := sleepContext(ctx, g.latency); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return 0, fmt.Errorf("%w: %w", gateway_interface.ErrTimeout, err)
+			}
+			return 0, fmt.Errorf("probe cancelled: %w", err)
+		}
+	}
+	return time.Since(start), nil
+}
+
+// HealthCheck モックゲートウェイは常に正常
+func (g *MockGateway) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// GetUnsolicitedResponseCh deliver_afterのルートのレスポンスが後から届くチャンネル
+func (g *MockGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse {
+	return g.unsolicited
+}
+
+// Close まだ届けていないレスポンスを捨てる
+func (g *MockGateway) Close() error {
+	g.closeOnce.Do(func() { close(g.done) })
+	g.deliveries.Wait()
+	return nil
+}
@@ -0,0 +1,171 @@
+// mock_scenario.go - mockモードのゲートウェイの振る舞い（URLごとのレスポンス・遅延・失敗・後から届くレスポンス）をJSONのファイルから読む
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+)
+
+// mockモードで失敗させるときのエラーの種類（MockRoute.Failure）
+const (
+	mockFailureSendFailed = "send_failed" // バンドルを送れなかった（ErrSendFailed）
+	mockFailureLinkDown   = "link_down"   // リンクが切れている（ErrLinkDown）
+	mockFailureTimeout    = "timeout"     // 送ったがレスポンスが届かなかった（ErrTimeout）
+)
+
+// MockScenarios mockモードのゲートウェイの振る舞い
+// Routesを書いた順に確かめ、最初にURLが合ったルートでレスポンスを返す。どのルートにも合わないURLはDefault（省略した場合はURLを書いたHTML）で返す
+type MockScenarios struct {
+	Default *MockRoute  `json:"default,omitempty"`
+	Routes  []MockRoute `json:"routes"`
+}
+
+// MockRoute 1つのURL（またはURLの前方一致）に返すレスポンスと、その返し方
+type MockRoute struct {
+	// URL 完全一致するURL（末尾を*にすると前方一致）。Defaultでは使わない
+	URL string `json:"url,omitempty"`
+
+	// Status・ContentType・Headers・Body 返すレスポンス（Statusは省略すると200、Bodyは省略するとURLを書いたHTML）
+	Status      int               `json:"status,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+
+	// Latency ProxyRequestが返すまでの時間（省略するとmock_latency）
+	Latency MockDuration `json:"latency,omitempty"`
+
+	// FailureRate・Failure FailureRateの確率（0〜1）でFailureの種類のエラーを返す（Failureは省略するとsend_failed）
+	FailureRate float64 `json:"failure_rate,omitempty"`
+	Failure     string  `json:"failure,omitempty"`
+
+	// DeliverAfter 0より大きい場合、ProxyRequestはLatencyの後にErrTimeoutを返し、レスポンスはProxyRequestを呼んでからDeliverAfterの後に
+	// 待っているリクエストのないレスポンス（GetUnsolicitedResponseCh）として届ける（DTNで応答が遅れて届く場合の再現）
+	DeliverAfter MockDuration `json:"deliver_after,omitempty"`
+}
+
+// MockDuration JSONでは"1.5s"のようなtime.ParseDurationの文字列で書く時間
+type MockDuration time.Duration
+
+func (d *MockDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1.5s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = MockDuration(parsed)
+	return nil
+}
+
+func (d MockDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadMockScenarios pathのJSONからmockモードの振る舞いを読む（知らないフィールドは書き間違いとしてエラーにする）
+func LoadMockScenarios(path string) (*MockScenarios, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock scenarios: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var scenarios MockScenarios
+	if err := dec.Decode(&scenarios); err != nil {
+		return nil, fmt.Errorf("failed to parse mock scenarios %s: %w", path, err)
+	}
+	if err := scenarios.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mock scenarios %s: %w", path, err)
+	}
+	return &scenarios, nil
+}
+
+// Validate ルートのURL・確率・失敗の種類・時間を確かめる
+func (s *MockScenarios) Validate() error {
+	if s.Default != nil {
+		if err := s.Default.validate(); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	for i := range s.Routes {
+		route := &s.Routes[i]
+		if route.URL == "" {
+			return fmt.Errorf("routes[%d]: url is required", i)
+		}
+		if err := route.validate(); err != nil {
+			return fmt.Errorf("routes[%d] (%s): %w", i, route.URL, err)
+		}
+	}
+	return nil
+}
+
+func (r *MockRoute) validate() error {
+	if r.Status != 0 && (r.Status < 100 || r.Status > 999) {
+		return fmt.Errorf("status %d is not an HTTP status code", r.Status)
+	}
+	if r.FailureRate < 0 || r.FailureRate > 1 {
+		return fmt.Errorf("failure_rate %v must be between 0 and 1", r.FailureRate)
+	}
+	switch r.Failure {
+	case "", mockFailureSendFailed, mockFailureLinkDown, mockFailureTimeout:
+	default:
+		return fmt.Errorf("unknown failure %q (use %s, %s or %s)", r.Failure, mockFailureSendFailed, mockFailureLinkDown, mockFailureTimeout)
+	}
+	if r.Latency < 0 || r.DeliverAfter < 0 {
+		return errors.New("latency and deliver_after must not be negative")
+	}
+	return nil
+}
+
+// route urlに返すルート（どのルートにも合わない場合はDefault、Defaultもない場合はnil）
+func (s *MockScenarios) route(url string) *MockRoute {
+	if s == nil {
+		return nil
+	}
+	for i := range s.Routes {
+		route := &s.Routes[i]
+		if prefix, ok := strings.CutSuffix(route.URL, "*"); ok {
+			if strings.HasPrefix(url, prefix) {
+				return route
+			}
+		} else if route.URL == url {
+			return route
+		}
+	}
+	return s.Default
+}
+
+// failure ルートのFailureの種類のエラー
+func (r *MockRoute) failure(url string) error {
+	switch r.Failure {
+	case mockFailureLinkDown:
+		return fmt.Errorf("%w: mock failure for %s", gateway_interface.ErrLinkDown, url)
+	case mockFailureTimeout:
+		return fmt.Errorf("%w: mock failure for %s", gateway_interface.ErrTimeout, url)
+	default:
+		return fmt.Errorf("%w: mock failure for %s", gateway_interface.ErrSendFailed, url)
+	}
+}
+
+// header ルートのContent-Type（省略した場合はfallback）とHeaders
+func (r *MockRoute) header(fallback string) (http.Header, string) {
+	h := make(http.Header, len(r.Headers)+1)
+	for name, value := range r.Headers {
+		h.Set(name, value)
+	}
+	contentType := r.ContentType
+	if contentType == "" {
+		contentType = fallback
+	}
+	h.Set("Content-Type", contentType)
+	return h, contentType
+}
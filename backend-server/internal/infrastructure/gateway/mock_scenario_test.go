@@ -0,0 +1,145 @@
+// mock_scenario_test.go - mockモードのURLごとの振る舞い（レスポンス・遅延・失敗・後から届くレスポンス）のテスト
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// newScenarioGateway testdata/mock_scenarios.jsonの振る舞いのMockGateway（失敗させるかはrandomで決める）
+func newScenarioGateway(t *testing.T, random float64) *MockGateway {
+	t.Helper()
+	scenarios, err := LoadMockScenarios("testdata/mock_scenarios.json")
+	if err != nil {
+		t.Fatalf("LoadMockScenarios failed: %v", err)
+	}
+	g := NewMockGateway(0, nil)
+	g.SetScenarios(scenarios)
+	g.random = func() float64 { return random }
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func mockGet(g *MockGateway, url string) (*model.BpResponse, error) {
+	return g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: url, RequestID: "req-1"}, gateway_interface.ProxyOptions{})
+}
+
+func TestMockScenariosResponses(t *testing.T) {
+	g := newScenarioGateway(t, 0.9)
+
+	tests := []struct {
+		url         string
+		status      int
+		contentType string
+		body        string
+	}{
+		{url: "http://example.com/", status: http.StatusOK, contentType: "text/html; charset=utf-8", body: "<h1>home</h1>"},
+		{url: "http://example.com/missing", status: http.StatusNotFound, contentType: "text/plain; charset=utf-8", body: "not found"},
+		// ルートに合わないURLはdefault（Bodyを省略しているためURLを書いたHTML）
+		{url: "http://example.com/other", status: http.StatusOK, contentType: "text/html; charset=utf-8", body: "mock response for GET http://example.com/other"},
+		// failure_rateより大きい乱数では失敗しない
+		{url: "http://flaky.example.com/page", status: http.StatusOK, contentType: "text/html; charset=utf-8", body: "http://flaky.example.com/page"},
+	}
+	for _, tt := range tests {
+		resp, err := mockGet(g, tt.url)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.url, err)
+		}
+		if resp.StatusCode != tt.status || resp.ContentType != tt.contentType || !strings.Contains(string(resp.Body), tt.body) {
+			t.Errorf("%s: expected %d %q containing %q, got %d %q %q", tt.url, tt.status, tt.contentType, tt.body, resp.StatusCode, resp.ContentType, resp.Body)
+		}
+		if resp.RequestID != "req-1" {
+			t.Errorf("%s: expected the RequestID to be echoed, got %q", tt.url, resp.RequestID)
+		}
+	}
+}
+
+func TestMockScenariosFailures(t *testing.T) {
+	g := newScenarioGateway(t, 0.1)
+
+	if _, err := mockGet(g, "http://flaky.example.com/page"); !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Errorf("Expected a timeout below the failure rate, got %v", err)
+	}
+	if _, err := mockGet(g, "http://down.example.com/"); !errors.Is(err, gateway_interface.ErrLinkDown) {
+		t.Errorf("Expected the link to be down, got %v", err)
+	}
+	if _, err := mockGet(g, "http://example.com/"); err != nil {
+		t.Errorf("Expected routes without a failure rate to succeed, got %v", err)
+	}
+}
+
+func TestMockScenariosDeliverLater(t *testing.T) {
+	g := newScenarioGateway(t, 0)
+
+	start := time.Now()
+	if _, err := mockGet(g, "http://example.com/late"); !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Fatalf("Expected ProxyRequest to time out, got %v", err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond || d >= 50*time.Millisecond {
+		t.Errorf("Expected ProxyRequest to return after the latency, took %v", d)
+	}
+
+	select {
+	case resp := <-g.GetUnsolicitedResponseCh():
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("Expected the response after deliver_after, got it after %v", d)
+		}
+		if resp.RequestID != "req-1" || http.Header(resp.Headers).Get("X-Original-URL") != "http://example.com/late" {
+			t.Errorf("Expected the response to name its request, got RequestID=%q headers=%v", resp.RequestID, resp.Headers)
+		}
+		if !strings.Contains(string(resp.Body), "<h1>late</h1>") || http.Header(resp.Headers).Get("Cache-Control") != "max-age=3600" {
+			t.Errorf("Unexpected late response: %v %q", resp.Headers, resp.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the late response to be delivered")
+	}
+}
+
+func TestMockGatewayCloseDropsLateResponses(t *testing.T) {
+	g := newScenarioGateway(t, 0)
+	if _, err := mockGet(g, "http://example.com/late"); !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Fatalf("Expected ProxyRequest to time out, got %v", err)
+	}
+	g.Close()
+
+	select {
+	case resp := <-g.GetUnsolicitedResponseCh():
+		t.Errorf("Expected no response after Close, got %+v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLoadMockScenariosErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{name: "unknown field", json: `{"routes":[{"url":"http://example.com/","latancy":"1s"}]}`, wantErr: `unknown field "latancy"`},
+		{name: "missing url", json: `{"routes":[{"body":"x"}]}`, wantErr: "routes[0]: url is required"},
+		{name: "failure rate", json: `{"routes":[{"url":"http://example.com/","failure_rate":1.5}]}`, wantErr: "failure_rate 1.5 must be between 0 and 1"},
+		{name: "failure kind", json: `{"default":{"failure":"dropped"}}`, wantErr: `default: unknown failure "dropped"`},
+		{name: "duration", json: `{"routes":[{"url":"http://example.com/","latency":5}]}`, wantErr: "duration must be a string"},
+		{name: "negative duration", json: `{"routes":[{"url":"http://example.com/","deliver_after":"-1s"}]}`, wantErr: "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenarios.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadMockScenarios(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
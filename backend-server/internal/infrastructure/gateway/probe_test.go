@@ -0,0 +1,104 @@
+// probe_test.go - 地上局へのプローブの往復時間・タイムアウトと、プローブの結果によるサーキットブレーカーの更新のテスト
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+)
+
+func TestBpSocketGatewayProbe(t *testing.T) {
+	const earthDelay = 50 * time.Millisecond
+	var probes int
+	g, _ := newLoopbackGateway(t, 5*time.Second, func(req *DTNJsonRequest) time.Duration {
+		if req.URL == probeURL {
+			probes++
+		}
+		return earthDelay
+	})
+
+	rtt, err := g.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if rtt < earthDelay || rtt > 5*time.Second {
+		t.Errorf("Expected a round trip of at least %v, got %v", earthDelay, rtt)
+	}
+	if probes != 1 {
+		t.Errorf("Expected the earth station to receive 1 probe, got %d", probes)
+	}
+}
+
+func TestBpSocketGatewayProbeTimeout(t *testing.T) {
+	g, _ := newLoopbackGateway(t, 5*time.Second, func(req *DTNJsonRequest) time.Duration { return 200 * time.Millisecond })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := g.Probe(ctx); !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestIonCLIGatewayProbe(t *testing.T) {
+	g := newIonCLIGateway(newScriptedRunner(), t.TempDir(), DefaultEIDs, 5*time.Second, nil)
+	defer g.Close()
+	if rtt, err := g.Probe(context.Background()); err != nil || rtt <= 0 {
+		t.Errorf("Expected a round trip, got %v %v", rtt, err)
+	}
+
+	silent := newIonCLIGateway(silentRunner{}, t.TempDir(), DefaultEIDs, 30*time.Millisecond, nil)
+	defer silent.Close()
+	if _, err := silent.Probe(context.Background()); !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+// probingGateway 決めた順にプローブの結果を返す（nilは応答が届いた）ゲートウェイ
+type probingGateway struct {
+	failingGateway
+	probes []error
+}
+
+func (g *probingGateway) Probe(ctx context.Context) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	err := g.probes[0]
+	g.probes = g.probes[1:]
+	if err != nil {
+		return 0, err
+	}
+	return time.Second, nil
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	timeout := fmt.Errorf("%w: no reply", gateway_interface.ErrTimeout)
+	gw := &probingGateway{probes: []error{timeout, timeout, nil}}
+	cb, _ := newTestBreaker(gw, 2, nil)
+
+	// 届かなかったプローブは失敗として数え、続けば開く
+	for range 2 {
+		if _, err := cb.Probe(context.Background()); !errors.Is(err, gateway_interface.ErrTimeout) {
+			t.Fatalf("Expected ErrTimeout, got %v", err)
+		}
+	}
+	if cb.State() != circuitOpen {
+		t.Fatalf("Expected the breaker to open after failed probes, got %s", cb.State())
+	}
+
+	// 開いている間もプローブは送り、応答が届けばクールダウンを待たずに閉じる
+	if rtt, err := cb.Probe(context.Background()); err != nil || rtt != time.Second {
+		t.Fatalf("Expected the probe to be answered, got %v %v", rtt, err)
+	}
+	if cb.State() != circuitClosed {
+		t.Errorf("Expected the breaker to close after an answered probe, got %s", cb.State())
+	}
+
+	// プローブを送れないゲートウェイ
+	if _, err := NewCircuitBreaker(&failingGateway{}, 1, time.Minute, nil).Probe(context.Background()); !errors.Is(err, gateway_interface.ErrProbeUnsupported) {
+		t.Errorf("Expected ErrProbeUnsupported, got %v", err)
+	}
+}
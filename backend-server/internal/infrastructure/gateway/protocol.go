@@ -3,14 +3,23 @@ package gateway
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
 )
 
 const protocolVersion = 1
 
+// originalURLHeader 地上局がレスポンスに付ける、取得したURLのヘッダー
+const originalURLHeader = "X-Original-URL"
+
+// probeURL 地上局がサイトを取得せずにすぐ200で応答する、リンクの確認（プローブ）用のURL（earthのprobeURLと同じ）
+const probeURL = "status://earth"
+
+// DTNJsonRequest 地上局へ送るリクエスト（earthのbpsocket.DTNJsonRequestと同じ形式、/testdata/dtn/request.jsonで両方のテストが確認する）
 type DTNJsonRequest struct {
 	Version   int                 `json:"version"`
 	RequestID string              `json:"request_id"`
@@ -20,6 +29,18 @@ type DTNJsonRequest struct {
 	Body      string              `json:"body"`
 }
 
+// requestBatchType 複数のリクエストを1つのバンドルにまとめたエンベロープのtype（earthのbpsocket.RequestBatchTypeと同じ）
+const requestBatchType = "request_batch"
+
+// DTNJsonRequestBatch 1つのバンドルにまとめて地上局へ送るリクエスト（/testdata/dtn/request_batch.jsonで両方のテストが確認する）
+// 地上局はまとめたリクエストを1つずつ処理し、レスポンスはリクエストごとに別のバンドルで返す
+type DTNJsonRequestBatch struct {
+	Version  int               `json:"version"`
+	Type     string            `json:"type"`
+	Requests []json.RawMessage `json:"requests"`
+}
+
+// DTNJsonResponse 地上局から届くレスポンス（earthのBpResponseと同じ形式、/testdata/dtn/response.jsonで両方のテストが確認する）
 type DTNJsonResponse struct {
 	Version       int                 `json:"version"`
 	RequestID     string              `json:"request_id"`
@@ -28,6 +49,13 @@ type DTNJsonResponse struct {
 	Body          string              `json:"body"`
 	ContentType   string              `json:"content_type"`
 	ContentLength int64               `json:"content_length"`
+	// FinalURL リダイレクトをたどった後のURL（地上局が送った場合のみ）
+	FinalURL string `json:"final_url,omitempty"`
+	// ETag・LastModified 転送先のバリデーター（ヘッダーにない場合はヘッダーに補う）
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// SuggestedTTL 地上局が勧めるキャッシュの期間（秒）。転送先がCache-Controlを付けていない場合にmax-ageとして使う
+	SuggestedTTL int64 `json:"suggested_ttl,omitempty"`
 }
 
 func NewDTNJsonRequest(reqID string, breq *model.BpRequest) *DTNJsonRequest {
@@ -53,8 +81,22 @@ func ConvertToBpResponse(dtnResp *DTNJsonResponse) (*model.BpResponse, error) {
 			httpHeader.Add(k, hVal)
 		}
 	}
+	// どのURLのレスポンスかはX-Original-URLで判断するため、ない場合はfinal_urlで補う
+	if httpHeader.Get(originalURLHeader) == "" && dtnResp.FinalURL != "" {
+		httpHeader.Set(originalURLHeader, dtnResp.FinalURL)
+	}
+	if httpHeader.Get("ETag") == "" && dtnResp.ETag != "" {
+		httpHeader.Set("ETag", dtnResp.ETag)
+	}
+	if httpHeader.Get("Last-Modified") == "" && dtnResp.LastModified != "" {
+		httpHeader.Set("Last-Modified", dtnResp.LastModified)
+	}
+	if httpHeader.Get("Cache-Control") == "" && dtnResp.SuggestedTTL > 0 {
+		httpHeader.Set("Cache-Control", "max-age="+strconv.FormatInt(dtnResp.SuggestedTTL, 10))
+	}
 
 	return &model.BpResponse{
+		RequestID:     dtnResp.RequestID,
 		StatusCode:    dtnResp.StatusCode,
 		Headers:       httpHeader,
 		Body:          decodedBodyBytes,
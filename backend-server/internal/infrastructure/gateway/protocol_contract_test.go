@@ -0,0 +1,170 @@
+// protocol_contract_test.go - 地上局（earth）とやり取りするJSONの形式のテスト
+// /testdata/dtn のファイルはearth側のテストでも読むため、形式を変える場合は両方のテストを通す
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// contractFile リポジトリ直下のtestdata/dtnにある、バックエンドと地上局で共有するJSON
+func contractFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "testdata", "dtn", name))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	return data
+}
+
+// sameJSON キーの順序や空白によらず、同じJSONかを返す
+func sameJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestDTNJsonRequestContract(t *testing.T) {
+	breq := &model.BpRequest{
+		Method: http.MethodPost,
+		URL:    "https://example.com/form?q=1",
+		Headers: map[string][]string{
+			"Accept-Language": {"ja", "en;q=0.8"},
+			"Content-Type":    {"application/x-www-form-urlencoded"},
+		},
+		Body: []byte("a=1&b=\x00\xff"),
+	}
+	data, err := json.Marshal(NewDTNJsonRequest("6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b", breq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := contractFile(t, "request.json"); !sameJSON(t, data, want) {
+		t.Errorf("request bundle drifted from testdata/dtn/request.json:\ngot  %s\nwant %s", data, want)
+	}
+}
+
+// TestGatewaysSendOriginalURL 設定のhost・portに書き換えず、クライアントが求めたURL（スキーム・ホスト・パス・クエリ）のまま地上局へ送る
+// 地上局がtestdata/dtn/request_https.jsonからURLを取り出せることはearth側のテストで確認する
+func TestGatewaysSendOriginalURL(t *testing.T) {
+	const reqID = "3c1d9e7a-5b2f-4c8d-a6e1-9f0b2c3d4e5f"
+	breq := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/a?b=c", Headers: map[string][]string{"Accept": {"text/html"}}}
+
+	t.Run("bp_socket", func(t *testing.T) {
+		// 地上局の代わりに、送ったバンドルだけを受け取る
+		conn := newLoopbackConn()
+		g := newBpSocketGateway(conn, 20*time.Millisecond, nil)
+		t.Cleanup(func() { g.Close() })
+		sent := *breq
+		sent.RequestID = reqID
+		if _, err := g.ProxyRequest(context.Background(), &sent, gateway_interface.ProxyOptions{}); !errors.Is(err, gateway_interface.ErrTimeout) {
+			t.Fatalf("Expected ErrTimeout without an earth station, got %v", err)
+		}
+		bundle := <-conn.outbox
+		if want := contractFile(t, "request_https.json"); !sameJSON(t, bundle, want) {
+			t.Errorf("request bundle drifted from testdata/dtn/request_https.json:\ngot  %s\nwant %s", bundle, want)
+		}
+	})
+
+	t.Run("ion_cli", func(t *testing.T) {
+		// 設定のhost・portを渡しても、URLは変えない
+		g := openIonCLIGateway(newScriptedRunner(), DefaultEIDs, "localhost", 8081, 5*time.Second, nil)
+		t.Cleanup(func() { g.Close() })
+		resp, err := g.ProxyRequest(context.Background(), breq, gateway_interface.ProxyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body) != "https://example.com/a?b=c " {
+			t.Errorf("Expected the original URL to be sent, got %q", resp.Body)
+		}
+	})
+}
+
+func TestDTNJsonResponseContract(t *testing.T) {
+	var dtnResp DTNJsonResponse
+	if err := json.Unmarshal(contractFile(t, "response.json"), &dtnResp); err != nil {
+		t.Fatal(err)
+	}
+	if dtnResp.Version != protocolVersion || dtnResp.RequestID != "6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b" {
+		t.Errorf("unexpected version or request_id: %d %q", dtnResp.Version, dtnResp.RequestID)
+	}
+
+	resp, err := ConvertToBpResponse(&dtnResp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(resp.Body) != "<h1>hi</h1>" || resp.ContentLength != 11 {
+		t.Errorf("unexpected response: %d %q %d", resp.StatusCode, resp.Body, resp.ContentLength)
+	}
+	header := http.Header(resp.Headers)
+	for key, want := range map[string]string{
+		"Content-Type":   "text/html; charset=utf-8",
+		"X-Original-URL": "https://example.com/page",
+		"ETag":           `"v1"`,
+		"Last-Modified":  "Mon, 12 Oct 2026 09:00:00 GMT",
+		// 転送先がCache-Controlを付けていないため、地上局が勧める期間を使う
+		"Cache-Control": "max-age=3600",
+	} {
+		if got := header.Get(key); got != want {
+			t.Errorf("expected %s %q, got %q", key, want, got)
+		}
+	}
+
+	// 地上局が送ったフィールドをすべて読む（未知のフィールドがあれば形式がずれている）
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(contractFile(t, "response.json"), &fields); err != nil {
+		t.Fatal(err)
+	}
+	known := make(map[string]bool)
+	respType := reflect.TypeFor[DTNJsonResponse]()
+	for i := range respType.NumField() {
+		name, _, _ := strings.Cut(respType.Field(i).Tag.Get("json"), ",")
+		known[name] = true
+	}
+	for name := range fields {
+		if !known[name] {
+			t.Errorf("response.json has a field the backend does not read: %s", name)
+		}
+	}
+}
+
+func TestDTNJsonRequestBatchContract(t *testing.T) {
+	var requests []json.RawMessage
+	for _, r := range []struct {
+		id      string
+		url     string
+		headers map[string][]string
+	}{
+		{"6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b", "https://example.com/page", map[string][]string{"Accept-Language": {"ja"}}},
+		{"0b7e4d1a-3c5f-4a2b-8e9d-7f6a5b4c3d2e", "https://example.com/style.css", nil},
+	} {
+		data, err := json.Marshal(NewDTNJsonRequest(r.id, &model.BpRequest{Method: http.MethodGet, URL: r.url, Headers: r.headers}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, data)
+	}
+	data, err := json.Marshal(DTNJsonRequestBatch{Version: protocolVersion, Type: requestBatchType, Requests: requests})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := contractFile(t, "request_batch.json"); !sameJSON(t, data, want) {
+		t.Errorf("request batch bundle drifted from testdata/dtn/request_batch.json:\ngot  %s\nwant %s", data, want)
+	}
+}
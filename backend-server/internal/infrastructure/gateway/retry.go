@@ -0,0 +1,102 @@
+// retry.go - バンドルを送れなかった一時的な失敗（bpsendfileの異常終了、ソケットのENOBUFSなど）を間隔を空けて送り直す
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy バンドルの送信に一時的に失敗したときの送り直し方
+// 送り直すのは送信側の失敗だけで、送った後のレスポンス待ちのタイムアウトは送り直さない（同じリクエストを二重に送らない）
+// 送信に失敗したバンドルが実際には届いていた場合も、同じrequest_idで待っているため、重複したレスポンスは待っていないレスポンスとして扱われる
+type RetryPolicy struct {
+	Attempts  int           // 送る回数の上限（最初の送信を含む。1以下は送り直さない）
+	BaseDelay time.Duration // 1回目の送り直しまでの待ち時間（送り直すたびに2倍にする）
+	MaxDelay  time.Duration // 待ち時間の上限（0以下は上限なし）
+	Jitter    float64       // 待ち時間をずらす割合（0.2なら±20%、複数のワーカーが同時に送り直さないように）
+}
+
+// Delay retry回目（1から）の送り直しまでの待ち時間。randomは0以上1未満の乱数で、Jitterの分だけずらす
+func (p RetryPolicy) Delay(retry int, random float64) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+	if jitter := min(max(p.Jitter, 0), 1); jitter > 0 {
+		delay += time.Duration(float64(delay) * jitter * (2*random - 1))
+	}
+	return delay
+}
+
+// transientSendError 送り直せば送れる可能性がある送信の失敗
+type transientSendError struct {
+	err error
+}
+
+func (e *transientSendError) Error() string { return e.err.Error() }
+func (e *transientSendError) Unwrap() error { return e.err }
+
+// transientSend errを送り直せる失敗として印を付ける
+func transientSend(err error) error {
+	return &transientSendError{err: err}
+}
+
+// isTransientSend errが送り直せる失敗か
+func isTransientSend(err error) bool {
+	var transient *transientSendError
+	return errors.As(err, &transient)
+}
+
+// sendRetrier RetryPolicyに従ってsendを呼び直す（テストでは待ち方と乱数を差し替える）
+type sendRetrier struct {
+	policy RetryPolicy
+	sleep  func(ctx context.Context, d time.Duration) error
+	random func() float64
+}
+
+func newSendRetrier(policy RetryPolicy) sendRetrier {
+	return sendRetrier{policy: policy, sleep: sleepContext, random: rand.Float64}
+}
+
+// do sendが送り直せる失敗を返す間、policyの回数まで間隔を空けて呼び直す
+// 送り直した後も失敗した場合は、送った回数をエラーに含める。待っている間にctxが終わった場合は最後の失敗を返す
+func (r sendRetrier) do(ctx context.Context, logPrefix string, send func() error) error {
+	attempts := max(r.policy.Attempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if !isTransientSend(err) || attempt >= attempts || ctx.Err() != nil {
+			if attempt > 1 {
+				return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+			}
+			return err
+		}
+
+		delay := r.policy.Delay(attempt, r.random())
+		log.Printf("%s Send failed (attempt %d/%d), retrying in %v: %v", logPrefix, attempt, attempts, delay, err)
+		if sleepErr := r.sleep(ctx, delay); sleepErr != nil {
+			return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+		}
+	}
+}
+
+// sleepContext dだけ待つ（ctxが終わった場合はそのエラーを返す）
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
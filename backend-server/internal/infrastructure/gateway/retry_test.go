@@ -0,0 +1,263 @@
+// retry_test.go - 送信の一時的な失敗を間隔を空けて送り直し、レスポンス待ちのタイムアウトは送り直さないことのテスト
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway/bpsocket"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Attempts: 8, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000, 1000}
+	for i, w := range want {
+		if got := policy.Delay(i+1, 0.5); got != w*time.Millisecond {
+			t.Errorf("retry %d: expected %v, got %v", i+1, w*time.Millisecond, got)
+		}
+	}
+
+	// ジッターは待ち時間の前後にずらす
+	policy.Jitter = 0.5
+	for random, want := range map[float64]time.Duration{0: 50 * time.Millisecond, 0.5: 100 * time.Millisecond, 0.75: 125 * time.Millisecond} {
+		if got := policy.Delay(1, random); got != want {
+			t.Errorf("random %v: expected %v, got %v", random, want, got)
+		}
+	}
+}
+
+// recordingRetrier 待たずに、待つはずだった時間を記録するsendRetrier
+func recordingRetrier(policy RetryPolicy, sleeps *[]time.Duration) sendRetrier {
+	r := newSendRetrier(policy)
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		*sleeps = append(*sleeps, d)
+		return ctx.Err()
+	}
+	r.random = func() float64 { return 0.5 }
+	return r
+}
+
+func TestSendRetrier(t *testing.T) {
+	policy := RetryPolicy{Attempts: 4, BaseDelay: 10 * time.Millisecond, MaxDelay: 25 * time.Millisecond}
+	busy := transientSend(errors.New("sendto: no buffer space"))
+	tooLarge := errors.New("bundle size exceeds max")
+
+	tests := []struct {
+		name     string
+		script   []error // sendが順に返すエラー
+		sends    int
+		sleeps   []time.Duration
+		wantErr  error
+		attempts string // 諦めた場合のエラーに含まれる回数
+	}{
+		{name: "succeeds after transient failures", script: []error{busy, busy, nil}, sends: 3, sleeps: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}},
+		{name: "gives up after the attempts", script: []error{busy, busy, busy, busy}, sends: 4, sleeps: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}, wantErr: busy, attempts: "gave up after 4 attempts"},
+		{name: "permanent failure is not retried", script: []error{tooLarge}, sends: 1, wantErr: tooLarge},
+		{name: "permanent failure after a retry", script: []error{busy, tooLarge}, sends: 2, sleeps: []time.Duration{10 * time.Millisecond}, wantErr: tooLarge, attempts: "gave up after 2 attempts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sleeps []time.Duration
+			r := recordingRetrier(policy, &sleeps)
+			sends := 0
+			err := r.do(context.Background(), "[Test]", func() error {
+				err := tt.script[sends]
+				sends++
+				return err
+			})
+			if sends != tt.sends {
+				t.Errorf("Expected %d sends, got %d", tt.sends, sends)
+			}
+			if fmt.Sprint(sleeps) != fmt.Sprint(tt.sleeps) {
+				t.Errorf("Expected backoff %v, got %v", tt.sleeps, sleeps)
+			}
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Expected success, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.attempts != "" && !strings.Contains(err.Error(), tt.attempts) {
+				t.Errorf("Expected the error to report %q, got %v", tt.attempts, err)
+			}
+		})
+	}
+
+	// 待っている間に呼び出し元が止めた場合は、それ以上送らない
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newSendRetrier(RetryPolicy{Attempts: 5, BaseDelay: time.Hour})
+	sends := 0
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := r.do(ctx, "[Test]", func() error { sends++; return busy }); !errors.Is(err, busy) || sends != 1 {
+		t.Errorf("Expected to stop waiting on cancel after 1 send, got %d sends: %v", sends, err)
+	}
+}
+
+// flakyRunner bpsendfileが最初のfailures回だけ異常終了する（それ以外はrunnerで実行する）
+type flakyRunner struct {
+	commandRunner
+
+	mu       sync.Mutex
+	failures int
+	sends    int
+}
+
+func (r *flakyRunner) Run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	if name == "bpsendfile" {
+		r.mu.Lock()
+		r.sends++
+		fail := r.sends <= r.failures
+		r.mu.Unlock()
+		if fail {
+			return []byte("bpsendfile: can't send bundle"), errors.New("exit status 1")
+		}
+	}
+	return r.commandRunner.Run(ctx, dir, name, args...)
+}
+
+func (r *flakyRunner) Sends() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sends
+}
+
+func TestIonCLIGatewayRetriesSend(t *testing.T) {
+	runner := &flakyRunner{commandRunner: newScriptedRunner(), failures: 2}
+	g := newIonCLIGateway(runner, t.TempDir(), DefaultEIDs, 5*time.Second, nil)
+	defer g.Close()
+	var sleeps []time.Duration
+	g.retry = recordingRetrier(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond}, &sleeps)
+
+	resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/busy"}, gateway_interface.ProxyOptions{})
+	if err != nil {
+		t.Fatalf("Expected the request to be sent on the third attempt, got %v", err)
+	}
+	if string(resp.Body) != "https://example.com/busy " || runner.Sends() != 3 {
+		t.Errorf("Unexpected response %q after %d sends", resp.Body, runner.Sends())
+	}
+	if fmt.Sprint(sleeps) != "[1ms 2ms]" {
+		t.Errorf("Expected backoff [1ms 2ms], got %v", sleeps)
+	}
+
+	// 送り直しても送れない場合は、送った回数を含めて返す
+	runner.mu.Lock()
+	runner.failures = 10
+	runner.mu.Unlock()
+	_, err = g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/busy"}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrSendFailed) || !strings.Contains(err.Error(), "gave up after 3 attempts") {
+		t.Errorf("Expected ErrSendFailed after 3 attempts, got %v", err)
+	}
+}
+
+// countingRunner bpsendfileを実行した回数を数える
+type countingRunner struct {
+	commandRunner
+
+	mu    sync.Mutex
+	sends int
+}
+
+func (r *countingRunner) Run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	if name == "bpsendfile" {
+		r.mu.Lock()
+		r.sends++
+		r.mu.Unlock()
+	}
+	return r.commandRunner.Run(ctx, dir, name, args...)
+}
+
+func TestIonCLIGatewayDoesNotResendAfterTimeout(t *testing.T) {
+	runner := &countingRunner{commandRunner: silentRunner{}}
+	g := newIonCLIGateway(runner, t.TempDir(), DefaultEIDs, 30*time.Millisecond, nil)
+	defer g.Close()
+	g.SetRetryPolicy(RetryPolicy{Attempts: 5, BaseDelay: time.Millisecond})
+
+	// バンドルは送れたがレスポンスが届かない場合は、二重に送らない
+	_, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/lost"}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	if runner.sends != 1 {
+		t.Errorf("Expected the bundle to be sent once, got %d", runner.sends)
+	}
+}
+
+// uninstalledRunner IONのコマンドがインストールされていない（bpsendfileを実行できない）環境
+type uninstalledRunner struct {
+	missingCommandRunner
+}
+
+func (r uninstalledRunner) Run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	if name == "bpsendfile" {
+		return nil, exec.ErrNotFound
+	}
+	return r.missingCommandRunner.Run(ctx, dir, name, args...)
+}
+
+func TestIonCLIGatewayMissingCommandIsNotRetried(t *testing.T) {
+	g := newIonCLIGateway(uninstalledRunner{missingCommandRunner{newScriptedRunner()}}, t.TempDir(), DefaultEIDs, time.Second, nil)
+	defer g.Close()
+	var sleeps []time.Duration
+	g.retry = recordingRetrier(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond}, &sleeps)
+
+	_, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/"}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrLinkDown) || len(sleeps) != 0 {
+		t.Errorf("Expected ErrLinkDown without a retry, got %v after %v", err, sleeps)
+	}
+}
+
+// busyConn 最初のfailures回の送信で、送信キューに空きがない（ENOBUFS）エラーを返す
+type busyConn struct {
+	*loopbackConn
+
+	mu       sync.Mutex
+	failures int
+	sends    int
+}
+
+func (c *busyConn) Send(ctx context.Context, data []byte) error {
+	c.mu.Lock()
+	c.sends++
+	fail := c.sends <= c.failures
+	c.mu.Unlock()
+	if fail {
+		return fmt.Errorf("sendto ipn:2.1 failed: %w", bpsocket.ErrNoBufferSpace)
+	}
+	return c.loopbackConn.Send(ctx, data)
+}
+
+func TestBpSocketGatewayRetriesNoBufferSpace(t *testing.T) {
+	origin := newOrigin(t)
+	loopback := newLoopbackConn()
+	runEarth(t, loopback, nil)
+	conn := &busyConn{loopbackConn: loopback, failures: 1}
+	g := newBpSocketGateway(conn, 5*time.Second, nil)
+	t.Cleanup(func() { g.Close() })
+	var sleeps []time.Duration
+	g.retry = recordingRetrier(RetryPolicy{Attempts: 2, BaseDelay: time.Millisecond}, &sleeps)
+
+	resp, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/page"}, gateway_interface.ProxyOptions{})
+	if err != nil {
+		t.Fatalf("Expected the bundle to be sent on the second attempt, got %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(sleeps) != 1 {
+		t.Errorf("Expected 200 after one retry, got %d after %v", resp.StatusCode, sleeps)
+	}
+}
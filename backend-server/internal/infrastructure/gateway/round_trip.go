@@ -0,0 +1,146 @@
+// round_trip.go - 最近のDTNの往復時間を記録し、レスポンスを待つ時間とレスポンスが届く時刻の目安に使う
+package gateway
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// defaultRoundTripWindow RoundTripPolicy.Windowを指定しない場合に覚えておく往復時間の数
+const defaultRoundTripWindow = 100
+
+// RoundTripPolicy 往復時間の記録と、記録からレスポンスを待つ時間を決める設定
+// Factorが0以下の場合は記録だけして、待つ時間は設定のtimeoutのままにする
+type RoundTripPolicy struct {
+	Window     int           // 覚えておく最近の往復時間の数（0以下はdefaultRoundTripWindow）
+	MinSamples int           // 待つ時間を記録から決めるのに必要な往復時間の数（これより少ない間は設定のtimeout）
+	Factor     float64       // 待つ時間をp95の何倍にするか
+	Min        time.Duration // 記録から決めた待つ時間の下限
+	Max        time.Duration // 記録から決めた待つ時間の上限（0以下は上限なし）
+}
+
+// RoundTripStats 記録した往復時間の分位数と、次のリクエストでレスポンスを待つ時間
+type RoundTripStats struct {
+	Samples int           `json:"samples"`
+	P50     time.Duration `json:"p50"`
+	P95     time.Duration `json:"p95"`
+	Timeout time.Duration `json:"timeout"` // 記録から決めていない場合は0
+}
+
+// RoundTripTracker 送ったリクエストのレスポンスが届くまでの時間（送信から、request_idで対応付けたレスポンスまで）を最近のWindow件だけ覚える
+// 接続できる時間帯（コンタクト）によって往復時間が数秒から数時間まで変わるため、固定のtimeoutの代わりに最近の往復時間から待つ時間を決める
+// nilのRoundTripTrackerのメソッドは記録せず、待つ時間は設定のtimeoutのままにする
+type RoundTripTracker struct {
+	policy  RoundTripPolicy
+	metrics *metrics.Metrics
+
+	mu      sync.Mutex
+	samples []time.Duration // 古い順に最大Window件のリングバッファ
+	next    int             // 次に上書きする位置（samplesが埋まった後）
+}
+
+var _ model.RoundTripSource = (*RoundTripTracker)(nil)
+
+// NewRoundTripTracker policyで往復時間を記録するRoundTripTrackerを作る
+func NewRoundTripTracker(policy RoundTripPolicy, metrics *metrics.Metrics) *RoundTripTracker {
+	if policy.Window <= 0 {
+		policy.Window = defaultRoundTripWindow
+	}
+	return &RoundTripTracker{
+		policy:  policy,
+		metrics: metrics,
+		samples: make([]time.Duration, 0, policy.Window),
+	}
+}
+
+// Observe レスポンスが届いたリクエストの往復時間を記録する
+func (t *RoundTripTracker) Observe(rtt time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if len(t.samples) < t.policy.Window {
+		t.samples = append(t.samples, rtt)
+	} else {
+		t.samples[t.next] = rtt
+		t.next = (t.next + 1) % t.policy.Window
+	}
+	stats := t.statsLocked()
+	t.mu.Unlock()
+
+	t.metrics.SetRoundTripEstimate(stats.P50, stats.P95, stats.Timeout)
+}
+
+// Timeout 次のリクエストでレスポンスを待つ時間
+// 往復時間がMinSamples件以上あればp95のFactor倍をMin・Maxに収めた時間、それ以外はstatic（設定のtimeout）を返す
+func (t *RoundTripTracker) Timeout(static time.Duration) time.Duration {
+	if t == nil {
+		return static
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if timeout := t.statsLocked().Timeout; timeout > 0 {
+		return timeout
+	}
+	return static
+}
+
+// EstimatedRoundTrip 最近の往復時間のp50（プレースホルダーなどのレスポンスが届く時刻の目安に使う、model.RoundTripSource）
+// MinSamples件に満たない場合はfalseを返す
+func (t *RoundTripTracker) EstimatedRoundTrip() (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) == 0 || len(t.samples) < t.policy.MinSamples {
+		return 0, false
+	}
+	return t.statsLocked().P50, true
+}
+
+// Stats 記録した往復時間の数と分位数、記録から決めた待つ時間
+func (t *RoundTripTracker) Stats() RoundTripStats {
+	if t == nil {
+		return RoundTripStats{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.statsLocked()
+}
+
+// statsLocked t.muを持って呼ぶ
+func (t *RoundTripTracker) statsLocked() RoundTripStats {
+	stats := RoundTripStats{Samples: len(t.samples)}
+	if len(t.samples) == 0 {
+		return stats
+	}
+	sorted := slices.Clone(t.samples)
+	slices.Sort(sorted)
+	stats.P50 = quantile(sorted, 0.50)
+	stats.P95 = quantile(sorted, 0.95)
+	if t.policy.Factor > 0 && len(t.samples) >= t.policy.MinSamples {
+		stats.Timeout = adaptiveTimeout(stats.P95, t.policy)
+	}
+	return stats
+}
+
+// quantile 昇順に並んだsortedのq分位数（nearest-rank）
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+// adaptiveTimeout p95のFactor倍をpolicyのMin・Maxに収めた待つ時間
+func adaptiveTimeout(p95 time.Duration, policy RoundTripPolicy) time.Duration {
+	timeout := max(time.Duration(float64(p95)*policy.Factor), policy.Min)
+	if policy.Max > 0 {
+		timeout = min(timeout, policy.Max)
+	}
+	return timeout
+}
@@ -0,0 +1,152 @@
+// round_trip_test.go - 往復時間の記録と、記録からレスポンスを待つ時間を決めることのテスト
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// observeSeconds 1秒からn秒までの往復時間を記録する
+func observeSeconds(tracker *RoundTripTracker, n int) {
+	for i := 1; i <= n; i++ {
+		tracker.Observe(time.Duration(i) * time.Second)
+	}
+}
+
+func TestRoundTripTrackerQuantiles(t *testing.T) {
+	tracker := NewRoundTripTracker(RoundTripPolicy{}, nil)
+	if stats := tracker.Stats(); stats != (RoundTripStats{}) {
+		t.Errorf("Expected no samples, got %+v", stats)
+	}
+
+	observeSeconds(tracker, 20)
+	stats := tracker.Stats()
+	if stats.Samples != 20 || stats.P50 != 10*time.Second || stats.P95 != 19*time.Second {
+		t.Errorf("Expected p50 10s and p95 19s of 20 samples, got %+v", stats)
+	}
+	// Factorがない場合は記録だけして、待つ時間は設定のtimeoutのまま
+	if stats.Timeout != 0 || tracker.Timeout(3*time.Minute) != 3*time.Minute {
+		t.Errorf("Expected the static timeout without a factor, got %+v", stats)
+	}
+}
+
+func TestRoundTripTrackerWindow(t *testing.T) {
+	tracker := NewRoundTripTracker(RoundTripPolicy{Window: 10}, nil)
+	observeSeconds(tracker, 10)
+	// 古い往復時間は新しいものに置き換わる（コンタクトが始まって往復時間が短くなった）
+	for range 10 {
+		tracker.Observe(100 * time.Millisecond)
+	}
+	if stats := tracker.Stats(); stats.Samples != 10 || stats.P95 != 100*time.Millisecond {
+		t.Errorf("Expected only the recent samples, got %+v", stats)
+	}
+}
+
+func TestRoundTripTrackerAdaptiveTimeout(t *testing.T) {
+	policy := RoundTripPolicy{MinSamples: 5, Factor: 2, Min: 10 * time.Second, Max: time.Minute}
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    time.Duration
+	}{
+		{name: "not enough samples", samples: []time.Duration{time.Second, time.Second}, want: 3 * time.Minute},
+		{name: "p95 times factor", samples: []time.Duration{5 * time.Second, 6 * time.Second, 7 * time.Second, 8 * time.Second, 12 * time.Second}, want: 24 * time.Second},
+		{name: "clamped to min", samples: []time.Duration{time.Second, time.Second, time.Second, time.Second, 2 * time.Second}, want: 10 * time.Second},
+		{name: "clamped to max", samples: []time.Duration{time.Minute, time.Minute, time.Minute, time.Minute, time.Hour}, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewRoundTripTracker(policy, nil)
+			for _, d := range tt.samples {
+				tracker.Observe(d)
+			}
+			if got := tracker.Timeout(3 * time.Minute); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// 上限がない場合はp95のFactor倍
+	tracker := NewRoundTripTracker(RoundTripPolicy{Factor: 1.5}, nil)
+	tracker.Observe(2 * time.Hour)
+	if got := tracker.Timeout(3 * time.Minute); got != 3*time.Hour {
+		t.Errorf("Expected 3h without a max, got %v", got)
+	}
+
+	// nilのRoundTripTrackerは設定のtimeoutのまま
+	var none *RoundTripTracker
+	none.Observe(time.Second)
+	if got := none.Timeout(3 * time.Minute); got != 3*time.Minute {
+		t.Errorf("Expected the static timeout from a nil tracker, got %v", got)
+	}
+}
+
+func TestRoundTripTrackerEstimate(t *testing.T) {
+	tracker := NewRoundTripTracker(RoundTripPolicy{MinSamples: 3}, nil)
+	tracker.Observe(time.Minute)
+	if _, ok := tracker.EstimatedRoundTrip(); ok {
+		t.Error("Expected no estimate before MinSamples")
+	}
+	tracker.Observe(2 * time.Minute)
+	tracker.Observe(3 * time.Minute)
+
+	// プレースホルダーの到着予定は、同じ記録のp50を使う
+	estimate := model.DeliveryEstimate{RoundTrip: time.Hour, Observed: tracker, Concurrency: 1}
+	now := time.Now()
+	if got := estimate.EstimatedAt(now, now, 1); !got.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("Expected the p50 to be used for the ETA, got %v", got.Sub(now))
+	}
+}
+
+func TestBpSocketGatewayAdaptiveTimeout(t *testing.T) {
+	origin := newOrigin(t)
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := origin.URL + "/slow"
+	g, _ := newLoopbackGateway(t, 5*time.Second, func(req *DTNJsonRequest) time.Duration {
+		if req.URL == slow {
+			return 500 * time.Millisecond
+		}
+		return 0
+	})
+	tracker := NewRoundTripTracker(RoundTripPolicy{MinSamples: 3, Factor: 2, Min: 100 * time.Millisecond, Max: 100 * time.Millisecond}, m)
+	g.SetRoundTripTracker(tracker)
+
+	// レスポンスが届いた往復時間を記録する
+	for range 3 {
+		if _, err := g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: origin.URL + "/fast"}, gateway_interface.ProxyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := tracker.Stats()
+	if stats.Samples != 3 || stats.Timeout != 100*time.Millisecond {
+		t.Fatalf("Expected 3 samples and a 100ms timeout, got %+v", stats)
+	}
+	if got := gaugeValue(t, reg, "bp_proxy_gateway_response_timeout_seconds"); got != 0.1 {
+		t.Errorf("Expected the adaptive timeout in the metrics, got %v", got)
+	}
+
+	// 設定のtimeout（5s）ではなく、記録から決めた時間で待つのをやめる
+	start := time.Now()
+	_, err = g.ProxyRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: slow}, gateway_interface.ProxyOptions{})
+	if !errors.Is(err, gateway_interface.ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the adaptive timeout instead of 5s, waited %v", elapsed)
+	}
+	if tracker.Stats().Samples != 3 {
+		t.Errorf("Expected the timed-out request not to be recorded, got %+v", tracker.Stats())
+	}
+}
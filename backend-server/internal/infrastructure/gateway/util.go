@@ -2,16 +2,168 @@
 package gateway
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
+
+	gateway_interface "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// メトリクスに記録するトランスポート名（設定のtransport_modeと同じ）
+const (
+	transportBpSocket = "bp_socket"
+	transportIonCLI   = "ion_cli"
+	transportLocal    = "local"
+	transportMock     = "mock"
 )
 
+// transportAliases 設定のtransport_modeで受け付ける別名（bp-socketのbpsocket、IONのファイル送受信のbpfile）
+var transportAliases = map[string]string{
+	"bpsocket": transportBpSocket,
+	"bpfile":   transportIonCLI,
+}
+
+// NormalizeTransportMode 設定のtransport_modeの別名を正式な名前にする（別名でない値はそのまま返す）
+func NormalizeTransportMode(mode string) string {
+	if canonical, ok := transportAliases[mode]; ok {
+		return canonical
+	}
+	return mode
+}
+
+// observeRoundTrip startからの往復時間をProxyRequestの結果（ok、timeout、error）とともに記録する
+func observeRoundTrip(m *metrics.Metrics, transport string, start time.Time, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, gateway_interface.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	m.ObserveRoundTrip(transport, result, time.Since(start))
+}
+
+// probeRoundTrip roundTripでprobeURLを送り、応答が届くまでの往復時間を返す
+// 地上局が200以外で応答した場合（プローブに対応していないなど）は、リンクは使えているがプローブとしては読めないためErrResponseCorruptでラップする
+func probeRoundTrip(ctx context.Context, roundTrip func(context.Context, *model.BpRequest, gateway_interface.Priority) (*model.BpResponse, error)) (time.Duration, error) {
+	start := time.Now()
+	resp, err := roundTrip(ctx, &model.BpRequest{Method: http.MethodGet, URL: probeURL}, gateway_interface.PriorityHigh)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	if resp.StatusCode != http.StatusOK {
+		return rtt, fmt.Errorf("%w: probe answered with status %d", gateway_interface.ErrResponseCorrupt, resp.StatusCode)
+	}
+	return rtt, nil
+}
+
+// awaitResponse バンドルを送ったリクエストのレスポンスをtimeoutまで待つ
+// 期限を過ぎた場合はErrTimeout、レスポンスを読めない場合はErrResponseCorruptでラップしたエラーを返す
+// 呼び出し元がキャンセルした場合はctxのエラーを返す
+func awaitResponse(ctx context.Context, respCh <-chan *DTNJsonResponse, timeout time.Duration) (*model.BpResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case dtnResp := <-respCh:
+		resp, err := ConvertToBpResponse(dtnResp)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", gateway_interface.ErrResponseCorrupt, err)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %w", gateway_interface.ErrTimeout, ctx.Err())
+		}
+		return nil, fmt.Errorf("request cancelled: %w", ctx.Err())
+	}
+}
+
+// awaitRoundTrip 送ったリクエストのレスポンスを、trackerが決めた時間（決めていない場合はtimeout）まで待ち、届いた場合は往復時間をtrackerに記録する
+func awaitRoundTrip(ctx context.Context, respCh <-chan *DTNJsonResponse, timeout time.Duration, tracker *RoundTripTracker) (*model.BpResponse, error) {
+	sentAt := time.Now()
+	resp, err := awaitResponse(ctx, respCh, tracker.Timeout(timeout))
+	if err == nil {
+		tracker.Observe(time.Since(sentAt))
+	}
+	return resp, err
+}
+
+// responseWaiter レスポンスを待っているリクエストのURLと、レスポンスを受け取るチャンネル
+type responseWaiter struct {
+	url string
+	ch  chan *DTNJsonResponse
+}
+
+// sendFailure バンドルを送れなかったエラーをラップする
+// 呼び出し元の期限が送信中に過ぎた場合はErrTimeout、それ以外はErrSendFailedでラップする
+func sendFailure(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", gateway_interface.ErrTimeout, err)
+	}
+	return fmt.Errorf("%w: %w", gateway_interface.ErrSendFailed, err)
+}
+
+// registerResponseCh バンドルのrequest_idを決めて、レスポンスを受け取るチャンネルをresponseChsに登録する
+// ブラウザのリクエストID（X-Request-ID）があればそのまま使い、地上局のログと突き合わせられるようにする
+// 同じIDのリクエストが送信中の場合（クライアントがIDを使い回した場合など）は、レスポンスを取り違えないよう生成したIDを付け足す
+func registerResponseCh(responseChs *sync.Map, breq *model.BpRequest, ch chan *DTNJsonResponse) string {
+	waiter := &responseWaiter{url: breq.URL, ch: ch}
+	if breq.RequestID != "" {
+		if _, loaded := responseChs.LoadOrStore(breq.RequestID, waiter); !loaded {
+			return breq.RequestID
+		}
+		reqID := breq.RequestID + "." + generateID()
+		responseChs.Store(reqID, waiter)
+		return reqID
+	}
+	reqID := generateID()
+	responseChs.Store(reqID, waiter)
+	return reqID
+}
+
+// lookupResponseCh dtnRespを待っているリクエストのチャンネルを返す
+// 地上局は再帰的に取得したリンクのレスポンスにも元のリクエストのrequest_idを付けるため、
+// X-Original-URLが待っているリクエストのURLと違う場合は、待っているリクエストのないレスポンスとして扱う
+func lookupResponseCh(responseChs *sync.Map, dtnResp *DTNJsonResponse) (chan *DTNJsonResponse, bool) {
+	v, ok := responseChs.Load(dtnResp.RequestID)
+	if !ok {
+		return nil, false
+	}
+	waiter := v.(*responseWaiter)
+	if url := headerValue(dtnResp.Headers, originalURLHeader); url != "" && url != waiter.url {
+		return nil, false
+	}
+	return waiter.ch, true
+}
+
+// headerValue バンドルのヘッダーからkeyの最初の値を返す（JSONのヘッダー名は正規化されていないため、大文字小文字を区別しない）
+func headerValue(headers map[string][]string, key string) string {
+	for k, values := range headers {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// generateID バンドルのrequest_idに使うUUID（バージョン4）を生成する
 func generateID() string {
-	b := make([]byte, 8)
+	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
 		return fmt.Sprintf("%d", time.Now().UnixNano())
 	}
-	return hex.EncodeToString(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
 }
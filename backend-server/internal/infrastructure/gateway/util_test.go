@@ -0,0 +1,92 @@
+// util_test.go - バンドルのrequest_idの決め方のテスト
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+func TestRegisterResponseCh(t *testing.T) {
+	var responseChs sync.Map
+	breq := &model.BpRequest{Method: "GET", URL: "https://example.com/", RequestID: "browser-123"}
+
+	first := registerResponseCh(&responseChs, breq, make(chan *DTNJsonResponse, 1))
+	if first != "browser-123" {
+		t.Fatalf("expected the request ID to be used for the bundle, got %q", first)
+	}
+
+	// 同じIDのリクエストが送信中の場合は、取り違えないよう別のIDにする（元のIDは残す）
+	second := registerResponseCh(&responseChs, breq, make(chan *DTNJsonResponse, 1))
+	if second == first || !strings.HasPrefix(second, "browser-123.") {
+		t.Errorf("expected a distinct ID prefixed with the request ID, got %q", second)
+	}
+
+	// リクエストIDがない場合は生成する
+	generated := registerResponseCh(&responseChs, &model.BpRequest{URL: "https://example.com/"}, make(chan *DTNJsonResponse, 1))
+	if generated == "" || generated == first || generated == second {
+		t.Errorf("expected a generated ID, got %q", generated)
+	}
+	for _, id := range []string{first, second, generated} {
+		if _, ok := responseChs.Load(id); !ok {
+			t.Errorf("expected a response channel registered for %q", id)
+		}
+	}
+
+	data, err := json.Marshal(NewDTNJsonRequest(first, breq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"request_id":"browser-123"`) {
+		t.Errorf("expected the request ID in the bundle, got %s", data)
+	}
+}
+
+func TestLookupResponseCh(t *testing.T) {
+	var responseChs sync.Map
+	ch := make(chan *DTNJsonResponse, 1)
+	reqID := registerResponseCh(&responseChs, &model.BpRequest{Method: "GET", URL: "https://example.com/page", RequestID: "req-1"}, ch)
+
+	tests := []struct {
+		name    string
+		resp    *DTNJsonResponse
+		matched bool
+	}{
+		{"same URL", &DTNJsonResponse{RequestID: reqID, Headers: map[string][]string{"X-Original-URL": {"https://example.com/page"}}}, true},
+		// 地上局のエラーレスポンスにはX-Original-URLがない
+		{"no URL", &DTNJsonResponse{RequestID: reqID}, true},
+		// 再帰的に取得したリンクのレスポンスは元のリクエストと同じrequest_idで届く
+		{"prefetched link", &DTNJsonResponse{RequestID: reqID, Headers: map[string][]string{"X-Original-URL": {"https://example.com/other"}}}, false},
+		{"unknown ID", &DTNJsonResponse{RequestID: "req-2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := lookupResponseCh(&responseChs, tt.resp)
+			if ok != tt.matched || (ok && got != ch) {
+				t.Errorf("expected matched=%v, got %v", tt.matched, ok)
+			}
+		})
+	}
+}
+
+func TestConvertToBpResponseFinalURL(t *testing.T) {
+	resp, err := ConvertToBpResponse(&DTNJsonResponse{StatusCode: 200, FinalURL: "https://example.com/moved"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := http.Header(resp.Headers).Values("X-Original-URL"); len(got) != 1 || got[0] != "https://example.com/moved" {
+		t.Errorf("expected final_url to fill X-Original-URL, got %v", got)
+	}
+
+	resp, err = ConvertToBpResponse(&DTNJsonResponse{StatusCode: 200, FinalURL: "https://example.com/moved", Headers: map[string][]string{"X-Original-URL": {"https://example.com/old"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := http.Header(resp.Headers).Values("X-Original-URL"); len(got) != 1 || got[0] != "https://example.com/old" {
+		t.Errorf("expected X-Original-URL to be kept, got %v", got)
+	}
+}
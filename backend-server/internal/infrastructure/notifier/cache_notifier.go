@@ -0,0 +1,56 @@
+package notifier
+
+import (
+	"sync"
+)
+
+// CacheNotifier プロセス内のpub/sub（RequestHandlerなどが保存後にPublishし、/system/notifyが購読する）
+type CacheNotifier struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func NewCacheNotifier() *CacheNotifier {
+	return &CacheNotifier{
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Publish cacheKeyの購読者に通知する（購読者がいなければ何もしない）
+func (n *CacheNotifier) Publish(cacheKey string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.subscribers[cacheKey] {
+		// 通知済みで未読の場合は重ねて送らない（ブロックしない）
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribe cacheKeyの通知を受け取るチャネルと、購読を解除する関数を返す
+func (n *CacheNotifier) Subscribe(cacheKey string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	if n.subscribers[cacheKey] == nil {
+		n.subscribers[cacheKey] = make(map[chan struct{}]struct{})
+	}
+	n.subscribers[cacheKey][ch] = struct{}{}
+	n.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			delete(n.subscribers[cacheKey], ch)
+			if len(n.subscribers[cacheKey]) == 0 {
+				delete(n.subscribers, cacheKey)
+			}
+		})
+	}
+	return ch, unsubscribe
+}
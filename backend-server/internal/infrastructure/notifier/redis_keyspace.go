@@ -0,0 +1,51 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ListenKeyspaceNotifications Redisのキースペース通知を購読し、他のインスタンスが保存したキャッシュもlocalへPublishする
+// metaKeyPrefixはキャッシュのメタデータのキーの接頭辞（"bp:cache:meta:"、続く部分がキャッシュキー）
+// ctxが終了するまでブロックする
+func ListenKeyspaceNotifications(ctx context.Context, rclient *redis.Client, db int, metaKeyPrefix string, local *CacheNotifier) {
+	// キースペース通知は既定で無効のため、文字列コマンド（SET）の通知を有効にする
+	// マネージドRedisなどでCONFIGが禁止されている場合は、サーバー側で notify-keyspace-events に "K$" を含めておく
+	if err := rclient.ConfigSet(ctx, "notify-keyspace-events", "K$").Err(); err != nil {
+		log.Printf("[CacheNotifier] notify-keyspace-eventsを設定できませんでした（サーバー側の設定を使用します）: %v", err)
+	}
+
+	channelPrefix := fmt.Sprintf("__keyspace@%d__:", db)
+	pubsub := rclient.PSubscribe(ctx, channelPrefix+metaKeyPrefix+"*")
+	defer pubsub.Close()
+
+	log.Printf("[CacheNotifier] キースペース通知の購読を開始しました: %s%s*", channelPrefix, metaKeyPrefix)
+	defer log.Printf("[CacheNotifier] キースペース通知の購読を終了しました")
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if cacheKey, ok := cacheKeyFromKeyspaceEvent(msg.Channel, msg.Payload, channelPrefix+metaKeyPrefix); ok {
+				local.Publish(cacheKey)
+			}
+		}
+	}
+}
+
+// cacheKeyFromKeyspaceEvent メタデータのSET通知からキャッシュキーを取り出す
+func cacheKeyFromKeyspaceEvent(channel, event, prefix string) (string, bool) {
+	if event != "set" || !strings.HasPrefix(channel, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(channel, prefix), true
+}
@@ -3,10 +3,15 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
@@ -15,74 +20,143 @@ import (
 type BpRepository struct {
 	client   BpRepoClient
 	cacheDir string
+	// staleGrace 有効期限を過ぎたキャッシュを削除せずに保持する期間（0以下は有効期限で削除する）
+	// この間は期限切れ（ExpiresAtが過去）のレスポンスとして返し、Service層が返しながら更新を予約する
+	staleGrace time.Duration
+	// maxObjectSize 1件のキャッシュの大きさ（バイト）の上限。超えるレスポンスは保存しない（0以下は上限なし）
+	maxObjectSize int64
+	// maxCacheSize キャッシュ全体の容量（バイト）の上限。超えると最終アクセスの古いキャッシュから削除する（0以下は上限なし）
+	maxCacheSize int64
+
+	// cleanupMu DeleteExpiredCachesを1つずつ実行し、cleanupCursorとcleanupBudgetを守る
+	cleanupMu sync.Mutex
+	// cleanupCursor 前回のDeleteExpiredCachesが上限で止まったときの、ScanExpiredKeysのカーソル（0は最初から）
+	cleanupCursor uint64
+	// cleanupBudget DeleteExpiredCachesの1回で削除する期限切れキャッシュの上限（0以下は上限なし）
+	cleanupBudget int
+
+	// evictions, evictedBytes 容量を超えたために削除したキャッシュの数と大きさの合計（プロセスを起動してから、管理用APIで返す）
+	evictions    atomic.Int64
+	evictedBytes atomic.Int64
 }
 
-func NewBpRepository(client BpRepoClient, cacheDir string) *BpRepository {
+func NewBpRepository(client BpRepoClient, cacheDir string, staleGrace time.Duration, maxObjectSize, maxCacheSize int64) *BpRepository {
 	// キャッシュディレクトリが存在しない場合は作成
 	_ = os.MkdirAll(cacheDir, 0755)
 
 	return &BpRepository{
-		client:   client,
-		cacheDir: cacheDir,
+		client:        client,
+		cacheDir:      cacheDir,
+		staleGrace:    staleGrace,
+		maxObjectSize: maxObjectSize,
+		maxCacheSize:  maxCacheSize,
 	}
 }
 
 // GetResponse キャッシュからレスポンスを取得
+// 有効期限を過ぎても保持している間のキャッシュは、ExpiresAtが過去のレスポンスとして返す
 func (br *BpRepository) GetResponse(ctx context.Context, cacheKey string) (*model.BpResponse, bool, error) {
-	// Redisからメタデータを取得
-	metaKey := _getMetaKey(cacheKey)
-	metaData, err := br.client.GetMetaData(ctx, metaKey)
+	metadata, found := br.getValidMetadata(ctx, cacheKey)
+	if !found {
+		return nil, false, nil
+	}
+
+	// ファイルシステムからボディを読み込む
+	body, err := os.ReadFile(metadata.FilePath)
 	if err != nil {
-		return nil, false, nil // キャッシュミス
+		br.forgetMissingFile(ctx, cacheKey, err)
+		return nil, false, nil
 	}
 
-	// メタデータが空の場合はキャッシュミス
-	if len(metaData) == 0 {
+	// BpResponseを構築
+	resp := newCachedResponse(metadata)
+	resp.Body = body
+	return resp, true, nil
+}
+
+// GetResponseStream キャッシュからレスポンスを取得し、ボディはキャッシュファイルを開いたまま返す
+// 大きいボディをメモリに読み込まずにクライアントへコピーするために使う
+func (br *BpRepository) GetResponseStream(ctx context.Context, cacheKey string) (*model.BpResponse, bool, error) {
+	metadata, found := br.getValidMetadata(ctx, cacheKey)
+	if !found {
 		return nil, false, nil
 	}
 
-	// メタデータをデコード
-	var metadata model.CacheMetadata
-	err = json.Unmarshal(metaData, &metadata)
+	file, err := os.Open(metadata.FilePath)
 	if err != nil {
-		// JSONデコードエラーの場合はキャッシュミスとして扱う（破損したキャッシュ）
-		log.Printf("[BpRepository] GetResponse JSONデコードエラー: %v, metaKey=%s", err, metaKey)
+		br.forgetMissingFile(ctx, cacheKey, err)
 		return nil, false, nil
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("failed to stat cache file: %w", err)
+	}
+
+	// ContentLengthはメタデータ（転送先のヘッダー）ではなく、実際に読めるファイルの大きさにする
+	resp := newCachedResponse(metadata)
+	resp.BodyStream = file
+	resp.ContentLength = info.Size()
+	return resp, true, nil
+}
+
+// getValidMetadata 有効期限内、または期限切れでも保持している間のキャッシュのメタデータを取得する
+// Redisのエラー・壊れたメタデータはキャッシュミスとして扱い、保持する期間も過ぎたキャッシュは削除してキャッシュミスにする
+func (br *BpRepository) getValidMetadata(ctx context.Context, cacheKey string) (*model.CacheMetadata, bool) {
+	metadata, found, err := br.getMetadata(ctx, cacheKey)
+	if err != nil || !found {
+		return nil, false
+	}
 
 	// 有効期限チェック
-	if metadata.IsExpired() {
-		// TTLが切れている場合は削除
+	if metadata.IsEvicted(br.staleGrace) {
+		// 保持する期間も切れている場合は削除
 		_ = os.Remove(metadata.FilePath)
-		metaKey := _getMetaKey(cacheKey)
-		_ = br.client.DeleteMetaData(ctx, metaKey)
-		return nil, false, nil
+		_ = br.client.DeleteMetaData(ctx, _getMetaKey(cacheKey))
+		_ = br.client.RemoveCacheIndex(ctx, cacheKey)
+		return nil, false
 	}
 
-	// ファイルシステムからボディを読み込む
-	body, err := os.ReadFile(metadata.FilePath)
-	if err != nil {
-		// ファイルが存在しない場合はRedisからも削除（アクセス時のクリア）
-		if os.IsNotExist(err) {
-			metaKey := _getMetaKey(cacheKey)
-			_ = br.client.DeleteMetaData(ctx, metaKey)
+	// 容量を超えたときに最近使われていないキャッシュから削除するため、最終アクセス時刻を記録する
+	if br.maxCacheSize > 0 {
+		if err := br.client.TouchCacheIndex(ctx, cacheKey, time.Now()); err != nil {
+			log.Printf("[BpRepository] 最終アクセス時刻の記録に失敗しました (key: %s): %v", cacheKey, err)
 		}
-		return nil, false, nil
 	}
+	return metadata, true
+}
 
-	// BpResponseを構築
+// forgetMissingFile キャッシュファイルが存在しない場合はRedisからも削除する（アクセス時のクリア）
+func (br *BpRepository) forgetMissingFile(ctx context.Context, cacheKey string, err error) {
+	if os.IsNotExist(err) {
+		_ = br.client.DeleteMetaData(ctx, _getMetaKey(cacheKey))
+		_ = br.client.RemoveCacheIndex(ctx, cacheKey)
+	}
+}
+
+// newCachedResponse メタデータからボディ以外のレスポンスを作る（ヘッダーは保存したものから返せるものだけ）
+func newCachedResponse(metadata *model.CacheMetadata) *model.BpResponse {
 	return &model.BpResponse{
 		StatusCode:    metadata.StatusCode,
-		Headers:       metadata.Headers,
-		Body:          body,
+		Headers:       cachedHeaders(metadata),
 		ContentType:   metadata.ContentType,
 		ContentLength: metadata.ContentLength,
-	}, true, nil
+		CachedAt:      metadata.CreatedAt,
+		ExpiresAt:     metadata.ExpiresAt,
+		Negative:      metadata.Negative,
+	}
 }
 
 // SetResponseWithURL レスポンスをキャッシュに保存（URL指定版）
 // BpRequestからキャッシュパス情報を生成してURLベースの階層構造でキャッシュを保存します
+// response.Negativeの場合はネガティブキャッシュとして記録し、期限切れのまま保持しない
+// 大きさの上限を超えるレスポンスは保存せずにmodel.ErrCacheTooLargeを返し、保存してキャッシュ全体の容量を超えた場合は最終アクセスの古いキャッシュを削除する
 func (br *BpRepository) SetResponseWithURL(ctx context.Context, req *model.BpRequest, response *model.BpResponse, ttl time.Duration) error {
+	size := int64(len(response.Body))
+	if limit := br.objectSizeLimit(); limit > 0 && size > limit {
+		return fmt.Errorf("%w: %d bytes (limit %d bytes)", model.ErrCacheTooLarge, size, limit)
+	}
+
 	// domain層のロジックを使用してキャッシュパス情報を生成
 	pathInfo, err := req.GenerateCachePathInfo(response.ContentType)
 	if err != nil {
@@ -109,16 +183,19 @@ func (br *BpRepository) SetResponseWithURL(ctx context.Context, req *model.BpReq
 		return fmt.Errorf("failed to write cache file: %w", err)
 	}
 
-	// メタデータを作成
+	// メタデータを作成（管理用APIやログに出すURLは正規化したもの、ヘッダーはキャッシュから返せるものを保存する）
 	now := time.Now()
+	canonicalURL := req.NormalizedURL()
 	metadata := model.CacheMetadata{
+		URL:           canonicalURL,
 		FilePath:      filePath,
 		StatusCode:    response.StatusCode,
-		Headers:       response.Headers,
+		Headers:       storableHeaders(response.Headers),
 		ContentType:   response.ContentType,
 		ContentLength: response.ContentLength,
 		CreatedAt:     now,
 		ExpiresAt:     now.Add(ttl),
+		Negative:      response.Negative,
 	}
 
 	// メタデータをJSONにエンコード
@@ -129,44 +206,194 @@ func (br *BpRepository) SetResponseWithURL(ctx context.Context, req *model.BpReq
 		return err
 	}
 
-	// Redisにメタデータを保存（TTL付き）
+	// Redisにメタデータを保存（TTL付き、期限切れのキャッシュを保持する期間も含める）
 	cacheKey := req.GenerateCacheKey()
 	metaKey := _getMetaKey(cacheKey)
-	err = br.client.SetMetaData(ctx, metaKey, metaData, ttl)
+	retain := ttl + max(br.staleGrace, 0)
+	if response.Negative {
+		retain = ttl
+	}
+	err = br.client.SetMetaData(ctx, metaKey, metaData, retain)
 	if err != nil {
 		// Redis保存に失敗した場合はファイルも削除
 		_ = os.Remove(filePath)
 		return err
 	}
 
+	// 管理用APIでURL・ドメインから検索できるようにインデックスに登録する
+	// 失敗してもキャッシュ自体は使えるため、エラーにはしない
+	err = br.client.AddCacheIndex(ctx, CacheIndexEntry{
+		CacheKey: cacheKey,
+		URL:      canonicalURL,
+		Domain:   model.CacheDomain(canonicalURL),
+		Size:     size,
+		StoredAt: now,
+	})
+	if err != nil {
+		log.Printf("[BpRepository] キャッシュのインデックス登録に失敗しました (URL: %s): %v", canonicalURL, err)
+	}
+
+	// 容量を超えた場合も保存したキャッシュは使えるため、削除の失敗はエラーにしない
+	if br.maxCacheSize > 0 {
+		if err := br.evictLeastRecentlyUsed(ctx, cacheKey); err != nil {
+			log.Printf("[BpRepository] 容量を超えたキャッシュの削除に失敗しました: %v", err)
+		}
+	}
+
 	return nil
 }
 
-// _getMetaKey メタデータ用のRedisキーを生成
-func _getMetaKey(cacheKey string) string {
-	return fmt.Sprintf("bp:cache:meta:%s", cacheKey)
+// objectSizeLimit 1件のキャッシュとして保存できる大きさの上限（キャッシュ全体の容量より大きいものも保存しない、0は上限なし）
+func (br *BpRepository) objectSizeLimit() int64 {
+	limit := max(br.maxObjectSize, 0)
+	if br.maxCacheSize > 0 && (limit == 0 || br.maxCacheSize < limit) {
+		limit = br.maxCacheSize
+	}
+	return limit
 }
 
-// DeleteExpiredCaches 期限切れキャッシュを削除する
-func (br *BpRepository) DeleteExpiredCaches(ctx context.Context) error {
-	items, err := br.client.ScanExpiredKeys(ctx)
+// evictBatch 容量を超えたときに一度に読み出す、最終アクセスの古いキャッシュキーの数
+const evictBatch = 16
+
+// evictLeastRecentlyUsed キャッシュ全体の容量がmaxCacheSize以下になるまで、最終アクセスの古いキャッシュから削除する
+// keepは保存したばかりのキャッシュで、削除しない
+func (br *BpRepository) evictLeastRecentlyUsed(ctx context.Context, keep string) error {
+	used, _, err := br.client.GetCacheUsage(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read cache usage: %w", err)
 	}
 
-	// 各期限切れアイテムを削除
-	for _, item := range items {
-		// ファイルシステムから削除
-		if item.FilePath != "" {
-			_ = os.Remove(item.FilePath)
+	evicted := 0
+	// 削除に失敗したキーが古い順の先頭に残り続けても、同じキーを何度も試さない
+	tried := map[string]bool{keep: true}
+	for used > br.maxCacheSize {
+		cacheKeys, err := br.client.LeastRecentlyUsedKeys(ctx, len(tried)+evictBatch)
+		if err != nil {
+			return fmt.Errorf("failed to list least recently used caches: %w", err)
+		}
+		progressed := false
+		for _, cacheKey := range cacheKeys {
+			if tried[cacheKey] {
+				continue
+			}
+			tried[cacheKey] = true
+			progressed = true
+
+			metadata, found, err := br.getMetadata(ctx, cacheKey)
+			if err != nil {
+				return err
+			}
+			if !found {
+				// 期限切れで既に消えている
+				_ = br.client.RemoveCacheIndex(ctx, cacheKey)
+			} else {
+				size := cacheFileSize(metadata)
+				if err := br.purgeEntry(ctx, cacheKey, metadata); err != nil {
+					return err
+				}
+				evicted++
+				br.evictions.Add(1)
+				br.evictedBytes.Add(size)
+			}
+
+			if used, _, err = br.client.GetCacheUsage(ctx); err != nil {
+				return fmt.Errorf("failed to read cache usage: %w", err)
+			}
+			if used <= br.maxCacheSize {
+				break
+			}
+		}
+		if !progressed {
+			// 保存したばかりのキャッシュ以外に削除できるものがない
+			break
 		}
-		// Redisからメタデータを削除
-		_ = br.client.DeleteMetaData(ctx, item.Key)
 	}
 
+	if evicted > 0 {
+		log.Printf("[BpRepository] 容量を超えたため最終アクセスの古いキャッシュを削除しました (%d件, 使用量: %d / %d bytes)", evicted, used, br.maxCacheSize)
+	}
 	return nil
 }
 
+// GetCacheUsage キャッシュ全体の使用量と上限、容量を超えたために削除したキャッシュの数を返す
+func (br *BpRepository) GetCacheUsage(ctx context.Context) (model.CacheUsage, error) {
+	used, entries, err := br.client.GetCacheUsage(ctx)
+	if err != nil {
+		return model.CacheUsage{}, fmt.Errorf("failed to read cache usage: %w", err)
+	}
+	return model.CacheUsage{
+		Bytes:          used,
+		Entries:        entries,
+		MaxBytes:       max(br.maxCacheSize, 0),
+		MaxObjectBytes: br.objectSizeLimit(),
+		Evictions:      br.evictions.Load(),
+		EvictedBytes:   br.evictedBytes.Load(),
+	}, nil
+}
+
+// _getMetaKey メタデータ用のRedisキーを生成
+func _getMetaKey(cacheKey string) string {
+	return fmt.Sprintf("bp:cache:meta:%s", cacheKey)
+}
+
+// SetCleanupBudget DeleteExpiredCachesの1回で削除する期限切れキャッシュの上限を設定する（0以下は上限なし）
+func (br *BpRepository) SetCleanupBudget(budget int) {
+	br.cleanupMu.Lock()
+	defer br.cleanupMu.Unlock()
+	br.cleanupBudget = budget
+}
+
+// DeleteExpiredCaches 期限切れキャッシュを削除する（ネガティブキャッシュは結果で分けて数える）
+// メタデータをScanExpiredKeysで少しずつ調べ、見つけたものから削除する
+// 削除した数がcleanupBudgetに達すると途中で戻り、次の呼び出しは続きのカーソルから調べる
+func (br *BpRepository) DeleteExpiredCaches(ctx context.Context) (model.CleanupResult, error) {
+	// スケジューラーと管理APIから同時に呼ばれても、カーソルを取り合わないようにする
+	br.cleanupMu.Lock()
+	defer br.cleanupMu.Unlock()
+
+	var result model.CleanupResult
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		scan, err := br.client.ScanExpiredKeys(ctx, br.cleanupCursor)
+		if err != nil {
+			return result, err
+		}
+		result.Scanned += scan.Scanned
+
+		// 各期限切れアイテムを削除
+		for i, item := range scan.Items {
+			if br.cleanupBudget > 0 && result.Deleted >= br.cleanupBudget {
+				// 残りは次の呼び出しで同じカーソルから調べ直す（削除したものは見つからない）
+				result.Remaining = len(scan.Items) - i
+				return result, nil
+			}
+			// ファイルシステムから削除
+			if item.FilePath != "" {
+				_ = os.Remove(item.FilePath)
+			}
+			// Redisからメタデータを削除
+			_ = br.client.DeleteMetaData(ctx, item.Key)
+			_ = br.client.RemoveCacheIndex(ctx, strings.TrimPrefix(item.Key, _getMetaKey("")))
+
+			result.Deleted++
+			if item.Negative {
+				result.Negative++
+			}
+		}
+
+		br.cleanupCursor = scan.Cursor
+		if scan.Cursor == 0 {
+			result.Complete = true
+			return result, nil
+		}
+		if br.cleanupBudget > 0 && result.Deleted >= br.cleanupBudget {
+			return result, nil
+		}
+	}
+}
+
 // DeleteAllCaches すべてのキャッシュを削除する
 func (br *BpRepository) DeleteAllCaches(ctx context.Context) error {
 	// Redisのキャッシュを全削除
@@ -185,27 +412,73 @@ func (br *BpRepository) DeleteAllCaches(ctx context.Context) error {
 
 // ReserveRequest 非同期処理（Worker Pool）で処理するためにリクエストを予約する
 // Redisキューに追加して、RequestProcessorが非同期で処理する
-func (br *BpRepository) ReserveRequest(ctx context.Context, req *model.BpRequest) error {
-	log.Printf("[BpRepository] ReserveRequest called: URL=%s", req.URL)
+// 同じキャッシュキーのリクエストが予約済みの場合はキューに追加せず、既にある予約の時刻を返す
+// （同じページに複数のクライアントがアクセスしても、DTNへ送るバンドルは1つになる）
+// 事前取得の予約は、デッドレターのあるURLを予約しない（HTMLを保存するたびに、失敗し続けるリソースを予約し直さない）
+func (br *BpRepository) ReserveRequest(ctx context.Context, req *model.BpRequest) (model.Reservation, error) {
+	log.Printf("[BpRepository] ReserveRequest called: URL=%s, RequestID=%s", req.URL, req.RequestID)
+	if req.Prefetch {
+		deadLetter, err := br.client.GetDeadLetter(ctx, req.GenerateCacheKey())
+		if err != nil {
+			// 確かめられなくても予約はする
+			log.Printf("[BpRepository] デッドレターを確認できません: %v", err)
+		} else if deadLetter != nil {
+			log.Printf("[BpRepository] ReserveRequest skipped (dead-lettered prefetch): URL=%s", req.URL)
+			return model.Reservation{}, nil
+		}
+	}
+	return br.reserve(ctx, req)
+}
 
-	// BpRequestをJSONにエンコード
-	job, err := json.Marshal(req)
+// reserve reqを予約キューに追加する（デッドレターは確かめない）
+func (br *BpRepository) reserve(ctx context.Context, req *model.BpRequest) (model.Reservation, error) {
+	// 予約時刻を記録してJSONにエンコード（呼び出し元のリクエストは変更しない）
+	// UTCにしておくと、キューから取り出したリクエストを再エンコードしても同じJSONになる（RemoveReservedRequestで使う）
+	reserved := *req
+	reserved.ReservedAt = time.Now().UTC()
+	job, err := json.Marshal(&reserved)
 	if err != nil {
 		log.Printf("[BpRepository] JSON Marshal エラー: %v", err)
-		return err
+		return model.Reservation{}, err
 	}
 
 	log.Printf("[BpRepository] Redisキューに追加: URL=%s, job size=%d bytes", req.URL, len(job))
 
-	// RedisのListに追加（キューとして使用）
-	err = br.client.ReserveRequest(ctx, job)
+	// Redisのソート済みセットに追加（優先度と予約した時刻の順に取り出すキューとして使用）
+	// キャッシュの更新と事前取得の予約は、ブラウザが待っているリクエストより後に処理する
+	queued, existing, err := br.client.ReserveRequest(ctx, req.GenerateCacheKey(), job, req.Priority())
 	if err != nil {
 		log.Printf("[BpRepository] ReserveRequest failed: %v", err)
-		return err
+		return model.Reservation{}, err
+	}
+	if !queued {
+		var current model.BpRequest
+		if err := json.Unmarshal(existing, &current); err != nil {
+			// 時刻が分からなくても予約済みであることは変わらない
+			log.Printf("[BpRepository] 予約済みのリクエストを読み込めません: %v", err)
+		}
+		log.Printf("[BpRepository] ReserveRequest skipped (already queued since %s): URL=%s, RequestID=%s", current.ReservedAt.Format(time.RFC3339), req.URL, req.RequestID)
+		return br.withQueuePosition(ctx, req, model.Reservation{ReservedAt: current.ReservedAt}), nil
 	}
 
-	log.Printf("[BpRepository] ReserveRequest succeeded: URL=%s", req.URL)
-	return nil
+	log.Printf("[BpRepository] ReserveRequest succeeded: URL=%s, RequestID=%s", req.URL, req.RequestID)
+	return br.withQueuePosition(ctx, req, model.Reservation{Queued: true, ReservedAt: reserved.ReservedAt}), nil
+}
+
+// withQueuePosition 予約キューでの位置とキューの長さを予約の結果に加える（プレースホルダーに待ち順を表示するため）
+// キャッシュの更新と事前取得の予約は待っているクライアントがいないため調べない。調べられなくても予約はできているため、ログに残すだけにする
+func (br *BpRepository) withQueuePosition(ctx context.Context, req *model.BpRequest, reservation model.Reservation) model.Reservation {
+	if req.Priority() != model.PriorityInteractive {
+		return reservation
+	}
+	queue, err := br.GetReservedRequests(ctx)
+	if err != nil {
+		log.Printf("[BpRepository] 予約キューの位置を取得できません: %v", err)
+		return reservation
+	}
+	reservation.Position, _ = model.FindQueuePosition(queue, req.GenerateCacheKey())
+	reservation.QueueLength = len(queue)
+	return reservation
 }
 
 // GetReservedRequests 予約されたリクエストのリストを取得する
@@ -239,7 +512,7 @@ func (br *BpRepository) RemoveReservedRequest(ctx context.Context, req *model.Bp
 		return err
 	}
 
-	err = br.client.RemoveReservedRequest(ctx, data)
+	err = br.client.RemoveReservedRequest(ctx, req.GenerateCacheKey(), data)
 	if err != nil {
 		return err
 	}
@@ -247,6 +520,91 @@ func (br *BpRepository) RemoveReservedRequest(ctx context.Context, req *model.Bp
 	return nil
 }
 
+// RequeueReservedRequest Workerが取り出した予約を、予約済みのままその優先度の最後に戻す
+func (br *BpRepository) RequeueReservedRequest(ctx context.Context, req *model.BpRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return br.client.RequeueReservedRequest(ctx, req.GenerateCacheKey(), data, req.Priority())
+}
+
+// RetryReservedRequest Workerが取り出した予約を、予約済みのままdelayが過ぎてからその優先度の最後に戻す
+func (br *BpRepository) RetryReservedRequest(ctx context.Context, req *model.BpRequest, delay time.Duration) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if delay <= 0 {
+		return br.client.RequeueReservedRequest(ctx, req.GenerateCacheKey(), data, req.Priority())
+	}
+	return br.client.DelayReservedRequest(ctx, req.GenerateCacheKey(), data, req.Priority(), time.Now().Add(delay))
+}
+
+// DeadLetterReservedRequest 送り直しの上限に達した予約を削除し、lastErrorとともにデッドレターとして残す
+func (br *BpRepository) DeadLetterReservedRequest(ctx context.Context, req *model.BpRequest, lastError string) error {
+	job, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(&model.DeadLetter{
+		CacheKey:  req.GenerateCacheKey(),
+		Request:   req,
+		LastError: lastError,
+		FailedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return br.client.DeadLetterReservedRequest(ctx, req.GenerateCacheKey(), job, entry)
+}
+
+// ListDeadLetters デッドレターを新しい順に返す（読めないデッドレターはスキップする）
+func (br *BpRepository) ListDeadLetters(ctx context.Context) ([]*model.DeadLetter, error) {
+	dataList, err := br.client.GetDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := make([]*model.DeadLetter, 0, len(dataList))
+	for _, data := range dataList {
+		var deadLetter model.DeadLetter
+		if err := json.Unmarshal(data, &deadLetter); err != nil || deadLetter.Request == nil {
+			log.Printf("[BpRepository] デッドレターを読めません: %v", err)
+			continue
+		}
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+	slices.SortFunc(deadLetters, func(a, b *model.DeadLetter) int { return b.FailedAt.Compare(a.FailedAt) })
+	return deadLetters, nil
+}
+
+// RequeueDeadLetter cacheKeyのデッドレターの予約を、送り直しの回数を0にして予約し直し、デッドレターを削除する
+// 予約し直せなかった場合は、デッドレターを残す
+func (br *BpRepository) RequeueDeadLetter(ctx context.Context, cacheKey string) (model.Reservation, bool, error) {
+	data, err := br.client.GetDeadLetter(ctx, cacheKey)
+	if err != nil || data == nil {
+		return model.Reservation{}, false, err
+	}
+	var deadLetter model.DeadLetter
+	if err := json.Unmarshal(data, &deadLetter); err != nil {
+		return model.Reservation{}, true, fmt.Errorf("failed to decode the dead letter %s: %w", cacheKey, err)
+	}
+	if deadLetter.Request == nil {
+		return model.Reservation{}, true, fmt.Errorf("dead letter %s has no request", cacheKey)
+	}
+
+	req := *deadLetter.Request
+	req.Attempts = 0
+	reservation, err := br.reserve(ctx, &req)
+	if err != nil {
+		return model.Reservation{}, true, err
+	}
+	if err := br.client.DeleteDeadLetter(ctx, cacheKey); err != nil {
+		return reservation, true, fmt.Errorf("requeued but failed to remove the dead letter %s: %w", cacheKey, err)
+	}
+	return reservation, true, nil
+}
+
 // BLPopReservedRequest 予約されたリクエストをブロッキングで取得する
 func (br *BpRepository) BLPopReservedRequest(ctx context.Context, timeout time.Duration) (*model.BpRequest, error) {
 	// Redisから生のバイトデータを取得
@@ -269,6 +627,76 @@ func (br *BpRepository) BLPopReservedRequest(ctx context.Context, timeout time.D
 	return &req, nil
 }
 
+// RequeueStuckRequests 取得してからstuckAfterより長く処理中のままの予約を、取り出したときの優先度の最後に戻す
+func (br *BpRepository) RequeueStuckRequests(ctx context.Context, stuckAfter time.Duration) ([]*model.BpRequest, error) {
+	dataList, err := br.client.RequeueStuckRequests(ctx, time.Now().Add(-stuckAfter))
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]*model.BpRequest, 0, len(dataList))
+	for _, data := range dataList {
+		var req model.BpRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			// キューには戻したため、読めない予約はログに残すだけにする
+			log.Printf("[BpRepository] キューに戻した予約を読めません: %v", err)
+			continue
+		}
+		requests = append(requests, &req)
+	}
+	return requests, nil
+}
+
+// JournalSentRequest DTNへ送るリクエストを送信の記録に追加する
+func (br *BpRepository) JournalSentRequest(ctx context.Context, sent *model.SentRequest) error {
+	data, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+	return br.client.SetSentRequest(ctx, sent.RequestID, data)
+}
+
+// GetSentRequest RequestIDの送信の記録を返す
+func (br *BpRepository) GetSentRequest(ctx context.Context, requestID string) (*model.SentRequest, bool, error) {
+	data, err := br.client.GetSentRequest(ctx, requestID)
+	if err != nil || data == nil {
+		return nil, false, err
+	}
+	var sent model.SentRequest
+	if err := json.Unmarshal(data, &sent); err != nil {
+		return nil, false, fmt.Errorf("invalid sent request %s: %w", requestID, err)
+	}
+	if sent.Request == nil {
+		return nil, false, fmt.Errorf("invalid sent request %s: missing request", requestID)
+	}
+	return &sent, true, nil
+}
+
+// ListSentRequests 送信の記録をすべて返す（読めない記録はログに残して削除する）
+func (br *BpRepository) ListSentRequests(ctx context.Context) ([]*model.SentRequest, error) {
+	dataList, err := br.client.GetSentRequests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sentRequests := make([]*model.SentRequest, 0, len(dataList))
+	for _, data := range dataList {
+		var sent model.SentRequest
+		if err := json.Unmarshal(data, &sent); err != nil || sent.Request == nil {
+			log.Printf("[BpRepository] 送信の記録を読み込めないため削除します (RequestID: %s): %v", sent.RequestID, err)
+			if sent.RequestID != "" {
+				_ = br.client.DeleteSentRequest(ctx, sent.RequestID)
+			}
+			continue
+		}
+		sentRequests = append(sentRequests, &sent)
+	}
+	return sentRequests, nil
+}
+
+// CompleteSentRequest RequestIDの送信の記録を削除する
+func (br *BpRepository) CompleteSentRequest(ctx context.Context, requestID string) error {
+	return br.client.DeleteSentRequest(ctx, requestID)
+}
+
 // AddPendingRequest 処理中のリクエストとしてマークする
 func (br *BpRepository) AddPendingRequest(ctx context.Context, url string) (bool, error) {
 	return br.client.AddPendingRequest(ctx, url)
@@ -278,3 +706,30 @@ func (br *BpRepository) AddPendingRequest(ctx context.Context, url string) (bool
 func (br *BpRepository) RemovePendingRequest(ctx context.Context, url string) error {
 	return br.client.RemovePendingRequest(ctx, url)
 }
+
+// Ping Redisに接続できるかを確認する
+func (br *BpRepository) Ping(ctx context.Context) error {
+	if err := br.client.Ping(ctx); err != nil {
+		return fmt.Errorf("redis ping failed: %w", err)
+	}
+	return nil
+}
+
+// CheckCacheDir キャッシュディレクトリに一時ファイルを作って消せるかを確認する
+func (br *BpRepository) CheckCacheDir(ctx context.Context) error {
+	if err := os.MkdirAll(br.cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	f, err := os.CreateTemp(br.cacheDir, ".health-*")
+	if err != nil {
+		return fmt.Errorf("cache directory is not writable: %w", err)
+	}
+	name := f.Name()
+	_, writeErr := f.Write([]byte("ok"))
+	closeErr := f.Close()
+	removeErr := os.Remove(name)
+	if err := errors.Join(writeErr, closeErr, removeErr); err != nil {
+		return fmt.Errorf("cache directory is not writable: %w", err)
+	}
+	return nil
+}
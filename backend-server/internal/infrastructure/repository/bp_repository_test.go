@@ -0,0 +1,256 @@
+// bp_repository_test.go - キャッシュファイルを開いたまま返す取得（GetResponseStream）、期限切れのキャッシュの保持と送信の記録のテスト
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+func TestGetResponseStream(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/video.mp4"}
+	// 転送先のContent-Lengthがない（-1）場合もファイルの大きさを返す
+	resp := &model.BpResponse{StatusCode: http.StatusOK, Body: body, ContentType: "video/mp4", ContentLength: -1, Headers: map[string][]string{"Etag": {`"v1"`}}}
+	if err := repo.SetResponseWithURL(context.Background(), req, resp, time.Hour); err != nil {
+		t.Fatalf("SetResponseWithURL failed: %v", err)
+	}
+
+	got, found, err := repo.GetResponseStream(context.Background(), req.GenerateCacheKey())
+	if err != nil || !found {
+		t.Fatalf("Expected a cache hit, got found=%v err=%v", found, err)
+	}
+	if got.BodyStream == nil || len(got.Body) != 0 {
+		t.Fatalf("Expected the body as a stream only, got %d bytes in memory", len(got.Body))
+	}
+	if got.ContentLength != int64(len(body)) || got.StatusCode != http.StatusOK || got.ContentType != "video/mp4" || got.CachedAt.IsZero() {
+		t.Errorf("Unexpected metadata: %+v", got)
+	}
+
+	// 途中まで読んで閉じられる
+	head := make([]byte, 25)
+	if _, err := io.ReadFull(got.BodyStream, head); err != nil || !bytes.Equal(head, body[:25]) {
+		t.Fatalf("Expected the first 25 bytes, got %q (%v)", head, err)
+	}
+	if err := got.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := got.BodyStream.Read(head); err == nil {
+		t.Error("Expected reads to fail after Close")
+	}
+
+	// 別の取得は最初から読める
+	again, _, _ := repo.GetResponseStream(context.Background(), req.GenerateCacheKey())
+	defer again.Close()
+	if all, err := io.ReadAll(again.BodyStream); err != nil || !bytes.Equal(all, body) {
+		t.Errorf("Expected the whole body, got %d bytes (%v)", len(all), err)
+	}
+}
+
+func TestGetResponseStreamMissingFile(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	req := storeTestCache(t, repo, "https://example.com/gone.html", nil)
+	metaKey := _getMetaKey(req.GenerateCacheKey())
+	metadata, found, err := repo.getMetadata(context.Background(), req.GenerateCacheKey())
+	if err != nil || !found {
+		t.Fatalf("Expected metadata, got found=%v err=%v", found, err)
+	}
+	if err := os.Remove(metadata.FilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// ファイルが消えている場合はキャッシュミスにして、メタデータも削除する
+	if _, found, err := repo.GetResponseStream(context.Background(), req.GenerateCacheKey()); found || err != nil {
+		t.Errorf("Expected a cache miss, got found=%v err=%v", found, err)
+	}
+	if _, ok := client.meta[metaKey]; ok {
+		t.Error("Expected the metadata of the missing file to be deleted")
+	}
+}
+
+func TestStaleRetention(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), time.Hour, 0, 0)
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		found bool
+		stale bool
+	}{
+		{"fresh", time.Hour, true, false},
+		// 有効期限を過ぎても保持する期間内は、期限切れのレスポンスとして返す
+		{"stale", -time.Minute, true, true},
+		// 保持する期間も過ぎた場合はキャッシュミスにして削除する
+		{"evicted", -2 * time.Hour, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/" + tt.name + ".html"}
+			resp := &model.BpResponse{StatusCode: http.StatusOK, Body: []byte(tt.name), ContentType: "text/html"}
+			if err := repo.SetResponseWithURL(context.Background(), req, resp, tt.ttl); err != nil {
+				t.Fatalf("SetResponseWithURL failed: %v", err)
+			}
+			cacheKey := req.GenerateCacheKey()
+			metaKey := _getMetaKey(cacheKey)
+			// Redisには保持する期間の分だけ長く残す
+			if got := client.ttl[metaKey]; got != tt.ttl+time.Hour {
+				t.Errorf("Expected the metadata TTL to include the grace period, got %v", got)
+			}
+			metadata, _, _ := repo.getMetadata(context.Background(), cacheKey)
+
+			for _, get := range []func(context.Context, string) (*model.BpResponse, bool, error){repo.GetResponse, repo.GetResponseStream} {
+				got, found, err := get(context.Background(), cacheKey)
+				if err != nil || found != tt.found {
+					t.Fatalf("Expected found=%v, got found=%v err=%v", tt.found, found, err)
+				}
+				if !found {
+					continue
+				}
+				got.Close()
+				if got.IsStale() != tt.stale {
+					t.Errorf("Expected stale=%v, got ExpiresAt %v", tt.stale, got.ExpiresAt)
+				}
+			}
+
+			_, fileErr := os.Stat(metadata.FilePath)
+			_, metaKept := client.meta[metaKey]
+			if tt.found && (fileErr != nil || !metaKept) {
+				t.Errorf("Expected the entry to be retained, got file error %v, metadata kept %v", fileErr, metaKept)
+			}
+			if !tt.found && (!os.IsNotExist(fileErr) || metaKept) {
+				t.Errorf("Expected the entry to be deleted, got file error %v, metadata kept %v", fileErr, metaKept)
+			}
+		})
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), time.Hour, 0, 0)
+	ctx := context.Background()
+
+	store := func(path string, resp *model.BpResponse, ttl time.Duration) string {
+		t.Helper()
+		req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/" + path}
+		if err := repo.SetResponseWithURL(ctx, req, resp, ttl); err != nil {
+			t.Fatalf("SetResponseWithURL failed: %v", err)
+		}
+		return req.GenerateCacheKey()
+	}
+	notFound := func() *model.BpResponse {
+		return &model.BpResponse{StatusCode: http.StatusNotFound, Body: []byte("not found"), ContentType: "text/html", Negative: true}
+	}
+
+	// ネガティブキャッシュは期限切れのまま保持しない
+	fresh := store("missing.html", notFound(), 5*time.Minute)
+	if got := client.ttl[_getMetaKey(fresh)]; got != 5*time.Minute {
+		t.Errorf("Expected the negative TTL without the grace period, got %v", got)
+	}
+	got, found, err := repo.GetResponse(ctx, fresh)
+	if err != nil || !found {
+		t.Fatalf("Expected the negative entry, got found=%v err=%v", found, err)
+	}
+	if !got.Negative || got.StatusCode != http.StatusNotFound || string(got.Body) != "not found" {
+		t.Errorf("Expected the negative 404 to round-trip, got %+v", got)
+	}
+	entry, found, err := repo.getCacheEntry(ctx, fresh)
+	if err != nil || !found || !entry.Negative {
+		t.Errorf("Expected the cache entry to be negative, got %+v found=%v err=%v", entry, found, err)
+	}
+
+	expired := store("gone.html", notFound(), -time.Minute)
+	if _, found, err := repo.GetResponse(ctx, expired); err != nil || found {
+		t.Errorf("Expected an expired negative entry to be a miss, got found=%v err=%v", found, err)
+	}
+
+	// クリーンアップはネガティブキャッシュを分けて数える
+	store("expired-negative.html", notFound(), -time.Minute)
+	store("expired-positive.html", &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("ok"), ContentType: "text/html"}, -2*time.Hour)
+	store("stale.html", &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("ok"), ContentType: "text/html"}, -time.Minute)
+	result, err := repo.DeleteExpiredCaches(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredCaches failed: %v", err)
+	}
+	if result.Deleted != 2 || result.Negative != 1 {
+		t.Errorf("Expected 2 deleted entries with 1 negative, got %+v", result)
+	}
+	if _, ok := client.meta[_getMetaKey(fresh)]; !ok {
+		t.Error("Expected the fresh negative entry to be kept")
+	}
+}
+
+// sentRequestClient 送信の記録だけをメモリ上に持つBpRepoClient
+type sentRequestClient struct {
+	BpRepoClient
+	sent map[string][]byte
+}
+
+func (c *sentRequestClient) SetSentRequest(ctx context.Context, requestID string, data []byte) error {
+	c.sent[requestID] = data
+	return nil
+}
+
+func (c *sentRequestClient) GetSentRequest(ctx context.Context, requestID string) ([]byte, error) {
+	return c.sent[requestID], nil
+}
+
+func (c *sentRequestClient) GetSentRequests(ctx context.Context) ([][]byte, error) {
+	var values [][]byte
+	for _, data := range c.sent {
+		values = append(values, data)
+	}
+	return values, nil
+}
+
+func (c *sentRequestClient) DeleteSentRequest(ctx context.Context, requestID string) error {
+	delete(c.sent, requestID)
+	return nil
+}
+
+func TestSentRequestJournal(t *testing.T) {
+	client := &sentRequestClient{sent: make(map[string][]byte)}
+	br := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	ctx := context.Background()
+
+	sentAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page", Headers: map[string][]string{"Accept": {"text/html"}}, RequestID: "req-1"}
+	if err := br.JournalSentRequest(ctx, &model.SentRequest{RequestID: "req-1", Request: req, SentAt: sentAt}); err != nil {
+		t.Fatalf("JournalSentRequest failed: %v", err)
+	}
+	sent, found, err := br.GetSentRequest(ctx, "req-1")
+	if err != nil || !found {
+		t.Fatalf("Expected the entry, got found=%v err=%v", found, err)
+	}
+	if !sent.SentAt.Equal(sentAt) || sent.Request.GenerateCacheKey() != req.GenerateCacheKey() {
+		t.Errorf("Unexpected entry %+v", sent)
+	}
+	if _, found, err := br.GetSentRequest(ctx, "missing"); found || err != nil {
+		t.Errorf("Expected no entry, got found=%v err=%v", found, err)
+	}
+
+	// 読めない記録は一覧から除いて削除する
+	client.sent["broken"] = []byte(`{"request_id":"broken"}`)
+	entries, err := br.ListSentRequests(ctx)
+	if err != nil || len(entries) != 1 || entries[0].RequestID != "req-1" {
+		t.Fatalf("Expected only req-1, got %v %v", entries, err)
+	}
+	if _, ok := client.sent["broken"]; ok {
+		t.Error("Expected the unreadable entry to be deleted")
+	}
+
+	if err := br.CompleteSentRequest(ctx, "req-1"); err != nil {
+		t.Fatalf("CompleteSentRequest failed: %v", err)
+	}
+	if _, found, _ := br.GetSentRequest(ctx, "req-1"); found {
+		t.Error("Expected the entry to be removed")
+	}
+}
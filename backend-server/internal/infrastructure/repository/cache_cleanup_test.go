@@ -0,0 +1,98 @@
+// cache_cleanup_test.go - 期限切れのキャッシュを1回に削除する数の上限と、次の回に続きから削除することのテスト
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteExpiredCachesBudget(t *testing.T) {
+	client := newMemoryRepoClient()
+	client.scanBatch = 10
+	dir := t.TempDir()
+	repo := NewBpRepository(client, dir, 0, 0, 0)
+	repo.SetCleanupBudget(6)
+	ctx := context.Background()
+
+	// 25件のうち5件おきに期限内のキャッシュを置く（期限切れは20件）
+	var files []string
+	for i := range 25 {
+		filePath := filepath.Join(dir, fmt.Sprintf("%02d", i))
+		if err := os.WriteFile(filePath, []byte("body"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		ttl := time.Duration(0)
+		if i%5 == 0 {
+			ttl = time.Hour
+		} else {
+			files = append(files, filePath)
+		}
+		metadata := fmt.Sprintf(`{"file_path":%q,"negative":%v}`, filePath, i%2 == 0)
+		_ = client.SetMetaData(ctx, _getMetaKey(fmt.Sprintf("%02d", i)), []byte(metadata), ttl)
+	}
+
+	var deleted, negative int
+	for pass := 1; ; pass++ {
+		result, err := repo.DeleteExpiredCaches(ctx)
+		if err != nil {
+			t.Fatalf("DeleteExpiredCaches failed: %v", err)
+		}
+		if result.Deleted > 6 {
+			t.Errorf("Pass %d: expected at most 6 deleted caches, got %+v", pass, result)
+		}
+		if pass == 1 && (result.Complete || result.Remaining == 0 || result.Scanned == 0) {
+			t.Errorf("Pass 1: expected the budget to leave expired caches for later, got %+v", result)
+		}
+		deleted += result.Deleted
+		negative += result.Negative
+		if result.Complete {
+			if pass != 4 {
+				t.Errorf("Expected 4 passes (6+6+6+2), got %d", pass)
+			}
+			break
+		}
+		if pass > 4 {
+			t.Fatalf("Expected the cleanup to finish, got %+v", result)
+		}
+	}
+	if deleted != 20 || negative != 10 {
+		t.Errorf("Expected 20 deleted caches (10 negative), got %d (%d negative)", deleted, negative)
+	}
+	for _, filePath := range files {
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", filePath, err)
+		}
+	}
+	if len(client.meta) != 5 {
+		t.Errorf("Expected the 5 fresh caches to be kept, got %d", len(client.meta))
+	}
+
+	// 調べ終えた後は最初から調べ直す
+	_ = client.SetMetaData(ctx, _getMetaKey("late"), []byte(`{}`), 0)
+	result, err := repo.DeleteExpiredCaches(ctx)
+	if err != nil || result.Deleted != 1 || !result.Complete || result.Scanned != 6 {
+		t.Errorf("Expected the next cleanup to start over, got %+v err=%v", result, err)
+	}
+}
+
+func TestDeleteExpiredCachesWithoutBudget(t *testing.T) {
+	client := newMemoryRepoClient()
+	client.scanBatch = 3
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	ctx := context.Background()
+	for i := range 10 {
+		_ = client.SetMetaData(ctx, _getMetaKey(fmt.Sprintf("%02d", i)), []byte(`{}`), 0)
+	}
+
+	result, err := repo.DeleteExpiredCaches(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredCaches failed: %v", err)
+	}
+	if result.Deleted != 10 || result.Scanned != 10 || result.Remaining != 0 || !result.Complete {
+		t.Errorf("Expected every batch in one pass, got %+v", result)
+	}
+}
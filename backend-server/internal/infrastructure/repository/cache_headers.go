@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"net/http"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/headers"
+)
+
+// unreplayableHeaders ホップ・バイ・ホップヘッダーのほかに、キャッシュから返さないヘッダー
+// Set-Cookieは最初にアクセスした利用者のCookieのため、同じキャッシュを読む他の利用者に返さない
+var unreplayableHeaders = []string{"Set-Cookie", "Set-Cookie2"}
+
+// storableHeaders レスポンスヘッダーからキャッシュに保存するヘッダーを選ぶ（キーは正規化する）
+// ETag・Last-Modified・Content-Languageなどはそのまま保存し、条件付きリクエストやコンテントネゴシエーションに使えるようにする
+func storableHeaders(header map[string][]string) http.Header {
+	if header == nil {
+		return nil
+	}
+	canonical := make(http.Header, len(header))
+	for key, values := range header {
+		for _, value := range values {
+			canonical.Add(key, value)
+		}
+	}
+	stored := headers.StripHopByHop(canonical)
+	for _, name := range unreplayableHeaders {
+		stored.Del(name)
+	}
+	return stored
+}
+
+// cachedHeaders メタデータからキャッシュとして返すレスポンスヘッダーを作る
+// ヘッダーを除く前に保存したメタデータも読めるよう、保存するときと同じヘッダーを除く
+// ヘッダーのない古いメタデータ（"headers": null）は、Content-Typeだけを保存した値から補う
+func cachedHeaders(metadata *model.CacheMetadata) http.Header {
+	header := storableHeaders(metadata.Headers)
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Content-Type") == "" && metadata.ContentType != "" {
+		header.Set("Content-Type", metadata.ContentType)
+	}
+	return header
+}
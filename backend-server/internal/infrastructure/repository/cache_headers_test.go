@@ -0,0 +1,130 @@
+// cache_headers_test.go - キャッシュに保存するレスポンスヘッダーと、古い形式のメタデータから返すヘッダーのテスト
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+func TestCachedResponseKeepsHeaders(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	ctx := context.Background()
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/ja/"}
+	resp := &model.BpResponse{
+		StatusCode:  http.StatusOK,
+		ContentType: "text/html; charset=Shift_JIS",
+		Body:        []byte("<p>page</p>"),
+		Headers: map[string][]string{
+			"Content-Type":      {"text/html; charset=Shift_JIS"},
+			"content-language":  {"ja"}, // 正規化していないキーも保存する
+			"Etag":              {`"v1"`},
+			"Last-Modified":     {"Mon, 02 Jan 2006 15:04:05 GMT"},
+			"Vary":              {"Accept-Language", "Accept-Encoding"},
+			"Link":              {"</style.css>; rel=preload", "</app.js>; rel=preload"},
+			"Set-Cookie":        {"session=alice"},
+			"Connection":        {"X-Debug"},
+			"X-Debug":           {"1"},
+			"Keep-Alive":        {"timeout=5"},
+			"Transfer-Encoding": {"chunked"},
+		},
+	}
+	if err := repo.SetResponseWithURL(ctx, req, resp, time.Hour); err != nil {
+		t.Fatalf("SetResponseWithURL failed: %v", err)
+	}
+	// 保存した後もレスポンスのヘッダーは変えない（Service層がそのまま返す）
+	if len(resp.Headers["Set-Cookie"]) != 1 {
+		t.Errorf("Expected the response headers to be left as they were, got %v", resp.Headers)
+	}
+
+	for name, get := range map[string]func(context.Context, string) (*model.BpResponse, bool, error){
+		"GetResponse":       repo.GetResponse,
+		"GetResponseStream": repo.GetResponseStream,
+	} {
+		got, found, err := get(ctx, req.GenerateCacheKey())
+		if err != nil || !found {
+			t.Fatalf("%s: expected a hit, got found=%v err=%v", name, found, err)
+		}
+		got.Close()
+		header := http.Header(got.Headers)
+		want := http.Header{
+			"Content-Type":     {"text/html; charset=Shift_JIS"},
+			"Content-Language": {"ja"},
+			"Etag":             {`"v1"`},
+			"Last-Modified":    {"Mon, 02 Jan 2006 15:04:05 GMT"},
+			"Vary":             {"Accept-Language", "Accept-Encoding"},
+			"Link":             {"</style.css>; rel=preload", "</app.js>; rel=preload"},
+		}
+		for key, values := range want {
+			if !slices.Equal(header.Values(key), values) {
+				t.Errorf("%s: expected %s %q, got %q", name, key, values, header.Values(key))
+			}
+		}
+		for _, key := range []string{"Set-Cookie", "Connection", "X-Debug", "Keep-Alive", "Transfer-Encoding"} {
+			if values := header.Values(key); len(values) > 0 {
+				t.Errorf("%s: expected %s not to be replayed, got %q", name, key, values)
+			}
+		}
+	}
+}
+
+func TestCachedResponseFromOldMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string // メタデータのJSONのheaders（空の場合は書かない）
+		want    http.Header
+	}{
+		{name: "without headers", want: http.Header{"Content-Type": {"text/css"}}},
+		{name: "null headers", headers: `null`, want: http.Header{"Content-Type": {"text/css"}}},
+		// ヘッダーを除く前に保存したメタデータもSet-Cookieは返さない
+		{name: "unfiltered headers", headers: `{"Content-Type":["text/css; charset=utf-8"],"Etag":["\"v1\""],"Set-Cookie":["session=alice"],"Connection":["keep-alive"]}`,
+			want: http.Header{"Content-Type": {"text/css; charset=utf-8"}, "Etag": {`"v1"`}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMemoryRepoClient()
+			dir := t.TempDir()
+			repo := NewBpRepository(client, dir, 0, 0, 0)
+			ctx := context.Background()
+			filePath := filepath.Join(dir, "style.css")
+			if err := os.WriteFile(filePath, []byte("body{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			headers := ""
+			if tt.headers != "" {
+				headers = `"headers":` + tt.headers + `,`
+			}
+			now := time.Now()
+			metadata := fmt.Sprintf(`{"url":"https://example.com/style.css","file_path":%q,"status_code":200,%s"content_type":"text/css","created_at":%q,"expires_at":%q}`,
+				filePath, headers, now.Format(time.RFC3339Nano), now.Add(time.Hour).Format(time.RFC3339Nano))
+			req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/style.css"}
+			if err := client.SetMetaData(ctx, _getMetaKey(req.GenerateCacheKey()), []byte(metadata), time.Hour); err != nil {
+				t.Fatal(err)
+			}
+
+			got, found, err := repo.GetResponse(ctx, req.GenerateCacheKey())
+			if err != nil || !found {
+				t.Fatalf("Expected a hit, got found=%v err=%v", found, err)
+			}
+			if string(got.Body) != "body{}" || got.ContentType != "text/css" {
+				t.Errorf("Unexpected response: %q %q", got.ContentType, got.Body)
+			}
+			if header := http.Header(got.Headers); len(header) != len(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, header)
+			}
+			for key, values := range tt.want {
+				if got := http.Header(got.Headers).Values(key); !slices.Equal(got, values) {
+					t.Errorf("Expected %s %q, got %q", key, values, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// ListCacheEntries キャッシュの一覧を新しい順に返す（domainが空でなければそのドメインだけ）
+// インデックスに残っていてもメタデータが期限切れで消えているキャッシュは、インデックスから削除して一覧から除く
+func (br *BpRepository) ListCacheEntries(ctx context.Context, domain string, offset, limit int) ([]*model.CacheEntry, int, error) {
+	cacheKeys, total, err := br.client.ListCacheKeys(ctx, domain, offset, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list cache index: %w", err)
+	}
+
+	entries := make([]*model.CacheEntry, 0, len(cacheKeys))
+	for _, cacheKey := range cacheKeys {
+		entry, found, err := br.getCacheEntry(ctx, cacheKey)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !found {
+			total--
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, nil
+}
+
+// GetCacheEntries URLのキャッシュを返す（ヘッダー違いで複数ある場合はすべて）
+// インデックスには正規化したURLで登録しているため、urlも正規化してから探す
+func (br *BpRepository) GetCacheEntries(ctx context.Context, url string) ([]*model.CacheEntry, error) {
+	cacheKeys, err := br.client.GetCacheKeysByURL(ctx, model.NormalizeURL(url, model.StripQueryParams))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up cache index: %w", err)
+	}
+
+	var entries []*model.CacheEntry
+	for _, cacheKey := range cacheKeys {
+		entry, found, err := br.getCacheEntry(ctx, cacheKey)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// PurgeCache URLのキャッシュ（メタデータとファイル）をすべて削除する
+func (br *BpRepository) PurgeCache(ctx context.Context, url string) (int, error) {
+	cacheKeys, err := br.client.GetCacheKeysByURL(ctx, model.NormalizeURL(url, model.StripQueryParams))
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up cache index: %w", err)
+	}
+
+	purged := 0
+	for _, cacheKey := range cacheKeys {
+		metadata, found, err := br.getMetadata(ctx, cacheKey)
+		if err != nil {
+			return purged, err
+		}
+		if !found {
+			// 期限切れで既に消えている
+			_ = br.client.RemoveCacheIndex(ctx, cacheKey)
+			continue
+		}
+		if err := br.purgeEntry(ctx, cacheKey, metadata); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	log.Printf("[BpRepository] キャッシュを削除しました (URL: %s, %d件)", url, purged)
+	return purged, nil
+}
+
+// purgeEntry メタデータとファイルをまとめて削除する
+// 先にファイルを退避してからメタデータを削除し、メタデータの削除に失敗した場合はファイルを戻す
+// （メタデータだけ・ファイルだけが残った状態にしない。退避中に読まれた場合はGetResponseがファイルなしとしてキャッシュミスにする）
+func (br *BpRepository) purgeEntry(ctx context.Context, cacheKey string, metadata *model.CacheMetadata) error {
+	purging := metadata.FilePath + ".purging"
+	moved := false
+	if metadata.FilePath != "" {
+		if err := os.Rename(metadata.FilePath, purging); err == nil {
+			moved = true
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove cache file: %w", err)
+		}
+	}
+
+	if err := br.client.DeleteMetaData(ctx, _getMetaKey(cacheKey)); err != nil {
+		if moved {
+			_ = os.Rename(purging, metadata.FilePath)
+		}
+		return fmt.Errorf("failed to delete cache metadata: %w", err)
+	}
+
+	if moved {
+		_ = os.Remove(purging)
+	}
+	if err := br.client.RemoveCacheIndex(ctx, cacheKey); err != nil {
+		log.Printf("[BpRepository] キャッシュのインデックス削除に失敗しました (key: %s): %v", cacheKey, err)
+	}
+	return nil
+}
+
+// getCacheEntry キャッシュキーのメタデータからCacheEntryを作る（メタデータがなければインデックスから削除してfoundをfalseにする）
+func (br *BpRepository) getCacheEntry(ctx context.Context, cacheKey string) (*model.CacheEntry, bool, error) {
+	metadata, found, err := br.getMetadata(ctx, cacheKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found || metadata.IsEvicted(br.staleGrace) {
+		_ = br.client.RemoveCacheIndex(ctx, cacheKey)
+		return nil, false, nil
+	}
+
+	return &model.CacheEntry{
+		CacheKey:    cacheKey,
+		URL:         metadata.URL,
+		StatusCode:  metadata.StatusCode,
+		ContentType: metadata.ContentType,
+		Size:        cacheFileSize(metadata),
+		StoredAt:    metadata.CreatedAt,
+		ExpiresAt:   metadata.ExpiresAt,
+		Negative:    metadata.Negative,
+	}, true, nil
+}
+
+// getMetadata キャッシュキーのメタデータを読む（存在しない・壊れている場合はfoundがfalse）
+func (br *BpRepository) getMetadata(ctx context.Context, cacheKey string) (*model.CacheMetadata, bool, error) {
+	data, err := br.client.GetMetaData(ctx, _getMetaKey(cacheKey))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache metadata: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, false, nil
+	}
+
+	var metadata model.CacheMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		log.Printf("[BpRepository] メタデータのJSONデコードエラー: %v, key=%s", err, cacheKey)
+		return nil, false, nil
+	}
+	return &metadata, true, nil
+}
+
+// cacheFileSize キャッシュファイルの大きさ（ファイルを調べられない場合はメタデータのContentLength）
+func cacheFileSize(metadata *model.CacheMetadata) int64 {
+	if info, err := os.Stat(metadata.FilePath); err == nil {
+		return info.Size()
+	}
+	return metadata.ContentLength
+}
+
+// reconcileBatch ReconcileCacheUsageで一度に読み出すキャッシュキーの数
+const reconcileBatch = 500
+
+// orphanGrace 書き込み中のキャッシュ（ファイルを書いてからインデックスに登録するまで）のファイルを、参照されていないとして削除しないための猶予
+const orphanGrace = time.Minute
+
+// ReconcileCacheUsage キャッシュ全体の使用量を、キャッシュディレクトリのファイルと突き合わせて数え直す
+// メタデータやファイルがなくなったキャッシュはインデックスから外し、登録済みのキャッシュの大きさは実際のファイルの大きさにする
+// どのキャッシュからも参照されていないファイルは削除する（orphanGraceより新しいファイルは書き込み中の可能性があるため残す）
+func (br *BpRepository) ReconcileCacheUsage(ctx context.Context) (model.CacheReconcileResult, error) {
+	var result model.CacheReconcileResult
+	orphanBefore := time.Now().Add(-orphanGrace)
+
+	before, _, err := br.client.GetCacheUsage(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to read cache usage: %w", err)
+	}
+	result.BytesBefore = before
+
+	// インデックスから外すと一覧の位置がずれるため、先にすべてのキーを読み出す
+	var cacheKeys []string
+	for offset := 0; ; offset += reconcileBatch {
+		keys, total, err := br.client.ListCacheKeys(ctx, "", offset, reconcileBatch)
+		if err != nil {
+			return result, fmt.Errorf("failed to list cache index: %w", err)
+		}
+		cacheKeys = append(cacheKeys, keys...)
+		if len(keys) == 0 || offset+reconcileBatch >= total {
+			break
+		}
+	}
+
+	sizes := make(map[string]int64, len(cacheKeys))
+	referenced := make(map[string]bool, len(cacheKeys))
+	for _, cacheKey := range cacheKeys {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		metadata, found, err := br.getMetadata(ctx, cacheKey)
+		if err != nil {
+			return result, err
+		}
+		if !found {
+			// 期限切れで既に消えている
+			_ = br.client.RemoveCacheIndex(ctx, cacheKey)
+			result.Removed++
+			continue
+		}
+		if metadata.IsEvicted(br.staleGrace) {
+			if err := br.purgeEntry(ctx, cacheKey, metadata); err != nil {
+				return result, err
+			}
+			result.Removed++
+			continue
+		}
+		info, err := os.Stat(metadata.FilePath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return result, fmt.Errorf("failed to stat cache file: %w", err)
+			}
+			br.forgetMissingFile(ctx, cacheKey, err)
+			result.Removed++
+			continue
+		}
+		sizes[cacheKey] = info.Size()
+		referenced[filepath.Clean(metadata.FilePath)] = true
+	}
+
+	err = filepath.WalkDir(br.cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 調べている間に削除されたファイル・ディレクトリ
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || referenced[filepath.Clean(path)] || strings.HasSuffix(path, ".purging") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(orphanBefore) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		result.OrphanFiles++
+		result.OrphanBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to scan cache directory: %w", err)
+	}
+
+	if err := br.client.RecountCacheUsage(ctx, sizes); err != nil {
+		return result, fmt.Errorf("failed to recount cache usage: %w", err)
+	}
+	// 数え直した結果が容量を超えていれば、保存したときと同じく最終アクセスの古いキャッシュから削除する
+	if br.maxCacheSize > 0 {
+		if err := br.evictLeastRecentlyUsed(ctx, ""); err != nil {
+			return result, err
+		}
+	}
+	if result.Bytes, result.Entries, err = br.client.GetCacheUsage(ctx); err != nil {
+		return result, fmt.Errorf("failed to read cache usage: %w", err)
+	}
+
+	log.Printf("[BpRepository] キャッシュの使用量を数え直しました (%d -> %d bytes, %d件, インデックスから外したキャッシュ%d件, 削除したファイル%d件 %d bytes)",
+		result.BytesBefore, result.Bytes, result.Entries, result.Removed, result.OrphanFiles, result.OrphanBytes)
+	return result, nil
+}
@@ -0,0 +1,365 @@
+// cache_index_test.go - キャッシュの二次インデックスの維持とURL単位の削除のテスト
+package repository
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// memoryRepoClient メタデータと二次インデックスをメモリ上に持つBpRepoClient（Redisの代わり）
+// 二次インデックスと最終アクセス時刻はmuで守る（同時のアクセスのテストのため）
+type memoryRepoClient struct {
+	BpRepoClient
+	meta    map[string][]byte
+	ttl     map[string]time.Duration
+	mu      sync.Mutex
+	index   map[string]CacheIndexEntry
+	access  map[string]time.Time
+	failDel bool
+
+	// scanBatch ScanExpiredKeysで1回に調べるキーの数（0はすべて）
+	scanBatch   int
+	scanCursors []string
+}
+
+func newMemoryRepoClient() *memoryRepoClient {
+	return &memoryRepoClient{
+		meta:   make(map[string][]byte),
+		ttl:    make(map[string]time.Duration),
+		index:  make(map[string]CacheIndexEntry),
+		access: make(map[string]time.Time),
+	}
+}
+
+func (c *memoryRepoClient) GetMetaData(ctx context.Context, metaKey string) ([]byte, error) {
+	return c.meta[metaKey], nil
+}
+
+func (c *memoryRepoClient) SetMetaData(ctx context.Context, metaKey string, data []byte, ttl time.Duration) error {
+	c.meta[metaKey] = data
+	c.ttl[metaKey] = ttl
+	return nil
+}
+
+func (c *memoryRepoClient) DeleteMetaData(ctx context.Context, metaKey string) error {
+	if c.failDel {
+		return os.ErrPermission
+	}
+	delete(c.meta, metaKey)
+	return nil
+}
+
+// ScanExpiredKeys Redisと同じく、TTLが0以下で保存したメタデータを期限切れとして返す
+// scanBatch件ずつキーの順に調べる。Redisと同じく、途中でキーを削除しても残りのキーを調べられるよう、
+// cursorは最後に調べたキー（scanCursors）の番号にする（0は最初から、または調べ終えた）
+func (c *memoryRepoClient) ScanExpiredKeys(ctx context.Context, cursor uint64) (ExpiredScan, error) {
+	keys := make([]string, 0, len(c.meta))
+	for key := range c.meta {
+		if cursor == 0 || key > c.scanCursors[cursor-1] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var scan ExpiredScan
+	if c.scanBatch > 0 && len(keys) > c.scanBatch {
+		keys = keys[:c.scanBatch]
+		c.scanCursors = append(c.scanCursors, keys[len(keys)-1])
+		scan.Cursor = uint64(len(c.scanCursors))
+	}
+	scan.Scanned = len(keys)
+	for _, key := range keys {
+		if c.ttl[key] > 0 {
+			continue
+		}
+		var metadata model.CacheMetadata
+		_ = json.Unmarshal(c.meta[key], &metadata)
+		scan.Items = append(scan.Items, CacheItem{Key: key, FilePath: metadata.FilePath, Negative: metadata.Negative})
+	}
+	return scan, nil
+}
+
+func (c *memoryRepoClient) AddCacheIndex(ctx context.Context, entry CacheIndexEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index[entry.CacheKey] = entry
+	c.access[entry.CacheKey] = entry.StoredAt
+	return nil
+}
+
+func (c *memoryRepoClient) RemoveCacheIndex(ctx context.Context, cacheKey string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.index, cacheKey)
+	delete(c.access, cacheKey)
+	return nil
+}
+
+// TouchCacheIndex Redisのスクリプトと同じく、登録済みのキーの時刻を新しい場合だけ更新する
+func (c *memoryRepoClient) TouchCacheIndex(ctx context.Context, cacheKey string, accessedAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current, ok := c.access[cacheKey]; ok && accessedAt.After(current) {
+		c.access[cacheKey] = accessedAt
+	}
+	return nil
+}
+
+func (c *memoryRepoClient) LeastRecentlyUsedKeys(ctx context.Context, limit int) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.access))
+	for key := range c.access {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(c.access[a].Compare(c.access[b]), cmp.Compare(a, b))
+	})
+	return keys[:min(limit, len(keys))], nil
+}
+
+func (c *memoryRepoClient) GetCacheUsage(ctx context.Context) (int64, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total int64
+	for _, entry := range c.index {
+		total += entry.Size
+	}
+	return total, len(c.index), nil
+}
+
+// RecountCacheUsage 合計は登録済みのキャッシュから毎回数えるため、大きさを置き換えるだけ
+func (c *memoryRepoClient) RecountCacheUsage(ctx context.Context, sizes map[string]int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, size := range sizes {
+		if entry, ok := c.index[key]; ok {
+			entry.Size = size
+			c.index[key] = entry
+		}
+	}
+	return nil
+}
+
+func (c *memoryRepoClient) GetCacheKeysByURL(ctx context.Context, url string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for key, entry := range c.index {
+		if entry.URL == url {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (c *memoryRepoClient) ListCacheKeys(ctx context.Context, domain string, offset, limit int) ([]string, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var entries []CacheIndexEntry
+	for _, entry := range c.index {
+		if domain == "" || entry.Domain == domain {
+			entries = append(entries, entry)
+		}
+	}
+	// 新しい順（同時刻はURL順）
+	slices.SortFunc(entries, func(a, b CacheIndexEntry) int {
+		return cmp.Or(b.StoredAt.Compare(a.StoredAt), cmp.Compare(a.URL, b.URL))
+	})
+	var keys []string
+	for i := offset; i < min(offset+limit, len(entries)); i++ {
+		keys = append(keys, entries[i].CacheKey)
+	}
+	return keys, len(entries), nil
+}
+
+func storeTestCache(t *testing.T, repo *BpRepository, url string, header http.Header) *model.BpRequest {
+	t.Helper()
+	req := &model.BpRequest{Method: http.MethodGet, URL: url, Headers: header}
+	resp := &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("<html>" + url + "</html>"), ContentType: "text/html"}
+	if err := repo.SetResponseWithURL(context.Background(), req, resp, time.Hour); err != nil {
+		t.Fatalf("SetResponseWithURL failed: %v", err)
+	}
+	return req
+}
+
+func TestSetResponseAddsCacheIndex(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	req := storeTestCache(t, repo, "https://Example.com:8443/a/page.html", nil)
+
+	entry, ok := client.index[req.GenerateCacheKey()]
+	if !ok {
+		t.Fatalf("Expected the cache to be indexed, got %+v", client.index)
+	}
+	// インデックスには正規化したURLで登録する
+	const canonical = "https://example.com:8443/a/page.html"
+	if entry.URL != canonical || entry.Domain != "example.com" {
+		t.Errorf("Expected URL %s and domain example.com, got %+v", canonical, entry)
+	}
+
+	entries, err := repo.GetCacheEntries(context.Background(), req.URL)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %v (err=%v)", entries, err)
+	}
+	e := entries[0]
+	if e.URL != canonical || e.ContentType != "text/html" || e.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if want := int64(len("<html>" + req.URL + "</html>")); e.Size != want {
+		t.Errorf("Expected size %d, got %d", want, e.Size)
+	}
+	if e.StoredAt.IsZero() || !e.ExpiresAt.After(e.StoredAt) {
+		t.Errorf("Expected stored-at before expires-at, got %v / %v", e.StoredAt, e.ExpiresAt)
+	}
+}
+
+func TestListCacheEntriesByDomain(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	storeTestCache(t, repo, "https://example.com/a.html", nil)
+	storeTestCache(t, repo, "https://example.com/b.html", nil)
+	storeTestCache(t, repo, "https://example.org/c.html", nil)
+
+	entries, total, err := repo.ListCacheEntries(context.Background(), "", 0, 10)
+	if err != nil || total != 3 || len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d of %d (err=%v)", len(entries), total, err)
+	}
+
+	entries, total, err = repo.ListCacheEntries(context.Background(), "example.com", 0, 1)
+	if err != nil || total != 2 || len(entries) != 1 {
+		t.Fatalf("Expected the first of 2 example.com entries, got %d of %d (err=%v)", len(entries), total, err)
+	}
+	if model.CacheDomain(entries[0].URL) != "example.com" {
+		t.Errorf("Expected an example.com entry, got %s", entries[0].URL)
+	}
+}
+
+func TestListCacheEntriesPrunesExpiredIndex(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	gone := storeTestCache(t, repo, "https://example.com/gone.html", nil)
+	storeTestCache(t, repo, "https://example.com/kept.html", nil)
+
+	// RedisのTTLでメタデータだけが消えた状態
+	delete(client.meta, _getMetaKey(gone.GenerateCacheKey()))
+
+	entries, total, err := repo.ListCacheEntries(context.Background(), "", 0, 10)
+	if err != nil || total != 1 || len(entries) != 1 || entries[0].URL != "https://example.com/kept.html" {
+		t.Fatalf("Expected only the live entry, got %v of %d (err=%v)", entries, total, err)
+	}
+	if _, ok := client.index[gone.GenerateCacheKey()]; ok {
+		t.Errorf("Expected the stale index entry to be removed")
+	}
+}
+
+func TestPurgeCache(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	url := "https://example.com/docs/page.html"
+	ja := storeTestCache(t, repo, url, http.Header{"Accept-Language": {"ja"}})
+	en := storeTestCache(t, repo, url, http.Header{"Accept-Language": {"en"}})
+	other := storeTestCache(t, repo, "https://example.com/other.html", nil)
+
+	var metadata model.CacheMetadata
+	data, _ := client.GetMetaData(context.Background(), _getMetaKey(ja.GenerateCacheKey()))
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := repo.PurgeCache(context.Background(), url)
+	if err != nil || purged != 2 {
+		t.Fatalf("Expected 2 purged, got %d (err=%v)", purged, err)
+	}
+	for _, req := range []*model.BpRequest{ja, en} {
+		if _, ok := client.meta[_getMetaKey(req.GenerateCacheKey())]; ok {
+			t.Errorf("Expected metadata for %v to be deleted", req.Headers)
+		}
+		if _, ok := client.index[req.GenerateCacheKey()]; ok {
+			t.Errorf("Expected index for %v to be deleted", req.Headers)
+		}
+	}
+	if _, err := os.Stat(metadata.FilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", metadata.FilePath, err)
+	}
+	if _, err := os.Stat(metadata.FilePath + ".purging"); !os.IsNotExist(err) {
+		t.Errorf("Expected no leftover purging file, got %v", err)
+	}
+	if _, found, _ := repo.GetResponse(context.Background(), other.GenerateCacheKey()); !found {
+		t.Errorf("Expected other URLs to stay cached")
+	}
+
+	if purged, err := repo.PurgeCache(context.Background(), url); err != nil || purged != 0 {
+		t.Errorf("Expected nothing left to purge, got %d (err=%v)", purged, err)
+	}
+}
+
+func TestPurgeCacheKeepsFileWhenMetadataDeleteFails(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	req := storeTestCache(t, repo, "https://example.com/keep.html", nil)
+	client.failDel = true
+
+	if _, err := repo.PurgeCache(context.Background(), req.URL); err == nil {
+		t.Fatal("Expected purge to fail")
+	}
+	client.failDel = false
+	resp, found, err := repo.GetResponse(context.Background(), req.GenerateCacheKey())
+	if err != nil || !found || string(resp.Body) != "<html>"+req.URL+"</html>" {
+		t.Errorf("Expected the cache to remain intact, got found=%v err=%v", found, err)
+	}
+}
+
+func TestCheckCacheDir(t *testing.T) {
+	br := NewBpRepository(newMemoryRepoClient(), t.TempDir(), 0, 0, 0)
+	if err := br.CheckCacheDir(context.Background()); err != nil {
+		t.Fatalf("expected writable cache dir, got %v", err)
+	}
+	entries, _ := os.ReadDir(br.cacheDir)
+	if len(entries) != 0 {
+		t.Errorf("expected health check file to be removed, got %v", entries)
+	}
+
+	// キャッシュディレクトリの場所にファイルがあり、書き込めない
+	file := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	br = NewBpRepository(newMemoryRepoClient(), file, 0, 0, 0)
+	if err := br.CheckCacheDir(context.Background()); err == nil {
+		t.Error("expected error for non-directory cache path")
+	}
+}
+
+func TestCacheIndexUsesNormalizedURL(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	storeTestCache(t, repo, "HTTP://Example.com:80/a/./b/../page.html?b=2&a=1", nil)
+
+	// 表記の違うURLでも同じキャッシュが見つかる
+	for _, url := range []string{"http://example.com/a/page.html?a=1&b=2", "http://EXAMPLE.com/a/%70age.html?b=2&a=1"} {
+		entries, err := repo.GetCacheEntries(context.Background(), url)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("Expected 1 entry for %s, got %v (err=%v)", url, entries, err)
+		}
+		if entries[0].URL != "http://example.com/a/page.html?a=1&b=2" {
+			t.Errorf("Expected the canonical URL, got %s", entries[0].URL)
+		}
+	}
+
+	purged, err := repo.PurgeCache(context.Background(), "http://example.com:80/a/page.html?b=2&a=1")
+	if err != nil || purged != 1 {
+		t.Errorf("Expected to purge 1 entry by a non-canonical URL, got %d (err=%v)", purged, err)
+	}
+}
@@ -0,0 +1,225 @@
+// cache_size_test.go - キャッシュの大きさの上限による保存の拒否と、容量を超えたときの最終アクセスの古い順の削除のテスト
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// storeSizedCache size バイトのボディのキャッシュを保存する
+func storeSizedCache(repo *BpRepository, url string, size int) (*model.BpRequest, error) {
+	req := &model.BpRequest{Method: http.MethodGet, URL: url}
+	resp := &model.BpResponse{StatusCode: http.StatusOK, Body: bytes.Repeat([]byte("x"), size), ContentType: "application/octet-stream"}
+	return req, repo.SetResponseWithURL(context.Background(), req, resp, time.Hour)
+}
+
+func isCached(t *testing.T, repo *BpRepository, req *model.BpRequest) bool {
+	t.Helper()
+	_, found, err := repo.GetResponse(context.Background(), req.GenerateCacheKey())
+	if err != nil {
+		t.Fatalf("GetResponse failed: %v", err)
+	}
+	return found
+}
+
+func TestSetResponseRejectsTooLarge(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxObjectSize int64
+		maxCacheSize  int64
+		size          int
+		wantStored    bool
+	}{
+		{"no limits", 0, 0, 1000, true},
+		{"at object limit", 100, 0, 100, true},
+		{"above object limit", 100, 0, 101, false},
+		// キャッシュ全体の容量より大きいものは、1件の上限がなくても保存しない
+		{"above cache size", 0, 100, 101, false},
+		{"cache size below object limit", 500, 100, 101, false},
+		{"negative limits disabled", -1, -1, 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMemoryRepoClient()
+			repo := NewBpRepository(client, t.TempDir(), 0, tt.maxObjectSize, tt.maxCacheSize)
+
+			req, err := storeSizedCache(repo, "https://example.com/download.bin", tt.size)
+			if tt.wantStored {
+				if err != nil || !isCached(t, repo, req) {
+					t.Fatalf("Expected the response to be cached, got err=%v", err)
+				}
+				return
+			}
+			if !errors.Is(err, model.ErrCacheTooLarge) {
+				t.Fatalf("Expected ErrCacheTooLarge, got %v", err)
+			}
+			if len(client.meta) != 0 || len(client.index) != 0 {
+				t.Errorf("Expected no metadata or index, got %d / %d", len(client.meta), len(client.index))
+			}
+			files, _ := os.ReadDir(repo.cacheDir)
+			if len(files) != 0 {
+				t.Errorf("Expected no cache file to be written, got %v", files)
+			}
+		})
+	}
+}
+
+func TestSetResponseTooLargeKeepsExistingCache(t *testing.T) {
+	repo := NewBpRepository(newMemoryRepoClient(), t.TempDir(), 0, 100, 0)
+	req, err := storeSizedCache(repo, "https://example.com/file.bin", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storeSizedCache(repo, req.URL, 200); !errors.Is(err, model.ErrCacheTooLarge) {
+		t.Fatalf("Expected ErrCacheTooLarge, got %v", err)
+	}
+	resp, found, err := repo.GetResponse(context.Background(), req.GenerateCacheKey())
+	if err != nil || !found || len(resp.Body) != 50 {
+		t.Errorf("Expected the previous 50-byte cache to remain, got found=%v err=%v", found, err)
+	}
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 300)
+
+	a, _ := storeSizedCache(repo, "https://example.com/a", 100)
+	b, _ := storeSizedCache(repo, "https://example.com/b", 100)
+	c, _ := storeSizedCache(repo, "https://example.com/c", 100)
+
+	// aを読むと最終アクセスが最も新しくなり、保存した順では最も古いbが削除される
+	if !isCached(t, repo, a) {
+		t.Fatal("Expected a to be cached")
+	}
+	var bPath string
+	if metadata, found, _ := repo.getMetadata(context.Background(), b.GenerateCacheKey()); found {
+		bPath = metadata.FilePath
+	}
+	d, err := storeSizedCache(repo, "https://example.com/d", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		req  *model.BpRequest
+		want bool
+	}{{a, true}, {b, false}, {c, true}, {d, true}} {
+		if _, ok := client.index[tt.req.GenerateCacheKey()]; ok != tt.want {
+			t.Errorf("Expected %s cached=%v", tt.req.URL, tt.want)
+		}
+	}
+	if _, err := os.Stat(bPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the evicted file %s to be removed, got %v", bPath, err)
+	}
+
+	usage, err := repo.GetCacheUsage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Bytes != 300 || usage.Entries != 3 || usage.MaxBytes != 300 || usage.MaxObjectBytes != 300 || usage.Evictions != 1 || usage.EvictedBytes != 100 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	// 大きいキャッシュは、容量に収まるまで古い順に複数件を削除する
+	e, err := storeSizedCache(repo, "https://example.com/e", 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := client.LeastRecentlyUsedKeys(context.Background(), 10)
+	if len(keys) != 1 || keys[0] != e.GenerateCacheKey() {
+		t.Errorf("Expected only e to remain, got %v", keys)
+	}
+
+	// 削除した数と大きさは、起動してからの合計を返す
+	usage, _ = repo.GetCacheUsage(context.Background())
+	if usage.Evictions != 4 || usage.EvictedBytes != 400 {
+		t.Errorf("Expected 4 evictions of 400 bytes, got %+v", usage)
+	}
+}
+
+func TestEvictKeepsStoredCache(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 150)
+
+	first, _ := storeSizedCache(repo, "https://example.com/first", 100)
+	second, err := storeSizedCache(repo, "https://example.com/second", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isCached(t, repo, first) || !isCached(t, repo, second) {
+		t.Errorf("Expected the older cache to be evicted and the new one kept")
+	}
+
+	// メタデータだけが期限切れで消えたキャッシュは、インデックスから外して容量に数えない
+	third, _ := storeSizedCache(repo, "https://example.com/third", 100)
+	delete(client.meta, _getMetaKey(third.GenerateCacheKey()))
+	if _, err := storeSizedCache(repo, "https://example.com/fourth", 100); err != nil {
+		t.Fatal(err)
+	}
+	if used, entries, _ := client.GetCacheUsage(context.Background()); used != 100 || entries != 1 {
+		t.Errorf("Expected 1 entry of 100 bytes, got %d entries of %d bytes", entries, used)
+	}
+}
+
+func TestConcurrentAccessUpdatesLastAccess(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 1000)
+
+	old, _ := storeSizedCache(repo, "https://example.com/old", 100)
+	recent, _ := storeSizedCache(repo, "https://example.com/recent", 100)
+	stored := client.access[old.GenerateCacheKey()]
+
+	// 保存した順では古いoldを、同時に何度も読む
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				if _, found, err := repo.GetResponse(context.Background(), old.GenerateCacheKey()); err != nil || !found {
+					t.Errorf("Expected old to be cached, got found=%v err=%v", found, err)
+				}
+				return
+			}
+			resp, found, err := repo.GetResponseStream(context.Background(), old.GenerateCacheKey())
+			if err != nil || !found {
+				t.Errorf("Expected old to be cached, got found=%v err=%v", found, err)
+				return
+			}
+			resp.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	if !client.access[old.GenerateCacheKey()].After(stored) {
+		t.Errorf("Expected the last access of old to be updated")
+	}
+	keys, _ := client.LeastRecentlyUsedKeys(context.Background(), 10)
+	if len(keys) != 2 || keys[0] != recent.GenerateCacheKey() {
+		t.Fatalf("Expected recent to be the least recently used, got %v", keys)
+	}
+
+	// 古い時刻の記録が遅れて届いても、最終アクセス時刻は戻らない
+	latest := client.access[old.GenerateCacheKey()]
+	_ = client.TouchCacheIndex(context.Background(), old.GenerateCacheKey(), stored)
+	if !client.access[old.GenerateCacheKey()].Equal(latest) {
+		t.Errorf("Expected the last access not to move backwards")
+	}
+
+	// 容量を超えると、最近読まれていないrecentが先に削除される
+	repo.maxCacheSize = 200
+	if _, err := storeSizedCache(repo, "https://example.com/new", 100); err != nil {
+		t.Fatal(err)
+	}
+	if !isCached(t, repo, old) || isCached(t, repo, recent) {
+		t.Errorf("Expected recent to be evicted before the frequently read old")
+	}
+}
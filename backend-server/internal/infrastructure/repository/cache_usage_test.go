@@ -0,0 +1,147 @@
+// cache_usage_test.go - キャッシュ全体の使用量をキャッシュディレクトリのファイルと突き合わせて数え直すことのテスト
+package repository
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// ageCacheFiles キャッシュディレクトリのファイルの更新時刻を、書き込み中とみなさない程度に古くする
+func ageCacheFiles(t *testing.T, dir string) {
+	t.Helper()
+	old := time.Now().Add(-2 * orphanGrace)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	})
+	if err != nil {
+		t.Fatalf("Failed to age cache files: %v", err)
+	}
+}
+
+func cachedFilePath(t *testing.T, repo *BpRepository, req *model.BpRequest) string {
+	t.Helper()
+	metadata, found, err := repo.getMetadata(context.Background(), req.GenerateCacheKey())
+	if err != nil || !found {
+		t.Fatalf("Expected metadata for %s, got found=%v err=%v", req.URL, found, err)
+	}
+	return metadata.FilePath
+}
+
+func TestReconcileCacheUsage(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	ctx := context.Background()
+
+	shrunk, _ := storeSizedCache(repo, "https://example.com/shrunk", 100)
+	kept, _ := storeSizedCache(repo, "https://example.com/kept", 100)
+	noFile, _ := storeSizedCache(repo, "https://example.com/no-file", 100)
+	noMeta, _ := storeSizedCache(repo, "https://example.com/no-meta", 100)
+
+	// 記録した大きさとファイルがずれた状態を作る
+	// - shrunkのファイルは外から書き換えられて小さくなった
+	// - no-fileのファイルは消えたが、インデックスとメタデータは残っている
+	// - no-metaのメタデータは消えたが、インデックスとファイルは残っている
+	// - どのキャッシュからも参照されないファイルがある
+	if err := os.WriteFile(cachedFilePath(t, repo, shrunk), make([]byte, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(cachedFilePath(t, repo, noFile)); err != nil {
+		t.Fatal(err)
+	}
+	noMetaPath := cachedFilePath(t, repo, noMeta)
+	delete(client.meta, _getMetaKey(noMeta.GenerateCacheKey()))
+	orphan := filepath.Join(repo.cacheDir, "gone.example", "index.html")
+	if err := os.MkdirAll(filepath.Dir(orphan), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphan, make([]byte, 60), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ageCacheFiles(t, repo.cacheDir)
+	// 書いたばかりのファイルは、インデックスに登録する前の書き込み中のキャッシュかもしれないため残す
+	writing := filepath.Join(repo.cacheDir, "writing.example", "index.html")
+	if err := os.MkdirAll(filepath.Dir(writing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(writing, make([]byte, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.ReconcileCacheUsage(ctx)
+	if err != nil {
+		t.Fatalf("ReconcileCacheUsage failed: %v", err)
+	}
+	want := model.CacheReconcileResult{BytesBefore: 400, Bytes: 140, Entries: 2, Removed: 2, OrphanFiles: 2, OrphanBytes: 160}
+	if result != want {
+		t.Errorf("Expected %+v, got %+v", want, result)
+	}
+
+	if size := client.index[shrunk.GenerateCacheKey()].Size; size != 40 {
+		t.Errorf("Expected the index to record the 40-byte file, got %d", size)
+	}
+	for _, req := range []*model.BpRequest{noFile, noMeta} {
+		if _, ok := client.index[req.GenerateCacheKey()]; ok {
+			t.Errorf("Expected %s to be removed from the index", req.URL)
+		}
+	}
+	if _, ok := client.meta[_getMetaKey(noFile.GenerateCacheKey())]; ok {
+		t.Errorf("Expected the metadata without a file to be removed")
+	}
+	for _, path := range []string{noMetaPath, orphan} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected the unreferenced file %s to be removed, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(writing); err != nil {
+		t.Errorf("Expected the file being written to be kept, got %v", err)
+	}
+	if !isCached(t, repo, shrunk) || !isCached(t, repo, kept) {
+		t.Errorf("Expected the caches with files to be kept")
+	}
+
+	// ずれがなければ何も変えない
+	result, err = repo.ReconcileCacheUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (model.CacheReconcileResult{BytesBefore: 140, Bytes: 140, Entries: 2}); result != want {
+		t.Errorf("Expected %+v, got %+v", want, result)
+	}
+}
+
+func TestReconcileCacheUsageEvictsOverQuota(t *testing.T) {
+	client := newMemoryRepoClient()
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 250)
+	ctx := context.Background()
+
+	older, _ := storeSizedCache(repo, "https://example.com/older", 100)
+	grown, _ := storeSizedCache(repo, "https://example.com/grown", 100)
+	_ = client.TouchCacheIndex(ctx, grown.GenerateCacheKey(), time.Now().Add(time.Minute))
+
+	// ファイルが大きくなり、数え直すと容量を超える
+	if err := os.WriteFile(cachedFilePath(t, repo, grown), make([]byte, 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := repo.ReconcileCacheUsage(ctx)
+	if err != nil {
+		t.Fatalf("ReconcileCacheUsage failed: %v", err)
+	}
+	if result.BytesBefore != 200 || result.Bytes != 200 || result.Entries != 1 {
+		t.Errorf("Expected the total to be 200 bytes in 1 entry after eviction, got %+v", result)
+	}
+	if isCached(t, repo, older) || !isCached(t, repo, grown) {
+		t.Errorf("Expected the least recently used cache to be evicted")
+	}
+	if usage, _ := repo.GetCacheUsage(ctx); usage.Evictions != 1 || usage.EvictedBytes != 100 {
+		t.Errorf("Expected 1 eviction of 100 bytes, got %+v", usage)
+	}
+}
@@ -3,25 +3,111 @@ package repository
 import (
 	"context"
 	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
 )
 
 type CacheItem struct {
 	Key      string
 	FilePath string
+	// Negative エラーレスポンスのネガティブキャッシュか（クリーンアップの結果を分けて数えるため）
+	Negative bool
+}
+
+// ExpiredScan ScanExpiredKeysで1回に調べた結果
+type ExpiredScan struct {
+	// Items 調べたキーのうち期限切れのメタデータ
+	Items []CacheItem
+	// Cursor 次に調べる位置（0はすべて調べ終えた）
+	Cursor uint64
+	// Scanned 調べたメタデータのキーの数
+	Scanned int
+}
+
+// CacheIndexEntry キャッシュの二次インデックスの1件
+// キャッシュキーはハッシュのため、URL・ドメインからキャッシュキーを引けるようにする
+// Sizeはキャッシュ全体の容量の計算に、StoredAtは最終アクセス時刻の初期値に使う
+type CacheIndexEntry struct {
+	CacheKey string
+	URL      string
+	Domain   string
+	Size     int64
+	StoredAt time.Time
 }
 
 type BpRepoClient interface {
 	GetMetaData(ctx context.Context, metaKey string) ([]byte, error)
-	ScanExpiredKeys(ctx context.Context) ([]CacheItem, error)
+	// ScanExpiredKeys cursor（最初は0）から1回分のメタデータのキーを調べ、期限切れのものを返す
+	// すべてを一度に読まないため、大量のキャッシュがあっても1回の呼び出しは短く終わる
+	ScanExpiredKeys(ctx context.Context, cursor uint64) (ExpiredScan, error)
 	SetMetaData(ctx context.Context, metaKey string, data []byte, ttl time.Duration) error
 	DeleteMetaData(ctx context.Context, metaKey string) error
 	FlushAllMetaData(ctx context.Context) error
-	ReserveRequest(ctx context.Context, job []byte) error
+	// ReserveRequest cacheKeyが予約済みでなければjobをpriorityと今の時刻で予約キューに追加し、cacheKeyを予約済みとして記録する（まとめて1回の操作で行う）
+	// 予約済みのcacheKeyをより高い優先度で予約した場合は、既にある予約を予約した時刻のままその優先度に上げる（事前取得の予約をブラウザが求めた場合など）
+	// 戻り値: 追加した場合はtrue、予約済みの場合はfalseと既にある予約のjob
+	ReserveRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) (bool, []byte, error)
+	// GetReservedRequests 予約キューの要素を、優先度の高い順、同じ優先度では時刻の古い順に返す
+	// 送り直しを待っている予約は、キューに戻す時刻をその予約の時刻として並べる
 	GetReservedRequests(ctx context.Context) ([][]byte, error)
-	RemoveReservedRequest(ctx context.Context, job []byte) error
+	// RemoveReservedRequest jobとcacheKeyの予約済みの記録にあるjobを、予約キューと処理中の予約から削除し、
+	// cacheKeyの予約済みの記録も削除する（まとめて1回の操作で行う）
+	RemoveReservedRequest(ctx context.Context, cacheKey string, job []byte) error
+	// RequeueReservedRequest cacheKeyの予約済みの記録にあるjobを処理中の予約と予約キューから削除し、jobをpriorityと今の時刻で（その優先度の最後に）予約キューに追加して、
+	// cacheKeyの予約済みの記録をjobに置き換える（まとめて1回の操作で行う）
+	RequeueReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) error
+	// DelayReservedRequest RequeueReservedRequestと同じだが、jobの時刻をreadyAtにし、readyAtを過ぎるまでは取り出さない
+	DelayReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority, readyAt time.Time) error
+	// BLPopReservedRequest 時刻を過ぎた予約のうち、優先度の最も高い、同じ優先度では時刻の最も古い予約をブロッキングで取り出す
+	// 取り出した予約は、取り出した時刻と優先度とともに処理中の予約へ移す（予約キューからの削除と同じ1回の操作で行う）
+	// 処理中の予約は、RemoveReservedRequest・RequeueReservedRequestまで残る（Workerが止まっても失われない）
 	BLPopReservedRequest(ctx context.Context, timeout time.Duration) ([]byte, error)
+	// RequeueStuckRequests claimedBeforeより前に取り出したまま処理中の予約を、取り出したときの優先度と今の時刻で予約キューに戻す
+	// 戻り値: キューに戻したjob（取り出した順）
+	RequeueStuckRequests(ctx context.Context, claimedBefore time.Time) ([][]byte, error)
+	// DeadLetterReservedRequest RemoveReservedRequestと同じく予約を削除し、cacheKeyのデッドレターをentryにする（まとめて1回の操作で行う）
+	// デッドレターはFlushAllReservedRequest・FlushAllCachesでは削除しない
+	DeadLetterReservedRequest(ctx context.Context, cacheKey string, job []byte, entry []byte) error
+	// GetDeadLetter cacheKeyのデッドレターを返す（ない場合はnil）
+	GetDeadLetter(ctx context.Context, cacheKey string) ([]byte, error)
+	// GetDeadLetters デッドレターをすべて返す
+	GetDeadLetters(ctx context.Context) ([][]byte, error)
+	// DeleteDeadLetter cacheKeyのデッドレターを削除する
+	DeleteDeadLetter(ctx context.Context, cacheKey string) error
+	// SetSentRequest requestIDの送信の記録をdataにする
+	SetSentRequest(ctx context.Context, requestID string, data []byte) error
+	// GetSentRequest requestIDの送信の記録を返す（ない場合はnil）
+	GetSentRequest(ctx context.Context, requestID string) ([]byte, error)
+	// GetSentRequests 送信の記録をすべて返す
+	GetSentRequests(ctx context.Context) ([][]byte, error)
+	// DeleteSentRequest requestIDの送信の記録を削除する
+	DeleteSentRequest(ctx context.Context, requestID string) error
 	AddPendingRequest(ctx context.Context, url string) (bool, error)
 	RemovePendingRequest(ctx context.Context, url string) error
 	FlushAllReservedRequest(ctx context.Context) error
+	// FlushAllCaches キャッシュのメタデータと二次インデックス（使用量を含む）を削除する
+	// 予約キュー・処理中の予約・予約済みのキャッシュキー・送信の記録は削除しない（FlushAllReservedRequestで削除する）
 	FlushAllCaches(ctx context.Context) error
+
+	// AddCacheIndex キャッシュを二次インデックスに登録し、大きさをキャッシュ全体の容量に加える（同じキャッシュキーは置き換える）
+	AddCacheIndex(ctx context.Context, entry CacheIndexEntry) error
+	// RemoveCacheIndex キャッシュキーを二次インデックスから削除し、大きさをキャッシュ全体の容量から引く（登録されていなければ何もしない）
+	RemoveCacheIndex(ctx context.Context, cacheKey string) error
+	// TouchCacheIndex キャッシュキーの最終アクセス時刻を記録する（登録されていない、またはより新しい時刻が記録済みの場合は何もしない）
+	TouchCacheIndex(ctx context.Context, cacheKey string, accessedAt time.Time) error
+	// LeastRecentlyUsedKeys 最終アクセス時刻の古い順にlimit件のキャッシュキーを返す
+	LeastRecentlyUsedKeys(ctx context.Context, limit int) ([]string, error)
+	// GetCacheUsage 二次インデックスに登録したキャッシュの大きさの合計（バイト）と件数を返す
+	GetCacheUsage(ctx context.Context) (int64, int, error)
+	// RecountCacheUsage 登録済みのキャッシュキーの大きさをsizesの値に置き換え、容量の合計と件数を登録済みのキャッシュから数え直す
+	// （sizesにあっても登録されていないキーは無視する。合計を加減だけで保つと、ファイルとずれたまま戻らないため）
+	RecountCacheUsage(ctx context.Context, sizes map[string]int64) error
+	// GetCacheKeysByURL URLのキャッシュキーを返す
+	GetCacheKeysByURL(ctx context.Context, url string) ([]string, error)
+	// ListCacheKeys キャッシュキーを保存時刻の新しい順に返す（domainが空でなければそのドメインだけ）
+	// 戻り値: offset件目からlimit件までのキャッシュキーと総数
+	ListCacheKeys(ctx context.Context, domain string, offset, limit int) ([]string, int, error)
+
+	// Ping Redisに接続できるかを確認する
+	Ping(ctx context.Context) error
 }
@@ -0,0 +1,837 @@
+// bolt_client.go - Redisの代わりにbbolt（1つのファイルのKVS）にメタデータ・予約キュー・インデックスを持つBpRepoClient（Redisを動かさない1台構成用）
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+	bolt "go.etcd.io/bbolt"
+)
+
+// バケット（RedisClientのキーに対応する）
+//   - meta           メタデータキー → 消える時刻（Unixナノ秒、0はTTLなし）+ メタデータ
+//   - meta_expiry    消える時刻 + メタデータキー（ScanExpiredKeysで期限を過ぎたものだけを読む）
+//   - reserved       優先度 + 時刻（Unixナノ秒、送り直しを待っている予約は戻す時刻）+ job → なし（予約キュー、キーの順に取り出す）
+//   - reserved_keys  キャッシュキー → キューに追加したjob
+//   - processing     取り出した時刻（Unixナノ秒）+ job → 取り出す前の優先度
+//   - dead_letters   キャッシュキー → デッドレター
+//   - sent           RequestID → 送信の記録
+//   - pending        URL → なし
+//   - cache_index    キャッシュキー → CacheIndexEntryのJSON
+//   - cache_all      保存時刻（Unix秒）+ キャッシュキー
+//   - cache_domain   ドメイン + 0x00 + 保存時刻（Unix秒）+ キャッシュキー
+//   - cache_url      URL + 0x00 + キャッシュキー
+//   - cache_lru      最終アクセス時刻（Unixミリ秒）+ キャッシュキー
+//   - cache_access   キャッシュキー → 最終アクセス時刻（Unixミリ秒、cache_lruのキーを引くため）
+//   - cache_usage    bytes・entries → キャッシュの大きさの合計と件数
+var (
+	bucketMeta         = []byte("meta")
+	bucketMetaExpiry   = []byte("meta_expiry")
+	bucketReserved     = []byte("reserved")
+	bucketReservedKeys = []byte("reserved_keys")
+	bucketProcessing   = []byte("processing")
+	bucketDeadLetters  = []byte("dead_letters")
+	bucketSent         = []byte("sent")
+	bucketPending      = []byte("pending")
+	bucketCacheIndex   = []byte("cache_index")
+	bucketCacheAll     = []byte("cache_all")
+	bucketCacheDomain  = []byte("cache_domain")
+	bucketCacheURL     = []byte("cache_url")
+	bucketCacheLRU     = []byte("cache_lru")
+	bucketCacheAccess  = []byte("cache_access")
+	bucketCacheUsage   = []byte("cache_usage")
+
+	metaBuckets     = [][]byte{bucketMeta, bucketMetaExpiry}
+	reserveBuckets  = [][]byte{bucketReserved, bucketReservedKeys, bucketProcessing, bucketSent}
+	indexBuckets    = [][]byte{bucketCacheIndex, bucketCacheAll, bucketCacheDomain, bucketCacheURL, bucketCacheLRU, bucketCacheAccess, bucketCacheUsage}
+	boltBuckets     = slices.Concat(metaBuckets, reserveBuckets, [][]byte{bucketPending, bucketDeadLetters}, indexBuckets)
+	usageBytesKey   = []byte("bytes")
+	usageEntriesKey = []byte("entries")
+)
+
+// BoltClient RedisClientと同じ振る舞いをbboltのファイルで行う
+// キューの順序（優先度の高い順、同じ優先度では時刻の古い順に取り出す）やTTL、送り直しを待っている予約の扱いもRedisClientに合わせる
+// MemoryClientと違い、再起動しても予約とキャッシュのメタデータを引き継ぐ
+type BoltClient struct {
+	db  *bolt.DB
+	now func() time.Time
+
+	mu     sync.Mutex
+	notify chan struct{} // 予約が追加されたときに閉じて作り直し、BLPopReservedRequestで待っている呼び出しを起こす
+}
+
+var _ repository.BpRepoClient = (*BoltClient)(nil)
+
+// NewBoltClient pathのデータベースを開く（なければディレクトリとファイルを作る）
+// 他のプロセスが開いている場合は1秒待って失敗する
+func NewBoltClient(path string) (*BoltClient, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the directory for %s: %w", path, err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets in %s: %w", path, err)
+	}
+	return &BoltClient{db: db, now: time.Now, notify: make(chan struct{})}, nil
+}
+
+// Close データベースを閉じ、BLPopReservedRequestで待っている呼び出しをエラーで返す
+func (bc *BoltClient) Close() error {
+	err := bc.db.Close()
+	bc.wakeWaiters()
+	return err
+}
+
+func (bc *BoltClient) Ping(ctx context.Context) error {
+	return bc.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// sortableInt 符号付きの整数を、バイト列の順序が数値の順序と同じになる8バイトにする
+func sortableInt(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n)^(1<<63))
+	return b
+}
+
+func fromSortableInt(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b) ^ (1 << 63))
+}
+
+// compositeKey 区切りの0x00を挟んでキーを連結する（URL・ドメインには0x00が含まれない）
+func compositeKey(prefix string, rest ...[]byte) []byte {
+	return slices.Concat(append([][]byte{[]byte(prefix), {0}}, rest...)...)
+}
+
+// recreateBuckets バケットを削除して空で作り直す
+func recreateBuckets(tx *bolt.Tx, names [][]byte) error {
+	for _, name := range names {
+		if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bc *BoltClient) GetMetaData(ctx context.Context, metaKey string) ([]byte, error) {
+	var data []byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketMeta).Get([]byte(metaKey))
+		if v == nil {
+			return nil
+		}
+		// RedisではTTLを過ぎたキーは読めない
+		if deadline := fromSortableInt(v[:8]); deadline != 0 && !bc.now().Before(time.Unix(0, deadline)) {
+			return nil
+		}
+		data = slices.Clone(v[8:])
+		return nil
+	})
+	return data, err
+}
+
+// ScanExpiredKeys 消える時刻のインデックスから、TTLを過ぎたメタデータと、RedisClientと同じくTTLのないメタデータを最大でexpiredScanBatch件返す
+// MemoryClientと同じく、TTLを過ぎたメタデータはDeleteMetaDataを呼ぶまで残るため、キャッシュファイルのパスも返せる
+// インデックスは期限切れのものから並ぶため、cursorは使わずに先頭から読む（返したものを削除すれば、次の呼び出しはその続きになる）
+func (bc *BoltClient) ScanExpiredKeys(ctx context.Context, cursor uint64) (repository.ExpiredScan, error) {
+	var scan repository.ExpiredScan
+	now := sortableInt(bc.now().UnixNano())
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		c := tx.Bucket(bucketMetaExpiry).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:8], now) <= 0; k, _ = c.Next() {
+			if len(scan.Items) == expiredScanBatch {
+				// 続きは次の呼び出しで返す
+				scan.Cursor = 1
+				break
+			}
+			scan.Scanned++
+			item := repository.CacheItem{Key: string(k[8:])}
+			var metadata model.CacheMetadata
+			if v := meta.Get(k[8:]); v != nil && json.Unmarshal(v[8:], &metadata) == nil {
+				item.FilePath = metadata.FilePath
+				item.Negative = metadata.Negative
+			}
+			scan.Items = append(scan.Items, item)
+		}
+		return nil
+	})
+	return scan, err
+}
+
+func (bc *BoltClient) SetMetaData(ctx context.Context, metaKey string, data []byte, ttl time.Duration) error {
+	var deadline int64
+	if ttl > 0 {
+		deadline = bc.now().Add(ttl).UnixNano()
+	}
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteMeta(tx, []byte(metaKey)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketMeta).Put([]byte(metaKey), slices.Concat(sortableInt(deadline), data)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketMetaExpiry).Put(slices.Concat(sortableInt(deadline), []byte(metaKey)), nil)
+	})
+}
+
+// deleteMeta メタデータと、消える時刻のインデックスの要素を削除する
+func deleteMeta(tx *bolt.Tx, metaKey []byte) error {
+	meta := tx.Bucket(bucketMeta)
+	v := meta.Get(metaKey)
+	if v == nil {
+		return nil
+	}
+	if err := tx.Bucket(bucketMetaExpiry).Delete(slices.Concat(v[:8], metaKey)); err != nil {
+		return err
+	}
+	return meta.Delete(metaKey)
+}
+
+func (bc *BoltClient) DeleteMetaData(ctx context.Context, metaKey string) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return deleteMeta(tx, []byte(metaKey))
+	})
+}
+
+func (bc *BoltClient) FlushAllMetaData(ctx context.Context) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return recreateBuckets(tx, metaBuckets)
+	})
+}
+
+// queueKey 予約キュー（reservedバケット）のキー（優先度 + 時刻（Unixナノ秒）+ job）
+// キーの順が取り出す順（優先度の高い順、同じ優先度では時刻の古い順）になる
+func queueKey(priority model.ReservationPriority, at time.Time, job []byte) []byte {
+	return slices.Concat([]byte{byte(priority)}, sortableInt(at.UnixNano()), job)
+}
+
+// findQueued 予約キューでjobと同じ要素のキー（ない場合はnil）
+func findQueued(queue *bolt.Bucket, job []byte) []byte {
+	c := queue.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if bytes.Equal(k[9:], job) {
+			return slices.Clone(k)
+		}
+	}
+	return nil
+}
+
+// waitCh 次に予約が追加されたときに閉じるチャンネル
+func (bc *BoltClient) waitCh() <-chan struct{} {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.notify
+}
+
+// wakeWaiters BLPopReservedRequestで待っている呼び出しを起こす
+func (bc *BoltClient) wakeWaiters() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	close(bc.notify)
+	bc.notify = make(chan struct{})
+}
+
+func (bc *BoltClient) ReserveRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) (bool, []byte, error) {
+	var queued bool
+	var result []byte
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bucketReservedKeys)
+		queue := tx.Bucket(bucketReserved)
+		if existing := keys.Get([]byte(cacheKey)); existing != nil {
+			result = slices.Clone(existing)
+			// 既にある予約より高い優先度で予約した場合は、時刻はそのままで優先度を上げる
+			k := findQueued(queue, result)
+			if k == nil || model.ReservationPriority(k[0]) <= priority {
+				return nil
+			}
+			if err := queue.Delete(k); err != nil {
+				return err
+			}
+			k[0] = byte(priority)
+			return queue.Put(k, nil)
+		}
+
+		if err := keys.Put([]byte(cacheKey), job); err != nil {
+			return err
+		}
+		if err := queue.Put(queueKey(priority, bc.now(), job), nil); err != nil {
+			return err
+		}
+		queued, result = true, slices.Clone(job)
+		return nil
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	if queued {
+		bc.wakeWaiters()
+	}
+	return queued, result, nil
+}
+
+func (bc *BoltClient) GetReservedRequests(ctx context.Context) ([][]byte, error) {
+	var result [][]byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketReserved).ForEach(func(k, v []byte) error {
+			result = append(result, slices.Clone(k[9:]))
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (bc *BoltClient) RemoveReservedRequest(ctx context.Context, cacheKey string, job []byte) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return removeReserved(tx, cacheKey, job)
+	})
+}
+
+// removeReserved jobと予約済みの記録にあるjobを、予約キューと処理中の予約から削除し、予約済みの記録も削除する
+func removeReserved(tx *bolt.Tx, cacheKey string, job []byte) error {
+	keys := tx.Bucket(bucketReservedKeys)
+	jobs := [][]byte{job}
+	if existing := keys.Get([]byte(cacheKey)); existing != nil && !bytes.Equal(existing, job) {
+		jobs = append(jobs, slices.Clone(existing))
+	}
+	for _, job := range jobs {
+		if err := removeJob(tx, job); err != nil {
+			return err
+		}
+	}
+	return keys.Delete([]byte(cacheKey))
+}
+
+// removeJob jobを予約キューと処理中の予約から削除する
+func removeJob(tx *bolt.Tx, job []byte) error {
+	if k := findQueued(tx.Bucket(bucketReserved), job); k != nil {
+		if err := tx.Bucket(bucketReserved).Delete(k); err != nil {
+			return err
+		}
+	}
+	c := tx.Bucket(bucketProcessing).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if bytes.Equal(k[8:], job) {
+			return c.Delete()
+		}
+	}
+	return nil
+}
+
+func (bc *BoltClient) RequeueReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) error {
+	// 今の時刻で、同じ優先度の予約の最後に戻す
+	return bc.requeue(cacheKey, job, priority, bc.now())
+}
+
+func (bc *BoltClient) DelayReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority, readyAt time.Time) error {
+	// 戻す時刻をキーの時刻にするため、BLPopReservedRequestはその時刻を過ぎるまで取り出さない
+	return bc.requeue(cacheKey, job, priority, readyAt)
+}
+
+// requeue 予約済みの記録にあるjobを予約キューと処理中の予約から削除し、jobを優先度と時刻atで予約キューに追加する
+func (bc *BoltClient) requeue(cacheKey string, job []byte, priority model.ReservationPriority, at time.Time) error {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bucketReservedKeys)
+		if existing := keys.Get([]byte(cacheKey)); existing != nil {
+			if err := removeJob(tx, slices.Clone(existing)); err != nil {
+				return err
+			}
+		}
+		if err := keys.Put([]byte(cacheKey), job); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketReserved).Put(queueKey(priority, at, job), nil)
+	})
+	if err != nil {
+		return err
+	}
+	bc.wakeWaiters()
+	return nil
+}
+
+// BLPopReservedRequest 時刻を過ぎた予約のうち、優先度の最も高い、同じ優先度では時刻の最も古い予約が追加されるまで待って取り出し、処理中の予約にする
+// RedisのBLPOPと同じく、timeoutが過ぎた場合はnil、timeoutが0の場合は予約が追加されるかctxが終わるまで待つ
+// 取り出しは1つのトランザクションで行うため、複数のワーカーが待っていても同じ予約を2回取り出さない
+func (bc *BoltClient) BLPopReservedRequest(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var readyTimer *time.Timer
+	defer func() {
+		if readyTimer != nil {
+			readyTimer.Stop()
+		}
+	}()
+	for {
+		// 取り出す前にチャンネルを受け取り、取り出してから待つまでの間に追加された予約も見逃さない
+		wake := bc.waitCh()
+		job, nextReady, err := bc.pop()
+		if err != nil || job != nil {
+			return job, err
+		}
+		// 送り直しを待っている予約は、戻す時刻に起きて取り出す
+		var ready <-chan time.Time
+		if readyTimer != nil {
+			readyTimer.Stop()
+			readyTimer = nil
+		}
+		if !nextReady.IsZero() {
+			readyTimer = time.NewTimer(nextReady.Sub(bc.now()))
+			ready = readyTimer.C
+		}
+		select {
+		case <-wake:
+		case <-ready:
+		case <-expired:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pop 優先度ごとにその優先度の最初（時刻の最も古い）の予約を調べ、時刻を過ぎた最初の予約を取り出して処理中の予約にする
+// 取り出せる予約がない場合はnilと、次に時刻を過ぎる予約の時刻（ない場合はゼロ）を返す
+func (bc *BoltClient) pop() ([]byte, time.Time, error) {
+	var job []byte
+	var nextReady time.Time
+	now := bc.now()
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketReserved).Cursor()
+		for _, priority := range model.ReservationPriorities {
+			k, _ := c.Seek([]byte{byte(priority)})
+			if k == nil || model.ReservationPriority(k[0]) != priority {
+				continue
+			}
+			if at := time.Unix(0, fromSortableInt(k[1:9])); at.After(now) {
+				if nextReady.IsZero() || at.Before(nextReady) {
+					nextReady = at
+				}
+				continue
+			}
+			job = slices.Clone(k[9:])
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			return tx.Bucket(bucketProcessing).Put(slices.Concat(sortableInt(now.UnixNano()), job), []byte{byte(priority)})
+		}
+		return nil
+	})
+	if job != nil {
+		nextReady = time.Time{}
+	}
+	return job, nextReady, err
+}
+
+// RequeueStuckRequests claimedBeforeより前に取り出したまま処理中の予約を、取り出す前の優先度と今の時刻で予約キューに戻す
+func (bc *BoltClient) RequeueStuckRequests(ctx context.Context, claimedBefore time.Time) ([][]byte, error) {
+	var requeued [][]byte
+	now := bc.now()
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		cutoff := sortableInt(claimedBefore.UnixNano())
+		c := tx.Bucket(bucketProcessing).Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k[:8], cutoff) < 0; k, v = c.First() {
+			job := slices.Clone(k[8:])
+			if err := tx.Bucket(bucketReserved).Put(queueKey(model.ReservationPriority(v[0]), now, job), nil); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			requeued = append(requeued, job)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(requeued) > 0 {
+		bc.wakeWaiters()
+	}
+	return requeued, nil
+}
+
+func (bc *BoltClient) DeadLetterReservedRequest(ctx context.Context, cacheKey string, job []byte, entry []byte) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		if err := removeReserved(tx, cacheKey, job); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketDeadLetters).Put([]byte(cacheKey), entry)
+	})
+}
+
+func (bc *BoltClient) GetDeadLetter(ctx context.Context, cacheKey string) ([]byte, error) {
+	var data []byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		data = slices.Clone(tx.Bucket(bucketDeadLetters).Get([]byte(cacheKey)))
+		return nil
+	})
+	return data, err
+}
+
+func (bc *BoltClient) GetDeadLetters(ctx context.Context) ([][]byte, error) {
+	var result [][]byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDeadLetters).ForEach(func(k, v []byte) error {
+			result = append(result, slices.Clone(v))
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (bc *BoltClient) DeleteDeadLetter(ctx context.Context, cacheKey string) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDeadLetters).Delete([]byte(cacheKey))
+	})
+}
+
+func (bc *BoltClient) SetSentRequest(ctx context.Context, requestID string, data []byte) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSent).Put([]byte(requestID), data)
+	})
+}
+
+func (bc *BoltClient) GetSentRequest(ctx context.Context, requestID string) ([]byte, error) {
+	var data []byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		data = slices.Clone(tx.Bucket(bucketSent).Get([]byte(requestID)))
+		return nil
+	})
+	return data, err
+}
+
+func (bc *BoltClient) GetSentRequests(ctx context.Context) ([][]byte, error) {
+	var result [][]byte
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSent).ForEach(func(k, v []byte) error {
+			result = append(result, slices.Clone(v))
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (bc *BoltClient) DeleteSentRequest(ctx context.Context, requestID string) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSent).Delete([]byte(requestID))
+	})
+}
+
+func (bc *BoltClient) AddPendingRequest(ctx context.Context, url string) (bool, error) {
+	var added bool
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(bucketPending)
+		if pending.Get([]byte(url)) != nil {
+			return nil
+		}
+		added = true
+		return pending.Put([]byte(url), []byte{})
+	})
+	return added, err
+}
+
+func (bc *BoltClient) RemovePendingRequest(ctx context.Context, url string) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).Delete([]byte(url))
+	})
+}
+
+// FlushAllReservedRequest 予約キューと処理中の予約、予約済みのキャッシュキー・送ったリクエストの記録を削除する
+// デッドレターは調べられるように残す
+func (bc *BoltClient) FlushAllReservedRequest(ctx context.Context) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return recreateBuckets(tx, reserveBuckets)
+	})
+}
+
+func (bc *BoltClient) FlushAllCaches(ctx context.Context) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return recreateBuckets(tx, slices.Concat(metaBuckets, indexBuckets))
+	})
+}
+
+// addUsage キャッシュの大きさの合計と件数に差を加える
+func addUsage(tx *bolt.Tx, bytesDelta int64, entriesDelta int64) error {
+	usage := tx.Bucket(bucketCacheUsage)
+	for _, d := range []struct {
+		key   []byte
+		delta int64
+	}{{usageBytesKey, bytesDelta}, {usageEntriesKey, entriesDelta}} {
+		if d.delta == 0 {
+			continue
+		}
+		if err := usage.Put(d.key, sortableInt(usageValue(usage, d.key)+d.delta)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func usageValue(usage *bolt.Bucket, key []byte) int64 {
+	v := usage.Get(key)
+	if v == nil {
+		return 0
+	}
+	return fromSortableInt(v)
+}
+
+// removeIndexEntry キャッシュキーをインデックスから外す（大きさと件数は呼び出し側で合計から引く）
+// 戻り値: 登録していたエントリ（ない場合はnil）と、最終アクセス時刻が記録されていたか
+func removeIndexEntry(tx *bolt.Tx, cacheKey []byte) (*repository.CacheIndexEntry, bool, error) {
+	var old *repository.CacheIndexEntry
+	index := tx.Bucket(bucketCacheIndex)
+	if v := index.Get(cacheKey); v != nil {
+		var entry repository.CacheIndexEntry
+		if err := json.Unmarshal(v, &entry); err == nil {
+			old = &entry
+			stored := sortableInt(entry.StoredAt.Unix())
+			if err := tx.Bucket(bucketCacheAll).Delete(slices.Concat(stored, cacheKey)); err != nil {
+				return nil, false, err
+			}
+			if err := tx.Bucket(bucketCacheDomain).Delete(compositeKey(entry.Domain, stored, cacheKey)); err != nil {
+				return nil, false, err
+			}
+			if err := tx.Bucket(bucketCacheURL).Delete(compositeKey(entry.URL, cacheKey)); err != nil {
+				return nil, false, err
+			}
+		}
+		if err := index.Delete(cacheKey); err != nil {
+			return nil, false, err
+		}
+	}
+
+	access := tx.Bucket(bucketCacheAccess)
+	accessedAt := access.Get(cacheKey)
+	if accessedAt == nil {
+		return old, false, nil
+	}
+	if err := tx.Bucket(bucketCacheLRU).Delete(slices.Concat(accessedAt, cacheKey)); err != nil {
+		return nil, false, err
+	}
+	return old, true, access.Delete(cacheKey)
+}
+
+// AddCacheIndex キャッシュを登録し、置き換える前の大きさとの差を容量の合計に加える（最終アクセス時刻は保存時刻にする）
+func (bc *BoltClient) AddCacheIndex(ctx context.Context, entry repository.CacheIndexEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(entry.CacheKey)
+		old, accessed, err := removeIndexEntry(tx, key)
+		if err != nil {
+			return err
+		}
+		bytesDelta, entriesDelta := entry.Size, int64(1)
+		if old != nil {
+			bytesDelta -= old.Size
+		}
+		if accessed {
+			entriesDelta = 0
+		}
+
+		stored := sortableInt(entry.StoredAt.Unix())
+		accessedAt := sortableInt(entry.StoredAt.UnixMilli())
+		puts := []struct {
+			bucket     []byte
+			key, value []byte
+		}{
+			{bucketCacheIndex, key, data},
+			{bucketCacheAll, slices.Concat(stored, key), nil},
+			{bucketCacheDomain, compositeKey(entry.Domain, stored, key), nil},
+			{bucketCacheURL, compositeKey(entry.URL, key), nil},
+			{bucketCacheLRU, slices.Concat(accessedAt, key), nil},
+			{bucketCacheAccess, key, accessedAt},
+		}
+		for _, p := range puts {
+			if err := tx.Bucket(p.bucket).Put(p.key, p.value); err != nil {
+				return err
+			}
+		}
+		return addUsage(tx, bytesDelta, entriesDelta)
+	})
+}
+
+func (bc *BoltClient) RemoveCacheIndex(ctx context.Context, cacheKey string) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		old, accessed, err := removeIndexEntry(tx, []byte(cacheKey))
+		if err != nil {
+			return err
+		}
+		var bytesDelta, entriesDelta int64
+		if old != nil {
+			bytesDelta = -old.Size
+		}
+		if accessed {
+			entriesDelta = -1
+		}
+		return addUsage(tx, bytesDelta, entriesDelta)
+	})
+}
+
+func (bc *BoltClient) TouchCacheIndex(ctx context.Context, cacheKey string, accessedAt time.Time) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(cacheKey)
+		access := tx.Bucket(bucketCacheAccess)
+		current := access.Get(key)
+		next := sortableInt(accessedAt.UnixMilli())
+		if current == nil || bytes.Compare(next, current) <= 0 {
+			return nil
+		}
+		lru := tx.Bucket(bucketCacheLRU)
+		if err := lru.Delete(slices.Concat(current, key)); err != nil {
+			return err
+		}
+		if err := lru.Put(slices.Concat(next, key), nil); err != nil {
+			return err
+		}
+		return access.Put(key, next)
+	})
+}
+
+// LeastRecentlyUsedKeys 最終アクセス時刻の古い順（同じ時刻はキャッシュキーの順、RedisのZRANGEと同じ）にlimit件返す
+func (bc *BoltClient) LeastRecentlyUsedKeys(ctx context.Context, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var keys []string
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketCacheLRU).Cursor()
+		for k, _ := c.First(); k != nil && len(keys) < limit; k, _ = c.Next() {
+			keys = append(keys, string(k[8:]))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (bc *BoltClient) GetCacheUsage(ctx context.Context) (int64, int, error) {
+	var total, entries int64
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		usage := tx.Bucket(bucketCacheUsage)
+		total, entries = usageValue(usage, usageBytesKey), usageValue(usage, usageEntriesKey)
+		return nil
+	})
+	return total, int(entries), err
+}
+
+func (bc *BoltClient) RecountCacheUsage(ctx context.Context, sizes map[string]int64) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(bucketCacheIndex)
+		for cacheKey, size := range sizes {
+			v := index.Get([]byte(cacheKey))
+			if v == nil {
+				continue
+			}
+			var entry repository.CacheIndexEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			entry.Size = size
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := index.Put([]byte(cacheKey), data); err != nil {
+				return err
+			}
+		}
+
+		var total int64
+		err := index.ForEach(func(k, v []byte) error {
+			var entry repository.CacheIndexEntry
+			if err := json.Unmarshal(v, &entry); err == nil {
+				total += entry.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		var entries int64
+		_ = tx.Bucket(bucketCacheAccess).ForEach(func(k, v []byte) error {
+			entries++
+			return nil
+		})
+		usage := tx.Bucket(bucketCacheUsage)
+		if err := usage.Put(usageBytesKey, sortableInt(total)); err != nil {
+			return err
+		}
+		return usage.Put(usageEntriesKey, sortableInt(entries))
+	})
+}
+
+func (bc *BoltClient) GetCacheKeysByURL(ctx context.Context, url string) ([]string, error) {
+	var keys []string
+	prefix := compositeKey(url)
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketCacheURL).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, string(k[len(prefix):]))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// ListCacheKeys キャッシュキーを保存時刻の新しい順（RedisClientと同じく秒単位、同じ時刻はキャッシュキーの逆順）に返す
+func (bc *BoltClient) ListCacheKeys(ctx context.Context, domain string, offset, limit int) ([]string, int, error) {
+	bucket, prefix := bucketCacheAll, []byte{}
+	if domain != "" {
+		bucket, prefix = bucketCacheDomain, compositeKey(domain)
+	}
+	var keys []string
+	total := 0
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		// 接頭辞の範囲の最後から逆順にたどる（ドメインの接頭辞は0x00で終わるため、0x01にした位置の1つ前が最後）
+		var k []byte
+		if len(prefix) == 0 {
+			k, _ = c.Last()
+		} else if k, _ = c.Seek(slices.Concat(prefix[:len(prefix)-1], []byte{1})); k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+			if total >= offset && len(keys) < limit {
+				keys = append(keys, string(k[len(prefix)+8:]))
+			}
+			total++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return keys, total, nil
+}
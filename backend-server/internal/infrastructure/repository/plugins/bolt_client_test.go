@@ -0,0 +1,144 @@
+// bolt_client_test.go - bboltのBpRepoClientの、再起動後の引き継ぎ（処理中の予約を含む）と閉じたときの振る舞い、NewRepoClientのテスト
+package plugins
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+)
+
+func TestBoltClientKeepsDataAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nested", "repository.db")
+	client, err := NewBoltClient(path)
+	if err != nil {
+		t.Fatalf("NewBoltClient failed: %v", err)
+	}
+	_ = client.SetMetaData(ctx, "bp:cache:meta:a", []byte(`{"file_path":"/cache/a"}`), time.Hour)
+	_, _, _ = client.ReserveRequest(ctx, "a", []byte("job-a"), model.PriorityInteractive)
+	_, _, _ = client.ReserveRequest(ctx, "b", []byte("job-b"), model.PriorityPrefetch)
+	_ = client.AddCacheIndex(ctx, repository.CacheIndexEntry{CacheKey: "a", URL: "http://example.com/", Domain: "example.com", Size: 10, StoredAt: time.Now()})
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	client, err = NewBoltClient(path)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	defer client.Close()
+	if data, _ := client.GetMetaData(ctx, "bp:cache:meta:a"); string(data) != `{"file_path":"/cache/a"}` {
+		t.Errorf("Expected the metadata to be kept, got %q", data)
+	}
+	expectQueue(t, client, "job-a", "job-b")
+	if queued, _, _ := client.ReserveRequest(ctx, "a", []byte("job-a2"), model.PriorityInteractive); queued {
+		t.Error("Expected the reservation to be kept")
+	}
+	if bytes, entries, _ := client.GetCacheUsage(ctx); bytes != 10 || entries != 1 {
+		t.Errorf("Expected the index to be kept, got %d bytes in %d entries", bytes, entries)
+	}
+}
+
+func TestBoltClientRequeuesProcessingAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "repository.db")
+	client, err := NewBoltClient(path)
+	if err != nil {
+		t.Fatalf("NewBoltClient failed: %v", err)
+	}
+	_, _, _ = client.ReserveRequest(ctx, "a", []byte("job-a"), model.PriorityInteractive)
+	if job, _ := client.BLPopReservedRequest(ctx, time.Second); string(job) != "job-a" {
+		t.Fatalf("Expected job-a, got %q", job)
+	}
+	// 処理を終える前にプロセスが止まる
+	client.Close()
+
+	client, err = NewBoltClient(path)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	defer client.Close()
+	expectQueue(t, client)
+	jobs, err := client.RequeueStuckRequests(ctx, time.Now().Add(time.Minute))
+	if err != nil || len(jobs) != 1 || string(jobs[0]) != "job-a" {
+		t.Fatalf("Expected job-a to be requeued, got %q (%v)", jobs, err)
+	}
+	expectQueue(t, client, "job-a")
+}
+
+func TestBoltClientCloseWakesWaitingCalls(t *testing.T) {
+	client, err := NewBoltClient(filepath.Join(t.TempDir(), "repository.db"))
+	if err != nil {
+		t.Fatalf("NewBoltClient failed: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := client.BLPopReservedRequest(context.Background(), 0)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to wake the waiting call")
+	}
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("Expected Ping to fail after Close")
+	}
+}
+
+func TestNewRepoClient(t *testing.T) {
+	rclient := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer rclient.Close()
+	boltPath := filepath.Join(t.TempDir(), "repository.db")
+
+	tests := []struct {
+		name    string
+		conf    ClientConfig
+		check   func(repository.BpRepoClient) bool
+		wantErr string
+	}{
+		{name: "default", conf: ClientConfig{Redis: rclient}, check: func(c repository.BpRepoClient) bool { _, ok := c.(*RedisClient); return ok }},
+		{name: "redis", conf: ClientConfig{Type: ClientTypeRedis, Redis: rclient}, check: func(c repository.BpRepoClient) bool { _, ok := c.(*RedisClient); return ok }},
+		{name: "memory", conf: ClientConfig{Type: ClientTypeMemory}, check: func(c repository.BpRepoClient) bool { _, ok := c.(*MemoryClient); return ok }},
+		{name: "bolt", conf: ClientConfig{Type: ClientTypeBolt, BoltPath: boltPath}, check: func(c repository.BpRepoClient) bool { _, ok := c.(*BoltClient); return ok }},
+		{name: "redis without connection", conf: ClientConfig{Type: ClientTypeRedis}, wantErr: "no Redis connection"},
+		{name: "bolt without path", conf: ClientConfig{Type: ClientTypeBolt}, wantErr: "no database path"},
+		{name: "bolt in a file", conf: ClientConfig{Type: ClientTypeBolt, BoltPath: filepath.Join(boltPath, "repository.db")}, wantErr: "repository client bolt"},
+		{name: "unknown", conf: ClientConfig{Type: "memcached"}, wantErr: `unknown repository client "memcached"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewRepoClient(tt.conf)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				if client != nil {
+					t.Errorf("Expected no client on error, got %T", client)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewRepoClient failed: %v", err)
+			}
+			if !tt.check(client) {
+				t.Errorf("Unexpected client %T", client)
+			}
+			if closer, ok := client.(*BoltClient); ok {
+				closer.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,679 @@
+// client_contract_test.go - Redisを使わないBpRepoClient（memory・bolt）がRedisClientと同じ振る舞いをすることのテスト
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+)
+
+// forEachRepoClient Redisを使わないBpRepoClientのそれぞれでfnを実行する（TTLはclockで進める）
+func forEachRepoClient(t *testing.T, fn func(t *testing.T, client repository.BpRepoClient, clock *fakeClock)) {
+	clients := []struct {
+		name string
+		open func(t *testing.T, clock *fakeClock) repository.BpRepoClient
+	}{
+		{name: ClientTypeMemory, open: func(t *testing.T, clock *fakeClock) repository.BpRepoClient {
+			client := NewMemoryClient()
+			client.now = clock.Now
+			return client
+		}},
+		{name: ClientTypeBolt, open: func(t *testing.T, clock *fakeClock) repository.BpRepoClient {
+			client, err := NewBoltClient(filepath.Join(t.TempDir(), "repository.db"))
+			if err != nil {
+				t.Fatalf("NewBoltClient failed: %v", err)
+			}
+			t.Cleanup(func() { client.Close() })
+			client.now = clock.Now
+			return client
+		}},
+	}
+	for _, c := range clients {
+		t.Run(c.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Now()}
+			fn(t, c.open(t, clock), clock)
+		})
+	}
+}
+
+func jobsToStrings(jobs [][]byte) []string {
+	result := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		result = append(result, string(job))
+	}
+	return result
+}
+
+func expectQueue(t *testing.T, client repository.BpRepoClient, want ...string) {
+	t.Helper()
+	jobs, err := client.GetReservedRequests(context.Background())
+	if err != nil {
+		t.Fatalf("GetReservedRequests failed: %v", err)
+	}
+	if got := jobsToStrings(jobs); !slices.Equal(got, want) && (len(got) != 0 || len(want) != 0) {
+		t.Errorf("Expected the queue %v, got %v", want, got)
+	}
+}
+
+func TestRepoClientMetaData(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+		if data, err := client.GetMetaData(ctx, "bp:cache:meta:missing"); err != nil || data != nil {
+			t.Fatalf("Expected nil for a missing key, got %q (%v)", data, err)
+		}
+
+		metadata := func(path string, negative bool) []byte {
+			data, _ := json.Marshal(model.CacheMetadata{FilePath: path, Negative: negative})
+			return data
+		}
+		_ = client.SetMetaData(ctx, "bp:cache:meta:short", metadata("/cache/short", true), time.Minute)
+		_ = client.SetMetaData(ctx, "bp:cache:meta:long", metadata("/cache/long", false), time.Hour)
+		_ = client.SetMetaData(ctx, "bp:cache:meta:forever", metadata("/cache/forever", false), 0)
+
+		if data, _ := client.GetMetaData(ctx, "bp:cache:meta:short"); string(data) != string(metadata("/cache/short", true)) {
+			t.Errorf("Expected the stored metadata, got %q", data)
+		}
+
+		// TTLのないキーはRedisのTTLが-1のため、期限を過ぎたものとして返す
+		scan := func() map[string]repository.CacheItem {
+			scan, err := client.ScanExpiredKeys(ctx, 0)
+			if err != nil {
+				t.Fatalf("ScanExpiredKeys failed: %v", err)
+			}
+			if scan.Cursor != 0 {
+				t.Errorf("Expected a few keys to fit in one batch, got cursor %d", scan.Cursor)
+			}
+			result := make(map[string]repository.CacheItem)
+			for _, item := range scan.Items {
+				result[item.Key] = item
+			}
+			return result
+		}
+		if items := scan(); len(items) != 1 || items["bp:cache:meta:forever"].FilePath != "/cache/forever" {
+			t.Errorf("Expected only the key without a TTL, got %+v", items)
+		}
+
+		clock.Advance(time.Minute)
+		if data, _ := client.GetMetaData(ctx, "bp:cache:meta:short"); data != nil {
+			t.Errorf("Expected the metadata to expire with the TTL, got %q", data)
+		}
+		items := scan()
+		if short := items["bp:cache:meta:short"]; len(items) != 2 || short.FilePath != "/cache/short" || !short.Negative {
+			t.Errorf("Expected the expired key with its file, got %+v", items)
+		}
+
+		// 上書きするとTTLも置き換える
+		_ = client.SetMetaData(ctx, "bp:cache:meta:short", metadata("/cache/short", false), time.Hour)
+		if items := scan(); len(items) != 1 {
+			t.Errorf("Expected the rewritten key not to be expired, got %+v", items)
+		}
+
+		_ = client.DeleteMetaData(ctx, "bp:cache:meta:forever")
+		if data, _ := client.GetMetaData(ctx, "bp:cache:meta:forever"); data != nil {
+			t.Errorf("Expected the deleted metadata to be gone, got %q", data)
+		}
+		if items := scan(); len(items) != 0 {
+			t.Errorf("Expected nothing to be expired, got %+v", items)
+		}
+
+		if err := client.FlushAllMetaData(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := client.GetMetaData(ctx, "bp:cache:meta:long"); data != nil {
+			t.Errorf("Expected the metadata to be flushed, got %q", data)
+		}
+	})
+}
+
+func TestRepoClientScanExpiredKeysInBatches(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+		const expired = 2*expiredScanBatch + 10
+		for i := range expired {
+			_ = client.SetMetaData(ctx, fmt.Sprintf("bp:cache:meta:old%03d", i), []byte(`{}`), time.Minute)
+		}
+		_ = client.SetMetaData(ctx, "bp:cache:meta:fresh", []byte(`{}`), time.Hour)
+		clock.Advance(2 * time.Minute)
+
+		// 返したものを削除すれば、次の呼び出しはその続きを返す
+		var cursor uint64
+		seen := make(map[string]bool)
+		for calls := 1; ; calls++ {
+			scan, err := client.ScanExpiredKeys(ctx, cursor)
+			if err != nil {
+				t.Fatalf("ScanExpiredKeys failed: %v", err)
+			}
+			if len(scan.Items) > expiredScanBatch || scan.Scanned < len(scan.Items) {
+				t.Fatalf("Expected at most %d items with the scanned count, got %d items after scanning %d", expiredScanBatch, len(scan.Items), scan.Scanned)
+			}
+			for _, item := range scan.Items {
+				if seen[item.Key] {
+					t.Errorf("Expected %s to be returned once", item.Key)
+				}
+				seen[item.Key] = true
+				_ = client.DeleteMetaData(ctx, item.Key)
+			}
+			cursor = scan.Cursor
+			if cursor == 0 {
+				if calls != 3 {
+					t.Errorf("Expected 3 batches, got %d", calls)
+				}
+				break
+			}
+			if calls > 3 {
+				t.Fatal("Expected the scan to finish")
+			}
+		}
+		if len(seen) != expired || seen["bp:cache:meta:fresh"] {
+			t.Errorf("Expected the %d expired keys only, got %d", expired, len(seen))
+		}
+	})
+}
+
+func TestRepoClientReservationQueue(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+		// 予約ごとに時刻を進め、同じ優先度では予約した順に並ぶことを確かめる
+		reserve := func(key, job string, priority model.ReservationPriority) (bool, string) {
+			t.Helper()
+			clock.Advance(time.Millisecond)
+			queued, existing, err := client.ReserveRequest(ctx, key, []byte(job), priority)
+			if err != nil {
+				t.Fatalf("ReserveRequest failed: %v", err)
+			}
+			return queued, string(existing)
+		}
+
+		// 同じ優先度では先に予約したものが先頭になる
+		if queued, job := reserve("a", "job-a", model.PriorityInteractive); !queued || job != "job-a" {
+			t.Errorf("Expected job-a to be queued, got %v %q", queued, job)
+		}
+		reserve("b", "job-b", model.PriorityInteractive)
+		if queued, job := reserve("a", "job-a2", model.PriorityInteractive); queued || job != "job-a" {
+			t.Errorf("Expected the existing job-a, got %v %q", queued, job)
+		}
+		expectQueue(t, client, "job-a", "job-b")
+
+		// 優先度の低い予約は、後から予約した優先度の高い予約より後に並ぶ
+		reserve("p", "job-p", model.PriorityPrefetch)
+		reserve("q", "job-q", model.PriorityPrefetch)
+		reserve("v", "job-v", model.PriorityRevalidation)
+		reserve("c", "job-c", model.PriorityInteractive)
+		expectQueue(t, client, "job-a", "job-b", "job-c", "job-v", "job-p", "job-q")
+		if queued, job := reserve("p", "job-p2", model.PriorityPrefetch); queued || job != "job-p" {
+			t.Errorf("Expected the existing prefetch, got %v %q", queued, job)
+		}
+		expectQueue(t, client, "job-a", "job-b", "job-c", "job-v", "job-p", "job-q")
+
+		// 既にある予約を高い優先度で予約すると、予約した時刻のまま優先度を上げる（低い優先度では下げない）
+		if queued, job := reserve("p", "job-p3", model.PriorityInteractive); queued || job != "job-p" {
+			t.Errorf("Expected the existing prefetch, got %v %q", queued, job)
+		}
+		reserve("q", "job-q2", model.PriorityRevalidation)
+		reserve("a", "job-a3", model.PriorityPrefetch)
+		expectQueue(t, client, "job-a", "job-b", "job-p", "job-c", "job-q", "job-v")
+
+		// 送り直しは同じ優先度の最後に追加し、予約済みの記録をjobに置き換える
+		clock.Advance(time.Millisecond)
+		if err := client.RequeueReservedRequest(ctx, "b", []byte("job-b2"), model.PriorityInteractive); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.RequeueReservedRequest(ctx, "r", []byte("job-r"), model.PriorityPrefetch); err != nil {
+			t.Fatal(err)
+		}
+		expectQueue(t, client, "job-a", "job-p", "job-c", "job-b2", "job-q", "job-v", "job-r")
+		if _, job := reserve("b", "job-b3", model.PriorityInteractive); job != "job-b2" {
+			t.Errorf("Expected the requeued job to be recorded, got %q", job)
+		}
+
+		for _, want := range []string{"job-a", "job-p", "job-c", "job-b2", "job-q", "job-v"} {
+			job, err := client.BLPopReservedRequest(ctx, time.Second)
+			if err != nil || string(job) != want {
+				t.Fatalf("Expected %s, got %q (%v)", want, job, err)
+			}
+		}
+
+		// 取り出した後の削除は予約済みの記録だけを削除し、キューに残った要素は削除する
+		if err := client.RemoveReservedRequest(ctx, "a", []byte("job-a")); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.RemoveReservedRequest(ctx, "r", []byte("job-r")); err != nil {
+			t.Fatal(err)
+		}
+		expectQueue(t, client)
+		if queued, _ := reserve("a", "job-a4", model.PriorityInteractive); !queued {
+			t.Error("Expected the removed page to be reserved again")
+		}
+		if queued, _ := reserve("r", "job-r2", model.PriorityInteractive); !queued {
+			t.Error("Expected the removed prefetch to be reserved again")
+		}
+
+		if err := client.FlushAllReservedRequest(ctx); err != nil {
+			t.Fatal(err)
+		}
+		expectQueue(t, client)
+		if queued, _ := reserve("b", "job-b4", model.PriorityInteractive); !queued {
+			t.Error("Expected the flush to forget the reserved keys")
+		}
+	})
+}
+
+func TestRepoClientProcessingRequests(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+		pop := func(want string) {
+			t.Helper()
+			job, err := client.BLPopReservedRequest(ctx, time.Second)
+			if err != nil || string(job) != want {
+				t.Fatalf("Expected %s, got %q (%v)", want, job, err)
+			}
+		}
+		requeueStuck := func(want ...string) {
+			t.Helper()
+			jobs, err := client.RequeueStuckRequests(ctx, clock.Now())
+			if err != nil {
+				t.Fatalf("RequeueStuckRequests failed: %v", err)
+			}
+			if got := jobsToStrings(jobs); !slices.Equal(got, want) && (len(got) != 0 || len(want) != 0) {
+				t.Errorf("Expected %v to be requeued, got %v", want, got)
+			}
+		}
+
+		_, _, _ = client.ReserveRequest(ctx, "a", []byte("job-a"), model.PriorityInteractive)
+		_, _, _ = client.ReserveRequest(ctx, "p", []byte("job-p"), model.PriorityPrefetch)
+		pop("job-a")
+		pop("job-p")
+		expectQueue(t, client)
+		// 取り出したばかりの予約は戻さない
+		requeueStuck()
+
+		// Workerが処理中に止まった予約は、取り出す前の優先度の最後に予約済みのまま戻る
+		clock.Advance(time.Minute)
+		_, _, _ = client.ReserveRequest(ctx, "c", []byte("job-c"), model.PriorityInteractive)
+		pop("job-c")
+		requeueStuck("job-a", "job-p")
+		expectQueue(t, client, "job-a", "job-p")
+		if queued, job, _ := client.ReserveRequest(ctx, "a", []byte("job-a2"), model.PriorityInteractive); queued || string(job) != "job-a" {
+			t.Errorf("Expected job-a to stay reserved, got %v %q", queued, job)
+		}
+
+		// 処理を終えた予約と、送り直すためにキューに戻した予約は処理中でなくなる
+		if err := client.RemoveReservedRequest(ctx, "c", []byte("job-c")); err != nil {
+			t.Fatal(err)
+		}
+		pop("job-a")
+		if err := client.RequeueReservedRequest(ctx, "a", []byte("job-a3"), model.PriorityInteractive); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+		requeueStuck()
+		expectQueue(t, client, "job-a3", "job-p")
+
+		// 削除はキューにある予約と同じく処理中の予約も削除する
+		pop("job-a3")
+		if err := client.RemoveReservedRequest(ctx, "a", []byte("job-a3")); err != nil {
+			t.Fatal(err)
+		}
+		pop("job-p")
+		if err := client.FlushAllReservedRequest(ctx); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+		requeueStuck()
+		expectQueue(t, client)
+	})
+}
+
+func TestRepoClientDelayedAndDeadLetters(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+		pop := func(want string) {
+			t.Helper()
+			job, err := client.BLPopReservedRequest(ctx, 10*time.Millisecond)
+			if err != nil || string(job) != want {
+				t.Fatalf("Expected %q, got %q (%v)", want, job, err)
+			}
+		}
+
+		_, _, _ = client.ReserveRequest(ctx, "a", []byte("job-a"), model.PriorityInteractive)
+		_, _, _ = client.ReserveRequest(ctx, "b", []byte("job-b"), model.PriorityInteractive)
+		_, _, _ = client.ReserveRequest(ctx, "p", []byte("job-p"), model.PriorityPrefetch)
+		pop("job-a")
+		pop("job-b")
+
+		// 待ち時間を空けて戻した予約は、予約済みのまま同じ優先度の中で戻す時刻の順に並ぶ
+		if err := client.DelayReservedRequest(ctx, "a", []byte("job-a2"), model.PriorityInteractive, clock.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.DelayReservedRequest(ctx, "b", []byte("job-b2"), model.PriorityInteractive, clock.Now().Add(30*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		expectQueue(t, client, "job-b2", "job-a2", "job-p")
+		if queued, job, _ := client.ReserveRequest(ctx, "a", []byte("job-a3"), model.PriorityInteractive); queued || string(job) != "job-a2" {
+			t.Errorf("Expected job-a2 to stay reserved, got %v %q", queued, job)
+		}
+		if jobs, _ := client.RequeueStuckRequests(ctx, clock.Now().Add(time.Hour)); len(jobs) != 0 {
+			t.Errorf("Expected delayed jobs not to be processing, got %q", jobs)
+		}
+
+		// 戻す時刻になるまでは取り出さない（優先度の低い事前取得の予約が先に出る）
+		pop("job-p")
+		_ = client.RemoveReservedRequest(ctx, "p", []byte("job-p"))
+		pop("")
+		clock.Advance(45 * time.Second)
+		pop("job-b2")
+		pop("")
+		clock.Advance(time.Minute)
+		pop("job-a2")
+
+		// デッドレターに移すと予約は削除され、フラッシュしても残る
+		if err := client.DelayReservedRequest(ctx, "b", []byte("job-b3"), model.PriorityInteractive, clock.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.DeadLetterReservedRequest(ctx, "a", []byte("job-a2"), []byte("dead-a")); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.DeadLetterReservedRequest(ctx, "b", []byte("job-b3"), []byte("dead-b")); err != nil {
+			t.Fatal(err)
+		}
+		expectQueue(t, client)
+		if jobs, _ := client.RequeueStuckRequests(ctx, clock.Now().Add(time.Hour)); len(jobs) != 0 {
+			t.Errorf("Expected nothing processing, got %q", jobs)
+		}
+		if queued, _, _ := client.ReserveRequest(ctx, "a", []byte("job-a4"), model.PriorityInteractive); !queued {
+			t.Error("Expected to reserve a again")
+		}
+		if err := client.FlushAllReservedRequest(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if entry, err := client.GetDeadLetter(ctx, "a"); err != nil || string(entry) != "dead-a" {
+			t.Errorf("Expected the dead letter of a, got %q (%v)", entry, err)
+		}
+		entries, err := client.GetDeadLetters(ctx)
+		if got := jobsToStrings(entries); err != nil || !slices.Equal(slices.Sorted(slices.Values(got)), []string{"dead-a", "dead-b"}) {
+			t.Errorf("Expected both dead letters, got %v (%v)", got, err)
+		}
+
+		if err := client.DeleteDeadLetter(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if entry, err := client.GetDeadLetter(ctx, "a"); err != nil || entry != nil {
+			t.Errorf("Expected the dead letter to be deleted, got %q (%v)", entry, err)
+		}
+		if entries, _ := client.GetDeadLetters(ctx); len(entries) != 1 {
+			t.Errorf("Expected one dead letter left, got %q", entries)
+		}
+	})
+}
+
+func TestRepoClientSentAndPendingRequests(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+		if data, err := client.GetSentRequest(ctx, "req-1"); err != nil || data != nil {
+			t.Fatalf("Expected nil for a missing record, got %q (%v)", data, err)
+		}
+		_ = client.SetSentRequest(ctx, "req-1", []byte("sent-1"))
+		_ = client.SetSentRequest(ctx, "req-2", []byte("sent-2"))
+		_ = client.SetSentRequest(ctx, "req-1", []byte("sent-1b"))
+		if data, _ := client.GetSentRequest(ctx, "req-1"); string(data) != "sent-1b" {
+			t.Errorf("Expected the record to be replaced, got %q", data)
+		}
+		all, _ := client.GetSentRequests(ctx)
+		got := jobsToStrings(all)
+		sort.Strings(got)
+		if !slices.Equal(got, []string{"sent-1b", "sent-2"}) {
+			t.Errorf("Expected both records, got %v", got)
+		}
+		_ = client.DeleteSentRequest(ctx, "req-2")
+		if data, _ := client.GetSentRequest(ctx, "req-2"); data != nil {
+			t.Errorf("Expected the record to be deleted, got %q", data)
+		}
+		// 予約をすべて削除すると送信の記録も削除する
+		_ = client.FlushAllReservedRequest(ctx)
+		if all, _ := client.GetSentRequests(ctx); len(all) != 0 {
+			t.Errorf("Expected the records to be flushed, got %q", all)
+		}
+
+		for i, want := range []bool{true, false} {
+			if added, err := client.AddPendingRequest(ctx, "http://example.com/"); err != nil || added != want {
+				t.Errorf("AddPendingRequest #%d: expected %v, got %v (%v)", i, want, added, err)
+			}
+		}
+		_ = client.RemovePendingRequest(ctx, "http://example.com/")
+		if added, _ := client.AddPendingRequest(ctx, "http://example.com/"); !added {
+			t.Error("Expected the removed URL to be added again")
+		}
+	})
+}
+
+func TestRepoClientCacheIndex(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+		base := time.Unix(1_700_000_000, 0)
+		add := func(key, url, domain string, size int64, storedAt time.Time) {
+			t.Helper()
+			err := client.AddCacheIndex(ctx, repository.CacheIndexEntry{CacheKey: key, URL: url, Domain: domain, Size: size, StoredAt: storedAt})
+			if err != nil {
+				t.Fatalf("AddCacheIndex failed: %v", err)
+			}
+		}
+		usage := func(wantBytes int64, wantEntries int) {
+			t.Helper()
+			total, entries, err := client.GetCacheUsage(ctx)
+			if err != nil || total != wantBytes || entries != wantEntries {
+				t.Errorf("Expected %d bytes in %d entries, got %d in %d (%v)", wantBytes, wantEntries, total, entries, err)
+			}
+		}
+		list := func(domain string, offset, limit int, want []string, wantTotal int) {
+			t.Helper()
+			keys, total, err := client.ListCacheKeys(ctx, domain, offset, limit)
+			if err != nil || total != wantTotal || !slices.Equal(keys, want) && (len(keys) != 0 || len(want) != 0) {
+				t.Errorf("ListCacheKeys(%q, %d, %d): expected %v of %d, got %v of %d (%v)", domain, offset, limit, want, wantTotal, keys, total, err)
+			}
+		}
+
+		usage(0, 0)
+		add("k1", "http://a.example/", "a.example", 100, base)
+		add("k2", "http://a.example/", "a.example", 200, base.Add(time.Second))
+		add("k3", "http://b.example/", "b.example", 300, base.Add(time.Second))
+		add("k4", "http://b.example/x", "b.example", 400, base.Add(2*time.Second))
+		usage(1000, 4)
+
+		// 保存時刻の新しい順、同じ秒はキャッシュキーの逆順（ZREVRANGE）
+		list("", 0, 10, []string{"k4", "k3", "k2", "k1"}, 4)
+		list("", 1, 2, []string{"k3", "k2"}, 4)
+		list("", 4, 2, nil, 4)
+		list("", 0, 0, nil, 4)
+		list("b.example", 0, 10, []string{"k4", "k3"}, 2)
+		list("c.example", 0, 10, nil, 0)
+
+		keys, _ := client.GetCacheKeysByURL(ctx, "http://a.example/")
+		sort.Strings(keys)
+		if !slices.Equal(keys, []string{"k1", "k2"}) {
+			t.Errorf("Expected both variants of the URL, got %v", keys)
+		}
+
+		// 最終アクセス時刻の古い順、同じ時刻はキャッシュキーの順（ZRANGE）
+		lru := func(limit int, want ...string) {
+			t.Helper()
+			keys, err := client.LeastRecentlyUsedKeys(ctx, limit)
+			if err != nil || !slices.Equal(keys, want) && (len(keys) != 0 || len(want) != 0) {
+				t.Errorf("LeastRecentlyUsedKeys(%d): expected %v, got %v (%v)", limit, want, keys, err)
+			}
+		}
+		lru(10, "k1", "k2", "k3", "k4")
+		lru(0)
+		_ = client.TouchCacheIndex(ctx, "k1", base.Add(time.Hour))
+		_ = client.TouchCacheIndex(ctx, "k2", base) // 記録済みより古い時刻では戻さない
+		_ = client.TouchCacheIndex(ctx, "missing", base.Add(time.Hour))
+		lru(2, "k2", "k3")
+		usage(1000, 4)
+
+		// 同じキャッシュキーは置き換え、大きさの差を合計に加える
+		add("k3", "http://b.example/", "b.example", 50, base.Add(3*time.Second))
+		usage(750, 4)
+		list("b.example", 0, 10, []string{"k3", "k4"}, 2)
+		lru(10, "k2", "k4", "k3", "k1")
+
+		_ = client.RemoveCacheIndex(ctx, "k2")
+		_ = client.RemoveCacheIndex(ctx, "k2")
+		usage(550, 3)
+		list("a.example", 0, 10, []string{"k1"}, 1)
+		if keys, _ := client.GetCacheKeysByURL(ctx, "http://a.example/"); !slices.Equal(keys, []string{"k1"}) {
+			t.Errorf("Expected only k1 for the URL, got %v", keys)
+		}
+
+		// 数え直すと、登録済みのキーの大きさを置き換えて合計を数え直す（登録されていないキーは無視する）
+		if err := client.RecountCacheUsage(ctx, map[string]int64{"k1": 10, "k2": 999}); err != nil {
+			t.Fatalf("RecountCacheUsage failed: %v", err)
+		}
+		usage(460, 3)
+		lru(10, "k4", "k3", "k1")
+		if err := client.RecountCacheUsage(ctx, nil); err != nil {
+			t.Fatalf("RecountCacheUsage failed: %v", err)
+		}
+		usage(460, 3)
+
+		// FlushAllCachesはメタデータ・インデックスを削除し、予約（処理中を含む）と送信の記録は残す
+		_ = client.SetMetaData(ctx, "bp:cache:meta:k1", []byte("{}"), time.Hour)
+		_, _, _ = client.ReserveRequest(ctx, "k8", []byte("job-k8"), model.PriorityInteractive)
+		if job, _ := client.BLPopReservedRequest(ctx, time.Second); string(job) != "job-k8" {
+			t.Fatalf("Expected to claim job-k8, got %q", job)
+		}
+		_, _, _ = client.ReserveRequest(ctx, "k9", []byte("job-k9"), model.PriorityInteractive)
+		_ = client.SetSentRequest(ctx, "req-9", []byte("sent-9"))
+		if err := client.FlushAllCaches(ctx); err != nil {
+			t.Fatal(err)
+		}
+		usage(0, 0)
+		list("", 0, 10, nil, 0)
+		lru(10)
+		expectQueue(t, client, "job-k9")
+		if data, _ := client.GetMetaData(ctx, "bp:cache:meta:k1"); data != nil {
+			t.Errorf("Expected the metadata to be flushed, got %q", data)
+		}
+		if data, _ := client.GetSentRequest(ctx, "req-9"); string(data) != "sent-9" {
+			t.Errorf("Expected the sent request to survive the flush, got %q", data)
+		}
+		if jobs, _ := client.RequeueStuckRequests(ctx, clock.Now().Add(time.Hour)); !slices.Equal(jobsToStrings(jobs), []string{"job-k8"}) {
+			t.Errorf("Expected the claimed job to survive the flush, got %q", jobs)
+		}
+	})
+}
+
+func TestRepoClientBLPopBlocks(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx := context.Background()
+
+		start := time.Now()
+		if job, err := client.BLPopReservedRequest(ctx, 20*time.Millisecond); err != nil || job != nil {
+			t.Fatalf("Expected a timeout, got %q (%v)", job, err)
+		}
+		if d := time.Since(start); d < 20*time.Millisecond {
+			t.Errorf("Expected to wait for the timeout, returned after %v", d)
+		}
+
+		// 予約の追加と送り直しのどちらでも待っている呼び出しを起こす
+		for _, add := range []func() error{
+			func() error {
+				_, _, err := client.ReserveRequest(ctx, "a", []byte("job-a"), model.PriorityPrefetch)
+				return err
+			},
+			func() error {
+				return client.RequeueReservedRequest(ctx, "b", []byte("job-b"), model.PriorityInteractive)
+			},
+		} {
+			done := make(chan []byte)
+			go func() {
+				job, _ := client.BLPopReservedRequest(ctx, 0)
+				done <- job
+			}()
+			time.Sleep(10 * time.Millisecond)
+			if err := add(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case job := <-done:
+				if job == nil {
+					t.Error("Expected the waiting call to receive the job")
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected the new job to wake the waiting call")
+			}
+		}
+
+		cancelled, cancel := context.WithCancel(ctx)
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if _, err := client.BLPopReservedRequest(cancelled, 0); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the cancellation, got %v", err)
+		}
+	})
+}
+
+// 複数のワーカーが同時に待っていても、それぞれの予約を1回だけ取り出す
+func TestRepoClientConcurrentBLPop(t *testing.T) {
+	forEachRepoClient(t, func(t *testing.T, client repository.BpRepoClient, clock *fakeClock) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		const workers, jobs = 4, 40
+
+		var mu sync.Mutex
+		received := make(map[string]int)
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					job, err := client.BLPopReservedRequest(ctx, 0)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					received[string(job)]++
+					mu.Unlock()
+				}
+			}()
+		}
+
+		for i := range jobs {
+			key := fmt.Sprintf("key-%d", i)
+			if _, _, err := client.ReserveRequest(ctx, key, []byte("job-"+key), model.ReservationPriorities[i%len(model.ReservationPriorities)]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			n := len(received)
+			mu.Unlock()
+			if n == jobs || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		wg.Wait()
+
+		if len(received) != jobs {
+			t.Errorf("Expected %d jobs, got %d", jobs, len(received))
+		}
+		for job, n := range received {
+			if n != 1 {
+				t.Errorf("Expected %s to be popped once, got %d", job, n)
+			}
+		}
+		expectQueue(t, client)
+	})
+}
@@ -0,0 +1,54 @@
+// client_factory.go - 設定の種類（redis・memory・bolt）からBpRepoClientを作る
+package plugins
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+)
+
+// キャッシュのメタデータと予約キューを置く場所
+const (
+	ClientTypeRedis  = "redis"  // Redisサーバー（空の場合も）
+	ClientTypeMemory = "memory" // プロセスのメモリ
+	ClientTypeBolt   = "bolt"   // bboltのファイル
+)
+
+// ClientConfig NewRepoClientで作るBpRepoClientの設定
+type ClientConfig struct {
+	Type string
+
+	// Redis・RedisConfig redisの場合に使う
+	Redis       *redis.Client
+	RedisConfig RedisClientConfig
+
+	// BoltPath boltの場合のデータベースファイル
+	BoltPath string
+}
+
+// NewRepoClient conf.TypeのBpRepoClientを作る
+// boltの場合はファイルを開くため、他のプロセスが開いていると失敗する
+func NewRepoClient(conf ClientConfig) (repository.BpRepoClient, error) {
+	switch conf.Type {
+	case "", ClientTypeRedis:
+		if conf.Redis == nil {
+			return nil, fmt.Errorf("repository client %s: no Redis connection", ClientTypeRedis)
+		}
+		return NewRedisClient(conf.Redis, conf.RedisConfig), nil
+	case ClientTypeMemory:
+		return NewMemoryClient(), nil
+	case ClientTypeBolt:
+		if conf.BoltPath == "" {
+			return nil, fmt.Errorf("repository client %s: no database path", ClientTypeBolt)
+		}
+		client, err := NewBoltClient(conf.BoltPath)
+		if err != nil {
+			// 失敗した場合に*BoltClientのnilをインターフェースとして返さない
+			return nil, fmt.Errorf("repository client %s: %w", ClientTypeBolt, err)
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown repository client %q (use %s, %s or %s)", conf.Type, ClientTypeRedis, ClientTypeMemory, ClientTypeBolt)
+	}
+}
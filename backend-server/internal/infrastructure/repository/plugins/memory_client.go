@@ -0,0 +1,564 @@
+// memory_client.go - Redisの代わりにプロセスのメモリにメタデータ・予約キュー・インデックスを持つBpRepoClient（テスト・Redisなしの1台構成のデモ用）
+package plugins
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+)
+
+// MemoryClient RedisClientと同じ振る舞いをメモリ上で行う
+// キューの順序（優先度の高い順、同じ優先度では時刻の古い順に取り出す）やTTL、送り直しを待っている予約の扱いもRedisClientに合わせる
+// プロセスを終了すると予約とキャッシュのメタデータは失われる（キャッシュファイルはディレクトリに残る）
+type MemoryClient struct {
+	mu   sync.Mutex
+	cond *sync.Cond // 予約が追加されたとき、BLPopReservedRequestで待っている呼び出しを起こす
+	now  func() time.Time
+
+	meta         map[string]memoryValue
+	queue        []queuedJob       // 予約キュー（compareQueuedの順、送り直しを待っている予約も時刻が先の要素として含む）
+	reservedKeys map[string][]byte // 予約済みのキャッシュキーとキューに追加したjob
+	processing   []claimedJob      // 取り出して処理中の予約（取り出した順）
+	deadLetters  map[string][]byte // キャッシュキーごとのデッドレター
+	sent         map[string][]byte
+	pending      map[string]struct{}
+
+	index      map[string]repository.CacheIndexEntry
+	lastAccess map[string]int64 // キャッシュキーごとの最終アクセス時刻（Unixミリ秒、RedisClientのlruのスコアと同じ）
+	bytes      int64
+}
+
+// queuedJob 予約キューの予約と、優先度・時刻（予約した時刻、送り直しを待っている予約は戻す時刻）
+type queuedJob struct {
+	job      []byte
+	priority model.ReservationPriority
+	at       time.Time
+}
+
+// claimedJob 取り出して処理中の予約と、取り出す前の優先度・取り出した時刻
+type claimedJob struct {
+	job       []byte
+	priority  model.ReservationPriority
+	claimedAt time.Time
+}
+
+// memoryValue メタデータと、TTLから決めた消える時刻（ゼロはTTLなし）
+type memoryValue struct {
+	data     []byte
+	deadline time.Time
+}
+
+var _ repository.BpRepoClient = (*MemoryClient)(nil)
+
+func NewMemoryClient() *MemoryClient {
+	mc := &MemoryClient{
+		now:          time.Now,
+		meta:         make(map[string]memoryValue),
+		reservedKeys: make(map[string][]byte),
+		deadLetters:  make(map[string][]byte),
+		sent:         make(map[string][]byte),
+		pending:      make(map[string]struct{}),
+		index:        make(map[string]repository.CacheIndexEntry),
+		lastAccess:   make(map[string]int64),
+	}
+	mc.cond = sync.NewCond(&mc.mu)
+	return mc
+}
+
+func (mc *MemoryClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+// expired TTLを過ぎたか（RedisではTTLを過ぎたキーは読めない）
+func (mc *MemoryClient) expired(v memoryValue) bool {
+	return !v.deadline.IsZero() && !mc.now().Before(v.deadline)
+}
+
+func (mc *MemoryClient) GetMetaData(ctx context.Context, metaKey string) ([]byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	v, ok := mc.meta[metaKey]
+	if !ok || mc.expired(v) {
+		return nil, nil
+	}
+	return slices.Clone(v.data), nil
+}
+
+// expiredScanBatch MemoryClient・BoltClientのScanExpiredKeysで1回に返す期限切れのメタデータの上限（RedisClientのScanCountに当たる）
+const expiredScanBatch = 100
+
+// ScanExpiredKeys TTLを過ぎたメタデータと、RedisClientと同じくTTLのないメタデータを、キーの順に最大でexpiredScanBatch件返す
+// Redisと違い、TTLを過ぎたメタデータはDeleteMetaDataを呼ぶまで残るため、キャッシュファイルのパスも返せる
+// 期限切れのものだけを先頭から返すため、cursorは使わない（返したものを削除すれば、次の呼び出しはその続きになる）
+func (mc *MemoryClient) ScanExpiredKeys(ctx context.Context, cursor uint64) (repository.ExpiredScan, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	keys := make([]string, 0, len(mc.meta))
+	for key := range mc.meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var scan repository.ExpiredScan
+	for _, key := range keys {
+		v := mc.meta[key]
+		if !v.deadline.IsZero() && !mc.expired(v) {
+			scan.Scanned++
+			continue
+		}
+		if len(scan.Items) == expiredScanBatch {
+			// 続きは次の呼び出しで返す
+			scan.Cursor = 1
+			break
+		}
+		scan.Scanned++
+		item := repository.CacheItem{Key: key}
+		var metadata model.CacheMetadata
+		if err := json.Unmarshal(v.data, &metadata); err == nil {
+			item.FilePath = metadata.FilePath
+			item.Negative = metadata.Negative
+		}
+		scan.Items = append(scan.Items, item)
+	}
+	return scan, nil
+}
+
+func (mc *MemoryClient) SetMetaData(ctx context.Context, metaKey string, data []byte, ttl time.Duration) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	v := memoryValue{data: slices.Clone(data)}
+	if ttl > 0 {
+		v.deadline = mc.now().Add(ttl)
+	}
+	mc.meta[metaKey] = v
+	return nil
+}
+
+func (mc *MemoryClient) DeleteMetaData(ctx context.Context, metaKey string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.meta, metaKey)
+	return nil
+}
+
+func (mc *MemoryClient) FlushAllMetaData(ctx context.Context) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	clear(mc.meta)
+	return nil
+}
+
+func (mc *MemoryClient) ReserveRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) (bool, []byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if existing, ok := mc.reservedKeys[cacheKey]; ok {
+		// 既にある予約より高い優先度で予約した場合は、時刻はそのままで優先度を上げる
+		if i := mc.indexOfQueued(existing); i >= 0 && mc.queue[i].priority > priority {
+			q := mc.queue[i]
+			mc.queue = slices.Delete(mc.queue, i, i+1)
+			q.priority = priority
+			mc.enqueue(q)
+		}
+		return false, slices.Clone(existing), nil
+	}
+
+	job = slices.Clone(job)
+	mc.reservedKeys[cacheKey] = job
+	mc.enqueue(queuedJob{job: job, priority: priority, at: mc.now()})
+	mc.cond.Broadcast()
+	return true, slices.Clone(job), nil
+}
+
+// compareQueued 予約キューの順序（優先度の高い順、同じ優先度では時刻の古い順、同じ時刻ではjobの順）で比べる
+// RedisClientのソート済みセットと同じく、スコアが同じ要素はメンバーのバイト列の順になる
+func compareQueued(a, b queuedJob) int {
+	if c := cmp.Compare(a.priority, b.priority); c != 0 {
+		return c
+	}
+	if c := a.at.Compare(b.at); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.job, b.job)
+}
+
+// enqueue qを予約キューの順序の位置に追加する（mc.muを持って呼ぶ）
+func (mc *MemoryClient) enqueue(q queuedJob) {
+	i, _ := slices.BinarySearchFunc(mc.queue, q, compareQueued)
+	mc.queue = slices.Insert(mc.queue, i, q)
+}
+
+// indexOfQueued 予約キューでjobと同じ要素の位置（ない場合は-1）（mc.muを持って呼ぶ）
+func (mc *MemoryClient) indexOfQueued(job []byte) int {
+	return slices.IndexFunc(mc.queue, func(q queuedJob) bool { return bytes.Equal(q.job, job) })
+}
+
+func (mc *MemoryClient) GetReservedRequests(ctx context.Context) ([][]byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	result := make([][]byte, 0, len(mc.queue))
+	for _, q := range mc.queue {
+		result = append(result, slices.Clone(q.job))
+	}
+	return result, nil
+}
+
+func (mc *MemoryClient) RemoveReservedRequest(ctx context.Context, cacheKey string, job []byte) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.removeReserved(cacheKey, job)
+	return nil
+}
+
+// removeReserved jobと予約済みの記録にあるjobを、予約キューと処理中の予約から削除し、予約済みの記録も削除する（mc.muを持って呼ぶ）
+func (mc *MemoryClient) removeReserved(cacheKey string, job []byte) {
+	jobs := [][]byte{job}
+	if existing, ok := mc.reservedKeys[cacheKey]; ok && !bytes.Equal(existing, job) {
+		jobs = append(jobs, existing)
+	}
+	for _, job := range jobs {
+		mc.removeJob(job)
+	}
+	delete(mc.reservedKeys, cacheKey)
+}
+
+// removeJob jobを予約キューと処理中の予約から削除する（mc.muを持って呼ぶ）
+func (mc *MemoryClient) removeJob(job []byte) {
+	if i := mc.indexOfQueued(job); i >= 0 {
+		mc.queue = slices.Delete(mc.queue, i, i+1)
+	}
+	mc.processing = slices.DeleteFunc(mc.processing, func(c claimedJob) bool { return bytes.Equal(c.job, job) })
+}
+
+func (mc *MemoryClient) RequeueReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) error {
+	// 今の時刻で、同じ優先度の予約の最後に戻す
+	return mc.requeue(cacheKey, job, priority, mc.now())
+}
+
+func (mc *MemoryClient) DelayReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority, readyAt time.Time) error {
+	// 戻す時刻をキューの時刻にするため、BLPopReservedRequestはその時刻を過ぎるまで取り出さない
+	return mc.requeue(cacheKey, job, priority, readyAt)
+}
+
+// requeue 予約済みの記録にあるjobを予約キューと処理中の予約から削除し、jobを優先度と時刻atで予約キューに追加する
+func (mc *MemoryClient) requeue(cacheKey string, job []byte, priority model.ReservationPriority, at time.Time) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if existing, ok := mc.reservedKeys[cacheKey]; ok {
+		mc.removeJob(existing)
+	}
+	job = slices.Clone(job)
+	mc.reservedKeys[cacheKey] = job
+	mc.enqueue(queuedJob{job: job, priority: priority, at: at})
+	mc.cond.Broadcast()
+	return nil
+}
+
+// BLPopReservedRequest 時刻を過ぎた予約のうち、優先度の最も高い、同じ優先度では時刻の最も古い予約が追加されるまで待って取り出し、処理中の予約にする
+// RedisのBLPOPと同じく、timeoutが過ぎた場合はnil、timeoutが0の場合は予約が追加されるかctxが終わるまで待つ
+func (mc *MemoryClient) BLPopReservedRequest(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	wake := func() {
+		mc.mu.Lock()
+		mc.cond.Broadcast()
+		mc.mu.Unlock()
+	}
+	stop := context.AfterFunc(ctx, wake)
+	defer stop()
+	timedOut := false
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			mc.mu.Lock()
+			timedOut = true
+			mc.cond.Broadcast()
+			mc.mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	var ready *time.Timer
+	defer func() {
+		if ready != nil {
+			ready.Stop()
+		}
+	}()
+	for {
+		job, next := mc.pop()
+		if job != nil {
+			return job, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if timedOut {
+			return nil, nil
+		}
+		// 送り直しを待っている予約は、戻す時刻に起きて取り出す
+		if ready != nil {
+			ready.Stop()
+			ready = nil
+		}
+		if !next.IsZero() {
+			ready = time.AfterFunc(next.Sub(mc.now()), wake)
+		}
+		mc.cond.Wait()
+	}
+}
+
+// pop 時刻を過ぎた予約のうち予約キューの順序で最初の予約を取り出し、処理中の予約にする（mc.muを持って呼ぶ）
+// 取り出せる予約がない場合は、次に時刻を過ぎる予約の時刻（ない場合はゼロ）を返す
+func (mc *MemoryClient) pop() ([]byte, time.Time) {
+	now := mc.now()
+	var next time.Time
+	for i, q := range mc.queue {
+		if q.at.After(now) {
+			if next.IsZero() || q.at.Before(next) {
+				next = q.at
+			}
+			continue
+		}
+		mc.queue = slices.Delete(mc.queue, i, i+1)
+		mc.processing = append(mc.processing, claimedJob{job: q.job, priority: q.priority, claimedAt: now})
+		return slices.Clone(q.job), time.Time{}
+	}
+	return nil, next
+}
+
+// RequeueStuckRequests claimedBeforeより前に取り出したまま処理中の予約を、取り出す前の優先度と今の時刻で予約キューに戻す
+func (mc *MemoryClient) RequeueStuckRequests(ctx context.Context, claimedBefore time.Time) ([][]byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	now := mc.now()
+	var requeued [][]byte
+	mc.processing = slices.DeleteFunc(mc.processing, func(c claimedJob) bool {
+		if !c.claimedAt.Before(claimedBefore) {
+			return false
+		}
+		mc.enqueue(queuedJob{job: c.job, priority: c.priority, at: now})
+		requeued = append(requeued, slices.Clone(c.job))
+		return true
+	})
+	if len(requeued) > 0 {
+		mc.cond.Broadcast()
+	}
+	return requeued, nil
+}
+
+func (mc *MemoryClient) DeadLetterReservedRequest(ctx context.Context, cacheKey string, job []byte, entry []byte) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.removeReserved(cacheKey, job)
+	mc.deadLetters[cacheKey] = slices.Clone(entry)
+	return nil
+}
+
+func (mc *MemoryClient) GetDeadLetter(ctx context.Context, cacheKey string) ([]byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return slices.Clone(mc.deadLetters[cacheKey]), nil
+}
+
+func (mc *MemoryClient) GetDeadLetters(ctx context.Context) ([][]byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	result := make([][]byte, 0, len(mc.deadLetters))
+	for _, entry := range mc.deadLetters {
+		result = append(result, slices.Clone(entry))
+	}
+	return result, nil
+}
+
+func (mc *MemoryClient) DeleteDeadLetter(ctx context.Context, cacheKey string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.deadLetters, cacheKey)
+	return nil
+}
+
+func (mc *MemoryClient) SetSentRequest(ctx context.Context, requestID string, data []byte) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.sent[requestID] = slices.Clone(data)
+	return nil
+}
+
+func (mc *MemoryClient) GetSentRequest(ctx context.Context, requestID string) ([]byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	data, ok := mc.sent[requestID]
+	if !ok {
+		return nil, nil
+	}
+	return slices.Clone(data), nil
+}
+
+func (mc *MemoryClient) GetSentRequests(ctx context.Context) ([][]byte, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	result := make([][]byte, 0, len(mc.sent))
+	for _, data := range mc.sent {
+		result = append(result, slices.Clone(data))
+	}
+	return result, nil
+}
+
+func (mc *MemoryClient) DeleteSentRequest(ctx context.Context, requestID string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.sent, requestID)
+	return nil
+}
+
+func (mc *MemoryClient) AddPendingRequest(ctx context.Context, url string) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if _, ok := mc.pending[url]; ok {
+		return false, nil
+	}
+	mc.pending[url] = struct{}{}
+	return true, nil
+}
+
+func (mc *MemoryClient) RemovePendingRequest(ctx context.Context, url string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.pending, url)
+	return nil
+}
+
+// FlushAllReservedRequest 予約キューと処理中の予約、予約済みのキャッシュキー・送ったリクエストの記録を削除する
+// デッドレターは調べられるように残す
+func (mc *MemoryClient) FlushAllReservedRequest(ctx context.Context) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.queue, mc.processing = nil, nil
+	clear(mc.reservedKeys)
+	clear(mc.sent)
+	return nil
+}
+
+func (mc *MemoryClient) FlushAllCaches(ctx context.Context) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	clear(mc.meta)
+	clear(mc.index)
+	clear(mc.lastAccess)
+	mc.bytes = 0
+	return nil
+}
+
+// AddCacheIndex キャッシュを登録し、置き換える前の大きさとの差を容量の合計に加える（最終アクセス時刻は保存時刻にする）
+func (mc *MemoryClient) AddCacheIndex(ctx context.Context, entry repository.CacheIndexEntry) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.bytes += entry.Size - mc.index[entry.CacheKey].Size
+	mc.index[entry.CacheKey] = entry
+	mc.lastAccess[entry.CacheKey] = entry.StoredAt.UnixMilli()
+	return nil
+}
+
+func (mc *MemoryClient) RemoveCacheIndex(ctx context.Context, cacheKey string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if entry, ok := mc.index[cacheKey]; ok {
+		mc.bytes -= entry.Size
+		delete(mc.index, cacheKey)
+	}
+	delete(mc.lastAccess, cacheKey)
+	return nil
+}
+
+func (mc *MemoryClient) TouchCacheIndex(ctx context.Context, cacheKey string, accessedAt time.Time) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if current, ok := mc.lastAccess[cacheKey]; ok && accessedAt.UnixMilli() > current {
+		mc.lastAccess[cacheKey] = accessedAt.UnixMilli()
+	}
+	return nil
+}
+
+// LeastRecentlyUsedKeys 最終アクセス時刻の古い順（同じ時刻はキャッシュキーの順、RedisのZRANGEと同じ）にlimit件返す
+func (mc *MemoryClient) LeastRecentlyUsedKeys(ctx context.Context, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	keys := make([]string, 0, len(mc.lastAccess))
+	for key := range mc.lastAccess {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := mc.lastAccess[keys[i]], mc.lastAccess[keys[j]]
+		if a != b {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys[:min(limit, len(keys))], nil
+}
+
+func (mc *MemoryClient) GetCacheUsage(ctx context.Context) (int64, int, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.bytes, len(mc.lastAccess), nil
+}
+
+func (mc *MemoryClient) RecountCacheUsage(ctx context.Context, sizes map[string]int64) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.bytes = 0
+	for key, entry := range mc.index {
+		if size, ok := sizes[key]; ok {
+			entry.Size = size
+			mc.index[key] = entry
+		}
+		mc.bytes += entry.Size
+	}
+	return nil
+}
+
+func (mc *MemoryClient) GetCacheKeysByURL(ctx context.Context, url string) ([]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	var keys []string
+	for key, entry := range mc.index {
+		if entry.URL == url {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListCacheKeys キャッシュキーを保存時刻の新しい順（RedisClientと同じく秒単位、同じ時刻はキャッシュキーの逆順）に返す
+func (mc *MemoryClient) ListCacheKeys(ctx context.Context, domain string, offset, limit int) ([]string, int, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	var keys []string
+	for key, entry := range mc.index {
+		if domain == "" || entry.Domain == domain {
+			keys = append(keys, key)
+		}
+	}
+	total := len(keys)
+	if limit <= 0 || offset >= total {
+		return nil, total, nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := mc.index[keys[i]].StoredAt.Unix(), mc.index[keys[j]].StoredAt.Unix()
+		if a != b {
+			return a > b
+		}
+		return keys[i] > keys[j]
+	})
+	return keys[offset:min(offset+limit, total)], total, nil
+}
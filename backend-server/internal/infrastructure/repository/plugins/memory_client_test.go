@@ -0,0 +1,390 @@
+// memory_client_test.go - メモリ上のBpRepoClientと、それを使ったBpRepository（キャッシュの保存・取得、予約の流れ、送り直しとデッドレター、期限切れの削除）のテスト
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+)
+
+// fakeClock MemoryClientのTTLを確かめるために進められる時計
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newMemoryRepository MemoryClientを使うBpRepositoryと、MemoryClientのTTLの時計を返す
+func newMemoryRepository(t *testing.T) (*repository.BpRepository, *MemoryClient, *fakeClock) {
+	t.Helper()
+	client := NewMemoryClient()
+	clock := &fakeClock{now: time.Now()}
+	client.now = clock.Now
+	return repository.NewBpRepository(client, t.TempDir(), 0, 0, 0), client, clock
+}
+
+// cacheFilePath MemoryClientに保存したメタデータから、urlのキャッシュのファイルパスを探す
+func cacheFilePath(t *testing.T, client *MemoryClient, url string) string {
+	t.Helper()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, value := range client.meta {
+		var metadata model.CacheMetadata
+		if err := json.Unmarshal(value.data, &metadata); err == nil && metadata.URL == url {
+			return metadata.FilePath
+		}
+	}
+	t.Fatalf("no metadata for %s", url)
+	return ""
+}
+
+func TestMemoryRepositorySetAndGetResponse(t *testing.T) {
+	repo, _, _ := newMemoryRepository(t)
+	ctx := context.Background()
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page", Headers: map[string][]string{"Accept-Language": {"ja"}}}
+
+	if _, found, err := repo.GetResponse(ctx, req.GenerateCacheKey()); err != nil || found {
+		t.Fatalf("Expected a miss before storing, got found=%v err=%v", found, err)
+	}
+
+	resp := &model.BpResponse{StatusCode: http.StatusOK, Headers: map[string][]string{"Content-Type": {"text/html"}}, Body: []byte("<p>page</p>"), ContentType: "text/html", ContentLength: 11}
+	if err := repo.SetResponseWithURL(ctx, req, resp, time.Hour); err != nil {
+		t.Fatalf("SetResponseWithURL failed: %v", err)
+	}
+
+	got, found, err := repo.GetResponse(ctx, req.GenerateCacheKey())
+	if err != nil || !found {
+		t.Fatalf("Expected a hit, got found=%v err=%v", found, err)
+	}
+	if string(got.Body) != "<p>page</p>" || got.StatusCode != http.StatusOK || got.ContentType != "text/html" {
+		t.Errorf("Unexpected cached response: %d %q %q", got.StatusCode, got.ContentType, got.Body)
+	}
+	if d := got.ExpiresAt.Sub(got.CachedAt); d != time.Hour {
+		t.Errorf("Expected a one-hour TTL, got %v", d)
+	}
+
+	// キャッシュキーはヘッダーを含む
+	other := &model.BpRequest{Method: http.MethodGet, URL: req.URL, Headers: map[string][]string{"Accept-Language": {"en"}}}
+	if _, found, _ := repo.GetResponse(ctx, other.GenerateCacheKey()); found {
+		t.Error("Expected a miss for a different Accept-Language")
+	}
+
+	entries, err := repo.GetCacheEntries(ctx, req.URL)
+	if err != nil || len(entries) != 1 || entries[0].Size != 11 {
+		t.Errorf("Expected the cache to be indexed by URL, got %+v (%v)", entries, err)
+	}
+	if usage, _ := repo.GetCacheUsage(ctx); usage.Bytes != 11 || usage.Entries != 1 {
+		t.Errorf("Expected 11 bytes in 1 entry, got %+v", usage)
+	}
+}
+
+func TestMemoryRepositoryMetadataTTL(t *testing.T) {
+	repo, _, clock := newMemoryRepository(t)
+	ctx := context.Background()
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/short"}
+	if err := repo.SetResponseWithURL(ctx, req, &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("short")}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(59 * time.Second)
+	if _, found, _ := repo.GetResponse(ctx, req.GenerateCacheKey()); !found {
+		t.Fatal("Expected the cache to be kept until the TTL")
+	}
+	// TTLを過ぎたメタデータはRedisと同じく読めない
+	clock.Advance(time.Second)
+	if _, found, _ := repo.GetResponse(ctx, req.GenerateCacheKey()); found {
+		t.Error("Expected the metadata to expire with the TTL")
+	}
+}
+
+func TestMemoryRepositoryReservationLifecycle(t *testing.T) {
+	repo, _, clock := newMemoryRepository(t)
+	ctx := context.Background()
+	page := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page", RequestID: "page-1"}
+	other := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/other", RequestID: "other-1"}
+
+	first, err := repo.ReserveRequest(ctx, page)
+	if err != nil || !first.Queued || first.Position != 1 || first.QueueLength != 1 {
+		t.Fatalf("Expected the first reservation to be queued, got %+v (%v)", first, err)
+	}
+	// 同じページは予約し直さず、既にある予約の時刻を返す
+	again, err := repo.ReserveRequest(ctx, &model.BpRequest{Method: http.MethodGet, URL: page.URL, RequestID: "page-2"})
+	if err != nil || again.Queued || !again.ReservedAt.Equal(first.ReservedAt) {
+		t.Fatalf("Expected the existing reservation, got %+v (%v)", again, err)
+	}
+	clock.Advance(time.Millisecond)
+	if _, err := repo.ReserveRequest(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if queued, _ := repo.GetReservedRequests(ctx); len(queued) != 2 {
+		t.Fatalf("Expected 2 reservations, got %d", len(queued))
+	}
+
+	// RedisClientと同じく、同じ優先度では先に予約したものから取り出す
+	popped, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || popped == nil || popped.RequestID != "page-1" {
+		t.Fatalf("Expected the page reservation, got %+v (%v)", popped, err)
+	}
+
+	// 取り出した予約を同じ優先度の最後に戻すと、予約済みのまま後から予約したものの次に取り出せる
+	retry := *popped
+	retry.Attempts++
+	clock.Advance(time.Millisecond)
+	if err := repo.RequeueReservedRequest(ctx, &retry); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := repo.ReserveRequest(ctx, page); again.Queued {
+		t.Error("Expected the requeued page to stay reserved")
+	}
+	popped, _ = repo.BLPopReservedRequest(ctx, time.Second)
+	if popped == nil || popped.URL != other.URL {
+		t.Fatalf("Expected %s, got %+v", other.URL, popped)
+	}
+	if err := repo.RemoveReservedRequest(ctx, popped); err != nil {
+		t.Fatal(err)
+	}
+	popped, _ = repo.BLPopReservedRequest(ctx, time.Second)
+	if popped == nil || popped.Attempts != 1 {
+		t.Fatalf("Expected the requeued reservation, got %+v", popped)
+	}
+	if err := repo.RemoveReservedRequest(ctx, popped); err != nil {
+		t.Fatal(err)
+	}
+
+	// すべて処理した後は同じページをまた予約できる
+	if again, _ := repo.ReserveRequest(ctx, page); !again.Queued {
+		t.Error("Expected the page to be reserved again after removal")
+	}
+}
+
+func TestMemoryRepositoryPrefetchWaitsForBrowserRequests(t *testing.T) {
+	repo, _, _ := newMemoryRepository(t)
+	ctx := context.Background()
+	prefetch := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/asset.css", Prefetch: true}
+	browser := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page"}
+	_, _ = repo.ReserveRequest(ctx, prefetch)
+	_, _ = repo.ReserveRequest(ctx, browser)
+
+	for _, want := range []string{browser.URL, prefetch.URL} {
+		popped, err := repo.BLPopReservedRequest(ctx, time.Second)
+		if err != nil || popped == nil || popped.URL != want {
+			t.Fatalf("Expected %s, got %+v (%v)", want, popped, err)
+		}
+	}
+}
+
+func TestMemoryRepositoryPriorityPositions(t *testing.T) {
+	repo, _, clock := newMemoryRepository(t)
+	ctx := context.Background()
+	reserve := func(req *model.BpRequest) model.Reservation {
+		t.Helper()
+		clock.Advance(time.Millisecond)
+		reservation, err := repo.ReserveRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reservation
+	}
+	prefetch := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/asset.css", Prefetch: true}
+	stale := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/stale", Revalidation: true}
+	a := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/a"}
+	b := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/b"}
+	reserve(prefetch)
+	reserve(stale)
+
+	// ブラウザからの予約の位置は、先に予約した事前取得・再検証の予約より前になる
+	if reservation := reserve(a); reservation.Position != 1 || reservation.QueueLength != 3 {
+		t.Errorf("Expected a at 1/3, got %+v", reservation)
+	}
+	if reservation := reserve(b); reservation.Position != 2 || reservation.QueueLength != 4 {
+		t.Errorf("Expected b at 2/4, got %+v", reservation)
+	}
+	// 事前取得の予約と同じページをブラウザが求めると、予約した時刻のまま先頭に上がる
+	if reservation := reserve(&model.BpRequest{Method: http.MethodGet, URL: prefetch.URL}); reservation.Queued || reservation.Position != 1 {
+		t.Errorf("Expected the promoted prefetch at position 1, got %+v", reservation)
+	}
+
+	for _, want := range []string{prefetch.URL, a.URL, b.URL, stale.URL} {
+		popped, err := repo.BLPopReservedRequest(ctx, time.Second)
+		if err != nil || popped == nil || popped.URL != want {
+			t.Fatalf("Expected %s, got %+v (%v)", want, popped, err)
+		}
+	}
+}
+
+func TestMemoryRepositoryRetryAndDeadLetter(t *testing.T) {
+	repo, _, clock := newMemoryRepository(t)
+	ctx := context.Background()
+	prefetch := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/asset.css", Prefetch: true}
+	_, _ = repo.ReserveRequest(ctx, prefetch)
+
+	// 送り直しの待ち時間が過ぎるまでは取り出さない
+	popped, _ := repo.BLPopReservedRequest(ctx, time.Second)
+	retry := *popped
+	retry.Attempts++
+	if err := repo.RetryReservedRequest(ctx, &retry, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if queued, _ := repo.GetReservedRequests(ctx); len(queued) != 1 || queued[0].Attempts != 1 {
+		t.Fatalf("Expected the delayed reservation to be listed, got %+v", queued)
+	}
+	if early, err := repo.BLPopReservedRequest(ctx, 10*time.Millisecond); err != nil || early != nil {
+		t.Fatalf("Expected nothing before the delay, got %+v (%v)", early, err)
+	}
+	clock.Advance(2 * time.Minute)
+	popped, _ = repo.BLPopReservedRequest(ctx, time.Second)
+	if popped == nil || popped.Attempts != 1 {
+		t.Fatalf("Expected the reservation after the delay, got %+v", popped)
+	}
+
+	// デッドレターに移した事前取得は、キューに戻すまで予約し直さない
+	if err := repo.DeadLetterReservedRequest(ctx, popped, "no response"); err != nil {
+		t.Fatal(err)
+	}
+	if reservation, err := repo.ReserveRequest(ctx, prefetch); err != nil || reservation.Queued {
+		t.Errorf("Expected the dead-lettered prefetch to be skipped, got %+v (%v)", reservation, err)
+	}
+	deadLetters, err := repo.ListDeadLetters(ctx)
+	if err != nil || len(deadLetters) != 1 || deadLetters[0].LastError != "no response" || deadLetters[0].Request.Attempts != 1 {
+		t.Fatalf("Expected one dead letter, got %+v (%v)", deadLetters, err)
+	}
+
+	reservation, found, err := repo.RequeueDeadLetter(ctx, deadLetters[0].CacheKey)
+	if err != nil || !found || !reservation.Queued {
+		t.Fatalf("Expected the dead letter to be requeued, got %+v %v (%v)", reservation, found, err)
+	}
+	if popped, _ := repo.BLPopReservedRequest(ctx, time.Second); popped == nil || popped.URL != prefetch.URL || popped.Attempts != 0 {
+		t.Errorf("Expected the requeued reservation with no attempts, got %+v", popped)
+	}
+	if deadLetters, _ := repo.ListDeadLetters(ctx); len(deadLetters) != 0 {
+		t.Errorf("Expected the dead letter to be removed, got %+v", deadLetters)
+	}
+	if _, found, err := repo.RequeueDeadLetter(ctx, "bp:cache:missing"); err != nil || found {
+		t.Errorf("Expected no dead letter, got %v (%v)", found, err)
+	}
+}
+
+func TestMemoryClientBLPopWaitsForReservation(t *testing.T) {
+	repo, _, _ := newMemoryRepository(t)
+	ctx := context.Background()
+
+	start := time.Now()
+	if popped, err := repo.BLPopReservedRequest(ctx, 20*time.Millisecond); err != nil || popped != nil {
+		t.Fatalf("Expected a timeout, got %+v (%v)", popped, err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("Expected to wait for the timeout, returned after %v", d)
+	}
+
+	done := make(chan *model.BpRequest)
+	go func() {
+		popped, _ := repo.BLPopReservedRequest(ctx, 5*time.Second)
+		done <- popped
+	}()
+	time.Sleep(10 * time.Millisecond)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page"}
+	if _, err := repo.ReserveRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case popped := <-done:
+		if popped == nil || popped.URL != req.URL {
+			t.Errorf("Expected the waiting worker to receive %s, got %+v", req.URL, popped)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the reservation to wake the waiting worker")
+	}
+
+	// 待っている間にctxが終わった場合はそのエラーを返す
+	cancelled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := repo.BLPopReservedRequest(cancelled, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation, got %v", err)
+	}
+}
+
+func TestMemoryRepositoryReconcileCacheUsage(t *testing.T) {
+	repo, client, _ := newMemoryRepository(t)
+	ctx := context.Background()
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page"}
+	if err := repo.SetResponseWithURL(ctx, req, &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("0123456789")}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// 加減だけで保つ合計がずれても、登録済みのキャッシュのファイルから数え直す
+	client.mu.Lock()
+	client.bytes = 12345
+	client.mu.Unlock()
+	result, err := repo.ReconcileCacheUsage(ctx)
+	if err != nil {
+		t.Fatalf("ReconcileCacheUsage failed: %v", err)
+	}
+	if result.BytesBefore != 12345 || result.Bytes != 10 || result.Entries != 1 {
+		t.Errorf("Expected the total to be recounted to 10 bytes, got %+v", result)
+	}
+	if usage, _ := repo.GetCacheUsage(ctx); usage.Bytes != 10 {
+		t.Errorf("Expected 10 bytes in use, got %+v", usage)
+	}
+}
+
+func TestMemoryRepositoryDeleteExpiredCaches(t *testing.T) {
+	repo, client, clock := newMemoryRepository(t)
+	ctx := context.Background()
+	store := func(url string, ttl time.Duration, negative bool) *model.BpRequest {
+		req := &model.BpRequest{Method: http.MethodGet, URL: url}
+		resp := &model.BpResponse{StatusCode: http.StatusOK, Body: []byte(url), Negative: negative}
+		if negative {
+			resp.StatusCode = http.StatusNotFound
+		}
+		if err := repo.SetResponseWithURL(ctx, req, resp, ttl); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	expired := store("https://example.com/old", time.Minute, false)
+	missing := store("https://example.com/missing", time.Minute, true)
+	fresh := store("https://example.com/new", time.Hour, false)
+	expiredFile := cacheFilePath(t, client, expired.URL)
+
+	clock.Advance(2 * time.Minute)
+	result, err := repo.DeleteExpiredCaches(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredCaches failed: %v", err)
+	}
+	if result != (model.CleanupResult{Deleted: 2, Negative: 1, Scanned: 3, Complete: true}) {
+		t.Errorf("Expected 2 deleted caches (1 negative), got %+v", result)
+	}
+	if _, err := os.Stat(expiredFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired cache file to be removed, got %v", err)
+	}
+	for _, req := range []*model.BpRequest{expired, missing} {
+		if entries, _ := repo.GetCacheEntries(ctx, req.URL); len(entries) != 0 {
+			t.Errorf("Expected %s to be removed from the index, got %+v", req.URL, entries)
+		}
+	}
+	if _, found, _ := repo.GetResponse(ctx, fresh.GenerateCacheKey()); !found {
+		t.Error("Expected the fresh cache to be kept")
+	}
+	if usage, _ := repo.GetCacheUsage(ctx); usage.Entries != 1 || usage.Bytes != int64(len(fresh.URL)) {
+		t.Errorf("Expected only the fresh cache to be counted, got %+v", usage)
+	}
+}
@@ -0,0 +1,215 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+)
+
+// キャッシュの二次インデックス（キャッシュキーはハッシュのため、URL・ドメインから引けるようにする）
+//   - <prefix>entry:<cacheKey>  Hash    url, domain（削除時にどのインデックスから外すかを知るため）, size
+//   - <prefix>all               ZSet    キャッシュキー（スコアは保存時刻のUnix秒）
+//   - <prefix>domain:<domain>   ZSet    ドメインごとのキャッシュキー
+//   - <prefix>url:<url>         Set     URLごとのキャッシュキー（ヘッダー違いで複数ある）
+//   - <prefix>lru               ZSet    キャッシュキー（スコアは最終アクセス時刻のUnixミリ秒、容量を超えたときに古いものから削除する）
+//   - <prefix>bytes             String  登録したキャッシュの大きさの合計
+//
+// メタデータはTTLでRedisから消えるが、インデックスには残るため、読み出し側（repository）で見つからないキーを削除する
+
+const defaultCacheIndexPrefix = "bp:cache:index:"
+
+func (rc *RedisClient) indexKey(parts ...string) string {
+	prefix := rc.config.CacheIndexPrefix
+	if prefix == "" {
+		prefix = defaultCacheIndexPrefix
+	}
+	for _, part := range parts {
+		prefix += part
+	}
+	return prefix
+}
+
+// addCacheIndexScript キャッシュを登録し、置き換える前の大きさとの差を容量の合計に加える
+// KEYS: entry、all、domain、url、lru、bytes
+// ARGV: キャッシュキー、URL、ドメイン、大きさ、保存時刻（Unix秒）、最終アクセス時刻（Unixミリ秒）
+var addCacheIndexScript = redis.NewScript(`
+local old = tonumber(redis.call("HGET", KEYS[1], "size") or "0") or 0
+redis.call("HSET", KEYS[1], "url", ARGV[2], "domain", ARGV[3], "size", ARGV[4])
+redis.call("ZADD", KEYS[2], ARGV[5], ARGV[1])
+redis.call("ZADD", KEYS[3], ARGV[5], ARGV[1])
+redis.call("SADD", KEYS[4], ARGV[1])
+redis.call("ZADD", KEYS[5], ARGV[6], ARGV[1])
+redis.call("INCRBY", KEYS[6], tonumber(ARGV[4]) - old)
+return 1
+`)
+
+// removeCacheIndexScript キャッシュをインデックスから外し、entryを削除できた場合だけ大きさを容量の合計から引く
+// （同じキャッシュキーを同時に削除しても、合計から引くのは1回だけ）
+// KEYS: entry、all、domain、url、lru、bytes
+// ARGV: キャッシュキー
+var removeCacheIndexScript = redis.NewScript(`
+local size = tonumber(redis.call("HGET", KEYS[1], "size") or "0") or 0
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("ZREM", KEYS[3], ARGV[1])
+redis.call("SREM", KEYS[4], ARGV[1])
+redis.call("ZREM", KEYS[5], ARGV[1])
+if redis.call("DEL", KEYS[1]) == 1 and size ~= 0 then
+	redis.call("DECRBY", KEYS[6], size)
+end
+return 1
+`)
+
+// touchCacheIndexScript 登録済みのキャッシュキーの最終アクセス時刻を、記録済みの時刻より新しい場合だけ更新する
+// （同時のアクセスで古い時刻が後から書き込まれても、時刻が戻らないようにする）
+// KEYS: lru
+// ARGV: キャッシュキー、最終アクセス時刻（Unixミリ秒）
+var touchCacheIndexScript = redis.NewScript(`
+local current = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if current and tonumber(ARGV[2]) > tonumber(current) then
+	redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+end
+return 1
+`)
+
+// recountCacheUsageScript 登録済みのキャッシュの大きさを置き換え、容量の合計をすべてのentryの大きさから数え直す
+// （数え直す間に登録・削除されても合計がずれないように、置き換えと数え直しを1つのスクリプトにする）
+// KEYS: lru、bytes
+// ARGV: entryのキーの接頭辞、キャッシュキーと大きさの組の繰り返し
+var recountCacheUsageScript = redis.NewScript(`
+for i = 2, #ARGV, 2 do
+	local entry = ARGV[1] .. ARGV[i]
+	if redis.call("EXISTS", entry) == 1 then
+		redis.call("HSET", entry, "size", ARGV[i + 1])
+	end
+end
+local total = 0
+for _, key in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+	total = total + (tonumber(redis.call("HGET", ARGV[1] .. key, "size") or "0") or 0)
+end
+redis.call("SET", KEYS[2], string.format("%.0f", total))
+return 1
+`)
+
+func (rc *RedisClient) AddCacheIndex(ctx context.Context, entry repository.CacheIndexEntry) error {
+	keys := []string{
+		rc.indexKey("entry:", entry.CacheKey),
+		rc.indexKey("all"),
+		rc.indexKey("domain:", entry.Domain),
+		rc.indexKey("url:", entry.URL),
+		rc.indexKey("lru"),
+		rc.indexKey("bytes"),
+	}
+	return addCacheIndexScript.Run(ctx, rc.rclient, keys,
+		entry.CacheKey, entry.URL, entry.Domain, entry.Size, entry.StoredAt.Unix(), entry.StoredAt.UnixMilli()).Err()
+}
+
+func (rc *RedisClient) RemoveCacheIndex(ctx context.Context, cacheKey string) error {
+	entryKey := rc.indexKey("entry:", cacheKey)
+	fields, err := rc.rclient.HMGet(ctx, entryKey, "url", "domain").Result()
+	if err != nil {
+		return err
+	}
+	url, _ := fields[0].(string)
+	domain, _ := fields[1].(string)
+
+	keys := []string{
+		entryKey,
+		rc.indexKey("all"),
+		rc.indexKey("domain:", domain),
+		rc.indexKey("url:", url),
+		rc.indexKey("lru"),
+		rc.indexKey("bytes"),
+	}
+	return removeCacheIndexScript.Run(ctx, rc.rclient, keys, cacheKey).Err()
+}
+
+func (rc *RedisClient) TouchCacheIndex(ctx context.Context, cacheKey string, accessedAt time.Time) error {
+	return touchCacheIndexScript.Run(ctx, rc.rclient, []string{rc.indexKey("lru")}, cacheKey, accessedAt.UnixMilli()).Err()
+}
+
+func (rc *RedisClient) LeastRecentlyUsedKeys(ctx context.Context, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	return rc.rclient.ZRange(ctx, rc.indexKey("lru"), 0, int64(limit-1)).Result()
+}
+
+func (rc *RedisClient) GetCacheUsage(ctx context.Context) (int64, int, error) {
+	var bytes *redis.StringCmd
+	var entries *redis.IntCmd
+	_, err := rc.rclient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		bytes = pipe.Get(ctx, rc.indexKey("bytes"))
+		entries = pipe.ZCard(ctx, rc.indexKey("lru"))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, err
+	}
+	total, err := bytes.Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, err
+	}
+	return total, int(entries.Val()), nil
+}
+
+func (rc *RedisClient) RecountCacheUsage(ctx context.Context, sizes map[string]int64) error {
+	args := make([]any, 0, 1+2*len(sizes))
+	args = append(args, rc.indexKey("entry:"))
+	for cacheKey, size := range sizes {
+		args = append(args, cacheKey, size)
+	}
+	return recountCacheUsageScript.Run(ctx, rc.rclient, []string{rc.indexKey("lru"), rc.indexKey("bytes")}, args...).Err()
+}
+
+func (rc *RedisClient) GetCacheKeysByURL(ctx context.Context, url string) ([]string, error) {
+	return rc.rclient.SMembers(ctx, rc.indexKey("url:", url)).Result()
+}
+
+func (rc *RedisClient) ListCacheKeys(ctx context.Context, domain string, offset, limit int) ([]string, int, error) {
+	key := rc.indexKey("all")
+	if domain != "" {
+		key = rc.indexKey("domain:", domain)
+	}
+
+	total, err := rc.rclient.ZCard(ctx, key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 || int64(offset) >= total {
+		return nil, int(total), nil
+	}
+
+	keys, err := rc.rclient.ZRevRange(ctx, key, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	return keys, int(total), nil
+}
+
+// FlushCacheIndex 二次インデックスのキーをすべて削除する
+func (rc *RedisClient) FlushCacheIndex(ctx context.Context) error {
+	scanCount := rc.config.ScanCount
+	if scanCount == 0 {
+		scanCount = 100
+	}
+
+	var cursor uint64
+	for {
+		keys, nextCursor, err := rc.rclient.Scan(ctx, cursor, rc.indexKey("*"), int64(scanCount)).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := rc.rclient.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+		cursor = nextCursor
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
@@ -3,6 +3,8 @@ package plugins
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -11,9 +13,12 @@ import (
 )
 
 type RedisClientConfig struct {
-	ReservedRequestsKey string
+	ReservedRequestsKey string // 予約キューのソート済みセット（スコアは優先度と予約した時刻、queueScoreを参照）
+	ReservedKeysKey     string // 予約済みのキャッシュキーのハッシュ（空の場合はdefaultReservedKeysKey）
 	PendingRequestsKey  string // 追加
+	SentRequestsKey     string // DTNへ送ったリクエストの記録のハッシュ（空の場合はdefaultSentRequestsKey）
 	CacheMetaPattern    string
+	CacheIndexPrefix    string // キャッシュの二次インデックスのキーの接頭辞（空の場合はdefaultCacheIndexPrefix）
 	ScanCount           int
 }
 
@@ -29,6 +34,10 @@ func NewRedisClient(rclient *redis.Client, config RedisClientConfig) *RedisClien
 	}
 }
 
+func (rc *RedisClient) Ping(ctx context.Context) error {
+	return rc.rclient.Ping(ctx).Err()
+}
+
 func (rc *RedisClient) GetMetaData(ctx context.Context, metaKey string) ([]byte, error) {
 	metaData, err := rc.rclient.Get(ctx, metaKey).Bytes()
 	if err == redis.Nil {
@@ -40,62 +49,72 @@ func (rc *RedisClient) GetMetaData(ctx context.Context, metaKey string) ([]byte,
 	return metaData, nil
 }
 
-func (rc *RedisClient) ScanExpiredKeys(ctx context.Context) ([]repository.CacheItem, error) {
-	// ページネーションを使用してキーをスキャン
-	var cursor uint64
-	var expiredItems []repository.CacheItem
-	pattern := rc.config.CacheMetaPattern
-
-	// ScanCountが0の場合はデフォルト値100を使用
-	scanCount := rc.config.ScanCount
-	if scanCount == 0 {
-		scanCount = 100
+// ScanExpiredKeys SCANでcursorからScanCount件ほどのメタデータのキーを調べ、TTLが0以下のものを返す
+// KEYSのようにRedisを止めないよう、1回の呼び出しはSCANの1回分だけにし、TTLとメタデータの取得はパイプラインでまとめる
+func (rc *RedisClient) ScanExpiredKeys(ctx context.Context, cursor uint64) (repository.ExpiredScan, error) {
+	keys, nextCursor, err := rc.rclient.Scan(ctx, cursor, rc.config.CacheMetaPattern, int64(rc.scanCount())).Result()
+	if err != nil {
+		return repository.ExpiredScan{}, err
+	}
+	scan := repository.ExpiredScan{Cursor: nextCursor, Scanned: len(keys)}
+	if len(keys) == 0 {
+		return scan, nil
 	}
 
-	for {
-		keys, nextCursor, err := rc.rclient.Scan(ctx, cursor, pattern, int64(scanCount)).Result()
-		if err != nil {
-			return nil, err
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err = rc.rclient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			ttls[i] = pipe.TTL(ctx, key)
 		}
-
-		for _, key := range keys {
-			ttl, err := rc.rclient.TTL(ctx, key).Result()
-			if err != nil {
-				continue
-			}
-
-			if ttl <= 0 {
-				// メタデータを取得してJSONデコードしてファイルパスを抽出
-				metaData, err := rc.rclient.Get(ctx, key).Bytes()
-				if err != nil {
-					// メタデータが取得できない場合はキーだけ追加
-					expiredItems = append(expiredItems, repository.CacheItem{
-						Key:      key,
-						FilePath: "",
-					})
-					continue
-				}
-
-				var filePath string
-				var metadata model.CacheMetadata
-				if err := json.Unmarshal(metaData, &metadata); err == nil {
-					filePath = metadata.FilePath
-				}
-
-				expiredItems = append(expiredItems, repository.CacheItem{
-					Key:      key,
-					FilePath: filePath,
-				})
-			}
+		return nil
+	})
+	if err != nil {
+		return repository.ExpiredScan{}, err
+	}
+	var expiredKeys []string
+	for i, key := range keys {
+		if ttl, err := ttls[i].Result(); err == nil && ttl <= 0 {
+			expiredKeys = append(expiredKeys, key)
 		}
+	}
+	if len(expiredKeys) == 0 {
+		return scan, nil
+	}
 
-		cursor = nextCursor
-		if cursor == 0 {
-			break
+	// メタデータを取得してJSONデコードしてファイルパスを抽出（取得できない場合はキーだけ返す）
+	values := make([]*redis.StringCmd, len(expiredKeys))
+	_, err = rc.rclient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range expiredKeys {
+			values[i] = pipe.Get(ctx, key)
 		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return repository.ExpiredScan{}, err
 	}
+	for i, key := range expiredKeys {
+		data, err := values[i].Bytes()
+		if errors.Is(err, redis.Nil) {
+			// SCANとGETの間に消えた（TTLが切れた・削除された）キーは返さない
+			continue
+		}
+		item := repository.CacheItem{Key: key}
+		var metadata model.CacheMetadata
+		if err == nil && json.Unmarshal(data, &metadata) == nil {
+			item.FilePath = metadata.FilePath
+			item.Negative = metadata.Negative
+		}
+		scan.Items = append(scan.Items, item)
+	}
+	return scan, nil
+}
 
-	return expiredItems, nil
+// scanCount SCANで1回に調べるキーの数の目安（ScanCountが0の場合はデフォルト値100を使用）
+func (rc *RedisClient) scanCount() int {
+	if rc.config.ScanCount == 0 {
+		return 100
+	}
+	return rc.config.ScanCount
 }
 
 func (rc *RedisClient) SetMetaData(ctx context.Context, metaKey string, data []byte, ttl time.Duration) error {
@@ -148,10 +167,8 @@ func (rc *RedisClient) FlushAllMetaData(ctx context.Context) error {
 }
 
 func (rc *RedisClient) GetReservedRequests(ctx context.Context) ([][]byte, error) {
-	key := rc.config.ReservedRequestsKey
-
-	// Listの全要素を取得
-	dataList, err := rc.rclient.LRange(ctx, key, 0, -1).Result()
+	// スコアの順（優先度の高い順、同じ優先度では時刻の古い順）に全要素を取得
+	dataList, err := rc.rclient.ZRange(ctx, rc.config.ReservedRequestsKey, 0, -1).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -161,56 +178,341 @@ func (rc *RedisClient) GetReservedRequests(ctx context.Context) ([][]byte, error
 	for _, data := range dataList {
 		result = append(result, []byte(data))
 	}
-
 	return result, nil
 }
 
-func (rc *RedisClient) ReserveRequest(ctx context.Context, job []byte) error {
-	jobKey := rc.config.ReservedRequestsKey
-	err := rc.rclient.LPush(ctx, jobKey, job).Err()
-	if err != nil {
-		return err
+// defaultReservedKeysKey 予約済みのキャッシュキーを記録するハッシュ（フィールドはキャッシュキー、値はキューに追加したjob）
+const defaultReservedKeysKey = "bp:reserved:keys"
+
+// queueScoreBand 予約キューのスコアの優先度1つ分の幅（Unixミリ秒の時刻より大きく、スコアをdoubleで正確に表せる値）
+// スコアは 優先度 × queueScoreBand + 時刻（Unixミリ秒）で、優先度の高い（値の小さい）予約ほど、同じ優先度では時刻の古い予約ほど小さい
+const queueScoreBand = 1e13
+
+// queueScore priorityと時刻tの予約キューのスコア
+func queueScore(priority model.ReservationPriority, t time.Time) float64 {
+	return float64(priority)*queueScoreBand + float64(t.UnixMilli())
+}
+
+// reserveScript キャッシュキーが予約済みでなければキューに追加する
+// HSETNXとZADDを1つのスクリプトで行うため、同時に同じページへアクセスがあってもキューの要素は1つになる
+// KEYS: 予約キュー、予約済みのキャッシュキーのハッシュ
+// ARGV: キャッシュキー、job、優先度、スコア、queueScoreBand
+// 戻り値: {1, job}（追加した場合）または{0, 既にある予約のjob}
+var reserveScript = redis.NewScript(`
+if redis.call("HSETNX", KEYS[2], ARGV[1], ARGV[2]) == 1 then
+	redis.call("ZADD", KEYS[1], ARGV[4], ARGV[2])
+	return {1, ARGV[2]}
+end
+local existing = redis.call("HGET", KEYS[2], ARGV[1])
+local score = existing and redis.call("ZSCORE", KEYS[1], existing)
+if score then
+	-- 既にある予約より高い優先度で予約した場合は、時刻はそのままで優先度を上げる
+	local band = tonumber(ARGV[5])
+	local priority = math.floor(tonumber(score) / band)
+	if priority > tonumber(ARGV[3]) then
+		redis.call("ZADD", KEYS[1], tonumber(score) - (priority - tonumber(ARGV[3])) * band, existing)
+	end
+end
+return {0, existing}
+`)
+
+func (rc *RedisClient) reservedKeysKey() string {
+	if rc.config.ReservedKeysKey == "" {
+		return defaultReservedKeysKey
 	}
+	return rc.config.ReservedKeysKey
+}
 
-	return nil
+// processingKey 取り出して処理中の予約のソート済みセット（メンバーはjob、スコアは取り出す前の予約キューのスコア）
+func (rc *RedisClient) processingKey() string {
+	return rc.config.ReservedRequestsKey + ":processing"
 }
 
-func (rc *RedisClient) BLPopReservedRequest(ctx context.Context, timeout time.Duration) ([]byte, error) {
-	key := rc.config.ReservedRequestsKey
+// claimsKey 処理中の予約を取り出した時刻（Unixミリ秒）のソート済みセット（メンバーはjob）
+func (rc *RedisClient) claimsKey() string {
+	return rc.config.ReservedRequestsKey + ":claims"
+}
 
-	// BLPOPでブロッキング取得（タイムアウト付き）
-	result, err := rc.rclient.BLPop(ctx, timeout, key).Result()
+// reliableQueueKeys 予約キューと処理中の予約、取り出した時刻のソート済みセット
+func (rc *RedisClient) reliableQueueKeys() []string {
+	return []string{rc.config.ReservedRequestsKey, rc.processingKey(), rc.claimsKey()}
+}
+
+// deadLettersKey 送り直しの上限に達した予約のハッシュ（フィールドはキャッシュキー、値はデッドレターのJSON）
+func (rc *RedisClient) deadLettersKey() string {
+	return rc.config.ReservedRequestsKey + ":dead"
+}
+
+func (rc *RedisClient) ReserveRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) (bool, []byte, error) {
+	keys := []string{rc.config.ReservedRequestsKey, rc.reservedKeysKey()}
+	score := queueScore(priority, time.Now())
+	result, err := reserveScript.Run(ctx, rc.rclient, keys, cacheKey, job, int(priority), score, queueScoreBand).Slice()
 	if err != nil {
-		if err == redis.Nil {
-			// タイムアウト
-			return nil, nil
+		return false, nil, err
+	}
+	if len(result) == 0 {
+		return false, nil, fmt.Errorf("unexpected reserve result: %v", result)
+	}
+	queued, _ := result[0].(int64)
+	// 予約済みのjobがスクリプトの実行中に削除された場合（nil）は要素が1つになる
+	var existing []byte
+	if len(result) > 1 {
+		if s, ok := result[1].(string); ok {
+			existing = []byte(s)
 		}
-		return nil, err
 	}
+	return queued == 1, existing, nil
+}
+
+// claimScript 時刻を過ぎた予約のうち、優先度の最も高い、同じ優先度では時刻の最も古い予約を予約キューから処理中の予約へ移し、取り出した時刻を記録する
+// 優先度ごとに、その優先度のスコアの範囲のうち今の時刻までをZRANGEBYSCOREで調べる（送り直しを待っている予約は時刻が先のため取り出さない）
+// KEYS: reliableQueueKeys
+// ARGV: 今の時刻（Unixミリ秒）、queueScoreBand、優先度の数
+// 戻り値: 取り出したjob（取り出せる予約がない場合はnil）
+var claimScript = redis.NewScript(`
+local band = tonumber(ARGV[2])
+for priority = 0, tonumber(ARGV[3]) - 1 do
+	local base = priority * band
+	local due = redis.call("ZRANGEBYSCORE", KEYS[1], base, base + tonumber(ARGV[1]), "WITHSCORES", "LIMIT", 0, 1)
+	if due[1] then
+		redis.call("ZREM", KEYS[1], due[1])
+		redis.call("ZADD", KEYS[2], due[2], due[1])
+		redis.call("ZADD", KEYS[3], ARGV[1], due[1])
+		return due[1]
+	end
+end
+return false
+`)
+
+// claimPollInterval 取り出せる予約がない場合に、予約キューを確かめ直す間隔
+// ソート済みセットは時刻を過ぎた予約だけを待つことができないため、BZPOPMINの代わりにこの間隔で確かめる
+var claimPollInterval = 200 * time.Millisecond
+
+// BLPopReservedRequest 時刻を過ぎた予約のうち優先度の最も高いものを取り出して処理中の予約へ移す
+// 取り出せる予約がない場合は、claimPollIntervalごとに確かめ直してtimeoutまで待つ
+func (rc *RedisClient) BLPopReservedRequest(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		job, err := claimScript.Run(ctx, rc.rclient, rc.reliableQueueKeys(), time.Now().UnixMilli(), queueScoreBand, len(model.ReservationPriorities)).Text()
+		if err == nil {
+			// 生のバイトデータを返す（JSONデコードはrepository層で行う）
+			return []byte(job), nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
 
-	// result[0]はキー名、result[1]は値
-	if len(result) < 2 {
-		return nil, nil
+		wait := claimPollInterval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				// タイムアウト
+				return nil, nil
+			}
+			wait = min(wait, remaining)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
 	}
+}
+
+// removeReservedScript jobと予約済みの記録にあるjobを、予約キューと処理中の予約から削除し、予約済みの記録も削除する
+// デッドレターが渡された場合は、キャッシュキーのデッドレターとして保存する
+// KEYS: reliableQueueKeys、予約済みのキャッシュキーのハッシュ、デッドレターのハッシュ
+// ARGV: キャッシュキー、job、デッドレター（省略できる）
+var removeReservedScript = redis.NewScript(`
+local jobs = {ARGV[2]}
+local existing = redis.call("HGET", KEYS[4], ARGV[1])
+if existing and existing ~= ARGV[2] then
+	table.insert(jobs, existing)
+end
+for _, job in ipairs(jobs) do
+	for i = 1, 3 do
+		redis.call("ZREM", KEYS[i], job)
+	end
+end
+redis.call("HDEL", KEYS[4], ARGV[1])
+if ARGV[3] then
+	redis.call("HSET", KEYS[5], ARGV[1], ARGV[3])
+end
+return 1
+`)
+
+// removeReservedKeys removeReservedScriptのKEYS
+func (rc *RedisClient) removeReservedKeys() []string {
+	return append(rc.reliableQueueKeys(), rc.reservedKeysKey(), rc.deadLettersKey())
+}
+
+func (rc *RedisClient) RemoveReservedRequest(ctx context.Context, cacheKey string, job []byte) error {
+	// 予約キューから該当する要素を削除し、同じキャッシュキーを再び予約できるようにする
+	// Workerが取り出した後は処理中の予約にあるため、そこから削除する
+	return removeReservedScript.Run(ctx, rc.rclient, rc.removeReservedKeys(), cacheKey, job).Err()
+}
+
+// requeueScript 予約済みの記録にあるjobを予約キューと処理中の予約から削除し、jobをスコアで予約キューに追加して予約済みの記録を置き換える
+// KEYS: reliableQueueKeys、予約済みのキャッシュキーのハッシュ
+// ARGV: キャッシュキー、job、スコア
+var requeueScript = redis.NewScript(`
+local existing = redis.call("HGET", KEYS[4], ARGV[1])
+if existing then
+	for i = 1, 3 do
+		redis.call("ZREM", KEYS[i], existing)
+	end
+end
+redis.call("HSET", KEYS[4], ARGV[1], ARGV[2])
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[2])
+return 1
+`)
+
+func (rc *RedisClient) RequeueReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) error {
+	// 今の時刻をスコアにして、同じ優先度の予約の最後（最も後に取り出す位置）に戻す
+	return rc.requeue(ctx, cacheKey, job, queueScore(priority, time.Now()))
+}
+
+func (rc *RedisClient) DelayReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority, readyAt time.Time) error {
+	// 戻す時刻をスコアにするため、BLPopReservedRequestはその時刻を過ぎるまで取り出さない
+	return rc.requeue(ctx, cacheKey, job, queueScore(priority, readyAt))
+}
 
-	// 生のバイトデータを返す（JSONデコードはrepository層で行う）
-	return []byte(result[1]), nil
+func (rc *RedisClient) requeue(ctx context.Context, cacheKey string, job []byte, score float64) error {
+	keys := append(rc.reliableQueueKeys(), rc.reservedKeysKey())
+	return requeueScript.Run(ctx, rc.rclient, keys, cacheKey, job, score).Err()
 }
 
-func (rc *RedisClient) RemoveReservedRequest(ctx context.Context, job []byte) error {
-	// Listから該当する要素を削除
-	key := rc.config.ReservedRequestsKey
-	err := rc.rclient.LRem(ctx, key, 1, job).Err()
+// requeueStuckScript claimedBeforeより前に取り出した処理中の予約を、取り出す前の優先度と今の時刻で予約キューに戻す
+// KEYS: reliableQueueKeys
+// ARGV: claimedBefore（Unixミリ秒）、今の時刻（Unixミリ秒）、queueScoreBand
+// 戻り値: キューに戻したjob
+var requeueStuckScript = redis.NewScript(`
+local band = tonumber(ARGV[3])
+local requeued = {}
+for _, job in ipairs(redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", "(" .. ARGV[1])) do
+	local score = redis.call("ZSCORE", KEYS[2], job)
+	if score then
+		redis.call("ZREM", KEYS[2], job)
+		redis.call("ZADD", KEYS[1], math.floor(tonumber(score) / band) * band + tonumber(ARGV[2]), job)
+		table.insert(requeued, job)
+	end
+	redis.call("ZREM", KEYS[3], job)
+end
+return requeued
+`)
+
+func (rc *RedisClient) RequeueStuckRequests(ctx context.Context, claimedBefore time.Time) ([][]byte, error) {
+	jobs, err := requeueStuckScript.Run(ctx, rc.rclient, rc.reliableQueueKeys(), claimedBefore.UnixMilli(), time.Now().UnixMilli(), queueScoreBand).StringSlice()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	result := make([][]byte, 0, len(jobs))
+	for _, job := range jobs {
+		result = append(result, []byte(job))
+	}
+	return result, nil
+}
 
-	return nil
+// legacyPrefetchRequestsKey 予約キューをリストで持っていた以前の形式の、事前取得の予約のキュー
+const legacyPrefetchRequestsKey = "bp:prefetch:requests"
+
+// MigrateLegacyQueue 予約キューをリストで持っていた以前の形式のキーを、ソート済みセットの予約キューへ移す（起動時、Workerを起動する前に呼ぶ）
+// 以前の形式では予約キューと処理中の予約が同じ名前のリストで、事前取得の予約と送り直しを待っている予約は別のキーにあった
+// リストの予約は処理中の予約を先に取り出す順で今の時刻までに、送り直しを待っている予約は戻す時刻に、jobの優先度で並べる
+// 予約キューのキーが以前の形式でもソート済みセットでもない場合は、予約を取り出せないためエラーを返す
+// 戻り値: 移した予約の数（以前の形式のキーがなければ0）
+func (rc *RedisClient) MigrateLegacyQueue(ctx context.Context) (int, error) {
+	queueKey := rc.config.ReservedRequestsKey
+	lists := []string{rc.processingKey(), queueKey, legacyPrefetchRequestsKey + ":processing", legacyPrefetchRequestsKey}
+	delayed := []string{queueKey + ":delayed", legacyPrefetchRequestsKey + ":delayed"}
+
+	var legacy, listed []string
+	var members []redis.Z
+	var jobs []any
+	add := func(job string, at time.Time) {
+		members = append(members, redis.Z{Score: queueScore(legacyJobPriority(job), at), Member: job})
+		jobs = append(jobs, job)
+	}
+	for _, key := range lists {
+		keyType, err := rc.rclient.Type(ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to check the type of %s: %w", key, err)
+		}
+		switch {
+		case keyType == "list":
+		case keyType == "none" || keyType == "zset" && (key == queueKey || key == rc.processingKey()):
+			continue
+		default:
+			return 0, fmt.Errorf("reservation queue key %s has unexpected type %s", key, keyType)
+		}
+		queued, err := rc.rclient.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read legacy queue %s: %w", key, err)
+		}
+		legacy = append(legacy, key)
+		listed = append(listed, queued...)
+	}
+	// 1ミリ秒ずつずらして取り出す順を保ち、最後の予約が今の時刻になるようにする（すぐに取り出せる）
+	start := time.Now().Add(-time.Duration(len(listed)) * time.Millisecond)
+	for i, job := range listed {
+		add(job, start.Add(time.Duration(i+1)*time.Millisecond))
+	}
+	for _, key := range delayed {
+		keyType, err := rc.rclient.Type(ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to check the type of %s: %w", key, err)
+		}
+		if keyType != "zset" {
+			continue
+		}
+		waiting, err := rc.rclient.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read legacy delayed queue %s: %w", key, err)
+		}
+		legacy = append(legacy, key)
+		for _, z := range waiting {
+			job, _ := z.Member.(string)
+			add(job, time.UnixMilli(int64(z.Score)))
+		}
+	}
+	if len(legacy) == 0 {
+		return 0, nil
+	}
+
+	// 以前の形式のキーを削除してから、同じ名前のキーをソート済みセットとして作り直す
+	// 処理中だった予約もキューに戻すため、取り出した時刻の記録から外す
+	_, err := rc.rclient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, legacy...)
+		if len(members) > 0 {
+			pipe.ZAdd(ctx, queueKey, members...)
+			pipe.ZRem(ctx, rc.claimsKey(), jobs...)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to migrate legacy queue: %w", err)
+	}
+	return len(members), nil
+}
+
+// legacyJobPriority 以前の形式のキューのjobの優先度（デコードできない場合はブラウザが待っているリクエストとみなす）
+func legacyJobPriority(job string) model.ReservationPriority {
+	var req model.BpRequest
+	if err := json.Unmarshal([]byte(job), &req); err != nil {
+		return model.PriorityInteractive
+	}
+	return req.Priority()
 }
 
 func (rc *RedisClient) FlushAllReservedRequest(ctx context.Context) error {
-	// 予約済みリクエストのキューを削除
-	err := rc.rclient.Del(ctx, rc.config.ReservedRequestsKey).Err()
+	// 予約キューと処理中の予約、予約済みのキャッシュキー・送ったリクエストの記録を削除
+	// デッドレターは調べられるように残す
+	err := rc.rclient.Del(ctx, append(rc.reliableQueueKeys(), rc.reservedKeysKey(), rc.sentRequestsKey())...).Err()
 	if err != nil {
 		return err
 	}
@@ -218,13 +520,13 @@ func (rc *RedisClient) FlushAllReservedRequest(ctx context.Context) error {
 }
 
 func (rc *RedisClient) FlushAllCaches(ctx context.Context) error {
-	// Redis上の関連キーをすべて削除
+	// キャッシュのメタデータと二次インデックスを削除（予約と送信の記録は残す）
 	err := rc.FlushAllMetaData(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = rc.FlushAllReservedRequest(ctx)
+	err = rc.FlushCacheIndex(ctx)
 	if err != nil {
 		return err
 	}
@@ -232,6 +534,72 @@ func (rc *RedisClient) FlushAllCaches(ctx context.Context) error {
 	return nil
 }
 
+func (rc *RedisClient) DeadLetterReservedRequest(ctx context.Context, cacheKey string, job []byte, entry []byte) error {
+	return removeReservedScript.Run(ctx, rc.rclient, rc.removeReservedKeys(), cacheKey, job, entry).Err()
+}
+
+func (rc *RedisClient) GetDeadLetter(ctx context.Context, cacheKey string) ([]byte, error) {
+	data, err := rc.rclient.HGet(ctx, rc.deadLettersKey(), cacheKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (rc *RedisClient) GetDeadLetters(ctx context.Context) ([][]byte, error) {
+	values, err := rc.rclient.HVals(ctx, rc.deadLettersKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, 0, len(values))
+	for _, value := range values {
+		result = append(result, []byte(value))
+	}
+	return result, nil
+}
+
+func (rc *RedisClient) DeleteDeadLetter(ctx context.Context, cacheKey string) error {
+	return rc.rclient.HDel(ctx, rc.deadLettersKey(), cacheKey).Err()
+}
+
+// defaultSentRequestsKey DTNへ送ったリクエストの記録のハッシュ（フィールドはRequestID、値は記録のJSON）
+const defaultSentRequestsKey = "bp:sent:requests"
+
+func (rc *RedisClient) sentRequestsKey() string {
+	if rc.config.SentRequestsKey == "" {
+		return defaultSentRequestsKey
+	}
+	return rc.config.SentRequestsKey
+}
+
+func (rc *RedisClient) SetSentRequest(ctx context.Context, requestID string, data []byte) error {
+	return rc.rclient.HSet(ctx, rc.sentRequestsKey(), requestID, data).Err()
+}
+
+func (rc *RedisClient) GetSentRequest(ctx context.Context, requestID string) ([]byte, error) {
+	data, err := rc.rclient.HGet(ctx, rc.sentRequestsKey(), requestID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (rc *RedisClient) GetSentRequests(ctx context.Context) ([][]byte, error) {
+	values, err := rc.rclient.HVals(ctx, rc.sentRequestsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, 0, len(values))
+	for _, value := range values {
+		result = append(result, []byte(value))
+	}
+	return result, nil
+}
+
+func (rc *RedisClient) DeleteSentRequest(ctx context.Context, requestID string) error {
+	return rc.rclient.HDel(ctx, rc.sentRequestsKey(), requestID).Err()
+}
+
 func (rc *RedisClient) AddPendingRequest(ctx context.Context, url string) (bool, error) {
 	key := rc.config.PendingRequestsKey
 	// SAdd returns the number of elements added. If 1, it's new. If 0, it already existed.
@@ -0,0 +1,467 @@
+// redis_client_test.go - Redis（miniredis）のキーが多い場合に期限切れのキャッシュを上限ずつ削除すること、ずれたキャッシュの容量の合計を数え直すこと、予約キューの優先度の順と処理中の予約をキューに戻すこと、以前の形式（リスト）の予約キューを移すことのテスト
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+)
+
+// newMiniRedisClient miniredisに接続したRedisClientを作る
+func newMiniRedisClient(t *testing.T) (*RedisClient, *miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	rclient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rclient.Close() })
+	client := NewRedisClient(rclient, RedisClientConfig{
+		ReservedRequestsKey: "bp:reserved:requests",
+		CacheMetaPattern:    "bp:cache:meta:*",
+		ScanCount:           500,
+	})
+	return client, server, rclient
+}
+
+// fillMetaData count件のメタデータをパイプラインで保存する（ttlが0の場合はTTLなし）
+func fillMetaData(t *testing.T, rclient *redis.Client, count int, data func(i int) (key string, value string, ttl time.Duration)) {
+	t.Helper()
+	ctx := context.Background()
+	for start := 0; start < count; start += 1000 {
+		_, err := rclient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := start; i < min(start+1000, count); i++ {
+				key, value, ttl := data(i)
+				pipe.Set(ctx, key, value, ttl)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to fill metadata: %v", err)
+		}
+	}
+}
+
+func TestRedisClientScanExpiredKeys(t *testing.T) {
+	client, server, rclient := newMiniRedisClient(t)
+	ctx := context.Background()
+	fillMetaData(t, rclient, 2000, func(i int) (string, string, time.Duration) {
+		ttl := time.Hour
+		if i%10 == 0 {
+			ttl = 0
+		}
+		if i%10 == 1 {
+			ttl = time.Minute
+		}
+		return fmt.Sprintf("bp:cache:meta:%05d", i), fmt.Sprintf(`{"file_path":"/cache/%05d","negative":%v}`, i, i%20 == 0), ttl
+	})
+	// パターンに一致しないキーは調べない
+	_ = rclient.Set(ctx, "bp:other", "x", 0).Err()
+	_ = rclient.LPush(ctx, "bp:reserved:requests", "job").Err()
+
+	// Redisでは期限の切れたキーは消えるため、期限切れとして返すのはTTLのないキー
+	server.FastForward(2 * time.Minute)
+	items := make(map[string]repository.CacheItem)
+	var scanned int
+	var cursor uint64
+	for calls := 0; calls == 0 || cursor != 0; calls++ {
+		if calls > 2000 {
+			t.Fatal("Expected the scan to finish")
+		}
+		scan, err := client.ScanExpiredKeys(ctx, cursor)
+		if err != nil {
+			t.Fatalf("ScanExpiredKeys failed: %v", err)
+		}
+		scanned += scan.Scanned
+		for _, item := range scan.Items {
+			items[item.Key] = item
+		}
+		cursor = scan.Cursor
+	}
+	if scanned != 1800 {
+		t.Errorf("Expected the 1800 remaining metadata keys to be scanned, got %d", scanned)
+	}
+	if len(items) != 200 {
+		t.Errorf("Expected the 200 keys without a TTL, got %d", len(items))
+	}
+	if item := items["bp:cache:meta:00020"]; item.FilePath != "/cache/00020" || !item.Negative {
+		t.Errorf("Expected the file path and the negative flag, got %+v", item)
+	}
+	if item := items["bp:cache:meta:00030"]; item.FilePath != "/cache/00030" || item.Negative {
+		t.Errorf("Expected a positive cache, got %+v", item)
+	}
+}
+
+func TestRedisRepositoryDeleteExpiredCachesInBudget(t *testing.T) {
+	client, _, rclient := newMiniRedisClient(t)
+	dir := t.TempDir()
+	repo := repository.NewBpRepository(client, dir, 0, 0, 0)
+	repo.SetCleanupBudget(1000)
+	ctx := context.Background()
+
+	// 20000件のうち5000件がTTLのない（期限切れとして削除する）メタデータ。そのうち100件はファイルも置く
+	const total, expired = 20000, 5000
+	var files []string
+	for i := 0; i < 400; i += 4 {
+		filePath := filepath.Join(dir, fmt.Sprintf("%05d", i))
+		if err := os.WriteFile(filePath, []byte("body"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filePath)
+	}
+	fillMetaData(t, rclient, total, func(i int) (string, string, time.Duration) {
+		ttl := time.Hour
+		if i%4 == 0 {
+			ttl = 0
+		}
+		return fmt.Sprintf("bp:cache:meta:%05d", i), fmt.Sprintf(`{"file_path":%q}`, filepath.Join(dir, fmt.Sprintf("%05d", i))), ttl
+	})
+
+	var deleted int
+	for pass := 1; ; pass++ {
+		result, err := repo.DeleteExpiredCaches(ctx)
+		if err != nil {
+			t.Fatalf("DeleteExpiredCaches failed: %v", err)
+		}
+		if result.Deleted != 1000 {
+			t.Errorf("Pass %d: expected the budget of 1000 deleted caches, got %+v", pass, result)
+		}
+		if result.Scanned == 0 {
+			t.Errorf("Pass %d: expected the scanned count, got %+v", pass, result)
+		}
+		deleted += result.Deleted
+		if want := expired - deleted; !result.Complete && result.Remaining != want {
+			t.Errorf("Pass %d: expected %d remaining, got %+v", pass, want, result)
+		}
+		if result.Complete {
+			if pass != 5 {
+				t.Errorf("Expected 5 passes, got %d", pass)
+			}
+			break
+		}
+		if pass > 5 {
+			t.Fatalf("Expected the cleanup to finish, got %+v", result)
+		}
+	}
+
+	if deleted != expired {
+		t.Errorf("Expected %d deleted caches, got %d", expired, deleted)
+	}
+	if keys, _ := rclient.Keys(ctx, "bp:cache:meta:*").Result(); len(keys) != total-expired {
+		t.Errorf("Expected %d metadata keys to be kept, got %d", total-expired, len(keys))
+	}
+	for _, filePath := range files {
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", filePath, err)
+		}
+	}
+
+	// 削除し終えた後は何も残らない
+	result, err := repo.DeleteExpiredCaches(ctx)
+	if err != nil || result.Deleted != 0 || !result.Complete || result.Scanned != total-expired {
+		t.Errorf("Expected nothing left to delete, got %+v err=%v", result, err)
+	}
+}
+
+func TestRedisClientRecountCacheUsage(t *testing.T) {
+	client, _, rclient := newMiniRedisClient(t)
+	ctx := context.Background()
+	base := time.Unix(1_700_000_000, 0)
+	for i, size := range []int64{100, 200, 300} {
+		entry := repository.CacheIndexEntry{CacheKey: fmt.Sprintf("k%d", i), URL: fmt.Sprintf("http://example.com/%d", i), Domain: "example.com", Size: size, StoredAt: base}
+		if err := client.AddCacheIndex(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 加減だけで保つ合計がずれても、数え直すとentryの大きさの合計に戻る
+	if err := rclient.Set(ctx, client.indexKey("bytes"), 1<<40, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RecountCacheUsage(ctx, map[string]int64{"k0": 10, "missing": 999}); err != nil {
+		t.Fatalf("RecountCacheUsage failed: %v", err)
+	}
+	if total, entries, err := client.GetCacheUsage(ctx); err != nil || total != 510 || entries != 3 {
+		t.Errorf("Expected 510 bytes in 3 entries, got %d in %d (%v)", total, entries, err)
+	}
+	if exists, _ := rclient.Exists(ctx, client.indexKey("entry:missing")).Result(); exists != 0 {
+		t.Errorf("Expected no entry to be created for an unregistered key")
+	}
+
+	// 数え直した後も、削除した大きさは合計から引く
+	if err := client.RemoveCacheIndex(ctx, "k0"); err != nil {
+		t.Fatal(err)
+	}
+	if total, entries, _ := client.GetCacheUsage(ctx); total != 500 || entries != 2 {
+		t.Errorf("Expected 500 bytes in 2 entries, got %d in %d", total, entries)
+	}
+}
+
+func TestRedisClientPriorityQueue(t *testing.T) {
+	client, _, rclient := newMiniRedisClient(t)
+	ctx := context.Background()
+	// スコアの時刻はミリ秒のため、予約ごとに時刻を進めて同じ優先度では予約した順に並ぶことを確かめる
+	reserve := func(key string, priority model.ReservationPriority) {
+		t.Helper()
+		time.Sleep(2 * time.Millisecond)
+		if _, _, err := client.ReserveRequest(ctx, key, []byte("job-"+key), priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reserve("p", model.PriorityPrefetch)
+	reserve("v", model.PriorityRevalidation)
+	reserve("a", model.PriorityInteractive)
+	reserve("q", model.PriorityPrefetch)
+	reserve("b", model.PriorityInteractive)
+	expectQueue(t, client, "job-a", "job-b", "job-v", "job-p", "job-q")
+
+	// スコアは優先度の幅と予約した時刻で、高い優先度で予約し直すと時刻のまま幅だけ移る
+	before, err := rclient.ZScore(ctx, "bp:reserved:requests", "job-q").Result()
+	if err != nil || before < 2*queueScoreBand {
+		t.Fatalf("Expected job-q in the prefetch band, got %v (%v)", before, err)
+	}
+	reserve("q", model.PriorityInteractive)
+	if after, _ := rclient.ZScore(ctx, "bp:reserved:requests", "job-q").Result(); after != before-2*queueScoreBand {
+		t.Errorf("Expected job-q to keep its time in the interactive band, got %v", after)
+	}
+	reserve("a", model.PriorityPrefetch)
+	expectQueue(t, client, "job-a", "job-q", "job-b", "job-v", "job-p")
+
+	for _, want := range []string{"job-a", "job-q", "job-b", "job-v", "job-p"} {
+		if job, err := client.BLPopReservedRequest(ctx, time.Second); err != nil || string(job) != want {
+			t.Fatalf("Expected %s, got %q (%v)", want, job, err)
+		}
+	}
+}
+
+func TestRedisClientProcessingRequests(t *testing.T) {
+	client, _, rclient := newMiniRedisClient(t)
+	ctx := context.Background()
+	_, _, _ = client.ReserveRequest(ctx, "a", []byte("job-a"), model.PriorityInteractive)
+	_, _, _ = client.ReserveRequest(ctx, "p", []byte("job-p"), model.PriorityPrefetch)
+
+	// 取り出した予約は処理中の予約に移り、取り出した時刻が記録される
+	for _, want := range []string{"job-a", "job-p"} {
+		job, err := client.BLPopReservedRequest(ctx, time.Second)
+		if err != nil || string(job) != want {
+			t.Fatalf("Expected %s, got %q (%v)", want, job, err)
+		}
+	}
+	processing := func() []string {
+		t.Helper()
+		jobs, err := rclient.ZRange(ctx, client.processingKey(), 0, -1).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobs
+	}
+	if got := processing(); !slices.Equal(got, []string{"job-a", "job-p"}) {
+		t.Errorf("Expected job-a and job-p to be processing, got %v", got)
+	}
+	if n, _ := rclient.ZCard(ctx, client.claimsKey()).Result(); n != 2 {
+		t.Errorf("Expected 2 claims, got %d", n)
+	}
+
+	// Workerが止まった後に別のプロセスが見回ると、取り出す前の優先度の最後に戻す
+	other := NewRedisClient(rclient, client.config)
+	if jobs, err := other.RequeueStuckRequests(ctx, time.Now().Add(-time.Minute)); err != nil || len(jobs) != 0 {
+		t.Errorf("Expected fresh claims to be kept, got %q (%v)", jobs, err)
+	}
+	jobs, err := other.RequeueStuckRequests(ctx, time.Now().Add(time.Minute))
+	if err != nil || !slices.Equal(jobsToStrings(jobs), []string{"job-a", "job-p"}) {
+		t.Fatalf("Expected job-a and job-p to be requeued, got %q (%v)", jobs, err)
+	}
+	expectQueue(t, client, "job-a", "job-p")
+	if score, _ := rclient.ZScore(ctx, "bp:reserved:requests", "job-p").Result(); score < 2*queueScoreBand {
+		t.Errorf("Expected job-p back in the prefetch band, got %v", score)
+	}
+	if n, _ := rclient.ZCard(ctx, client.claimsKey()).Result(); n != 0 || len(processing()) != 0 {
+		t.Errorf("Expected no processing requests, got %d claims", n)
+	}
+	if queued, _, _ := client.ReserveRequest(ctx, "a", []byte("job-a2"), model.PriorityInteractive); queued {
+		t.Error("Expected job-a to stay reserved")
+	}
+
+	// 処理を終えた予約は処理中の予約と予約済みの記録から削除する
+	job, _ := client.BLPopReservedRequest(ctx, time.Second)
+	if err := client.RemoveReservedRequest(ctx, "a", job); err != nil {
+		t.Fatal(err)
+	}
+	if got := processing(); len(got) != 0 {
+		t.Errorf("Expected job-a to be removed from the processing requests, got %v", got)
+	}
+	if n, _ := rclient.ZCard(ctx, client.claimsKey()).Result(); n != 0 {
+		t.Errorf("Expected the claim to be removed, got %d", n)
+	}
+	if queued, _, _ := client.ReserveRequest(ctx, "a", []byte("job-a3"), model.PriorityInteractive); !queued {
+		t.Error("Expected the removed page to be reserved again")
+	}
+}
+
+func TestRedisClientBLPopWaits(t *testing.T) {
+	client, _, _ := newMiniRedisClient(t)
+	ctx := context.Background()
+
+	if job, err := client.BLPopReservedRequest(ctx, time.Second); err != nil || job != nil {
+		t.Errorf("Expected nil after the timeout, got %q (%v)", job, err)
+	}
+
+	// 待っている間に追加された予約は、どの優先度のものも取り出す
+	for _, priority := range model.ReservationPriorities {
+		done := make(chan string, 1)
+		go func() {
+			job, _ := client.BLPopReservedRequest(ctx, 5*time.Second)
+			done <- string(job)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		want := fmt.Sprintf("job-%v", priority)
+		_, _, _ = client.ReserveRequest(ctx, want, []byte(want), priority)
+		select {
+		case job := <-done:
+			if job != want {
+				t.Errorf("Expected the added job (priority: %v), got %q", priority, job)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Expected the waiting call to take the added job (priority: %v)", priority)
+		}
+	}
+	if jobs, _ := client.RequeueStuckRequests(ctx, time.Now().Add(time.Minute)); len(jobs) != len(model.ReservationPriorities) {
+		t.Errorf("Expected all jobs to be processing, got %q", jobs)
+	}
+}
+
+func TestRedisClientDelayedAndDeadLetters(t *testing.T) {
+	client, _, rclient := newMiniRedisClient(t)
+	ctx := context.Background()
+	_, _, _ = client.ReserveRequest(ctx, "a", []byte("job-a"), model.PriorityInteractive)
+	_, _, _ = client.ReserveRequest(ctx, "p", []byte("job-p"), model.PriorityPrefetch)
+	if job, _ := client.BLPopReservedRequest(ctx, time.Second); string(job) != "job-a" {
+		t.Fatalf("Expected job-a, got %q", job)
+	}
+
+	// 待ち時間を空けて戻した予約は、戻す時刻をスコアにして予約キューで待つ
+	readyAt := time.Now().Add(time.Hour)
+	if err := client.DelayReservedRequest(ctx, "a", []byte("job-a2"), model.PriorityInteractive, readyAt); err != nil {
+		t.Fatal(err)
+	}
+	if score, err := rclient.ZScore(ctx, "bp:reserved:requests", "job-a2").Result(); err != nil || int64(score) != readyAt.UnixMilli() {
+		t.Errorf("Expected job-a2 to wait until %d, got %v (%v)", readyAt.UnixMilli(), score, err)
+	}
+	if n, _ := rclient.ZCard(ctx, client.claimsKey()).Result(); n != 0 {
+		t.Errorf("Expected the claim to be removed, got %d", n)
+	}
+	expectQueue(t, client, "job-a2", "job-p")
+	if job, _ := client.BLPopReservedRequest(ctx, time.Second); string(job) != "job-p" {
+		t.Fatalf("Expected job-p before the delayed job, got %q", job)
+	}
+	_ = client.RemoveReservedRequest(ctx, "p", []byte("job-p"))
+	if job, err := client.BLPopReservedRequest(ctx, time.Second); err != nil || job != nil {
+		t.Fatalf("Expected nothing before the ready time, got %q (%v)", job, err)
+	}
+
+	// 戻す時刻を過ぎると取り出す
+	_ = rclient.ZAdd(ctx, "bp:reserved:requests", redis.Z{Score: 1, Member: "job-a2"}).Err()
+	if job, _ := client.BLPopReservedRequest(ctx, time.Second); string(job) != "job-a2" {
+		t.Fatalf("Expected job-a2 after the ready time, got %q", job)
+	}
+
+	// デッドレターに移すと処理中の予約と予約済みの記録から削除し、フラッシュしても残す
+	if err := client.DeadLetterReservedRequest(ctx, "a", []byte("job-a2"), []byte("dead-a")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := rclient.ZCard(ctx, client.processingKey()).Result(); n != 0 {
+		t.Errorf("Expected nothing processing, got %d", n)
+	}
+	if queued, _, _ := client.ReserveRequest(ctx, "a", []byte("job-a3"), model.PriorityInteractive); !queued {
+		t.Error("Expected the dead-lettered page to be reserved again")
+	}
+	if err := client.FlushAllReservedRequest(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := client.GetDeadLetter(ctx, "a"); err != nil || string(entry) != "dead-a" {
+		t.Errorf("Expected the dead letter to be kept, got %q (%v)", entry, err)
+	}
+	if err := client.DeleteDeadLetter(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := client.GetDeadLetters(ctx); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no dead letters, got %q (%v)", entries, err)
+	}
+}
+
+// legacyJob 以前の形式のキューに入っていたjob
+func legacyJob(t *testing.T, req *model.BpRequest) string {
+	t.Helper()
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRedisClientMigrateLegacyQueue(t *testing.T) {
+	client, _, rclient := newMiniRedisClient(t)
+	ctx := context.Background()
+
+	claimed := legacyJob(t, &model.BpRequest{URL: "http://example.com/claimed"})
+	interactive := legacyJob(t, &model.BpRequest{URL: "http://example.com/interactive"})
+	revalidation := legacyJob(t, &model.BpRequest{URL: "http://example.com/stale", Revalidation: true})
+	prefetch := legacyJob(t, &model.BpRequest{URL: "http://example.com/asset", Prefetch: true})
+	waiting := legacyJob(t, &model.BpRequest{URL: "http://example.com/retry"})
+
+	// 以前の形式: 予約キューと処理中の予約はリスト、事前取得の予約と送り直しを待っている予約は別のキー
+	rclient.RPush(ctx, "bp:reserved:requests", revalidation, interactive)
+	rclient.RPush(ctx, "bp:reserved:requests:processing", claimed)
+	rclient.ZAdd(ctx, "bp:reserved:requests:claims", redis.Z{Score: float64(time.Now().UnixMilli()), Member: claimed})
+	rclient.RPush(ctx, "bp:prefetch:requests", prefetch)
+	rclient.ZAdd(ctx, "bp:reserved:requests:delayed", redis.Z{Score: float64(time.Now().Add(-time.Minute).UnixMilli()), Member: waiting})
+
+	migrated, err := client.MigrateLegacyQueue(ctx)
+	if err != nil {
+		t.Fatalf("MigrateLegacyQueue failed: %v", err)
+	}
+	if migrated != 5 {
+		t.Errorf("Expected 5 reservations to be migrated, got %d", migrated)
+	}
+	for _, key := range []string{"bp:reserved:requests:processing", "bp:prefetch:requests", "bp:reserved:requests:delayed", "bp:reserved:requests:claims"} {
+		if n, _ := rclient.Exists(ctx, key).Result(); n != 0 {
+			t.Errorf("Expected the legacy key %s to be removed", key)
+		}
+	}
+
+	// 優先度の順に、同じ優先度では処理中だった予約、キューの順、送り直しを待っていた予約の順に取り出す
+	var got []string
+	for {
+		job, err := client.BLPopReservedRequest(ctx, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("BLPopReservedRequest failed: %v", err)
+		}
+		if job == nil {
+			break
+		}
+		got = append(got, string(job))
+	}
+	want := []string{waiting, claimed, interactive, revalidation, prefetch}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected the migrated order %v, got %v", want, got)
+	}
+
+	// 移した後は以前の形式のキーがないため何もしない
+	if migrated, err := client.MigrateLegacyQueue(ctx); err != nil || migrated != 0 {
+		t.Errorf("Expected nothing to migrate, got %d (%v)", migrated, err)
+	}
+
+	// 予約キューのキーが他の型の場合はエラーにする
+	rclient.Del(ctx, "bp:reserved:requests", "bp:reserved:requests:processing")
+	rclient.HSet(ctx, "bp:reserved:requests", "field", "value")
+	if _, err := client.MigrateLegacyQueue(ctx); err == nil {
+		t.Error("Expected an error for a queue key of another type")
+	}
+}
@@ -0,0 +1,154 @@
+// prefetch_test.go - 事前取得の予約を低優先度のキューに入れること、タイムアウトした予約をキューに戻すことのテスト
+package repository
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// priorityQueueClient 通常のキューと低優先度（事前取得・再検証）のキューをメモリ上に持つBpRepoClient（Redisの代わり）
+// 通常のキューが空の場合だけ低優先度のキューから取り出す
+type priorityQueueClient struct {
+	BpRepoClient
+	queue    [][]byte
+	prefetch [][]byte
+	reserved map[string][]byte
+}
+
+func (c *priorityQueueClient) ReserveRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) (bool, []byte, error) {
+	if existing, ok := c.reserved[cacheKey]; ok {
+		return false, existing, nil
+	}
+	c.reserved[cacheKey] = job
+	if priority != model.PriorityInteractive {
+		c.prefetch = append(c.prefetch, job)
+	} else {
+		c.queue = append(c.queue, job)
+	}
+	return true, job, nil
+}
+
+func (c *priorityQueueClient) GetDeadLetter(ctx context.Context, cacheKey string) ([]byte, error) {
+	return nil, nil
+}
+
+func (c *priorityQueueClient) GetReservedRequests(ctx context.Context) ([][]byte, error) {
+	return append(append([][]byte{}, c.queue...), c.prefetch...), nil
+}
+
+func (c *priorityQueueClient) BLPopReservedRequest(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	for _, queue := range []*[][]byte{&c.queue, &c.prefetch} {
+		if len(*queue) > 0 {
+			job := (*queue)[0]
+			*queue = (*queue)[1:]
+			return job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *priorityQueueClient) RemoveReservedRequest(ctx context.Context, cacheKey string, job []byte) error {
+	if string(c.reserved[cacheKey]) == string(job) {
+		delete(c.reserved, cacheKey)
+	}
+	return nil
+}
+
+func (c *priorityQueueClient) RequeueReservedRequest(ctx context.Context, cacheKey string, job []byte, priority model.ReservationPriority) error {
+	c.reserved[cacheKey] = job
+	if priority != model.PriorityInteractive {
+		c.prefetch = append(c.prefetch, job)
+	} else {
+		c.queue = append(c.queue, job)
+	}
+	return nil
+}
+
+func TestReservePrefetchRequest(t *testing.T) {
+	client := &priorityQueueClient{reserved: make(map[string][]byte)}
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	ctx := context.Background()
+
+	prefetch := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/reading", Prefetch: true, PrefetchDepth: 2}
+	browser := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/now", RequestID: "browser-1"}
+	for _, req := range []*model.BpRequest{prefetch, browser} {
+		if reservation, err := repo.ReserveRequest(ctx, req); err != nil || !reservation.Queued {
+			t.Fatalf("Expected %s to be queued, got %+v (%v)", req.URL, reservation, err)
+		}
+	}
+	if len(client.queue) != 1 || len(client.prefetch) != 1 {
+		t.Fatalf("Expected one reservation in each queue, got %d normal and %d prefetch", len(client.queue), len(client.prefetch))
+	}
+
+	// 後から予約したブラウザからのリクエストを先に取り出す
+	first, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || first == nil || first.URL != browser.URL || first.Prefetch {
+		t.Fatalf("Expected the browser request first, got %+v (%v)", first, err)
+	}
+	// リクエストIDはキューを通しても残る（ワーカーがバンドルに付ける）
+	if first.RequestID != "browser-1" {
+		t.Errorf("Expected the request ID to survive the queue, got %q", first.RequestID)
+	}
+	second, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || second == nil || second.URL != prefetch.URL {
+		t.Fatalf("Expected the prefetch request second, got %+v (%v)", second, err)
+	}
+	// 事前取得の印と深さはキューを通しても残る
+	if !second.Prefetch || second.PrefetchDepth != 2 {
+		t.Errorf("Expected prefetch with depth 2, got prefetch=%v depth=%d", second.Prefetch, second.PrefetchDepth)
+	}
+
+	// 取り出したリクエストで予約を削除でき（同じJSONになる）、同じURLを再び予約できる
+	if err := repo.RemoveReservedRequest(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.reserved[prefetch.GenerateCacheKey()]; ok {
+		t.Fatal("Expected the prefetch reservation to be removed")
+	}
+	if reservation, _ := repo.ReserveRequest(ctx, prefetch); !reservation.Queued {
+		t.Error("Expected the URL to be prefetched again after the worker finished")
+	}
+}
+
+func TestRequeueReservedRequest(t *testing.T) {
+	client := &priorityQueueClient{reserved: make(map[string][]byte)}
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	ctx := context.Background()
+
+	prefetch := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/reading", Prefetch: true}
+	if _, err := repo.ReserveRequest(ctx, prefetch); err != nil {
+		t.Fatal(err)
+	}
+	popped, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || popped == nil {
+		t.Fatalf("Expected the reservation, got %+v (%v)", popped, err)
+	}
+
+	// タイムアウトした予約は予約済みのまま、同じ優先度のキューに戻す
+	popped.Attempts++
+	if err := repo.RequeueReservedRequest(ctx, popped); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.prefetch) != 1 || len(client.queue) != 0 {
+		t.Fatalf("Expected the request back in the prefetch queue, got %d normal and %d prefetch", len(client.queue), len(client.prefetch))
+	}
+	if reservation, _ := repo.ReserveRequest(ctx, prefetch); reservation.Queued {
+		t.Error("Expected the URL to stay reserved while requeued")
+	}
+
+	// 戻した予約を取り出して削除できる（回数を含めて同じJSONになる）
+	retry, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || retry == nil || retry.Attempts != 1 {
+		t.Fatalf("Expected the requeued request with 1 attempt, got %+v (%v)", retry, err)
+	}
+	if err := repo.RemoveReservedRequest(ctx, retry); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.reserved[prefetch.GenerateCacheKey()]; ok {
+		t.Error("Expected the reservation to be removed")
+	}
+}
@@ -0,0 +1,38 @@
+// queue_position_test.go - 予約の結果に予約キューでの位置とキューの長さを含めることのテスト
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+func TestReserveRequestQueuePosition(t *testing.T) {
+	client := &priorityQueueClient{reserved: make(map[string][]byte)}
+	repo := NewBpRepository(client, t.TempDir(), 0, 0, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		req := &model.BpRequest{Method: http.MethodGet, URL: fmt.Sprintf("https://example.com/%d", i)}
+		reservation, err := repo.ReserveRequest(ctx, req)
+		if err != nil || reservation.Position != i+1 || reservation.QueueLength != i+1 {
+			t.Fatalf("Expected position %d of %d, got %+v (%v)", i+1, i+1, reservation, err)
+		}
+	}
+
+	// 事前取得の予約は待っているクライアントがいないため位置を調べない
+	prefetch := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/prefetch", Prefetch: true}
+	if reservation, err := repo.ReserveRequest(ctx, prefetch); err != nil || reservation.Position != 0 || reservation.QueueLength != 0 {
+		t.Errorf("Expected no position for a prefetch, got %+v (%v)", reservation, err)
+	}
+
+	// 予約済みのページは既にある予約の位置を返す
+	again := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/1"}
+	reservation, err := repo.ReserveRequest(ctx, again)
+	if err != nil || reservation.Queued || reservation.Position != 2 || reservation.QueueLength != 4 {
+		t.Errorf("Expected the existing reservation at position 2 of 4, got %+v (%v)", reservation, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
 )
 
 type CacheHandler struct {
@@ -17,7 +18,7 @@ func NewCacheHandler(bprepo repository.BpRepository) *CacheHandler {
 }
 
 // DeleteExpiredCaches 期限切れのキャッシュを削除する
-func (ch *CacheHandler) DeleteExpiredCaches(ctx context.Context) error {
+func (ch *CacheHandler) DeleteExpiredCaches(ctx context.Context) (model.CleanupResult, error) {
 	return ch.bprepo.DeleteExpiredCaches(ctx)
 }
 
@@ -25,3 +26,8 @@ func (ch *CacheHandler) DeleteExpiredCaches(ctx context.Context) error {
 func (ch *CacheHandler) DeleteAllCaches(ctx context.Context) error {
 	return ch.bprepo.DeleteAllCaches(ctx)
 }
+
+// ReconcileCacheUsage キャッシュ全体の使用量をキャッシュディレクトリのファイルと突き合わせて数え直す
+func (ch *CacheHandler) ReconcileCacheUsage(ctx context.Context) (model.CacheReconcileResult, error) {
+	return ch.bprepo.ReconcileCacheUsage(ctx)
+}
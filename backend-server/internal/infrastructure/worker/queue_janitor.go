@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+)
+
+type QueueJanitor struct {
+	bprepo repository.BpRepository
+	// stuckAfter 取り出してから処理中のままキューに戻すまでの時間（DTNの応答を待つ時間より長くする）
+	stuckAfter time.Duration
+}
+
+// NewQueueJanitor 取り出してからstuckAfterより長く処理中のままの予約をキューに戻すQueueJanitorを作る
+func NewQueueJanitor(bprepo repository.BpRepository, stuckAfter time.Duration) *QueueJanitor {
+	return &QueueJanitor{
+		bprepo:     bprepo,
+		stuckAfter: stuckAfter,
+	}
+}
+
+// RequeueStuckRequests 処理中のまま残った予約をキューに戻す
+// 予約済みの記録は残っているため、キューに戻した予約はプレースホルダーで待っているクライアントの予約のまま処理される
+func (qj *QueueJanitor) RequeueStuckRequests(ctx context.Context) (int, error) {
+	requeued, err := qj.bprepo.RequeueStuckRequests(ctx, qj.stuckAfter)
+	if err != nil {
+		return 0, err
+	}
+	for _, req := range requeued {
+		log.Printf("[QueueJanitor] 処理中のまま%vを過ぎた予約をキューに戻しました (URL: %s, RequestID: %s)", qj.stuckAfter, req.URL, req.RequestID)
+	}
+	return len(requeued), nil
+}
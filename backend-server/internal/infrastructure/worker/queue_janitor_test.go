@@ -0,0 +1,62 @@
+// queue_janitor_test.go - 処理の途中でWorkerが止まった予約をキューに戻して処理し直すことのテスト
+package worker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+)
+
+func TestQueueJanitorRecoversCrashedWorker(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.RespondBody("https://example.com/page", "text/html", "<p>page</p>")
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+	ctx := context.Background()
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page", RequestID: "req-1"}
+	if _, err := repo.ReserveRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	// 取り出した後、HandleRequestを終える前にWorkerが止まる
+	if popped, err := NewQueueWatcher(repo, time.Second).WatchQueue(ctx); err != nil || popped == nil {
+		t.Fatalf("Expected a reservation, got %v %v", popped, err)
+	}
+	if queue, _ := repo.GetReservedRequests(ctx); len(queue) != 0 {
+		t.Fatalf("Expected the queue to be empty while processing, got %+v", queue)
+	}
+
+	// 処理中になって間もない予約は戻さない
+	if n, err := NewQueueJanitor(repo, time.Hour).RequeueStuckRequests(ctx); err != nil || n != 0 {
+		t.Errorf("Expected the fresh claim to be kept, got %d (%v)", n, err)
+	}
+	n, err := NewQueueJanitor(repo, 0).RequeueStuckRequests(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected the stuck reservation to be requeued, got %d (%v)", n, err)
+	}
+	if processing := repo.ProcessingRequests(); len(processing) != 0 {
+		t.Errorf("Expected nothing left processing, got %+v", processing)
+	}
+	// 予約済みの記録は残り、同じページへのアクセスで二重に予約しない
+	if !repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected the reservation to be kept")
+	}
+	if queue, _ := repo.GetReservedRequests(ctx); len(queue) != 1 || queue[0].RequestID != "req-1" {
+		t.Fatalf("Expected the request to be back in the queue, got %+v", queue)
+	}
+
+	// 別のWorkerが処理し直す
+	popAndHandle(t, repo, rh)
+	if _, found, _ := repo.GetResponse(ctx, req.GenerateCacheKey()); !found {
+		t.Error("Expected the response to be cached")
+	}
+	if repo.IsReserved(req.GenerateCacheKey()) || len(repo.ProcessingRequests()) != 0 {
+		t.Error("Expected the reservation to be removed after handling")
+	}
+	if n, _ := NewQueueJanitor(repo, 0).RequeueStuckRequests(ctx); n != 0 {
+		t.Errorf("Expected nothing to requeue after handling, got %d", n)
+	}
+}
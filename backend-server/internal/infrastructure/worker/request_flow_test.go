@@ -0,0 +1,337 @@
+// request_flow_test.go - 予約の取り出しから転送・キャッシュの保存・予約の削除まで（HandleRequest）のテスト（メモリ上のリポジトリとゲートウェイを使う）
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	gateway_impl "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+)
+
+// popAndHandle 予約を1つ取り出してHandleRequestで処理する
+func popAndHandle(t *testing.T, repo *fakes.Repository, rh *RequestHandler) *model.BpRequest {
+	t.Helper()
+	req, err := repo.BLPopReservedRequest(context.Background(), time.Second)
+	if err != nil || req == nil {
+		t.Fatalf("Expected a reservation, got %v %v", req, err)
+	}
+	if err := rh.HandleRequest(context.Background(), req, 1); err != nil {
+		t.Fatalf("HandleRequest failed: %v", err)
+	}
+	return req
+}
+
+func TestHandleRequestStoresResponse(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.RespondBody("https://example.com/page", "text/html", "<p>page</p>")
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page", RequestID: "req-1"}
+	if _, err := repo.ReserveRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	popAndHandle(t, repo, rh)
+
+	resp, found, err := repo.GetResponse(context.Background(), req.GenerateCacheKey())
+	if err != nil || !found || string(resp.Body) != "<p>page</p>" {
+		t.Fatalf("Expected the response to be cached, got found=%v err=%v", found, err)
+	}
+	if d := time.Until(resp.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Expected a one-hour TTL, got %v", d)
+	}
+	if repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected the reservation to be removed")
+	}
+	if requests := gw.Requests(); len(requests) != 1 || requests[0].RequestID != "req-1" {
+		t.Errorf("Expected the reserved request to be sent once, got %+v", requests)
+	}
+
+	// キャッシュがある間に届いた予約は転送しない
+	if _, err := repo.ReserveRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	popAndHandle(t, repo, rh)
+	if len(gw.Requests()) != 1 {
+		t.Errorf("Expected the cached page not to be fetched again, got %d requests", len(gw.Requests()))
+	}
+}
+
+func TestHandleRequestGatewayFailure(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.Fail("https://example.com/page", fmt.Errorf("%w: broken pipe", gateway.ErrSendFailed))
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+	ctx := context.Background()
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page", Prefetch: true}
+	if _, err := repo.ReserveRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt < defaultRetryPolicy.Attempts; attempt++ {
+		popped, err := repo.BLPopReservedRequest(ctx, time.Second)
+		if err != nil || popped == nil {
+			t.Fatalf("Expected attempt %d to be queued, got %v %v", attempt, popped, err)
+		}
+		if err := rh.HandleRequest(ctx, popped, 1); !errors.Is(err, gateway.ErrSendFailed) {
+			t.Errorf("Expected the send failure to be reported, got %v", err)
+		}
+	}
+
+	// 上限まで送っても失敗した予約は、最後のエラーとともにデッドレターに移す
+	popAndHandle(t, repo, rh)
+	if _, found, _ := repo.GetResponse(ctx, req.GenerateCacheKey()); found {
+		t.Error("Expected nothing to be cached")
+	}
+	if repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected the reservation to be removed")
+	}
+	deadLetters, err := repo.ListDeadLetters(ctx)
+	if err != nil || len(deadLetters) != 1 {
+		t.Fatalf("Expected one dead letter, got %+v %v", deadLetters, err)
+	}
+	if dl := deadLetters[0]; dl.CacheKey != req.GenerateCacheKey() || dl.Request.Attempts != defaultRetryPolicy.Attempts-1 || dl.LastError != "failed to send bundle: broken pipe" {
+		t.Errorf("Unexpected dead letter %+v (request %+v)", dl, dl.Request)
+	}
+
+	// 同じページのプリフェッチは予約し直さないが、ブラウザからのアクセスでは予約し直せる
+	if reservation, err := repo.ReserveRequest(ctx, req); err != nil || reservation.Queued {
+		t.Errorf("Expected the prefetch to be skipped, got %+v %v", reservation, err)
+	}
+	browser := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page"}
+	if reservation, err := repo.ReserveRequest(ctx, browser); err != nil || !reservation.Queued {
+		t.Errorf("Expected to reserve again after the failure, got %+v %v", reservation, err)
+	}
+}
+
+func TestHandleRequestRetryDelay(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.Fail("https://example.com/lost", fmt.Errorf("%w: no response", gateway.ErrTimeout))
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+	rh.SetRetryPolicy(gateway_impl.RetryPolicy{Attempts: 3, BaseDelay: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/lost"}
+	_, _ = repo.ReserveRequest(ctx, req)
+	popped, _ := repo.BLPopReservedRequest(ctx, time.Second)
+	if err := rh.HandleRequest(ctx, popped, 1); !errors.Is(err, gateway.ErrTimeout) {
+		t.Fatalf("Expected the timeout to be reported, got %v", err)
+	}
+
+	// 待ち時間が過ぎるまでは取り出さない
+	if queue, _ := repo.GetReservedRequests(ctx); len(queue) != 1 || queue[0].Attempts != 1 {
+		t.Fatalf("Expected the request to wait with 1 attempt, got %+v", queue)
+	}
+	if early, err := repo.BLPopReservedRequest(ctx, 10*time.Millisecond); err != nil || early != nil {
+		t.Fatalf("Expected nothing before the delay, got %+v %v", early, err)
+	}
+	start := time.Now()
+	popped, err := repo.BLPopReservedRequest(ctx, time.Second)
+	if err != nil || popped == nil || popped.Attempts != 1 {
+		t.Fatalf("Expected the request after the delay, got %+v %v", popped, err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Expected to wait for the delay, waited %v", waited)
+	}
+
+	// 送り直すたびに待ち時間を2倍にする
+	if err := rh.HandleRequest(ctx, popped, 1); err == nil || !strings.Contains(err.Error(), "retry 2/2 in 200ms") {
+		t.Errorf("Expected the second retry to wait 200ms, got %v", err)
+	}
+}
+
+func TestHandleRequestCancelledByLatency(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.RespondBody("https://example.com/slow", "text/plain", "slow")
+	gw.SetLatency(time.Minute)
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/slow"}
+	_, _ = repo.ReserveRequest(context.Background(), req)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rh.HandleRequest(ctx, req, 1); err != nil {
+		t.Fatalf("HandleRequest failed: %v", err)
+	}
+	if _, found, _ := repo.GetResponse(context.Background(), req.GenerateCacheKey()); found || repo.IsReserved(req.GenerateCacheKey()) {
+		t.Errorf("Expected nothing cached and the reservation removed, got found=%v", found)
+	}
+}
+
+func TestHandleRequestNegativeThenRefresh(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, time.Minute, []int{http.StatusNotFound}, 0, nil)
+
+	// レスポンスを決めていないURLは404になり、ネガティブキャッシュとして保存する
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/missing"}
+	_, _ = repo.ReserveRequest(context.Background(), req)
+	popAndHandle(t, repo, rh)
+	resp, found, _ := repo.GetResponse(context.Background(), req.GenerateCacheKey())
+	if !found || !resp.Negative || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected a negative cache, got found=%v resp=%+v", found, resp)
+	}
+
+	// ネガティブキャッシュの予約は取得し直す
+	gw.RespondBody(req.URL, "text/plain", "found")
+	_, _ = repo.ReserveRequest(context.Background(), req)
+	popAndHandle(t, repo, rh)
+	if resp, _, _ := repo.GetResponse(context.Background(), req.GenerateCacheKey()); resp == nil || resp.Negative || string(resp.Body) != "found" {
+		t.Errorf("Expected the negative cache to be replaced, got %+v", resp)
+	}
+	if requests := gw.Requests(); len(requests) != 2 {
+		t.Errorf("Expected 2 requests over the gateway, got %d", len(requests))
+	}
+}
+
+func TestHandleRequestTimeoutRequeues(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.Fail("https://example.com/lost", fmt.Errorf("%w: no response", gateway.ErrTimeout))
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/lost"}
+	if _, err := repo.ReserveRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt < defaultRetryPolicy.Attempts; attempt++ {
+		popped, err := repo.BLPopReservedRequest(context.Background(), time.Second)
+		if err != nil || popped == nil {
+			t.Fatalf("Expected attempt %d to be queued, got %v %v", attempt, popped, err)
+		}
+		if err := rh.HandleRequest(context.Background(), popped, 1); !errors.Is(err, gateway.ErrTimeout) {
+			t.Errorf("Expected the timeout to be reported, got %v", err)
+		}
+		// 予約は残り、同じページへのアクセスで二重に予約しない
+		if !repo.IsReserved(req.GenerateCacheKey()) {
+			t.Fatalf("Expected the reservation to be kept after attempt %d", attempt)
+		}
+		if queue, _ := repo.GetReservedRequests(context.Background()); len(queue) != 1 || queue[0].Attempts != attempt {
+			t.Fatalf("Expected the request to be requeued with %d attempts, got %+v", attempt, queue)
+		}
+	}
+
+	// 上限まで送り直しても届かない場合は予約をデッドレターに移す
+	popAndHandle(t, repo, rh)
+	if repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected the reservation to be removed after the last retry")
+	}
+	if deadLetters, _ := repo.ListDeadLetters(context.Background()); len(deadLetters) != 1 || !strings.Contains(deadLetters[0].LastError, "no response") {
+		t.Errorf("Expected the request to be dead-lettered, got %+v", deadLetters)
+	}
+	if len(gw.Requests()) != defaultRetryPolicy.Attempts {
+		t.Errorf("Expected %d attempts, got %d", defaultRetryPolicy.Attempts, len(gw.Requests()))
+	}
+}
+
+func TestHandleRequestCircuitOpenHoldsReservation(t *testing.T) {
+	defer func(wait time.Duration) { circuitOpenWait = wait }(circuitOpenWait)
+	circuitOpenWait = time.Millisecond
+
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.Fail("https://example.com/down", fmt.Errorf("%w (retry after later)", gateway.ErrCircuitOpen))
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/down", Attempts: 2}
+	if _, err := repo.ReserveRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	// 何度取り出しても、予約は送り直しの回数を変えずに残る
+	for i := 0; i < defaultRetryPolicy.Attempts+1; i++ {
+		popped, err := repo.BLPopReservedRequest(context.Background(), time.Second)
+		if err != nil || popped == nil {
+			t.Fatalf("Expected the reservation to be queued, got %v %v", popped, err)
+		}
+		if err := rh.HandleRequest(context.Background(), popped, 1); !errors.Is(err, gateway.ErrCircuitOpen) {
+			t.Errorf("Expected the open breaker to be reported, got %v", err)
+		}
+		if !repo.IsReserved(req.GenerateCacheKey()) {
+			t.Fatal("Expected the reservation to be kept while the breaker is open")
+		}
+		if queue, _ := repo.GetReservedRequests(context.Background()); len(queue) != 1 || queue[0].Attempts != 2 {
+			t.Fatalf("Expected the request to be requeued unchanged, got %+v", queue)
+		}
+	}
+}
+
+func TestHandleRequestInFlightLimitKeepsReservationQueued(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.SetLatency(time.Hour)
+	limited := gateway_impl.NewInFlightLimiter(gw, 1, nil)
+	rh := NewRequestHandler(repo, limited, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	// 1つ目のリクエストが枠を使ったまま、レスポンスを待つ
+	busy, stopBusy := context.WithCancel(context.Background())
+	defer stopBusy()
+	go func() {
+		_ = rh.HandleRequest(busy, &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/slow", RequestID: "slow"}, 1)
+	}()
+	for limited.Stats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/waiting", Attempts: 1, RequestID: "waiting"}
+	if _, err := repo.ReserveRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	popped, err := repo.BLPopReservedRequest(context.Background(), time.Second)
+	if err != nil || popped == nil {
+		t.Fatalf("Expected a reservation, got %v %v", popped, err)
+	}
+
+	// 空きを待っている間にWorkerを止める
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rh.HandleRequest(ctx, popped, 2) }()
+	for limited.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, gateway.ErrInFlightLimit) {
+		t.Fatalf("Expected ErrInFlightLimit, got %v", err)
+	}
+
+	if len(gw.Requests()) != 1 {
+		t.Errorf("Expected only the first request to be sent, got %d", len(gw.Requests()))
+	}
+	if queue, _ := repo.GetReservedRequests(context.Background()); len(queue) != 1 || queue[0].URL != req.URL || queue[0].Attempts != 1 {
+		t.Fatalf("Expected the reservation to be queued unchanged, got %+v", queue)
+	}
+	if _, found, _ := repo.GetSentRequest(context.Background(), "waiting"); found {
+		t.Error("Expected the unsent request to be removed from the journal")
+	}
+}
+
+func TestHandleRequestPriority(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.RespondBody("https://example.com/page", "text/plain", "page")
+	gw.RespondBody("https://example.com/next", "text/plain", "next")
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	// ブラウザの予約（取得し直しを含む）はhigh、事前取得はlowのレーンで送る
+	for _, req := range []*model.BpRequest{
+		{Method: http.MethodGet, URL: "https://example.com/page"},
+		{Method: http.MethodGet, URL: "https://example.com/next", Prefetch: true},
+	} {
+		if _, err := repo.ReserveRequest(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+		popAndHandle(t, repo, rh)
+	}
+
+	requests, options := gw.Requests(), gw.Options()
+	if len(options) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(options))
+	}
+	for i, want := range []gateway.Priority{gateway.PriorityHigh, gateway.PriorityLow} {
+		if options[i].Priority != want {
+			t.Errorf("Expected %s to be sent with %s priority, got %s", requests[i].URL, want, options[i].Priority)
+		}
+	}
+}
@@ -2,77 +2,255 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/notifier"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	gateway_impl "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
 )
 
+// defaultRetryPolicy SetRetryPolicyを呼ばない場合の、転送に失敗した予約の送り直し方（最初の転送を含め4回まで、すぐにキューに戻す）
+var defaultRetryPolicy = gateway_impl.RetryPolicy{Attempts: 4}
+
+// circuitOpenWait サーキットブレーカーが開いていて予約をキューに戻したあと、次の予約を取り出すまで待つ時間
+// （すぐに取り出すと、同じ予約を取り出しては戻すことを繰り返すため）
+var circuitOpenWait = 5 * time.Second
+
 type RequestHandler struct {
-	bprepo     repository.BpRepository
-	bpgateway  gateway.BpGateway
-	defaultTTL time.Duration
+	bprepo    repository.BpRepository
+	bpgateway gateway.BpGateway
+	// ttlPolicy 転送先がキャッシュの期間を指定せず、推定もできない場合のContent-Type・ドメインごとのTTL
+	ttlPolicy model.TTLPolicy
+	// minTTL・maxTTL 転送先のCache-Controlなどから決めたキャッシュの期間の下限と上限（maxTTLが0以下は上限なし）
+	minTTL time.Duration
+	maxTTL time.Duration
+	// negativeTTL・negativeStatuses ネガティブキャッシュとして短い期間だけ保存するエラーレスポンスのステータスコードとその期間（negativeTTLが0以下は保存しない）
+	negativeTTL      time.Duration
+	negativeStatuses []int
+	// assetLimit HTMLを保存したときに事前取得として予約する、HTMLが参照するリソースの数の上限（0以下は予約しない）
+	assetLimit int
+	notifier   notifier.CacheNotifier
+	// retry 転送に失敗した予約を送り直す回数（最初の転送を含む）と、キューに戻すまでの待ち時間
+	retry gateway_impl.RetryPolicy
 }
 
+// NewRequestHandler ttlPolicyは転送先がキャッシュの期間を指定せず、推定もできない場合のTTL（Content-Type・ドメインごと）
+// negativeStatusesのエラーレスポンス（404など）はnegativeTTLの間だけネガティブキャッシュとして保存する
+// HTMLを保存したときは、キャッシュのない同じホストのリソース（CSS・画像など）を最大assetLimit件まで事前取得として予約する
 func NewRequestHandler(
 	bprepo repository.BpRepository,
 	bpgateway gateway.BpGateway,
-	defaultTTL time.Duration,
+	ttlPolicy model.TTLPolicy,
+	minTTL time.Duration,
+	maxTTL time.Duration,
+	negativeTTL time.Duration,
+	negativeStatuses []int,
+	assetLimit int,
+	notifier notifier.CacheNotifier,
 ) *RequestHandler {
 	return &RequestHandler{
-		bprepo:     bprepo,
-		bpgateway:  bpgateway,
-		defaultTTL: defaultTTL,
+		bprepo:           bprepo,
+		bpgateway:        bpgateway,
+		ttlPolicy:        ttlPolicy,
+		minTTL:           minTTL,
+		maxTTL:           maxTTL,
+		negativeTTL:      negativeTTL,
+		negativeStatuses: negativeStatuses,
+		assetLimit:       assetLimit,
+		notifier:         notifier,
+		retry:            defaultRetryPolicy,
 	}
 }
 
+// SetRetryPolicy 転送に失敗した予約を、policy.Attempts回（最初の転送を含む）まで、policyの待ち時間を空けてキューに戻すようにする
+// 上限まで送っても失敗した予約は、最後のエラーとともにデッドレターに移す
+func (rh *RequestHandler) SetRetryPolicy(policy gateway_impl.RetryPolicy) {
+	rh.retry = policy
+}
+
 // HandleRequest 予約されたリクエストを処理してキャッシュに保存
 func (rh *RequestHandler) HandleRequest(ctx context.Context, req *model.BpRequest, workerID int) error {
-	log.Printf("[Worker %d] リクエスト処理開始: %s", workerID, req.URL)
+	log.Printf("[Worker %d] リクエスト処理開始: %s (RequestID: %s)", workerID, req.URL, req.RequestID)
 
 	// // レスポンスのキャッシュが既に存在しないかをチェックする
+	// 期限切れのキャッシュ（更新のための予約）、ネガティブキャッシュ、クライアントが取得し直しを求めた予約（Cache-Control: no-cacheなど）は取得し直す
 	cacheKey := req.GenerateCacheKey()
-	_, found, err := rh.bprepo.GetResponse(ctx, cacheKey)
+	cached, found, err := rh.bprepo.GetResponse(ctx, cacheKey)
 	if err != nil {
-		log.Printf("[Worker %d] キャッシュ確認中にエラーが発生しました (URL: %s): %v", workerID, req.URL, err)
+		log.Printf("[Worker %d] キャッシュ確認中にエラーが発生しました (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
 		// エラーがあっても実行を継続する
-	} else if found {
-		log.Printf("[Worker %d] 既にキャッシュが存在するため処理をスキップします (URL: %s)", workerID, req.URL)
+	} else if found && !cached.IsStale() && !cached.Negative && !req.WantsRefresh() {
+		log.Printf("[Worker %d] 既にキャッシュが存在するため処理をスキップします (URL: %s, RequestID: %s)", workerID, req.URL, req.RequestID)
 		// 予約は削除する
 		_ = rh._removeReservedRequest(ctx, req, workerID)
 		return nil
 	}
 
-	// Gatewayでリクエストを転送
-	resp, err := rh.bpgateway.ProxyRequest(ctx, req)
+	// Gatewayでリクエストを転送（事前取得・再検証は誰も待っていないため、ブラウザの予約より低い優先度のレーンで送る）
+	opts := gateway.ProxyOptions{Priority: gateway.PriorityHigh}
+	if req.Priority() != model.PriorityInteractive {
+		opts.Priority = gateway.PriorityLow
+	}
+	// 送る前に送信の記録に残し、レスポンスを処理する前にバックエンドが終了しても次の起動で予約と突き合わせられるようにする
+	sent := rh._journalSentRequest(ctx, req, workerID)
+	resp, err := rh.bpgateway.ProxyRequest(ctx, sent, opts)
+	if errors.Is(err, gateway.ErrInFlightLimit) {
+		// 同時に転送できる数の空きを待っている間に止めた場合は、送っていないため予約をそのままキューに戻す
+		return rh._returnUnsentRequest(ctx, req, sent, err, workerID)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// レスポンスを待っている間にWorkerを止めた場合は、予約と送信の記録を残して次の起動で引き継ぐ（RecoverSentRequests）
+		log.Printf("[Worker %d] 停止するため送信の記録を残します (URL: %s, RequestID: %s)", workerID, req.URL, sent.RequestID)
+		return fmt.Errorf("stopped while waiting for the response: %w", ctx.Err())
+	}
+	rh._completeSentRequest(ctx, sent, workerID)
 	if err != nil {
-		log.Printf("[Worker %d] リクエストの転送に失敗 (URL: %s): %v", workerID, req.URL, err)
+		// サーキットブレーカーが開いている間は転送を試していないため、予約を変えずに（送り直しの回数も数えずに）キューに戻す
+		if errors.Is(err, gateway.ErrCircuitOpen) {
+			return rh._holdReservedRequest(ctx, req, err, workerID)
+		}
 
-		// エラーが発生しても予約は削除（次回再試行）
-		return rh._removeReservedRequest(ctx, req, workerID)
-		// return nil
+		log.Printf("[Worker %d] リクエストの転送に失敗 (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
+
+		// ゲートウェイの失敗以外（Workerの期限切れなど）は予約を削除する（次のアクセスで予約し直す）
+		if !isGatewayFailure(err) {
+			return rh._removeReservedRequest(ctx, req, workerID)
+		}
+		// 上限までは予約を残したまま、間隔を空けてキューに戻して送り直す
+		// （応答が届かなかっただけの場合、遅れて届いたレスポンスはResponseWatcherがキャッシュに保存する）
+		if req.Attempts+1 < max(rh.retry.Attempts, 1) {
+			return rh._retryReservedRequest(ctx, req, err, workerID)
+		}
+		// 上限まで送っても失敗した予約（地上局で取得できないURLなど）は、キューを回り続けないようにデッドレターに移す
+		return rh._deadLetterReservedRequest(ctx, req, err, workerID)
+	}
+
+	// 404などのエラーレスポンスは短い期間だけネガティブキャッシュとして保存し、同じURLを何度も予約しないようにする
+	if resp.StatusCode != 200 && rh.isNegative(resp) {
+		resp.Negative = true
+		return rh._storeResponse(ctx, req, resp, rh.negativeTTL, workerID)
 	}
 
 	// 追加: ステータスコードが200以外（特にリダイレクトやエラー）はキャッシュしない
 	if resp.StatusCode != 200 {
-		log.Printf("[Worker %d] ステータスコードが200ではないためキャッシュしません (URL: %s, Status: %d)", workerID, req.URL, resp.StatusCode)
+		log.Printf("[Worker %d] ステータスコードが200ではないためキャッシュしません (URL: %s, Status: %d, RequestID: %s)", workerID, req.URL, resp.StatusCode, req.RequestID)
 		// 予約だけ削除して終了
 		_ = rh._removeReservedRequest(ctx, req, workerID)
 		return nil
 	}
 
-	// レスポンスをキャッシュに保存（URLベースの階層構造で保存）
-	cache_ttl := rh.defaultTTL // 設定値を使用
+	// 転送先のCache-Control・Expires・Last-Modifiedからキャッシュする期間を決める（no-store・privateは保存しない）
+	cache_ttl, ok := rh.cacheTTL(req, resp)
+	if !ok {
+		log.Printf("[Worker %d] 転送先がキャッシュを許可していないため保存しません (URL: %s, Cache-Control: %q, RequestID: %s)", workerID, req.URL, http.Header(resp.Headers).Get("Cache-Control"), req.RequestID)
+		_ = rh._removeReservedRequest(ctx, req, workerID)
+		return nil
+	}
+
+	if err := rh._storeResponse(ctx, req, resp, cache_ttl, workerID); err != nil {
+		return err
+	}
+
+	// HTMLが参照するリソースを予約しておき、ブラウザがページを表示するときにプレースホルダーばかりにならないようにする
+	rh._prefetchAssets(ctx, req, resp, workerID)
+	// 事前取得の予約は、HTMLのリンク先も深さの分だけ事前取得として予約する
+	rh._prefetchLinks(ctx, req, resp, workerID)
+	return nil
+}
+
+// _prefetchAssets 保存したHTMLが参照する同じホストのリソース（CSS・画像・スクリプトなど）のうち、キャッシュのないものを事前取得として予約する
+// 予約は同じキャッシュキーで1つにまとまるため、ブラウザが同じリソースを求めても重複しない（ブラウザからの予約が来れば先に処理される）
+// ヘッダーは付けない（earthから届いたリソースのレスポンスと同じキャッシュキーにする）
+func (rh *RequestHandler) _prefetchAssets(ctx context.Context, req *model.BpRequest, resp *model.BpResponse, workerID int) {
+	if rh.assetLimit <= 0 || !isHTML(resp) {
+		return
+	}
+
+	queued := 0
+	for _, asset := range model.AssetLinks(req.URL, resp.Body, rh.assetLimit) {
+		child := &model.BpRequest{Method: http.MethodGet, URL: asset, Prefetch: true}
+		cached, found, err := rh.bprepo.GetResponseStream(ctx, child.GenerateCacheKey())
+		if err != nil {
+			log.Printf("[Worker %d] リソースのキャッシュ確認に失敗 (URL: %s): %v", workerID, asset, err)
+			continue
+		}
+		if found {
+			cached.Close()
+			if !cached.IsStale() {
+				continue
+			}
+		}
+		reservation, err := rh.bprepo.ReserveRequest(ctx, child)
+		if err != nil {
+			log.Printf("[Worker %d] リソースの事前取得の予約に失敗 (URL: %s): %v", workerID, asset, err)
+			continue
+		}
+		if reservation.Queued {
+			queued++
+		}
+	}
+	if queued > 0 {
+		log.Printf("[Worker %d] HTMLが参照するリソースを事前取得として予約しました: %s (%d件)", workerID, req.URL, queued)
+	}
+}
+
+// _prefetchLinks 事前取得（PrefetchDepthが1以上）で取得したHTMLのリンク先を、深さを1つ減らして事前取得として予約する
+// 予約に失敗してもこのリクエストの処理は終わっているため、ログに残すだけにする
+func (rh *RequestHandler) _prefetchLinks(ctx context.Context, req *model.BpRequest, resp *model.BpResponse, workerID int) {
+	if !req.Prefetch || req.PrefetchDepth <= 0 {
+		return
+	}
+	if !isHTML(resp) {
+		return
+	}
+
+	queued := 0
+	for _, link := range model.PrefetchLinks(req.URL, resp.Body) {
+		child := &model.BpRequest{
+			Method:        http.MethodGet,
+			URL:           link,
+			Headers:       req.Headers,
+			Prefetch:      true,
+			PrefetchDepth: req.PrefetchDepth - 1,
+		}
+		reservation, err := rh.bprepo.ReserveRequest(ctx, child)
+		if err != nil {
+			log.Printf("[Worker %d] リンク先の事前取得の予約に失敗 (URL: %s): %v", workerID, link, err)
+			continue
+		}
+		if reservation.Queued {
+			queued++
+		}
+	}
+	log.Printf("[Worker %d] リンク先を事前取得として予約しました: %s (%d件, 残りの深さ: %d)", workerID, req.URL, queued, req.PrefetchDepth-1)
+}
+
+// _storeResponse レスポンスをキャッシュに保存し、待機しているクライアントに通知して予約を削除する
+func (rh *RequestHandler) _storeResponse(ctx context.Context, req *model.BpRequest, resp *model.BpResponse, cache_ttl time.Duration, workerID int) error {
+	cacheKey := req.GenerateCacheKey()
 
 	// SetResponseWithURLを使用してURLベースの階層構造でキャッシュを保存
-	err = rh.bprepo.SetResponseWithURL(ctx, req, resp, cache_ttl)
-	if err != nil {
-		log.Printf("[Worker %d] キャッシュの保存に失敗 (URL: %s): %v", workerID, req.URL, err)
+	err := rh.bprepo.SetResponseWithURL(ctx, req, resp, cache_ttl)
+	if errors.Is(err, model.ErrCacheTooLarge) {
+		log.Printf("[Worker %d] 大きさの上限を超えるためキャッシュしません (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
+	} else if err != nil {
+		log.Printf("[Worker %d] キャッシュの保存に失敗 (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
 
 		// キャッシュ保存に失敗しても予約は削除
 		// return rh._removeReservedRequest(ctx, req, workerID)
+	} else if rh.notifier != nil {
+		// /system/notifyで待機しているクライアントに通知
+		rh.notifier.Publish(cacheKey)
 	}
 
 	// 予約を削除
@@ -81,21 +259,145 @@ func (rh *RequestHandler) HandleRequest(ctx context.Context, req *model.BpReques
 		return err
 	}
 
-	log.Printf("[Worker %d] リクエスト処理完了: %s", workerID, req.URL)
+	log.Printf("[Worker %d] リクエスト処理完了: %s (RequestID: %s)", workerID, req.URL, req.RequestID)
+
+	return nil
+}
+
+// isNegative エラーレスポンスをネガティブキャッシュとして保存するか
+// negativeStatusesに含まれるステータスコードで、転送先がキャッシュを禁止していない（no-store・privateでない）場合に保存する
+func (rh *RequestHandler) isNegative(resp *model.BpResponse) bool {
+	if rh.negativeTTL <= 0 || !slices.Contains(rh.negativeStatuses, resp.StatusCode) {
+		return false
+	}
+	return resp.Freshness(time.Now()).Storable
+}
+
+// cacheTTL 転送先のレスポンスからキャッシュする期間を決める（保存しない場合はfalse）
+// 転送先が指定した期間（s-maxage・max-age・Expires）やLast-Modifiedから推定した期間はminTTL〜maxTTLに収め、
+// どちらもない場合はttlPolicyでContent-Type・ドメインから決める。no-cacheや期限切れのレスポンスもminTTLの間は新しいとみなす（DTNでは使うたびに取得し直せないため）
+func (rh *RequestHandler) cacheTTL(req *model.BpRequest, resp *model.BpResponse) (time.Duration, bool) {
+	freshness := resp.Freshness(time.Now())
+	if !freshness.Storable {
+		return 0, false
+	}
+	if freshness.Source == model.FreshnessNone {
+		ttl := rh.ttlPolicy.Resolve(req.URL, resp.ResponseContentType())
+		return ttl, ttl > 0
+	}
+
+	ttl := freshness.Lifetime
+	if rh.maxTTL > 0 {
+		ttl = min(ttl, rh.maxTTL)
+	}
+	ttl = max(ttl, rh.minTTL)
+	// TTLが0のメタデータはRedisで期限なしになるため保存しない
+	return ttl, ttl > 0
+}
 
+// isHTML レスポンスがHTMLか（リンクを取り出す対象）
+func isHTML(resp *model.BpResponse) bool {
+	return strings.HasPrefix(strings.ToLower(resp.ResponseContentType()), "text/html")
+}
+
+// isGatewayFailure errがゲートウェイの転送の失敗（リンク断・送信の失敗・タイムアウト・壊れたレスポンス）か
+func isGatewayFailure(err error) bool {
+	for _, target := range []error{gateway.ErrLinkDown, gateway.ErrSendFailed, gateway.ErrTimeout, gateway.ErrResponseCorrupt} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// _retryReservedRequest 転送に失敗した予約を、送り直した回数を増やして予約済みのまま待ち時間の後にキューの最後に戻す（戻せない場合は予約を削除する）
+func (rh *RequestHandler) _retryReservedRequest(ctx context.Context, req *model.BpRequest, cause error, workerID int) error {
+	retry := *req
+	retry.Attempts++
+	delay := rh.retry.Delay(retry.Attempts, rand.Float64())
+	if err := rh.bprepo.RetryReservedRequest(ctx, &retry, delay); err != nil {
+		log.Printf("[Worker %d] 予約をキューに戻せません (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
+		return rh._removeReservedRequest(ctx, req, workerID)
+	}
+	log.Printf("[Worker %d] 転送に失敗したため%v後に予約をキューに戻します (URL: %s, RequestID: %s, 送り直し%d/%d回目)", workerID, delay, req.URL, req.RequestID, retry.Attempts, rh.retry.Attempts-1)
+	return fmt.Errorf("requeued after failure (retry %d/%d in %v): %w", retry.Attempts, rh.retry.Attempts-1, delay, cause)
+}
+
+// _deadLetterReservedRequest 送り直しの上限に達した予約を、最後のエラーとともにデッドレターに移す（移せない場合は予約を削除する）
+func (rh *RequestHandler) _deadLetterReservedRequest(ctx context.Context, req *model.BpRequest, cause error, workerID int) error {
+	_ = rh.bprepo.RemovePendingRequest(ctx, req.URL)
+	if err := rh.bprepo.DeadLetterReservedRequest(ctx, req, cause.Error()); err != nil {
+		log.Printf("[Worker %d] 予約をデッドレターに移せません (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
+		return rh._removeReservedRequest(ctx, req, workerID)
+	}
+	log.Printf("[Worker %d] %d回転送しても失敗したため、予約をデッドレターに移しました (URL: %s, RequestID: %s): %v", workerID, req.Attempts+1, req.URL, req.RequestID, cause)
 	return nil
 }
 
+// _holdReservedRequest リンクが使えない間、予約をそのままキューの最後に戻し、circuitOpenWaitだけ待ってから返す
+func (rh *RequestHandler) _holdReservedRequest(ctx context.Context, req *model.BpRequest, cause error, workerID int) error {
+	if err := rh.bprepo.RequeueReservedRequest(ctx, req); err != nil {
+		log.Printf("[Worker %d] 予約をキューに戻せません (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
+		return rh._removeReservedRequest(ctx, req, workerID)
+	}
+	log.Printf("[Worker %d] リンクが使えないため予約をそのままキューに戻しました (URL: %s, RequestID: %s)", workerID, req.URL, req.RequestID)
+
+	timer := time.NewTimer(circuitOpenWait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return fmt.Errorf("held while the link is down: %w", cause)
+}
+
+// _returnUnsentRequest 送らなかった予約を、送信の記録から削除して変えずに（送り直しの回数も数えずに）キューに戻す
+// Workerを止めるときにも呼ばれるため、ctxが終わっていても戻す
+func (rh *RequestHandler) _returnUnsentRequest(ctx context.Context, req, sent *model.BpRequest, cause error, workerID int) error {
+	ctx = context.WithoutCancel(ctx)
+	rh._completeSentRequest(ctx, sent, workerID)
+	if err := rh.bprepo.RequeueReservedRequest(ctx, req); err != nil {
+		log.Printf("[Worker %d] 予約をキューに戻せません (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
+		return rh._removeReservedRequest(ctx, req, workerID)
+	}
+	log.Printf("[Worker %d] 転送の空きを待つ間に止めたため予約をそのままキューに戻しました (URL: %s, RequestID: %s)", workerID, req.URL, req.RequestID)
+	return fmt.Errorf("returned to the queue before sending: %w", cause)
+}
+
+// _journalSentRequest reqを送信の記録に追加し、DTNへ送るリクエストを返す
+// RequestIDのない予約（事前取得など）は、レスポンスと突き合わせられるよう生成したRequestIDを付けたコピーを送る
+// 記録に失敗しても、リクエストは送る（再起動しなければ記録は使わないため）
+func (rh *RequestHandler) _journalSentRequest(ctx context.Context, req *model.BpRequest, workerID int) *model.BpRequest {
+	sent := req
+	if sent.RequestID == "" {
+		copied := *req
+		copied.RequestID = requestid.New()
+		sent = &copied
+	}
+	entry := &model.SentRequest{RequestID: sent.RequestID, Request: req, SentAt: time.Now().UTC()}
+	if err := rh.bprepo.JournalSentRequest(ctx, entry); err != nil {
+		log.Printf("[Worker %d] 送信の記録に失敗 (URL: %s, RequestID: %s): %v", workerID, req.URL, sent.RequestID, err)
+	}
+	return sent
+}
+
+// _completeSentRequest レスポンスを受け取った（または送れなかった）リクエストを送信の記録から削除する
+func (rh *RequestHandler) _completeSentRequest(ctx context.Context, sent *model.BpRequest, workerID int) {
+	if err := rh.bprepo.CompleteSentRequest(ctx, sent.RequestID); err != nil {
+		log.Printf("[Worker %d] 送信の記録の削除に失敗 (URL: %s, RequestID: %s): %v", workerID, sent.URL, sent.RequestID, err)
+	}
+}
+
 func (rh *RequestHandler) _removeReservedRequest(ctx context.Context, req *model.BpRequest, workerID int) error {
 	// Pending状態を解除
 	_ = rh.bprepo.RemovePendingRequest(ctx, req.URL)
 
 	err := rh.bprepo.RemoveReservedRequest(ctx, req)
 	if err != nil {
-		log.Printf("[Worker %d] 予約の削除に失敗 (URL: %s): %v", workerID, req.URL, err)
+		log.Printf("[Worker %d] 予約の削除に失敗 (URL: %s, RequestID: %s): %v", workerID, req.URL, req.RequestID, err)
 	}
 
-	log.Printf("[Worker %d] リクエストは削除されました (URL: %s)", workerID, req.URL)
+	log.Printf("[Worker %d] リクエストは削除されました (URL: %s, RequestID: %s)", workerID, req.URL, req.RequestID)
 
 	return err
 }
@@ -0,0 +1,415 @@
+// request_handler_test.go - 予約したリクエストのレスポンスを転送先のCache-Controlに従ってキャッシュすることのテスト
+package worker
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+)
+
+// ttlRepository キャッシュがなく、保存したTTLを記録するリポジトリ
+type ttlRepository struct {
+	repository.BpRepository
+	stored bool
+	ttl    time.Duration
+	resp   *model.BpResponse
+}
+
+func (r *ttlRepository) GetResponse(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	return nil, false, nil
+}
+
+func (r *ttlRepository) SetResponseWithURL(ctx context.Context, req *model.BpRequest, resp *model.BpResponse, ttl time.Duration) error {
+	r.stored = true
+	r.ttl = ttl
+	r.resp = resp
+	return nil
+}
+
+func (r *ttlRepository) RemovePendingRequest(ctx context.Context, url string) error { return nil }
+
+func (r *ttlRepository) RemoveReservedRequest(ctx context.Context, req *model.BpRequest) error {
+	return nil
+}
+
+func (r *ttlRepository) JournalSentRequest(ctx context.Context, sent *model.SentRequest) error {
+	return nil
+}
+
+func (r *ttlRepository) CompleteSentRequest(ctx context.Context, requestID string) error { return nil }
+
+// headerGateway headerを付けたstatus（0の場合は200）のレスポンスを返すゲートウェイ
+type headerGateway struct {
+	status int
+	header http.Header
+}
+
+func (g headerGateway) ProxyRequest(ctx context.Context, req *model.BpRequest, opts gateway.ProxyOptions) (*model.BpResponse, error) {
+	status := g.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &model.BpResponse{StatusCode: status, Headers: g.header, Body: []byte("ok")}, nil
+}
+
+func (g headerGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse { return nil }
+
+func TestHandleRequestCacheControl(t *testing.T) {
+	const (
+		defaultTTL = 24 * time.Hour
+		minTTL     = time.Minute
+		maxTTL     = 7 * 24 * time.Hour
+	)
+	lastModified := time.Now().Add(-100 * time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		stored bool
+		ttl    time.Duration
+	}{
+		{"no directives", nil, true, defaultTTL},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, false, 0},
+		{"private", http.Header{"Cache-Control": {"private, max-age=600"}}, false, 0},
+		{"max-age", http.Header{"Cache-Control": {"max-age=3600"}}, true, time.Hour},
+		{"s-maxage", http.Header{"Cache-Control": {"max-age=60, s-maxage=7200"}}, true, 2 * time.Hour},
+		// 転送先が示した期間は下限と上限に収める
+		{"max-age below min", http.Header{"Cache-Control": {"max-age=5"}}, true, minTTL},
+		{"max-age above max", http.Header{"Cache-Control": {"max-age=31536000"}}, true, maxTTL},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, true, minTTL},
+		{"expired", http.Header{"Expires": {"0"}}, true, minTTL},
+		// Last-Modifiedから100時間経っているため、その10%
+		{"heuristic", http.Header{"Last-Modified": {lastModified}, "Date": {time.Now().Format(http.TimeFormat)}}, true, 10 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &ttlRepository{}
+			rh := NewRequestHandler(repo, headerGateway{header: tt.header}, model.TTLPolicy{Default: defaultTTL}, minTTL, maxTTL, 0, nil, 0, nil)
+			req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page.html"}
+			if err := rh.HandleRequest(context.Background(), req, 1); err != nil {
+				t.Fatalf("HandleRequest failed: %v", err)
+			}
+			if repo.stored != tt.stored {
+				t.Fatalf("Expected stored=%v, got %v", tt.stored, repo.stored)
+			}
+			// DateとLast-Modifiedの秒単位の丸めの分だけずれることがある
+			if diff := repo.ttl - tt.ttl; diff < -time.Second || diff > time.Second {
+				t.Errorf("Expected TTL %v, got %v", tt.ttl, repo.ttl)
+			}
+		})
+	}
+}
+
+func TestCacheTTLWithoutMinimum(t *testing.T) {
+	// 下限がない場合、すぐに期限切れになるレスポンスは保存しない（Redisでは0のTTLが期限なしになる）
+	rh := NewRequestHandler(nil, nil, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+	if _, ok := rh.cacheTTL(&model.BpRequest{URL: "https://example.com/"}, &model.BpResponse{Headers: map[string][]string{"Cache-Control": {"max-age=0"}}}); ok {
+		t.Error("Expected a zero TTL not to be stored")
+	}
+	if ttl, ok := rh.cacheTTL(&model.BpRequest{URL: "https://example.com/"}, &model.BpResponse{Headers: map[string][]string{"Cache-Control": {"max-age=31536000"}}}); !ok || ttl != 365*24*time.Hour {
+		t.Errorf("Expected no upper bound, got %v", ttl)
+	}
+}
+
+func TestHandleRequestNegativeCache(t *testing.T) {
+	const negativeTTL = 5 * time.Minute
+	statuses := []int{http.StatusNotFound, http.StatusGone, http.StatusServiceUnavailable}
+	tests := []struct {
+		name        string
+		status      int
+		header      http.Header
+		negativeTTL time.Duration
+		stored      bool
+	}{
+		{"not found", http.StatusNotFound, nil, negativeTTL, true},
+		// 転送先のmax-ageではなくネガティブキャッシュの期間を使う
+		{"gone with max-age", http.StatusGone, http.Header{"Cache-Control": {"max-age=86400"}}, negativeTTL, true},
+		{"service unavailable", http.StatusServiceUnavailable, nil, negativeTTL, true},
+		{"not listed", http.StatusInternalServerError, nil, negativeTTL, false},
+		{"redirect", http.StatusFound, nil, negativeTTL, false},
+		{"no-store", http.StatusNotFound, http.Header{"Cache-Control": {"no-store"}}, negativeTTL, false},
+		{"disabled", http.StatusNotFound, nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &ttlRepository{}
+			rh := NewRequestHandler(repo, headerGateway{status: tt.status, header: tt.header}, model.TTLPolicy{Default: time.Hour}, time.Minute, 0, tt.negativeTTL, statuses, 0, nil)
+			req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/missing.html"}
+			if err := rh.HandleRequest(context.Background(), req, 1); err != nil {
+				t.Fatalf("HandleRequest failed: %v", err)
+			}
+			if repo.stored != tt.stored {
+				t.Fatalf("Expected stored=%v, got %v", tt.stored, repo.stored)
+			}
+			if !tt.stored {
+				return
+			}
+			if repo.ttl != negativeTTL {
+				t.Errorf("Expected the negative TTL %v, got %v", negativeTTL, repo.ttl)
+			}
+			if !repo.resp.Negative || repo.resp.StatusCode != tt.status {
+				t.Errorf("Expected a negative %d, got negative=%v status=%d", tt.status, repo.resp.Negative, repo.resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandleRequestTTLPolicy(t *testing.T) {
+	policy := model.TTLPolicy{
+		Default: 24 * time.Hour,
+		Rules: []model.TTLRule{
+			{ContentType: "text/html", TTL: time.Hour},
+			{ContentType: "image/", TTL: 7 * 24 * time.Hour},
+			{ContentType: "font/", TTL: 30 * 24 * time.Hour},
+			{Domain: "*.news.example", ContentType: "text/html", TTL: 10 * time.Minute},
+		},
+	}
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		ttl    time.Duration
+	}{
+		{"html", "https://example.com/index.html", http.Header{"Content-Type": {"text/html; charset=utf-8"}}, time.Hour},
+		{"image", "https://example.com/logo.png", http.Header{"Content-Type": {"image/png"}}, 7 * 24 * time.Hour},
+		{"font", "https://example.com/a.woff2", http.Header{"Content-Type": {"font/woff2"}}, 30 * 24 * time.Hour},
+		{"unlisted type", "https://example.com/data.json", http.Header{"Content-Type": {"application/json"}}, 24 * time.Hour},
+		{"no content type", "https://example.com/blob", nil, 24 * time.Hour},
+		{"domain override", "https://www.news.example/top.html", http.Header{"Content-Type": {"text/html"}}, 10 * time.Minute},
+		{"domain override other type", "https://www.news.example/photo.jpg", http.Header{"Content-Type": {"image/jpeg"}}, 7 * 24 * time.Hour},
+		// 転送先が期間を示した場合はポリシーを使わない
+		{"explicit max-age", "https://example.com/logo.png", http.Header{"Content-Type": {"image/png"}, "Cache-Control": {"max-age=600"}}, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &ttlRepository{}
+			rh := NewRequestHandler(repo, headerGateway{header: tt.header}, policy, time.Minute, 0, 0, nil, 0, nil)
+			req := &model.BpRequest{Method: http.MethodGet, URL: tt.url}
+			if err := rh.HandleRequest(context.Background(), req, 1); err != nil {
+				t.Fatalf("HandleRequest failed: %v", err)
+			}
+			if !repo.stored {
+				t.Fatal("Expected the response to be stored")
+			}
+			if repo.ttl != tt.ttl {
+				t.Errorf("Expected TTL %v, got %v", tt.ttl, repo.ttl)
+			}
+		})
+	}
+}
+
+// freshRepository 有効なキャッシュを返し、保存と予約の削除を記録するリポジトリ
+type freshRepository struct {
+	ttlRepository
+	removed bool
+}
+
+func (r *freshRepository) GetResponse(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	return &model.BpResponse{StatusCode: http.StatusOK, Body: []byte("old"), ExpiresAt: time.Now().Add(time.Hour)}, true, nil
+}
+
+func (r *freshRepository) RemoveReservedRequest(ctx context.Context, req *model.BpRequest) error {
+	r.removed = true
+	return nil
+}
+
+func TestHandleRequestClientRefresh(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		stored bool
+	}{
+		// 有効なキャッシュがある予約は転送しない
+		{"fresh cache", nil, false},
+		// クライアントが取得し直しを求めた予約は有効なキャッシュがあっても取得し直す
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, true},
+		{"pragma", http.Header{"Pragma": {"no-cache"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &freshRepository{}
+			rh := NewRequestHandler(repo, headerGateway{}, model.TTLPolicy{Default: time.Hour}, time.Minute, 0, 0, nil, 0, nil)
+			req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page.html", Headers: tt.header}
+			if err := rh.HandleRequest(context.Background(), req, 1); err != nil {
+				t.Fatalf("HandleRequest failed: %v", err)
+			}
+			if repo.stored != tt.stored {
+				t.Errorf("Expected stored=%v, got %v", tt.stored, repo.stored)
+			}
+			if !repo.removed {
+				t.Error("Expected the reservation to be removed")
+			}
+		})
+	}
+}
+
+// prefetchRepository 保存と予約を記録するリポジトリ（cachedのURLだけキャッシュがあり、同じキャッシュキーは一度だけ予約する）
+type prefetchRepository struct {
+	ttlRepository
+	cached   map[string]*model.BpResponse
+	reserved []*model.BpRequest
+}
+
+func (r *prefetchRepository) GetResponseStream(ctx context.Context, key string) (*model.BpResponse, bool, error) {
+	for url, resp := range r.cached {
+		if (&model.BpRequest{Method: http.MethodGet, URL: url}).GenerateCacheKey() == key {
+			return resp, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (r *prefetchRepository) ReserveRequest(ctx context.Context, req *model.BpRequest) (model.Reservation, error) {
+	for _, reserved := range r.reserved {
+		if reserved.GenerateCacheKey() == req.GenerateCacheKey() {
+			return model.Reservation{ReservedAt: reserved.ReservedAt}, nil
+		}
+	}
+	r.reserved = append(r.reserved, req)
+	return model.Reservation{Queued: true, ReservedAt: time.Now()}, nil
+}
+
+// htmlGateway bodyをHTMLとして返すゲートウェイ
+type htmlGateway struct {
+	body string
+}
+
+func (g htmlGateway) ProxyRequest(ctx context.Context, req *model.BpRequest, opts gateway.ProxyOptions) (*model.BpResponse, error) {
+	return &model.BpResponse{StatusCode: http.StatusOK, ContentType: "text/html; charset=utf-8", Body: []byte(g.body)}, nil
+}
+
+func (g htmlGateway) GetUnsolicitedResponseCh() <-chan *model.BpResponse { return nil }
+
+func TestHandleRequestPrefetchLinks(t *testing.T) {
+	const page = `<a href="/news/1">1</a><a href="https://other.example/">other</a><img src="/logo.png">`
+	header := map[string][]string{"Accept-Language": {"ja"}}
+	tests := []struct {
+		name     string
+		req      *model.BpRequest
+		reserved int
+	}{
+		{"browser request", &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/"}, 0},
+		{"prefetch without depth", &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/", Prefetch: true}, 0},
+		{"prefetch with depth", &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/", Headers: header, Prefetch: true, PrefetchDepth: 2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &prefetchRepository{}
+			rh := NewRequestHandler(repo, htmlGateway{body: page}, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+			if err := rh.HandleRequest(context.Background(), tt.req, 1); err != nil {
+				t.Fatalf("HandleRequest failed: %v", err)
+			}
+			if !repo.stored {
+				t.Fatal("Expected the page to be stored")
+			}
+			if len(repo.reserved) != tt.reserved {
+				t.Fatalf("Expected %d links to be reserved, got %d", tt.reserved, len(repo.reserved))
+			}
+			// 同じホストのリンクだけを、深さを1つ減らして事前取得として予約する
+			for i, want := range []string{"https://example.com/news/1", "https://example.com/logo.png"}[:tt.reserved] {
+				got := repo.reserved[i]
+				if got.URL != want || !got.Prefetch || got.PrefetchDepth != tt.req.PrefetchDepth-1 {
+					t.Errorf("Expected %s with depth %d, got %s prefetch=%v depth=%d", want, tt.req.PrefetchDepth-1, got.URL, got.Prefetch, got.PrefetchDepth)
+				}
+				if got.Headers["Accept-Language"][0] != "ja" {
+					t.Errorf("Expected the link to keep the cache key headers, got %v", got.Headers)
+				}
+			}
+		})
+	}
+}
+
+// articleFixture CSS・画像・スクリプトを参照する記事のHTML
+const articleFixture = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Orbital news</title>
+  <link rel="stylesheet" href="/static/site.css">
+  <link rel="icon" href="/favicon.ico">
+  <link rel="canonical" href="https://news.example/articles/42">
+  <script src="/static/app.js" defer></script>
+  <script src="https://cdn.other.example/analytics.js"></script>
+</head>
+<body>
+  <img src="images/hero.jpg" alt="hero">
+  <img src="/images/author.png" alt="author">
+  <img src="images/hero.jpg" alt="hero again">
+  <a href="/articles/43">next</a>
+  <img src="https://images.other.example/ad.gif">
+</body>
+</html>`
+
+func TestHandleRequestPrefetchAssets(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		cached map[string]*model.BpResponse
+		want   []string
+	}{
+		{"all assets", 10, nil, []string{
+			"https://news.example/static/site.css",
+			"https://news.example/favicon.ico",
+			"https://news.example/static/app.js",
+			"https://news.example/articles/images/hero.jpg",
+			"https://news.example/images/author.png",
+		}},
+		{"per-page limit", 2, nil, []string{
+			"https://news.example/static/site.css",
+			"https://news.example/favicon.ico",
+		}},
+		// 有効なキャッシュがあるリソースは予約しない（期限切れは予約する）
+		{"skip cached", 10, map[string]*model.BpResponse{
+			"https://news.example/static/site.css":   {StatusCode: http.StatusOK, ExpiresAt: time.Now().Add(time.Hour)},
+			"https://news.example/favicon.ico":       {StatusCode: http.StatusOK, ExpiresAt: time.Now().Add(-time.Minute)},
+			"https://news.example/images/author.png": {StatusCode: http.StatusOK, ExpiresAt: time.Now().Add(time.Hour)},
+		}, []string{
+			"https://news.example/favicon.ico",
+			"https://news.example/static/app.js",
+			"https://news.example/articles/images/hero.jpg",
+		}},
+		{"disabled", 0, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &prefetchRepository{cached: tt.cached}
+			rh := NewRequestHandler(repo, htmlGateway{body: articleFixture}, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, tt.limit, nil)
+			req := &model.BpRequest{Method: http.MethodGet, URL: "https://news.example/articles/42", Headers: map[string][]string{"Accept": {"text/html"}}}
+			if err := rh.HandleRequest(context.Background(), req, 1); err != nil {
+				t.Fatalf("HandleRequest failed: %v", err)
+			}
+			if !repo.stored {
+				t.Fatal("Expected the page to be stored")
+			}
+
+			var got []string
+			for _, reserved := range repo.reserved {
+				got = append(got, reserved.URL)
+				// リソースは低優先度で、earthから届くリソースと同じくヘッダーなしのキャッシュキーで予約する
+				if !reserved.Prefetch || reserved.PrefetchDepth != 0 || len(reserved.Headers) != 0 {
+					t.Errorf("Expected %s to be a header-less prefetch, got prefetch=%v depth=%d headers=%v", reserved.URL, reserved.Prefetch, reserved.PrefetchDepth, reserved.Headers)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected reservations\n%v\ngot\n%v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleRequestPrefetchAssetsSkipsNonHTML(t *testing.T) {
+	repo := &prefetchRepository{}
+	gw := headerGateway{header: http.Header{"Content-Type": {"text/plain"}}}
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 10, nil)
+	if err := rh.HandleRequest(context.Background(), &model.BpRequest{Method: http.MethodGet, URL: "https://news.example/robots.txt"}, 1); err != nil {
+		t.Fatalf("HandleRequest failed: %v", err)
+	}
+	if len(repo.reserved) != 0 {
+		t.Errorf("Expected no reservations for a non-HTML response, got %d", len(repo.reserved))
+	}
+}
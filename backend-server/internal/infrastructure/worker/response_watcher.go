@@ -3,26 +3,40 @@ package worker
 import (
 	"context"
 	"log"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/notifier"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 )
 
 type ResponseWatcher struct {
 	bpgateway gateway.BpGateway
 	bprepo    repository.BpRepository
+	// ttlPolicy 待っているリクエストのないレスポンスをキャッシュする期間（Content-Type・ドメインごと）
+	ttlPolicy model.TTLPolicy
+	notifier  notifier.CacheNotifier
+	metrics   *metrics.Metrics
 }
 
+// NewResponseWatcher ttlPolicyはRequestHandlerと同じ、Content-Type・ドメインごとのTTL
 func NewResponseWatcher(
 	bpgateway gateway.BpGateway,
 	bprepo repository.BpRepository,
+	ttlPolicy model.TTLPolicy,
+	notifier notifier.CacheNotifier,
+	metrics *metrics.Metrics,
 ) *ResponseWatcher {
 	return &ResponseWatcher{
 		bpgateway: bpgateway,
 		bprepo:    bprepo,
+		ttlPolicy: ttlPolicy,
+		notifier:  notifier,
+		metrics:   metrics,
 	}
 }
 
@@ -46,50 +60,116 @@ func (rw *ResponseWatcher) Start(ctx context.Context) {
 	}
 }
 
+// handleResponse 地上局が事前に取得したリンクや、タイムアウト後に届いたレスポンスをキャッシュに保存する
+// X-Original-URLからGETのリクエストを組み立て直して保存し、予約キューは使わない
 func (rw *ResponseWatcher) handleResponse(ctx context.Context, resp *model.BpResponse) {
-	// X-Original-URL ヘッダーからURLを取得
-	urls, ok := resp.Headers["X-Original-URL"]
-	if !ok || len(urls) == 0 {
+	if rw.handleSentResponse(ctx, resp) {
+		return
+	}
+
+	url := originalURL(resp)
+	if url == "" {
 		log.Printf("[ResponseWatcher] X-Original-URL ヘッダーが見つかりません (Status: %d)", resp.StatusCode)
+		rw.metrics.IncUnsolicitedResponse("skipped")
 		return
 	}
-	url := urls[0]
 
 	// エラーレスポンスはキャッシュしない
-	if resp.StatusCode != 200 {
+	if resp.StatusCode != http.StatusOK {
 		log.Printf("[ResponseWatcher] エラーレスポンスのためキャッシュしません (URL: %s, Status: %d)", url, resp.StatusCode)
 		// Pending状態だけ解除しておく
 		_ = rw.bprepo.RemovePendingRequest(ctx, url)
+		rw.metrics.IncUnsolicitedResponse("skipped")
 		return
 	}
 
 	log.Printf("[ResponseWatcher] Unsolicited Responseを受信しました: %s", url)
 
-	// キャッシュに保存
 	// Requestオブジェクトを再構築（キャッシュパス生成のため）
-	req := &model.BpRequest{
-		URL:    url,
-		Method: "GET", // 仮定
-	}
-
-	// URLが http/https で始まっていない場合は補完（念のため）
-	if !strings.HasPrefix(url, "http") {
-		// ログだけ出してそのまま処理（GenerateCachePathInfoでエラーになるかも）
-		log.Printf("[ResponseWatcher] URLの形式が不正です: %s", url)
+	req := &model.BpRequest{URL: url, Method: http.MethodGet}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") || !req.IsCacheable() {
+		log.Printf("[ResponseWatcher] キャッシュできないURLです: %s", url)
+		rw.metrics.IncUnsolicitedResponse("skipped")
+		return
 	}
 
 	// キャッシュ保存（内部でRemovePendingRequestも呼ばれる）
-	// TTLはデフォルト値を使用したいが、ここではハードコードするか、設定から渡す必要がある
-	// 簡易的に24時間とする（またはConfigから渡すように修正する）
-	// TODO: TTLをConfigから注入する
-	ttl := 24 * 60 * 60 * time.Second // 24h
+	ttl := rw.ttlPolicy.Resolve(url, resp.ResponseContentType())
 
 	err := rw.bprepo.SetResponseWithURL(ctx, req, resp, ttl)
 	if err != nil {
 		log.Printf("[ResponseWatcher] キャッシュ保存エラー (URL: %s): %v", url, err)
 		// 失敗してもPendingは解除する（SetResponseWithURL内で呼ばれているはずだが、念のため）
 		_ = rw.bprepo.RemovePendingRequest(ctx, url)
-	} else {
-		log.Printf("[ResponseWatcher] キャッシュを保存しました (URL: %s)", url)
+		rw.metrics.IncUnsolicitedResponse("error")
+		return
+	}
+	log.Printf("[ResponseWatcher] キャッシュを保存しました (URL: %s)", url)
+	rw.metrics.IncUnsolicitedResponse("stored")
+	if rw.notifier != nil {
+		rw.notifier.Publish(req.GenerateCacheKey())
+	}
+}
+
+// handleSentResponse 送信の記録にあるリクエスト（前回の起動で送ったまま終了したものなど）のレスポンスを、送った予約のキャッシュキーで保存し、
+// 予約と記録を削除する。記録がない場合や、再帰的に取得したリンクのレスポンス（X-Original-URLが送ったURLと違う）の場合はfalseを返す
+func (rw *ResponseWatcher) handleSentResponse(ctx context.Context, resp *model.BpResponse) bool {
+	if resp.RequestID == "" {
+		return false
+	}
+	sent, found, err := rw.bprepo.GetSentRequest(ctx, resp.RequestID)
+	if err != nil {
+		log.Printf("[ResponseWatcher] 送信の記録を取得できません (RequestID: %s): %v", resp.RequestID, err)
+		return false
+	}
+	if !found {
+		return false
+	}
+	req := sent.Request
+	if url := originalURL(resp); url != "" && url != req.URL {
+		return false
+	}
+
+	log.Printf("[ResponseWatcher] 送信の記録にあるリクエストのレスポンスを受信しました: %s (RequestID: %s, 送信から%v)", req.URL, resp.RequestID, sent.Age(time.Now()).Round(time.Second))
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		log.Printf("[ResponseWatcher] エラーレスポンスのためキャッシュしません (URL: %s, Status: %d)", req.URL, resp.StatusCode)
+		rw.metrics.IncUnsolicitedResponse("skipped")
+	case !req.IsCacheable():
+		log.Printf("[ResponseWatcher] キャッシュできないリクエストです: %s %s", req.Method, req.URL)
+		rw.metrics.IncUnsolicitedResponse("skipped")
+	default:
+		ttl := rw.ttlPolicy.Resolve(req.URL, resp.ResponseContentType())
+		if err := rw.bprepo.SetResponseWithURL(ctx, req, resp, ttl); err != nil {
+			log.Printf("[ResponseWatcher] キャッシュ保存エラー (URL: %s): %v", req.URL, err)
+			rw.metrics.IncUnsolicitedResponse("error")
+			break
+		}
+		log.Printf("[ResponseWatcher] キャッシュを保存しました (URL: %s)", req.URL)
+		rw.metrics.IncUnsolicitedResponse("stored")
+		if rw.notifier != nil {
+			rw.notifier.Publish(req.GenerateCacheKey())
+		}
+	}
+
+	// 予約はレスポンスを受け取ったため削除する（キューに戻していた場合もキューから取り除く）
+	_ = rw.bprepo.RemovePendingRequest(ctx, req.URL)
+	if err := rw.bprepo.RemoveReservedRequest(ctx, req); err != nil {
+		log.Printf("[ResponseWatcher] 予約の削除に失敗 (URL: %s, RequestID: %s): %v", req.URL, resp.RequestID, err)
+	}
+	if err := rw.bprepo.CompleteSentRequest(ctx, resp.RequestID); err != nil {
+		log.Printf("[ResponseWatcher] 送信の記録の削除に失敗 (RequestID: %s): %v", resp.RequestID, err)
+	}
+	return true
+}
+
+// originalURL 地上局が付けたX-Original-URL（ゲートウェイがヘッダー名を正規化している場合もある）
+func originalURL(resp *model.BpResponse) string {
+	if url := http.Header(resp.Headers).Get("X-Original-URL"); url != "" {
+		return url
+	}
+	if urls := resp.Headers["X-Original-URL"]; len(urls) > 0 {
+		return urls[0]
 	}
+	return ""
 }
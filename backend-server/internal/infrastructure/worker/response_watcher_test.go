@@ -0,0 +1,151 @@
+// response_watcher_test.go - 待っているリクエストのないレスポンス（事前取得やタイムアウト後に届いたもの）のキャッシュのテスト
+package worker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+func TestResponseWatcherCachesUnsolicited(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	policy := model.TTLPolicy{Default: time.Hour, Rules: []model.TTLRule{{ContentType: "image/", TTL: 48 * time.Hour}}}
+	rw := NewResponseWatcher(gw, repo, policy, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rw.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		ct     string
+		ttl    time.Duration
+		cached bool
+	}{
+		{"page", "https://example.com/page", http.StatusOK, "text/html", time.Hour, true},
+		{"image uses the content type rule", "https://example.com/logo.png", http.StatusOK, "image/png", 48 * time.Hour, true},
+		{"error is not cached", "https://example.com/missing", http.StatusNotFound, "text/html", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			gw.Push(&model.BpResponse{
+				StatusCode:  tt.status,
+				Headers:     map[string][]string{"Content-Type": {tt.ct}, "X-Original-URL": {tt.url}},
+				Body:        []byte("body"),
+				ContentType: tt.ct,
+			})
+
+			key := (&model.BpRequest{Method: http.MethodGet, URL: tt.url}).GenerateCacheKey()
+			deadline := time.Now().Add(time.Second)
+			var resp *model.BpResponse
+			var found bool
+			for time.Now().Before(deadline) {
+				if resp, found, _ = repo.GetResponse(context.Background(), key); found {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if found != tt.cached {
+				t.Fatalf("Expected cached=%v, got %v", tt.cached, found)
+			}
+			if !found {
+				return
+			}
+			if ttl := resp.ExpiresAt.Sub(before); ttl < tt.ttl || ttl > tt.ttl+time.Minute {
+				t.Errorf("Expected a TTL of %v, got %v", tt.ttl, ttl)
+			}
+		})
+	}
+}
+
+// unsolicitedCounts result（stored、skipped、error）ごとのunsolicited_responses_total
+func unsolicitedCounts(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "bp_proxy_unsolicited_responses_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			counts[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
+
+func TestResponseWatcherPrefetchBatch(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := NewResponseWatcher(gw, repo, model.TTLPolicy{Default: time.Hour}, nil, m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rw.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// 地上局がページのリンクを再帰的に取得して送ってきたレスポンス（ゲートウェイはヘッダー名を正規化する）
+	links := []string{"https://example.com/a", "https://example.com/b", "https://example.com/c", "https://example.com/d"}
+	for _, url := range links {
+		gw.Push(&model.BpResponse{
+			StatusCode:  http.StatusOK,
+			Headers:     http.Header{"Content-Type": {"text/html"}, "X-Original-Url": {url}},
+			Body:        []byte(url),
+			ContentType: "text/html",
+		})
+	}
+	// URLのないレスポンスとhttp以外のURLはキャッシュしない
+	gw.Push(&model.BpResponse{StatusCode: http.StatusOK, Headers: http.Header{}})
+	gw.Push(&model.BpResponse{StatusCode: http.StatusOK, Headers: http.Header{"X-Original-Url": {"error://bundle-too-large/1"}}})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if counts := unsolicitedCounts(t, reg); counts["stored"]+counts["skipped"] == float64(len(links)+2) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if counts := unsolicitedCounts(t, reg); counts["stored"] != float64(len(links)) || counts["skipped"] != 2 {
+		t.Errorf("Expected %d stored and 2 skipped, got %v", len(links), counts)
+	}
+
+	for _, url := range links {
+		resp, found, err := repo.GetResponse(context.Background(), (&model.BpRequest{Method: http.MethodGet, URL: url}).GenerateCacheKey())
+		if err != nil || !found || string(resp.Body) != url {
+			t.Errorf("Expected %s to be cached, got found=%v err=%v", url, found, err)
+		}
+	}
+	// 予約キューは使わない
+	if queue, _ := repo.GetReservedRequests(context.Background()); len(queue) != 0 {
+		t.Errorf("Expected no reservation, got %d", len(queue))
+	}
+}
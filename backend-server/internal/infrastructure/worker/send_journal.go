@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/repository"
+)
+
+// RecoveryResult 起動時に引き継いだ送信の記録の件数
+type RecoveryResult struct {
+	Requeued int // 予約キューに戻したもの（レスポンスが届けばResponseWatcherが記録と突き合わせる）
+	Queued   int // 予約がまだキューにあったため、そのままにしたもの
+	Dropped  int // maxAgeより前に送ったため、予約とともに削除したもの
+}
+
+// RecoverSentRequests 前回の起動でDTNへ送ったまま、レスポンスを処理せずに終了したリクエストを引き継ぐ（Workerを起動する前に呼ぶ）
+// 記録は残して、後から届いたレスポンスを送った予約と突き合わせられるようにし、予約はキューに戻して届かない場合に送り直す
+// maxAgeより前に送った記録（0以下は期限なし）はレスポンスが届く見込みがないため、ログに残して予約とともに削除する
+func RecoverSentRequests(ctx context.Context, bprepo repository.BpRepository, maxAge time.Duration) (RecoveryResult, error) {
+	var result RecoveryResult
+	entries, err := bprepo.ListSentRequests(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to list sent requests: %w", err)
+	}
+	if len(entries) == 0 {
+		return result, nil
+	}
+	queued, err := bprepo.GetReservedRequests(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to get reserved requests: %w", err)
+	}
+	inQueue := make(map[string]bool, len(queued))
+	for _, req := range queued {
+		inQueue[req.GenerateCacheKey()] = true
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		req := entry.Request
+		if maxAge > 0 && entry.Age(now) > maxAge {
+			log.Printf("[SendJournal] 送ってから%vが経ったため、送信の記録と予約を削除します (URL: %s, RequestID: %s, 送信: %s)",
+				entry.Age(now).Round(time.Second), req.URL, entry.RequestID, entry.SentAt.Format(time.RFC3339))
+			_ = bprepo.RemovePendingRequest(ctx, req.URL)
+			if err := bprepo.RemoveReservedRequest(ctx, req); err != nil {
+				return result, fmt.Errorf("failed to remove reserved request %s: %w", req.URL, err)
+			}
+			if err := bprepo.CompleteSentRequest(ctx, entry.RequestID); err != nil {
+				return result, fmt.Errorf("failed to remove sent request %s: %w", entry.RequestID, err)
+			}
+			result.Dropped++
+			continue
+		}
+
+		key := req.GenerateCacheKey()
+		if inQueue[key] {
+			// 送り直すためにキューに戻した後で終了した予約は、二重にキューに入れない
+			result.Queued++
+			continue
+		}
+		if err := bprepo.RequeueReservedRequest(ctx, req); err != nil {
+			return result, fmt.Errorf("failed to requeue reserved request %s: %w", req.URL, err)
+		}
+		inQueue[key] = true
+		log.Printf("[SendJournal] 前回の起動で送ったリクエストを予約キューに戻しました (URL: %s, RequestID: %s, 送信: %s)", req.URL, entry.RequestID, entry.SentAt.Format(time.RFC3339))
+		result.Requeued++
+	}
+	log.Printf("[SendJournal] 送信の記録を引き継ぎました (キューに戻した予約: %d, キューにあった予約: %d, 削除: %d)", result.Requeued, result.Queued, result.Dropped)
+	return result, nil
+}
@@ -0,0 +1,186 @@
+// send_journal_test.go - DTNへ送ったリクエストの記録と、送ってからレスポンスが届くまでの間の再起動のテスト
+package worker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/fakes"
+)
+
+// sendAndStop reqを予約してWorkerが取り出し、DTNへ送ってレスポンスを待っている間にWorkerを止める（バックエンドの再起動の前半）
+// 送ったリクエスト（バンドルのrequest_idを含む）を返す
+func sendAndStop(t *testing.T, repo *fakes.Repository, req *model.BpRequest) *model.BpRequest {
+	t.Helper()
+	gw := fakes.NewGateway()
+	gw.SetLatency(time.Hour)
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	if _, err := repo.ReserveRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	popped, err := repo.BLPopReservedRequest(context.Background(), time.Second)
+	if err != nil || popped == nil {
+		t.Fatalf("Expected a reservation, got %v %v", popped, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- rh.HandleRequest(ctx, popped, 1) }()
+
+	deadline := time.Now().Add(time.Second)
+	for len(gw.Requests()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the request to be sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("Expected HandleRequest to stop with an error")
+	}
+	return gw.Requests()[0]
+}
+
+func TestRecoverSentRequestsAfterRestart(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page", Headers: map[string][]string{"Accept-Language": {"ja"}}, RequestID: "browser-1"}
+	sent := sendAndStop(t, repo, req)
+	if sent.RequestID != "browser-1" {
+		t.Fatalf("Expected the browser's RequestID to be sent, got %q", sent.RequestID)
+	}
+	key := req.GenerateCacheKey()
+	if !repo.IsReserved(key) {
+		t.Fatal("Expected the reservation to be kept while stopping")
+	}
+
+	// 再起動: 送信の記録から予約をキューに戻す
+	result, err := RecoverSentRequests(context.Background(), repo, time.Hour)
+	if err != nil {
+		t.Fatalf("RecoverSentRequests failed: %v", err)
+	}
+	if result != (RecoveryResult{Requeued: 1}) {
+		t.Errorf("Expected 1 requeued entry, got %+v", result)
+	}
+	queued, _ := repo.GetReservedRequests(context.Background())
+	if len(queued) != 1 || queued[0].GenerateCacheKey() != key {
+		t.Fatalf("Expected the reservation to be queued again, got %v", queued)
+	}
+
+	// 前回の起動で送ったバンドルのレスポンスは、待っているリクエストのないレスポンスとして届く
+	rw := NewResponseWatcher(fakes.NewGateway(), repo, model.TTLPolicy{Default: time.Hour}, nil, nil)
+	rw.handleResponse(context.Background(), &model.BpResponse{
+		RequestID:   sent.RequestID,
+		StatusCode:  http.StatusOK,
+		Headers:     map[string][]string{"Content-Type": {"text/html"}, "X-Original-URL": {req.URL}},
+		Body:        []byte("<p>page</p>"),
+		ContentType: "text/html",
+	})
+
+	// 送った予約のキャッシュキー（ヘッダーを含む）で保存し、予約と記録を削除する
+	resp, found, _ := repo.GetResponse(context.Background(), key)
+	if !found || string(resp.Body) != "<p>page</p>" {
+		t.Fatalf("Expected the response cached under the reserved key, got found=%v", found)
+	}
+	if repo.IsReserved(key) {
+		t.Error("Expected the reservation to be removed")
+	}
+	if queued, _ := repo.GetReservedRequests(context.Background()); len(queued) != 0 {
+		t.Errorf("Expected the queue to be empty, got %v", queued)
+	}
+	if entries, _ := repo.ListSentRequests(context.Background()); len(entries) != 0 {
+		t.Errorf("Expected the journal to be empty, got %v", entries)
+	}
+}
+
+func TestRecoverSentRequestsDropsOldEntries(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/old", RequestID: "old-1"}
+	sendAndStop(t, repo, req)
+	sent, _, _ := repo.GetSentRequest(context.Background(), "old-1")
+	sent.SentAt = time.Now().Add(-2 * time.Hour)
+	_ = repo.JournalSentRequest(context.Background(), sent)
+
+	result, err := RecoverSentRequests(context.Background(), repo, time.Hour)
+	if err != nil {
+		t.Fatalf("RecoverSentRequests failed: %v", err)
+	}
+	if result != (RecoveryResult{Dropped: 1}) {
+		t.Errorf("Expected 1 dropped entry, got %+v", result)
+	}
+	if repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected the reservation to be released")
+	}
+	if _, found, _ := repo.GetSentRequest(context.Background(), "old-1"); found {
+		t.Error("Expected the old entry to be removed")
+	}
+}
+
+func TestRecoverSentRequestsKeepsQueuedReservation(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page"}
+	_ = repo.JournalSentRequest(context.Background(), &model.SentRequest{RequestID: "queued-1", Request: req, SentAt: time.Now()})
+	// 送り直すためにキューに戻した後で終了した予約
+	_, _ = repo.ReserveRequest(context.Background(), req)
+
+	result, err := RecoverSentRequests(context.Background(), repo, 0)
+	if err != nil {
+		t.Fatalf("RecoverSentRequests failed: %v", err)
+	}
+	if result != (RecoveryResult{Queued: 1}) {
+		t.Errorf("Expected 1 entry already queued, got %+v", result)
+	}
+	if queued, _ := repo.GetReservedRequests(context.Background()); len(queued) != 1 {
+		t.Errorf("Expected the reservation to be queued once, got %d", len(queued))
+	}
+	if _, found, _ := repo.GetSentRequest(context.Background(), "queued-1"); !found {
+		t.Error("Expected the entry to be kept for the late response")
+	}
+}
+
+func TestHandleRequestCompletesJournal(t *testing.T) {
+	repo, gw := fakes.NewRepository(0), fakes.NewGateway()
+	gw.RespondBody("https://example.com/asset.css", "text/css", "body{}")
+	rh := NewRequestHandler(repo, gw, model.TTLPolicy{Default: time.Hour}, 0, 0, 0, nil, 0, nil)
+
+	// 事前取得の予約はRequestIDがないため、生成したRequestIDで送る
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/asset.css", Prefetch: true}
+	_, _ = repo.ReserveRequest(context.Background(), req)
+	popAndHandle(t, repo, rh)
+
+	if requests := gw.Requests(); len(requests) != 1 || requests[0].RequestID == "" {
+		t.Fatalf("Expected the prefetch to be sent with a generated RequestID, got %v", requests)
+	}
+	if entries, _ := repo.ListSentRequests(context.Background()); len(entries) != 0 {
+		t.Errorf("Expected the journal to be empty after the response, got %v", entries)
+	}
+}
+
+func TestResponseWatcherIgnoresJournalForOtherURL(t *testing.T) {
+	repo := fakes.NewRepository(0)
+	req := &model.BpRequest{Method: http.MethodGet, URL: "https://example.com/page"}
+	_, _ = repo.ReserveRequest(context.Background(), req)
+	_ = repo.JournalSentRequest(context.Background(), &model.SentRequest{RequestID: "page-1", Request: req, SentAt: time.Now()})
+
+	// 地上局が再帰的に取得したリンクのレスポンスは、同じrequest_idでも送ったリクエストのものではない
+	rw := NewResponseWatcher(fakes.NewGateway(), repo, model.TTLPolicy{Default: time.Hour}, nil, nil)
+	link := "https://example.com/link"
+	rw.handleResponse(context.Background(), &model.BpResponse{
+		RequestID:  "page-1",
+		StatusCode: http.StatusOK,
+		Headers:    map[string][]string{"X-Original-URL": {link}},
+		Body:       []byte("link"),
+	})
+
+	if _, found, _ := repo.GetResponse(context.Background(), (&model.BpRequest{Method: http.MethodGet, URL: link}).GenerateCacheKey()); !found {
+		t.Error("Expected the link to be cached by its URL")
+	}
+	if !repo.IsReserved(req.GenerateCacheKey()) {
+		t.Error("Expected the page reservation to be kept")
+	}
+	if _, found, _ := repo.GetSentRequest(context.Background(), "page-1"); !found {
+		t.Error("Expected the page entry to be kept")
+	}
+}
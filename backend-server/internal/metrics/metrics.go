@@ -0,0 +1,332 @@
+// metrics.go - /metricsで公開するPrometheusのメトリクス
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace メトリクス名の接頭辞
+const namespace = "bp_proxy"
+
+// requestBuckets HTTPリクエストの所要時間のバケット（キャッシュは数ミリ秒、直接転送はDTNの往復を待つ）
+var requestBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+
+// dtnBuckets DTNの往復時間・予約キューの待ち時間のバケット（数秒から数時間）
+var dtnBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200}
+
+// Metrics プロキシのメトリクス
+// レジストリはコンストラクタで受け取り、グローバルな状態を持たない（テストでは専用のレジストリから値を確認する）
+// nilのMetricsのメソッドは何もしないため、メトリクスを使わない場合はnilを渡せる
+type Metrics struct {
+	registerer prometheus.Registerer
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	cacheResults    *prometheus.CounterVec
+	cacheCleanup    *prometheus.CounterVec
+
+	workerJobs      *prometheus.CounterVec
+	workerQueueWait prometheus.Histogram
+	unsolicited     *prometheus.CounterVec
+
+	bundlesSent      *prometheus.CounterVec
+	gatewayRoundTrip *prometheus.HistogramVec
+	gatewayErrors    *prometheus.CounterVec
+	circuitState     *prometheus.GaugeVec
+	circuitChanges   *prometheus.CounterVec
+	linkUp           prometheus.Gauge
+	probeRoundTrip   prometheus.Histogram
+	inFlight         prometheus.Gauge
+	inFlightWaiting  prometheus.Gauge
+	roundTripRecent  *prometheus.GaugeVec
+	responseTimeout  prometheus.Gauge
+
+	passthroughConnections prometheus.Counter
+	passthroughBytes       *prometheus.CounterVec
+}
+
+// New メトリクスを作成してregに登録する
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		registerer: reg,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Proxied HTTP requests by method and outcome (cache status, blocked, timeout, error).",
+		}, []string{"method", "outcome"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to answer a proxied HTTP request by method and outcome.",
+			Buckets:   requestBuckets,
+		}, []string{"method", "outcome"}),
+		cacheResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_results_total",
+			Help:      "How the service answered requests (hit, stale, negative, miss-reserved, miss-placeholder, miss-direct).",
+		}, []string{"status"}),
+		cacheCleanup: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_cleanup_deleted_total",
+			Help:      "Expired cache entries deleted by the cleanup job by kind (positive, negative).",
+		}, []string{"kind"}),
+		workerJobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "worker_jobs_total",
+			Help:      "Reserved requests handled by the worker pool by result (processed, failed).",
+		}, []string{"result"}),
+		workerQueueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "worker_queue_wait_seconds",
+			Help:      "Time a reserved request waited in the queue before a worker picked it up.",
+			Buckets:   dtnBuckets,
+		}),
+		unsolicited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "unsolicited_responses_total",
+			Help:      "Responses that arrived without a waiting request (prefetched by the earth station or late), by result (stored, skipped, error).",
+		}, []string{"result"}),
+		bundlesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "gateway_bundles_sent_total",
+			Help:      "Bundles sent to the DTN by transport.",
+		}, []string{"transport"}),
+		gatewayRoundTrip: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "gateway_round_trip_seconds",
+			Help:      "Time from sending a request through the gateway until its response arrived, by transport and result (ok, error, timeout).",
+			Buckets:   dtnBuckets,
+		}, []string{"transport", "result"}),
+		gatewayErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "gateway_errors_total",
+			Help:      "Requests answered with an error page because the gateway failed, by reason (link-down, send-failed, gateway-timeout, response-corrupt, gateway-unreachable).",
+		}, []string{"reason"}),
+		circuitState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gateway_circuit_state",
+			Help:      "Current state of the gateway circuit breaker (1 for the current state: closed, open, half_open).",
+		}, []string{"state"}),
+		circuitChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "gateway_circuit_transitions_total",
+			Help:      "State changes of the gateway circuit breaker by the state it moved to (closed, open, half_open).",
+		}, []string{"state"}),
+		linkUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gateway_link_up",
+			Help:      "Whether the last probe to the earth station was answered (1) or not (0).",
+		}),
+		probeRoundTrip: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "gateway_probe_round_trip_seconds",
+			Help:      "Round-trip time of answered probes to the earth station.",
+			Buckets:   dtnBuckets,
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gateway_in_flight_requests",
+			Help:      "Requests sent through the gateway and waiting for their response.",
+		}),
+		inFlightWaiting: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gateway_in_flight_waiting_requests",
+			Help:      "Requests waiting for a free slot because the gateway's in-flight limit is reached.",
+		}),
+		roundTripRecent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gateway_recent_round_trip_seconds",
+			Help:      "Quantiles (0.5, 0.95) of the recent round-trip times from sending a request until its response arrived.",
+		}, []string{"quantile"}),
+		responseTimeout: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "gateway_response_timeout_seconds",
+			Help:      "Time the gateway waits for a response, derived from the recent round-trip times (0 while the configured timeout is used).",
+		}),
+		passthroughConnections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "passthrough_connections_total",
+			Help:      "CONNECT tunnels spliced to the target without SSL bump.",
+		}),
+		passthroughBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "passthrough_bytes_total",
+			Help:      "Bytes relayed through passthrough tunnels by direction (sent to the target, received from the target).",
+		}, []string{"direction"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.requests, m.requestDuration, m.cacheResults, m.cacheCleanup,
+		m.workerJobs, m.workerQueueWait, m.unsolicited,
+		m.bundlesSent, m.gatewayRoundTrip, m.gatewayErrors, m.circuitState, m.circuitChanges, m.linkUp, m.probeRoundTrip,
+		m.inFlight, m.inFlightWaiting, m.roundTripRecent, m.responseTimeout,
+		m.passthroughConnections, m.passthroughBytes,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// RegisterQueueDepth DTNへの予約キューの長さをスクレイプのたびにdepthで取得するゲージを登録する
+// depthが負の値を返した場合（取得に失敗した場合など）もそのまま公開する
+func (m *Metrics) RegisterQueueDepth(depth func() float64) error {
+	if m == nil {
+		return nil
+	}
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dtn_queue_depth",
+		Help:      "Requests reserved for the DTN and not yet handled by the worker pool.",
+	}, depth)
+	if err := m.registerer.Register(gauge); err != nil {
+		return fmt.Errorf("failed to register queue depth: %w", err)
+	}
+	return nil
+}
+
+// ObserveRequest プロキシしたHTTPリクエストを記録する
+func (m *Metrics) ObserveRequest(method, outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(method, outcome).Inc()
+	m.requestDuration.WithLabelValues(method, outcome).Observe(d.Seconds())
+}
+
+// IncCacheResult Service層がレスポンスをどのように用意したか（model.CacheStatusの値）を記録する
+func (m *Metrics) IncCacheResult(status string) {
+	if m == nil {
+		return
+	}
+	m.cacheResults.WithLabelValues(status).Inc()
+}
+
+// ObserveCacheCleanup クリーンアップで削除した期限切れのキャッシュを、通常のキャッシュとネガティブキャッシュに分けて記録する
+// deletedは削除した総数で、そのうちnegative件がネガティブキャッシュ
+func (m *Metrics) ObserveCacheCleanup(deleted, negative int) {
+	if m == nil {
+		return
+	}
+	m.cacheCleanup.WithLabelValues("positive").Add(float64(deleted - negative))
+	m.cacheCleanup.WithLabelValues("negative").Add(float64(negative))
+}
+
+// ObserveWorkerJob ワーカーが予約を処理した結果を記録する（failedはエラーで終わった場合）
+func (m *Metrics) ObserveWorkerJob(failed bool) {
+	if m == nil {
+		return
+	}
+	result := "processed"
+	if failed {
+		result = "failed"
+	}
+	m.workerJobs.WithLabelValues(result).Inc()
+}
+
+// ObserveQueueWait 予約してからワーカーが取り出すまでの時間を記録する
+func (m *Metrics) ObserveQueueWait(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.workerQueueWait.Observe(d.Seconds())
+}
+
+// IncUnsolicitedResponse 待っているリクエストのないレスポンスを、キャッシュに保存したか（stored、skipped、error）とともに記録する
+func (m *Metrics) IncUnsolicitedResponse(result string) {
+	if m == nil {
+		return
+	}
+	m.unsolicited.WithLabelValues(result).Inc()
+}
+
+// IncBundlesSent DTNへ送ったバンドルを記録する
+func (m *Metrics) IncBundlesSent(transport string) {
+	if m == nil {
+		return
+	}
+	m.bundlesSent.WithLabelValues(transport).Inc()
+}
+
+// ObserveRoundTrip ゲートウェイでリクエストを送ってからレスポンスが届く（または失敗する）までの時間を記録する
+func (m *Metrics) ObserveRoundTrip(transport, result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.gatewayRoundTrip.WithLabelValues(transport, result).Observe(d.Seconds())
+}
+
+// IncGatewayError ゲートウェイが転送に失敗してエラーページを返したリクエストを、失敗の理由ごとに記録する
+func (m *Metrics) IncGatewayError(reason string) {
+	if m == nil {
+		return
+	}
+	m.gatewayErrors.WithLabelValues(reason).Inc()
+}
+
+// circuitStates サーキットブレーカーの状態（gateway_circuit_stateのラベル）
+var circuitStates = []string{"closed", "open", "half_open"}
+
+// SetCircuitState サーキットブレーカーがstateになったことを記録する（stateのゲージだけを1にする）
+// changedがfalseの場合は、起動時など状態の変化でないためgateway_circuit_transitions_totalを増やさない
+func (m *Metrics) SetCircuitState(state string, changed bool) {
+	if m == nil {
+		return
+	}
+	for _, s := range circuitStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		m.circuitState.WithLabelValues(s).Set(value)
+	}
+	if changed {
+		m.circuitChanges.WithLabelValues(state).Inc()
+	}
+}
+
+// ObserveProbe 地上局へのプローブの結果を記録する（応答が届いた場合はその往復時間も記録する）
+func (m *Metrics) ObserveProbe(answered bool, rtt time.Duration) {
+	if m == nil {
+		return
+	}
+	if !answered {
+		m.linkUp.Set(0)
+		return
+	}
+	m.linkUp.Set(1)
+	m.probeRoundTrip.Observe(rtt.Seconds())
+}
+
+// SetGatewayInFlight ゲートウェイで転送中のリクエストと、同時に転送できる上限のために待っているリクエストの数を記録する
+func (m *Metrics) SetGatewayInFlight(inFlight, waiting int) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Set(float64(inFlight))
+	m.inFlightWaiting.Set(float64(waiting))
+}
+
+// SetRoundTripEstimate 最近の往復時間のp50・p95と、そこから決めたレスポンスを待つ時間（決めていない場合は0）を記録する
+func (m *Metrics) SetRoundTripEstimate(p50, p95, timeout time.Duration) {
+	if m == nil {
+		return
+	}
+	m.roundTripRecent.WithLabelValues("0.5").Set(p50.Seconds())
+	m.roundTripRecent.WithLabelValues("0.95").Set(p95.Seconds())
+	m.responseTimeout.Set(timeout.Seconds())
+}
+
+// ObservePassthrough SSL Bumpせずに中継した接続と、その中継したバイト数を記録する
+func (m *Metrics) ObservePassthrough(sent, received int64) {
+	if m == nil {
+		return
+	}
+	m.passthroughConnections.Inc()
+	m.passthroughBytes.WithLabelValues("sent").Add(float64(sent))
+	m.passthroughBytes.WithLabelValues("received").Add(float64(received))
+}
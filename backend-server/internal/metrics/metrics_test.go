@@ -0,0 +1,128 @@
+// metrics_test.go - メトリクスの登録と予約キューの長さのテスト
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNilMetricsIsNoop(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("GET", "hit", time.Millisecond)
+	m.IncCacheResult("hit")
+	m.ObserveCacheCleanup(3, 1)
+	m.ObserveWorkerJob(true)
+	m.ObserveQueueWait(time.Second)
+	m.IncUnsolicitedResponse("stored")
+	m.IncBundlesSent("bp_socket")
+	m.ObserveRoundTrip("bp_socket", "ok", time.Second)
+	m.ObservePassthrough(1, 2)
+	m.SetCircuitState("open", true)
+	m.ObserveProbe(true, time.Second)
+	if err := m.RegisterQueueDepth(func() float64 { return 1 }); err != nil {
+		t.Errorf("expected nil metrics to ignore queue depth, got %v", err)
+	}
+}
+
+func TestRegisterQueueDepth(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	depth := 3.0
+	if err := m.RegisterQueueDepth(func() float64 { return depth }); err != nil {
+		t.Fatalf("failed to register queue depth: %v", err)
+	}
+	if got, err := testutil.GatherAndCount(reg, "bp_proxy_dtn_queue_depth"); err != nil || got != 1 {
+		t.Fatalf("expected queue depth gauge, got %d (%v)", got, err)
+	}
+
+	// スクレイプのたびに取得し直す
+	depth = 7
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "bp_proxy_dtn_queue_depth" {
+			if got := family.GetMetric()[0].GetGauge().GetValue(); got != 7 {
+				t.Errorf("expected queue depth 7, got %v", got)
+			}
+		}
+	}
+
+	// 同じレジストリに二重に登録できない
+	if _, err := New(reg); err == nil {
+		t.Error("expected error registering metrics twice")
+	}
+}
+
+func TestObserveCacheCleanup(t *testing.T) {
+	m, err := New(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	m.ObserveCacheCleanup(5, 2)
+	m.ObserveCacheCleanup(1, 0)
+	if got := testutil.ToFloat64(m.cacheCleanup.WithLabelValues("positive")); got != 4 {
+		t.Errorf("expected 4 positive entries deleted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.cacheCleanup.WithLabelValues("negative")); got != 2 {
+		t.Errorf("expected 2 negative entries deleted, got %v", got)
+	}
+}
+
+func TestSetCircuitState(t *testing.T) {
+	m, err := New(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	m.SetCircuitState("closed", false)
+	m.SetCircuitState("open", true)
+	for state, want := range map[string]float64{"closed": 0, "open": 1, "half_open": 0} {
+		if got := testutil.ToFloat64(m.circuitState.WithLabelValues(state)); got != want {
+			t.Errorf("expected %s gauge %v, got %v", state, want, got)
+		}
+	}
+	if got := testutil.ToFloat64(m.circuitChanges.WithLabelValues("open")); got != 1 {
+		t.Errorf("expected 1 transition to open, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.circuitChanges.WithLabelValues("closed")); got != 0 {
+		t.Errorf("expected the initial state not to count as a transition, got %v", got)
+	}
+}
+
+func TestObserveProbe(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	m.ObserveProbe(true, 3*time.Second)
+	if got := testutil.ToFloat64(m.linkUp); got != 1 {
+		t.Errorf("expected the link to be up, got %v", got)
+	}
+	m.ObserveProbe(false, 0)
+	if got := testutil.ToFloat64(m.linkUp); got != 0 {
+		t.Errorf("expected the link to be down, got %v", got)
+	}
+
+	// 応答が届かなかったプローブは往復時間に含めない
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "bp_proxy_gateway_probe_round_trip_seconds" {
+			continue
+		}
+		histogram := family.GetMetric()[0].GetHistogram()
+		if histogram.GetSampleCount() != 1 || histogram.GetSampleSum() != 3 {
+			t.Errorf("expected one 3s round trip, got %d samples (sum %v)", histogram.GetSampleCount(), histogram.GetSampleSum())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// アクセスログに載せる値をハンドラーがgin.Contextに設定するキー
+const (
+	accessTargetKey = "access_log.target"
+	accessCacheKey  = "access_log.cache"
+	accessResultKey = "access_log.outcome"
+)
+
+// AccessLogEntry アクセスログの1行（1リクエスト）
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	ClientIP   string    `json:"client_ip"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	// Cache キャッシュの扱い（hit・stale・revalidating・negative・reserved・placeholder・direct、Service層まで届かなかった場合は空）
+	Cache string `json:"cache,omitempty"`
+	// Outcome ハンドラーが判断したリクエストの結果（メトリクスのoutcomeと同じ値）
+	Outcome   string `json:"outcome,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// SetAccessTarget アクセスログに載せる転送先の完全なURLを設定する（設定しない場合はリクエストのURL）
+func SetAccessTarget(c *gin.Context, url string) {
+	c.Set(accessTargetKey, url)
+}
+
+// SetAccessOutcome アクセスログに載せるリクエストの結果とキャッシュの扱いを設定する（cacheは空でもよい）
+func SetAccessOutcome(c *gin.Context, outcome, cache string) {
+	c.Set(accessResultKey, outcome)
+	if cache != "" {
+		c.Set(accessCacheKey, cache)
+	}
+}
+
+// AccessLog 1リクエストにつき1行のJSONをoutに書き込むGinのミドルウェア
+// 時刻・接続元・メソッド・URL・ステータス・書き込んだバイト数・所要時間に加えて、ハンドラーが設定した結果とキャッシュの扱い、リクエストIDを記録する
+// CONNECTはトンネルを閉じたときに1行を書き込む（SSL Bumpしたトンネル内のリクエストはログに出さない）
+func AccessLog(out io.Writer) gin.HandlerFunc {
+	var mu sync.Mutex
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		entry := AccessLogEntry{
+			Time:       start,
+			ClientIP:   remoteIP(c.Request.RemoteAddr),
+			Method:     c.Request.Method,
+			URL:        c.GetString(accessTargetKey),
+			Status:     c.Writer.Status(),
+			Bytes:      max(c.Writer.Size(), 0),
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			Cache:      c.GetString(accessCacheKey),
+			Outcome:    c.GetString(accessResultKey),
+			RequestID:  requestid.FromContext(c.Request.Context()),
+		}
+		if entry.URL == "" {
+			entry.URL = requestURL(c.Request)
+		}
+		if entry.RequestID == "" {
+			entry.RequestID = c.Writer.Header().Get(requestid.Header)
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("[AccessLog] Failed to encode entry: %v", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := out.Write(append(line, '\n')); err != nil {
+			log.Printf("[AccessLog] Failed to write entry: %v", err)
+		}
+	}
+}
+
+// requestURL ハンドラーがURLを設定しなかった場合にログに載せるURL（CONNECTはhost:port）
+func requestURL(r *http.Request) string {
+	if r.Method == http.MethodConnect {
+		return r.Host
+	}
+	return r.URL.String()
+}
+
+// rotatingFile 大きさがmaxSizeを超えたらpath.1、path.2、…へずらして新しいファイルに書き込むアクセスログのファイル
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenAccessLogFile アクセスログのファイルを開く（追記）
+// maxSizeが0以下の場合はローテーションしない。maxBackupsは残す古いファイルの数
+func OpenAccessLogFile(path string, maxSize int64, maxBackups int) (io.WriteCloser, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log %s: %w", rf.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat access log %s: %w", rf.path, err)
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate 古いファイルを1つずつずらし（残す数を超えたものは削除し）、新しいファイルを開く
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("failed to close access log %s: %w", rf.path, err)
+	}
+	if rf.maxBackups <= 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove access log %s: %w", rf.path, err)
+		}
+		return rf.open()
+	}
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate access log %s: %w", rf.path, err)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
@@ -0,0 +1,99 @@
+// access_log_test.go - アクセスログのミドルウェアとファイルのローテーションのテスト
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessLogDefaults(t *testing.T) {
+	var out bytes.Buffer
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID(), AccessLog(&out))
+	r.GET("/system/health", func(c *gin.Context) {
+		c.String(http.StatusOK, "healthy")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/system/health?verbose=1", nil)
+	req.RemoteAddr = "192.168.1.20:53211"
+	req.Header.Set("X-Request-ID", "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry AccessLogEntry
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("expected one JSON line, got %q: %v", out.String(), err)
+	}
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("expected exactly one line, got %q", out.String())
+	}
+	// ハンドラーが何も設定しない場合はリクエストのURLを載せ、結果とキャッシュの扱いは空
+	if entry.URL != "/system/health?verbose=1" || entry.Method != http.MethodGet || entry.Status != http.StatusOK {
+		t.Errorf("unexpected request fields: %+v", entry)
+	}
+	if entry.ClientIP != "192.168.1.20" || entry.Bytes != len("healthy") || entry.RequestID != "req-1" {
+		t.Errorf("unexpected client fields: %+v", entry)
+	}
+	if entry.Cache != "" || entry.Outcome != "" || entry.Time.IsZero() {
+		t.Errorf("unexpected handler fields: %+v", entry)
+	}
+}
+
+func TestAccessLogFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenAccessLogFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 10バイトを超える行を書き込むたびにずらす（.1が直前、.2がその前、それより古いものは消える）
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("expected %s to contain %q, got %q", filepath.Base(name), content, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no more than 2 backups, got %v", err)
+	}
+}
+
+func TestAccessLogFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenAccessLogFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("new\n"))
+	f.Close()
+
+	got, _ := os.ReadFile(path)
+	if string(got) != "old\nnew\n" {
+		t.Errorf("expected the existing log to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth /system/admin のエンドポイントを保護するGinのミドルウェア
+// tokenが設定されている場合は "Authorization: Bearer <token>" が一致するリクエストだけを通し、それ以外は401を返す
+// tokenが空の場合は、共有LANに管理用エンドポイントを公開しないよう、ループバックからの接続だけを通す（それ以外は403）
+func AdminAuth(token string) gin.HandlerFunc {
+	if token == "" {
+		log.Println("[AdminAuth] No admin token configured, admin endpoints accept loopback connections only")
+		return func(c *gin.Context) {
+			if !isLoopback(c.Request.RemoteAddr) {
+				log.Printf("[AdminAuth] Rejected non-loopback admin request from %s: %s %s", remoteIP(c.Request.RemoteAddr), c.Request.Method, c.Request.URL.Path)
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are only available from localhost (configure an admin token to allow remote access)"})
+				return
+			}
+			c.Next()
+		}
+	}
+
+	expected := []byte(token)
+	return func(c *gin.Context) {
+		given, ok := bearerToken(c.GetHeader("Authorization"))
+		// 長さの違いも含めて比較時間から推測されないよう、ConstantTimeCompareで比較する
+		if !ok || subtle.ConstantTimeCompare([]byte(given), expected) != 1 {
+			log.Printf("[AdminAuth] Rejected admin request from %s: %s %s", remoteIP(c.Request.RemoteAddr), c.Request.Method, c.Request.URL.Path)
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// bearerToken Authorizationヘッダーからトークンを取り出す
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// isLoopback 接続元がループバックアドレスか
+// X-Forwarded-Forなどのヘッダーは偽装できるため、接続元のアドレスだけを見る
+func isLoopback(remoteAddr string) bool {
+	ip := net.ParseIP(remoteIP(remoteAddr))
+	return ip != nil && ip.IsLoopback()
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
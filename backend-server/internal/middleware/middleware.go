@@ -8,11 +8,15 @@ import (
 
 type MiddlewarePlugins struct {
 	SSLBumpHandler *module.SSLBumpHandler
+	DomainFilter   *module.DomainFilter // nilの場合はすべてのドメインを転送する
+	Passthrough    *module.Passthrough  // SSL Bumpせずに中継するCONNECTの接続先（nilの場合はすべてSSL Bumpする）
 }
 
-func NewMiddlewarePlugins(sslBumpHandler *module.SSLBumpHandler) *MiddlewarePlugins {
+func NewMiddlewarePlugins(sslBumpHandler *module.SSLBumpHandler, domainFilter *module.DomainFilter, passthrough *module.Passthrough) *MiddlewarePlugins {
 	return &MiddlewarePlugins{
 		SSLBumpHandler: sslBumpHandler,
+		DomainFilter:   domainFilter,
+		Passthrough:    passthrough,
 	}
 }
 
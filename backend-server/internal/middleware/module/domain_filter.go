@@ -0,0 +1,118 @@
+package module
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DomainFilter DTNの帯域を消費する前に、転送しないドメインをホスト名で判定する
+// パターンは完全一致のホスト名（"example.com"）か、"*.example.com"（example.com自身とそのサブドメイン）
+// ブロックリストは許可リストより優先し、許可リストが空でない場合は許可リストにないホストをすべてブロックする
+// 管理用エンドポイントから再読み込みできるよう、リストの差し替えは実行中のリクエストと並行して行える
+type DomainFilter struct {
+	mu    sync.RWMutex
+	allow []string
+	block []string
+}
+
+// NewDomainFilter allowは許可リスト（空の場合はすべて許可）、blockはブロックリスト
+func NewDomainFilter(allow, block []string) (*DomainFilter, error) {
+	df := &DomainFilter{}
+	if err := df.Update(allow, block); err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+// Update リストを差し替える
+// 不正なパターンがある場合はエラーを返し、現在のリストをそのまま使い続ける
+func (df *DomainFilter) Update(allow, block []string) error {
+	allowPatterns, err := normalizePatterns(allow)
+	if err != nil {
+		return fmt.Errorf("invalid allowlist: %w", err)
+	}
+	blockPatterns, err := normalizePatterns(block)
+	if err != nil {
+		return fmt.Errorf("invalid blocklist: %w", err)
+	}
+
+	df.mu.Lock()
+	defer df.mu.Unlock()
+	df.allow = allowPatterns
+	df.block = blockPatterns
+	return nil
+}
+
+// Lists 現在の許可リストとブロックリスト（正規化したパターン）のコピーを返す
+func (df *DomainFilter) Lists() (allow, block []string) {
+	df.mu.RLock()
+	defer df.mu.RUnlock()
+	return append([]string{}, df.allow...), append([]string{}, df.block...)
+}
+
+// Check hostを転送してよいかを判定し、ブロックする場合はその理由を返す
+// hostはポート付き（"example.com:443"）でもよい
+func (df *DomainFilter) Check(host string) (allowed bool, reason string) {
+	if df == nil {
+		return true, ""
+	}
+	host = normalizeHost(host)
+
+	df.mu.RLock()
+	defer df.mu.RUnlock()
+	for _, pattern := range df.block {
+		if matchDomain(pattern, host) {
+			return false, fmt.Sprintf("%s is on the blocklist (%s)", host, pattern)
+		}
+	}
+	if len(df.allow) == 0 {
+		return true, ""
+	}
+	for _, pattern := range df.allow {
+		if matchDomain(pattern, host) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("%s is not on the allowlist", host)
+}
+
+// matchDomain hostがpatternに一致するか（patternとhostは正規化済み）
+func matchDomain(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// normalizeHost ポート・IPv6の角括弧・末尾のドットを取り除いて小文字にする
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// normalizePatterns パターンを正規化する（空のパターンは読み飛ばす）
+func normalizePatterns(patterns []string) ([]string, error) {
+	normalized := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(p)), ".")
+		if p == "" {
+			continue
+		}
+		if ip := net.ParseIP(strings.Trim(p, "[]")); ip != nil {
+			// IPアドレスのリテラルは完全一致のみ
+			normalized = append(normalized, strings.Trim(p, "[]"))
+			continue
+		}
+		name := strings.TrimPrefix(p, "*.")
+		if name == "" || strings.ContainsAny(name, "*/: ") {
+			return nil, fmt.Errorf("unsupported pattern %q (use \"example.com\" or \"*.example.com\")", p)
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,99 @@
+// domain_filter_test.go - ドメインの許可リスト・ブロックリストの判定のテスト
+package module
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDomainFilterPatterns(t *testing.T) {
+	df, err := NewDomainFilter(nil, []string{"video.example.com", "*.Huge.Example", " ", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("NewDomainFilter failed: %v", err)
+	}
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"video.example.com", false},
+		{"VIDEO.example.com.", false},
+		{"video.example.com:443", false},
+		{"cdn.video.example.com", true}, // 完全一致のパターンはサブドメインを含まない
+		{"example.com", true},
+		{"huge.example", false}, // *.suffix はsuffix自身も含む
+		{"a.b.huge.example:8080", false},
+		{"nothuge.example", true},
+		{"192.0.2.1:80", false},
+		{"192.0.2.10", true},
+	}
+	for _, tt := range tests {
+		allowed, reason := df.Check(tt.host)
+		if allowed != tt.allowed {
+			t.Errorf("Check(%q): expected allowed=%v, got %v (%s)", tt.host, tt.allowed, allowed, reason)
+		}
+		if !allowed && reason == "" {
+			t.Errorf("Check(%q): expected a reason for blocking", tt.host)
+		}
+	}
+}
+
+func TestDomainFilterBlocklistWins(t *testing.T) {
+	df, err := NewDomainFilter([]string{"*.example.com"}, []string{"*.video.example.com", "ads.example.com"})
+	if err != nil {
+		t.Fatalf("NewDomainFilter failed: %v", err)
+	}
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"www.example.com", true},
+		{"example.com", true},
+		{"video.example.com", false},    // 許可リストにも一致するがブロックリストが優先
+		{"hd.video.example.com", false}, // 同上
+		{"ads.example.com", false},
+		{"example.org", false}, // 許可リストを設定した場合はリストにないホストをブロック
+	}
+	for _, tt := range tests {
+		if allowed, reason := df.Check(tt.host); allowed != tt.allowed {
+			t.Errorf("Check(%q): expected allowed=%v, got %v (%s)", tt.host, tt.allowed, allowed, reason)
+		}
+	}
+}
+
+func TestDomainFilterUpdate(t *testing.T) {
+	df, err := NewDomainFilter(nil, []string{"blocked.example"})
+	if err != nil {
+		t.Fatalf("NewDomainFilter failed: %v", err)
+	}
+
+	// 不正なパターンを含む場合は現在のリストを使い続ける
+	for _, bad := range []string{"*", "*.", "foo*.example", "example.com/path", "example.com:443"} {
+		if err := df.Update(nil, []string{bad}); err == nil {
+			t.Errorf("Expected an error for pattern %q", bad)
+		}
+	}
+	if allowed, _ := df.Check("blocked.example"); allowed {
+		t.Error("Expected the previous blocklist to stay in effect after a failed update")
+	}
+
+	if err := df.Update([]string{"Only.Example."}, nil); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	allow, block := df.Lists()
+	if !reflect.DeepEqual(allow, []string{"only.example"}) || len(block) != 0 {
+		t.Errorf("Expected normalized lists, got allow=%v block=%v", allow, block)
+	}
+	if allowed, _ := df.Check("blocked.example"); allowed {
+		t.Error("Expected hosts outside the new allowlist to be blocked")
+	}
+	if allowed, _ := df.Check("only.example"); !allowed {
+		t.Error("Expected the allowlisted host to be allowed")
+	}
+}
+
+func TestDomainFilterNil(t *testing.T) {
+	var df *DomainFilter
+	if allowed, _ := df.Check("example.com"); !allowed {
+		t.Error("Expected a nil filter to allow everything")
+	}
+}
@@ -0,0 +1,125 @@
+package module
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// passthroughDialTimeout パススルーで接続先に直接接続するまでの期限
+const passthroughDialTimeout = 10 * time.Second
+
+// spliceBufferSize 中継するときの読み込みバッファのサイズ
+const spliceBufferSize = 32 << 10
+
+// Passthrough SSL Bumpせずにバイト列をそのまま中継する（スプライスする）CONNECTの接続先
+// 証明書のピンニングを行うアプリ（銀行アプリなど）はSSL Bumpすると接続できないため、復号せずに接続先と直接つなぐ
+// パターンはDomainFilterと同じく完全一致のホスト名か"*.example.com"
+type Passthrough struct {
+	patterns []string
+	// idleTimeout どちらの方向にもデータが流れない時間がこれを超えた場合は接続を閉じる（0以下は無制限）
+	idleTimeout time.Duration
+}
+
+// NewPassthrough patternsはパススルーするホスト、idleTimeoutは中継中のアイドルタイムアウト
+func NewPassthrough(patterns []string, idleTimeout time.Duration) (*Passthrough, error) {
+	normalized, err := normalizePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &Passthrough{patterns: normalized, idleTimeout: idleTimeout}, nil
+}
+
+// Match hostをパススルーするか（hostはポート付きでもよい）
+func (p *Passthrough) Match(host string) bool {
+	if p == nil {
+		return false
+	}
+	host = normalizeHost(host)
+	for _, pattern := range p.patterns {
+		if matchDomain(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial CONNECTの接続先（ポートがない場合は443）にTCPで直接接続する
+func (p *Passthrough) Dial(host string) (net.Conn, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "443")
+	}
+	return net.DialTimeout("tcp", addr, passthroughDialTimeout)
+}
+
+// Splice clientとupstreamの間でバイト列を双方向に中継し、両方向が終わるまで待つ
+// 片方向がEOFになった場合は相手側の書き込みだけを閉じ（ハーフクローズ）、もう片方向の終わりを待つ
+// アイドルタイムアウトやエラーの場合は両方の接続を閉じる
+// sentはクライアントから接続先へ、receivedは接続先からクライアントへ中継したバイト数
+func (p *Passthrough) Splice(client, upstream net.Conn) (sent, received int64) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	closeBoth := sync.OnceFunc(func() {
+		client.Close()
+		upstream.Close()
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sent = p.copyIdle(upstream, client, &lastActive, closeBoth)
+	}()
+	go func() {
+		defer wg.Done()
+		received = p.copyIdle(client, upstream, &lastActive, closeBoth)
+	}()
+	wg.Wait()
+	return sent, received
+}
+
+// copyIdle srcからdstへ中継する
+// 読み込みの期限が切れても、もう片方向でidleTimeout以内にデータが流れていれば中継を続ける
+func (p *Passthrough) copyIdle(dst, src net.Conn, lastActive *atomic.Int64, closeBoth func()) int64 {
+	buf := make([]byte, spliceBufferSize)
+	var written int64
+	for {
+		src.SetReadDeadline(p.deadline())
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActive.Store(time.Now().UnixNano())
+			dst.SetWriteDeadline(p.deadline())
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				closeBoth()
+				return written
+			}
+			written += int64(n)
+		}
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && time.Since(time.Unix(0, lastActive.Load())) < p.idleTimeout {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+				return written
+			}
+		}
+		closeBoth()
+		return written
+	}
+}
+
+// deadline 次の読み書きの期限（アイドルタイムアウトがない場合は期限なし）
+func (p *Passthrough) deadline() time.Time {
+	if p.idleTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(p.idleTimeout)
+}
@@ -0,0 +1,140 @@
+// passthrough_test.go - SSL Bumpせずに中継する接続先の判定とスプライスのテスト
+package module
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPassthroughMatch(t *testing.T) {
+	p, err := NewPassthrough([]string{"bank.example", "*.Pinned.Example"}, time.Minute)
+	if err != nil {
+		t.Fatalf("NewPassthrough failed: %v", err)
+	}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"bank.example:443", true},
+		{"BANK.example.", true},
+		{"www.bank.example", false}, // 完全一致のパターンはサブドメインを含まない
+		{"pinned.example", true},
+		{"api.pinned.example:8443", true},
+		{"example.com:443", false},
+	}
+	for _, tt := range tests {
+		if got := p.Match(tt.host); got != tt.want {
+			t.Errorf("Match(%q): expected %v, got %v", tt.host, tt.want, got)
+		}
+	}
+
+	var none *Passthrough
+	if none.Match("bank.example") {
+		t.Error("expected nil Passthrough to match nothing")
+	}
+	if _, err := NewPassthrough([]string{"bank.*"}, time.Minute); err == nil {
+		t.Error("expected an error for an unsupported pattern")
+	}
+}
+
+// tcpPair ループバックでつながったTCP接続の組を返す
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("Accept failed")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed, conn
+}
+
+// spliced クライアント⇔(client, upstream)⇔接続先の経路をSpliceでつなぎ、クライアント側と接続先側の接続を返す
+func spliced(t *testing.T, p *Passthrough) (client, target net.Conn, done <-chan [2]int64) {
+	t.Helper()
+	client, proxyClient := tcpPair(t)
+	proxyUpstream, target := tcpPair(t)
+	ch := make(chan [2]int64, 1)
+	go func() {
+		sent, received := p.Splice(proxyClient, proxyUpstream)
+		ch <- [2]int64{sent, received}
+	}()
+	return client, target, ch
+}
+
+func TestSpliceHalfClose(t *testing.T) {
+	p, _ := NewPassthrough(nil, time.Minute)
+	client, target, done := spliced(t, p)
+
+	client.Write([]byte("request"))
+	client.(*net.TCPConn).CloseWrite()
+	got, err := io.ReadAll(target)
+	if err != nil || string(got) != "request" {
+		t.Fatalf("expected the target to read the request until EOF, got %q (%v)", got, err)
+	}
+
+	// クライアントが書き込みを閉じた後も、接続先からのレスポンスは届く
+	target.Write([]byte("response!"))
+	target.Close()
+	got, err = io.ReadAll(client)
+	if err != nil || string(got) != "response!" {
+		t.Fatalf("expected the response after half-close, got %q (%v)", got, err)
+	}
+
+	select {
+	case n := <-done:
+		if n[0] != 7 || n[1] != 9 {
+			t.Errorf("expected 7 bytes sent and 9 received, got %v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Splice did not return after both directions closed")
+	}
+}
+
+func TestSpliceIdleTimeout(t *testing.T) {
+	p, _ := NewPassthrough(nil, 100*time.Millisecond)
+	client, target, done := spliced(t, p)
+
+	// 片方向だけでもデータが流れている間は閉じない
+	go func() {
+		for i := 0; i < 6; i++ {
+			time.Sleep(40 * time.Millisecond)
+			target.Write([]byte("x"))
+		}
+	}()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("expected the tunnel to stay open while data flows, got %v", err)
+	}
+
+	select {
+	case n := <-done:
+		if n[0] != 0 || n[1] != 6 {
+			t.Errorf("expected 0 bytes sent and 6 received, got %v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Splice did not close the idle tunnel")
+	}
+	if _, err := client.Read(buf); err == nil {
+		t.Error("expected the client connection to be closed after the idle timeout")
+	}
+}
@@ -95,6 +95,11 @@ func (s *SSLBumpHandler) loadCA() error {
 	return nil
 }
 
+// CACertificate 読み込んだCA証明書を返す
+func (s *SSLBumpHandler) CACertificate() *x509.Certificate {
+	return s.caCert
+}
+
 // HandleConnection は、指定された接続に対して SSL Bump (MitM) を実行します。
 // クライアントとのTLSハンドシェイクを完了させた接続（tls.Conn）を返します。
 // 呼び出し元は、返された接続を使ってHTTPリクエストを読み書きし、最後に閉じる責任があります。
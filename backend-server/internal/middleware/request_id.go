@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+// RequestID リクエストごとにX-Request-IDを決めてcontextに付けるGinのミドルウェア
+// クライアントが送ったIDが使える場合はそのまま使い、ない場合は生成する
+// 同じIDをレスポンスのヘッダーで返し、予約とDTNのバンドルにも載せるため、ブラウザのリクエストと地上局での取得をIDで突き合わせられる
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestid.FromHeader(c.GetHeader(requestid.Header))
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.Header, id)
+		c.Next()
+	}
+}
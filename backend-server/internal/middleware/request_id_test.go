@@ -0,0 +1,45 @@
+// request_id_test.go - X-Request-IDを決めてcontextに付けるミドルウェアのテスト
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/requestid"
+)
+
+func serveRequestID(t *testing.T, incoming string) (header, inContext string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/", func(c *gin.Context) {
+		inContext = requestid.FromContext(c.Request.Context())
+		c.String(http.StatusOK, "ok")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if incoming != "" {
+		req.Header.Set(requestid.Header, incoming)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec.Header().Get(requestid.Header), inContext
+}
+
+func TestRequestIDHonorsIncoming(t *testing.T) {
+	header, inContext := serveRequestID(t, "browser-123")
+	if header != "browser-123" || inContext != "browser-123" {
+		t.Errorf("expected incoming ID to be kept and echoed, got header %q, context %q", header, inContext)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	for _, incoming := range []string{"", "bad id with spaces"} {
+		header, inContext := serveRequestID(t, incoming)
+		if header == "" || header == incoming || header != inContext {
+			t.Errorf("expected a generated ID for %q echoed and in context, got header %q, context %q", incoming, header, inContext)
+		}
+	}
+}
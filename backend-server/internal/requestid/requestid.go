@@ -0,0 +1,62 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Header リクエストIDを受け渡すヘッダー
+const Header = "X-Request-ID"
+
+// maxLength クライアントから受け取るリクエストIDの最大長（ログとバンドルに載せるため短く制限する）
+const maxLength = 128
+
+type contextKey struct{}
+
+// New 新しいリクエストIDを生成する
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// FromHeader クライアントが送ったリクエストIDが使える場合はそれを、使えない場合（なし・長すぎる・使えない文字を含む）は新しいIDを返す
+// IDはログの行とDTNのバンドルにそのまま載るため、英数字と "-_.:" だけを受け付ける
+func FromHeader(value string) string {
+	if Valid(value) {
+		return value
+	}
+	return New()
+}
+
+// Valid リクエストIDとしてそのまま使えるか
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext リクエストIDを持つcontextを返す
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext contextのリクエストIDを返す（ない場合は空文字列）
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
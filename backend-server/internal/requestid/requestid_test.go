@@ -0,0 +1,52 @@
+// requestid_test.go - リクエストIDの検証・生成とcontextへの受け渡しのテスト
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFromHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		keep  bool
+	}{
+		{"uuid", "0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"dotted", "web.42:7", true},
+		{"empty", "", false},
+		{"space", "abc def", false},
+		{"newline", "abc\nInjected: 1", false},
+		{"too long", strings.Repeat("a", maxLength+1), false},
+		{"non-ascii", "リクエスト", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromHeader(tt.value)
+			if tt.keep && got != tt.value {
+				t.Errorf("expected %q to be kept, got %q", tt.value, got)
+			}
+			if !tt.keep && (got == tt.value || !Valid(got)) {
+				t.Errorf("expected %q to be replaced by a generated ID, got %q", tt.value, got)
+			}
+		})
+	}
+}
+
+func TestNewIsUnique(t *testing.T) {
+	a, b := New(), New()
+	if a == b || len(a) != 32 || !Valid(a) {
+		t.Errorf("expected two distinct 32-character IDs, got %q and %q", a, b)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if id := FromContext(context.Background()); id != "" {
+		t.Errorf("expected no ID in an empty context, got %q", id)
+	}
+	ctx := NewContext(context.Background(), "abc")
+	if id := FromContext(ctx); id != "abc" {
+		t.Errorf("expected abc, got %q", id)
+	}
+}
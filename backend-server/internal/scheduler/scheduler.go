@@ -2,11 +2,14 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/worker"
 	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
 )
 
 type RequestProcessor struct {
@@ -17,6 +20,16 @@ type RequestProcessor struct {
 	cacheHandler    worker.CacheHandler
 	responseWatcher worker.ResponseWatcher // 修正: ポインタではなくインターフェース
 	cleanupInterval time.Duration
+	metrics         *metrics.Metrics
+
+	// prober・probeInterval・probeTimeout SetLinkProbeで設定した、地上局までのリンクを一定間隔で確かめるプローブ
+	prober        gateway.Prober
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+
+	// janitor・janitorInterval SetQueueJanitorで設定した、処理中のまま残った予約を一定間隔でキューに戻すハンドラー
+	janitor         worker.QueueJanitor
+	janitorInterval time.Duration
 }
 
 func NewRequestProcessor(
@@ -26,6 +39,7 @@ func NewRequestProcessor(
 	cacheHandler worker.CacheHandler,
 	responseWatcher worker.ResponseWatcher, // 修正: ポインタではなくインターフェース
 	cleanupInterval time.Duration,
+	metrics *metrics.Metrics,
 ) *RequestProcessor {
 	return &RequestProcessor{
 		workers:         workers,
@@ -35,14 +49,37 @@ func NewRequestProcessor(
 		cacheHandler:    cacheHandler,
 		responseWatcher: responseWatcher,
 		cleanupInterval: cleanupInterval,
+		metrics:         metrics,
 	}
 }
 
+// SetLinkProbe interval（0以下は送らない）ごとにproberでプローブを送り、timeoutまで応答を待つ（Startの前に呼ぶ）
+// 結果はリンクの状態のメトリクスに記録する。proberがサーキットブレーカーの場合は、ブレーカーの状態も更新する
+func (rp *RequestProcessor) SetLinkProbe(prober gateway.Prober, interval, timeout time.Duration) {
+	rp.prober = prober
+	rp.probeInterval = interval
+	rp.probeTimeout = timeout
+}
+
+// SetQueueJanitor interval（0以下は見回らない）ごとにjanitorで処理中のまま残った予約をキューに戻す（Startの前に呼ぶ）
+// 起動時にも、Workerを起動する前に1度見回る
+func (rp *RequestProcessor) SetQueueJanitor(janitor worker.QueueJanitor, interval time.Duration) {
+	rp.janitor = janitor
+	rp.janitorInterval = interval
+}
+
 func (rp *RequestProcessor) Start(ctx context.Context) {
-	// 0. すべてのキャッシュを削除（サーバ起動時のみ）
-	if err := rp.cacheHandler.DeleteAllCaches(ctx); err != nil {
-		log.Printf("[RequestProcessor] サーバ起動時のキャッシュ削除エラー: %v", err)
-		return
+	// 0. 前回の起動で保存したキャッシュを引き継ぎ、キャッシュ全体の使用量をキャッシュディレクトリのファイルと突き合わせて数え直す
+	// 停止中に変わったファイルの大きさを数え直し、容量の上限を下げた場合は最終アクセスの古いキャッシュから削除する
+	// 数え直せなくても、保存・削除のたびに加減した使用量で動き続ける
+	if result, err := rp.cacheHandler.ReconcileCacheUsage(ctx); err != nil {
+		log.Printf("[RequestProcessor] サーバ起動時のキャッシュ使用量の数え直しエラー: %v", err)
+	} else {
+		log.Printf("[RequestProcessor] キャッシュの使用量: %d bytes (%d件)", result.Bytes, result.Entries)
+	}
+	// 前回の起動で取り出したまま処理中に止まった予約を、Workerが予約を取り出す前に1度見回ってキューに戻す
+	if rp.janitor != nil {
+		rp.requeueStuckRequests(ctx)
 	}
 
 	// 1. Worker Poolを起動(リクエスト処理)
@@ -62,6 +99,18 @@ func (rp *RequestProcessor) Start(ctx context.Context) {
 	// 4. ResponseWatcherを起動
 	go rp.responseWatcher.Start(ctx)
 	log.Printf("[RequestProcessor] ResponseWatcherを起動しました")
+
+	// 5. リンクのプローブを起動
+	if rp.prober != nil && rp.probeInterval > 0 {
+		go rp.startLinkProbe(ctx)
+		log.Printf("[RequestProcessor] リンクのプローブを起動しました (interval: %v)", rp.probeInterval)
+	}
+
+	// 6. 処理中のまま残った予約の見回りを起動
+	if rp.janitor != nil && rp.janitorInterval > 0 {
+		go rp.startQueueJanitor(ctx)
+		log.Printf("[RequestProcessor] 処理中の予約の見回りを起動しました (interval: %v)", rp.janitorInterval)
+	}
 }
 
 func (rp *RequestProcessor) worker(ctx context.Context, id int) {
@@ -69,11 +118,17 @@ func (rp *RequestProcessor) worker(ctx context.Context, id int) {
 	defer log.Printf("[Worker %d] 終了しました", id)
 
 	for req := range rp.jobQueue {
-		log.Printf("[Worker %d] ジョブキューからリクエストを受信: %s", id, req.URL)
+		log.Printf("[Worker %d] ジョブキューからリクエストを受信: %s (RequestID: %s)", id, req.URL, req.RequestID)
+		// 予約してからワーカーが取り出すまでの待ち時間（予約時刻のない古い予約は記録しない）
+		if !req.ReservedAt.IsZero() {
+			rp.metrics.ObserveQueueWait(time.Since(req.ReservedAt))
+		}
 		// プラグイン可能なハンドラーを使用
-		if err := rp.reqhandler.HandleRequest(ctx, req, id); err != nil {
-			log.Printf("[Worker %d] リクエスト処理エラー (URL: %s): %v", id, req.URL, err)
+		err := rp.reqhandler.HandleRequest(ctx, req, id)
+		if err != nil {
+			log.Printf("[Worker %d] リクエスト処理エラー (URL: %s, RequestID: %s): %v", id, req.URL, req.RequestID, err)
 		}
+		rp.metrics.ObserveWorkerJob(err != nil)
 	}
 }
 
@@ -122,11 +177,86 @@ func (rp *RequestProcessor) startCacheCleanup(ctx context.Context) {
 			return
 		case <-ticker.C:
 			// プラグイン可能なキャッシュハンドラーを使用
-			if err := rp.cacheHandler.DeleteExpiredCaches(ctx); err != nil {
+			result, err := rp.cacheHandler.DeleteExpiredCaches(ctx)
+			if err != nil {
 				log.Printf("[Cache Cleanup] 期限切れキャッシュ削除エラー: %v", err)
 			} else {
-				log.Printf("[Cache Cleanup] 期限切れキャッシュを削除しました")
+				log.Printf("[Cache Cleanup] 期限切れキャッシュを削除しました: %d件（うちネガティブキャッシュ%d件、調べたメタデータ%d件）", result.Deleted, result.Negative, result.Scanned)
+				if !result.Complete {
+					// 1回に削除する数の上限に達した。残りは次の回に続きから削除する
+					log.Printf("[Cache Cleanup] 削除の上限に達したため、残りを次の回に削除します（調べた範囲の残り%d件）", result.Remaining)
+				}
+				rp.metrics.ObserveCacheCleanup(result.Deleted, result.Negative)
 			}
 		}
 	}
 }
+
+// startQueueJanitor 処理中のまま残った予約（処理中にWorkerが止まったものなど）を一定間隔でキューに戻す
+func (rp *RequestProcessor) startQueueJanitor(ctx context.Context) {
+	log.Printf("[Queue Janitor] 見回りを開始しました")
+	defer log.Printf("[Queue Janitor] 見回りを終了しました")
+
+	ticker := time.NewTicker(rp.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rp.requeueStuckRequests(ctx)
+		}
+	}
+}
+
+// requeueStuckRequests janitorで処理中のまま残った予約をキューに戻す（戻せなくても次の見回りで続ける）
+func (rp *RequestProcessor) requeueStuckRequests(ctx context.Context) {
+	requeued, err := rp.janitor.RequeueStuckRequests(ctx)
+	if err != nil {
+		log.Printf("[Queue Janitor] 処理中の予約を戻せません: %v", err)
+	} else if requeued > 0 {
+		log.Printf("[Queue Janitor] 処理中のまま残った予約をキューに戻しました: %d件", requeued)
+	}
+}
+
+func (rp *RequestProcessor) startLinkProbe(ctx context.Context) {
+	log.Printf("[Link Probe] プローブを開始しました")
+	defer log.Printf("[Link Probe] プローブを終了しました")
+
+	ticker := time.NewTicker(rp.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		if !rp.probeLink(ctx) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeLink プローブを1回送り、結果をメトリクスに記録する（ゲートウェイがプローブを送れない場合はfalse）
+func (rp *RequestProcessor) probeLink(ctx context.Context) bool {
+	probeCtx, cancel := context.WithTimeout(ctx, rp.probeTimeout)
+	defer cancel()
+
+	rtt, err := rp.prober.Probe(probeCtx)
+	switch {
+	case errors.Is(err, gateway.ErrProbeUnsupported):
+		log.Printf("[Link Probe] ゲートウェイがプローブに対応していないため停止します: %v", err)
+		return false
+	case ctx.Err() != nil:
+		// 終了するときのキャンセルはリンクの状態として記録しない
+	case err != nil:
+		log.Printf("[Link Probe] 地上局から応答がありません: %v", err)
+		rp.metrics.ObserveProbe(false, 0)
+	default:
+		log.Printf("[Link Probe] 地上局から応答がありました (RTT: %v)", rtt)
+		rp.metrics.ObserveProbe(true, rtt)
+	}
+	return true
+}
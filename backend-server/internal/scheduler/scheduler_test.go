@@ -0,0 +1,293 @@
+// scheduler_test.go - Worker Poolとリンクのプローブのメトリクス、起動時の予約とキャッシュの引き継ぎのテスト
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/interface/gateway"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/application/model"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/repository/plugins"
+	scheduler_worker "github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/infrastructure/worker"
+	"github.com/watanabetatsumi/ORF-2025-Space/backend-server/internal/metrics"
+)
+
+// failingRequestHandler URLが/failのリクエストの処理に失敗するハンドラー
+type failingRequestHandler struct{}
+
+func (failingRequestHandler) HandleRequest(ctx context.Context, req *model.BpRequest, workerID int) error {
+	if req.URL == "http://example.com/fail" {
+		return errors.New("gateway timeout")
+	}
+	return nil
+}
+
+func TestWorkerMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	rp := NewRequestProcessor(2, failingRequestHandler{}, nil, nil, nil, time.Minute, m)
+
+	rp.jobQueue <- &model.BpRequest{URL: "http://example.com/ok", ReservedAt: time.Now().Add(-90 * time.Second)}
+	rp.jobQueue <- &model.BpRequest{URL: "http://example.com/fail", ReservedAt: time.Now().Add(-90 * time.Second)}
+	rp.jobQueue <- &model.BpRequest{URL: "http://example.com/legacy"} // 予約時刻のない予約
+	close(rp.jobQueue)
+	rp.worker(context.Background(), 0)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	jobs := make(map[string]float64)
+	var waits uint64
+	var waitSum float64
+	for _, family := range families {
+		switch family.GetName() {
+		case "bp_proxy_worker_jobs_total":
+			for _, metric := range family.GetMetric() {
+				jobs[metric.GetLabel()[0].GetValue()] = metric.GetCounter().GetValue()
+			}
+		case "bp_proxy_worker_queue_wait_seconds":
+			waits = family.GetMetric()[0].GetHistogram().GetSampleCount()
+			waitSum = family.GetMetric()[0].GetHistogram().GetSampleSum()
+		}
+	}
+	if jobs["processed"] != 2 || jobs["failed"] != 1 {
+		t.Errorf("expected 2 processed and 1 failed job, got %v", jobs)
+	}
+	if waits != 2 {
+		t.Errorf("expected 2 queue wait observations, got %d", waits)
+	}
+	if waitSum < 180 {
+		t.Errorf("expected queue wait of at least 90s per job, got sum %v", waitSum)
+	}
+}
+
+// scriptedProber 決めた順にプローブの結果を返す（nilは1秒で応答が届いた）
+type scriptedProber struct {
+	results []error
+	calls   int
+}
+
+func (p *scriptedProber) Probe(ctx context.Context) (time.Duration, error) {
+	err := p.results[p.calls]
+	p.calls++
+	if err != nil {
+		return 0, err
+	}
+	return time.Second, nil
+}
+
+func TestProbeLink(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	prober := &scriptedProber{results: []error{nil, gateway.ErrTimeout, gateway.ErrProbeUnsupported}}
+	rp := NewRequestProcessor(1, failingRequestHandler{}, nil, nil, nil, time.Minute, m)
+	rp.SetLinkProbe(prober, time.Minute, time.Second)
+
+	linkUp := func() float64 {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+		for _, family := range families {
+			if family.GetName() == "bp_proxy_gateway_link_up" {
+				return family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		return -1
+	}
+
+	if !rp.probeLink(context.Background()) || linkUp() != 1 {
+		t.Errorf("Expected the link to be up after an answered probe, got %v", linkUp())
+	}
+	if !rp.probeLink(context.Background()) || linkUp() != 0 {
+		t.Errorf("Expected the link to be down after a timed out probe, got %v", linkUp())
+	}
+	// プローブを送れないゲートウェイでは、プローブを止める
+	if rp.probeLink(context.Background()) {
+		t.Error("Expected probing to stop for a gateway without probes")
+	}
+	if prober.calls != 3 {
+		t.Errorf("Expected 3 probes, got %d", prober.calls)
+	}
+}
+
+// idleQueueWatcher 予約を取り出さずにctxの終了まで待つ（起動後の予約キューをそのまま確かめるため）
+type idleQueueWatcher struct{}
+
+func (idleQueueWatcher) WatchQueue(ctx context.Context) (*model.BpRequest, error) {
+	<-ctx.Done()
+	return nil, nil
+}
+
+// idleResponseWatcher レスポンスを待たないResponseWatcher
+type idleResponseWatcher struct{}
+
+func (idleResponseWatcher) Start(ctx context.Context) {}
+
+// openBoltRepository dbPathのBoltDBとcacheDirでリポジトリを開く（テストの終了時に閉じる）
+func openBoltRepository(t *testing.T, dbPath, cacheDir string) (*plugins.BoltClient, *repository.BpRepository) {
+	t.Helper()
+	return openBoltRepositoryWithQuota(t, dbPath, cacheDir, 0)
+}
+
+// openBoltRepositoryWithQuota openBoltRepositoryと同じく開き、キャッシュ全体の容量の上限をmaxCacheSizeにする
+func openBoltRepositoryWithQuota(t *testing.T, dbPath, cacheDir string, maxCacheSize int64) (*plugins.BoltClient, *repository.BpRepository) {
+	t.Helper()
+	client, err := plugins.NewBoltClient(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open bolt db: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client, repository.NewBpRepository(client, cacheDir, 0, 0, maxCacheSize)
+}
+
+// startAfterRestart main.goと同じく、送信の記録を引き継いでからRequestProcessorを起動する
+func startAfterRestart(t *testing.T, ctx context.Context, bprepo *repository.BpRepository, configure func(rp *RequestProcessor)) {
+	t.Helper()
+	if _, err := scheduler_worker.RecoverSentRequests(ctx, bprepo, 0); err != nil {
+		t.Fatalf("RecoverSentRequests failed: %v", err)
+	}
+	rp := NewRequestProcessor(1, failingRequestHandler{}, idleQueueWatcher{}, scheduler_worker.NewCacheHandler(bprepo), idleResponseWatcher{}, time.Hour, nil)
+	if configure != nil {
+		configure(rp)
+	}
+	rp.Start(ctx)
+}
+
+func TestStartKeepsJournaledRequests(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bp.db")
+	cacheDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := &model.BpRequest{URL: "http://example.com/sent", Method: "GET"}
+
+	// 前回の起動: 予約を取り出してDTNへ送り、レスポンスを処理する前に終了した
+	client, bprepo := openBoltRepository(t, dbPath, cacheDir)
+	if _, err := bprepo.ReserveRequest(ctx, req); err != nil {
+		t.Fatalf("ReserveRequest failed: %v", err)
+	}
+	if popped, err := bprepo.BLPopReservedRequest(ctx, time.Second); err != nil || popped == nil {
+		t.Fatalf("expected to pop the reservation, got %v (%v)", popped, err)
+	}
+	if err := bprepo.JournalSentRequest(ctx, &model.SentRequest{RequestID: "req-1", Request: req, SentAt: time.Now()}); err != nil {
+		t.Fatalf("JournalSentRequest failed: %v", err)
+	}
+	_ = client.Close()
+
+	_, bprepo = openBoltRepository(t, dbPath, cacheDir)
+	startAfterRestart(t, ctx, bprepo, nil)
+
+	queued, err := bprepo.GetReservedRequests(ctx)
+	if err != nil {
+		t.Fatalf("GetReservedRequests failed: %v", err)
+	}
+	if len(queued) != 1 || queued[0].URL != req.URL {
+		t.Errorf("expected the journaled request to stay queued after Start, got %v", queued)
+	}
+	if _, found, err := bprepo.GetSentRequest(ctx, "req-1"); err != nil || !found {
+		t.Errorf("expected the sent request to survive Start, got found=%v err=%v", found, err)
+	}
+}
+
+func TestStartRequeuesStuckClaimsBeforeWorkers(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bp.db")
+	cacheDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := &model.BpRequest{URL: "http://example.com/claimed", Method: "GET"}
+
+	// 前回の起動: 予約を取り出したが、送る前に終了した（送信の記録がないため引き継ぎでは戻らない）
+	client, bprepo := openBoltRepository(t, dbPath, cacheDir)
+	if _, err := bprepo.ReserveRequest(ctx, req); err != nil {
+		t.Fatalf("ReserveRequest failed: %v", err)
+	}
+	if popped, err := bprepo.BLPopReservedRequest(ctx, time.Second); err != nil || popped == nil {
+		t.Fatalf("expected to pop the reservation, got %v (%v)", popped, err)
+	}
+	_ = client.Close()
+	time.Sleep(5 * time.Millisecond)
+
+	// 見回りの間隔は0（定期的には見回らない）にして、起動時の1度で戻ることを確かめる
+	_, bprepo = openBoltRepository(t, dbPath, cacheDir)
+	startAfterRestart(t, ctx, bprepo, func(rp *RequestProcessor) {
+		rp.SetQueueJanitor(scheduler_worker.NewQueueJanitor(bprepo, 0), 0)
+	})
+
+	queued, err := bprepo.GetReservedRequests(ctx)
+	if err != nil {
+		t.Fatalf("GetReservedRequests failed: %v", err)
+	}
+	if len(queued) != 1 || queued[0].URL != req.URL {
+		t.Errorf("expected the stuck claim to be requeued at startup, got %v", queued)
+	}
+}
+
+func TestStartReconcilesPersistedCaches(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bp.db")
+	cacheDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 前回の起動: 100バイトのキャッシュを3件、古い順に保存した
+	client, bprepo := openBoltRepository(t, dbPath, cacheDir)
+	var reqs []*model.BpRequest
+	for _, host := range []string{"a.example", "b.example", "c.example"} {
+		req := &model.BpRequest{Method: http.MethodGet, URL: "http://" + host + "/"}
+		resp := &model.BpResponse{StatusCode: http.StatusOK, Body: make([]byte, 100), ContentType: "application/octet-stream"}
+		if err := bprepo.SetResponseWithURL(ctx, req, resp, time.Hour); err != nil {
+			t.Fatalf("SetResponseWithURL failed: %v", err)
+		}
+		reqs = append(reqs, req)
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = client.Close()
+
+	// 停止中にc.exampleのファイルが150バイトになり、再起動では容量の上限を300バイトに下げた
+	err := filepath.WalkDir(filepath.Join(cacheDir, "c.example"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.WriteFile(path, make([]byte, 150), 0644)
+	})
+	if err != nil {
+		t.Fatalf("failed to grow the cache file: %v", err)
+	}
+	_, bprepo = openBoltRepositoryWithQuota(t, dbPath, cacheDir, 300)
+	startAfterRestart(t, ctx, bprepo, nil)
+
+	// 数え直すと350バイトで上限を超えるため、最終アクセスの最も古いa.exampleを削除する
+	usage, err := bprepo.GetCacheUsage(ctx)
+	if err != nil {
+		t.Fatalf("GetCacheUsage failed: %v", err)
+	}
+	if usage.Bytes != 250 || usage.Entries != 2 {
+		t.Errorf("expected 250 bytes in 2 entries after reconciling, got %+v", usage)
+	}
+	if usage.Evictions != 1 || usage.EvictedBytes != 100 {
+		t.Errorf("expected 1 eviction of 100 bytes, got %+v", usage)
+	}
+	for i, req := range reqs {
+		_, found, err := bprepo.GetResponse(ctx, req.GenerateCacheKey())
+		if err != nil {
+			t.Fatalf("GetResponse failed: %v", err)
+		}
+		if found != (i > 0) {
+			t.Errorf("expected %s cached=%v after restart, got %v", req.URL, i > 0, found)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 画像のプレースホルダーの大きさ（URLから推定できない場合はHTMLの画像の既定の大きさ）
+const (
+	defaultImageWidth  = 300
+	defaultImageHeight = 150
+	// maxImageDimension これより大きい値はURLの別の数字（IDなど）とみなして使わない
+	maxImageDimension = 4096
+)
+
+// 画像のプレースホルダーに表示する文言
+const (
+	ImagePlaceholderQueued    = "queued for DTN delivery"
+	ImagePlaceholderNotQueued = "not fetched over DTN"
+)
+
+// imageSizePattern パス・クエリの値に含まれる「幅x高さ」（photo_640x480.jpg、/640x480/、-300x200.png、size=640x480など）
+var imageSizePattern = regexp.MustCompile(`(?i)(?:^|[^0-9a-z])(\d{1,4})[x×](\d{1,4})(?:[^0-9]|$)`)
+
+// imageWidthParams・imageHeightParams 幅・高さを指定するクエリパラメータ（画像の変換サービスでよく使われるもの）
+var (
+	imageWidthParams  = []string{"width", "w"}
+	imageHeightParams = []string{"height", "h"}
+)
+
+// InferImageSize 画像のURLから表示される大きさを推定する
+// クエリの幅・高さ（width=640&height=480、w=640など）を優先し、なければパスの「幅x高さ」（photo_640x480.jpgなど）を使う
+// 幅・高さの一方だけ分かる場合は既定の縦横比で補い、どちらも分からない場合は既定の大きさ（300x150）を返す
+func InferImageSize(rawURL string) (width, height int) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return defaultImageWidth, defaultImageHeight
+	}
+	query := u.Query()
+	width = queryDimension(query, imageWidthParams)
+	height = queryDimension(query, imageHeightParams)
+
+	if width == 0 && height == 0 {
+		width, height = pathDimensions(u.Path)
+	}
+	if width == 0 && height == 0 {
+		for _, values := range query {
+			for _, value := range values {
+				if w, h := pathDimensions(value); w != 0 {
+					width, height = w, h
+				}
+			}
+		}
+	}
+
+	switch {
+	case width == 0 && height == 0:
+		return defaultImageWidth, defaultImageHeight
+	case height == 0:
+		height = max(1, width*defaultImageHeight/defaultImageWidth)
+	case width == 0:
+		width = max(1, height*defaultImageWidth/defaultImageHeight)
+	}
+	return width, height
+}
+
+// queryDimension クエリのnamesのいずれかから大きさを読む（読めない場合は0）
+func queryDimension(query url.Values, names []string) int {
+	for _, name := range names {
+		if n := parseDimension(query.Get(name)); n != 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// pathDimensions 文字列に含まれる最後の「幅x高さ」を読む（ない場合は0, 0）
+func pathDimensions(s string) (int, int) {
+	matches := imageSizePattern.FindAllStringSubmatch(s, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		width, height := parseDimension(matches[i][1]), parseDimension(matches[i][2])
+		if width != 0 && height != 0 {
+			return width, height
+		}
+	}
+	return 0, 0
+}
+
+// parseDimension 1からmaxImageDimensionまでの整数を読む（それ以外は0）
+func parseDimension(s string) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "px"))
+	if err != nil || n <= 0 || n > maxImageDimension {
+		return 0
+	}
+	return n
+}
+
+// ImagePlaceholderSVG 画像のキャッシュミスのときに返す、width×heightの灰色の四角に文言を表示するSVG
+// 元の画像と同じ大きさにしてページのレイアウトが崩れないようにする
+func ImagePlaceholderSVG(width, height int, label string) []byte {
+	// 文言が幅に収まる文字の大きさ（1文字あたり約0.55em）にし、高さの1/3は超えないようにする
+	fontSize := float64(width) / (float64(len(label))*0.55 + 2)
+	fontSize = min(fontSize, float64(height)/3)
+	escaped := html.EscapeString(label)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s">`+
+		`<rect width="100%%" height="100%%" fill="#e0e0e0"/>`+
+		`<text x="50%%" y="50%%" fill="#757575" font-family="sans-serif" font-size="%.1f" text-anchor="middle" dominant-baseline="middle">%s</text>`+
+		`</svg>`, width, height, width, height, escaped, fontSize, escaped))
+}
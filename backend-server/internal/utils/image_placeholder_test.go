@@ -0,0 +1,92 @@
+// image_placeholder_test.go - 画像のURLからの大きさの推定と、画像のプレースホルダーのSVGのテスト
+package utils
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestInferImageSize(t *testing.T) {
+	tests := []struct {
+		url           string
+		width, height int
+	}{
+		{"https://example.com/photo_640x480.jpg", 640, 480},
+		{"https://example.com/uploads/hero-1200X630.png", 1200, 630},
+		{"https://example.com/thumbs/320x240/cat.webp", 320, 240},
+		{"https://cdn.example.com/img.jpg?width=800&height=600", 800, 600},
+		{"https://cdn.example.com/img.jpg?w=400", 400, 200},
+		{"https://cdn.example.com/img.jpg?h=90px", 180, 90},
+		{"https://cdn.example.com/img.jpg?size=128x128", 128, 128},
+		// クエリの幅・高さはパスより優先する
+		{"https://example.com/a_640x480.jpg?w=100&h=50", 100, 50},
+		// 複数ある場合は最後のもの
+		{"https://example.com/1024x768/photo_64x48.png", 64, 48},
+		// 大きさに見えない数字・範囲外の値は使わない
+		{"https://example.com/photo.jpg", 300, 150},
+		{"https://example.com/2024x/photo.jpg", 300, 150},
+		{"https://example.com/photo_99999x480.jpg", 300, 150},
+		{"https://example.com/photo0x0.jpg", 300, 150},
+		{"https://example.com/img.jpg?w=-1&h=abc", 300, 150},
+		{"https://example.com/abc640x480.jpg", 300, 150},
+	}
+	for _, tt := range tests {
+		w, h := InferImageSize(tt.url)
+		if w != tt.width || h != tt.height {
+			t.Errorf("InferImageSize(%q): expected %dx%d, got %dx%d", tt.url, tt.width, tt.height, w, h)
+		}
+	}
+}
+
+func TestImagePlaceholderSVG(t *testing.T) {
+	svg := ImagePlaceholderSVG(640, 480, `queued <"DTN">`)
+
+	var root struct {
+		XMLName xml.Name `xml:"svg"`
+		Width   string   `xml:"width,attr"`
+		Height  string   `xml:"height,attr"`
+		ViewBox string   `xml:"viewBox,attr"`
+		Text    string   `xml:"text"`
+	}
+	if err := xml.Unmarshal(svg, &root); err != nil {
+		t.Fatalf("Expected valid XML, got %v:\n%s", err, svg)
+	}
+	if root.XMLName.Space != "http://www.w3.org/2000/svg" {
+		t.Errorf("Expected the SVG namespace, got %q", root.XMLName.Space)
+	}
+	if root.Width != "640" || root.Height != "480" || root.ViewBox != "0 0 640 480" {
+		t.Errorf("Expected 640x480, got width=%q height=%q viewBox=%q", root.Width, root.Height, root.ViewBox)
+	}
+	if root.Text != `queued <"DTN">` {
+		t.Errorf("Expected the label, got %q", root.Text)
+	}
+}
+
+func TestGetPlaceholderContentImage(t *testing.T) {
+	dir := t.TempDir()
+	body, contentType, err := GetPlaceholderContent("https://example.com/photo_320x200.png", dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/svg+xml" {
+		t.Errorf("Expected image/svg+xml, got %q", contentType)
+	}
+	if !strings.Contains(string(body), `width="320" height="200"`) || !strings.Contains(string(body), ImagePlaceholderQueued) {
+		t.Errorf("Expected a 320x200 queued placeholder, got %s", body)
+	}
+
+	body, _, _ = GetPlaceholderContent("https://example.com/icon.svg", dir, false)
+	if !strings.Contains(string(body), ImagePlaceholderNotQueued) {
+		t.Errorf("Expected the not-queued label, got %s", body)
+	}
+
+	// 画像以外は従来どおり
+	body, contentType, _ = GetPlaceholderContent("https://example.com/app.css", dir, true)
+	if contentType != "text/css; charset=utf-8" || len(body) == 0 {
+		t.Errorf("Expected the CSS placeholder, got %q %q", contentType, body)
+	}
+	if body, _, _ := GetPlaceholderContent("https://example.com/", dir, true); body != nil {
+		t.Errorf("Expected no placeholder for HTML, got %q", body)
+	}
+}
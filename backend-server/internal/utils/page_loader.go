@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -62,113 +63,108 @@ func FindProjectRoot() string {
 	return "."
 }
 
-// GetPlaceholderContent URLからコンテンツタイプを判定して適切なプレースホルダーを返す
-// defaultDir: デフォルトページとプレースホルダーファイルのディレクトリ
-// ファイルが存在する場合はファイルから読み込み、存在しない場合はコードで生成する
-func GetPlaceholderContent(url string, defaultDir string) ([]byte, string, error) {
-	urlLower := strings.ToLower(url)
-	projectRoot := FindProjectRoot()
-
-	// ディレクトリパスを解決
-	var dirPath string
+// ResolvePageDir デフォルトページのディレクトリのパスを解決する
+// 相対パスの場合はプロジェクトルートからの相対パスとして扱う
+func ResolvePageDir(defaultDir string) string {
 	if filepath.IsAbs(defaultDir) {
-		dirPath = defaultDir
-	} else {
-		dirPath = filepath.Join(projectRoot, defaultDir)
+		return defaultDir
 	}
+	return filepath.Join(FindProjectRoot(), defaultDir)
+}
 
-	// CSSファイル
-	if strings.HasSuffix(urlLower, ".css") || strings.Contains(urlLower, "/css/") {
-		filePath := filepath.Join(dirPath, "placeholder.css")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "text/css; charset=utf-8", nil
-		}
-		return []byte("/* CSS will be loaded from cache */"), "text/css; charset=utf-8", nil
-	}
+// placeholderFile コンテンツタイプごとのプレースホルダーのファイル名と、ファイルがない場合に返す内容
+// 画像はファイルがない場合、fallbackの代わりにURLから推定した大きさのSVGを生成する
+type placeholderFile struct {
+	name     string
+	fallback []byte
+}
 
-	// JavaScriptファイル
-	if strings.HasSuffix(urlLower, ".js") || strings.Contains(urlLower, "/js/") {
-		filePath := filepath.Join(dirPath, "placeholder.js")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "application/javascript; charset=utf-8", nil
-		}
-		return []byte("// JavaScript will be loaded from cache"), "application/javascript; charset=utf-8", nil
-	}
+// placeholderFiles InferContentTypeが返すコンテンツタイプごとのプレースホルダー（JSONなどここにないものはプレースホルダーを返さない）
+var placeholderFiles = map[string]placeholderFile{
+	"text/css; charset=utf-8":               {"placeholder.css", []byte("/* CSS will be loaded from cache */")},
+	"application/javascript; charset=utf-8": {"placeholder.js", []byte("// JavaScript will be loaded from cache")},
+	"image/png":                             {"placeholder.png", nil},
+	"image/jpeg":                            {"placeholder.jpg", nil},
+	"image/gif":                             {"placeholder.gif", nil},
+	"image/svg+xml":                         {"placeholder.svg", nil},
+	"image/webp":                            {"placeholder.webp", nil},
+	"image/x-icon":                          {"placeholder.ico", nil},
+	"font/woff":                             {"placeholder.woff", []byte{}},
+	"font/woff2":                            {"placeholder.woff2", []byte{}},
+	"font/ttf":                              {"placeholder.ttf", []byte{}},
+	"font/otf":                              {"placeholder.otf", []byte{}},
+}
 
+// suffixContentTypes パスの拡張子から推定するコンテンツタイプ
+var suffixContentTypes = []struct {
+	suffix      string
+	contentType string
+}{
 	// 画像ファイル（PNG、JPG、GIF、SVG、WebP、ICO）
-	if strings.HasSuffix(urlLower, ".png") {
-		filePath := filepath.Join(dirPath, "placeholder.png")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "image/png", nil
-		}
-		return []byte{}, "image/png", nil
-	}
-	if strings.HasSuffix(urlLower, ".jpg") || strings.HasSuffix(urlLower, ".jpeg") {
-		filePath := filepath.Join(dirPath, "placeholder.jpg")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "image/jpeg", nil
-		}
-		return []byte{}, "image/jpeg", nil
-	}
-	if strings.HasSuffix(urlLower, ".gif") {
-		filePath := filepath.Join(dirPath, "placeholder.gif")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "image/gif", nil
-		}
-		return []byte{}, "image/gif", nil
-	}
-	if strings.HasSuffix(urlLower, ".svg") {
-		filePath := filepath.Join(dirPath, "placeholder.svg")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "image/svg+xml", nil
-		}
-		return []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"></svg>`), "image/svg+xml", nil
+	{".png", "image/png"},
+	{".jpg", "image/jpeg"},
+	{".jpeg", "image/jpeg"},
+	{".gif", "image/gif"},
+	{".svg", "image/svg+xml"},
+	{".webp", "image/webp"},
+	{".ico", "image/x-icon"},
+	// フォントファイル（WOFF、WOFF2、TTF、OTF）
+	{".woff", "font/woff"},
+	{".woff2", "font/woff2"},
+	{".ttf", "font/ttf"},
+	{".otf", "font/otf"},
+	// JSON（APIのレスポンスなど）
+	{".json", "application/json; charset=utf-8"},
+}
+
+// InferContentType URLのパスから転送先のレスポンスのコンテンツタイプを推定する（推定できない場合、HTMLなどは空）
+// キャッシュミスのときに返すプレースホルダーや、JSONの状態を返すかの判断に使う
+func InferContentType(rawURL string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+	}
+	path = strings.ToLower(path)
+
+	// CSSファイル
+	if strings.HasSuffix(path, ".css") || strings.Contains(path, "/css/") {
+		return "text/css; charset=utf-8"
 	}
-	if strings.HasSuffix(urlLower, ".webp") {
-		filePath := filepath.Join(dirPath, "placeholder.webp")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "image/webp", nil
-		}
-		return []byte{}, "image/webp", nil
+	// JavaScriptファイル
+	if strings.HasSuffix(path, ".js") || strings.Contains(path, "/js/") {
+		return "application/javascript; charset=utf-8"
 	}
-	if strings.HasSuffix(urlLower, ".ico") {
-		filePath := filepath.Join(dirPath, "placeholder.ico")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "image/x-icon", nil
+	for _, s := range suffixContentTypes {
+		if strings.HasSuffix(path, s.suffix) {
+			return s.contentType
 		}
-		return []byte{}, "image/x-icon", nil
 	}
+	return ""
+}
 
-	// フォントファイル（WOFF、WOFF2、TTF、OTF）
-	if strings.HasSuffix(urlLower, ".woff") {
-		filePath := filepath.Join(dirPath, "placeholder.woff")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "font/woff", nil
-		}
-		return []byte{}, "font/woff", nil
-	}
-	if strings.HasSuffix(urlLower, ".woff2") {
-		filePath := filepath.Join(dirPath, "placeholder.woff2")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "font/woff2", nil
-		}
-		return []byte{}, "font/woff2", nil
-	}
-	if strings.HasSuffix(urlLower, ".ttf") {
-		filePath := filepath.Join(dirPath, "placeholder.ttf")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "font/ttf", nil
-		}
-		return []byte{}, "font/ttf", nil
+// GetPlaceholderContent URLからコンテンツタイプを判定して適切なプレースホルダーを返す
+// defaultDir: デフォルトページとプレースホルダーファイルのディレクトリ
+// ファイルが存在する場合はファイルから読み込み、存在しない場合はコードで生成する
+// 画像はURLから推定した大きさの灰色の四角のSVG（image/svg+xml）を生成し、queued（DTNへ予約したか）に応じた文言を表示する
+func GetPlaceholderContent(rawURL string, defaultDir string, queued bool) ([]byte, string, error) {
+	contentType := InferContentType(rawURL)
+	placeholder, ok := placeholderFiles[contentType]
+	if !ok {
+		// その他（HTMLなど）はnilを返して、呼び出し元でデフォルトページを読み込む
+		return nil, "", nil
 	}
-	if strings.HasSuffix(urlLower, ".otf") {
-		filePath := filepath.Join(dirPath, "placeholder.otf")
-		if data, err := os.ReadFile(filePath); err == nil {
-			return data, "font/otf", nil
+
+	filePath := filepath.Join(ResolvePageDir(defaultDir), placeholder.name)
+	if data, err := os.ReadFile(filePath); err == nil {
+		return data, contentType, nil
+	}
+	if strings.HasPrefix(contentType, "image/") {
+		label := ImagePlaceholderNotQueued
+		if queued {
+			label = ImagePlaceholderQueued
 		}
-		return []byte{}, "font/otf", nil
+		width, height := InferImageSize(rawURL)
+		return ImagePlaceholderSVG(width, height, label), "image/svg+xml", nil
 	}
-
-	// その他（HTMLなど）はnilを返して、呼び出し元でデフォルトページを読み込む
-	return nil, "", nil
+	return placeholder.fallback, contentType, nil
 }
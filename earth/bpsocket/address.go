@@ -1,9 +1,11 @@
-// address.go - IPNアドレス（ipn:node.service）の構造体と変換処理
+// address.go - BPエンドポイントID（ipn:node.service / dtn://node/service）の構造体と変換処理
 package bpsocket
 
 import (
+	"encoding/binary"
 	"fmt"
-	"unsafe"
+	"strconv"
+	"strings"
 )
 
 // カーネルモジュールのsockaddr_bp構造体に対応
@@ -17,21 +19,77 @@ import (
 //	      uint32_t node_id;
 //	      uint32_t service_id;
 //	    } ipn;
+//	    struct {
+//	      char uri[BP_DTN_URI_MAX];  // NUL終端
+//	    } dtn;
 //	  } bp_addr;
 //	};
+//
+// ipnスキームの場合はunionのうちipnメンバー分のみ（16バイト）、dtnスキームの場合はuri分まで含めて渡す
 type SockaddrBP struct {
 	Family  uint16 // bp_family (AF_BP = 28)
-	Scheme  int32  // bp_scheme (BP_SCHEME_IPN = 1)
+	Scheme  int32  // bp_scheme (BP_SCHEME_IPN = 1, BP_SCHEME_DTN = 2)
 	NodeNum uint32 // bp_addr.ipn.node_id
 	SvcNum  uint32 // bp_addr.ipn.service_id
+	URI     string // bp_addr.dtn.uri（"dtn://node/service"）
 }
 
+// sockaddr_bpの各フィールドのオフセットとサイズ
+const (
+	sockaddrSchemeOffset = 4 // bp_familyの後に2バイトのパディング
+	sockaddrAddrOffset   = 8
+	sockaddrIPNSize      = sockaddrAddrOffset + 8
+	sockaddrDTNSize      = sockaddrAddrOffset + BP_DTN_URI_MAX
+	sockaddrMaxSize      = sockaddrDTNSize
+)
+
+// ToBytes カーネルに渡すsockaddr_bpのバイト列を作成する
 func (sa *SockaddrBP) ToBytes() []byte {
-	size := int(unsafe.Sizeof(*sa))
-	ptr := unsafe.Pointer(sa)
-	return (*[unsafe.Sizeof(SockaddrBP{})]byte)(ptr)[:size:size]
+	var buf []byte
+	if sa.Scheme == BP_SCHEME_DTN {
+		buf = make([]byte, sockaddrDTNSize)
+		// 末尾はNUL終端として残す（ParseEIDで長さを検証済み）
+		copy(buf[sockaddrAddrOffset:sockaddrDTNSize-1], sa.URI)
+	} else {
+		buf = make([]byte, sockaddrIPNSize)
+		binary.NativeEndian.PutUint32(buf[sockaddrAddrOffset:], sa.NodeNum)
+		binary.NativeEndian.PutUint32(buf[sockaddrAddrOffset+4:], sa.SvcNum)
+	}
+	binary.NativeEndian.PutUint16(buf[0:], sa.Family)
+	binary.NativeEndian.PutUint32(buf[sockaddrSchemeOffset:], uint32(sa.Scheme))
+	return buf
+}
+
+// parseSockaddrBP カーネルから受け取ったsockaddr_bpのバイト列を解釈する
+func parseSockaddrBP(raw []byte) (*SockaddrBP, error) {
+	if len(raw) < sockaddrAddrOffset {
+		return nil, fmt.Errorf("sockaddr_bp too short: %d bytes", len(raw))
+	}
+	sa := &SockaddrBP{
+		Family: binary.NativeEndian.Uint16(raw[0:]),
+		Scheme: int32(binary.NativeEndian.Uint32(raw[sockaddrSchemeOffset:])),
+	}
+
+	switch sa.Scheme {
+	case BP_SCHEME_IPN:
+		if len(raw) < sockaddrIPNSize {
+			return nil, fmt.Errorf("sockaddr_bp too short for ipn: %d bytes", len(raw))
+		}
+		sa.NodeNum = binary.NativeEndian.Uint32(raw[sockaddrAddrOffset:])
+		sa.SvcNum = binary.NativeEndian.Uint32(raw[sockaddrAddrOffset+4:])
+	case BP_SCHEME_DTN:
+		uri := raw[sockaddrAddrOffset:]
+		if i := strings.IndexByte(string(uri), 0); i >= 0 {
+			uri = uri[:i]
+		}
+		sa.URI = string(uri)
+	default:
+		return nil, fmt.Errorf("unknown bp scheme: %d", sa.Scheme)
+	}
+	return sa, nil
 }
 
+// NewSockaddrBP ipnスキームのアドレスを作成
 func NewSockaddrBP(nodeNum, svcNum uint64) *SockaddrBP {
 	return &SockaddrBP{
 		Family:  AF_BP,
@@ -41,6 +99,60 @@ func NewSockaddrBP(nodeNum, svcNum uint64) *SockaddrBP {
 	}
 }
 
+// ParseEID エンドポイントIDの文字列（"ipn:150.1" または "dtn://earth/crawler"）からアドレスを作成
+func ParseEID(eid string) (*SockaddrBP, error) {
+	switch {
+	case strings.HasPrefix(eid, "ipn:"):
+		return parseIPNEID(eid)
+	case strings.HasPrefix(eid, "dtn://"):
+		return parseDTNEID(eid)
+	default:
+		return nil, fmt.Errorf("invalid EID %q: unsupported scheme (expected ipn: or dtn://)", eid)
+	}
+}
+
+func parseIPNEID(eid string) (*SockaddrBP, error) {
+	node, svc, ok := strings.Cut(strings.TrimPrefix(eid, "ipn:"), ".")
+	if !ok {
+		return nil, fmt.Errorf("invalid EID %q: expected ipn:<node>.<service>", eid)
+	}
+	nodeNum, err := strconv.ParseUint(node, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EID %q: bad node number: %w", eid, err)
+	}
+	svcNum, err := strconv.ParseUint(svc, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EID %q: bad service number: %w", eid, err)
+	}
+	return NewSockaddrBP(nodeNum, svcNum), nil
+}
+
+func parseDTNEID(eid string) (*SockaddrBP, error) {
+	if len(eid) >= BP_DTN_URI_MAX {
+		return nil, fmt.Errorf("invalid EID %q: longer than %d bytes", eid, BP_DTN_URI_MAX-1)
+	}
+	node, service, ok := strings.Cut(strings.TrimPrefix(eid, "dtn://"), "/")
+	if node == "" {
+		return nil, fmt.Errorf("invalid EID %q: empty node name", eid)
+	}
+	if !ok || service == "" {
+		return nil, fmt.Errorf("invalid EID %q: expected dtn://<node>/<service>", eid)
+	}
+	for _, c := range eid {
+		if c <= ' ' || c == 0x7f {
+			return nil, fmt.Errorf("invalid EID %q: contains whitespace or control characters", eid)
+		}
+	}
+	return &SockaddrBP{
+		Family: AF_BP,
+		Scheme: BP_SCHEME_DTN,
+		URI:    eid,
+	}, nil
+}
+
 func (sa *SockaddrBP) String() string {
+	if sa.Scheme == BP_SCHEME_DTN {
+		return sa.URI
+	}
 	return fmt.Sprintf("ipn:%d.%d", sa.NodeNum, sa.SvcNum)
 }
@@ -0,0 +1,118 @@
+// address_test.go - EIDの解析とsockaddr_bpの変換のテスト
+package bpsocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEIDRoundTrip(t *testing.T) {
+	for _, eid := range []string{"ipn:150.1", "ipn:0.0", "ipn:4294967295.7", "dtn://earth/crawler", "dtn://moon/cache/v2"} {
+		addr, err := ParseEID(eid)
+		if err != nil {
+			t.Errorf("ParseEID(%q) failed: %v", eid, err)
+			continue
+		}
+		if got := addr.String(); got != eid {
+			t.Errorf("ParseEID(%q).String() = %q", eid, got)
+		}
+	}
+}
+
+func TestParseEIDSetsScheme(t *testing.T) {
+	ipn, err := ParseEID("ipn:149.2")
+	if err != nil {
+		t.Fatalf("ParseEID failed: %v", err)
+	}
+	if ipn.Scheme != BP_SCHEME_IPN || ipn.NodeNum != 149 || ipn.SvcNum != 2 {
+		t.Errorf("Unexpected ipn address: %+v", ipn)
+	}
+
+	dtn, err := ParseEID("dtn://earth/crawler")
+	if err != nil {
+		t.Fatalf("ParseEID failed: %v", err)
+	}
+	if dtn.Scheme != BP_SCHEME_DTN || dtn.URI != "dtn://earth/crawler" {
+		t.Errorf("Unexpected dtn address: %+v", dtn)
+	}
+}
+
+func TestParseEIDRejectsMalformed(t *testing.T) {
+	for _, eid := range []string{
+		"",
+		"150.1",
+		"ipn:",
+		"ipn:150",
+		"ipn:150.",
+		"ipn:a.1",
+		"ipn:1.2.3",
+		"ipn:-1.2",
+		"ipn:4294967296.1",
+		"dtn://",
+		"dtn:///crawler",
+		"dtn://earth",
+		"dtn://earth/",
+		"dtn://earth/craw ler",
+		"dtn:none",
+		"http://earth/crawler",
+		"dtn://earth/" + strings.Repeat("x", BP_DTN_URI_MAX),
+	} {
+		if addr, err := ParseEID(eid); err == nil {
+			t.Errorf("ParseEID(%q) = %v, expected an error", eid, addr)
+		}
+	}
+}
+
+func TestSockaddrBytesRoundTrip(t *testing.T) {
+	ipn := NewSockaddrBP(150, 1)
+	raw := ipn.ToBytes()
+	if len(raw) != 16 {
+		t.Errorf("Expected the ipn sockaddr to keep its 16-byte layout, got %d bytes", len(raw))
+	}
+	if got, err := parseSockaddrBP(raw); err != nil || *got != *ipn {
+		t.Errorf("ipn round trip: got %+v, %v", got, err)
+	}
+
+	dtn, _ := ParseEID("dtn://earth/crawler")
+	raw = dtn.ToBytes()
+	if len(raw) != sockaddrDTNSize {
+		t.Errorf("Expected %d bytes for dtn sockaddr, got %d", sockaddrDTNSize, len(raw))
+	}
+	if got, err := parseSockaddrBP(raw); err != nil || *got != *dtn {
+		t.Errorf("dtn round trip: got %+v, %v", got, err)
+	}
+}
+
+func TestLoopbackWithDTNEIDs(t *testing.T) {
+	network := NewLoopbackNetwork()
+	recvTransport, err := network.ListenEID("dtn://moon/gateway")
+	if err != nil {
+		t.Fatalf("ListenEID failed: %v", err)
+	}
+	sendTransport, err := network.ListenEID("dtn://earth/crawler")
+	if err != nil {
+		t.Fatalf("ListenEID failed: %v", err)
+	}
+
+	receiver := NewBpReceiverWithTransport(recvTransport)
+	receiver.Start()
+	defer receiver.Close()
+	sender := NewBpSenderWithTransportAddr(sendTransport, recvTransport.LocalAddr())
+	defer sender.Close()
+
+	if err := sender.Send(context.Background(), "hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case bundle := <-receiver.GetDataChannel():
+		defer bundle.Release()
+		if bundle.From.String() != "dtn://earth/crawler" {
+			t.Errorf("Expected bundle from dtn://earth/crawler, got %s", bundle.From.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bundle was not delivered")
+	}
+}
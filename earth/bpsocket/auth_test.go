@@ -0,0 +1,256 @@
+// auth_test.go - HMACによるバンドル認証のテスト
+package bpsocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testKeyOld = []byte("old-pre-shared-key")
+	testKeyNew = []byte("new-pre-shared-key")
+)
+
+// sendSigned ipn:150.2からipn:149.1へ、指定したオプションのSenderでバンドルを送信する
+func sendSigned(t *testing.T, network *LoopbackNetwork, data interface{}, opts ...SenderOption) {
+	t.Helper()
+	transport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sender := NewBpSenderWithTransport(transport, 149, 1, opts...)
+	defer sender.Close()
+	if err := sender.Send(context.Background(), data); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+}
+
+func TestSignedBundleAcceptedByVerifyingReceiver(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithHMACVerification(true, testKeyNew))
+
+	sendSigned(t, network, "signed", WithHMACKey(testKeyNew), WithSequenceNumbers())
+
+	data, ok := receiveWithin(t, r, time.Second)
+	if !ok || string(data) != `"signed"` {
+		t.Fatalf("Expected the signed bundle, got %q (ok=%v)", data, ok)
+	}
+	if n := r.AuthFailures(); n != 0 {
+		t.Errorf("Expected no auth failures, got %d", n)
+	}
+}
+
+func TestSignedBundleAcceptedByNonVerifyingReceiver(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network)
+
+	sendSigned(t, network, "signed", WithHMACKey(testKeyNew))
+
+	if data, ok := receiveWithin(t, r, time.Second); !ok || string(data) != `"signed"` {
+		t.Fatalf("Expected the MAC to be ignored without verification, got %q (ok=%v)", data, ok)
+	}
+}
+
+func TestUnsignedBundleAcceptedWhenVerificationOptional(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithHMACVerification(false, testKeyNew))
+
+	sendSigned(t, network, "legacy")
+
+	if data, ok := receiveWithin(t, r, time.Second); !ok || string(data) != `"legacy"` {
+		t.Fatalf("Expected the unsigned bundle to be accepted, got %q (ok=%v)", data, ok)
+	}
+}
+
+func TestUnsignedBundleRejectedWhenVerificationRequired(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithHMACVerification(true, testKeyNew))
+
+	sendSigned(t, network, "legacy")
+
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Fatal("Expected the unsigned bundle to be rejected")
+	}
+	if n := r.AuthFailures(); n != 1 {
+		t.Errorf("Expected 1 auth failure, got %d", n)
+	}
+	select {
+	case ev := <-r.Events():
+		if ev.Type != EventAuthFailed || ev.Reason != errMACMissing.Error() {
+			t.Errorf("Unexpected event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected an AuthFailed event")
+	}
+}
+
+func TestTamperedBundleRejected(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithHMACVerification(false, testKeyNew))
+
+	sender := newBpSender(nil, NewSockaddrBP(149, 1))
+	WithHMACKey(testKeyNew)(sender)
+	bundle, _, err := sender.encodeBundle([]byte(`{"url":"https://example.com"}`))
+	if err != nil {
+		t.Fatalf("encodeBundle failed: %v", err)
+	}
+	bundle[len(bundle)-3] ^= 0xff
+
+	transport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer transport.Close()
+	if err := transport.SendTo(bundle, NewSockaddrBP(149, 1)); err != nil {
+		t.Fatalf("SendTo failed: %v", err)
+	}
+
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Fatal("Expected the tampered bundle to be rejected even when MACs are optional")
+	}
+	if n := r.AuthFailures(); n != 1 {
+		t.Errorf("Expected 1 auth failure, got %d", n)
+	}
+	select {
+	case ev := <-r.Events():
+		if ev.Type != EventAuthFailed || ev.Reason != errMACMismatch.Error() {
+			t.Errorf("Unexpected event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected an AuthFailed event")
+	}
+}
+
+func TestKeyRotationAcceptsOldAndNewKeys(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithHMACVerification(true, testKeyNew, testKeyOld))
+
+	sendSigned(t, network, "old", WithHMACKey(testKeyOld))
+	sendSigned(t, network, "new", WithHMACKey(testKeyNew))
+	sendSigned(t, network, "unknown", WithHMACKey([]byte("attacker-key")))
+
+	for _, want := range []string{`"old"`, `"new"`} {
+		if data, ok := receiveWithin(t, r, time.Second); !ok || string(data) != want {
+			t.Fatalf("Expected %s, got %q (ok=%v)", want, data, ok)
+		}
+	}
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Error("Expected the bundle signed with an unknown key to be rejected")
+	}
+	if n := r.AuthFailures(); n != 1 {
+		t.Errorf("Expected 1 auth failure, got %d", n)
+	}
+}
+
+func TestMACCoversSequenceNumber(t *testing.T) {
+	sender := newBpSender(nil, NewSockaddrBP(149, 1))
+	WithHMACKey(testKeyNew)(sender)
+	WithSequenceNumbers()(sender)
+	bundle, _, err := sender.encodeBundle([]byte(`"payload"`))
+	if err != nil {
+		t.Fatalf("encodeBundle failed: %v", err)
+	}
+
+	// シーケンス番号だけを書き換えたリプレイも検出できる
+	bundle[envelopeHeaderSize] ^= 0x01
+	env, err := decodeEnvelope(bundle)
+	if err != nil {
+		t.Fatalf("decodeEnvelope failed: %v", err)
+	}
+	v := &authVerifier{keys: [][]byte{testKeyNew}}
+	if v.verify(env) {
+		t.Error("Expected a modified sequence number to invalidate the MAC")
+	}
+}
+
+func TestUnauthenticatedAckNotDispatched(t *testing.T) {
+	transport, err := NewLoopbackNetwork().Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	r := NewBpReceiverWithTransport(transport, WithHMACVerification(true, testKeyNew, testKeyOld))
+	defer r.Close()
+	var acked, ponged []uint64
+	r.onAck = func(seq uint64) { acked = append(acked, seq) }
+	r.onPong = func(id uint64) { ponged = append(ponged, id) }
+	from := NewSockaddrBP(150, 2)
+
+	// 署名のない・別の鍵で署名したACK・pongは送信側に渡さない
+	for _, bundle := range [][]byte{encodeAck(1, nil), encodeAck(2, []byte("forged-key")), encodePong(3, nil)} {
+		if _, err := r.decodeBundle(bundle, from); errors.Is(err, errControlBundle) {
+			t.Errorf("Expected the unauthenticated control bundle to be rejected, got %v", err)
+		}
+	}
+	if len(acked) != 0 || len(ponged) != 0 {
+		t.Errorf("Expected no control bundles to be dispatched, got acks=%v pongs=%v", acked, ponged)
+	}
+	if n := r.AuthFailures(); n != 3 {
+		t.Errorf("Expected 3 auth failures, got %d", n)
+	}
+
+	// ローテーション中の古い鍵で署名したものは受け入れる
+	for _, bundle := range [][]byte{encodeAck(4, testKeyOld), encodePong(5, testKeyNew)} {
+		if _, err := r.decodeBundle(bundle, from); !errors.Is(err, errControlBundle) {
+			t.Errorf("Expected the signed control bundle to be consumed, got %v", err)
+		}
+	}
+	if len(acked) != 1 || acked[0] != 4 || len(ponged) != 1 || ponged[0] != 5 {
+		t.Errorf("Expected ack 4 and pong 5 to be dispatched, got acks=%v pongs=%v", acked, ponged)
+	}
+}
+
+func TestSignedReliableSendAcknowledgedWithSenderKey(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithHMACVerification(true, testKeyNew, testKeyOld))
+
+	// 受信側は送信側が使った古い鍵でACKに署名するため、送信側はACKを検証できる
+	sendSigned(t, network, "signed", WithHMACKey(testKeyOld), WithReliability(50*time.Millisecond, 3))
+	if data, ok := receiveWithin(t, r, time.Second); !ok || string(data) != `"signed"` {
+		t.Errorf("Expected the signed bundle, got %q (ok=%v)", data, ok)
+	}
+}
+
+func TestUnsignedAckIgnoredBySigningSender(t *testing.T) {
+	network := NewLoopbackNetwork()
+	// 検証しない受信側のACKは署名されないため、鍵を持つ送信側は受け入れない
+	newFilteredReceiver(t, network)
+
+	transport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sender := NewBpSenderWithTransport(transport, 149, 1, WithHMACKey(testKeyNew), WithReliability(20*time.Millisecond, 2))
+	defer sender.Close()
+	if err := sender.Send(context.Background(), "payload"); !errors.Is(err, ErrNotAcknowledged) {
+		t.Fatalf("Expected ErrNotAcknowledged, got %v", err)
+	}
+}
+
+// TestSignedResponseContract 地上局が署名したレスポンスのバンドルが、バックエンドのテストで検証するtestdata/dtn/response_signed.binと一致する
+// 形式や署名の対象を変える場合は、バックエンドのゲートウェイ（envelope.go）も合わせて変える
+func TestSignedResponseContract(t *testing.T) {
+	dir := filepath.Join("..", "..", "testdata", "dtn")
+	payload, err := os.ReadFile(filepath.Join(dir, "response.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join(dir, "response_signed.bin"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+
+	sender := newBpSender(nil, NewSockaddrBP(149, 1))
+	WithHMACKey([]byte("dtn-shared-hmac-key"))(sender)
+	bundle, _, err := sender.encodeBundle(payload)
+	if err != nil {
+		t.Fatalf("encodeBundle failed: %v", err)
+	}
+	if !bytes.Equal(bundle, want) {
+		t.Errorf("signed response drifted from testdata/dtn/response_signed.bin (got %d bytes, want %d)", len(bundle), len(want))
+	}
+}
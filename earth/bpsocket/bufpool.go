@@ -0,0 +1,114 @@
+// bufpool.go - 受信バンドル用のバッファプール
+// バンドルごとにmake([]byte, n)でコピーするとバースト時にGC負荷が高くなるため、
+// サイズ別の階層に分けたsync.Poolからバッファを払い出し、利用者がReleaseで返却する
+package bpsocket
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// bufferTiers プールするバッファサイズの階層（最大は受信側の最大バンドルサイズに置き換える）
+var bufferTiers = []int{4 * 1024, 64 * 1024, 512 * 1024, DefaultMaxBundleSize}
+
+// bufferPool サイズ階層ごとのsync.Pool
+type bufferPool struct {
+	tiers []int
+	pools []sync.Pool
+
+	// outstanding 払い出し中（未返却）のバッファ数
+	outstanding atomic.Int64
+}
+
+func newBufferPool(tiers []int) *bufferPool {
+	p := &bufferPool{
+		tiers: tiers,
+		pools: make([]sync.Pool, len(tiers)),
+	}
+	for i, size := range tiers {
+		size := size
+		p.pools[i].New = func() any {
+			b := make([]byte, size)
+			return &b
+		}
+	}
+	return p
+}
+
+// tierFor n バイトを格納できる最小の階層を返す（収まらない場合は-1）
+func (p *bufferPool) tierFor(n int) int {
+	for i, size := range p.tiers {
+		if n <= size {
+			return i
+		}
+	}
+	return -1
+}
+
+// get n バイト以上の容量を持つバッファを取得する
+// 最大階層を超えるサイズはプールせずに割り当てる
+func (p *bufferPool) get(n int) *[]byte {
+	p.outstanding.Add(1)
+	tier := p.tierFor(n)
+	if tier < 0 {
+		b := make([]byte, n)
+		return &b
+	}
+	return p.pools[tier].Get().(*[]byte)
+}
+
+// put バッファをプールに返却する
+func (p *bufferPool) put(b *[]byte) {
+	p.outstanding.Add(-1)
+	tier := p.tierFor(cap(*b))
+	if tier < 0 || p.tiers[tier] != cap(*b) {
+		return
+	}
+	*b = (*b)[:cap(*b)]
+	p.pools[tier].Put(b)
+}
+
+// Bundle 受信したバンドル
+// Dataはプールされたバッファを参照するため、処理が終わったら必ずReleaseを呼ぶこと
+// Release後にDataへアクセスしてはならない
+type Bundle struct {
+	Data []byte
+	From *SockaddrBP
+
+	// Truncated バンドルが受信バッファに収まらず、Dataは先頭部分のみ（エンベロープも未解釈）
+	Truncated bool
+	// Size 送信されたバンドルの実際のサイズ
+	Size int
+	// Limit 受信側の最大バンドルサイズ（Truncatedの場合のみ設定される）
+	Limit int
+
+	buf      *[]byte
+	pool     *bufferPool
+	released atomic.Bool
+}
+
+// newPooledBundle プールから取得したバッファにdataをコピーしたBundleを作成する
+func newPooledBundle(pool *bufferPool, data []byte, from *SockaddrBP) *Bundle {
+	buf := pool.get(len(data))
+	n := copy(*buf, data)
+	return &Bundle{Data: (*buf)[:n], From: from, Size: n, buf: buf, pool: pool}
+}
+
+// Err バンドルを正しく受信できなかった場合にエラーを返す（切り詰められた場合は*TruncatedError）
+func (b *Bundle) Err() error {
+	if b.Truncated {
+		return &TruncatedError{Size: b.Size, Limit: b.Limit}
+	}
+	return nil
+}
+
+// Release バッファをプールに返却する（複数回呼んでも安全）
+func (b *Bundle) Release() {
+	if b.released.Swap(true) {
+		return
+	}
+	b.Data = nil
+	if b.pool != nil && b.buf != nil {
+		b.pool.put(b.buf)
+	}
+}
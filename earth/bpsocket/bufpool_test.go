@@ -0,0 +1,91 @@
+// bufpool_test.go - 受信バッファプールのテストとベンチマーク
+package bpsocket
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBufferPoolSelectsSmallestTier(t *testing.T) {
+	p := newBufferPool([]int{16, 64})
+
+	for _, tc := range []struct{ n, wantCap int }{{1, 16}, {16, 16}, {17, 64}, {100, 100}} {
+		b := p.get(tc.n)
+		if cap(*b) != tc.wantCap {
+			t.Errorf("get(%d): expected cap %d, got %d", tc.n, tc.wantCap, cap(*b))
+		}
+		p.put(b)
+	}
+	if n := p.outstanding.Load(); n != 0 {
+		t.Errorf("Expected no outstanding buffers, got %d", n)
+	}
+}
+
+func TestReceiverReturnsBuffersOnRelease(t *testing.T) {
+	network := NewLoopbackNetwork()
+	recvTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sendTransport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	receiver := NewBpReceiverWithTransport(recvTransport)
+	receiver.Start()
+	defer receiver.Close()
+	sender := NewBpSenderWithTransport(sendTransport, 149, 1)
+	defer sender.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := sender.Send(context.Background(), map[string]int{"i": i}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		select {
+		case bundle := <-receiver.GetDataChannel():
+			bundle.Release()
+			bundle.Release() // 二重のReleaseで計数が狂わないこと
+		case <-time.After(time.Second):
+			t.Fatalf("Bundle %d was not delivered", i)
+		}
+	}
+
+	if n := receiver.pool.outstanding.Load(); n != 0 {
+		t.Errorf("Expected every buffer to be returned, %d still outstanding", n)
+	}
+}
+
+// benchPayload 一般的なHTMLレスポンス程度（32KB）のバンドル
+var benchPayload = append([]byte(`{"request_id":"bench","body":"`), append(bytes.Repeat([]byte("x"), 32*1024), `"}`...)...)
+
+var benchSink []byte
+
+// BenchmarkDecodeBundleCopy 変更前と同じく、バンドルごとに新しいスライスへコピーする
+func BenchmarkDecodeBundleCopy(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data := make([]byte, len(benchPayload))
+		copy(data, benchPayload)
+		benchSink = data
+	}
+}
+
+// BenchmarkDecodeBundlePooled プールされたバッファへコピーし、処理後に返却する
+func BenchmarkDecodeBundlePooled(b *testing.B) {
+	r := newBpReceiver(nil)
+	from := NewSockaddrBP(150, 2)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bundle, err := r.decodeBundle(benchPayload, from)
+		if err != nil {
+			b.Fatal(err)
+		}
+		bundle.Release()
+	}
+}
@@ -0,0 +1,113 @@
+// bundleopts.go - バンドルごとの有効期間と優先度の指定
+package bpsocket
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Priority バンドルの優先度（BPのクラスオブサービス）
+type Priority int
+
+const (
+	PriorityDefault   Priority = iota // 指定しない（送信側の既定値を使う）
+	PriorityBulk                      // プリフェッチなど急がないデータ
+	PriorityNormal                    // 通常
+	PriorityExpedited                 // 対話的なリクエストへの応答
+)
+
+// kernelValue カーネルモジュールのBP_PRIORITY_*に変換する
+func (p Priority) kernelValue() (int, bool) {
+	switch p {
+	case PriorityBulk:
+		return BP_PRIORITY_BULK, true
+	case PriorityNormal:
+		return BP_PRIORITY_NORMAL, true
+	case PriorityExpedited:
+		return BP_PRIORITY_EXPEDITED, true
+	default:
+		return 0, false
+	}
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBulk:
+		return "bulk"
+	case PriorityNormal:
+		return "normal"
+	case PriorityExpedited:
+		return "expedited"
+	default:
+		return "default"
+	}
+}
+
+// カーネルモジュールの既定値（一度変更したオプションを元に戻す際に使う）
+const (
+	defaultBundleLifetime = 24 * time.Hour
+	defaultPriority       = PriorityNormal
+)
+
+// SendOptions 1回の送信に対する指定（ゼロ値の項目は送信側の既定値を使う）
+type SendOptions struct {
+	// Lifetime バンドルの有効期間（届かないまま過ぎたバンドルは破棄される）
+	Lifetime time.Duration
+	// Priority バンドルの優先度
+	Priority Priority
+}
+
+// WithBundleOptions SendOptionsを指定しない送信で使う既定の有効期間と優先度を設定する
+func WithBundleOptions(defaults SendOptions) SenderOption {
+	return func(s *BpSender) {
+		s.defaultOptions = defaults
+	}
+}
+
+// resolve ゼロ値の項目を既定値で埋める
+func (o SendOptions) resolve(defaults SendOptions) SendOptions {
+	if o.Lifetime <= 0 {
+		o.Lifetime = defaults.Lifetime
+	}
+	if o.Lifetime <= 0 {
+		o.Lifetime = defaultBundleLifetime
+	}
+	if o.Priority == PriorityDefault {
+		o.Priority = defaults.Priority
+	}
+	if o.Priority == PriorityDefault {
+		o.Priority = defaultPriority
+	}
+	return o
+}
+
+// SendWithOptions 有効期間と優先度を指定してバンドルを送信
+func (s *BpSender) SendWithOptions(ctx context.Context, data interface{}, opts SendOptions) error {
+	return s.sendTo(ctx, s.remoteAddr, data, opts)
+}
+
+// applyBundleOptions ソケットのバンドルオプションを送信に合わせて設定する（sendSlotを持って呼ぶこと）
+// 前回の送信と同じ値であればsetsockoptを省略する
+func (s *BpSender) applyBundleOptions(opts SendOptions) {
+	setter, ok := s.socket.(bundleOptionSetter)
+	if !ok {
+		return
+	}
+	opts = opts.resolve(s.defaultOptions)
+
+	if opts.Lifetime != s.appliedOptions.Lifetime {
+		if err := setter.SetBundleLifetime(opts.Lifetime); err != nil {
+			log.Printf("[BpSender] WARNING: Failed to set bundle lifetime: %v", err)
+		} else {
+			s.appliedOptions.Lifetime = opts.Lifetime
+		}
+	}
+	if opts.Priority != s.appliedOptions.Priority {
+		if err := setter.SetPriority(opts.Priority); err != nil {
+			log.Printf("[BpSender] WARNING: Failed to set bundle priority: %v", err)
+		} else {
+			s.appliedOptions.Priority = opts.Priority
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// bundleopts_test.go - バンドルの有効期間と優先度の指定のテスト
+package bpsocket
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// optionRecorder setsockoptの呼び出し回数を数えるTransport
+type optionRecorder struct {
+	*LoopbackTransport
+	lifetimeCalls atomic.Int32
+	priorityCalls atomic.Int32
+}
+
+func (o *optionRecorder) SetBundleLifetime(d time.Duration) error {
+	o.lifetimeCalls.Add(1)
+	return o.LoopbackTransport.SetBundleLifetime(d)
+}
+
+func (o *optionRecorder) SetPriority(p Priority) error {
+	o.priorityCalls.Add(1)
+	return o.LoopbackTransport.SetPriority(p)
+}
+
+func newOptionRecorder(t *testing.T, network *LoopbackNetwork) *optionRecorder {
+	t.Helper()
+	if _, err := network.Listen(149, 1); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	transport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	return &optionRecorder{LoopbackTransport: transport}
+}
+
+func TestSendOptionsAreAppliedPerSend(t *testing.T) {
+	rec := newOptionRecorder(t, NewLoopbackNetwork())
+	sender := NewBpSenderWithTransport(rec, 149, 1)
+	defer sender.Close()
+
+	ctx := context.Background()
+	if err := sender.SendWithOptions(ctx, "a", SendOptions{Lifetime: time.Minute, Priority: PriorityExpedited}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := rec.BundleOptions(); got.Lifetime != time.Minute || got.Priority != PriorityExpedited {
+		t.Errorf("Unexpected options after override: %+v", got)
+	}
+
+	// 上書きなしの送信では既定値に戻す
+	if err := sender.Send(ctx, "b"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := rec.BundleOptions(); got.Lifetime != defaultBundleLifetime || got.Priority != defaultPriority {
+		t.Errorf("Expected defaults to be restored, got %+v", got)
+	}
+}
+
+func TestSendOptionsSkipUnchangedValues(t *testing.T) {
+	rec := newOptionRecorder(t, NewLoopbackNetwork())
+	sender := NewBpSenderWithTransport(rec, 149, 1, WithBundleOptions(SendOptions{Lifetime: 72 * time.Hour, Priority: PriorityBulk}))
+	defer sender.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := sender.Send(context.Background(), i); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if rec.lifetimeCalls.Load() != 1 || rec.priorityCalls.Load() != 1 {
+		t.Errorf("Expected a single setsockopt per option, got lifetime=%d priority=%d",
+			rec.lifetimeCalls.Load(), rec.priorityCalls.Load())
+	}
+	if got := rec.BundleOptions(); got.Lifetime != 72*time.Hour || got.Priority != PriorityBulk {
+		t.Errorf("Expected sender defaults, got %+v", got)
+	}
+}
+
+func TestLoopbackDropsExpiredBundles(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork(WithDelay(100*time.Millisecond)))
+
+	if err := sender.SendWithOptions(context.Background(), "short", SendOptions{Lifetime: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := sender.SendWithOptions(context.Background(), "long", SendOptions{Lifetime: time.Hour}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	data, ok := receiveWithin(t, receiver, time.Second)
+	if !ok || string(data) != `"long"` {
+		t.Fatalf("Expected only the long-lived bundle, got %q (ok=%v)", data, ok)
+	}
+	if _, ok := receiveWithin(t, receiver, 200*time.Millisecond); ok {
+		t.Error("Expected the short-lived bundle to expire in transit")
+	}
+}
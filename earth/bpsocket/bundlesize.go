@@ -0,0 +1,91 @@
+// bundlesize.go - 送受信できるバンドルの最大サイズ
+// ION の設定やコンバージェンスレイヤーによって扱えるペイロードの上限が異なるため、
+// 送信側・受信側それぞれで上限を指定できるようにする（未指定の場合はDefaultMaxBundleSize）
+package bpsocket
+
+import (
+	"fmt"
+)
+
+const (
+	// DefaultMaxBundleSize 最大バンドルサイズのデフォルト値
+	DefaultMaxBundleSize = 4 * 1024 * 1024
+
+	// MaxBundleSizeLimit 指定できる最大バンドルサイズの上限（展開後のサイズ上限と同じ）
+	MaxBundleSizeLimit = 64 * 1024 * 1024
+
+	// MinBundleSizeLimit 指定できる最大バンドルサイズの下限（エンベロープとチャンクヘッダーを載せられる大きさ）
+	MinBundleSizeLimit = 4 * 1024
+
+	// maxChunkOverhead チャンクバンドルでデータ以外に必要なバイト数の見積もり
+	// エンベロープ（ヘッダー・シーケンス番号・nonce・MAC・GCMタグ）、最長のストリームIDのチャンクヘッダー、
+	// 圧縮で増える分を含めても十分に収まる大きさ
+	maxChunkOverhead = 1024
+)
+
+// validateMaxBundleSize 最大バンドルサイズとして指定された値を検証する
+func validateMaxBundleSize(n int) error {
+	if n < MinBundleSizeLimit || n > MaxBundleSizeLimit {
+		return fmt.Errorf("invalid max bundle size %d: must be between %d and %d bytes", n, MinBundleSizeLimit, MaxBundleSizeLimit)
+	}
+	return nil
+}
+
+// WithMaxBundleSize 送信できるバンドルの最大サイズを指定する（デフォルト4MB）
+// エンコード後のバンドルが上限を超える場合、SendはErrBundleTooLargeを返す
+// BundleWriterはチャンクが上限に収まるように分割する
+// 範囲外の値を指定した場合、NewBpSenderはエラーを返す
+func WithMaxBundleSize(n int) SenderOption {
+	return func(s *BpSender) {
+		if err := validateMaxBundleSize(n); err != nil {
+			s.optionErr = err
+			return
+		}
+		s.maxBundleSize = n
+	}
+}
+
+// WithMaxReceiveSize 受信できるバンドルの最大サイズを指定する（デフォルト4MB）
+// 受信バッファはこのサイズで確保し、超えるバンドルはTruncatedとして渡す
+// 範囲外の値を指定した場合、NewBpReceiverはエラーを返す
+func WithMaxReceiveSize(n int) ReceiverOption {
+	return func(r *BpReceiver) {
+		if err := validateMaxBundleSize(n); err != nil {
+			r.optionErr = err
+			return
+		}
+		r.maxBundleSize = n
+		r.pool = newBufferPool(bufferTiersFor(n))
+	}
+}
+
+// bufferTiersFor 最大サイズがnのバッファ階層を返す
+func bufferTiersFor(n int) []int {
+	var tiers []int
+	for _, size := range bufferTiers[:len(bufferTiers)-1] {
+		if size < n {
+			tiers = append(tiers, size)
+		}
+	}
+	return append(tiers, n)
+}
+
+// chunkSizeFor 最大バンドルサイズがlimitの送信側で使うチャンクのデータサイズ
+func chunkSizeFor(limit int) int {
+	return min(defaultChunkSize, limit-maxChunkOverhead)
+}
+
+// MaxBundleSize 送信できるバンドルの最大サイズを返す
+func (s *BpSender) MaxBundleSize() int {
+	return s.maxBundleSize
+}
+
+// MaxBundleSize 受信できるバンドルの最大サイズを返す
+func (r *BpReceiver) MaxBundleSize() int {
+	return r.maxBundleSize
+}
+
+// MaxBundleSize 送信できるバンドルの最大サイズを返す（これを超えるレスポンスはBundleWriterで分割する）
+func (e *BpEndpoint) MaxBundleSize() int {
+	return e.sender.MaxBundleSize()
+}
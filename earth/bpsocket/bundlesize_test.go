@@ -0,0 +1,197 @@
+// bundlesize_test.go - 最大バンドルサイズの設定のテスト
+package bpsocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+const testBundleLimit = 1024 * 1024
+
+// newLimitedLoopbackPair 最大バンドルサイズを指定した送信側・受信側のペアを作成する
+func newLimitedLoopbackPair(t *testing.T, senderOpts []SenderOption, receiverOpts []ReceiverOption) (*BpSender, *BpReceiver, *LoopbackTransport) {
+	t.Helper()
+	network := NewLoopbackNetwork()
+
+	recvTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sendTransport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	receiver, err := newBpReceiverWithOptions(recvTransport, receiverOpts)
+	if err != nil {
+		t.Fatalf("Receiver options rejected: %v", err)
+	}
+	receiver.Start()
+	sender := newBpSender(sendTransport, NewSockaddrBP(149, 1))
+	if err := sender.apply(senderOpts); err != nil {
+		t.Fatalf("Sender options rejected: %v", err)
+	}
+	sender.start()
+
+	t.Cleanup(func() {
+		receiver.Close()
+		sender.Close()
+	})
+	return sender, receiver, sendTransport
+}
+
+// jsonStringOfSize JSONエンコード後にちょうどnバイトになる文字列
+func jsonStringOfSize(n int) json.RawMessage {
+	return json.RawMessage(`"` + string(bytes.Repeat([]byte("x"), n-2)) + `"`)
+}
+
+func TestValidateMaxBundleSize(t *testing.T) {
+	cases := []struct {
+		n     int
+		valid bool
+	}{
+		{0, false},
+		{-1, false},
+		{MinBundleSizeLimit - 1, false},
+		{MinBundleSizeLimit, true},
+		{testBundleLimit, true},
+		{DefaultMaxBundleSize, true},
+		{MaxBundleSizeLimit, true},
+		{MaxBundleSizeLimit + 1, false},
+	}
+	for _, tc := range cases {
+		err := validateMaxBundleSize(tc.n)
+		if (err == nil) != tc.valid {
+			t.Errorf("validateMaxBundleSize(%d): expected valid=%v, got %v", tc.n, tc.valid, err)
+		}
+	}
+}
+
+func TestInvalidMaxBundleSizeIsRejectedAtConstruction(t *testing.T) {
+	for _, n := range []int{0, -1, MaxBundleSizeLimit + 1} {
+		s := newBpSender(nil, NewSockaddrBP(149, 1))
+		if err := s.apply([]SenderOption{WithMaxBundleSize(n)}); err == nil {
+			t.Errorf("WithMaxBundleSize(%d): expected an error", n)
+		}
+		if s.MaxBundleSize() != DefaultMaxBundleSize {
+			t.Errorf("WithMaxBundleSize(%d): expected default limit to be kept, got %d", n, s.MaxBundleSize())
+		}
+
+		if _, err := newBpReceiverWithOptions(nil, []ReceiverOption{WithMaxReceiveSize(n)}); err == nil {
+			t.Errorf("WithMaxReceiveSize(%d): expected an error", n)
+		}
+
+		e, err := newBpEndpoint(nil, []EndpointOption{WithReceiverOptions(WithMaxReceiveSize(n))})
+		if err == nil {
+			t.Errorf("Endpoint with receive size %d: expected an error", n)
+		}
+		e.sender.stop()
+	}
+}
+
+func TestSenderRejectsBundleAboveLimit(t *testing.T) {
+	sender, receiver, _ := newLimitedLoopbackPair(t, []SenderOption{WithMaxBundleSize(testBundleLimit)}, nil)
+
+	if got := sender.MaxBundleSize(); got != testBundleLimit {
+		t.Fatalf("Expected MaxBundleSize %d, got %d", testBundleLimit, got)
+	}
+
+	if err := sender.Send(context.Background(), jsonStringOfSize(testBundleLimit)); err != nil {
+		t.Fatalf("Send at the limit failed: %v", err)
+	}
+	if data, ok := receiveWithin(t, receiver, time.Second); !ok || len(data) != testBundleLimit {
+		t.Fatalf("Expected a %d byte bundle, got %d (ok=%v)", testBundleLimit, len(data), ok)
+	}
+
+	err := sender.Send(context.Background(), jsonStringOfSize(testBundleLimit+1))
+	if !errors.Is(err, ErrBundleTooLarge) {
+		t.Fatalf("Expected ErrBundleTooLarge, got %v", err)
+	}
+	if _, ok := receiveWithin(t, receiver, 100*time.Millisecond); ok {
+		t.Error("Expected the oversized bundle not to be sent")
+	}
+	if stats := sender.Stats(); stats.SendErrors != 1 {
+		t.Errorf("Expected 1 send error, got %d", stats.SendErrors)
+	}
+}
+
+func TestReceiverTruncatesAboveConfiguredLimit(t *testing.T) {
+	_, receiver, sendTransport := newLimitedLoopbackPair(t, nil, []ReceiverOption{WithMaxReceiveSize(testBundleLimit)})
+
+	if got := receiver.MaxBundleSize(); got != testBundleLimit {
+		t.Fatalf("Expected MaxBundleSize %d, got %d", testBundleLimit, got)
+	}
+
+	for _, size := range []int{testBundleLimit, testBundleLimit + 1} {
+		if err := sendTransport.Send(bytes.Repeat([]byte("x"), size), 149, 1); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+
+		var bundle *Bundle
+		select {
+		case bundle = <-receiver.GetDataChannel():
+		case <-time.After(time.Second):
+			t.Fatalf("Bundle of %d bytes was not delivered", size)
+		}
+
+		wantTruncated := size > testBundleLimit
+		if bundle.Truncated != wantTruncated || bundle.Size != size || len(bundle.Data) != testBundleLimit {
+			t.Errorf("%d bytes: unexpected bundle: truncated=%v size=%d len=%d", size, bundle.Truncated, bundle.Size, len(bundle.Data))
+		}
+		var truncErr *TruncatedError
+		if wantTruncated && (!errors.As(bundle.Err(), &truncErr) || truncErr.Limit != testBundleLimit) {
+			t.Errorf("%d bytes: expected TruncatedError with limit %d, got %v", size, testBundleLimit, bundle.Err())
+		}
+		bundle.Release()
+	}
+}
+
+func TestBundleWriterFitsChunksInLimit(t *testing.T) {
+	senderOpts := []SenderOption{
+		WithMaxBundleSize(MinBundleSizeLimit),
+		WithSequenceNumbers(),
+		WithHMACKey([]byte("secret")),
+		WithEncryptionKey(testEncKey),
+	}
+	receiverOpts := []ReceiverOption{
+		WithMaxReceiveSize(MinBundleSizeLimit),
+		WithHMACVerification(true, []byte("secret")),
+		WithDecryptionKeys(testEncKey),
+	}
+	sender, receiver, _ := newLimitedLoopbackPair(t, senderOpts, receiverOpts)
+
+	streamID := string(bytes.Repeat([]byte("s"), 255))
+	reader, err := NewBundleReader(receiver, streamID)
+	if err != nil {
+		t.Fatalf("NewBundleReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	payload := make([]byte, 64*1024)
+	rand.Read(payload)
+
+	w := NewBundleWriter(sender, streamID)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("Received stream does not match the sent payload")
+	}
+	if largest := sender.Stats().LargestBundle; largest > MinBundleSizeLimit {
+		t.Errorf("Expected chunks to fit in %d bytes, largest was %d", MinBundleSizeLimit, largest)
+	}
+}
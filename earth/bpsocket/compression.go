@@ -0,0 +1,113 @@
+// compression.go - bp-socket層での透過的なペイロード圧縮
+package bpsocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Compression 送信時に使用する圧縮方式
+type Compression int
+
+const (
+	CompressionNone    Compression = iota // 圧縮しない（従来形式で送信）
+	CompressionGzip                       // gzip
+	CompressionDeflate                    // deflate（gzipよりヘッダーが小さい）
+)
+
+// defaultCompressionThreshold これ未満のペイロードは圧縮しない
+const defaultCompressionThreshold = 1024
+
+// maxDecompressedSize 展開後のサイズ上限（圧縮爆弾対策）
+const maxDecompressedSize = 64 * 1024 * 1024
+
+// CompressionStats 圧縮・展開の統計
+type CompressionStats struct {
+	Bundles           uint64 // 圧縮（展開）したバンドル数
+	UncompressedBytes uint64 // 圧縮前（展開後）の合計バイト数
+	CompressedBytes   uint64 // 圧縮後（展開前）の合計バイト数
+}
+
+// compressionCounters CompressionStatsをgoroutine安全に集計する
+type compressionCounters struct {
+	bundles           atomic.Uint64
+	uncompressedBytes atomic.Uint64
+	compressedBytes   atomic.Uint64
+}
+
+func (c *compressionCounters) add(uncompressed, compressed int) {
+	c.bundles.Add(1)
+	c.uncompressedBytes.Add(uint64(uncompressed))
+	c.compressedBytes.Add(uint64(compressed))
+}
+
+func (c *compressionCounters) snapshot() CompressionStats {
+	return CompressionStats{
+		Bundles:           c.bundles.Load(),
+		UncompressedBytes: c.uncompressedBytes.Load(),
+		CompressedBytes:   c.compressedBytes.Load(),
+	}
+}
+
+// compress ペイロードを圧縮し、エンベロープに設定するフラグとともに返す
+func compress(algo Compression, data []byte) ([]byte, byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var flag byte
+
+	switch algo {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+		flag = flagGzip
+	case CompressionDeflate:
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, 0, err
+		}
+		w = fw
+		flag = flagDeflate
+	default:
+		return nil, 0, fmt.Errorf("unknown compression: %d", algo)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, 0, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), flag, nil
+}
+
+// decompress エンベロープのフラグに従ってペイロードを展開する（圧縮されていなければそのまま返す）
+func decompress(flags byte, data []byte) ([]byte, bool, error) {
+	var r io.Reader
+	switch {
+	case flags&flagGzip != 0:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, fmt.Errorf("gzip header error: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	case flags&flagDeflate != 0:
+		fr := flate.NewReader(bytes.NewReader(data))
+		defer fr.Close()
+		r = fr
+	default:
+		return data, false, nil
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("decompress error: %w", err)
+	}
+	if len(out) > maxDecompressedSize {
+		return nil, false, fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedSize)
+	}
+	return out, true, nil
+}
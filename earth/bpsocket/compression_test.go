@@ -0,0 +1,113 @@
+// compression_test.go - ペイロード圧縮エンベロープのテスト
+package bpsocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCompressedLoopbackPair(t *testing.T, algo Compression, threshold int) (*BpSender, *BpReceiver, *LoopbackTransport) {
+	t.Helper()
+	network := NewLoopbackNetwork()
+
+	recvTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sendTransport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	receiver := NewBpReceiverWithTransport(recvTransport)
+	receiver.Start()
+	sender := NewBpSenderWithTransport(sendTransport, 149, 1, WithCompression(algo, threshold))
+
+	t.Cleanup(func() {
+		receiver.Close()
+		sender.Close()
+	})
+	return sender, receiver, sendTransport
+}
+
+func TestCompressionRoundTripLargePayload(t *testing.T) {
+	for _, algo := range []Compression{CompressionGzip, CompressionDeflate} {
+		sender, receiver, _ := newCompressedLoopbackPair(t, algo, 512)
+
+		body := strings.Repeat("<div class=\"item\">hello from the moon</div>\n", 2000)
+		msg := map[string]string{"request_id": "r1", "body": body}
+		if err := sender.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+
+		data, ok := receiveWithin(t, receiver, time.Second)
+		if !ok {
+			t.Fatalf("Bundle was not delivered (compression %d)", algo)
+		}
+		var got map[string]string
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Invalid payload: %v", err)
+		}
+		if got["body"] != body {
+			t.Errorf("Payload mismatch after decompression (compression %d)", algo)
+		}
+
+		sent := sender.CompressionStats()
+		if sent.Bundles != 1 || sent.CompressedBytes >= sent.UncompressedBytes {
+			t.Errorf("Unexpected sender stats: %+v", sent)
+		}
+		if recv := receiver.CompressionStats(); recv != sent {
+			t.Errorf("Expected receiver stats %+v to match sender stats %+v", recv, sent)
+		}
+	}
+}
+
+func TestCompressionSkipsTinyPayload(t *testing.T) {
+	sender, receiver, _ := newCompressedLoopbackPair(t, CompressionGzip, 512)
+
+	if err := sender.Send(context.Background(), map[string]string{"request_id": "r1"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	data, ok := receiveWithin(t, receiver, time.Second)
+	if !ok {
+		t.Fatal("Bundle was not delivered")
+	}
+	if data[0] != '{' {
+		t.Errorf("Expected tiny payload to be sent as raw JSON, got %q", data)
+	}
+	if stats := sender.CompressionStats(); stats.Bundles != 0 {
+		t.Errorf("Expected no compressed bundles, got %+v", stats)
+	}
+}
+
+func TestReceiverAcceptsLegacyRawJSON(t *testing.T) {
+	_, receiver, sendTransport := newCompressedLoopbackPair(t, CompressionGzip, 512)
+
+	legacy := []byte(`{"request_id":"r1","url":"https://example.com"}`)
+	if err := sendTransport.Send(legacy, 149, 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	data, ok := receiveWithin(t, receiver, time.Second)
+	if !ok {
+		t.Fatal("Bundle was not delivered")
+	}
+	if string(data) != string(legacy) {
+		t.Errorf("Expected legacy payload unchanged, got %q", data)
+	}
+}
+
+func TestReceiverDropsCorruptCompressedBundle(t *testing.T) {
+	_, receiver, sendTransport := newCompressedLoopbackPair(t, CompressionGzip, 512)
+
+	corrupt := encodeEnvelope(&envelope{Type: envelopeTypeData, Flags: flagGzip, Payload: []byte("not gzip")})
+	if err := sendTransport.Send(corrupt, 149, 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, ok := receiveWithin(t, receiver, 200*time.Millisecond); ok {
+		t.Error("Expected corrupt bundle to be dropped")
+	}
+}
@@ -7,5 +7,17 @@ const (
 	SOCK_DGRAM    = 2
 	BP_PROTO      = 0
 	BP_SCHEME_IPN = 1 // IPN scheme identifier
+	BP_SCHEME_DTN = 2 // DTN scheme identifier
+
+	BP_DTN_URI_MAX = 256 // dtnスキームのURIの最大長（NUL終端を含む）
+
+	// setsockoptのオプション（level = SOL_BP）
+	SOL_BP          = 284
+	BP_OPT_LIFETIME = 1 // バンドルの有効期間（秒, int）
+	BP_OPT_PRIORITY = 2 // バンドルの優先度（BP_PRIORITY_*, int）
+
+	BP_PRIORITY_BULK      = 0
+	BP_PRIORITY_NORMAL    = 1
+	BP_PRIORITY_EXPEDITED = 2
 )
 
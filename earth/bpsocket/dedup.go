@@ -0,0 +1,57 @@
+// dedup.go - シーケンス番号による重複バンドルの検出
+package bpsocket
+
+import "sync"
+
+// defaultDedupWindowSize 重複検出で記憶する(送信元, シーケンス番号)の数
+const defaultDedupWindowSize = 1024
+
+// seqKey 送信元EIDとシーケンス番号の組
+type seqKey struct {
+	source string
+	seq    uint64
+}
+
+// dedupWindow 直近に受信した(送信元, シーケンス番号)を固定長で記憶するスライディングウィンドウ
+// 容量を超えると最も古いものから忘れる
+type dedupWindow struct {
+	mu   sync.Mutex
+	seen map[seqKey]struct{}
+	ring []seqKey
+	next int
+	full bool
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	if size <= 0 {
+		size = defaultDedupWindowSize
+	}
+	return &dedupWindow{
+		seen: make(map[seqKey]struct{}, size),
+		ring: make([]seqKey, size),
+	}
+}
+
+// observe 組を記録し、既にウィンドウ内に存在した（重複）場合はtrueを返す
+func (w *dedupWindow) observe(source string, seq uint64) bool {
+	key := seqKey{source: source, seq: seq}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.seen[key]; ok {
+		return true
+	}
+
+	if w.full {
+		delete(w.seen, w.ring[w.next])
+	}
+	w.ring[w.next] = key
+	w.seen[key] = struct{}{}
+	w.next++
+	if w.next == len(w.ring) {
+		w.next = 0
+		w.full = true
+	}
+	return false
+}
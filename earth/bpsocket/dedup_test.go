@@ -0,0 +1,121 @@
+// dedup_test.go - シーケンス番号による重複検出のテスト
+package bpsocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// replayingTransport 送信したバンドルをtimes回ずつ送り直すTransport（DTNの重複配送を再現）
+type replayingTransport struct {
+	Transport
+	times int
+}
+
+func (r *replayingTransport) SendTo(data []byte, to *SockaddrBP) error {
+	for i := 0; i < r.times; i++ {
+		if err := r.Transport.SendTo(data, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newReplayingPair(t *testing.T, times int, senderOpts []SenderOption, receiverOpts []ReceiverOption) (*BpSender, *BpReceiver) {
+	t.Helper()
+	network := NewLoopbackNetwork()
+
+	recvTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sendTransport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	receiver := NewBpReceiverWithTransport(recvTransport, receiverOpts...)
+	receiver.Start()
+	sender := NewBpSenderWithTransport(&replayingTransport{Transport: sendTransport, times: times}, 149, 1, senderOpts...)
+
+	t.Cleanup(func() {
+		receiver.Close()
+		sender.Close()
+	})
+	return sender, receiver
+}
+
+func drain(t *testing.T, receiver *BpReceiver) int {
+	t.Helper()
+	n := 0
+	for {
+		if _, ok := receiveWithin(t, receiver, 100*time.Millisecond); !ok {
+			return n
+		}
+		n++
+	}
+}
+
+func TestDuplicateBundlesAreDropped(t *testing.T) {
+	sender, receiver := newReplayingPair(t, 3,
+		[]SenderOption{WithSequenceNumbers()},
+		[]ReceiverOption{WithDuplicateDetection(16)})
+
+	for i := 0; i < 5; i++ {
+		if err := sender.Send(context.Background(), i); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	if got := drain(t, receiver); got != 5 {
+		t.Errorf("Expected 5 unique bundles, got %d", got)
+	}
+	if dropped := receiver.DuplicatesDropped(); dropped != 10 {
+		t.Errorf("Expected 10 duplicates dropped, got %d", dropped)
+	}
+}
+
+func TestDuplicateDetectionIgnoresLegacyPeers(t *testing.T) {
+	// シーケンス番号なしの送信元からの重複は判別できないため、すべて受け入れる
+	sender, receiver := newReplayingPair(t, 2, nil, []ReceiverOption{WithDuplicateDetection(16)})
+
+	if err := sender.Send(context.Background(), map[string]string{"request_id": "r1"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := drain(t, receiver); got != 2 {
+		t.Errorf("Expected both legacy bundles to be delivered, got %d", got)
+	}
+	if dropped := receiver.DuplicatesDropped(); dropped != 0 {
+		t.Errorf("Expected no duplicates dropped, got %d", dropped)
+	}
+}
+
+func TestSequencedBundlesWithoutDetectionAreDelivered(t *testing.T) {
+	sender, receiver := newReplayingPair(t, 2, []SenderOption{WithSequenceNumbers()}, nil)
+
+	if err := sender.Send(context.Background(), "payload"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := drain(t, receiver); got != 2 {
+		t.Errorf("Expected duplicates to pass through when detection is disabled, got %d", got)
+	}
+}
+
+func TestDedupWindowForgetsOldestEntries(t *testing.T) {
+	w := newDedupWindow(2)
+
+	if w.observe("ipn:150.2", 1) || w.observe("ipn:150.2", 2) {
+		t.Fatal("First observations must not be duplicates")
+	}
+	if !w.observe("ipn:150.2", 2) {
+		t.Error("Expected seq 2 to be a duplicate")
+	}
+	if w.observe("ipn:151.2", 2) {
+		t.Error("Same seq from a different source must not be a duplicate")
+	}
+	// ipn:151.2の記録でseq 1が押し出される
+	if w.observe("ipn:150.2", 1) {
+		t.Error("Expected seq 1 to have left the window")
+	}
+}
@@ -0,0 +1,76 @@
+// drain.go - 受信済みのバンドルを取りこぼさずに受信を終了する
+// Closeは受信ループを止めるとすぐにデータチャネルをクローズするため、チャネルのクローズで処理を止める利用者は
+// チャネルに残っているバンドルを読まずに終了してしまう。Drainは利用者が読み終えるまでチャネルを開いたままにする
+package bpsocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// drainPollInterval Drain中にデータチャネルが空になったかを確認する間隔
+const drainPollInterval = 10 * time.Millisecond
+
+// Drain 新しいバンドルの受信を停止し、データチャネルに残っているバンドルを利用者が読み終えてから
+// チャネルとソケットをクローズする
+// ctxが先に終了した場合は読まれなかったバンドルを破棄してチャネルをクローズし、ctxのエラーを返す
+func (r *BpReceiver) Drain(ctx context.Context) error {
+	r.draining.Store(true)
+	r.stop()
+	defer r.Close()
+	defer r.closeData()
+
+	if r.started.Load() {
+		// 受信ループはRecvのタイムアウトごとにstopChanを確認するため、通常はrecvPollInterval以内に終了する
+		select {
+		case <-r.loopDone:
+		case <-ctx.Done():
+			// Recvがタイムアウトしないソケットでは、クローズしてRecvを戻す
+			r.Close()
+			<-r.loopDone
+			return r.discardBuffered(ctx.Err())
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for len(r.dataChan) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return r.discardBuffered(ctx.Err())
+		}
+	}
+
+	log.Println("[BpReceiver] Drained all buffered bundles")
+	return nil
+}
+
+// discardBuffered データチャネルに残っているバンドルを破棄し、その数を含むエラーを返す
+func (r *BpReceiver) discardBuffered(cause error) error {
+	discarded := 0
+	for {
+		select {
+		case bundle, ok := <-r.dataChan:
+			if ok {
+				bundle.Release()
+				r.stats.dropped.Add(1)
+				discarded++
+				continue
+			}
+		default:
+		}
+		break
+	}
+	log.Printf("[BpReceiver] WARNING: Drain interrupted, discarded %d unread bundles", discarded)
+	return fmt.Errorf("drain interrupted with %d unread bundles: %w", discarded, cause)
+}
+
+// Drain 新しいバンドルの受信を停止し、受信済みのバンドルを読み終えてからクローズする（BpReceiver.Drainと同じ）
+// Drain中も送信はできるため、受信済みのリクエストへの応答を送ってから終了できる
+func (e *BpEndpoint) Drain(ctx context.Context) error {
+	defer e.sender.stop()
+	return e.receiver.Drain(ctx)
+}
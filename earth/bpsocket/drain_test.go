@@ -0,0 +1,145 @@
+// drain_test.go - 受信済みバンドルを読み終えてから終了するDrainのテスト
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fillDataChannel n個のバンドルを送信し、すべてデータチャネルに溜まるまで待つ
+func fillDataChannel(t *testing.T, sender *BpSender, receiver *BpReceiver, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := sender.Send(context.Background(), map[string]int{"i": i}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(receiver.dataChan) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d buffered bundles, got %d", n, len(receiver.dataChan))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDrainDeliversBufferedBundles(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork())
+	const n = 10
+	fillDataChannel(t, sender, receiver, n)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- receiver.Drain(context.Background())
+	}()
+
+	// 利用者がゆっくり読んでも、すべて読み終えるまでチャネルはクローズされない
+	received := 0
+	for bundle := range receiver.GetDataChannel() {
+		time.Sleep(20 * time.Millisecond)
+		bundle.Release()
+		received++
+	}
+	if received != n {
+		t.Errorf("Expected all %d buffered bundles before the channel closed, got %d", n, received)
+	}
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("Drain failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return")
+	}
+	if _, _, err := receiver.socket.Recv(make([]byte, 64)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected the receiver socket to be closed after Drain, got %v", err)
+	}
+}
+
+func TestDrainDiscardsUnreadBundlesWhenContextExpires(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork())
+	const n = 5
+	fillDataChannel(t, sender, receiver, n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := receiver.Drain(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded, got %v", err)
+	}
+
+	if _, ok := <-receiver.GetDataChannel(); ok {
+		t.Error("Expected the data channel to be closed and empty")
+	}
+	if dropped := receiver.Stats().Dropped; dropped != n {
+		t.Errorf("Expected %d dropped bundles, got %d", n, dropped)
+	}
+	if outstanding := receiver.pool.outstanding.Load(); outstanding != 0 {
+		t.Errorf("Expected discarded bundles to be released, got %d outstanding", outstanding)
+	}
+}
+
+func TestDrainWithoutStart(t *testing.T) {
+	network := NewLoopbackNetwork()
+	transport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	receiver := NewBpReceiverWithTransport(transport)
+
+	if err := receiver.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if _, ok := <-receiver.GetDataChannel(); ok {
+		t.Error("Expected the data channel to be closed")
+	}
+	if err := receiver.Close(); err != nil {
+		t.Errorf("Close after Drain failed: %v", err)
+	}
+}
+
+func TestEndpointDrain(t *testing.T) {
+	network := NewLoopbackNetwork()
+	transport, err := network.Listen(150, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	endpoint := NewBpEndpointWithTransport(transport)
+	endpoint.Start()
+
+	peer, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer peer.Close()
+	if err := peer.Send([]byte(`{"request_id":"r1"}`), 150, 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(endpoint.receiver.dataChan) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Bundle was not buffered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- endpoint.Drain(context.Background())
+	}()
+
+	bundle, ok := <-endpoint.GetDataChannel()
+	if !ok || string(bundle.Data) != `{"request_id":"r1"}` {
+		t.Fatalf("Expected the buffered bundle, got ok=%v", ok)
+	}
+	bundle.Release()
+	if _, ok := <-endpoint.GetDataChannel(); ok {
+		t.Error("Expected the data channel to be closed after the buffered bundle")
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("Drain failed: %v", err)
+	}
+}
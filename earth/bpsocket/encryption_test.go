@@ -0,0 +1,216 @@
+// encryption_test.go - AES-GCMによるペイロード暗号化のテスト
+package bpsocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testEncKey  = bytes.Repeat([]byte{0x11}, EncryptionKeySize)
+	testEncKey2 = bytes.Repeat([]byte{0x22}, EncryptionKeySize)
+)
+
+func expectDecryptFailed(t *testing.T, r *BpReceiver, reason string) {
+	t.Helper()
+	select {
+	case ev := <-r.Events():
+		if ev.Type != EventDecryptFailed || ev.Reason != reason {
+			t.Errorf("Unexpected event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a DecryptFailed event")
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithDecryptionKeys(testEncKey))
+
+	msg := map[string]string{"url": "https://example.com/secret?token=abc"}
+	sendSigned(t, network, msg, WithEncryptionKey(testEncKey), WithSequenceNumbers())
+
+	data, ok := receiveWithin(t, r, time.Second)
+	if !ok || !strings.Contains(string(data), "token=abc") {
+		t.Fatalf("Expected the decrypted bundle, got %q (ok=%v)", data, ok)
+	}
+	if n := r.DecryptFailures(); n != 0 {
+		t.Errorf("Expected no decrypt failures, got %d", n)
+	}
+}
+
+func TestEncryptedBundleHidesPlaintext(t *testing.T) {
+	sender := newBpSender(nil, NewSockaddrBP(149, 1))
+	WithEncryptionKey(testEncKey)(sender)
+
+	bundle, _, err := sender.encodeBundle([]byte(`{"url":"https://example.com/secret"}`))
+	if err != nil {
+		t.Fatalf("encodeBundle failed: %v", err)
+	}
+	if bytes.Contains(bundle, []byte("secret")) {
+		t.Error("Expected the payload to be encrypted on the wire")
+	}
+	if bundle[1] != envelopeVersionEncrypted {
+		t.Errorf("Expected envelope version %d, got %d", envelopeVersionEncrypted, bundle[1])
+	}
+}
+
+func TestEncryptionComposesWithCompression(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithDecryptionKeys(testEncKey))
+
+	body := strings.Repeat("compressible ", 500)
+	sendSigned(t, network, body, WithEncryptionKey(testEncKey), WithCompression(CompressionGzip, 64))
+
+	data, ok := receiveWithin(t, r, time.Second)
+	if !ok || !strings.Contains(string(data), body) {
+		t.Fatalf("Expected the decompressed plaintext, got %d bytes (ok=%v)", len(data), ok)
+	}
+	if stats := r.CompressionStats(); stats.Bundles != 1 || stats.CompressedBytes >= stats.UncompressedBytes {
+		t.Errorf("Expected the payload to be compressed before encryption, got %+v", stats)
+	}
+}
+
+func TestDecryptWithWrongKeyFails(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithDecryptionKeys(testEncKey2))
+
+	sendSigned(t, network, "secret", WithEncryptionKey(testEncKey))
+
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Fatal("Expected the bundle encrypted with another key to be dropped")
+	}
+	if n := r.DecryptFailures(); n != 1 {
+		t.Errorf("Expected 1 decrypt failure, got %d", n)
+	}
+	expectDecryptFailed(t, r, errDecryptFailed.Error())
+}
+
+func TestDecryptionKeyRotation(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithDecryptionKeys(testEncKey2, testEncKey))
+
+	sendSigned(t, network, "old", WithEncryptionKey(testEncKey))
+	sendSigned(t, network, "new", WithEncryptionKey(testEncKey2))
+
+	for _, want := range []string{`"old"`, `"new"`} {
+		if data, ok := receiveWithin(t, r, time.Second); !ok || string(data) != want {
+			t.Fatalf("Expected %s, got %q (ok=%v)", want, data, ok)
+		}
+	}
+}
+
+func TestPlaintextPeersInteroperate(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithDecryptionKeys(testEncKey))
+
+	// 暗号化していない送信側のバンドルはそのまま受け付ける
+	sendSigned(t, network, "plain")
+	if data, ok := receiveWithin(t, r, time.Second); !ok || string(data) != `"plain"` {
+		t.Fatalf("Expected the plaintext bundle, got %q (ok=%v)", data, ok)
+	}
+}
+
+func TestEncryptedBundleWithoutDecryptionKey(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network)
+
+	sendSigned(t, network, "secret", WithEncryptionKey(testEncKey))
+
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Fatal("Expected the ciphertext not to be handed downstream")
+	}
+	expectDecryptFailed(t, r, errNoDecryptionKey.Error())
+}
+
+func TestTamperedHeaderFailsDecryption(t *testing.T) {
+	sender := newBpSender(nil, NewSockaddrBP(149, 1))
+	WithEncryptionKey(testEncKey)(sender)
+	WithSequenceNumbers()(sender)
+	bundle, _, err := sender.encodeBundle([]byte(`"payload"`))
+	if err != nil {
+		t.Fatalf("encodeBundle failed: %v", err)
+	}
+
+	// 暗号文に触れずにシーケンス番号だけを書き換えても検出できる
+	bundle[envelopeHeaderSize] ^= 0x01
+	env, err := decodeEnvelope(bundle)
+	if err != nil {
+		t.Fatalf("decodeEnvelope failed: %v", err)
+	}
+	aead, _ := newPayloadCipher(testEncKey)
+	if _, err := decryptEnvelope(nil, env); !errors.Is(err, errNoDecryptionKey) {
+		t.Errorf("Expected errNoDecryptionKey, got %v", err)
+	}
+	if _, err := decryptEnvelope([]cipher.AEAD{aead}, env); !errors.Is(err, errDecryptFailed) {
+		t.Errorf("Expected errDecryptFailed for a modified header, got %v", err)
+	}
+}
+
+func TestInvalidEncryptionKeyFailsClosed(t *testing.T) {
+	sock := newBlockingSocket()
+	close(sock.release)
+	sender := newBpSender(sock, NewSockaddrBP(149, 1))
+	WithEncryptionKey([]byte("too-short"))(sender)
+
+	if err := sender.Send(context.Background(), "secret"); err == nil {
+		t.Fatal("Expected Send to fail with an invalid encryption key")
+	}
+	if sock.sent.Load() != 0 {
+		t.Error("Expected nothing to be sent in plaintext")
+	}
+}
+
+// TestEncryptedResponseContract バックエンドのテストで復号するtestdata/dtn/response_encrypted.binが、地上局の暗号化したレスポンスの形式と一致する
+// nonceは送信ごとに変わるため、バイト列ではなく同じ鍵で復号できることを確認する（形式を変える場合はバックエンドのenvelope.goも合わせて変える）
+func TestEncryptedResponseContract(t *testing.T) {
+	dir := filepath.Join("..", "..", "testdata", "dtn")
+	want, err := os.ReadFile(filepath.Join(dir, "response.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "response_encrypted.bin"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+
+	key, _ := hex.DecodeString("1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100")
+	aead, err := newPayloadCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := decodeEnvelope(data)
+	if err != nil {
+		t.Fatalf("decodeEnvelope failed: %v", err)
+	}
+	if !env.encrypted() || env.Type != envelopeTypeData || env.Flags != 0 {
+		t.Fatalf("expected an uncompressed encrypted data envelope, got type=%d flags=%d", env.Type, env.Flags)
+	}
+	plaintext, err := decryptEnvelope([]cipher.AEAD{aead}, env)
+	if err != nil {
+		t.Fatalf("decryptEnvelope failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, want) {
+		t.Errorf("expected the payload of testdata/dtn/response.json, got %q", plaintext)
+	}
+
+	// 同じ鍵で暗号化したレスポンスは、ヘッダーの形式も同じになる
+	sender := newBpSender(nil, NewSockaddrBP(149, 1))
+	WithEncryptionKey(key)(sender)
+	bundle, _, err := sender.encodeBundle(want)
+	if err != nil {
+		t.Fatalf("encodeBundle failed: %v", err)
+	}
+	if len(bundle) != len(data) || !bytes.Equal(bundle[:envelopeHeaderSize], data[:envelopeHeaderSize]) {
+		t.Errorf("encrypted response drifted from testdata/dtn/response_encrypted.bin: header % x (%d bytes), want % x (%d bytes)",
+			bundle[:envelopeHeaderSize], len(bundle), data[:envelopeHeaderSize], len(data))
+	}
+}
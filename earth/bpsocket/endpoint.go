@@ -0,0 +1,215 @@
+// endpoint.go - 1つのサービス番号で送受信を行う双方向エンドポイント
+// BpSender/BpReceiverはそれぞれソケットをバインドするため、送受信で2つのサービス番号を消費していた
+// BpEndpointは1つのソケットを共有し、受信ループがACKとpongを送信側へ振り分ける
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+)
+
+// BpEndpoint 送受信を1つのソケットで行うエンドポイント
+// Sendは複数のgoroutineから同時に呼べ、受信ループと並行して動作する
+type BpEndpoint struct {
+	transport Transport
+	sender    *BpSender
+	receiver  *BpReceiver
+}
+
+// endpointConfig BpEndpointの作成時に指定する送信側・受信側のオプション
+type endpointConfig struct {
+	senderOpts   []SenderOption
+	receiverOpts []ReceiverOption
+
+	keepalivePeer string
+	keepalive     KeepaliveConfig
+}
+
+// EndpointOption BpEndpointの設定オプション
+type EndpointOption func(*endpointConfig)
+
+// WithSenderOptions 送信側のオプション（圧縮、再送など）を指定する
+func WithSenderOptions(opts ...SenderOption) EndpointOption {
+	return func(c *endpointConfig) {
+		c.senderOpts = append(c.senderOpts, opts...)
+	}
+}
+
+// WithReceiverOptions 受信側のオプション（重複検出、連続エラー時の停止など）を指定する
+func WithReceiverOptions(opts ...ReceiverOption) EndpointOption {
+	return func(c *endpointConfig) {
+		c.receiverOpts = append(c.receiverOpts, opts...)
+	}
+}
+
+// WithKeepaliveTo 指定したEIDへ定期的にpingを送り、リンク状態を判定する
+// 相手側もBpEndpointまたはBpReceiverであれば、ライブラリが自動でpongを返す
+func WithKeepaliveTo(peer string, conf KeepaliveConfig) EndpointOption {
+	return func(c *endpointConfig) {
+		c.keepalivePeer = peer
+		c.keepalive = conf
+	}
+}
+
+// NewBpEndpoint 送受信用のBP Socketを作成
+func NewBpEndpoint(localNodeNum, localSvcNum uint64, opts ...EndpointOption) (*BpEndpoint, error) {
+	return newBpEndpointAddr(NewSockaddrBP(localNodeNum, localSvcNum), opts...)
+}
+
+// NewBpEndpointEID EID文字列（"ipn:150.1" や "dtn://earth/crawler"）を指定して送受信用のBP Socketを作成
+func NewBpEndpointEID(localEID string, opts ...EndpointOption) (*BpEndpoint, error) {
+	localAddr, err := ParseEID(localEID)
+	if err != nil {
+		return nil, err
+	}
+	return newBpEndpointAddr(localAddr, opts...)
+}
+
+func newBpEndpointAddr(localAddr *SockaddrBP, opts ...EndpointOption) (*BpEndpoint, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("bp-socket is only supported on Linux (current OS: %s)", runtime.GOOS)
+	}
+
+	socket, err := NewBpSocketAddr(localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BP socket: %w", err)
+	}
+
+	e, err := newBpEndpoint(socket, opts)
+	if err != nil {
+		e.sender.stop()
+		socket.Close()
+		return nil, err
+	}
+
+	log.Printf("[BpEndpoint] Bound to %s", socket.LocalAddr().String())
+
+	return e, nil
+}
+
+// NewBpEndpointWithTransport 任意のTransport（ループバックなど）で送受信するBpEndpointを作成
+// 不正なオプションの値はログに記録し、デフォルト値を使う
+func NewBpEndpointWithTransport(transport Transport, opts ...EndpointOption) *BpEndpoint {
+	e, err := newBpEndpoint(transport, opts)
+	if err != nil {
+		log.Printf("[BpEndpoint] ERROR: %v, using defaults", err)
+	}
+	return e
+}
+
+// newBpEndpoint オプションを適用したBpEndpointを作成し、送信側・受信側に不正なオプションの値があればエラーを返す
+func newBpEndpoint(transport Transport, opts []EndpointOption) (*BpEndpoint, error) {
+	var conf endpointConfig
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	// 送信側は宛先を固定せず、ACKは独自に受信せずに受信ループから受け取る
+	sender := newBpSender(transport, nil)
+	senderErr := sender.apply(conf.senderOpts)
+	if conf.keepalivePeer != "" {
+		if peer, err := ParseEID(conf.keepalivePeer); err != nil {
+			log.Printf("[BpEndpoint] ERROR: Keepalive disabled: %v", err)
+		} else {
+			sender.keepalive = newKeepalive(conf.keepalive, peer)
+		}
+	}
+
+	receiver, receiverErr := newBpReceiverWithOptions(transport, conf.receiverOpts)
+	receiver.onAck = sender.handleAck
+	receiver.onPong = sender.handlePong
+	receiver.sendSlot = sender.sendSlot
+
+	if sender.keepalive != nil {
+		sender.startKeepalive()
+	}
+
+	return &BpEndpoint{
+		transport: transport,
+		sender:    sender,
+		receiver:  receiver,
+	}, errors.Join(senderErr, receiverErr)
+}
+
+// Start 受信ループを開始
+func (e *BpEndpoint) Start() {
+	e.receiver.Start()
+}
+
+// Send 指定したEIDへバンドルを送信
+func (e *BpEndpoint) Send(ctx context.Context, to string, data interface{}) error {
+	return e.SendWithOptions(ctx, to, data, SendOptions{})
+}
+
+// SendWithOptions 有効期間と優先度を指定して、指定したEIDへバンドルを送信
+func (e *BpEndpoint) SendWithOptions(ctx context.Context, to string, data interface{}, opts SendOptions) error {
+	remote, err := ParseEID(to)
+	if err != nil {
+		return err
+	}
+	return e.sender.sendTo(ctx, remote, data, opts)
+}
+
+// NewBundleWriter 指定したEIDへrequestIDのストリームを送信するWriterを作成
+func (e *BpEndpoint) NewBundleWriter(ctx context.Context, to, requestID string, opts SendOptions) (*BundleWriter, error) {
+	remote, err := ParseEID(to)
+	if err != nil {
+		return nil, err
+	}
+	return newBundleWriter(ctx, e.sender, remote, requestID, opts), nil
+}
+
+// NewBundleReader requestIDのストリームを読み出すReaderを作成（NewBundleReaderと同じ）
+func (e *BpEndpoint) NewBundleReader(requestID string) (*BundleReader, error) {
+	return NewBundleReader(e.receiver, requestID)
+}
+
+// GetDataChannel 受信データを取得するチャネル（BpReceiver.GetDataChannelと同じ）
+func (e *BpEndpoint) GetDataChannel() <-chan *Bundle {
+	return e.receiver.GetDataChannel()
+}
+
+// Events 受信イベントを取得するチャネル（BpReceiver.Eventsと同じ）
+func (e *BpEndpoint) Events() <-chan Event {
+	return e.receiver.Events()
+}
+
+// SetSourceAllowlist 受け入れる送信元のパターンを実行中に変更する（BpReceiver.SetSourceAllowlistと同じ）
+func (e *BpEndpoint) SetSourceAllowlist(patterns ...string) error {
+	return e.receiver.SetSourceAllowlist(patterns...)
+}
+
+// LinkState キープアライブで判定した現在のリンク状態を返す（WithKeepaliveToを指定しない場合はLinkUnknown）
+func (e *BpEndpoint) LinkState() LinkState {
+	return e.sender.LinkState()
+}
+
+// SubscribeLinkState リンク状態の遷移を受け取るチャネルを返す（BpSender.SubscribeLinkStateと同じ）
+func (e *BpEndpoint) SubscribeLinkState() <-chan LinkStateChange {
+	return e.sender.SubscribeLinkState()
+}
+
+// EndpointStats BpEndpointの送受信統計
+type EndpointStats struct {
+	Sent     SenderStats
+	Received ReceiverStats
+}
+
+// Stats 送受信統計のスナップショットを返す
+func (e *BpEndpoint) Stats() EndpointStats {
+	return EndpointStats{Sent: e.sender.Stats(), Received: e.receiver.Stats()}
+}
+
+// LocalAddr バインドしているローカルアドレスを返す
+func (e *BpEndpoint) LocalAddr() *SockaddrBP {
+	return e.transport.LocalAddr()
+}
+
+// Close 受信ループを停止してソケットをクローズ（複数回呼んでも安全）
+func (e *BpEndpoint) Close() error {
+	e.sender.stop()
+	return e.receiver.Close()
+}
@@ -0,0 +1,149 @@
+// envelope.go - バンドルペイロードに付与するエンベロープヘッダー
+//
+// フォーマット:
+//
+//	+-------+---------+------+-------+-----------------+-----------------+-----------------+-----------------+
+//	| magic | version | type | flags | seq (optional)  | nonce (v2のみ)  | mac (optional)  | payload ...     |
+//	| 1byte | 1byte   | 1byte| 1byte | 8byte BE        | 12byte          | 32byte          |                 |
+//	+-------+---------+------+-------+-----------------+-----------------+-----------------+-----------------+
+//
+// seqはflagSeq、macはflagMACが立っている場合のみ存在する
+// version 2はペイロードがAES-GCMで暗号化されていることを示し、nonceが続く（flagsは暗号化前のペイロードの圧縮方式を表す）
+// macはmacフィールドを除いたヘッダーとペイロードに対するHMAC-SHA256
+// 先頭がmagic以外のバンドル（従来の生JSONは必ず'{'で始まる）はエンベロープなしのレガシー形式として扱う
+package bpsocket
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	envelopeMagic            = 0xB5
+	envelopeVersion          = 1 // 平文のペイロード
+	envelopeVersionEncrypted = 2 // AES-GCMで暗号化されたペイロード
+	envelopeHeaderSize       = 4
+	envelopeSeqSize          = 8
+	envelopeMACSize          = 32
+	envelopeNonceSize        = 12
+)
+
+// エンベロープの種別
+const (
+	envelopeTypeData  byte = 0 // アプリケーションデータ
+	envelopeTypeAck   byte = 1 // 受信確認（seqに確認対象のバンドルIDが入り、ペイロードは空）
+	envelopeTypeChunk byte = 2 // ストリームの断片（ペイロードの先頭にチャンクヘッダーが付く、stream.goを参照）
+	envelopeTypePing  byte = 3 // キープアライブのプローブ（seqにプローブID、ペイロードは空）
+	envelopeTypePong  byte = 4 // pingへの応答（seqに対象のプローブID、ペイロードは空）
+)
+
+// エンベロープのフラグ
+const (
+	flagGzip         byte = 1 << 0 // ペイロードはgzip圧縮済み
+	flagDeflate      byte = 1 << 1 // ペイロードはdeflate圧縮済み
+	flagSeq          byte = 1 << 2 // ヘッダーの後にシーケンス番号が続く
+	flagAckRequested byte = 1 << 3 // 受信側にACKの返送を要求する（seqがバンドルIDとなる）
+	flagMAC          byte = 1 << 4 // ヘッダーの後にHMAC-SHA256が続く
+)
+
+// envelope デコード済みのエンベロープ
+type envelope struct {
+	Type    byte
+	Flags   byte
+	Seq     uint64 // Flags&flagSeqが立っている場合のみ有効
+	Nonce   []byte // 暗号化されている場合（version 2）のみ有効
+	MAC     []byte // Flags&flagMACが立っている場合のみ有効
+	Payload []byte
+	Legacy  bool // エンベロープなしで受信した（従来形式）
+}
+
+// hasSeq シーケンス番号を持つかどうか
+func (e *envelope) hasSeq() bool {
+	return e.Flags&flagSeq != 0
+}
+
+// hasMAC HMACを持つかどうか
+func (e *envelope) hasMAC() bool {
+	return e.Flags&flagMAC != 0
+}
+
+// encrypted ペイロードが暗号化されているかどうか
+func (e *envelope) encrypted() bool {
+	return e.Nonce != nil
+}
+
+// version ペイロードの形式を表すバージョン番号
+func (e *envelope) version() byte {
+	if e.encrypted() {
+		return envelopeVersionEncrypted
+	}
+	return envelopeVersion
+}
+
+// signedHeader MACとAES-GCMの追加認証データの対象となるヘッダー（macフィールドを除く）
+func (e *envelope) signedHeader() []byte {
+	header := []byte{envelopeMagic, e.version(), e.Type, e.Flags}
+	if e.hasSeq() {
+		header = binary.BigEndian.AppendUint64(header, e.Seq)
+	}
+	return append(header, e.Nonce...)
+}
+
+// isEnvelope データがエンベロープ形式かどうかを判定する
+func isEnvelope(data []byte) bool {
+	return len(data) > 0 && data[0] == envelopeMagic
+}
+
+// encodeEnvelope エンベロープをバイト列に変換する
+func encodeEnvelope(e *envelope) []byte {
+	size := envelopeHeaderSize + envelopeSeqSize + envelopeNonceSize + envelopeMACSize + len(e.Payload)
+	buf := append(make([]byte, 0, size), e.signedHeader()...)
+	if e.hasMAC() {
+		buf = append(buf, e.MAC...)
+	}
+	return append(buf, e.Payload...)
+}
+
+// decodeEnvelope バイト列からエンベロープを復元する（レガシー形式はそのままペイロードとして扱う）
+// 返されるPayloadはdataを参照するため、dataを再利用する場合は呼び出し側でコピーすること
+func decodeEnvelope(data []byte) (*envelope, error) {
+	if !isEnvelope(data) {
+		return &envelope{Type: envelopeTypeData, Payload: data, Legacy: true}, nil
+	}
+	if len(data) < envelopeHeaderSize {
+		return nil, fmt.Errorf("envelope too short: %d bytes", len(data))
+	}
+	version := data[1]
+	if version != envelopeVersion && version != envelopeVersionEncrypted {
+		return nil, fmt.Errorf("unsupported envelope version: %d", version)
+	}
+
+	e := &envelope{
+		Type:  data[2],
+		Flags: data[3],
+	}
+	offset := envelopeHeaderSize
+	if e.hasSeq() {
+		if len(data) < offset+envelopeSeqSize {
+			return nil, fmt.Errorf("envelope too short for sequence number: %d bytes", len(data))
+		}
+		e.Seq = binary.BigEndian.Uint64(data[offset:])
+		offset += envelopeSeqSize
+	}
+	if version == envelopeVersionEncrypted {
+		if len(data) < offset+envelopeNonceSize {
+			return nil, fmt.Errorf("envelope too short for nonce: %d bytes", len(data))
+		}
+		e.Nonce = data[offset : offset+envelopeNonceSize]
+		offset += envelopeNonceSize
+	}
+	if e.hasMAC() {
+		if len(data) < offset+envelopeMACSize {
+			return nil, fmt.Errorf("envelope too short for MAC: %d bytes", len(data))
+		}
+		e.MAC = data[offset : offset+envelopeMACSize]
+		offset += envelopeMACSize
+	}
+	e.Payload = data[offset:]
+	return e, nil
+}
@@ -0,0 +1,100 @@
+// errors.go - bp-socket操作で返すエラー定義
+package bpsocket
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// ErrTimeout SO_RCVTIMEO/SO_SNDTIMEOで設定したタイムアウトに達した（実際の障害ではない）
+var ErrTimeout = errors.New("bpsocket: operation timed out")
+
+// ErrModuleNotLoaded AF_BPのカーネルモジュールがロードされていない（ソケットを作成できない）
+var ErrModuleNotLoaded = errors.New("bpsocket: bp kernel module not loaded")
+
+// ErrAddressInUse 別のプロセスが同じEIDにバインドしている
+var ErrAddressInUse = errors.New("bpsocket: address already in use")
+
+// ErrInterrupted シグナルでシステムコールが中断された（再試行すればよい一時的なエラー）
+var ErrInterrupted = errors.New("bpsocket: interrupted system call")
+
+// SyscallError 失敗したシステムコールとerrno
+// errors.IsでErrModuleNotLoadedなどの分類済みのエラーと、元のerrno（syscall.EADDRINUSEなど）の両方に一致する
+type SyscallError struct {
+	Op    string        // システムコール名（"bind"、"sendto"など）
+	Errno syscall.Errno // カーネルが返したerrno
+}
+
+func (e *SyscallError) Error() string {
+	return fmt.Sprintf("%s syscall error: %v", e.Op, e.Errno)
+}
+
+func (e *SyscallError) Unwrap() []error {
+	if kind := classifyErrno(e.Errno); kind != nil {
+		return []error{kind, e.Errno}
+	}
+	return []error{e.Errno}
+}
+
+// newSyscallError システムコールのエラーをSyscallErrorに変換する（errno以外のエラーはそのまま返す）
+func newSyscallError(op string, err error) error {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	return &SyscallError{Op: op, Errno: errno}
+}
+
+// ErrClosed ソケットがクローズ済み
+var ErrClosed = errors.New("bpsocket: socket closed")
+
+// ErrNotAcknowledged 再送回数の上限までにACKが返ってこなかった
+var ErrNotAcknowledged = errors.New("bpsocket: bundle not acknowledged")
+
+// ErrTooManyRecvErrors 受信エラーが連続し、受信ループを停止した
+var ErrTooManyRecvErrors = errors.New("bpsocket: too many consecutive receive errors")
+
+// ErrOptionUnsupported カーネルモジュールがソケットオプションに対応していない
+var ErrOptionUnsupported = errors.New("bpsocket: socket option not supported by kernel module")
+
+// ErrBundleTooLarge エンコード後のバンドルが送信側の最大バンドルサイズを超えている
+// 大きなペイロードはBundleWriterでチャンクに分割して送信する
+var ErrBundleTooLarge = errors.New("bpsocket: bundle too large")
+
+// TruncatedError バンドルが受信バッファに収まらず切り詰められた
+// Sizeは送信されたバンドルの実際のサイズで、送信側が分割送信に切り替える判断に使える
+type TruncatedError struct {
+	Size  int // バンドルの実際のサイズ
+	Limit int // 受信できる最大サイズ
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("bpsocket: bundle truncated: %d bytes exceeds receive limit of %d bytes", e.Size, e.Limit)
+}
+
+// errControlBundle ACKなどの制御バンドルで、アプリケーションには渡さない
+var errControlBundle = errors.New("bpsocket: control bundle")
+
+// errAuthFailed バンドルのMACが検証できない、または必須のMACがない
+var errAuthFailed = errors.New("bpsocket: bundle authentication failed")
+
+var (
+	errMACMismatch = fmt.Errorf("%w: MAC mismatch", errAuthFailed)
+	errMACMissing  = fmt.Errorf("%w: missing MAC", errAuthFailed)
+)
+
+// errDecryptFailed 暗号化されたバンドルをどの鍵でも復号できない（鍵の不一致または改ざん）
+var errDecryptFailed = errors.New("bpsocket: bundle decryption failed")
+
+// errNoDecryptionKey 暗号化されたバンドルを受信したが復号鍵が設定されていない
+var errNoDecryptionKey = fmt.Errorf("%w: no decryption key configured", errDecryptFailed)
+
+// errSourceRejected 許可されていない送信元からのバンドル
+var errSourceRejected = errors.New("bpsocket: source not allowed")
+
+// errDataChannelFull データチャネルが満杯でバンドルを渡せない
+var errDataChannelFull = errors.New("bpsocket: data channel full")
+
+// errDuplicateBundle 重複検出ウィンドウ内で既に受信済みのバンドル
+var errDuplicateBundle = errors.New("bpsocket: duplicate bundle")
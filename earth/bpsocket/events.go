@@ -0,0 +1,89 @@
+// events.go - BpReceiverの受信イベント
+// 受信エラーやバンドルの破棄はログに出すだけでは呼び出し側が対処できないため、イベントとして通知する
+package bpsocket
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType 受信イベントの種別
+type EventType int
+
+const (
+	EventRecvError      EventType = iota // ソケットからの受信に失敗した
+	EventBundleDropped                   // 受信したバンドルを破棄した（重複・デコード失敗・チャネル満杯）
+	EventTruncated                       // バンドルがバッファに収まらず切り詰められた
+	EventClosed                          // 受信ループが終了した（Errがnilでなければ異常終了）
+	EventSourceRejected                  // 許可されていない送信元からのバンドルを破棄した
+	EventAuthFailed                      // MACの検証に失敗したバンドルを破棄した
+	EventDecryptFailed                   // 暗号化されたバンドルを復号できずに破棄した
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventRecvError:
+		return "RecvError"
+	case EventBundleDropped:
+		return "BundleDropped"
+	case EventTruncated:
+		return "Truncated"
+	case EventClosed:
+		return "Closed"
+	case EventSourceRejected:
+		return "SourceRejected"
+	case EventAuthFailed:
+		return "AuthFailed"
+	case EventDecryptFailed:
+		return "DecryptFailed"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event 受信ループで発生したイベント
+type Event struct {
+	Type   EventType
+	Time   time.Time
+	Err    error       // RecvError: 受信エラー、Closed: 終了原因（Closeによる正常終了ならnil）
+	From   *SockaddrBP // BundleDropped/Truncated/SourceRejected: 送信元
+	Size   int         // BundleDropped/Truncated/SourceRejected: 受信バイト数
+	Reason string      // BundleDropped/AuthFailed/DecryptFailed: 破棄した理由
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case EventRecvError, EventClosed:
+		if e.Err != nil {
+			return fmt.Sprintf("%s: %v", e.Type, e.Err)
+		}
+		return e.Type.String()
+	case EventBundleDropped, EventAuthFailed, EventDecryptFailed:
+		return fmt.Sprintf("%s: %d bytes from %s (%s)", e.Type, e.Size, e.From.String(), e.Reason)
+	default:
+		return fmt.Sprintf("%s: %d bytes from %s", e.Type, e.Size, e.From.String())
+	}
+}
+
+// eventQueueSize イベントチャネルのバッファサイズ（読まれない場合は古いイベントを待たずに捨てる）
+const eventQueueSize = 64
+
+// Events 受信イベントを取得するチャネル（受信ループ終了時にEventClosedを送ってからクローズされる）
+// 読み出しが追いつかない場合、イベントは破棄される（受信処理はブロックしない）
+func (r *BpReceiver) Events() <-chan Event {
+	return r.events
+}
+
+// emit イベントを通知する
+func (r *BpReceiver) emit(ev Event) {
+	ev.Time = time.Now()
+	select {
+	case r.events <- ev:
+	default:
+	}
+}
+
+// emitDropped バンドル破棄のイベントを通知する
+func (r *BpReceiver) emitDropped(from *SockaddrBP, size int, reason string) {
+	r.emit(Event{Type: EventBundleDropped, From: from, Size: size, Reason: reason})
+}
@@ -0,0 +1,135 @@
+// events_test.go - 受信イベントと連続エラー時の停止ポリシーのテスト
+package bpsocket
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDeviceGone = errors.New("no such device")
+
+// scriptedTransport 登録された結果を順に返し、尽きたら常にerrDeviceGoneを返すTransport
+type scriptedTransport struct {
+	mu      sync.Mutex
+	results []error // nilの場合は"{}"を1バンドル受信する
+}
+
+func (s *scriptedTransport) Recv(buf []byte) (int, *SockaddrBP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.results) == 0 {
+		return 0, nil, errDeviceGone
+	}
+	err := s.results[0]
+	s.results = s.results[1:]
+	if err != nil {
+		return 0, nil, err
+	}
+	return copy(buf, "{}"), NewSockaddrBP(150, 2), nil
+}
+
+func (s *scriptedTransport) SendTo(data []byte, to *SockaddrBP) error { return nil }
+func (s *scriptedTransport) Close() error                             { return nil }
+func (s *scriptedTransport) LocalAddr() *SockaddrBP                   { return NewSockaddrBP(149, 1) }
+
+// collectEvents イベントチャネルがクローズされるまでイベントを集める
+func collectEvents(t *testing.T, r *BpReceiver) []Event {
+	t.Helper()
+	var events []Event
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-r.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatalf("Event channel was not closed, got %v", events)
+		}
+	}
+}
+
+func countEvents(events []Event, typ EventType) int {
+	n := 0
+	for _, ev := range events {
+		if ev.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReceiverStopsAfterConsecutiveErrors(t *testing.T) {
+	r := NewBpReceiverWithTransport(&scriptedTransport{}, WithMaxConsecutiveErrors(3))
+	r.Start()
+	defer r.Close()
+
+	events := collectEvents(t, r)
+
+	if n := countEvents(events, EventRecvError); n != 3 {
+		t.Errorf("Expected 3 RecvError events, got %d", n)
+	}
+	last := events[len(events)-1]
+	if last.Type != EventClosed {
+		t.Fatalf("Expected last event to be Closed, got %v", last)
+	}
+	if !errors.Is(last.Err, ErrTooManyRecvErrors) || !errors.Is(last.Err, errDeviceGone) {
+		t.Errorf("Expected Closed cause to wrap ErrTooManyRecvErrors and the last error, got %v", last.Err)
+	}
+	if _, ok := <-r.GetDataChannel(); ok {
+		t.Error("Expected data channel to be closed")
+	}
+}
+
+func TestReceiverErrorCountResetsOnSuccess(t *testing.T) {
+	transport := &scriptedTransport{results: []error{errDeviceGone, errDeviceGone, nil, errDeviceGone, errDeviceGone, nil}}
+	r := NewBpReceiverWithTransport(transport, WithMaxConsecutiveErrors(3))
+	r.Start()
+	defer r.Close()
+
+	// 2連続エラーの後に受信が成功するため、末尾の3連続エラーまで停止しない
+	events := collectEvents(t, r)
+	if n := countEvents(events, EventRecvError); n != 7 {
+		t.Errorf("Expected 7 RecvError events, got %d", n)
+	}
+
+	delivered := 0
+	for bundle := range r.GetDataChannel() {
+		bundle.Release()
+		delivered++
+	}
+	if delivered != 2 {
+		t.Errorf("Expected 2 bundles delivered, got %d", delivered)
+	}
+}
+
+func TestReceiverEmitsDroppedEvents(t *testing.T) {
+	_, receiver, sendTransport := newCompressedLoopbackPair(t, CompressionNone, 0)
+
+	corrupt := encodeEnvelope(&envelope{Type: envelopeTypeData, Flags: flagGzip, Payload: []byte("not gzip")})
+	if err := sendTransport.Send(corrupt, 149, 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case ev := <-receiver.Events():
+		if ev.Type != EventBundleDropped || ev.Size != len(corrupt) || ev.From.String() != "ipn:150.2" {
+			t.Errorf("Unexpected event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a BundleDropped event")
+	}
+}
+
+func TestReceiverCloseEmitsCleanClosedEvent(t *testing.T) {
+	_, receiver, _ := newCompressedLoopbackPair(t, CompressionNone, 0)
+	receiver.Close()
+
+	events := collectEvents(t, receiver)
+	if len(events) != 1 || events[0].Type != EventClosed || events[0].Err != nil {
+		t.Errorf("Expected a single clean Closed event, got %v", events)
+	}
+}
@@ -0,0 +1,300 @@
+// keepalive.go - キープアライブ（ping/pong）によるリンク状態の検出
+//
+// 送信側は一定間隔でpingバンドル（envelopeTypePing、seqにプローブID）を送信する
+// 受信側のBpReceiverはライブラリ内で同じIDのpongバンドルを返し、アプリケーションのチャネルには渡さない
+// 送信側は直近のプローブの応答時間と喪失からリンク状態（Up/Degraded/Down）を判定する
+package bpsocket
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// LinkState キープアライブで判定したリンク状態
+type LinkState int
+
+const (
+	LinkUnknown  LinkState = iota // まだプローブの結果がない
+	LinkUp                        // 直近のプローブがすべて応答し、応答時間も閾値以下
+	LinkDegraded                  // プローブの一部が失われた、または応答時間が閾値を超えた
+	LinkDown                      // プローブが連続して失われた
+)
+
+func (s LinkState) String() string {
+	switch s {
+	case LinkUnknown:
+		return "Unknown"
+	case LinkUp:
+		return "Up"
+	case LinkDegraded:
+		return "Degraded"
+	case LinkDown:
+		return "Down"
+	default:
+		return "LinkState(?)"
+	}
+}
+
+// LinkStateChange リンク状態の遷移
+type LinkStateChange struct {
+	From    LinkState
+	To      LinkState
+	Time    time.Time
+	Latency time.Duration // 遷移の原因となったプローブの応答時間（喪失の場合は0）
+}
+
+// KeepaliveConfig キープアライブの設定（ゼロ値の項目はデフォルト値を使う）
+type KeepaliveConfig struct {
+	Interval        time.Duration // pingの送信間隔（デフォルト30秒）
+	Timeout         time.Duration // pongを待つ時間、超えたプローブは喪失とみなす（デフォルトはInterval）
+	DegradedLatency time.Duration // 平均応答時間がこれを超えるとDegraded（デフォルトはTimeoutの半分）
+	DownAfter       int           // 連続してこの回数失われるとDown（デフォルト3）
+	Window          int           // 判定に使う直近のプローブ数（デフォルト5、DownAfter未満の場合はDownAfter）
+}
+
+const (
+	defaultKeepaliveInterval  = 30 * time.Second
+	defaultKeepaliveDownAfter = 3
+	defaultKeepaliveWindow    = 5
+
+	// linkStateQueueSize 購読チャネルのバッファサイズ（読まれない場合は遷移を捨てる）
+	linkStateQueueSize = 16
+)
+
+func (c KeepaliveConfig) withDefaults() KeepaliveConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultKeepaliveInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = c.Interval
+	}
+	if c.DegradedLatency <= 0 {
+		c.DegradedLatency = c.Timeout / 2
+	}
+	if c.DownAfter <= 0 {
+		c.DownAfter = defaultKeepaliveDownAfter
+	}
+	if c.Window <= 0 {
+		c.Window = defaultKeepaliveWindow
+	}
+	c.Window = max(c.Window, c.DownAfter)
+	return c
+}
+
+// probeResult 1回のプローブの結果（lostでなければlatencyが有効）
+type probeResult struct {
+	latency time.Duration
+	lost    bool
+}
+
+// keepalive プローブの送信と応答からリンク状態を管理する
+type keepalive struct {
+	conf KeepaliveConfig
+	peer *SockaddrBP
+
+	mu          sync.Mutex
+	nextID      uint64
+	pending     map[uint64]time.Time
+	results     []probeResult
+	state       LinkState
+	subscribers []chan LinkStateChange
+	closed      bool
+}
+
+func newKeepalive(conf KeepaliveConfig, peer *SockaddrBP) *keepalive {
+	return &keepalive{
+		conf:    conf.withDefaults(),
+		peer:    peer,
+		nextID:  uint64(time.Now().UnixNano()),
+		pending: make(map[uint64]time.Time),
+	}
+}
+
+// WithKeepalive 送信先へ定期的にpingを送り、リンク状態を判定する（LinkStateとSubscribeLinkStateで取得する）
+func WithKeepalive(conf KeepaliveConfig) SenderOption {
+	return func(s *BpSender) {
+		s.keepalive = newKeepalive(conf, s.remoteAddr)
+	}
+}
+
+// nextProbe 応答のないプローブを喪失として記録し、次のプローブIDを登録する
+func (k *keepalive) nextProbe(now time.Time) uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for id, sent := range k.pending {
+		if now.Sub(sent) >= k.conf.Timeout {
+			delete(k.pending, id)
+			k.recordLocked(probeResult{lost: true}, now)
+		}
+	}
+
+	k.nextID++
+	k.pending[k.nextID] = now
+	return k.nextID
+}
+
+// pong pongを受け取ったプローブの応答時間を記録する（期限切れ・未知のIDは無視する）
+func (k *keepalive) pong(id uint64, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	sent, ok := k.pending[id]
+	if !ok {
+		return
+	}
+	delete(k.pending, id)
+	k.recordLocked(probeResult{latency: now.Sub(sent)}, now)
+}
+
+// recordLocked プローブの結果を記録し、リンク状態が変わった場合は購読者に通知する
+func (k *keepalive) recordLocked(r probeResult, now time.Time) {
+	k.results = append(k.results, r)
+	if len(k.results) > k.conf.Window {
+		k.results = k.results[len(k.results)-k.conf.Window:]
+	}
+
+	next := k.evaluateLocked()
+	if next == k.state {
+		return
+	}
+	change := LinkStateChange{From: k.state, To: next, Time: now, Latency: r.latency}
+	k.state = next
+	log.Printf("[Keepalive] Link to %s is %s (was %s)", k.peer.String(), change.To, change.From)
+	for _, ch := range k.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// evaluateLocked 直近のプローブ結果からリンク状態を判定する
+func (k *keepalive) evaluateLocked() LinkState {
+	if len(k.results) == 0 {
+		return LinkUnknown
+	}
+
+	consecutiveLost := 0
+	for i := len(k.results) - 1; i >= 0 && k.results[i].lost; i-- {
+		consecutiveLost++
+	}
+	if consecutiveLost >= k.conf.DownAfter {
+		return LinkDown
+	}
+
+	var total time.Duration
+	answered := 0
+	for _, r := range k.results {
+		if r.lost {
+			return LinkDegraded
+		}
+		total += r.latency
+		answered++
+	}
+	if total/time.Duration(answered) > k.conf.DegradedLatency {
+		return LinkDegraded
+	}
+	return LinkUp
+}
+
+func (k *keepalive) current() LinkState {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.state
+}
+
+func (k *keepalive) subscribe() <-chan LinkStateChange {
+	ch := make(chan LinkStateChange, linkStateQueueSize)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		close(ch)
+		return ch
+	}
+	k.subscribers = append(k.subscribers, ch)
+	return ch
+}
+
+func (k *keepalive) closeSubscribers() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, ch := range k.subscribers {
+		close(ch)
+	}
+	k.subscribers = nil
+	k.closed = true
+}
+
+// startKeepalive pingの送信ループを開始する
+func (s *BpSender) startKeepalive() {
+	go s.keepaliveLoop()
+}
+
+func (s *BpSender) keepaliveLoop() {
+	k := s.keepalive
+	defer k.closeSubscribers()
+
+	ticker := time.NewTicker(k.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.sendPing(k.nextProbe(time.Now()))
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendPing pingバンドルを送信する（期限内に届かないpingは意味がないため、有効期間はTimeoutとする）
+func (s *BpSender) sendPing(id uint64) {
+	k := s.keepalive
+	env := &envelope{Type: envelopeTypePing, Flags: flagSeq, Seq: id}
+	if s.hmacKey != nil {
+		signEnvelope(s.hmacKey, env)
+	}
+	opts := SendOptions{Lifetime: k.conf.Timeout, Priority: PriorityExpedited}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.conf.Timeout)
+	defer cancel()
+	if err := s.sendLocked(ctx, k.peer, encodeEnvelope(env), opts); err != nil {
+		log.Printf("[Keepalive] WARNING: Failed to send ping to %s: %v", k.peer.String(), err)
+	}
+}
+
+// handlePong 受信したpongをキープアライブに通知する（ACKと同様に受信ループから呼ばれる）
+func (s *BpSender) handlePong(id uint64) {
+	if s.keepalive != nil {
+		s.keepalive.pong(id, time.Now())
+	}
+}
+
+// LinkState キープアライブで判定した現在のリンク状態を返す（キープアライブが無効の場合はLinkUnknown）
+func (s *BpSender) LinkState() LinkState {
+	if s.keepalive == nil {
+		return LinkUnknown
+	}
+	return s.keepalive.current()
+}
+
+// SubscribeLinkState リンク状態の遷移を受け取るチャネルを返す（送信側をCloseするとクローズされる）
+// キープアライブが無効の場合はnilを返す
+func (s *BpSender) SubscribeLinkState() <-chan LinkStateChange {
+	if s.keepalive == nil {
+		return nil
+	}
+	return s.keepalive.subscribe()
+}
+
+// encodePong プローブIDに対するpongバンドルを作成する（macKeyがnilでなければ署名する）
+func encodePong(id uint64, macKey []byte) []byte {
+	env := &envelope{Type: envelopeTypePong, Flags: flagSeq, Seq: id}
+	if macKey != nil {
+		signEnvelope(macKey, env)
+	}
+	return encodeEnvelope(env)
+}
@@ -0,0 +1,136 @@
+// keepalive_test.go - キープアライブによるリンク状態検出のテスト
+package bpsocket
+
+import (
+	"testing"
+	"time"
+)
+
+var testKeepalive = KeepaliveConfig{
+	Interval:        20 * time.Millisecond,
+	Timeout:         100 * time.Millisecond,
+	DegradedLatency: 40 * time.Millisecond,
+	DownAfter:       3,
+	Window:          3,
+}
+
+// waitForLinkState 指定した状態への遷移が通知されるまで待つ
+func waitForLinkState(t *testing.T, changes <-chan LinkStateChange, want LinkState) {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				t.Fatalf("Subscription closed before reaching %s", want)
+			}
+			if c.To == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Link did not become %s", want)
+		}
+	}
+}
+
+func TestKeepaliveDrivesLinkState(t *testing.T) {
+	network := NewLoopbackNetwork()
+	earth, moon := newEndpointPair(t, network, WithKeepaliveTo("ipn:149.1", testKeepalive))
+	changes := earth.SubscribeLinkState()
+
+	waitForLinkState(t, changes, LinkUp)
+
+	network.SetDropRate(1.0)
+	waitForLinkState(t, changes, LinkDown)
+	if earth.LinkState() != LinkDown {
+		t.Errorf("Expected LinkState to report Down, got %s", earth.LinkState())
+	}
+
+	// 復旧直後は直近のプローブに喪失が残っているためDegradedを経由する
+	network.SetDropRate(0)
+	waitForLinkState(t, changes, LinkDegraded)
+	waitForLinkState(t, changes, LinkUp)
+
+	// 往復の遅延が閾値を超えるとDegraded
+	network.SetDelay(30 * time.Millisecond)
+	waitForLinkState(t, changes, LinkDegraded)
+
+	// pingとpongはアプリケーションのチャネルに渡らない
+	for _, ep := range []*BpEndpoint{earth, moon} {
+		select {
+		case b := <-ep.GetDataChannel():
+			t.Errorf("Unexpected bundle on data channel: %q", b.Data)
+		default:
+		}
+	}
+}
+
+func TestKeepaliveStandaloneSender(t *testing.T) {
+	network := NewLoopbackNetwork()
+	recvTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	receiver := NewBpReceiverWithTransport(recvTransport)
+	receiver.Start()
+	defer receiver.Close()
+
+	sendTransport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sender := NewBpSenderWithTransport(sendTransport, 149, 1, WithKeepalive(testKeepalive))
+	changes := sender.SubscribeLinkState()
+
+	waitForLinkState(t, changes, LinkUp)
+	if _, ok := receiveWithin(t, receiver, 50*time.Millisecond); ok {
+		t.Error("Expected pings not to be delivered to the receiver's data channel")
+	}
+
+	sender.Close()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Expected the subscription to be closed after Close")
+		}
+	}
+}
+
+func TestLinkStateWithoutKeepalive(t *testing.T) {
+	sender := newBpSender(nil, NewSockaddrBP(149, 1))
+	if sender.LinkState() != LinkUnknown || sender.SubscribeLinkState() != nil {
+		t.Error("Expected no link state without keepalive")
+	}
+}
+
+func TestEvaluateLinkState(t *testing.T) {
+	ok := func(ms int) probeResult { return probeResult{latency: time.Duration(ms) * time.Millisecond} }
+	lost := probeResult{lost: true}
+
+	tests := []struct {
+		name    string
+		results []probeResult
+		want    LinkState
+	}{
+		{"no probes", nil, LinkUnknown},
+		{"all answered quickly", []probeResult{ok(5), ok(10), ok(5)}, LinkUp},
+		{"slow answers", []probeResult{ok(50), ok(60), ok(50)}, LinkDegraded},
+		{"single loss", []probeResult{ok(5), lost, ok(5)}, LinkDegraded},
+		{"consecutive losses", []probeResult{lost, lost, lost}, LinkDown},
+		{"losses below threshold", []probeResult{ok(5), lost, lost}, LinkDegraded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKeepalive(testKeepalive, NewSockaddrBP(149, 1))
+			k.results = tt.results
+			if got := k.evaluateLocked(); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// loopback_test.go - ループバックトランスポートとSender/Receiverの結合テスト
+package bpsocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newLoopbackPair 同じネットワーク上のSender(150.2 -> 149.1)とReceiver(149.1)を作成する
+func newLoopbackPair(t *testing.T, network *LoopbackNetwork) (*BpSender, *BpReceiver) {
+	t.Helper()
+
+	recvTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sendTransport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	receiver := NewBpReceiverWithTransport(recvTransport)
+	receiver.Start()
+	sender := NewBpSenderWithTransport(sendTransport, 149, 1)
+
+	t.Cleanup(func() {
+		receiver.Close()
+		sender.Close()
+	})
+	return sender, receiver
+}
+
+func receiveWithin(t *testing.T, receiver *BpReceiver, d time.Duration) ([]byte, bool) {
+	t.Helper()
+	select {
+	case bundle, ok := <-receiver.GetDataChannel():
+		if !ok {
+			return nil, false
+		}
+		data := append([]byte(nil), bundle.Data...)
+		bundle.Release()
+		return data, true
+	case <-time.After(d):
+		return nil, false
+	}
+}
+
+func TestLoopbackRoundTrip(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork())
+
+	msg := map[string]string{"request_id": "r1", "url": "https://example.com"}
+	if err := sender.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	data, ok := receiveWithin(t, receiver, time.Second)
+	if !ok {
+		t.Fatal("Bundle was not delivered")
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Invalid payload: %v", err)
+	}
+	if got["url"] != "https://example.com" {
+		t.Errorf("Unexpected payload: %v", got)
+	}
+}
+
+func TestLoopbackDelay(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork(WithDelay(150*time.Millisecond)))
+
+	start := time.Now()
+	if err := sender.Send(context.Background(), "delayed"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, ok := receiveWithin(t, receiver, time.Second); !ok {
+		t.Fatal("Bundle was not delivered")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Bundle arrived after %v, expected at least the configured delay", elapsed)
+	}
+}
+
+func TestLoopbackDropRate(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork(WithDropRate(1.0)))
+
+	for i := 0; i < 10; i++ {
+		if err := sender.Send(context.Background(), i); err != nil {
+			t.Fatalf("Send must not report DTN loss as an error: %v", err)
+		}
+	}
+	if _, ok := receiveWithin(t, receiver, 200*time.Millisecond); ok {
+		t.Error("Expected every bundle to be dropped")
+	}
+}
+
+func TestLoopbackPartialDropIsDeterministicWithSeed(t *testing.T) {
+	count := func() int {
+		sender, receiver := newLoopbackPair(t, NewLoopbackNetwork(WithDropRate(0.5), WithSeed(42)))
+		for i := 0; i < 50; i++ {
+			sender.Send(context.Background(), i)
+		}
+		n := 0
+		for {
+			if _, ok := receiveWithin(t, receiver, 100*time.Millisecond); !ok {
+				return n
+			}
+			n++
+		}
+	}
+
+	first := count()
+	if first == 0 || first == 50 {
+		t.Fatalf("Expected partial loss with drop rate 0.5, got %d/50 delivered", first)
+	}
+	if second := count(); second != first {
+		t.Errorf("Expected the same loss pattern with a fixed seed, got %d and %d", first, second)
+	}
+}
+
+func TestLoopbackListenRejectsDuplicateAddress(t *testing.T) {
+	network := NewLoopbackNetwork()
+	first, err := network.Listen(150, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if _, err := network.Listen(150, 1); err == nil {
+		t.Error("Expected duplicate bind to fail")
+	}
+
+	first.Close()
+	if _, err := network.Listen(150, 1); err != nil {
+		t.Errorf("Expected address to be reusable after Close, got %v", err)
+	}
+}
+
+func TestLoopbackListenTwiceReturnsAddressInUse(t *testing.T) {
+	network := NewLoopbackNetwork()
+	first, err := network.Listen(150, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer first.Close()
+
+	if _, err := network.Listen(150, 1); !errors.Is(err, ErrAddressInUse) {
+		t.Errorf("Expected ErrAddressInUse, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+// multireceiver.go - 複数のサービス番号のソケットを1つのgoroutineで受信するMultiReceiver
+// ソケットごとに受信goroutineを立てずに、epoll（Linux）で全ソケットを待機し、
+// 受信したバンドルを受信したエンドポイントのサービス番号とともに1つのチャネルへ渡す
+// エンベロープの解釈・認証・重複検出などはエンドポイントごとのBpReceiverがそのまま行う
+package bpsocket
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// poller 複数のファイルディスクリプタの受信待機（Linuxではepoll、テストでは偽物に差し替える）
+type poller interface {
+	add(fd int) error
+	remove(fd int) error
+	// wait 読み込み可能なファイルディスクリプタを返す（timeoutまでに何もなければ空）
+	wait(timeout time.Duration) ([]int, error)
+	close() error
+}
+
+// pollable pollerで待機できるTransport（BpSocket）
+type pollable interface {
+	Transport
+	pollFd() int
+}
+
+// ServiceBundle MultiReceiverが受信したバンドル
+// 処理後にReleaseを呼んでバッファをプールへ返却すること（Bundleと同じ）
+type ServiceBundle struct {
+	*Bundle
+
+	// ServiceNum 受信したエンドポイントのサービス番号（dtnスキームの場合は0）
+	ServiceNum uint64
+	// Local 受信したエンドポイントのアドレス
+	Local *SockaddrBP
+}
+
+// multiEndpoint MultiReceiverに登録されたエンドポイント
+type multiEndpoint struct {
+	transport pollable
+	receiver  *BpReceiver
+}
+
+// MultiReceiver 複数のBpSocketを1つの受信ループで待機する
+// エンドポイントは受信中でもAdd/Removeで追加・削除できる
+type MultiReceiver struct {
+	poller   poller
+	dataChan chan ServiceBundle
+
+	// mu エンドポイント一覧を保護する（受信ループはソケットを読む間保持し、Remove後のfdを読まないようにする）
+	mu        sync.Mutex
+	endpoints map[int]*multiEndpoint
+
+	started   atomic.Bool
+	stopChan  chan struct{}
+	loopDone  chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewMultiReceiver エンドポイントを持たないMultiReceiverを作成する（AddでBpSocketを登録する）
+func NewMultiReceiver() (*MultiReceiver, error) {
+	p, err := newPoller()
+	if err != nil {
+		return nil, err
+	}
+	return newMultiReceiver(p), nil
+}
+
+func newMultiReceiver(p poller) *MultiReceiver {
+	return &MultiReceiver{
+		poller:    p,
+		dataChan:  make(chan ServiceBundle, 100),
+		endpoints: make(map[int]*multiEndpoint),
+		stopChan:  make(chan struct{}),
+		loopDone:  make(chan struct{}),
+	}
+}
+
+// Add ソケットを登録して受信対象に加える（受信中でも呼べる）
+// optsはこのエンドポイントのバンドルの解釈に使う（重複検出、認証、復号など）
+func (m *MultiReceiver) Add(socket *BpSocket, opts ...ReceiverOption) error {
+	return m.add(socket, opts)
+}
+
+func (m *MultiReceiver) add(transport pollable, opts []ReceiverOption) error {
+	// 受信タイムアウトも設定されるため、読み込み可能と通知されたソケットが空でも受信ループは止まらない
+	r, err := newBpReceiverWithOptions(transport, opts)
+	if err != nil {
+		return err
+	}
+
+	local := transport.LocalAddr()
+	ep := &multiEndpoint{transport: transport, receiver: r}
+	r.forward = func(bundle *Bundle) bool {
+		select {
+		case m.dataChan <- ServiceBundle{Bundle: bundle, ServiceNum: uint64(local.SvcNum), Local: local}:
+			return true
+		default:
+			return false
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.isClosed() {
+		return ErrClosed
+	}
+	fd := transport.pollFd()
+	if _, ok := m.endpoints[fd]; ok {
+		return fmt.Errorf("endpoint %s is already registered", local.String())
+	}
+	if err := m.poller.add(fd); err != nil {
+		return fmt.Errorf("failed to register %s: %w", local.String(), err)
+	}
+	m.endpoints[fd] = ep
+	log.Printf("[MultiReceiver] Listening on %s", local.String())
+	return nil
+}
+
+// Remove ソケットを受信対象から外してクローズする（受信中でも呼べる）
+func (m *MultiReceiver) Remove(socket *BpSocket) error {
+	return m.remove(socket)
+}
+
+func (m *MultiReceiver) remove(transport pollable) error {
+	m.mu.Lock()
+	fd := transport.pollFd()
+	ep, ok := m.endpoints[fd]
+	if !ok || ep.transport != transport {
+		m.mu.Unlock()
+		return fmt.Errorf("endpoint %s is not registered", transport.LocalAddr().String())
+	}
+	delete(m.endpoints, fd)
+	err := m.poller.remove(fd)
+	m.mu.Unlock()
+
+	if err != nil {
+		log.Printf("[MultiReceiver] WARNING: %v", err)
+	}
+	log.Printf("[MultiReceiver] Stopped listening on %s", transport.LocalAddr().String())
+	return transport.Close()
+}
+
+// Endpoints 登録されているエンドポイントのアドレスを返す
+func (m *MultiReceiver) Endpoints() []*SockaddrBP {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addrs := make([]*SockaddrBP, 0, len(m.endpoints))
+	for _, ep := range m.endpoints {
+		addrs = append(addrs, ep.transport.LocalAddr())
+	}
+	return addrs
+}
+
+// Start 受信ループを開始
+func (m *MultiReceiver) Start() {
+	m.started.Store(true)
+	go m.receiveLoop()
+}
+
+// GetDataChannel すべてのエンドポイントの受信データを取得するチャネル
+func (m *MultiReceiver) GetDataChannel() <-chan ServiceBundle {
+	return m.dataChan
+}
+
+// Stats エンドポイントごとの受信統計を返す（キーはエンドポイントのアドレス）
+func (m *MultiReceiver) Stats() map[string]ReceiverStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]ReceiverStats, len(m.endpoints))
+	for _, ep := range m.endpoints {
+		stats[ep.transport.LocalAddr().String()] = ep.receiver.Stats()
+	}
+	return stats
+}
+
+func (m *MultiReceiver) isClosed() bool {
+	select {
+	case <-m.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close 受信ループを停止し、すべてのソケットをクローズしてデータチャネルをクローズする（複数回呼んでも安全）
+func (m *MultiReceiver) Close() error {
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		close(m.stopChan)
+		m.mu.Unlock()
+		if m.started.Load() {
+			<-m.loopDone
+		}
+
+		m.mu.Lock()
+		endpoints := m.endpoints
+		m.endpoints = make(map[int]*multiEndpoint)
+		m.mu.Unlock()
+
+		var errs []error
+		for _, ep := range endpoints {
+			errs = append(errs, ep.transport.Close())
+		}
+		errs = append(errs, m.poller.close())
+		close(m.dataChan)
+		m.closeErr = errors.Join(errs...)
+	})
+	return m.closeErr
+}
+
+func (m *MultiReceiver) receiveLoop() {
+	defer close(m.loopDone)
+
+	var buf []byte
+	for {
+		select {
+		case <-m.stopChan:
+			log.Println("[MultiReceiver] Receive loop stopped")
+			return
+		default:
+		}
+
+		fds, err := m.poller.wait(recvPollInterval)
+		if err != nil {
+			log.Printf("[MultiReceiver] Poll error: %v", err)
+			// pollerが壊れた場合に空回りしないよう、少し待ってから再試行する
+			select {
+			case <-m.stopChan:
+			case <-time.After(recvPollInterval):
+			}
+			continue
+		}
+
+		m.mu.Lock()
+		for _, fd := range fds {
+			ep, ok := m.endpoints[fd]
+			if !ok {
+				// waitの後にRemoveされた
+				continue
+			}
+			r := ep.receiver
+			if len(buf) < r.maxBundleSize {
+				buf = make([]byte, r.maxBundleSize)
+			}
+			m.receiveFrom(ep, buf[:r.maxBundleSize])
+		}
+		m.mu.Unlock()
+	}
+}
+
+// receiveFrom 読み込み可能になったエンドポイントから1つのバンドルを受信して処理する
+func (m *MultiReceiver) receiveFrom(ep *multiEndpoint, buf []byte) {
+	r := ep.receiver
+	n, fromAddr, err := ep.transport.Recv(buf)
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrInterrupted) || errors.Is(err, ErrClosed) {
+		return
+	}
+	if err != nil {
+		r.stats.errors.Add(1)
+		log.Printf("[MultiReceiver] Recv error on %s: %v", ep.transport.LocalAddr().String(), err)
+		return
+	}
+
+	log.Printf("[MultiReceiver] Received %d bytes on %s from %s", n, ep.transport.LocalAddr().String(), fromAddr.String())
+	r.recordReceive(fromAddr, n, r.dispatch(buf, n, fromAddr))
+}
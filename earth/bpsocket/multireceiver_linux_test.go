@@ -0,0 +1,109 @@
+//go:build linux
+// +build linux
+
+// multireceiver_linux_test.go - epollを使ったMultiReceiverのテスト
+// AF_BPカーネルモジュールがなくても動作を確認できるよう、AF_UNIXのデータグラムソケットペアで代用する
+package bpsocket
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestEndpointPair サービス番号svcのBpSocketと、それに書き込む相手側のfdを作成する
+func newTestEndpointPair(t *testing.T, svc uint64) (*BpSocket, int) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("socketpair failed: %v", err)
+	}
+	sock := &BpSocket{fd: fds[0], localAddr: NewSockaddrBP(150, svc)}
+	t.Cleanup(func() {
+		sock.Close()
+		syscall.Close(fds[1])
+	})
+	return sock, fds[1]
+}
+
+func TestMultiReceiverEpoll(t *testing.T) {
+	m, err := NewMultiReceiver()
+	if err != nil {
+		t.Fatalf("NewMultiReceiver failed: %v", err)
+	}
+	defer m.Close()
+
+	crawl, crawlPeer := newTestEndpointPair(t, 1)
+	control, controlPeer := newTestEndpointPair(t, 3)
+	for _, s := range []*BpSocket{crawl, control} {
+		if err := m.Add(s); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	m.Start()
+
+	write := func(fd int, data string) {
+		t.Helper()
+		if _, err := syscall.Write(fd, []byte(data)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	write(controlPeer, `"control"`)
+	write(crawlPeer, `"crawl"`)
+
+	got := make(map[uint64]string)
+	for range 2 {
+		b := receiveServiceBundle(t, m)
+		got[b.ServiceNum] = string(b.Data)
+		b.Release()
+	}
+	if got[1] != `"crawl"` || got[3] != `"control"` {
+		t.Fatalf("Unexpected deliveries: %v", got)
+	}
+
+	// 受信中にエンドポイントを削除・追加する
+	if err := m.Remove(control); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	extra, extraPeer := newTestEndpointPair(t, 5)
+	if err := m.Add(extra); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	write(extraPeer, `"extra"`)
+
+	b := receiveServiceBundle(t, m)
+	if b.ServiceNum != 5 || string(b.Data) != `"extra"` {
+		t.Errorf("Expected the bundle from the added endpoint, got service %d: %q", b.ServiceNum, b.Data)
+	}
+	b.Release()
+
+	select {
+	case b := <-m.GetDataChannel():
+		t.Errorf("Unexpected bundle from service %d", b.ServiceNum)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMultiReceiverEpollCloseStopsLoopQuickly(t *testing.T) {
+	m, err := NewMultiReceiver()
+	if err != nil {
+		t.Fatalf("NewMultiReceiver failed: %v", err)
+	}
+	sock, _ := newTestEndpointPair(t, 1)
+	if err := m.Add(sock); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	m.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- m.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	case <-time.After(2 * recvPollInterval):
+		t.Fatal("Close did not stop the receive loop")
+	}
+}
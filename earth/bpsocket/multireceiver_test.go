@@ -0,0 +1,262 @@
+// multireceiver_test.go - 偽のpollerとソケットによるMultiReceiverの受信ループのテスト
+package bpsocket
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePoller readyに送られたfdをwaitで返すpoller
+type fakePoller struct {
+	mu         sync.Mutex
+	registered map[int]bool
+	ready      chan []int
+	closed     bool
+}
+
+func newFakePoller() *fakePoller {
+	return &fakePoller{registered: make(map[int]bool), ready: make(chan []int, 10)}
+}
+
+func (p *fakePoller) add(fd int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registered[fd] = true
+	return nil
+}
+
+func (p *fakePoller) remove(fd int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.registered[fd] {
+		return errors.New("not registered")
+	}
+	delete(p.registered, fd)
+	return nil
+}
+
+func (p *fakePoller) wait(timeout time.Duration) ([]int, error) {
+	select {
+	case fds := <-p.ready:
+		return fds, nil
+	case <-time.After(timeout):
+		return nil, nil
+	}
+}
+
+func (p *fakePoller) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *fakePoller) isRegistered(fd int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.registered[fd]
+}
+
+// fakePollable queueに積まれたペイロードをRecvで返すソケット
+type fakePollable struct {
+	fd     int
+	local  *SockaddrBP
+	queue  chan []byte
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFakePollable(fd int, svc uint64) *fakePollable {
+	return &fakePollable{fd: fd, local: NewSockaddrBP(150, svc), queue: make(chan []byte, 10)}
+}
+
+func (s *fakePollable) SendTo(data []byte, to *SockaddrBP) error { return nil }
+
+func (s *fakePollable) Recv(buf []byte) (int, *SockaddrBP, error) {
+	if s.isClosed() {
+		return 0, nil, ErrClosed
+	}
+	select {
+	case data := <-s.queue:
+		return copy(buf, data), NewSockaddrBP(149, 1), nil
+	default:
+		return 0, nil, ErrTimeout
+	}
+}
+
+func (s *fakePollable) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakePollable) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *fakePollable) LocalAddr() *SockaddrBP { return s.local }
+func (s *fakePollable) pollFd() int            { return s.fd }
+
+func newFakeMultiReceiver(t *testing.T) (*MultiReceiver, *fakePoller) {
+	t.Helper()
+	p := newFakePoller()
+	m := newMultiReceiver(p)
+	m.Start()
+	t.Cleanup(func() { m.Close() })
+	return m, p
+}
+
+func receiveServiceBundle(t *testing.T, m *MultiReceiver) ServiceBundle {
+	t.Helper()
+	select {
+	case b, ok := <-m.GetDataChannel():
+		if !ok {
+			t.Fatal("Data channel closed")
+		}
+		return b
+	case <-time.After(time.Second):
+		t.Fatal("No bundle delivered")
+		return ServiceBundle{}
+	}
+}
+
+func TestMultiReceiverDeliversWithServiceNumber(t *testing.T) {
+	m, p := newFakeMultiReceiver(t)
+	crawl, control := newFakePollable(10, 1), newFakePollable(11, 3)
+	for _, s := range []*fakePollable{crawl, control} {
+		if err := m.add(s, nil); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+
+	crawl.queue <- []byte(`{"request_id":"crawl"}`)
+	control.queue <- []byte(`{"request_id":"control"}`)
+	p.ready <- []int{10, 11}
+
+	got := make(map[uint64]string)
+	for range 2 {
+		b := receiveServiceBundle(t, m)
+		got[b.ServiceNum] = string(b.Data)
+		if b.Local.SvcNum != uint32(b.ServiceNum) {
+			t.Errorf("Local address %s does not match service %d", b.Local.String(), b.ServiceNum)
+		}
+		b.Release()
+	}
+	if got[1] != `{"request_id":"crawl"}` || got[3] != `{"request_id":"control"}` {
+		t.Errorf("Unexpected deliveries: %v", got)
+	}
+}
+
+func TestMultiReceiverRemoveAndAddAtRuntime(t *testing.T) {
+	m, p := newFakeMultiReceiver(t)
+	first, second := newFakePollable(10, 1), newFakePollable(11, 3)
+	for _, s := range []*fakePollable{first, second} {
+		if err := m.add(s, nil); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+
+	if err := m.remove(second); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if p.isRegistered(11) || !second.isClosed() {
+		t.Error("Expected the removed socket to be deregistered and closed")
+	}
+	if err := m.remove(second); err == nil {
+		t.Error("Expected an error removing an unregistered socket")
+	}
+
+	// 削除済みのfdが読み込み可能と通知されても無視する
+	second.queue <- []byte("stale")
+	p.ready <- []int{11}
+
+	third := newFakePollable(12, 5)
+	if err := m.add(third, nil); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	third.queue <- []byte(`"late"`)
+	p.ready <- []int{12}
+
+	b := receiveServiceBundle(t, m)
+	defer b.Release()
+	if b.ServiceNum != 5 || string(b.Data) != `"late"` {
+		t.Errorf("Expected the bundle from the new endpoint, got service %d: %q", b.ServiceNum, b.Data)
+	}
+	if n := len(m.Endpoints()); n != 2 {
+		t.Errorf("Expected 2 endpoints, got %d", n)
+	}
+}
+
+func TestMultiReceiverAppliesEndpointOptions(t *testing.T) {
+	m, p := newFakeMultiReceiver(t)
+	deduped, plain := newFakePollable(10, 1), newFakePollable(11, 3)
+	if err := m.add(deduped, []ReceiverOption{WithDuplicateDetection(0)}); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if err := m.add(plain, nil); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	bundle := encodeEnvelope(&envelope{Type: envelopeTypeData, Flags: flagSeq, Seq: 7, Payload: []byte(`"once"`)})
+	for _, s := range []*fakePollable{deduped, deduped, plain, plain} {
+		s.queue <- bundle
+		p.ready <- []int{s.fd}
+	}
+
+	got := make(map[uint64]int)
+	for range 3 {
+		b := receiveServiceBundle(t, m)
+		if string(b.Data) != `"once"` {
+			t.Errorf("Expected decoded payload, got %q", b.Data)
+		}
+		got[b.ServiceNum]++
+		b.Release()
+	}
+	if got[1] != 1 || got[3] != 2 {
+		t.Errorf("Expected duplicate detection only on service 1, got %v", got)
+	}
+	if stats := m.Stats()["ipn:150.1"]; stats.Dropped != 1 {
+		t.Errorf("Expected 1 dropped bundle on ipn:150.1, got %+v", stats)
+	}
+}
+
+func TestMultiReceiverClose(t *testing.T) {
+	p := newFakePoller()
+	m := newMultiReceiver(p)
+	m.Start()
+	s := newFakePollable(10, 1)
+	if err := m.add(s, nil); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, ok := <-m.GetDataChannel(); ok {
+		t.Error("Expected the data channel to be closed")
+	}
+	if !s.isClosed() || !p.closed {
+		t.Error("Expected sockets and poller to be closed")
+	}
+	if err := m.add(newFakePollable(11, 3), nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed adding after Close, got %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("Second Close failed: %v", err)
+	}
+}
+
+func TestMultiReceiverRejectsInvalidOptions(t *testing.T) {
+	m, p := newFakeMultiReceiver(t)
+	if err := m.add(newFakePollable(10, 1), []ReceiverOption{WithMaxReceiveSize(0)}); err == nil {
+		t.Fatal("Expected invalid options to be rejected")
+	}
+	if p.isRegistered(10) {
+		t.Error("Expected the socket not to be registered")
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+// poller_linux.go - epollによる複数ソケットの受信待機
+package bpsocket
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// maxPollEvents 1回のepoll_waitで受け取るイベント数
+const maxPollEvents = 64
+
+// epollPoller epollで複数のファイルディスクリプタを待機する
+type epollPoller struct {
+	epfd   int
+	events [maxPollEvents]syscall.EpollEvent
+}
+
+func newPoller() (poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll_create1 error: %v", err)
+	}
+	return &epollPoller{epfd: epfd}, nil
+}
+
+func (p *epollPoller) add(fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		return fmt.Errorf("epoll_ctl add %d error: %v", fd, err)
+	}
+	return nil
+}
+
+func (p *epollPoller) remove(fd int) error {
+	if err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil); err != nil {
+		return fmt.Errorf("epoll_ctl del %d error: %v", fd, err)
+	}
+	return nil
+}
+
+// wait いずれかのファイルディスクリプタが読み込み可能になるか、timeoutが経過するまで待つ
+// シグナルによる中断（EINTR）は受信可能なものがないとして扱う
+func (p *epollPoller) wait(timeout time.Duration) ([]int, error) {
+	n, err := syscall.EpollWait(p.epfd, p.events[:], int(timeout.Milliseconds()))
+	if err == syscall.EINTR {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("epoll_wait error: %v", err)
+	}
+	fds := make([]int, n)
+	for i := range n {
+		fds[i] = int(p.events[i].Fd)
+	}
+	return fds, nil
+}
+
+func (p *epollPoller) close() error {
+	return syscall.Close(p.epfd)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+// poller_windows.go - Windows環境用スタブ（bp-socketはサポートしてない）
+package bpsocket
+
+import "fmt"
+
+func newPoller() (poller, error) {
+	return nil, fmt.Errorf("bp-socket not supported on Windows")
+}
@@ -2,113 +2,494 @@
 package bpsocket
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const maxBundleSize = 4 * 1024 * 1024
+// recvPollInterval Recvのタイムアウト間隔（この間隔でstopChanを確認する）
+const recvPollInterval = 500 * time.Millisecond
 
 // BpReceiver BP Socketで連続的にバンドルを受信する
 type BpReceiver struct {
-	socket   *BpSocket
-	dataChan chan []byte
-	stopChan chan struct{}
+	socket    Transport
+	dataChan  chan *Bundle
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	closeErr  error
+
+	// Drainで使う受信ループの状態（Drain中はループ終了時にデータチャネルをクローズしない）
+	started       atomic.Bool
+	draining      atomic.Bool
+	loopDone      chan struct{}
+	dataCloseOnce sync.Once
+
+	compressionStats compressionCounters
+
+	pool          *bufferPool
+	maxBundleSize int
+
+	dedup             *dedupWindow
+	duplicatesDropped atomic.Uint64
+
+	events               chan Event
+	maxConsecutiveErrors int
+
+	sources         sourceFilter
+	sourcesRejected atomic.Uint64
+
+	streams streamTable
+
+	auth            *authVerifier
+	ciphers         []cipher.AEAD
+	decryptFailures atomic.Uint64
+
+	stats trafficCounters
+	hook  BundleHook
+
+	// optionErr オプションに指定された不正な値（NewBpReceiverはエラーとして返す）
+	optionErr error
+
+	// BpEndpointで送信側とソケットを共有する場合に設定される
+	onAck    func(seq uint64) // 受信したACKバンドルの通知先
+	onPong   func(id uint64)  // 受信したpongバンドルの通知先
+	sendSlot chan struct{}    // ACK・pong送信を送信側の書き込みと直列化する（BpSender.sendSlotと共有）
+
+	// forward MultiReceiverに登録されている場合のバンドルの受け渡し先
+	forward func(*Bundle) bool
+}
+
+// ReceiverOption BpReceiverの設定オプション
+type ReceiverOption func(*BpReceiver)
+
+// WithDuplicateDetection シーケンス番号付きバンドルの重複を検出して破棄する
+// 直近windowSize個の(送信元EID, シーケンス番号)を記憶する（0以下の場合はデフォルト値）
+// シーケンス番号を持たないバンドル（従来のピア）は常に受け入れる
+func WithDuplicateDetection(windowSize int) ReceiverOption {
+	return func(r *BpReceiver) {
+		r.dedup = newDedupWindow(windowSize)
+	}
+}
+
+// WithMaxConsecutiveErrors 受信エラーがn回連続したら受信ループを停止する（0の場合は停止しない）
+// カーネルモジュールのアンロードなどでソケットが使えなくなった場合に、エラーを出し続けて空回りするのを防ぐ
+// 停止時はEventClosedのErrにErrTooManyRecvErrorsが設定される
+func WithMaxConsecutiveErrors(n int) ReceiverOption {
+	return func(r *BpReceiver) {
+		r.maxConsecutiveErrors = n
+	}
 }
 
 // NewBpReceiver 受信専用のBP Socketを作成
-func NewBpReceiver(localNodeNum, localSvcNum uint64) (*BpReceiver, error) {
+func NewBpReceiver(localNodeNum, localSvcNum uint64, opts ...ReceiverOption) (*BpReceiver, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("bp-socket is only supported on Linux (current OS: %s)", runtime.GOOS)
+	}
+
+	return newBpReceiverAddr(NewSockaddrBP(localNodeNum, localSvcNum), opts...)
+}
+
+// NewBpReceiverEID EID文字列（"ipn:150.1" や "dtn://earth/crawler"）を指定して受信専用のBP Socketを作成
+func NewBpReceiverEID(localEID string, opts ...ReceiverOption) (*BpReceiver, error) {
+	localAddr, err := ParseEID(localEID)
+	if err != nil {
+		return nil, err
+	}
 	if runtime.GOOS != "linux" {
 		return nil, fmt.Errorf("bp-socket is only supported on Linux (current OS: %s)", runtime.GOOS)
 	}
+	return newBpReceiverAddr(localAddr, opts...)
+}
 
-	socket, err := NewBpSocket(localNodeNum, localSvcNum)
+func newBpReceiverAddr(localAddr *SockaddrBP, opts ...ReceiverOption) (*BpReceiver, error) {
+	socket, err := NewBpSocketAddr(localAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create BP socket: %w", err)
 	}
 
+	r, err := newBpReceiverWithOptions(socket, opts)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+
 	log.Printf("[BpReceiver] Listening on %s", socket.LocalAddr().String())
 
+	return r, nil
+}
+
+// NewBpReceiverWithTransport 任意のTransport（ループバックなど）で受信するBpReceiverを作成
+// 不正なオプションの値はログに記録し、デフォルト値を使う
+func NewBpReceiverWithTransport(transport Transport, opts ...ReceiverOption) *BpReceiver {
+	r, err := newBpReceiverWithOptions(transport, opts)
+	if err != nil {
+		log.Printf("[BpReceiver] ERROR: %v, using defaults", err)
+	}
+	return r
+}
+
+// newBpReceiverWithOptions オプションを適用したBpReceiverを作成し、不正なオプションの値があればエラーを返す
+func newBpReceiverWithOptions(transport Transport, opts []ReceiverOption) (*BpReceiver, error) {
+	// Recvがブロックし続けるとCloseで受信ループを止められないため、定期的にタイムアウトさせる
+	if setter, ok := transport.(readTimeoutSetter); ok {
+		if err := setter.SetReadTimeout(recvPollInterval); err != nil {
+			log.Printf("[BpReceiver] WARNING: Failed to set receive timeout: %v", err)
+		}
+	}
+	r := newBpReceiver(transport)
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, r.optionErr
+}
+
+func newBpReceiver(socket Transport) *BpReceiver {
 	return &BpReceiver{
-		socket:   socket,
-		dataChan: make(chan []byte, 100),
-		stopChan: make(chan struct{}),
-	}, nil
+		socket:        socket,
+		dataChan:      make(chan *Bundle, 100),
+		pool:          newBufferPool(bufferTiers),
+		maxBundleSize: DefaultMaxBundleSize,
+		stopChan:      make(chan struct{}),
+		loopDone:      make(chan struct{}),
+		events:        make(chan Event, eventQueueSize),
+	}
 }
 
 // Start 受信ループを開始
 func (r *BpReceiver) Start() {
+	r.started.Store(true)
 	go r.receiveLoop()
 }
 
 // GetDataChannel 受信データを取得するチャネル
-func (r *BpReceiver) GetDataChannel() <-chan []byte {
+// 受け取ったBundleは処理後にReleaseを呼んでバッファをプールへ返却すること
+func (r *BpReceiver) GetDataChannel() <-chan *Bundle {
 	return r.dataChan
 }
 
-// Close ソケットをクローズして受信を停止
+// Close ソケットをクローズして受信を停止（複数回呼んでも安全）
+// ブロック中のRecvはソケットのshutdownで戻り、受信ループ終了時にデータチャネルがクローズされる
 func (r *BpReceiver) Close() error {
-	close(r.stopChan)
-	return r.socket.Close()
+	r.stop()
+	r.closeOnce.Do(func() {
+		r.closeErr = r.socket.Close()
+	})
+	return r.closeErr
+}
+
+// stop 受信ループに停止を指示する（ソケットはクローズしない）
+func (r *BpReceiver) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+	})
+}
+
+// closeData データチャネルをクローズする（受信ループの終了時とDrainの完了時に呼ばれる）
+func (r *BpReceiver) closeData() {
+	r.dataCloseOnce.Do(func() {
+		close(r.dataChan)
+	})
 }
 
 func (r *BpReceiver) receiveLoop() {
-	buf := make([]byte, maxBundleSize)
+	var cause error
+	defer func() {
+		if !r.draining.Load() {
+			r.closeData()
+		}
+		close(r.loopDone)
+		r.emitClosed(cause)
+	}()
+
+	buf := make([]byte, r.maxBundleSize)
+	consecutiveErrors := 0
 
 	for {
 		select {
 		case <-r.stopChan:
 			log.Println("[BpReceiver] Receive loop stopped")
-			close(r.dataChan)
 			return
 		default:
 		}
 
 		n, fromAddr, err := r.socket.Recv(buf)
+		if errors.Is(err, ErrTimeout) || errors.Is(err, ErrInterrupted) {
+			// データが届いていないだけ（またはシグナルで中断された）なので、stopChanを確認して受信を続ける
+			continue
+		}
+		if errors.Is(err, ErrClosed) {
+			log.Println("[BpReceiver] Socket closed, receive loop stopped")
+			return
+		}
 		if err != nil {
 			select {
 			case <-r.stopChan:
 				return
 			default:
-				log.Printf("[BpReceiver] Recv error: %v", err)
-				continue
 			}
-		}
 
-		if n >= maxBundleSize {
-			log.Printf("[BpReceiver] WARNING: Received %d bytes (buffer limit), possible truncation", n)
+			consecutiveErrors++
+			r.stats.errors.Add(1)
+			log.Printf("[BpReceiver] Recv error (%d consecutive): %v", consecutiveErrors, err)
+			r.emit(Event{Type: EventRecvError, Err: err})
+
+			if r.maxConsecutiveErrors > 0 && consecutiveErrors >= r.maxConsecutiveErrors {
+				cause = fmt.Errorf("%w: %d consecutive errors, last: %w", ErrTooManyRecvErrors, consecutiveErrors, err)
+				log.Printf("[BpReceiver] Stopping receive loop: %v", cause)
+				return
+			}
+			continue
 		}
+		consecutiveErrors = 0
 
 		log.Printf("[BpReceiver] Received %d bytes from %s", n, fromAddr.String())
+		r.recordReceive(fromAddr, n, r.dispatch(buf, n, fromAddr))
+	}
+}
+
+// dispatch 受信したバンドルを解釈してデータチャネルに渡す
+// 破棄した場合はその理由を返す（制御バンドルとして処理した場合はnil）
+func (r *BpReceiver) dispatch(buf []byte, n int, fromAddr *SockaddrBP) error {
+	if !r.sources.allows(fromAddr) {
+		r.sourcesRejected.Add(1)
+		log.Printf("[BpReceiver] WARNING: Rejecting bundle from unauthorized source %s", fromAddr.String())
+		r.emit(Event{Type: EventSourceRejected, From: fromAddr, Size: n})
+		return errSourceRejected
+	}
+
+	var bundle *Bundle
+	var err error
+	if n > len(buf) {
+		// 切り詰められたバンドルは解釈できないため、先頭部分と実際のサイズだけを渡して利用者にエラー応答を任せる
+		log.Printf("[BpReceiver] WARNING: Bundle of %d bytes exceeds buffer limit %d, truncated", n, len(buf))
+		r.emit(Event{Type: EventTruncated, From: fromAddr, Size: n})
+		bundle = newPooledBundle(r.pool, buf, fromAddr)
+		bundle.Truncated = true
+		bundle.Size = n
+		bundle.Limit = len(buf)
+	} else {
+		bundle, err = r.decodeBundle(buf[:n], fromAddr)
+	}
+	if errors.Is(err, errControlBundle) {
+		return nil
+	}
+	if errors.Is(err, errAuthFailed) {
+		log.Printf("[BpReceiver] WARNING: Rejecting unauthenticated bundle from %s: %v", fromAddr.String(), err)
+		r.emit(Event{Type: EventAuthFailed, From: fromAddr, Size: n, Reason: err.Error()})
+		return err
+	}
+	if errors.Is(err, errDecryptFailed) {
+		log.Printf("[BpReceiver] WARNING: Dropping undecryptable bundle from %s: %v", fromAddr.String(), err)
+		r.emit(Event{Type: EventDecryptFailed, From: fromAddr, Size: n, Reason: err.Error()})
+		return err
+	}
+	if errors.Is(err, errDuplicateBundle) {
+		log.Printf("[BpReceiver] Dropping duplicate bundle from %s", fromAddr.String())
+		r.emitDropped(fromAddr, n, "duplicate")
+		return err
+	}
+	if err != nil {
+		log.Printf("[BpReceiver] WARNING: Dropping undecodable bundle from %s: %v", fromAddr.String(), err)
+		r.emitDropped(fromAddr, n, err.Error())
+		return err
+	}
+
+	if !r.deliver(bundle) {
+		log.Printf("[BpReceiver] WARNING: Data channel full, dropping bundle")
+		bundle.Release()
+		r.emitDropped(fromAddr, n, "data channel full")
+		return errDataChannelFull
+	}
+	r.stats.delivered.Add(1)
+	log.Printf("[BpReceiver] Bundle dispatched to processing pipeline")
+	return nil
+}
 
-		// データをコピーしてチャネルに送信
-		data := make([]byte, n)
-		copy(data, buf[:n])
+// deliver バンドルをデータチャネルに渡す（MultiReceiverに登録されている場合は共有のチャネルに渡す）
+// チャネルが満杯の場合はfalseを返す
+func (r *BpReceiver) deliver(bundle *Bundle) bool {
+	if r.forward != nil {
+		return r.forward(bundle)
+	}
+	select {
+	case r.dataChan <- bundle:
+		return true
+	default:
+		return false
+	}
+}
 
+// emitClosed 受信ループの終了を通知してイベントチャネルをクローズする
+// 終了通知は必ず届くよう、チャネルが満杯なら最も古いイベントを捨てる
+func (r *BpReceiver) emitClosed(cause error) {
+	ev := Event{Type: EventClosed, Time: time.Now(), Err: cause}
+	for {
+		select {
+		case r.events <- ev:
+			close(r.events)
+			return
+		default:
+		}
 		select {
-		case r.dataChan <- data:
-			log.Printf("[BpReceiver] Bundle dispatched to processing pipeline")
+		case <-r.events:
 		default:
-			log.Printf("[BpReceiver] WARNING: Data channel full, dropping bundle")
 		}
 	}
 }
 
-// ParseDTNRequest バンドルペイロードからDTNJsonRequestをパース
-func ParseDTNRequest(data []byte) (url string, reqID string, err error) {
-	var req struct {
-		RequestID string `json:"request_id"`
-		URL       string `json:"url"`
+// DuplicatesDropped 重複として破棄したバンドル数を返す
+func (r *BpReceiver) DuplicatesDropped() uint64 {
+	return r.duplicatesDropped.Load()
+}
+
+// sendAck 送信元へACKバンドルを返す（macKeyがnilでなければ署名する、失敗しても送信側の再送に任せる）
+func (r *BpReceiver) sendAck(to *SockaddrBP, seq uint64, macKey []byte) {
+	if err := r.sendControl(to, encodeAck(seq, macKey)); err != nil {
+		log.Printf("[BpReceiver] WARNING: Failed to send ACK for bundle %d to %s: %v", seq, to.String(), err)
+	}
+}
+
+// sendControl ACKやpongなどの制御バンドルを送信元へ返す
+func (r *BpReceiver) sendControl(to *SockaddrBP, bundle []byte) error {
+	if r.sendSlot != nil {
+		r.sendSlot <- struct{}{}
+		defer func() { <-r.sendSlot }()
 	}
+	return r.socket.SendTo(bundle, to)
+}
+
+// CompressionStats 受信時の展開統計を返す
+func (r *BpReceiver) CompressionStats() CompressionStats {
+	return r.compressionStats.snapshot()
+}
 
-	if err := json.Unmarshal(data, &req); err != nil {
-		return "", "", fmt.Errorf("JSON parse error: %w", err)
+// decodeBundle エンベロープを解釈してアプリケーションデータを取り出す
+// 従来の生JSONバンドルはそのまま返す。戻り値はbufを参照しない（受信バッファは再利用される）
+func (r *BpReceiver) decodeBundle(buf []byte, fromAddr *SockaddrBP) (*Bundle, error) {
+	env, err := decodeEnvelope(buf)
+	if err != nil {
+		return nil, err
+	}
+	// 認証できないバンドルにはACKも返さず、ACK・pongも送信側に渡さない（偽のACK・pongで再送やリンクの状態を操作させない）
+	// ACK・pongには、受信したバンドルを検証できた鍵で署名する
+	var macKey []byte
+	if r.auth != nil {
+		key, ok := r.auth.match(env)
+		if !ok {
+			r.auth.failures.Add(1)
+			if env.hasMAC() {
+				return nil, errMACMismatch
+			}
+			return nil, errMACMissing
+		}
+		macKey = key
 	}
 
-	if req.URL == "" {
-		return "", "", fmt.Errorf("URL is empty")
+	if env.Type == envelopeTypeAck && r.onAck != nil && env.hasSeq() {
+		r.onAck(env.Seq)
+		return nil, errControlBundle
+	}
+	if env.Type == envelopeTypePong && r.onPong != nil && env.hasSeq() {
+		r.onPong(env.Seq)
+		return nil, errControlBundle
+	}
+	if env.Type != envelopeTypeData && env.Type != envelopeTypeChunk && env.Type != envelopeTypePing {
+		return nil, fmt.Errorf("unexpected envelope type: %d", env.Type)
 	}
 
+	// 復号できないバンドルを後段に渡さず、ACKも返さない
+	payload := env.Payload
+	if env.encrypted() {
+		payload, err = decryptEnvelope(r.ciphers, env)
+		if err != nil {
+			r.decryptFailures.Add(1)
+			return nil, err
+		}
+	}
+
+	if env.Type == envelopeTypePing {
+		if env.hasSeq() {
+			r.sendControl(fromAddr, encodePong(env.Seq, macKey))
+		}
+		return nil, errControlBundle
+	}
+
+	// ACKが失われた場合の再送にも応答する必要があるため、重複判定より先にACKを返す
+	if env.Flags&flagAckRequested != 0 && env.hasSeq() {
+		r.sendAck(fromAddr, env.Seq, macKey)
+	}
+
+	if r.dedup != nil && env.hasSeq() && r.dedup.observe(fromAddr.String(), env.Seq) {
+		r.duplicatesDropped.Add(1)
+		return nil, errDuplicateBundle
+	}
+
+	data, compressed, err := decompress(env.Flags, payload)
+	if err != nil {
+		return nil, err
+	}
+	if compressed {
+		r.compressionStats.add(len(data), len(payload))
+	}
+	if env.Type == envelopeTypeChunk {
+		// チャンクはアプリケーションのチャネルではなく、対応するBundleReaderに渡す
+		if err := r.streams.deliver(data, compressed || env.encrypted()); err != nil {
+			return nil, err
+		}
+		return nil, errControlBundle
+	}
+	if compressed || env.encrypted() {
+		// 展開・復号結果は新たに割り当てられたスライスなのでプールを経由しない
+		return &Bundle{Data: data, From: fromAddr, Size: len(data)}, nil
+	}
+
+	return newPooledBundle(r.pool, data, fromAddr), nil
+}
+
+// ExtractRequestID 切り詰められたペイロードからでもrequest_idを取り出す（見つからなければ空文字）
+// JSONの先頭から順にトークンを読み、トップレベルのrequest_idの値が現れた時点で返す
+func ExtractRequestID(data []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return ""
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		key, _ := tok.(string)
+		if key == "request_id" {
+			tok, err := dec.Token()
+			if err != nil {
+				return ""
+			}
+			id, _ := tok.(string)
+			return id
+		}
+		// 値を読み飛ばす
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// ParseDTNRequest バンドルペイロードからURLとリクエストIDだけを取り出す
+// Method・Headers・Bodyも必要な場合はParseDTNRequestFullを使う
+func ParseDTNRequest(data []byte) (url string, reqID string, err error) {
+	req, err := ParseDTNRequestFull(data)
+	if err != nil {
+		return "", "", err
+	}
 	return req.URL, req.RequestID, nil
 }
@@ -0,0 +1,214 @@
+// reliability.go - アプリケーションレベルのACKと再送（ION custody transferが使えない環境向け）
+//
+// 送信側はバンドルIDとしてシーケンス番号を付与し、ACK要求フラグを立てて送信する
+// 受信側は同じIDのACKバンドル（envelopeTypeAck）を送信元へ返す
+// 送信側はackTimeout以内にACKが届かなければ再送し、maxAttempts回で諦めてErrNotAcknowledgedを返す
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ackBufferSize ACKバンドル受信用のバッファサイズ（ACKはヘッダーとseqのみ）
+const ackBufferSize = 256
+
+// ReliabilityStats 再送の統計
+type ReliabilityStats struct {
+	Acknowledged    uint64 // ACKを受け取ったバンドル数
+	Retransmissions uint64 // 再送した回数
+	Failures        uint64 // 再送上限に達して諦めたバンドル数
+}
+
+// reliability ACK待ちのバンドルを管理する
+type reliability struct {
+	ackTimeout  time.Duration
+	maxAttempts int
+
+	mu      sync.Mutex
+	pending map[uint64]chan struct{}
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+
+	acknowledged    atomic.Uint64
+	retransmissions atomic.Uint64
+	failures        atomic.Uint64
+}
+
+// WithReliability バンドルごとにACKを要求し、ackTimeout以内にACKが届かなければ最大maxAttempts回まで送信する
+// 有効にするとSendはACKを受け取るまで（または諦めるまで）ブロックする
+// 受信側でWithDuplicateDetectionを有効にすると、ACK喪失による再送を重複として破棄できる
+func WithReliability(ackTimeout time.Duration, maxAttempts int) SenderOption {
+	return func(s *BpSender) {
+		if maxAttempts < 1 {
+			maxAttempts = 1
+		}
+		s.reliability = &reliability{
+			ackTimeout:  ackTimeout,
+			maxAttempts: maxAttempts,
+			pending:     make(map[uint64]chan struct{}),
+			stopChan:    make(chan struct{}),
+		}
+	}
+}
+
+// ReliabilityStats 再送の統計を返す（信頼性レイヤーが無効の場合はゼロ値）
+func (s *BpSender) ReliabilityStats() ReliabilityStats {
+	rel := s.reliability
+	if rel == nil {
+		return ReliabilityStats{}
+	}
+	return ReliabilityStats{
+		Acknowledged:    rel.acknowledged.Load(),
+		Retransmissions: rel.retransmissions.Load(),
+		Failures:        rel.failures.Load(),
+	}
+}
+
+func (rel *reliability) register(seq uint64) <-chan struct{} {
+	ch := make(chan struct{})
+	rel.mu.Lock()
+	rel.pending[seq] = ch
+	rel.mu.Unlock()
+	return ch
+}
+
+func (rel *reliability) unregister(seq uint64) {
+	rel.mu.Lock()
+	delete(rel.pending, seq)
+	rel.mu.Unlock()
+}
+
+// acknowledge ACKを受け取ったバンドルの待機を解除する（未登録・重複のACKは無視する）
+func (rel *reliability) acknowledge(seq uint64) {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+
+	ch, ok := rel.pending[seq]
+	if !ok {
+		return
+	}
+	delete(rel.pending, seq)
+	close(ch)
+	rel.acknowledged.Add(1)
+}
+
+// handleAck 受信したACKを待機中の送信に通知する（BpEndpointの受信ループから呼ばれる）
+func (s *BpSender) handleAck(seq uint64) {
+	if s.reliability != nil {
+		s.reliability.acknowledge(seq)
+	}
+}
+
+func (rel *reliability) stop() {
+	rel.stopOnce.Do(func() {
+		close(rel.stopChan)
+	})
+}
+
+// sendReliable ACKを受け取るまでバンドルを再送する
+func (s *BpSender) sendReliable(ctx context.Context, remote *SockaddrBP, bundle []byte, seq uint64, opts SendOptions) error {
+	rel := s.reliability
+	acked := rel.register(seq)
+	defer rel.unregister(seq)
+
+	for attempt := 1; attempt <= rel.maxAttempts; attempt++ {
+		if attempt > 1 {
+			rel.retransmissions.Add(1)
+			log.Printf("[BpSender] Retransmitting bundle %d (attempt %d/%d)", seq, attempt, rel.maxAttempts)
+		}
+
+		if err := s.transmit(ctx, remote, bundle, opts); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(rel.ackTimeout)
+		select {
+		case <-acked:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("waiting for ACK of bundle %d aborted: %w", seq, ctx.Err())
+		case <-rel.stopChan:
+			timer.Stop()
+			return fmt.Errorf("waiting for ACK of bundle %d: %w", seq, ErrClosed)
+		}
+	}
+
+	rel.failures.Add(1)
+	return fmt.Errorf("bundle %d to %s after %d attempts: %w",
+		seq, remote.String(), rel.maxAttempts, ErrNotAcknowledged)
+}
+
+// startAckLoop 送信ソケットに返ってくるACKバンドルの受信を開始する
+func (s *BpSender) startAckLoop() {
+	if setter, ok := s.socket.(readTimeoutSetter); ok {
+		if err := setter.SetReadTimeout(recvPollInterval); err != nil {
+			log.Printf("[BpSender] WARNING: Failed to set ACK receive timeout: %v", err)
+		}
+	}
+	go s.ackLoop()
+}
+
+// ackLoop 送信専用ソケットに返ってくるACKとpongを受信する
+func (s *BpSender) ackLoop() {
+	buf := make([]byte, ackBufferSize)
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+
+		n, fromAddr, err := s.socket.Recv(buf)
+		if errors.Is(err, ErrTimeout) {
+			continue
+		}
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		if err != nil {
+			select {
+			case <-s.stopChan:
+				return
+			default:
+				log.Printf("[BpSender] ACK recv error: %v", err)
+				continue
+			}
+		}
+
+		if n > len(buf) {
+			log.Printf("[BpSender] WARNING: Ignoring oversized %d-byte bundle from %s", n, fromAddr.String())
+			continue
+		}
+		env, err := decodeEnvelope(buf[:n])
+		switch {
+		case err == nil && (env.Type == envelopeTypeAck || env.Type == envelopeTypePong) && !s.verifyControl(env):
+			log.Printf("[BpSender] WARNING: Ignoring unauthenticated control bundle from %s", fromAddr.String())
+		case err == nil && env.Type == envelopeTypeAck && env.hasSeq():
+			s.handleAck(env.Seq)
+		case err == nil && env.Type == envelopeTypePong && env.hasSeq():
+			s.handlePong(env.Seq)
+		default:
+			log.Printf("[BpSender] WARNING: Ignoring unexpected %d-byte bundle from %s", n, fromAddr.String())
+		}
+	}
+}
+
+// encodeAck バンドルIDに対するACKバンドルを作成する（macKeyがnilでなければ署名する）
+func encodeAck(seq uint64, macKey []byte) []byte {
+	env := &envelope{Type: envelopeTypeAck, Flags: flagSeq, Seq: seq}
+	if macKey != nil {
+		signEnvelope(macKey, env)
+	}
+	return encodeEnvelope(env)
+}
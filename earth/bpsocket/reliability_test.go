@@ -0,0 +1,123 @@
+// reliability_test.go - ACKと再送のテスト（ループバックで損失を注入）
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lossyTransport 最初のdrop回のSendを黙って捨てるTransport
+type lossyTransport struct {
+	Transport
+	drop atomic.Int32
+}
+
+func (l *lossyTransport) SendTo(data []byte, to *SockaddrBP) error {
+	if l.drop.Add(-1) >= 0 {
+		return nil
+	}
+	return l.Transport.SendTo(data, to)
+}
+
+// newReliablePair dataLoss個のデータバンドルとackLoss個のACKを失うSender/Receiverを作成する
+func newReliablePair(t *testing.T, network *LoopbackNetwork, dataLoss, ackLoss int32, opts ...SenderOption) (*BpSender, *BpReceiver) {
+	t.Helper()
+
+	recvTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sendTransport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	lossyRecv := &lossyTransport{Transport: recvTransport}
+	lossyRecv.drop.Store(ackLoss)
+	lossySend := &lossyTransport{Transport: sendTransport}
+	lossySend.drop.Store(dataLoss)
+
+	receiver := NewBpReceiverWithTransport(lossyRecv, WithDuplicateDetection(0))
+	receiver.Start()
+	sender := NewBpSenderWithTransport(lossySend, 149, 1, opts...)
+
+	t.Cleanup(func() {
+		receiver.Close()
+		sender.Close()
+	})
+	return sender, receiver
+}
+
+func TestReliableSendRecoversFromDataLoss(t *testing.T) {
+	sender, receiver := newReliablePair(t, NewLoopbackNetwork(), 2, 0, WithReliability(50*time.Millisecond, 5))
+
+	if err := sender.Send(context.Background(), map[string]string{"request_id": "r1"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := drain(t, receiver); got != 1 {
+		t.Errorf("Expected exactly one bundle delivered, got %d", got)
+	}
+
+	stats := sender.ReliabilityStats()
+	if stats.Acknowledged != 1 || stats.Retransmissions != 2 || stats.Failures != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestReliableSendRetransmitsOnAckLoss(t *testing.T) {
+	sender, receiver := newReliablePair(t, NewLoopbackNetwork(), 0, 1, WithReliability(50*time.Millisecond, 5))
+
+	if err := sender.Send(context.Background(), "payload"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	// 再送されたバンドルは重複として破棄され、アプリケーションには1つだけ届く
+	if got := drain(t, receiver); got != 1 {
+		t.Errorf("Expected exactly one bundle delivered, got %d", got)
+	}
+	if dropped := receiver.DuplicatesDropped(); dropped != 1 {
+		t.Errorf("Expected the retransmission to be dropped as a duplicate, got %d", dropped)
+	}
+}
+
+func TestReliableSendGivesUp(t *testing.T) {
+	sender, _ := newReliablePair(t, NewLoopbackNetwork(WithDropRate(1.0)), 0, 0, WithReliability(20*time.Millisecond, 3))
+
+	start := time.Now()
+	err := sender.Send(context.Background(), "payload")
+	if !errors.Is(err, ErrNotAcknowledged) {
+		t.Fatalf("Expected ErrNotAcknowledged, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Gave up after %v, expected to wait for every attempt", elapsed)
+	}
+
+	stats := sender.ReliabilityStats()
+	if stats.Retransmissions != 2 || stats.Failures != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestReliableSendHonorsContext(t *testing.T) {
+	sender, _ := newReliablePair(t, NewLoopbackNetwork(WithDropRate(1.0)), 0, 0, WithReliability(time.Second, 3))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := sender.Send(ctx, "payload"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestAckBundlesAreNotDeliveredAsData(t *testing.T) {
+	_, receiver, sendTransport := newCompressedLoopbackPair(t, CompressionNone, 0)
+
+	if err := sendTransport.Send(encodeAck(42, nil), 149, 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if data, ok := receiveWithin(t, receiver, 100*time.Millisecond); ok {
+		t.Errorf("Expected ACK bundle to be filtered out, got %q", data)
+	}
+}
@@ -0,0 +1,127 @@
+// request.go - バックエンドからDTN経由で送られるHTTPリクエスト（DTNJsonRequest）の解析
+package bpsocket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// DTNProtocolVersion 解釈できるDTNJsonRequestのバージョン
+const DTNProtocolVersion = 1
+
+// DTNJsonRequest DTN経由で受信するリクエスト構造体（バックエンドのgateway.DTNJsonRequestと同じ形式）
+type DTNJsonRequest struct {
+	RequestID string              `json:"request_id"`
+	Method    string              `json:"method"`
+	URL       string              `json:"url"`
+	Headers   map[string][]string `json:"headers"`
+	Body      string              `json:"body"` // Base64エンコード
+	Version   int                 `json:"version"`
+
+	// DecodedBody Bodyをデコードしたもの（ParseDTNRequestFullが設定する）
+	DecodedBody []byte `json:"-"`
+
+	// Present ペイロードに含まれていた任意フィールド（ParseDTNRequestFullが設定する）
+	Present RequestFields `json:"-"`
+}
+
+// RequestFields DTNJsonRequestの任意フィールドの有無
+type RequestFields uint8
+
+const (
+	FieldMethod RequestFields = 1 << iota
+	FieldHeaders
+	FieldBody
+	FieldVersion
+)
+
+// Has 指定したフィールドがすべて含まれていたかどうか
+func (f RequestFields) Has(fields RequestFields) bool {
+	return f&fields == fields
+}
+
+// optionalFields JSONのキーと任意フィールドの対応
+var optionalFields = map[string]RequestFields{
+	"method":  FieldMethod,
+	"headers": FieldHeaders,
+	"body":    FieldBody,
+	"version": FieldVersion,
+}
+
+// ErrInvalidRequest リクエストの必須フィールドがない、または値が不正
+var ErrInvalidRequest = errors.New("bpsocket: invalid DTN request")
+
+// ParseDTNRequestFull バンドルペイロードからDTNJsonRequestをパースして検証する
+// request_idとurlは必須。methodが省略された場合はGETとし、bodyはBase64デコードしてDecodedBodyに設定する
+// 未知のフィールドは将来の拡張のため無視する
+// JSONとして解釈できた場合は検証エラーでもリクエストを返すため、呼び出し側はRequestIDを使ってエラー応答できる
+func ParseDTNRequestFull(data []byte) (*DTNJsonRequest, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("JSON parse error: %w", err)
+	}
+
+	req := &DTNJsonRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("JSON parse error: %w", err)
+	}
+	for key, field := range optionalFields {
+		if v, ok := raw[key]; ok && string(v) != "null" {
+			req.Present |= field
+		}
+	}
+
+	if req.RequestID == "" {
+		return req, fmt.Errorf("%w: request_id is empty", ErrInvalidRequest)
+	}
+	if req.URL == "" {
+		return req, fmt.Errorf("%w: URL is empty", ErrInvalidRequest)
+	}
+	if req.Version > DTNProtocolVersion {
+		return req, fmt.Errorf("%w: unsupported version %d", ErrInvalidRequest, req.Version)
+	}
+	if req.Method == "" {
+		req.Method = "GET"
+	}
+
+	body, err := base64.StdEncoding.DecodeString(req.Body)
+	if err != nil {
+		return req, fmt.Errorf("%w: body is not valid Base64: %v", ErrInvalidRequest, err)
+	}
+	req.DecodedBody = body
+
+	return req, nil
+}
+
+// RequestBatchType 複数のリクエストを1つのバンドルにまとめたペイロードのtype（バックエンドのgateway.requestBatchTypeと同じ）
+const RequestBatchType = "request_batch"
+
+// DTNRequestBatch 1つのバンドルにまとめて送られるリクエスト（バックエンドのgateway.DTNJsonRequestBatchと同じ形式）
+// レスポンスはまとめずに、リクエストごとのバンドルで返す
+type DTNRequestBatch struct {
+	Version  int               `json:"version"`
+	Type     string            `json:"type"`
+	Requests []json.RawMessage `json:"requests"`
+}
+
+// SplitRequestBatch まとめたリクエストのペイロードを、リクエストごとのペイロード（ParseDTNRequestFullで解析する）に分ける
+// まとめたペイロードでない場合はbatchをfalseで返す。分けたペイロードはdataを参照しないため、dataのバッファを返却してもよい
+func SplitRequestBatch(data []byte) (requests [][]byte, batch bool, err error) {
+	var envelope DTNRequestBatch
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Type != RequestBatchType {
+		return nil, false, nil
+	}
+	if envelope.Version > DTNProtocolVersion {
+		return nil, true, fmt.Errorf("%w: unsupported batch version %d", ErrInvalidRequest, envelope.Version)
+	}
+	if len(envelope.Requests) == 0 {
+		return nil, true, fmt.Errorf("%w: request batch is empty", ErrInvalidRequest)
+	}
+	requests = make([][]byte, len(envelope.Requests))
+	for i, req := range envelope.Requests {
+		requests[i] = req
+	}
+	return requests, true, nil
+}
@@ -0,0 +1,199 @@
+// request_test.go - DTNJsonRequestの解析と検証のテスト
+package bpsocket
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDTNRequestFull(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantErr    error
+		wantReqID  string
+		wantMethod string
+		wantBody   string
+		present    RequestFields
+		absent     RequestFields
+	}{
+		{
+			name:       "full request",
+			data:       `{"version":1,"request_id":"r1","method":"POST","url":"https://example.com","headers":{"X-A":["1"]},"body":"aGVsbG8="}`,
+			wantReqID:  "r1",
+			wantMethod: "POST",
+			wantBody:   "hello",
+			present:    FieldMethod | FieldHeaders | FieldBody | FieldVersion,
+		},
+		{
+			name:       "minimal request defaults to GET",
+			data:       `{"request_id":"r2","url":"https://example.com"}`,
+			wantReqID:  "r2",
+			wantMethod: "GET",
+			absent:     FieldMethod | FieldHeaders | FieldBody | FieldVersion,
+		},
+		{
+			name:      "missing URL",
+			data:      `{"request_id":"r3","method":"GET"}`,
+			wantErr:   ErrInvalidRequest,
+			wantReqID: "r3",
+		},
+		{
+			name:    "missing request ID",
+			data:    `{"url":"https://example.com"}`,
+			wantErr: ErrInvalidRequest,
+		},
+		{
+			name:      "invalid Base64 body",
+			data:      `{"request_id":"r4","url":"https://example.com","body":"not base64!"}`,
+			wantErr:   ErrInvalidRequest,
+			wantReqID: "r4",
+		},
+		{
+			name:      "unsupported version",
+			data:      `{"version":2,"request_id":"r5","url":"https://example.com"}`,
+			wantErr:   ErrInvalidRequest,
+			wantReqID: "r5",
+		},
+		{
+			name:       "unknown extra fields are ignored",
+			data:       `{"request_id":"r6","url":"https://example.com","priority":"high","trace":{"id":1}}`,
+			wantReqID:  "r6",
+			wantMethod: "GET",
+		},
+		{
+			name:       "empty headers are reported as present",
+			data:       `{"request_id":"r7","url":"https://example.com","headers":{}}`,
+			wantReqID:  "r7",
+			wantMethod: "GET",
+			present:    FieldHeaders,
+			absent:     FieldBody,
+		},
+		{
+			name:       "null headers are treated as absent",
+			data:       `{"request_id":"r8","url":"https://example.com","headers":null}`,
+			wantReqID:  "r8",
+			wantMethod: "GET",
+			absent:     FieldHeaders,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseDTNRequestFull([]byte(tt.data))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				if req == nil || req.RequestID != tt.wantReqID {
+					t.Errorf("Expected the request ID %q to be returned with the error, got %+v", tt.wantReqID, req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if req.RequestID != tt.wantReqID || req.Method != tt.wantMethod {
+				t.Errorf("Unexpected request: %+v", req)
+			}
+			if string(req.DecodedBody) != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, req.DecodedBody)
+			}
+			if tt.present != 0 && !req.Present.Has(tt.present) {
+				t.Errorf("Expected fields %b to be present, got %b", tt.present, req.Present)
+			}
+			if req.Present&tt.absent != 0 {
+				t.Errorf("Expected fields %b to be absent, got %b", tt.absent, req.Present)
+			}
+		})
+	}
+}
+
+func TestParseDTNRequestFullRejectsMalformedJSON(t *testing.T) {
+	if req, err := ParseDTNRequestFull([]byte(`{"request_id":`)); err == nil || req != nil {
+		t.Errorf("Expected a parse error without a request, got %+v, %v", req, err)
+	}
+}
+
+func TestParseDTNRequestKeepsLegacySignature(t *testing.T) {
+	url, reqID, err := ParseDTNRequest([]byte(`{"request_id":"r1","url":"https://example.com"}`))
+	if err != nil || url != "https://example.com" || reqID != "r1" {
+		t.Errorf("Unexpected result: %q, %q, %v", url, reqID, err)
+	}
+}
+
+// TestParseDTNRequestContract バックエンドが送るリクエストの形式（リポジトリ直下のtestdata/dtn/request.json、バックエンドのテストでも確認する）を解釈できる
+func TestParseDTNRequestContract(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "dtn", "request.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	req, err := ParseDTNRequestFull(data)
+	if err != nil {
+		t.Fatalf("ParseDTNRequestFull failed: %v", err)
+	}
+	if req.RequestID != "6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b" || req.Method != "POST" || req.URL != "https://example.com/form?q=1" {
+		t.Errorf("unexpected request: %q %q %q", req.RequestID, req.Method, req.URL)
+	}
+	if req.Version != DTNProtocolVersion {
+		t.Errorf("expected version %d, got %d", DTNProtocolVersion, req.Version)
+	}
+	if got := req.Headers["Accept-Language"]; len(got) != 2 || got[0] != "ja" {
+		t.Errorf("expected the headers to be kept, got %v", req.Headers)
+	}
+	if string(req.DecodedBody) != "a=1&b=\x00\xff" {
+		t.Errorf("unexpected body %q", req.DecodedBody)
+	}
+	if !req.Present.Has(FieldMethod | FieldHeaders | FieldBody | FieldVersion) {
+		t.Errorf("expected all fields to be present, got %b", req.Present)
+	}
+}
+
+// TestSplitRequestBatchContract バックエンドがまとめて送るリクエスト（testdata/dtn/request_batch.json）をリクエストごとに分けて解釈できる
+func TestSplitRequestBatchContract(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "dtn", "request_batch.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	parts, batch, err := SplitRequestBatch(data)
+	if err != nil || !batch {
+		t.Fatalf("expected a request batch, got batch=%v err=%v", batch, err)
+	}
+	want := []struct{ id, url string }{
+		{"6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b", "https://example.com/page"},
+		{"0b7e4d1a-3c5f-4a2b-8e9d-7f6a5b4c3d2e", "https://example.com/style.css"},
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("expected %d requests, got %d", len(want), len(parts))
+	}
+	for i, w := range want {
+		req, err := ParseDTNRequestFull(parts[i])
+		if err != nil {
+			t.Fatalf("request %d: ParseDTNRequestFull failed: %v", i, err)
+		}
+		if req.RequestID != w.id || req.Method != "GET" || req.URL != w.url {
+			t.Errorf("request %d: unexpected request %q %q %q", i, req.RequestID, req.Method, req.URL)
+		}
+	}
+}
+
+func TestSplitRequestBatch(t *testing.T) {
+	// まとめていないリクエストはそのまま解析する
+	if _, batch, err := SplitRequestBatch([]byte(`{"request_id":"r1","url":"https://example.com"}`)); batch || err != nil {
+		t.Errorf("expected a single request not to be a batch, got batch=%v err=%v", batch, err)
+	}
+	if _, batch, _ := SplitRequestBatch([]byte(`{"request_id":`)); batch {
+		t.Error("expected malformed JSON not to be a batch")
+	}
+
+	for name, data := range map[string]string{
+		"empty batch":         `{"version":1,"type":"request_batch","requests":[]}`,
+		"unsupported version": `{"version":2,"type":"request_batch","requests":[{"request_id":"r1","url":"https://example.com"}]}`,
+	} {
+		if _, batch, err := SplitRequestBatch([]byte(data)); !batch || !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: expected ErrInvalidRequest, got batch=%v err=%v", name, batch, err)
+		}
+	}
+}
@@ -3,62 +3,329 @@ package bpsocket
 
 import (
 	"context"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // BpSender BP Socketでバンドルを送信する
+// 複数のgoroutineから同時にSendしても、ソケットへの書き込みは直列化される
 type BpSender struct {
-	socket        *BpSocket
-	remoteNodeNum uint64
-	remoteSvcNum  uint64
+	socket     Transport
+	remoteAddr *SockaddrBP
+	// sendSlot ソケットへ書き込む順番（容量1のセマフォ、順番を待つ間はctxでキャンセルできる）
+	sendSlot chan struct{}
+
+	compression          Compression
+	compressionThreshold int
+	compressionStats     compressionCounters
+
+	sequenced bool
+	nextSeq   atomic.Uint64
+
+	reliability *reliability
+	keepalive   *keepalive
+
+	stats trafficCounters
+	hook  BundleHook
+
+	// retryPolicy 一時的な送信失敗の再試行条件（nilの場合は再試行しない）
+	retryPolicy *SendRetryPolicy
+	sendRetries atomic.Uint64
+
+	maxBundleSize int
+	// optionErr オプションに指定された不正な値（NewBpSenderはエラーとして返す）
+	optionErr error
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+
+	hmacKey   []byte
+	cipher    cipher.AEAD
+	cipherErr error
+
+	defaultOptions SendOptions
+	appliedOptions SendOptions // ソケットに設定済みの値（sendSlotを持つ間だけ読み書きする）
+}
+
+// SenderOption BpSenderの設定オプション
+type SenderOption func(*BpSender)
+
+// WithCompression threshold以上のペイロードを指定した方式で圧縮して送信する
+// 受信側のBpReceiverはエンベロープのフラグを見て自動的に展開する
+func WithCompression(algo Compression, threshold int) SenderOption {
+	return func(s *BpSender) {
+		s.compression = algo
+		s.compressionThreshold = threshold
+	}
+}
+
+// WithSequenceNumbers すべてのバンドルにシーケンス番号付きのエンベロープを付与する
+// 受信側はWithDuplicateDetectionを有効にすることで重複バンドルを破棄できる
+// シーケンス番号は作成時刻を起点にするため、再起動後も以前の番号と衝突しない
+func WithSequenceNumbers() SenderOption {
+	return func(s *BpSender) {
+		s.sequenced = true
+	}
 }
 
 // NewBpSender 送信専用のBP Socketを作成
-func NewBpSender(localNodeNum, localSvcNum, remoteNodeNum, remoteSvcNum uint64) (*BpSender, error) {
+func NewBpSender(localNodeNum, localSvcNum, remoteNodeNum, remoteSvcNum uint64, opts ...SenderOption) (*BpSender, error) {
 	if runtime.GOOS != "linux" {
 		return nil, fmt.Errorf("bp-socket is only supported on Linux (current OS: %s)", runtime.GOOS)
 	}
 
-	socket, err := NewBpSocket(localNodeNum, localSvcNum)
+	return newBpSenderAddr(NewSockaddrBP(localNodeNum, localSvcNum), NewSockaddrBP(remoteNodeNum, remoteSvcNum), opts...)
+}
+
+// NewBpSenderEID EID文字列（"ipn:150.2" や "dtn://earth/crawler"）を指定して送信専用のBP Socketを作成
+func NewBpSenderEID(localEID, remoteEID string, opts ...SenderOption) (*BpSender, error) {
+	localAddr, err := ParseEID(localEID)
+	if err != nil {
+		return nil, err
+	}
+	remoteAddr, err := ParseEID(remoteEID)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("bp-socket is only supported on Linux (current OS: %s)", runtime.GOOS)
+	}
+	return newBpSenderAddr(localAddr, remoteAddr, opts...)
+}
+
+func newBpSenderAddr(localAddr, remoteAddr *SockaddrBP, opts ...SenderOption) (*BpSender, error) {
+	socket, err := NewBpSocketAddr(localAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create BP socket: %w", err)
 	}
 
-	log.Printf("[BpSender] Created socket %s -> ipn:%d.%d",
-		socket.LocalAddr().String(), remoteNodeNum, remoteSvcNum)
+	s := newBpSender(socket, remoteAddr)
+	if err := s.apply(opts); err != nil {
+		socket.Close()
+		return nil, err
+	}
+
+	log.Printf("[BpSender] Created socket %s -> %s",
+		socket.LocalAddr().String(), remoteAddr.String())
+
+	s.start()
+	return s, nil
+}
+
+// NewBpSenderWithTransport 任意のTransport（ループバックなど）で送信するBpSenderを作成
+func NewBpSenderWithTransport(transport Transport, remoteNodeNum, remoteSvcNum uint64, opts ...SenderOption) *BpSender {
+	return NewBpSenderWithTransportAddr(transport, NewSockaddrBP(remoteNodeNum, remoteSvcNum), opts...)
+}
+
+// NewBpSenderWithTransportAddr 任意のTransportで指定したアドレス（ipnまたはdtnスキーム）へ送信するBpSenderを作成
+// 不正なオプションの値はログに記録し、デフォルト値を使う
+func NewBpSenderWithTransportAddr(transport Transport, remoteAddr *SockaddrBP, opts ...SenderOption) *BpSender {
+	s := newBpSender(transport, remoteAddr)
+	if err := s.apply(opts); err != nil {
+		log.Printf("[BpSender] ERROR: %v, using defaults", err)
+	}
+	s.start()
+	return s
+}
+
+// apply オプションを適用し、不正なオプションの値があればエラーを返す
+func (s *BpSender) apply(opts []SenderOption) error {
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s.optionErr
+}
 
-	return &BpSender{
-		socket:        socket,
-		remoteNodeNum: remoteNodeNum,
-		remoteSvcNum:  remoteSvcNum,
-	}, nil
+// start ACKの受信ループとキープアライブを必要に応じて開始する
+func (s *BpSender) start() {
+	if s.reliability != nil || s.keepalive != nil {
+		s.startAckLoop()
+	}
+	if s.keepalive != nil {
+		s.startKeepalive()
+	}
+}
+
+func newBpSender(socket Transport, remoteAddr *SockaddrBP) *BpSender {
+	retryPolicy := SendRetryPolicy{}.withDefaults()
+	s := &BpSender{
+		socket:               socket,
+		remoteAddr:           remoteAddr,
+		compressionThreshold: defaultCompressionThreshold,
+		maxBundleSize:        DefaultMaxBundleSize,
+		retryPolicy:          &retryPolicy,
+		sendSlot:             make(chan struct{}, 1),
+		stopChan:             make(chan struct{}),
+	}
+	s.nextSeq.Store(uint64(time.Now().UnixNano()))
+	return s
 }
 
 // Send バンドルを送信
 func (s *BpSender) Send(ctx context.Context, data interface{}) error {
+	return s.sendTo(ctx, s.remoteAddr, data, SendOptions{})
+}
+
+// sendTo 指定したアドレスへバンドルを送信する（BpEndpointは送信ごとに宛先を指定する）
+func (s *BpSender) sendTo(ctx context.Context, remote *SockaddrBP, data interface{}, opts SendOptions) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("JSON marshal error: %w", err)
 	}
+	return s.sendPayload(ctx, remote, envelopeTypeData, jsonData, opts)
+}
 
-	if len(jsonData) > maxBundleSize {
-		return fmt.Errorf("bundle size %d exceeds max %d", len(jsonData), maxBundleSize)
+// sendPayload エンコード前のペイロードを指定した種別のバンドルとして送信する
+func (s *BpSender) sendPayload(ctx context.Context, remote *SockaddrBP, typ byte, payload []byte, opts SendOptions) error {
+	bundle, seq, err := s.encodeTypedBundle(typ, payload)
+	if err == nil && len(bundle) > s.maxBundleSize {
+		err = fmt.Errorf("%w: %d bytes exceeds max %d", ErrBundleTooLarge, len(bundle), s.maxBundleSize)
+	}
+	if err == nil {
+		if s.reliability != nil {
+			err = s.sendReliable(ctx, remote, bundle, seq, opts)
+		} else {
+			err = s.transmit(ctx, remote, bundle, opts)
+		}
+	}
+	s.recordSend(remote, len(bundle), err)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("[BpSender] Sending %d bytes to ipn:%d.%d", len(jsonData), s.remoteNodeNum, s.remoteSvcNum)
+	log.Printf("[BpSender] Bundle sent successfully")
+	return nil
+}
 
-	if err := s.socket.Send(jsonData, s.remoteNodeNum, s.remoteSvcNum); err != nil {
-		return fmt.Errorf("socket send error: %w", err)
+// CompressionStats 送信時の圧縮統計を返す
+func (s *BpSender) CompressionStats() CompressionStats {
+	return s.compressionStats.snapshot()
+}
+
+// encodeBundle ペイロードに圧縮とシーケンス番号を適用してバンドルを作成し、付与したシーケンス番号とともに返す
+// エンベロープが不要な場合（圧縮もシーケンス番号も使わない場合）は従来の生JSONのまま送信する
+func (s *BpSender) encodeBundle(payload []byte) ([]byte, uint64, error) {
+	return s.encodeTypedBundle(envelopeTypeData, payload)
+}
+
+// encodeTypedBundle 指定した種別のバンドルを作成する（データ以外の種別は常にエンベロープを付与する）
+func (s *BpSender) encodeTypedBundle(typ byte, payload []byte) ([]byte, uint64, error) {
+	if s.cipherErr != nil {
+		return nil, 0, fmt.Errorf("encryption unavailable: %w", s.cipherErr)
 	}
 
-	log.Printf("[BpSender] Bundle sent successfully")
+	env := &envelope{Type: typ, Payload: payload}
+
+	if s.compression != CompressionNone && len(payload) >= s.compressionThreshold {
+		compressed, flag, err := compress(s.compression, payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("compression error: %w", err)
+		}
+		s.compressionStats.add(len(payload), len(compressed))
+		env.Flags |= flag
+		env.Payload = compressed
+	}
+
+	// ACKの照合にはバンドルIDが必要なため、信頼性レイヤー有効時は常にシーケンス番号を付与する
+	if s.sequenced || s.reliability != nil {
+		env.Flags |= flagSeq
+		env.Seq = s.nextSeq.Add(1)
+	}
+	if s.reliability != nil {
+		env.Flags |= flagAckRequested
+	}
+	if s.hmacKey != nil {
+		env.Flags |= flagMAC
+	}
+	// 暗号文は圧縮できないため、圧縮してから暗号化する
+	if s.cipher != nil {
+		if err := encryptEnvelope(s.cipher, env); err != nil {
+			return nil, 0, err
+		}
+	}
+	// MACはフラグ・シーケンス番号・nonceも含めて計算するため、最後に付与する
+	if s.hmacKey != nil {
+		signEnvelope(s.hmacKey, env)
+	}
+
+	if env.Type == envelopeTypeData && env.Flags == 0 && !env.encrypted() {
+		return payload, 0, nil
+	}
+	return encodeEnvelope(env), env.Seq, nil
+}
+
+// transmit エンコード済みのバンドルを送信する
+// キャンセルできるのはsendtoを始める前まで。始めた後はバンドルが送られている可能性があるため、sendtoの結果を返す
+// （キャンセルとして失敗を返すと、送り直す呼び出し元が同じバンドルを2回送ってしまう）
+func (s *BpSender) transmit(ctx context.Context, remote *SockaddrBP, bundle []byte, opts SendOptions) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("send %d bytes to %s cancelled: %w", len(bundle), remote.String(), err)
+	}
+
+	log.Printf("[BpSender] Sending %d bytes to %s", len(bundle), remote.String())
+
+	if err := s.sendLocked(ctx, remote, bundle, opts); err != nil {
+		if errors.Is(err, errSendCancelled) {
+			return fmt.Errorf("send %d bytes to %s cancelled: %w", len(bundle), remote.String(), ctx.Err())
+		}
+		return fmt.Errorf("socket send error: %w", err)
+	}
 	return nil
 }
 
+// errSendCancelled ソケットへ書き込む順番を待つ間にキャンセルされた（バンドルは送っていない）
+var errSendCancelled = errors.New("cancelled before sending")
+
+// sendLocked ソケットへの書き込みを直列化して送信する
+// 順番を待つ間にctxがキャンセルされた場合は送らずにerrSendCancelledを返す
+// ctxに期限がある場合は、残り時間をソケットの送信タイムアウトとして設定する（sendtoがブロックし続けない）
+func (s *BpSender) sendLocked(ctx context.Context, remote *SockaddrBP, data []byte, opts SendOptions) error {
+	select {
+	case s.sendSlot <- struct{}{}:
+	case <-ctx.Done():
+		return errSendCancelled
+	}
+	defer func() { <-s.sendSlot }()
+
+	// 順番が回ってきたのと同時にキャンセルされた場合も送信しない
+	if ctx.Err() != nil {
+		return errSendCancelled
+	}
+
+	if setter, ok := s.socket.(writeTimeoutSetter); ok {
+		var timeout time.Duration
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = max(time.Until(deadline), time.Millisecond)
+		}
+		if err := setter.SetWriteTimeout(timeout); err != nil {
+			log.Printf("[BpSender] WARNING: Failed to set write timeout: %v", err)
+		}
+	}
+
+	s.applyBundleOptions(opts)
+
+	return s.sendWithRetry(ctx, remote, data)
+}
+
 // Close ソケットをクローズ
 func (s *BpSender) Close() error {
+	s.stop()
 	return s.socket.Close()
 }
+
+// stop ACK待ち・キープアライブ・ACK受信ループを停止する（ソケットはクローズしない）
+func (s *BpSender) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+	if s.reliability != nil {
+		s.reliability.stop()
+	}
+}
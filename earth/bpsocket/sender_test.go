@@ -0,0 +1,193 @@
+// sender_test.go - BpSenderのコンテキスト制御と送信の直列化のテスト
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingSocket releaseがクローズされるまでSendをブロックする偽ソケット
+// SetWriteTimeoutで送信タイムアウトを設定した場合は、AF_BPソケットと同じくタイムアウトでErrTimeoutを返す（送信しない）
+type blockingSocket struct {
+	release  chan struct{}
+	started  chan struct{} // Sendを始めるたびに通知する（受け取らない場合は通知しない）
+	sent     atomic.Int32
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+	timeout  atomic.Int64
+}
+
+func newBlockingSocket() *blockingSocket {
+	return &blockingSocket{release: make(chan struct{}), started: make(chan struct{}, 8)}
+}
+
+func (b *blockingSocket) SetWriteTimeout(d time.Duration) error {
+	b.timeout.Store(int64(d))
+	return nil
+}
+
+func (b *blockingSocket) SendTo(data []byte, to *SockaddrBP) error {
+	n := b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+	for {
+		seen := b.maxSeen.Load()
+		if n <= seen || b.maxSeen.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+	select {
+	case b.started <- struct{}{}:
+	default:
+	}
+	var timeout <-chan time.Time
+	if d := time.Duration(b.timeout.Load()); d > 0 {
+		timeout = time.After(d)
+	}
+	select {
+	case <-b.release:
+	case <-timeout:
+		return fmt.Errorf("send: %w", ErrTimeout)
+	}
+	b.sent.Add(1)
+	return nil
+}
+
+func (b *blockingSocket) Recv(buf []byte) (int, *SockaddrBP, error) { return 0, nil, ErrClosed }
+func (b *blockingSocket) Close() error                              { return nil }
+func (b *blockingSocket) LocalAddr() *SockaddrBP                    { return NewSockaddrBP(150, 2) }
+
+// TestSendCancelledDuringSendtoReportsResult sendtoを始めた後にキャンセルしても失敗を返さない
+// （バンドルは送られているため、失敗として送り直すと同じバンドルを2回送ってしまう）
+func TestSendCancelledDuringSendtoReportsResult(t *testing.T) {
+	sock := newBlockingSocket()
+	sender := newBpSender(sock, NewSockaddrBP(149, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- sender.Send(ctx, map[string]string{"request_id": "r1"}) }()
+
+	<-sock.started
+	cancel()
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("Send returned while sendto was still blocked: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(sock.release)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Expected the completed send to succeed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send did not return after sendto completed")
+	}
+	if n := sock.sent.Load(); n != 1 {
+		t.Errorf("Expected 1 bundle sent, got %d", n)
+	}
+}
+
+// TestSendCancelledWhileWaitingDoesNotSend 他の送信が終わるのを待つ間にキャンセルした場合は、送らずにすぐ失敗を返す
+func TestSendCancelledWhileWaitingDoesNotSend(t *testing.T) {
+	sock := newBlockingSocket()
+	sender := newBpSender(sock, NewSockaddrBP(149, 1))
+
+	firstErr := make(chan error, 1)
+	go func() { firstErr <- sender.Send(context.Background(), "first") }()
+	<-sock.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- sender.Send(ctx, "second") }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send did not return after context cancellation")
+	}
+
+	close(sock.release)
+	if err := <-firstErr; err != nil {
+		t.Fatalf("Expected the first send to succeed, got %v", err)
+	}
+	if n := sock.sent.Load(); n != 1 {
+		t.Errorf("Expected only the first bundle to be sent, got %d", n)
+	}
+}
+
+// TestSendHonorsDeadline ctxの期限はソケットの送信タイムアウトになり、sendtoがブロックし続けない
+func TestSendHonorsDeadline(t *testing.T) {
+	sock := newBlockingSocket()
+	defer close(sock.release)
+	sender := newBpSender(sock, NewSockaddrBP(149, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := sender.Send(ctx, "payload")
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if sock.sent.Load() != 0 {
+		t.Error("Expected no bundle to be sent after the write timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Send returned after %v, expected near the 50ms deadline", elapsed)
+	}
+}
+
+func TestSendWithCancelledContextDoesNotSend(t *testing.T) {
+	sock := newBlockingSocket()
+	close(sock.release)
+	sender := newBpSender(sock, NewSockaddrBP(149, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sender.Send(ctx, "payload"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if sock.sent.Load() != 0 {
+		t.Error("Expected no bundle to be sent with a cancelled context")
+	}
+}
+
+func TestConcurrentSendsAreSerialized(t *testing.T) {
+	sock := newBlockingSocket()
+	sender := newBpSender(sock, NewSockaddrBP(149, 1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sender.Send(context.Background(), "payload"); err != nil {
+				t.Errorf("Send failed: %v", err)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(sock.release)
+	wg.Wait()
+
+	if sock.sent.Load() != 3 {
+		t.Errorf("Expected 3 bundles sent, got %d", sock.sent.Load())
+	}
+	if sock.maxSeen.Load() != 1 {
+		t.Errorf("Expected sends to be serialized, saw %d concurrent sends", sock.maxSeen.Load())
+	}
+}
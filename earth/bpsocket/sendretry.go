@@ -0,0 +1,118 @@
+// sendretry.go - 一時的なsendtoの失敗の再試行
+// IONはSDRが一時的に満杯になるとENOBUFS/EAGAINを返すため、すぐに失敗とせず短い間隔で再試行する
+// 再試行の対象外のエラー（宛先不正など）はすぐに返す
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// SendRetryPolicy 一時的な送信失敗を再試行する条件（ゼロ値の項目はデフォルト値を使う）
+type SendRetryPolicy struct {
+	Errnos         []syscall.Errno // 再試行するerrno（デフォルトはENOBUFSとEAGAIN）
+	InitialBackoff time.Duration   // 最初の再試行までの待ち時間、以降は2倍ずつ伸ばす（デフォルト10ミリ秒）
+	MaxBackoff     time.Duration   // 待ち時間の上限（デフォルト500ミリ秒）
+	MaxElapsed     time.Duration   // 最初の送信から再試行を諦めるまでの時間（デフォルト5秒）
+}
+
+const (
+	defaultSendRetryInitialBackoff = 10 * time.Millisecond
+	defaultSendRetryMaxBackoff     = 500 * time.Millisecond
+	defaultSendRetryMaxElapsed     = 5 * time.Second
+)
+
+// defaultSendRetryErrnos IONのSDRが満杯のときに返るerrno（EWOULDBLOCKはEAGAINと同じ値）
+var defaultSendRetryErrnos = []syscall.Errno{syscall.ENOBUFS, syscall.EAGAIN}
+
+// withDefaults ゼロ値の項目をデフォルト値で埋める
+func (p SendRetryPolicy) withDefaults() SendRetryPolicy {
+	if len(p.Errnos) == 0 {
+		p.Errnos = defaultSendRetryErrnos
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = defaultSendRetryInitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = max(defaultSendRetryMaxBackoff, p.InitialBackoff)
+	}
+	if p.MaxElapsed == 0 {
+		p.MaxElapsed = defaultSendRetryMaxElapsed
+	}
+	return p
+}
+
+func (p SendRetryPolicy) validate() error {
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 || p.MaxElapsed < 0 {
+		return fmt.Errorf("invalid send retry policy: durations must not be negative (%+v)", p)
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		return fmt.Errorf("invalid send retry policy: max backoff %v is shorter than initial backoff %v", p.MaxBackoff, p.InitialBackoff)
+	}
+	return nil
+}
+
+// WithSendRetry 一時的な送信失敗を再試行する条件を指定する
+// 指定しない場合もデフォルトの条件で再試行する（無効にする場合はWithoutSendRetry）
+func WithSendRetry(policy SendRetryPolicy) SenderOption {
+	return func(s *BpSender) {
+		policy = policy.withDefaults()
+		if err := policy.validate(); err != nil {
+			s.optionErr = err
+			return
+		}
+		s.retryPolicy = &policy
+	}
+}
+
+// WithoutSendRetry 送信の失敗を再試行せずにすぐ返す
+func WithoutSendRetry() SenderOption {
+	return func(s *BpSender) {
+		s.retryPolicy = nil
+	}
+}
+
+// retryable errがpolicyで再試行するerrnoかを判定する
+func (p *SendRetryPolicy) retryable(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && slices.Contains(p.Errnos, errno)
+}
+
+// sendWithRetry ソケットへ送信し、一時的な失敗であれば指数バックオフで再試行する（sendSlotを持って呼ぶこと）
+// ctxがキャンセルされた場合やMaxElapsedを超えた場合は、最後の送信エラーを返す
+func (s *BpSender) sendWithRetry(ctx context.Context, remote *SockaddrBP, data []byte) error {
+	err := s.socket.SendTo(data, remote)
+	policy := s.retryPolicy
+	if err == nil || policy == nil || !policy.retryable(err) {
+		return err
+	}
+
+	deadline := time.Now().Add(policy.MaxElapsed)
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+		log.Printf("[BpSender] Transient send error, retrying in %v (attempt %d): %v", backoff, attempt, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		s.sendRetries.Add(1)
+		err = s.socket.SendTo(data, remote)
+		if err == nil || !policy.retryable(err) {
+			return err
+		}
+		backoff = min(backoff*2, policy.MaxBackoff)
+	}
+}
@@ -0,0 +1,134 @@
+// sendretry_test.go - 一時的な送信失敗の再試行のテスト（sendtoを差し替える）
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeSendto sendtoの結果をerrsの順に返すよう差し替え、呼び出し回数を返す関数を返す
+// errsを使い切った後は成功する
+func fakeSendto(t *testing.T, errs ...error) func() int {
+	t.Helper()
+	orig := sendtoFn
+	var mu sync.Mutex
+	calls := 0
+	sendtoFn = func(fd int, data []byte, to *SockaddrBP) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}
+	t.Cleanup(func() { sendtoFn = orig })
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+// newFakeSocketSender 実際のfdを持たないBpSocketで送信するBpSenderを作成する
+func newFakeSocketSender(t *testing.T, opts ...SenderOption) *BpSender {
+	t.Helper()
+	socket := &BpSocket{fd: -1, localAddr: NewSockaddrBP(150, 1)}
+	s := NewBpSenderWithTransport(socket, 149, 1, opts...)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSendRetriesTransientErrors(t *testing.T) {
+	calls := fakeSendto(t,
+		newSyscallError("sendto", syscall.EAGAIN),
+		newSyscallError("sendto", syscall.EAGAIN))
+	s := newFakeSocketSender(t, WithSendRetry(SendRetryPolicy{InitialBackoff: time.Millisecond}))
+
+	if err := s.Send(context.Background(), "response"); err != nil {
+		t.Fatalf("Expected Send to succeed after retries, got %v", err)
+	}
+	if n := calls(); n != 3 {
+		t.Errorf("Expected 3 sendto calls, got %d", n)
+	}
+	stats := s.Stats()
+	if stats.SendRetries != 2 || stats.BundlesSent != 1 || stats.SendErrors != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestSendReturnsPermanentErrorsImmediately(t *testing.T) {
+	calls := fakeSendto(t, newSyscallError("sendto", syscall.EINVAL))
+	s := newFakeSocketSender(t)
+
+	err := s.Send(context.Background(), "response")
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("Expected EINVAL, got %v", err)
+	}
+	if n := calls(); n != 1 {
+		t.Errorf("Expected a single sendto call, got %d", n)
+	}
+}
+
+func TestSendRetryGivesUpAfterMaxElapsed(t *testing.T) {
+	errs := make([]error, 100)
+	for i := range errs {
+		errs[i] = newSyscallError("sendto", syscall.ENOBUFS)
+	}
+	calls := fakeSendto(t, errs...)
+	s := newFakeSocketSender(t, WithSendRetry(SendRetryPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		MaxElapsed:     100 * time.Millisecond,
+	}))
+
+	start := time.Now()
+	err := s.Send(context.Background(), "response")
+	elapsed := time.Since(start)
+	if !errors.Is(err, syscall.ENOBUFS) {
+		t.Fatalf("Expected ENOBUFS, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Retrying took %v, expected it to stop near MaxElapsed", elapsed)
+	}
+	if n := calls(); n < 3 || n > 10 {
+		t.Errorf("Expected a bounded number of attempts, got %d", n)
+	}
+}
+
+func TestSendRetryStopsOnContextCancel(t *testing.T) {
+	fakeSendto(t,
+		newSyscallError("sendto", syscall.EAGAIN),
+		newSyscallError("sendto", syscall.EAGAIN))
+	s := newFakeSocketSender(t, WithSendRetry(SendRetryPolicy{InitialBackoff: time.Second, MaxElapsed: time.Minute}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Send(ctx, "response"); err == nil {
+		t.Fatal("Expected Send to fail when the context expires during backoff")
+	}
+}
+
+func TestWithoutSendRetry(t *testing.T) {
+	calls := fakeSendto(t, newSyscallError("sendto", syscall.EAGAIN))
+	s := newFakeSocketSender(t, WithoutSendRetry())
+
+	if err := s.Send(context.Background(), "response"); !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("Expected EAGAIN, got %v", err)
+	}
+	if n := calls(); n != 1 {
+		t.Errorf("Expected a single sendto call, got %d", n)
+	}
+}
+
+func TestSendRetryPolicyValidation(t *testing.T) {
+	s := newBpSender(nil, NewSockaddrBP(149, 1))
+	err := s.apply([]SenderOption{WithSendRetry(SendRetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Millisecond})})
+	if err == nil {
+		t.Error("Expected an error for MaxBackoff shorter than InitialBackoff")
+	}
+}
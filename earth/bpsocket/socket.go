@@ -2,38 +2,85 @@
 package bpsocket
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 type BpSocket struct {
 	fd        int
 	localAddr *SockaddrBP
+	closed    atomic.Bool
+
+	// optionsUnsupported カーネルモジュールがSOL_BPのオプションに対応していないことを検出済み
+	optionsUnsupported atomic.Bool
+
+	// tracer 送受信したペイロードのダンプ先（トレースが無効な場合はnil）
+	tracer *tracer
 }
 
-func NewBpSocket(localNodeNum, localSvcNum uint64) (*BpSocket, error) {
+func NewBpSocket(localNodeNum, localSvcNum uint64, opts ...SocketOption) (*BpSocket, error) {
+	return NewBpSocketAddr(NewSockaddrBP(localNodeNum, localSvcNum), opts...)
+}
+
+// NewBpSocketAddr 指定したアドレス（ipnまたはdtnスキーム）にバインドしたソケットを作成
+func NewBpSocketAddr(localAddr *SockaddrBP, opts ...SocketOption) (*BpSocket, error) {
 	fd, err := syscall.Socket(AF_BP, SOCK_DGRAM, BP_PROTO)
 	if err != nil {
-		return nil, fmt.Errorf("socket creation failed: %w", err)
+		return nil, fmt.Errorf("socket creation failed: %w", newSyscallError("socket", err))
 	}
 
-	localAddr := NewSockaddrBP(localNodeNum, localSvcNum)
-
 	err = bind(int(fd), localAddr)
 	if err != nil {
 		syscall.Close(fd)
 		return nil, fmt.Errorf("bind failed %s: %w", localAddr.String(), err)
 	}
 
-	return &BpSocket{
+	s := &BpSocket{
 		fd:        int(fd),
-		localAddr: localAddr,
-	}, nil
+		localAddr: resolveLocalAddr(int(fd), localAddr),
+		tracer:    tracerFromEnv(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// getsocknameFn getsocknameの呼び出し（テストで差し替える）
+var getsocknameFn = getsockname
+
+// sendtoFn sendtoの呼び出し（テストで差し替える）
+var sendtoFn = sendto
+
+// resolveLocalAddr バインド後にカーネルが割り当てたアドレスを取得する
+// カーネルモジュールがサービス番号などを正規化した場合は警告を出し、実際のアドレスを返す
+// 取得できない場合は要求したアドレスをそのまま使う
+func resolveLocalAddr(fd int, requested *SockaddrBP) *SockaddrBP {
+	actual, err := getsocknameFn(fd)
+	if err != nil {
+		log.Printf("[BpSocket] WARNING: Could not read bound address, assuming %s: %v", requested.String(), err)
+		return requested
+	}
+	if actual.String() != requested.String() {
+		log.Printf("[BpSocket] WARNING: Requested bind to %s but kernel assigned %s", requested.String(), actual.String())
+	}
+	return actual
 }
 
 func (s *BpSocket) Send(data []byte, remoteNodeNum, remoteSvcNum uint64) error {
-	remoteAddr := NewSockaddrBP(remoteNodeNum, remoteSvcNum)
-	err := sendto(s.fd, data, remoteAddr)
+	return s.SendTo(data, NewSockaddrBP(remoteNodeNum, remoteSvcNum))
+}
+
+// SendTo 指定したアドレス（ipnまたはdtnスキーム）へバンドルを送信
+func (s *BpSocket) SendTo(data []byte, remoteAddr *SockaddrBP) error {
+	if s.tracer != nil {
+		s.tracer.trace("TX", s.localAddr, remoteAddr, data)
+	}
+	err := sendtoFn(s.fd, data, remoteAddr)
 	if err != nil {
 		return fmt.Errorf("sendto %s failed: %w", remoteAddr.String(), err)
 	}
@@ -41,20 +88,85 @@ func (s *BpSocket) Send(data []byte, remoteNodeNum, remoteSvcNum uint64) error {
 }
 
 func (s *BpSocket) Recv(buf []byte) (int, *SockaddrBP, error) {
+	if s.closed.Load() {
+		return 0, nil, ErrClosed
+	}
 	n, fromAddr, err := recvfrom(s.fd, buf)
+	// Closeによるshutdownでrecvfromが戻った場合はクローズ済みとして扱う
+	if s.closed.Load() {
+		return 0, nil, ErrClosed
+	}
 	if err != nil {
 		return 0, nil, fmt.Errorf("recvfrom failed: %w", err)
 	}
+	if s.tracer != nil {
+		// MSG_TRUNCによりnはバッファより大きい場合がある
+		s.tracer.trace("RX", fromAddr, s.localAddr, buf[:min(n, len(buf))])
+	}
 	return n, fromAddr, nil
 }
 
+// SetReadTimeout Recvのタイムアウトを設定する（SO_RCVTIMEO、0で無期限）
+// タイムアウトした場合、RecvはErrTimeoutをラップしたエラーを返す
+func (s *BpSocket) SetReadTimeout(d time.Duration) error {
+	return setRecvTimeout(s.fd, d)
+}
+
+// SetWriteTimeout Sendのタイムアウトを設定する（SO_SNDTIMEO、0で無期限）
+// タイムアウトした場合、SendはErrTimeoutをラップしたエラーを返す
+func (s *BpSocket) SetWriteTimeout(d time.Duration) error {
+	return setSendTimeout(s.fd, d)
+}
+
+// SetBundleLifetime 以降に送信するバンドルの有効期間を設定する（BP_OPT_LIFETIME、秒単位）
+// カーネルモジュールが対応していない場合は警告を出して何もしない
+func (s *BpSocket) SetBundleLifetime(d time.Duration) error {
+	seconds := int((d + time.Second - 1) / time.Second)
+	return s.setBundleOption(BP_OPT_LIFETIME, max(seconds, 1))
+}
+
+// SetPriority 以降に送信するバンドルの優先度を設定する（BP_OPT_PRIORITY）
+// カーネルモジュールが対応していない場合は警告を出して何もしない
+func (s *BpSocket) SetPriority(p Priority) error {
+	value, ok := p.kernelValue()
+	if !ok {
+		return fmt.Errorf("invalid priority: %d", p)
+	}
+	return s.setBundleOption(BP_OPT_PRIORITY, value)
+}
+
+func (s *BpSocket) setBundleOption(opt, value int) error {
+	if s.optionsUnsupported.Load() {
+		return nil
+	}
+	err := setBundleOption(s.fd, opt, value)
+	if errors.Is(err, ErrOptionUnsupported) {
+		if !s.optionsUnsupported.Swap(true) {
+			log.Printf("[BpSocket] WARNING: Kernel module does not support bundle options, using defaults: %v", err)
+		}
+		return nil
+	}
+	return err
+}
+
+// Close ソケットをクローズする（複数回呼んでも安全）
+// shutdownを先に発行して、別goroutineでブロック中のRecvを戻してからfdを閉じる
 func (s *BpSocket) Close() error {
-	if s.fd >= 0 {
-		return closeFd(s.fd)
+	if s.fd < 0 || s.closed.Swap(true) {
+		return nil
 	}
-	return nil
+	if err := shutdownFd(s.fd); err != nil {
+		log.Printf("[BpSocket] shutdown %s failed: %v", s.localAddr.String(), err)
+	}
+	return closeFd(s.fd)
+}
+
+// pollFd epollで待機するファイルディスクリプタ
+func (s *BpSocket) pollFd() int {
+	return s.fd
 }
 
+// LocalAddr カーネルが割り当てたローカルアドレスを返す（bind後にgetsocknameで取得した値）
 func (s *BpSocket) LocalAddr() *SockaddrBP {
 	return s.localAddr
 }
@@ -0,0 +1,234 @@
+//go:build linux
+// +build linux
+
+// socket_linux_test.go - ソケットのタイムアウト/クローズ動作のテスト
+// AF_BPカーネルモジュールがなくても動作を確認できるよう、AF_UNIXのデータグラムソケットペアで代用する
+package bpsocket
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestSocketPair 相互に接続されたBpSocketのペアを作成する
+func newTestSocketPair(t *testing.T) (*BpSocket, *BpSocket) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("socketpair failed: %v", err)
+	}
+	a := &BpSocket{fd: fds[0], localAddr: NewSockaddrBP(150, 1)}
+	b := &BpSocket{fd: fds[1], localAddr: NewSockaddrBP(149, 1)}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestRecvReturnsWithinReadTimeout(t *testing.T) {
+	sock, _ := newTestSocketPair(t)
+
+	if err := sock.SetReadTimeout(100 * time.Millisecond); err != nil {
+		t.Fatalf("SetReadTimeout failed: %v", err)
+	}
+
+	start := time.Now()
+	_, _, err := sock.Recv(make([]byte, 64))
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Recv took %v, expected to return near the 100ms deadline", elapsed)
+	}
+}
+
+func TestRecvDeliversDataBeforeTimeout(t *testing.T) {
+	sock, peer := newTestSocketPair(t)
+
+	if err := sock.SetReadTimeout(time.Second); err != nil {
+		t.Fatalf("SetReadTimeout failed: %v", err)
+	}
+	if _, err := syscall.Write(peer.fd, []byte("bundle")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, _, err := sock.Recv(buf)
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if string(buf[:n]) != "bundle" {
+		t.Errorf("Expected 'bundle', got %q", buf[:n])
+	}
+}
+
+func TestReceiverCloseStopsLoopQuickly(t *testing.T) {
+	sock, _ := newTestSocketPair(t)
+	if err := sock.SetReadTimeout(recvPollInterval); err != nil {
+		t.Fatalf("SetReadTimeout failed: %v", err)
+	}
+
+	r := newBpReceiver(sock)
+	r.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	r.Close()
+
+	select {
+	case _, ok := <-r.GetDataChannel():
+		if ok {
+			t.Fatal("Expected data channel to be closed without data")
+		}
+	case <-time.After(2 * recvPollInterval):
+		t.Fatal("Receive loop did not stop after Close")
+	}
+}
+
+func TestReceiverCloseInterruptsBlockedRecv(t *testing.T) {
+	// タイムアウトを設定せず、recvfromでブロックした状態からCloseする
+	sock, _ := newTestSocketPair(t)
+
+	r := newBpReceiver(sock)
+	r.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- r.Close() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return within a second")
+	}
+
+	select {
+	case _, ok := <-r.GetDataChannel():
+		if ok {
+			t.Fatal("Expected data channel to be closed without data")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Data channel was not closed within a second")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("Second Close should be a no-op, got %v", err)
+	}
+}
+
+func TestSocketCloseIsIdempotent(t *testing.T) {
+	sock, _ := newTestSocketPair(t)
+
+	if err := sock.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := sock.Close(); err != nil {
+		t.Errorf("Second Close should be a no-op, got %v", err)
+	}
+	if _, _, err := sock.Recv(make([]byte, 8)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestRecvReportsRealSizeOfTruncatedBundle(t *testing.T) {
+	sock, peer := newTestSocketPair(t)
+
+	if _, err := syscall.Write(peer.fd, make([]byte, 100)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	n, _, err := sock.Recv(make([]byte, 10))
+	if err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("Expected MSG_TRUNC to report 100 bytes, got %d", n)
+	}
+}
+
+func TestBundleOptionsNoOpWhenUnsupported(t *testing.T) {
+	// AF_UNIXソケットはSOL_BPに対応していないため、カーネルモジュール非対応時と同じ経路を通る
+	sock, _ := newTestSocketPair(t)
+
+	if err := sock.SetBundleLifetime(time.Hour); err != nil {
+		t.Errorf("Expected unsupported lifetime option to be a no-op, got %v", err)
+	}
+	if !sock.optionsUnsupported.Load() {
+		t.Error("Expected the socket to remember that bundle options are unsupported")
+	}
+	if err := sock.SetPriority(PriorityExpedited); err != nil {
+		t.Errorf("Expected unsupported priority option to be a no-op, got %v", err)
+	}
+	if err := sock.SetPriority(Priority(42)); err == nil {
+		t.Error("Expected invalid priority to be rejected")
+	}
+}
+
+func TestSetBundleOptionReportsUnsupported(t *testing.T) {
+	sock, _ := newTestSocketPair(t)
+
+	if err := setBundleOption(sock.fd, BP_OPT_PRIORITY, BP_PRIORITY_BULK); !errors.Is(err, ErrOptionUnsupported) {
+		t.Errorf("Expected ErrOptionUnsupported, got %v", err)
+	}
+}
+
+func TestGetsocknameRoundTrip(t *testing.T) {
+	fd, err := syscall.Socket(AF_BP, SOCK_DGRAM, BP_PROTO)
+	if err != nil {
+		t.Skipf("AF_BP is not available: %v", err)
+	}
+	defer syscall.Close(fd)
+
+	requested := NewSockaddrBP(150, 42)
+	if err := bind(fd, requested); err != nil {
+		t.Skipf("bind failed (is ION running?): %v", err)
+	}
+	actual, err := getsockname(fd)
+	if err != nil {
+		t.Fatalf("getsockname failed: %v", err)
+	}
+	if actual.NodeNum != requested.NodeNum || actual.SvcNum != requested.SvcNum {
+		t.Errorf("Expected %s, got %s", requested.String(), actual.String())
+	}
+}
+
+func TestGetsocknameRejectsForeignFamily(t *testing.T) {
+	sock, _ := newTestSocketPair(t)
+
+	if _, err := getsockname(sock.fd); err == nil {
+		t.Error("Expected getsockname on an AF_UNIX socket to fail")
+	}
+	// 取得できない場合は要求したアドレスをそのまま使う
+	requested := NewSockaddrBP(150, 1)
+	if got := resolveLocalAddr(sock.fd, requested); got != requested {
+		t.Errorf("Expected the requested address as fallback, got %s", got.String())
+	}
+}
+
+func TestRecvTracesPayload(t *testing.T) {
+	sock, peer := newTestSocketPair(t)
+	var out bytes.Buffer
+	WithTrace(&out, 4)(sock)
+
+	if _, err := syscall.Write(peer.fd, []byte("bundle")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if _, _, err := sock.Recv(make([]byte, 64)); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+
+	want := "[BpSocket] RX ipn:0.0 -> ipn:150.1 (6 bytes)\n" +
+		"00000000  62 75 6e 64                                       |bund|\n" +
+		"... 2 more bytes\n"
+	if out.String() != want {
+		t.Errorf("Unexpected trace:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,61 @@
+// socket_test.go - バインド後のローカルアドレス解決のテスト（getsocknameを差し替える）
+package bpsocket
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+// fakeGetsockname getsocknameの結果を差し替え、出力されたログを返す
+func fakeGetsockname(t *testing.T, addr *SockaddrBP, err error) *bytes.Buffer {
+	t.Helper()
+	orig := getsocknameFn
+	getsocknameFn = func(fd int) (*SockaddrBP, error) { return addr, err }
+
+	var logs bytes.Buffer
+	origOutput := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() {
+		getsocknameFn = orig
+		log.SetOutput(origOutput)
+	})
+	return &logs
+}
+
+func TestResolveLocalAddrWarnsOnMismatch(t *testing.T) {
+	logs := fakeGetsockname(t, NewSockaddrBP(150, 2), nil)
+
+	got := resolveLocalAddr(3, NewSockaddrBP(150, 1))
+	if got.String() != "ipn:150.2" {
+		t.Errorf("Expected the kernel-assigned address, got %s", got.String())
+	}
+	if !strings.Contains(logs.String(), "Requested bind to ipn:150.1 but kernel assigned ipn:150.2") {
+		t.Errorf("Expected a mismatch warning, got %q", logs.String())
+	}
+}
+
+func TestResolveLocalAddrSilentWhenMatching(t *testing.T) {
+	logs := fakeGetsockname(t, NewSockaddrBP(150, 1), nil)
+
+	if got := resolveLocalAddr(3, NewSockaddrBP(150, 1)); got.String() != "ipn:150.1" {
+		t.Errorf("Unexpected address: %s", got.String())
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warning, got %q", logs.String())
+	}
+}
+
+func TestResolveLocalAddrFallsBackOnError(t *testing.T) {
+	logs := fakeGetsockname(t, nil, errors.New("not supported"))
+
+	requested := NewSockaddrBP(150, 1)
+	if got := resolveLocalAddr(3, requested); got != requested {
+		t.Errorf("Expected the requested address, got %s", got.String())
+	}
+	if !strings.Contains(logs.String(), "Could not read bound address") {
+		t.Errorf("Expected a warning, got %q", logs.String())
+	}
+}
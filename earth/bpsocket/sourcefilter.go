@@ -0,0 +1,108 @@
+// sourcefilter.go - 送信元EIDによる受信バンドルの制限
+// ipn:150.1に誰でもバンドルを注入できると、任意のURLをクロールさせられてしまうため、
+// 許可した送信元以外からのバンドルはJSONを解釈する前に破棄する
+package bpsocket
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// sourceFilter 許可する送信元EIDのパターン（空の場合はすべて許可）
+// パターンはpath.Matchの書式（"ipn:149.*", "dtn://moon/*"）で、数字のみの場合はそのノード番号のすべてのサービスを表す
+type sourceFilter struct {
+	mu       sync.RWMutex
+	patterns []string
+	denyAll  bool // 不正なパターンが指定された場合、安全側に倒してすべて拒否する
+}
+
+// normalizeSourcePatterns パターンを検証し、ノード番号のみの指定をipnのパターンに変換する
+func normalizeSourcePatterns(patterns []string) ([]string, error) {
+	normalized := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if _, err := strconv.ParseUint(p, 10, 32); err == nil {
+			p = "ipn:" + p + ".*"
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid source pattern %q: %w", p, err)
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized, nil
+}
+
+func (f *sourceFilter) set(patterns []string) error {
+	normalized, err := normalizeSourcePatterns(patterns)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.patterns = normalized
+	f.denyAll = false
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *sourceFilter) rejectAll() {
+	f.mu.Lock()
+	f.denyAll = true
+	f.mu.Unlock()
+}
+
+func (f *sourceFilter) get() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]string(nil), f.patterns...)
+}
+
+// allows 送信元が許可されているかどうか
+func (f *sourceFilter) allows(from *SockaddrBP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.denyAll {
+		return false
+	}
+	if len(f.patterns) == 0 {
+		return true
+	}
+	if from == nil {
+		return false
+	}
+	eid := from.String()
+	for _, p := range f.patterns {
+		if ok, _ := path.Match(p, eid); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithSourceAllowlist 指定したパターンに一致する送信元からのバンドルのみ受け入れる
+// 不正なパターンが含まれる場合は、ログに出したうえで安全側に倒してすべて拒否する
+func WithSourceAllowlist(patterns ...string) ReceiverOption {
+	return func(r *BpReceiver) {
+		if err := r.SetSourceAllowlist(patterns...); err != nil {
+			log.Printf("[BpReceiver] WARNING: %v, rejecting all sources", err)
+			r.sources.rejectAll()
+		}
+	}
+}
+
+// SetSourceAllowlist 受け入れる送信元のパターンを実行中に変更する（空にするとすべて許可）
+func (r *BpReceiver) SetSourceAllowlist(patterns ...string) error {
+	return r.sources.set(patterns)
+}
+
+// SourceAllowlist 現在の送信元パターンを返す
+func (r *BpReceiver) SourceAllowlist() []string {
+	return r.sources.get()
+}
+
+// SourcesRejected 許可されていない送信元から届き、破棄したバンドル数を返す
+func (r *BpReceiver) SourcesRejected() uint64 {
+	return r.sourcesRejected.Load()
+}
@@ -0,0 +1,116 @@
+// sourcefilter_test.go - 送信元EIDによる受信制限のテスト
+package bpsocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newFilteredReceiver ipn:149.1で受信するReceiverと、指定したノード番号から送信するSenderを作成する
+func newFilteredReceiver(t *testing.T, network *LoopbackNetwork, opts ...ReceiverOption) *BpReceiver {
+	t.Helper()
+	transport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	r := NewBpReceiverWithTransport(transport, opts...)
+	r.Start()
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func sendFrom(t *testing.T, network *LoopbackNetwork, nodeNum uint64, data interface{}) {
+	t.Helper()
+	transport, err := network.Listen(nodeNum, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sender := NewBpSenderWithTransport(transport, 149, 1)
+	defer sender.Close()
+	if err := sender.Send(context.Background(), data); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+}
+
+func TestSourceAllowlistDropsUnknownSources(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithSourceAllowlist("150"))
+
+	sendFrom(t, network, 666, "evil")
+	sendFrom(t, network, 150, "trusted")
+
+	data, ok := receiveWithin(t, r, time.Second)
+	if !ok || string(data) != `"trusted"` {
+		t.Fatalf("Expected only the trusted bundle, got %q (ok=%v)", data, ok)
+	}
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Error("Expected the bundle from node 666 to be dropped")
+	}
+	if n := r.SourcesRejected(); n != 1 {
+		t.Errorf("Expected 1 rejected bundle, got %d", n)
+	}
+
+	select {
+	case ev := <-r.Events():
+		if ev.Type != EventSourceRejected || ev.From.String() != "ipn:666.2" {
+			t.Errorf("Unexpected event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a SourceRejected event")
+	}
+}
+
+func TestSourceAllowlistCanChangeAtRuntime(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithSourceAllowlist("ipn:150.*"))
+
+	sendFrom(t, network, 151, "before")
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Fatal("Expected node 151 to be rejected initially")
+	}
+
+	if err := r.SetSourceAllowlist("ipn:150.*", "ipn:151.2"); err != nil {
+		t.Fatalf("SetSourceAllowlist failed: %v", err)
+	}
+	sendFrom(t, network, 151, "after")
+	if data, ok := receiveWithin(t, r, time.Second); !ok || string(data) != `"after"` {
+		t.Errorf("Expected node 151 to be accepted after update, got %q (ok=%v)", data, ok)
+	}
+
+	// 空にするとすべて許可
+	if err := r.SetSourceAllowlist(); err != nil {
+		t.Fatalf("SetSourceAllowlist failed: %v", err)
+	}
+	sendFrom(t, network, 152, "anyone")
+	if _, ok := receiveWithin(t, r, time.Second); !ok {
+		t.Error("Expected empty allowlist to accept every source")
+	}
+}
+
+func TestSourceAllowlistRejectsInvalidPatterns(t *testing.T) {
+	network := NewLoopbackNetwork()
+	r := newFilteredReceiver(t, network, WithSourceAllowlist("ipn:[150"))
+
+	if err := r.SetSourceAllowlist("ipn:[150"); err == nil {
+		t.Error("Expected invalid pattern to be rejected")
+	}
+
+	// 不正な設定で起動した場合は、安全側に倒してすべて拒否する
+	sendFrom(t, network, 150, "x")
+	if _, ok := receiveWithin(t, r, 100*time.Millisecond); ok {
+		t.Error("Expected every source to be rejected after an invalid allowlist")
+	}
+}
+
+func TestSourceAllowlistMatchesDTNEIDs(t *testing.T) {
+	var f sourceFilter
+	if err := f.set([]string{"dtn://earth/*"}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	allowed, _ := ParseEID("dtn://earth/crawler")
+	denied, _ := ParseEID("dtn://mars/crawler")
+	if !f.allows(allowed) || f.allows(denied) {
+		t.Error("Unexpected dtn pattern matching")
+	}
+}
@@ -0,0 +1,134 @@
+// stats.go - 送受信の統計（ログを集計しなくても転送量を把握できるようにする）
+package bpsocket
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SenderStats BpSenderの送信統計
+type SenderStats struct {
+	BundlesSent   uint64    // 送信に成功したバンドル数（再送は含まない）
+	BytesSent     uint64    // 送信に成功したバンドルのサイズ合計（エンベロープを含む）
+	SendErrors    uint64    // 送信に失敗したバンドル数
+	SendRetries   uint64    // 一時的な送信失敗（ENOBUFSなど）で再試行した回数
+	LargestBundle int       // 送信した最大のバンドルサイズ
+	LastActivity  time.Time // 最後に送信に成功した時刻（未送信の場合はゼロ値）
+}
+
+// ReceiverStats BpReceiverの受信統計
+type ReceiverStats struct {
+	BundlesReceived uint64    // ソケットから読んだバンドル数（制御バンドルや破棄したバンドルを含む）
+	BytesReceived   uint64    // ソケットから読んだバンドルのサイズ合計
+	Delivered       uint64    // データチャネルに渡したバンドル数
+	RecvErrors      uint64    // ソケットからの受信エラー数（タイムアウトは含まない）
+	Dropped         uint64    // 破棄したバンドル数（送信元の拒否・認証失敗・重複・デコード失敗・チャネル満杯）
+	LargestBundle   int       // 受信した最大のバンドルサイズ（切り詰められた場合は実際のサイズ）
+	LastActivity    time.Time // 最後にバンドルを受信した時刻（未受信の場合はゼロ値）
+}
+
+// BundleInfo 統計フックに渡す1バンドル分の情報
+type BundleInfo struct {
+	Peer *SockaddrBP // 送信先または送信元
+	Size int         // バンドルのサイズ
+	Err  error       // 送信の失敗、または受信したバンドルを破棄した理由（成功した場合はnil）
+}
+
+// BundleHook バンドルを送受信するたびに呼ばれる関数（Prometheusなどの外部集計との連携用）
+// 送信・受信ループから同期的に呼ばれるため、すぐに戻ること
+type BundleHook func(BundleInfo)
+
+// WithSendHook 送信したバンドルごとにhookを呼ぶ
+func WithSendHook(hook BundleHook) SenderOption {
+	return func(s *BpSender) {
+		s.hook = hook
+	}
+}
+
+// WithReceiveHook 受信したバンドルごとにhookを呼ぶ（制御バンドルを含む）
+func WithReceiveHook(hook BundleHook) ReceiverOption {
+	return func(r *BpReceiver) {
+		r.hook = hook
+	}
+}
+
+// trafficCounters 送受信統計のカウンター（送信・受信ループから並行して更新される）
+type trafficCounters struct {
+	bundles   atomic.Uint64
+	bytes     atomic.Uint64
+	delivered atomic.Uint64
+	errors    atomic.Uint64
+	dropped   atomic.Uint64
+	largest   atomic.Int64
+	last      atomic.Int64 // UnixNano
+}
+
+// transferred バンドルの送受信を記録する
+func (c *trafficCounters) transferred(size int) {
+	c.bundles.Add(1)
+	c.bytes.Add(uint64(size))
+	c.last.Store(time.Now().UnixNano())
+	for {
+		largest := c.largest.Load()
+		if int64(size) <= largest || c.largest.CompareAndSwap(largest, int64(size)) {
+			return
+		}
+	}
+}
+
+func (c *trafficCounters) lastActivity() time.Time {
+	if last := c.last.Load(); last != 0 {
+		return time.Unix(0, last)
+	}
+	return time.Time{}
+}
+
+// Stats 送信統計のスナップショットを返す
+func (s *BpSender) Stats() SenderStats {
+	c := &s.stats
+	return SenderStats{
+		BundlesSent:   c.bundles.Load(),
+		BytesSent:     c.bytes.Load(),
+		SendErrors:    c.errors.Load(),
+		SendRetries:   s.sendRetries.Load(),
+		LargestBundle: int(c.largest.Load()),
+		LastActivity:  c.lastActivity(),
+	}
+}
+
+// recordSend 送信結果を統計とフックに反映する
+func (s *BpSender) recordSend(remote *SockaddrBP, size int, err error) {
+	if err != nil {
+		s.stats.errors.Add(1)
+	} else {
+		s.stats.transferred(size)
+	}
+	if s.hook != nil {
+		s.hook(BundleInfo{Peer: remote, Size: size, Err: err})
+	}
+}
+
+// Stats 受信統計のスナップショットを返す
+func (r *BpReceiver) Stats() ReceiverStats {
+	c := &r.stats
+	return ReceiverStats{
+		BundlesReceived: c.bundles.Load(),
+		BytesReceived:   c.bytes.Load(),
+		Delivered:       c.delivered.Load(),
+		RecvErrors:      c.errors.Load(),
+		Dropped:         c.dropped.Load(),
+		LargestBundle:   int(c.largest.Load()),
+		LastActivity:    c.lastActivity(),
+	}
+}
+
+// recordReceive 受信したバンドルの処理結果を統計とフックに反映する
+func (r *BpReceiver) recordReceive(from *SockaddrBP, size int, err error) {
+	r.stats.transferred(size)
+	if err != nil {
+		r.stats.dropped.Add(1)
+	}
+	if r.hook != nil {
+		r.hook(BundleInfo{Peer: from, Size: size, Err: err})
+	}
+}
@@ -0,0 +1,136 @@
+// stats_test.go - 送受信統計とフックのテスト
+package bpsocket
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatsAfterScriptedExchange(t *testing.T) {
+	network := NewLoopbackNetwork()
+	var received, dropped atomic.Int32
+	r := newFilteredReceiver(t, network, WithSourceAllowlist("150"), WithReceiveHook(func(info BundleInfo) {
+		if info.Err != nil {
+			dropped.Add(1)
+		} else {
+			received.Add(1)
+		}
+	}))
+
+	transport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	var hooked atomic.Int32
+	sender := NewBpSenderWithTransport(transport, 149, 1, WithSendHook(func(info BundleInfo) {
+		if info.Err == nil && info.Peer.String() == "ipn:149.1" {
+			hooked.Add(1)
+		}
+	}))
+	defer sender.Close()
+
+	start := time.Now()
+	payloads := []string{"a", strings.Repeat("b", 1000), "c"}
+	for _, p := range payloads {
+		if err := sender.Send(context.Background(), p); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	for range payloads {
+		if _, ok := receiveWithin(t, r, time.Second); !ok {
+			t.Fatal("Bundle was not delivered")
+		}
+	}
+	sendFrom(t, network, 666, "rejected")
+	time.Sleep(50 * time.Millisecond)
+
+	sent := sender.Stats()
+	if sent.BundlesSent != 3 || sent.BytesSent != 3+1002+3 || sent.LargestBundle != 1002 || sent.SendErrors != 0 {
+		t.Errorf("Unexpected sender stats: %+v", sent)
+	}
+	if sent.LastActivity.Before(start) {
+		t.Errorf("Expected LastActivity to be updated, got %v", sent.LastActivity)
+	}
+	if hooked.Load() != 3 {
+		t.Errorf("Expected the send hook to run 3 times, got %d", hooked.Load())
+	}
+
+	got := r.Stats()
+	if got.BundlesReceived != 4 || got.Delivered != 3 || got.Dropped != 1 || got.LargestBundle != 1002 {
+		t.Errorf("Unexpected receiver stats: %+v", got)
+	}
+	if got.BytesReceived != uint64(3+1002+3+len(`"rejected"`)) {
+		t.Errorf("Unexpected received bytes: %d", got.BytesReceived)
+	}
+	if received.Load() != 3 || dropped.Load() != 1 {
+		t.Errorf("Expected the receive hook to see 3 delivered and 1 dropped, got %d and %d", received.Load(), dropped.Load())
+	}
+}
+
+func TestStatsCountSendErrors(t *testing.T) {
+	network := NewLoopbackNetwork()
+	transport, err := network.Listen(150, 2)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	var hookErr error
+	sender := NewBpSenderWithTransport(transport, 149, 1, WithSendHook(func(info BundleInfo) { hookErr = info.Err }))
+	transport.Close()
+
+	if err := sender.Send(context.Background(), "payload"); err == nil {
+		t.Fatal("Expected Send on a closed transport to fail")
+	}
+	if stats := sender.Stats(); stats.SendErrors != 1 || stats.BundlesSent != 0 || !stats.LastActivity.IsZero() {
+		t.Errorf("Unexpected sender stats: %+v", stats)
+	}
+	if !errors.Is(hookErr, ErrClosed) {
+		t.Errorf("Expected the hook to receive the send error, got %v", hookErr)
+	}
+}
+
+func TestStatsAreRaceSafe(t *testing.T) {
+	a, b := newEndpointPair(t, NewLoopbackNetwork())
+
+	const workers, perWorker = 8, 25
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if err := a.Send(context.Background(), "ipn:149.1", j); err != nil {
+					t.Errorf("Send failed: %v", err)
+				}
+			}
+		}()
+	}
+
+	// 受信チャネルの容量を超えた分はdispatchで捨てられるため、届いた数と捨てた数の合計を確かめる
+	const total = workers * perWorker
+	var received uint64
+	timeout := time.After(5 * time.Second)
+	for {
+		s := b.Stats().Received
+		if s.Delivered+s.Dropped == total && received == s.Delivered {
+			break
+		}
+		select {
+		case bundle := <-b.GetDataChannel():
+			bundle.Release()
+			received++
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("Only %d bundles accounted for: received %d, stats %+v", s.Delivered+s.Dropped, received, s)
+		}
+	}
+	wg.Wait()
+
+	if s := a.Stats().Sent; s.BundlesSent != total {
+		t.Errorf("Expected %d bundles sent, got %d", total, s.BundlesSent)
+	}
+}
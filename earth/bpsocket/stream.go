@@ -0,0 +1,343 @@
+// stream.go - 大きなペイロードをチャンクに分割して送受信するio.Writer/io.Readerのストリーム
+//
+// チャンクバンドルのペイロード（エンベロープの種別はenvelopeTypeChunk）:
+//
+//	+-----------+-------------------+-----------+-------------+-----------------+
+//	| id length | stream id         | index     | chunk flags | data ...        |
+//	| 1byte     | (id length) bytes | 4byte BE  | 1byte       |                 |
+//	+-----------+-------------------+-----------+-------------+-----------------+
+//
+// ストリームIDにはリクエストIDを使う。チャンクヘッダーはエンベロープのペイロードに含まれるため、
+// 圧縮・暗号化・MACは通常のバンドルと同様に適用される
+package bpsocket
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultChunkSize 1チャンクあたりのデータサイズ（送信側の最大バンドルサイズが小さい場合はそれに合わせる）
+	defaultChunkSize = 512 * 1024
+
+	// defaultChunkTimeout 次のチャンクを待つ時間（これを超えるとReadがErrChunkTimeoutを返す）
+	defaultChunkTimeout = 60 * time.Second
+
+	// maxPendingStreams 同時に受信できるストリーム数（読み手のいないストリームも含む）
+	maxPendingStreams = 64
+
+	// orphanStreamTimeout 読み手のいないストリームを破棄するまでの時間
+	orphanStreamTimeout = 10 * time.Minute
+
+	chunkFlagLast byte = 1 << 0 // ストリームの最後のチャンク
+)
+
+// ErrChunkTimeout 次のチャンクが一定時間届かなかった
+var ErrChunkTimeout = errors.New("bpsocket: timed out waiting for stream chunk")
+
+// encodeChunk チャンクヘッダーとデータを連結する
+func encodeChunk(streamID string, index uint32, last bool, data []byte) ([]byte, error) {
+	if len(streamID) == 0 || len(streamID) > 255 {
+		return nil, fmt.Errorf("stream ID must be 1-255 bytes, got %d", len(streamID))
+	}
+	buf := make([]byte, 0, 1+len(streamID)+5+len(data))
+	buf = append(buf, byte(len(streamID)))
+	buf = append(buf, streamID...)
+	buf = binary.BigEndian.AppendUint32(buf, index)
+	var flags byte
+	if last {
+		flags |= chunkFlagLast
+	}
+	buf = append(buf, flags)
+	return append(buf, data...), nil
+}
+
+// decodeChunk チャンクヘッダーを解釈する（返すdataはpayloadを参照する）
+func decodeChunk(payload []byte) (streamID string, index uint32, last bool, data []byte, err error) {
+	if len(payload) < 1 {
+		return "", 0, false, nil, fmt.Errorf("chunk too short: %d bytes", len(payload))
+	}
+	idLen := int(payload[0])
+	if idLen == 0 || len(payload) < 1+idLen+5 {
+		return "", 0, false, nil, fmt.Errorf("chunk too short: %d bytes", len(payload))
+	}
+	streamID = string(payload[1 : 1+idLen])
+	offset := 1 + idLen
+	index = binary.BigEndian.Uint32(payload[offset:])
+	last = payload[offset+4]&chunkFlagLast != 0
+	return streamID, index, last, payload[offset+5:], nil
+}
+
+// BundleWriter 書き込んだデータをチャンクバンドルに分割して送信するio.WriteCloser
+// チャンクサイズまでバッファし、Closeで残りを最後のチャンクとして送信する
+type BundleWriter struct {
+	sender   *BpSender
+	remote   *SockaddrBP
+	streamID string
+	ctx      context.Context
+	opts     SendOptions
+
+	buf    []byte
+	index  uint32
+	closed bool
+	err    error
+}
+
+// NewBundleWriter senderの宛先へrequestIDのストリームを送信するWriterを作成する
+func NewBundleWriter(sender *BpSender, requestID string) *BundleWriter {
+	return newBundleWriter(context.Background(), sender, sender.remoteAddr, requestID, SendOptions{})
+}
+
+func newBundleWriter(ctx context.Context, sender *BpSender, remote *SockaddrBP, streamID string, opts SendOptions) *BundleWriter {
+	return &BundleWriter{
+		sender:   sender,
+		remote:   remote,
+		streamID: streamID,
+		ctx:      ctx,
+		opts:     opts,
+		buf:      make([]byte, 0, chunkSizeFor(sender.maxBundleSize)),
+	}
+}
+
+// Write データをバッファし、チャンクサイズに達するごとに送信する
+func (w *BundleWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(cap(w.buf)-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close 残りのデータを最後のチャンクとして送信する（データがなくても終端を示すチャンクを送る）
+func (w *BundleWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	return w.flush(true)
+}
+
+func (w *BundleWriter) flush(last bool) error {
+	chunk, err := encodeChunk(w.streamID, w.index, last, w.buf)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if err := w.sender.sendPayload(w.ctx, w.remote, envelopeTypeChunk, chunk, w.opts); err != nil {
+		// 途中のチャンクが欠けたストリームは受信側で完成しないため、以降の書き込みもすべて失敗させる
+		w.err = fmt.Errorf("stream %s chunk %d: %w", w.streamID, w.index, err)
+		return w.err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// chunkStream 受信中のストリーム（順不同で届いたチャンクを保持する）
+type chunkStream struct {
+	mu       sync.Mutex
+	chunks   map[uint32][]byte
+	next     uint32 // 次にReadで返すチャンク
+	last     int64  // 最後のチャンクの番号（未着の場合は-1）
+	attached bool   // BundleReaderが読んでいる
+	updated  time.Time
+	notify   chan struct{}
+}
+
+func newChunkStream() *chunkStream {
+	return &chunkStream{
+		chunks:  make(map[uint32][]byte),
+		last:    -1,
+		updated: time.Now(),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// streamTable 受信側のストリーム一覧
+type streamTable struct {
+	mu      sync.Mutex
+	streams map[string]*chunkStream
+}
+
+// get ストリームを取得する（なければ作成する）
+func (t *streamTable) get(id string, attach bool) (*chunkStream, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.streams == nil {
+		t.streams = make(map[string]*chunkStream)
+	}
+	if s, ok := t.streams[id]; ok {
+		if attach {
+			s.mu.Lock()
+			s.attached = true
+			s.mu.Unlock()
+		}
+		return s, nil
+	}
+
+	t.sweepLocked()
+	if len(t.streams) >= maxPendingStreams {
+		return nil, fmt.Errorf("too many pending streams (%d)", maxPendingStreams)
+	}
+	s := newChunkStream()
+	s.attached = attach
+	t.streams[id] = s
+	return s, nil
+}
+
+// sweepLocked 読み手がつかないまま放置されたストリームを破棄する
+func (t *streamTable) sweepLocked() {
+	for id, s := range t.streams {
+		s.mu.Lock()
+		orphaned := !s.attached && time.Since(s.updated) > orphanStreamTimeout
+		s.mu.Unlock()
+		if orphaned {
+			log.Printf("[BpReceiver] Discarding unclaimed stream %s", id)
+			delete(t.streams, id)
+		}
+	}
+}
+
+func (t *streamTable) remove(id string, s *chunkStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.streams[id] == s {
+		delete(t.streams, id)
+	}
+}
+
+// deliver 受信したチャンクを対応するストリームに格納する
+// ownedがfalseの場合、payloadは受信バッファを参照しているためコピーする
+func (t *streamTable) deliver(payload []byte, owned bool) error {
+	id, index, last, data, err := decodeChunk(payload)
+	if err != nil {
+		return err
+	}
+	s, err := t.get(id, false)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		data = append([]byte(nil), data...)
+	}
+
+	s.mu.Lock()
+	if index >= s.next {
+		if _, dup := s.chunks[index]; !dup {
+			s.chunks[index] = data
+		}
+	}
+	if last {
+		s.last = int64(index)
+	}
+	s.updated = time.Now()
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// BundleReader requestIDのストリームのチャンクを順番に読み出すio.ReadCloser
+type BundleReader struct {
+	table    *streamTable
+	stream   *chunkStream
+	streamID string
+	timeout  time.Duration
+	current  []byte
+}
+
+// NewBundleReader receiverに届くrequestIDのストリームを読み出すReaderを作成する
+// Readerを作成する前に届いたチャンクも保持されている。読み終えたらCloseすること
+func NewBundleReader(receiver *BpReceiver, requestID string) (*BundleReader, error) {
+	s, err := receiver.streams.get(requestID, true)
+	if err != nil {
+		return nil, err
+	}
+	return &BundleReader{
+		table:    &receiver.streams,
+		stream:   s,
+		streamID: requestID,
+		timeout:  defaultChunkTimeout,
+	}, nil
+}
+
+// SetChunkTimeout 次のチャンクを待つ時間を設定する
+func (r *BundleReader) SetChunkTimeout(d time.Duration) {
+	r.timeout = d
+}
+
+// Read ストリームのデータを順番に読み出す（最後のチャンクまで読むとio.EOFを返す）
+func (r *BundleReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// nextChunk 次のチャンクが届くまで待ち、r.currentに設定する
+func (r *BundleReader) nextChunk() error {
+	s := r.stream
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if s.last >= 0 && int64(s.next) > s.last {
+			s.mu.Unlock()
+			return io.EOF
+		}
+		if data, ok := s.chunks[s.next]; ok {
+			delete(s.chunks, s.next)
+			s.next++
+			s.mu.Unlock()
+			r.current = data
+			return nil
+		}
+		missing := s.next
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-timer.C:
+			return fmt.Errorf("%w: stream %s chunk %d", ErrChunkTimeout, r.streamID, missing)
+		}
+	}
+}
+
+// Close ストリームの受信を終了し、残っているチャンクを破棄する
+func (r *BundleReader) Close() error {
+	r.table.remove(r.streamID, r.stream)
+	return nil
+}
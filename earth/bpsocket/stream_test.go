@@ -0,0 +1,153 @@
+// stream_test.go - チャンク分割によるストリーム送受信のテスト
+package bpsocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStreamLargePayloadOverLoopback(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork())
+
+	reader, err := NewBundleReader(receiver, "req-large")
+	if err != nil {
+		t.Fatalf("NewBundleReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	payload := make([]byte, 20*1024*1024)
+	rand.Read(payload)
+	want := sha256.Sum256(payload)
+
+	errCh := make(chan error, 1)
+	go func() {
+		w := NewBundleWriter(sender, "req-large")
+		// 書き込み単位がチャンク境界と揃わなくてもよい
+		if _, err := io.CopyBuffer(w, bytes.NewReader(payload), make([]byte, 100_000)); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- w.Close()
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+		t.Error("Received stream does not match the sent payload")
+	}
+
+	if _, ok := receiveWithin(t, receiver, 100*time.Millisecond); ok {
+		t.Error("Expected chunks not to be delivered on the data channel")
+	}
+}
+
+func TestStreamReassemblesOutOfOrderChunks(t *testing.T) {
+	r := newBpReceiver(nil)
+	for _, c := range []struct {
+		index uint32
+		last  bool
+		data  string
+	}{{2, true, "c"}, {0, false, "a"}, {1, false, "b"}, {1, false, "b"}} {
+		chunk, err := encodeChunk("req-1", c.index, c.last, []byte(c.data))
+		if err != nil {
+			t.Fatalf("encodeChunk failed: %v", err)
+		}
+		if err := r.streams.deliver(chunk, false); err != nil {
+			t.Fatalf("deliver failed: %v", err)
+		}
+	}
+
+	// チャンクが先に届いていてもReaderを作成した時点で読み出せる
+	reader, err := NewBundleReader(r, "req-1")
+	if err != nil {
+		t.Fatalf("NewBundleReader failed: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != "abc" {
+		t.Errorf("Expected \"abc\", got %q (err=%v)", data, err)
+	}
+}
+
+func TestStreamMissingChunkTimesOut(t *testing.T) {
+	r := newBpReceiver(nil)
+	reader, err := NewBundleReader(r, "req-1")
+	if err != nil {
+		t.Fatalf("NewBundleReader failed: %v", err)
+	}
+	defer reader.Close()
+	reader.SetChunkTimeout(100 * time.Millisecond)
+
+	chunk, _ := encodeChunk("req-1", 1, true, []byte("tail"))
+	r.streams.deliver(chunk, false)
+
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrChunkTimeout) {
+		t.Errorf("Expected ErrChunkTimeout for the missing first chunk, got %v", err)
+	}
+}
+
+func TestStreamEmptyWriterSendsEOF(t *testing.T) {
+	sender, receiver := newLoopbackPair(t, NewLoopbackNetwork())
+	reader, err := NewBundleReader(receiver, "req-empty")
+	if err != nil {
+		t.Fatalf("NewBundleReader failed: %v", err)
+	}
+	defer reader.Close()
+	reader.SetChunkTimeout(time.Second)
+
+	if err := NewBundleWriter(sender, "req-empty").Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if data, err := io.ReadAll(reader); err != nil || len(data) != 0 {
+		t.Errorf("Expected an empty stream, got %d bytes (err=%v)", len(data), err)
+	}
+}
+
+func TestStreamOverEncryptedEndpoints(t *testing.T) {
+	a, b := newEndpointPair(t, NewLoopbackNetwork(),
+		WithSenderOptions(WithEncryptionKey(testEncKey), WithCompression(CompressionGzip, 64)),
+		WithReceiverOptions(WithDecryptionKeys(testEncKey)))
+
+	reader, err := b.NewBundleReader("req-enc")
+	if err != nil {
+		t.Fatalf("NewBundleReader failed: %v", err)
+	}
+	defer reader.Close()
+
+	payload := bytes.Repeat([]byte("stream over an encrypted link "), 50_000)
+	w, err := a.NewBundleWriter(context.Background(), b.LocalAddr().String(), "req-enc", SendOptions{})
+	if err != nil {
+		t.Fatalf("NewBundleWriter failed: %v", err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(data, payload) {
+		t.Errorf("Expected the payload back, got %d bytes (err=%v)", len(data), err)
+	}
+}
+
+func TestWriterRejectsInvalidStreamID(t *testing.T) {
+	sock := newBlockingSocket()
+	close(sock.release)
+	w := NewBundleWriter(newBpSender(sock, NewSockaddrBP(149, 1)), "")
+	if err := w.Close(); err == nil {
+		t.Error("Expected an empty stream ID to be rejected")
+	}
+}
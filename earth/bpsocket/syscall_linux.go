@@ -7,60 +7,150 @@ package bpsocket
 import (
 	"fmt"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
+// classifyErrno errnoを呼び出し側が判断に使う分類済みのエラーに対応付ける（該当しない場合はnil）
+func classifyErrno(errno syscall.Errno) error {
+	switch errno {
+	case syscall.EAFNOSUPPORT, syscall.EPROTONOSUPPORT, syscall.ENODEV:
+		// AF_BPが登録されていない、またはカーネルモジュールがアンロードされた
+		return ErrModuleNotLoaded
+	case syscall.EADDRINUSE:
+		return ErrAddressInUse
+	case syscall.EINTR:
+		return ErrInterrupted
+	case syscall.EAGAIN, syscall.ETIMEDOUT:
+		// SO_RCVTIMEO/SO_SNDTIMEOのタイムアウト（EWOULDBLOCKはEAGAINと同じ値）
+		return ErrTimeout
+	}
+	return nil
+}
+
 func closeFd(fd int) error {
 	return syscall.Close(fd)
 }
 
+// shutdownFd ブロック中のrecvfrom/sendtoを戻すためにソケットをシャットダウンする
+func shutdownFd(fd int) error {
+	err := syscall.Shutdown(fd, syscall.SHUT_RDWR)
+	if err == syscall.ENOTCONN {
+		// 未接続のデータグラムソケットでも読み込み側は起床するため無視する
+		return nil
+	}
+	return err
+}
+
 func bind(fd int, addr *SockaddrBP) error {
-	rawAddr := (*syscall.RawSockaddrAny)(unsafe.Pointer(addr))
+	rawAddr := addr.ToBytes()
 	_, _, errno := syscall.Syscall(
 		syscall.SYS_BIND,
 		uintptr(fd),
-		uintptr(unsafe.Pointer(rawAddr)),
-		uintptr(unsafe.Sizeof(*addr)),
+		uintptr(unsafe.Pointer(&rawAddr[0])),
+		uintptr(len(rawAddr)),
 	)
 	if errno != 0 {
-		return fmt.Errorf("bind syscall error: %v", errno)
+		return newSyscallError("bind", errno)
 	}
 	return nil
 }
 
+// getsockname カーネルがソケットに割り当てたローカルアドレスを取得する
+func getsockname(fd int) (*SockaddrBP, error) {
+	var rawAddr [sockaddrMaxSize]byte
+	addrLen := uint32(len(rawAddr))
+
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_GETSOCKNAME,
+		uintptr(fd),
+		uintptr(unsafe.Pointer(&rawAddr[0])),
+		uintptr(unsafe.Pointer(&addrLen)),
+	)
+	if errno != 0 {
+		return nil, newSyscallError("getsockname", errno)
+	}
+
+	addr, err := parseSockaddrBP(rawAddr[:min(int(addrLen), len(rawAddr))])
+	if err != nil {
+		return nil, fmt.Errorf("getsockname: %w", err)
+	}
+	if addr.Family != AF_BP {
+		return nil, fmt.Errorf("getsockname: unexpected address family %d", addr.Family)
+	}
+	return addr, nil
+}
+
 func sendto(fd int, data []byte, remoteAddr *SockaddrBP) error {
-	rawAddr := (*syscall.RawSockaddrAny)(unsafe.Pointer(remoteAddr))
+	rawAddr := remoteAddr.ToBytes()
 	_, _, errno := syscall.Syscall6(
 		syscall.SYS_SENDTO,
 		uintptr(fd),
 		uintptr(unsafe.Pointer(&data[0])),
 		uintptr(len(data)),
 		0,
-		uintptr(unsafe.Pointer(rawAddr)),
-		uintptr(unsafe.Sizeof(*remoteAddr)),
+		uintptr(unsafe.Pointer(&rawAddr[0])),
+		uintptr(len(rawAddr)),
 	)
 	if errno != 0 {
-		return fmt.Errorf("sendto syscall error: %v", errno)
+		return newSyscallError("sendto", errno)
 	}
 	return nil
 }
 
+// recvfrom バンドルを受信する
+// MSG_TRUNCを指定しているため、バンドルがbufに収まらない場合は切り詰められ、戻り値は実際のサイズ（> len(buf)）となる
 func recvfrom(fd int, buf []byte) (int, *SockaddrBP, error) {
-	var fromAddr SockaddrBP
-	fromLen := uint32(unsafe.Sizeof(fromAddr))
+	var rawAddr [sockaddrMaxSize]byte
+	fromLen := uint32(len(rawAddr))
 
 	n, _, errno := syscall.Syscall6(
 		syscall.SYS_RECVFROM,
 		uintptr(fd),
 		uintptr(unsafe.Pointer(&buf[0])),
 		uintptr(len(buf)),
-		0,
-		uintptr(unsafe.Pointer(&fromAddr)),
+		syscall.MSG_TRUNC,
+		uintptr(unsafe.Pointer(&rawAddr[0])),
 		uintptr(unsafe.Pointer(&fromLen)),
 	)
 	if errno != 0 {
-		return 0, nil, fmt.Errorf("recvfrom syscall error: %v", errno)
+		return 0, nil, newSyscallError("recvfrom", errno)
+	}
+
+	fromAddr, err := parseSockaddrBP(rawAddr[:min(int(fromLen), len(rawAddr))])
+	if err != nil {
+		// 送信元が解釈できなくてもデータは受信できているため、空のアドレスとして扱う
+		fromAddr = &SockaddrBP{Family: AF_BP}
+	}
+	return int(n), fromAddr, nil
+}
+
+// setBundleOption SOL_BPレベルのソケットオプションを設定する
+// カーネルモジュールが対応していない場合はErrOptionUnsupportedをラップして返す
+func setBundleOption(fd int, opt int, value int) error {
+	err := syscall.SetsockoptInt(fd, SOL_BP, opt, value)
+	if err == syscall.ENOPROTOOPT || err == syscall.EOPNOTSUPP || err == syscall.EINVAL {
+		return fmt.Errorf("setsockopt %d: %w (%v)", opt, ErrOptionUnsupported, err)
+	}
+	if err != nil {
+		return fmt.Errorf("setsockopt %d error: %v", opt, err)
 	}
+	return nil
+}
+
+// setTimeout SO_RCVTIMEO/SO_SNDTIMEOを設定する（0で無期限）
+func setTimeout(fd int, opt int, d time.Duration) error {
+	tv := syscall.NsecToTimeval(d.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, opt, &tv); err != nil {
+		return fmt.Errorf("setsockopt timeout error: %v", err)
+	}
+	return nil
+}
+
+func setRecvTimeout(fd int, d time.Duration) error {
+	return setTimeout(fd, syscall.SO_RCVTIMEO, d)
+}
 
-	return int(n), &fromAddr, nil
+func setSendTimeout(fd int, d time.Duration) error {
+	return setTimeout(fd, syscall.SO_SNDTIMEO, d)
 }
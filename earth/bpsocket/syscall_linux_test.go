@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+// syscall_linux_test.go - errnoから分類済みのエラーへの変換のテスト
+package bpsocket
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSyscallErrorClassifiesErrno(t *testing.T) {
+	kinds := []error{ErrModuleNotLoaded, ErrAddressInUse, ErrInterrupted, ErrTimeout}
+	cases := []struct {
+		errno syscall.Errno
+		want  error // nilの場合はどの分類にも一致しない
+	}{
+		{syscall.EAFNOSUPPORT, ErrModuleNotLoaded},
+		{syscall.EPROTONOSUPPORT, ErrModuleNotLoaded},
+		{syscall.ENODEV, ErrModuleNotLoaded},
+		{syscall.EADDRINUSE, ErrAddressInUse},
+		{syscall.EINTR, ErrInterrupted},
+		{syscall.EAGAIN, ErrTimeout},
+		{syscall.EWOULDBLOCK, ErrTimeout},
+		{syscall.ETIMEDOUT, ErrTimeout},
+		{syscall.EACCES, nil},
+		{syscall.EINVAL, nil},
+	}
+	for _, tc := range cases {
+		// 呼び出し側と同じようにラップしても判別できる
+		err := fmt.Errorf("bind failed ipn:150.1: %w", newSyscallError("bind", tc.errno))
+
+		for _, kind := range kinds {
+			if got := errors.Is(err, kind); got != (kind == tc.want) {
+				t.Errorf("%v: errors.Is(%v) = %v", tc.errno, kind, got)
+			}
+		}
+		if !errors.Is(err, tc.errno) {
+			t.Errorf("%v: expected the original errno to be preserved", tc.errno)
+		}
+		var sysErr *SyscallError
+		if !errors.As(err, &sysErr) || sysErr.Op != "bind" || sysErr.Errno != tc.errno {
+			t.Errorf("%v: expected a *SyscallError for bind, got %v", tc.errno, err)
+		}
+	}
+}
+
+func TestNewSyscallErrorKeepsNonErrno(t *testing.T) {
+	orig := errors.New("not an errno")
+	if err := newSyscallError("socket", orig); err != orig {
+		t.Errorf("Expected non-errno errors to be returned unchanged, got %v", err)
+	}
+}
+
+func TestNewBpSocketReportsMissingModule(t *testing.T) {
+	sock, err := NewBpSocket(150, 1)
+	if err == nil {
+		sock.Close()
+		t.Skip("AF_BP is available on this host")
+	}
+	if errors.Is(err, syscall.EAFNOSUPPORT) && !errors.Is(err, ErrModuleNotLoaded) {
+		t.Errorf("Expected ErrModuleNotLoaded, got %v", err)
+	}
+}
+
+func TestRecvTimeoutIsClassified(t *testing.T) {
+	sock, _ := newTestSocketPair(t)
+	if err := sock.SetReadTimeout(10 * time.Millisecond); err != nil {
+		t.Fatalf("SetReadTimeout failed: %v", err)
+	}
+	_, _, err := sock.Recv(make([]byte, 16))
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("Expected ErrTimeout wrapping EAGAIN, got %v", err)
+	}
+}
@@ -7,16 +7,29 @@ package bpsocket
 import (
 	"fmt"
 	"syscall"
+	"time"
 )
 
+func classifyErrno(errno syscall.Errno) error {
+	return nil
+}
+
 func closeFd(fd int) error {
 	return syscall.Close(syscall.Handle(fd))
 }
 
+func shutdownFd(fd int) error {
+	return nil
+}
+
 func bind(fd int, addr *SockaddrBP) error {
 	return fmt.Errorf("bp-socket not supported on Windows")
 }
 
+func getsockname(fd int) (*SockaddrBP, error) {
+	return nil, fmt.Errorf("bp-socket not supported on Windows")
+}
+
 func sendto(fd int, data []byte, remoteAddr *SockaddrBP) error {
 	return fmt.Errorf("bp-socket not supported on Windows")
 }
@@ -24,3 +37,15 @@ func sendto(fd int, data []byte, remoteAddr *SockaddrBP) error {
 func recvfrom(fd int, buf []byte) (int, *SockaddrBP, error) {
 	return 0, nil, fmt.Errorf("bp-socket not supported on Windows")
 }
+
+func setBundleOption(fd int, opt int, value int) error {
+	return fmt.Errorf("bp-socket not supported on Windows")
+}
+
+func setRecvTimeout(fd int, d time.Duration) error {
+	return fmt.Errorf("bp-socket not supported on Windows")
+}
+
+func setSendTimeout(fd int, d time.Duration) error {
+	return fmt.Errorf("bp-socket not supported on Windows")
+}
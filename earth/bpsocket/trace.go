@@ -0,0 +1,89 @@
+// trace.go - BpSocketで送受信したペイロードのトレース（16進ダンプ）
+// カーネルモジュールとの相互接続が壊れた場合に、ソケットを実際に通過したバイト列を確認するためのデバッグ機能
+// WithTraceオプション、または環境変数BPSOCKET_TRACEで有効にする
+package bpsocket
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// DefaultTraceLimit トレースで出力するペイロードの既定の最大バイト数
+	DefaultTraceLimit = 256
+
+	// traceEnv 空でない値（"0"、"false"以外）を設定するとトレースを標準エラー出力に出す
+	traceEnv = "BPSOCKET_TRACE"
+	// traceLimitEnv トレースで出力するペイロードの最大バイト数（省略時はDefaultTraceLimit）
+	traceLimitEnv = "BPSOCKET_TRACE_LIMIT"
+)
+
+// SocketOption BpSocketの設定オプション
+type SocketOption func(*BpSocket)
+
+// WithTrace 送受信したペイロードの16進ダンプと送受信先のアドレスをwへ出力する
+// ペイロードは先頭limitバイトまで出力する（0以下の場合はDefaultTraceLimit）
+func WithTrace(w io.Writer, limit int) SocketOption {
+	return func(s *BpSocket) {
+		s.tracer = newTracer(w, limit)
+	}
+}
+
+// tracer ペイロードのダンプを書き出す（複数のgoroutineから送受信しても出力が混ざらないよう直列化する）
+type tracer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	limit int
+}
+
+func newTracer(w io.Writer, limit int) *tracer {
+	if limit <= 0 {
+		limit = DefaultTraceLimit
+	}
+	return &tracer{w: w, limit: limit}
+}
+
+// tracerFromEnv 環境変数でトレースが有効な場合に標準エラー出力へのtracerを返す（無効ならnil）
+func tracerFromEnv() *tracer {
+	switch os.Getenv(traceEnv) {
+	case "", "0", "false":
+		return nil
+	}
+	limit := DefaultTraceLimit
+	if v := os.Getenv(traceLimitEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Printf("[BpSocket] WARNING: Invalid %s=%q, using %d", traceLimitEnv, v, DefaultTraceLimit)
+		} else {
+			limit = n
+		}
+	}
+	return newTracer(os.Stderr, limit)
+}
+
+// trace 1つのペイロードのダンプを出力する
+// 形式: "[BpSocket] TX ipn:150.1 -> ipn:149.1 (1234 bytes)" の後にhex.Dumpの出力を続け、
+// 省略した場合は "... 978 more bytes" を付ける
+func (t *tracer) trace(direction string, from, to *SockaddrBP, data []byte) {
+	shown := data[:min(len(data), t.limit)]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "[BpSocket] %s %s -> %s (%d bytes)\n", direction, traceAddr(from), traceAddr(to), len(data))
+	io.WriteString(t.w, hex.Dump(shown))
+	if omitted := len(data) - len(shown); omitted > 0 {
+		fmt.Fprintf(t.w, "... %d more bytes\n", omitted)
+	}
+}
+
+func traceAddr(addr *SockaddrBP) string {
+	if addr == nil {
+		return "?"
+	}
+	return addr.String()
+}
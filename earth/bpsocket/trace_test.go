@@ -0,0 +1,71 @@
+// trace_test.go - 送受信ペイロードのトレース出力のテスト
+package bpsocket
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTraceDumpFormat(t *testing.T) {
+	var out bytes.Buffer
+	tr := newTracer(&out, 64)
+	tr.trace("TX", NewSockaddrBP(150, 1), NewSockaddrBP(149, 1), []byte(`{"request_id":"r1"}`))
+
+	want := "[BpSocket] TX ipn:150.1 -> ipn:149.1 (19 bytes)\n" +
+		"00000000  7b 22 72 65 71 75 65 73  74 5f 69 64 22 3a 22 72  |{\"request_id\":\"r|\n" +
+		"00000010  31 22 7d                                          |1\"}|\n"
+	if out.String() != want {
+		t.Errorf("Unexpected dump:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestTraceTruncatesPayload(t *testing.T) {
+	var out bytes.Buffer
+	tr := newTracer(&out, 16)
+	tr.trace("RX", NewSockaddrBP(149, 1), NewSockaddrBP(150, 1), bytes.Repeat([]byte("a"), 100))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header, one dump line and a truncation note, got:\n%s", out.String())
+	}
+	if lines[0] != "[BpSocket] RX ipn:149.1 -> ipn:150.1 (100 bytes)" {
+		t.Errorf("Unexpected header: %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "00000000  61 61") || !strings.HasSuffix(lines[1], "|aaaaaaaaaaaaaaaa|") {
+		t.Errorf("Unexpected dump line: %q", lines[1])
+	}
+	if lines[2] != "... 84 more bytes" {
+		t.Errorf("Unexpected truncation note: %q", lines[2])
+	}
+}
+
+func TestTraceDefaultLimit(t *testing.T) {
+	if tr := newTracer(&bytes.Buffer{}, 0); tr.limit != DefaultTraceLimit {
+		t.Errorf("Expected limit %d, got %d", DefaultTraceLimit, tr.limit)
+	}
+}
+
+func TestTracerFromEnv(t *testing.T) {
+	t.Setenv(traceEnv, "")
+	if tracerFromEnv() != nil {
+		t.Error("Expected tracing to be disabled without " + traceEnv)
+	}
+	t.Setenv(traceEnv, "0")
+	if tracerFromEnv() != nil {
+		t.Error("Expected tracing to be disabled with " + traceEnv + "=0")
+	}
+
+	t.Setenv(traceEnv, "1")
+	t.Setenv(traceLimitEnv, "32")
+	tr := tracerFromEnv()
+	if tr == nil || tr.w != os.Stderr || tr.limit != 32 {
+		t.Fatalf("Expected a stderr tracer limited to 32 bytes, got %+v", tr)
+	}
+
+	t.Setenv(traceLimitEnv, "lots")
+	if tr := tracerFromEnv(); tr == nil || tr.limit != DefaultTraceLimit {
+		t.Errorf("Expected the default limit for an invalid value, got %+v", tr)
+	}
+}
@@ -0,0 +1,45 @@
+// transport.go - バンドル送受信の抽象（AF_BPソケットとプロセス内ループバックで共通）
+package bpsocket
+
+import "time"
+
+// Transport バンドルの送受信を行うトランスポート
+// 実装: BpSocket（AF_BPカーネルモジュール）、LoopbackTransport（プロセス内の疑似DTN）
+type Transport interface {
+	// SendTo 指定したアドレス（ipnまたはdtnスキーム）へバンドルを送信する
+	SendTo(data []byte, to *SockaddrBP) error
+
+	// Recv バンドルを1つ受信してbufに格納し、バンドルの実際のサイズと送信元アドレスを返す
+	// バンドルがbufに収まらない場合はlen(buf)バイトに切り詰め、len(buf)より大きいサイズを返す（MSG_TRUNCと同じ）
+	Recv(buf []byte) (int, *SockaddrBP, error)
+
+	// Close トランスポートをクローズする（ブロック中のRecvはErrClosedで戻る）
+	Close() error
+
+	// LocalAddr バインドしているローカルアドレスを返す
+	LocalAddr() *SockaddrBP
+}
+
+// readTimeoutSetter 受信タイムアウトを設定できるトランスポート
+type readTimeoutSetter interface {
+	SetReadTimeout(d time.Duration) error
+}
+
+// writeTimeoutSetter 送信タイムアウトを設定できるトランスポート
+type writeTimeoutSetter interface {
+	SetWriteTimeout(d time.Duration) error
+}
+
+// bundleOptionSetter バンドルの有効期間と優先度を設定できるトランスポート
+type bundleOptionSetter interface {
+	SetBundleLifetime(d time.Duration) error
+	SetPriority(p Priority) error
+}
+
+var (
+	_ bundleOptionSetter = (*BpSocket)(nil)
+	_ bundleOptionSetter = (*LoopbackTransport)(nil)
+
+	_ Transport = (*BpSocket)(nil)
+	_ Transport = (*LoopbackTransport)(nil)
+)
@@ -0,0 +1,89 @@
+// truncation_test.go - 受信バッファを超えるバンドルの検出のテスト
+package bpsocket
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOversizedBundleIsMarkedTruncated(t *testing.T) {
+	_, receiver, sendTransport := newCompressedLoopbackPair(t, CompressionNone, 0)
+
+	size := DefaultMaxBundleSize + 100
+	payload := append([]byte(`{"request_id":"big-1","body":"`), bytes.Repeat([]byte("x"), size)...)
+	payload = payload[:size]
+	if err := sendTransport.Send(payload, 149, 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	var bundle *Bundle
+	select {
+	case bundle = <-receiver.GetDataChannel():
+	case <-time.After(time.Second):
+		t.Fatal("Truncated bundle was not delivered")
+	}
+	defer bundle.Release()
+
+	if !bundle.Truncated || bundle.Size != size || len(bundle.Data) != DefaultMaxBundleSize {
+		t.Fatalf("Unexpected bundle: truncated=%v size=%d len=%d", bundle.Truncated, bundle.Size, len(bundle.Data))
+	}
+
+	var truncErr *TruncatedError
+	if !errors.As(bundle.Err(), &truncErr) {
+		t.Fatalf("Expected *TruncatedError, got %v", bundle.Err())
+	}
+	if truncErr.Size != size || truncErr.Limit != DefaultMaxBundleSize {
+		t.Errorf("Unexpected error details: %+v", truncErr)
+	}
+	if want := fmt.Sprint(size); !bytes.Contains([]byte(truncErr.Error()), []byte(want)) {
+		t.Errorf("Expected error message to mention the real size %s, got %q", want, truncErr.Error())
+	}
+	if id := ExtractRequestID(bundle.Data); id != "big-1" {
+		t.Errorf("Expected request_id from the truncated prefix, got %q", id)
+	}
+
+	select {
+	case ev := <-receiver.Events():
+		if ev.Type != EventTruncated || ev.Size != size {
+			t.Errorf("Unexpected event: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a Truncated event")
+	}
+}
+
+func TestBundleAtLimitIsNotTruncated(t *testing.T) {
+	_, receiver, sendTransport := newCompressedLoopbackPair(t, CompressionNone, 0)
+
+	payload := bytes.Repeat([]byte("x"), DefaultMaxBundleSize)
+	if err := sendTransport.Send(payload, 149, 1); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case bundle := <-receiver.GetDataChannel():
+		defer bundle.Release()
+		if bundle.Truncated || bundle.Err() != nil || len(bundle.Data) != DefaultMaxBundleSize {
+			t.Errorf("Expected complete bundle, got truncated=%v len=%d", bundle.Truncated, len(bundle.Data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Bundle was not delivered")
+	}
+}
+
+func TestExtractRequestID(t *testing.T) {
+	cases := map[string]string{
+		`{"request_id":"r1","url":"https://exa`:             "r1",
+		`{"url":"https://example.com","request_id":"r2"`:    "r2",
+		`{"headers":{"request_id":["no"]},"request_id":"r3`: "",
+		`not json`: "",
+	}
+	for in, want := range cases {
+		if got := ExtractRequestID([]byte(in)); got != want {
+			t.Errorf("ExtractRequestID(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
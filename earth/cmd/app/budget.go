@@ -0,0 +1,204 @@
+// budget.go - 送信バイト数の記録と日次リンクバジェット（24時間ローリングウィンドウ）の管理
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// budgetWindow 送信量を集計するローリングウィンドウの長さ
+const budgetWindow = 24 * time.Hour
+
+// budgetFlushInterval 送信記録を状態ファイルに書き出す間隔（送信のたびにファイル全体を書き直さない）
+// 異常終了した場合は、最後に書き出してからの記録が失われる
+const budgetFlushInterval = 10 * time.Second
+
+// budgetRecord 1回の送信記録
+type budgetRecord struct {
+	At    time.Time `json:"at"`
+	Bytes int64     `json:"bytes"`
+}
+
+// BudgetStatus リンクバジェットの現在の状態（ステータスエンドポイントで公開）
+type BudgetStatus struct {
+	LimitBytes     int64     `json:"limit_bytes"`
+	UsedBytes      int64     `json:"used_bytes"`
+	RemainingBytes int64     `json:"remaining_bytes"`
+	Exceeded       bool      `json:"exceeded"`
+	WindowStart    time.Time `json:"window_start"`
+}
+
+// LinkBudget BpSenderに渡した送信バイト数を24時間のローリングウィンドウで記録する
+// 上限を超えた場合、Depth > 0（プリフェッチ・再帰リンク）のレスポンスの送信を止める
+type LinkBudget struct {
+	mu        sync.Mutex
+	limit     int64 // 0の場合は無制限
+	statePath string
+	records   []budgetRecord
+	exceeded  bool
+	dirty     bool // 最後に書き出してから記録が増えたか
+	now       func() time.Time
+}
+
+// NewLinkBudget リンクバジェットを作成し、statePathに保存された記録があれば読み込む
+func NewLinkBudget(limit int64, statePath string) *LinkBudget {
+	b := &LinkBudget{
+		limit:     limit,
+		statePath: statePath,
+		now:       time.Now,
+	}
+	if err := b.load(); err != nil {
+		log.Printf("⚠️  Link budget state load error: %v", err)
+	}
+	return b
+}
+
+// Allow 指定したDepthのレスポンスを送信してよいか判定する
+// ユーザーが直接要求したレスポンス（Depth == 0）は上限を超えていても常に送信する
+func (b *LinkBudget) Allow(depth int) bool {
+	if depth == 0 || b.limit <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune()
+	return b.used() < b.limit
+}
+
+// Record 送信したバイト数を記録する（状態ファイルへはFlushで書き出す）
+func (b *LinkBudget) Record(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune()
+	b.records = append(b.records, budgetRecord{At: b.now(), Bytes: int64(n)})
+
+	if b.limit > 0 {
+		exceeded := b.used() >= b.limit
+		if exceeded && !b.exceeded {
+			log.Printf("⚠️  LINK BUDGET EXCEEDED: %d/%d bytes in the last %v, prefetch responses are suspended",
+				b.used(), b.limit, budgetWindow)
+		}
+		b.exceeded = exceeded
+	}
+	b.dirty = true
+}
+
+// Flush 最後に書き出してから増えた記録があれば、状態ファイルに書き出す
+func (b *LinkBudget) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.dirty {
+		return nil
+	}
+	b.prune()
+	if err := b.save(); err != nil {
+		return err
+	}
+	b.dirty = false
+	return nil
+}
+
+// FlushEvery ctxが終わるまでintervalごとにFlushし、終わるときにもう一度Flushする
+func (b *LinkBudget) FlushEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := b.Flush(); err != nil {
+				log.Printf("⚠️  Link budget state save error: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				log.Printf("⚠️  Link budget state save error: %v", err)
+			}
+		}
+	}
+}
+
+// Status 現在のリンクバジェットの状態を返す
+func (b *LinkBudget) Status() BudgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune()
+	used := b.used()
+	status := BudgetStatus{
+		LimitBytes:  b.limit,
+		UsedBytes:   used,
+		WindowStart: b.now().Add(-budgetWindow),
+	}
+	if b.limit > 0 {
+		status.RemainingBytes = max(b.limit-used, 0)
+		status.Exceeded = used >= b.limit
+	}
+	return status
+}
+
+// prune ウィンドウ外の記録を削除する（ロック取得済みで呼ぶこと）
+func (b *LinkBudget) prune() {
+	cutoff := b.now().Add(-budgetWindow)
+	i := 0
+	for i < len(b.records) && !b.records[i].At.After(cutoff) {
+		i++
+	}
+	b.records = b.records[i:]
+
+	if b.exceeded && b.used() < b.limit {
+		log.Printf("✅ Link budget recovered: %d/%d bytes in the last %v", b.used(), b.limit, budgetWindow)
+		b.exceeded = false
+	}
+}
+
+// used ウィンドウ内の合計送信バイト数（ロック取得済みで呼ぶこと）
+func (b *LinkBudget) used() int64 {
+	var total int64
+	for _, r := range b.records {
+		total += r.Bytes
+	}
+	return total
+}
+
+func (b *LinkBudget) load() error {
+	if b.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(b.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &b.records); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", b.statePath, err)
+	}
+	return nil
+}
+
+// save 記録をファイルに書き出す（ロック取得済みで呼ぶこと）
+func (b *LinkBudget) save() error {
+	if b.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(b.records)
+	if err != nil {
+		return err
+	}
+	// 書き込み途中で停止しても状態ファイルが壊れないよう一時ファイル経由で置き換える
+	tmpPath := b.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, b.statePath)
+}
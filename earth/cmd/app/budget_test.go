@@ -0,0 +1,211 @@
+// budget_test.go - リンクバジェットのテスト（偽の時計でウィンドウの経過を再現）
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"earth/bpsocket"
+)
+
+// fakeClock テスト用に手動で進める時計
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBudget(t *testing.T, limit int64, clock *fakeClock) *LinkBudget {
+	t.Helper()
+	b := NewLinkBudget(limit, filepath.Join(t.TempDir(), "budget.json"))
+	b.now = clock.Now
+	return b
+}
+
+func TestLinkBudgetBlocksPrefetchWhenExceeded(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)}
+	b := newTestBudget(t, 1000, clock)
+
+	b.Record(600)
+	if !b.Allow(1) {
+		t.Fatal("Expected prefetch to be allowed below the budget")
+	}
+
+	b.Record(400)
+	if b.Allow(1) {
+		t.Error("Expected prefetch to be blocked once the budget is exhausted")
+	}
+	if !b.Allow(0) {
+		t.Error("Expected Depth==0 responses to always be allowed")
+	}
+
+	status := b.Status()
+	if !status.Exceeded || status.UsedBytes != 1000 || status.RemainingBytes != 0 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
+
+func TestLinkBudgetWindowRollsOver(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)}
+	b := newTestBudget(t, 1000, clock)
+
+	b.Record(700)
+	clock.Advance(12 * time.Hour)
+	b.Record(300)
+	if b.Allow(2) {
+		t.Fatal("Expected prefetch to be blocked with 1000 bytes in the window")
+	}
+
+	// 最初の記録がウィンドウから外れる
+	clock.Advance(12*time.Hour + time.Second)
+	if !b.Allow(2) {
+		t.Error("Expected prefetch to be allowed after the first record left the window")
+	}
+	if used := b.Status().UsedBytes; used != 300 {
+		t.Errorf("Expected 300 bytes in the window, got %d", used)
+	}
+
+	clock.Advance(24 * time.Hour)
+	if used := b.Status().UsedBytes; used != 0 {
+		t.Errorf("Expected empty window, got %d bytes", used)
+	}
+}
+
+func TestLinkBudgetPersistsAcrossRestarts(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)}
+	path := filepath.Join(t.TempDir(), "budget.json")
+
+	b := NewLinkBudget(1000, path)
+	b.now = clock.Now
+	b.Record(900)
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	restarted := NewLinkBudget(1000, path)
+	restarted.now = clock.Now
+	if used := restarted.Status().UsedBytes; used != 900 {
+		t.Fatalf("Expected 900 bytes after restart, got %d", used)
+	}
+	restarted.Record(100)
+	if restarted.Allow(1) {
+		t.Error("Expected restored records to count towards the budget")
+	}
+}
+
+func TestLinkBudgetFlushesInBatches(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)}
+	path := filepath.Join(t.TempDir(), "budget.json")
+	b := NewLinkBudget(1000, path)
+	b.now = clock.Now
+
+	// 送信のたびには書き出さない
+	b.Record(100)
+	b.Record(200)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no state file before a flush, got %v", err)
+	}
+
+	// 終了するときに、まだ書き出していない記録を書き出す
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.FlushEvery(ctx, time.Hour)
+	}()
+	cancel()
+	<-done
+	var records []budgetRecord
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &records) != nil || len(records) != 2 {
+		t.Fatalf("Expected 2 records in the state file, got %q (%v)", data, err)
+	}
+
+	// 増えた記録がなければ書き直さない
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no write without new records, got %v", err)
+	}
+}
+
+func TestLinkBudgetUnlimited(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	b := newTestBudget(t, 0, clock)
+	b.Record(1 << 30)
+
+	if !b.Allow(2) {
+		t.Error("Expected unlimited budget to allow everything")
+	}
+	if b.Status().Exceeded {
+		t.Error("Unlimited budget must never report exceeded")
+	}
+}
+
+func TestStatusEndpointReportsBudget(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)}
+	b := newTestBudget(t, 100, clock)
+	b.Record(150)
+
+	rec := httptest.NewRecorder()
+	newStatusHandler(b, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("X-Link-Budget-Exceeded") != "true" {
+		t.Error("Expected X-Link-Budget-Exceeded flag")
+	}
+
+	var status StationStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if status.LinkBudget.UsedBytes != 150 || !status.LinkBudget.Exceeded {
+		t.Errorf("Unexpected budget status: %+v", status.LinkBudget)
+	}
+	if status.LinkState != "Unknown" {
+		t.Errorf("Expected Unknown link state without keepalive, got %q", status.LinkState)
+	}
+}
+
+func TestStatusEndpointReportsLinkStats(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)}
+	b := newTestBudget(t, 0, clock)
+
+	network := bpsocket.NewLoopbackNetwork()
+	transport, err := network.Listen(150, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	link := newStationLink(bpsocket.NewBpEndpointWithTransport(transport), "ipn:149.1")
+	defer link.Close()
+	if err := link.SendWithOptions(context.Background(), "hello", bpsocket.SendOptions{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	newStatusHandler(b, link).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var status StationStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if status.Bundles == nil || status.Bundles.Sent.BundlesSent != 1 {
+		t.Errorf("Expected 1 bundle sent in status, got %+v", status.Bundles)
+	}
+	if status.LinkState != "Unknown" {
+		t.Errorf("Expected Unknown link state without keepalive, got %q", status.LinkState)
+	}
+}
@@ -0,0 +1,272 @@
+// config.go - Earth局の設定（JSONファイルから読み込み、未指定項目はデフォルト値）
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"earth/bpsocket"
+)
+
+// Config Earth局全体の設定
+type Config struct {
+	Fetcher    FetcherConfig  `json:"fetcher"`
+	LinkBudget BudgetConfig   `json:"link_budget"`
+	Receiver   ReceiverConfig `json:"receiver"`
+	Auth       AuthConfig     `json:"auth"`
+	Keepalive  KeepaliveConf  `json:"keepalive"`
+	SendRetry  SendRetryConf  `json:"send_retry"`
+	StatusAddr string         `json:"status_addr"` // ステータスエンドポイントのアドレス（認証がないため、デフォルトはループバックのみ）
+
+	// MaxBundleSize 送受信できるバンドルの最大サイズ（コンバージェンスレイヤーの上限に合わせる、0の場合は4MB）
+	MaxBundleSize int `json:"max_bundle_size"`
+}
+
+// FetcherConfig 外部サイトへのHTTPリクエストに関する設定
+type FetcherConfig struct {
+	// UserAgent すべてのリクエストに付与するデフォルトのUser-Agent（"-"で送信しない）
+	UserAgent string `json:"user_agent"`
+
+	// ExtraHeaders すべてのリクエストに付与する追加ヘッダー（値が"-"のヘッダーは送信しない）
+	ExtraHeaders map[string]string `json:"extra_headers"`
+}
+
+// BudgetConfig 日次の送信量上限（コンタクトプランのデータ量）に関する設定
+type BudgetConfig struct {
+	// DailyLimitBytes 24時間あたりの送信量上限（0の場合は無制限）
+	DailyLimitBytes int64 `json:"daily_limit_bytes"`
+
+	// StatePath 送信記録の保存先（再起動後も集計を引き継ぐ）
+	StatePath string `json:"state_path"`
+}
+
+// ReceiverConfig BP Socket受信ループの障害対応に関する設定
+type ReceiverConfig struct {
+	// MaxConsecutiveErrors 受信エラーがこの回数連続したら受信ソケットを作り直す
+	MaxConsecutiveErrors int `json:"max_consecutive_errors"`
+
+	// MaxReconnects 受信ソケットの作成に連続で失敗できる回数（超えた場合はEarth局を停止する）
+	MaxReconnects int `json:"max_reconnects"`
+
+	// AllowedSources リクエストを受け付ける送信元EIDのパターン（"ipn:149.*"、ノード番号のみも可、"*"ですべて許可）
+	AllowedSources []string `json:"allowed_sources"`
+}
+
+// AuthConfig バンドルの認証と暗号化に関する設定
+type AuthConfig struct {
+	// HMACKey 送信するレスポンスに付与するMACの鍵（空の場合は署名しない）
+	// バックエンドのbp_gateway.auth.verify_keysに同じ鍵を設定すると、バックエンドが検証して改ざんされたレスポンスを捨てる
+	HMACKey string `json:"hmac_key"`
+
+	// VerifyKeys リクエストの検証に使う鍵（鍵のローテーション中は新旧両方を指定する、空の場合は検証しない）
+	VerifyKeys []string `json:"verify_keys"`
+
+	// RequireMAC MACのないリクエストを拒否する
+	// バックエンドはリクエストに署名しないため、バックエンドからリクエストを受け取る場合は有効にしない（すべてのリクエストを拒否する）
+	RequireMAC bool `json:"require_mac"`
+
+	// EncryptionKeys AES-256の鍵（16進数64文字）。先頭の鍵でレスポンスを暗号化し、すべての鍵でリクエストの復号を試す（空の場合は暗号化しない）
+	// バックエンドのbp_gateway.auth.encryption_keysに同じ鍵を設定してから有効にする（鍵を入れ替える間はバックエンドに新旧両方を設定する）
+	EncryptionKeys []string `json:"encryption_keys"`
+}
+
+// KeepaliveConf 宇宙ノードへのキープアライブに関する設定
+type KeepaliveConf struct {
+	// IntervalSeconds pingの送信間隔（0の場合はキープアライブを行わない）
+	IntervalSeconds int `json:"interval_seconds"`
+
+	// TimeoutSeconds pongを待つ時間（0の場合は送信間隔と同じ）
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// endpointOptions キープアライブを有効にするエンドポイントのオプション
+func (c KeepaliveConf) endpointOptions(peer string) []bpsocket.EndpointOption {
+	if c.IntervalSeconds <= 0 {
+		return nil
+	}
+	return []bpsocket.EndpointOption{bpsocket.WithKeepaliveTo(peer, bpsocket.KeepaliveConfig{
+		Interval: time.Duration(c.IntervalSeconds) * time.Second,
+		Timeout:  time.Duration(c.TimeoutSeconds) * time.Second,
+	})}
+}
+
+// SendRetryConf IONのSDRが一時的に満杯のとき（ENOBUFS/EAGAIN）にレスポンスの送信を再試行する設定
+type SendRetryConf struct {
+	// Disabled 再試行せずにすぐ送信失敗とする
+	Disabled bool `json:"disabled"`
+
+	// MaxElapsedMillis 再試行を諦めるまでの時間（0の場合はbpsocketのデフォルト値）
+	MaxElapsedMillis int `json:"max_elapsed_ms"`
+}
+
+// senderOptions レスポンスの送信に適用する再試行のオプション
+func (c SendRetryConf) senderOptions() []bpsocket.SenderOption {
+	if c.Disabled {
+		return []bpsocket.SenderOption{bpsocket.WithoutSendRetry()}
+	}
+	if c.MaxElapsedMillis <= 0 {
+		return nil
+	}
+	return []bpsocket.SenderOption{bpsocket.WithSendRetry(bpsocket.SendRetryPolicy{
+		MaxElapsed: time.Duration(c.MaxElapsedMillis) * time.Millisecond,
+	})}
+}
+
+// bundleSizeOptions 最大バンドルサイズを送信側・受信側の両方に適用するオプション（範囲外の値はエンドポイントの作成時にエラーになる）
+func (c Config) bundleSizeOptions() []bpsocket.EndpointOption {
+	if c.MaxBundleSize == 0 {
+		return nil
+	}
+	return []bpsocket.EndpointOption{
+		bpsocket.WithSenderOptions(bpsocket.WithMaxBundleSize(c.MaxBundleSize)),
+		bpsocket.WithReceiverOptions(bpsocket.WithMaxReceiveSize(c.MaxBundleSize)),
+	}
+}
+
+// suppressHeaderValue ヘッダーを送信しないことを示す値
+const suppressHeaderValue = "-"
+
+func defaultConfig() Config {
+	return Config{
+		Fetcher: FetcherConfig{
+			UserAgent:    "Mozilla/5.0 (compatible; ORF2025-EarthStation/1.0)",
+			ExtraHeaders: map[string]string{},
+		},
+		LinkBudget: BudgetConfig{
+			DailyLimitBytes: 0,
+			StatePath:       "link_budget.json",
+		},
+		Receiver: ReceiverConfig{
+			MaxConsecutiveErrors: 10,
+			MaxReconnects:        5,
+			AllowedSources:       []string{"ipn:149.*"},
+		},
+		StatusAddr: "127.0.0.1:9090",
+	}
+}
+
+// LoadConfig 設定を読み込む
+// 環境変数 EARTH_CONFIG_PATH が設定されている場合はそれを使用し、それ以外は earth_config.json を探す
+// ファイルが存在しない場合はデフォルト値を使用する
+func LoadConfig() Config {
+	conf := defaultConfig()
+
+	path := os.Getenv("EARTH_CONFIG_PATH")
+	if path == "" {
+		path = "earth_config.json"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return conf
+	}
+
+	var fileConf Config
+	if err := json.Unmarshal(data, &fileConf); err != nil {
+		fmt.Printf("Warning: Failed to parse config file %s: %v, using defaults\n", path, err)
+		return conf
+	}
+
+	return mergeConfig(conf, fileConf)
+}
+
+// mergeConfig ファイルから読み込んだ設定でデフォルト設定をマージ
+func mergeConfig(defaults, fileConf Config) Config {
+	merged := defaults
+
+	if fileConf.Fetcher.UserAgent != "" {
+		merged.Fetcher.UserAgent = fileConf.Fetcher.UserAgent
+	}
+	if len(fileConf.Fetcher.ExtraHeaders) > 0 {
+		merged.Fetcher.ExtraHeaders = fileConf.Fetcher.ExtraHeaders
+	}
+	if fileConf.LinkBudget.DailyLimitBytes != 0 {
+		merged.LinkBudget.DailyLimitBytes = fileConf.LinkBudget.DailyLimitBytes
+	}
+	if fileConf.LinkBudget.StatePath != "" {
+		merged.LinkBudget.StatePath = fileConf.LinkBudget.StatePath
+	}
+	if fileConf.Receiver.MaxConsecutiveErrors != 0 {
+		merged.Receiver.MaxConsecutiveErrors = fileConf.Receiver.MaxConsecutiveErrors
+	}
+	if fileConf.Receiver.MaxReconnects != 0 {
+		merged.Receiver.MaxReconnects = fileConf.Receiver.MaxReconnects
+	}
+	if len(fileConf.Receiver.AllowedSources) > 0 {
+		merged.Receiver.AllowedSources = fileConf.Receiver.AllowedSources
+	}
+	if fileConf.Auth.HMACKey != "" {
+		merged.Auth.HMACKey = fileConf.Auth.HMACKey
+	}
+	if len(fileConf.Auth.VerifyKeys) > 0 {
+		merged.Auth.VerifyKeys = fileConf.Auth.VerifyKeys
+	}
+	if fileConf.Auth.RequireMAC {
+		merged.Auth.RequireMAC = true
+	}
+	if len(fileConf.Auth.EncryptionKeys) > 0 {
+		merged.Auth.EncryptionKeys = fileConf.Auth.EncryptionKeys
+	}
+	if fileConf.Keepalive.IntervalSeconds != 0 {
+		merged.Keepalive.IntervalSeconds = fileConf.Keepalive.IntervalSeconds
+	}
+	if fileConf.Keepalive.TimeoutSeconds != 0 {
+		merged.Keepalive.TimeoutSeconds = fileConf.Keepalive.TimeoutSeconds
+	}
+	if fileConf.SendRetry.Disabled {
+		merged.SendRetry.Disabled = true
+	}
+	if fileConf.SendRetry.MaxElapsedMillis != 0 {
+		merged.SendRetry.MaxElapsedMillis = fileConf.SendRetry.MaxElapsedMillis
+	}
+	if fileConf.StatusAddr != "" {
+		merged.StatusAddr = fileConf.StatusAddr
+	}
+	if fileConf.MaxBundleSize != 0 {
+		merged.MaxBundleSize = fileConf.MaxBundleSize
+	}
+
+	return merged
+}
+
+// senderOptions レスポンスの送信に適用するオプション
+func (c AuthConfig) senderOptions() []bpsocket.SenderOption {
+	var opts []bpsocket.SenderOption
+	if c.HMACKey != "" {
+		opts = append(opts, bpsocket.WithHMACKey([]byte(c.HMACKey)))
+	}
+	if len(c.EncryptionKeys) > 0 {
+		// 不正な鍵は空の鍵として渡し、平文で送信せずにエラーにする
+		key, _ := hex.DecodeString(c.EncryptionKeys[0])
+		opts = append(opts, bpsocket.WithEncryptionKey(key))
+	}
+	return opts
+}
+
+// receiverOptions リクエストの受信に適用するオプション
+func (c AuthConfig) receiverOptions() []bpsocket.ReceiverOption {
+	var opts []bpsocket.ReceiverOption
+	if len(c.VerifyKeys) > 0 {
+		keys := make([][]byte, len(c.VerifyKeys))
+		for i, k := range c.VerifyKeys {
+			keys[i] = []byte(k)
+		}
+		opts = append(opts, bpsocket.WithHMACVerification(c.RequireMAC, keys...))
+	}
+	if len(c.EncryptionKeys) > 0 {
+		var keys [][]byte
+		for _, k := range c.EncryptionKeys {
+			key, err := hex.DecodeString(k)
+			if err != nil {
+				fmt.Printf("Warning: Ignoring malformed encryption key: %v\n", err)
+				continue
+			}
+			keys = append(keys, key)
+		}
+		opts = append(opts, bpsocket.WithDecryptionKeys(keys...))
+	}
+	return opts
+}
@@ -0,0 +1,58 @@
+// fetcher.go - 外部サイトへのHTTPリクエスト生成（ヘッダー付与ルール）
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// newFetchRequest CrawlRequestから外部サイトへのHTTPリクエストを生成する
+func newFetchRequest(ctx context.Context, conf FetcherConfig, reqInfo CrawlRequest) (*http.Request, error) {
+	method := reqInfo.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if len(reqInfo.Body) > 0 {
+		body = bytes.NewReader(reqInfo.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqInfo.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	applyOutboundHeaders(req, conf, reqInfo.Headers)
+	return req, nil
+}
+
+// applyOutboundHeaders 設定のヘッダーとリクエスト固有のヘッダーを合成してreqに設定する
+// 優先順位: リクエスト固有ヘッダー(DTNJsonRequest.Headers) > ExtraHeaders > UserAgent
+// 最終的な値が "-" のヘッダーは送信しない
+func applyOutboundHeaders(req *http.Request, conf FetcherConfig, reqHeaders map[string][]string) {
+	if conf.UserAgent != "" {
+		req.Header.Set("User-Agent", conf.UserAgent)
+	}
+	for key, value := range conf.ExtraHeaders {
+		req.Header.Set(key, value)
+	}
+	for key, values := range reqHeaders {
+		canonicalKey := http.CanonicalHeaderKey(key)
+		req.Header.Del(canonicalKey)
+		for _, value := range values {
+			req.Header.Add(canonicalKey, value)
+		}
+	}
+
+	for key, values := range req.Header {
+		if len(values) == 0 || values[0] != suppressHeaderValue {
+			continue
+		}
+		if key == "User-Agent" {
+			// 空文字を設定するとGoのデフォルトUser-Agentも送信されない
+			req.Header.Set(key, "")
+			continue
+		}
+		req.Header.Del(key)
+	}
+}
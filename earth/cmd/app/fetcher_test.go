@@ -0,0 +1,144 @@
+// fetcher_test.go - 外部サイトへのリクエストヘッダー付与のテスト
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fetchHeaders テストサーバーにリクエストを送り、サーバーが受信したヘッダーを返す
+func fetchHeaders(t *testing.T, conf FetcherConfig, reqHeaders map[string][]string) http.Header {
+	t.Helper()
+
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer server.Close()
+
+	req, err := newFetchRequest(context.Background(), conf, CrawlRequest{URL: server.URL, Headers: reqHeaders})
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	return <-received
+}
+
+func TestFetchHeadersDefaults(t *testing.T) {
+	conf := FetcherConfig{
+		UserAgent:    "EarthStation/1.0",
+		ExtraHeaders: map[string]string{"X-Institution": "orf-2025"},
+	}
+
+	got := fetchHeaders(t, conf, nil)
+
+	if ua := got.Get("User-Agent"); ua != "EarthStation/1.0" {
+		t.Errorf("Expected configured User-Agent, got %q", ua)
+	}
+	if v := got.Get("X-Institution"); v != "orf-2025" {
+		t.Errorf("Expected extra header X-Institution=orf-2025, got %q", v)
+	}
+}
+
+func TestFetchHeadersRequestPrecedence(t *testing.T) {
+	conf := FetcherConfig{
+		UserAgent:    "EarthStation/1.0",
+		ExtraHeaders: map[string]string{"X-Institution": "orf-2025", "Accept-Language": "en"},
+	}
+	reqHeaders := map[string][]string{
+		"user-agent":      {"Browser/2.0"},
+		"Accept-Language": {"ja", "en;q=0.5"},
+	}
+
+	got := fetchHeaders(t, conf, reqHeaders)
+
+	if ua := got.Get("User-Agent"); ua != "Browser/2.0" {
+		t.Errorf("Expected per-request User-Agent to win, got %q", ua)
+	}
+	if langs := got.Values("Accept-Language"); len(langs) != 2 || langs[0] != "ja" {
+		t.Errorf("Expected per-request Accept-Language values, got %v", langs)
+	}
+	if v := got.Get("X-Institution"); v != "orf-2025" {
+		t.Errorf("Expected extra header to be kept, got %q", v)
+	}
+}
+
+func TestFetchHeadersSuppression(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       FetcherConfig
+		reqHeaders map[string][]string
+		header     string
+	}{
+		{
+			name:   "config suppresses User-Agent",
+			conf:   FetcherConfig{UserAgent: "-"},
+			header: "User-Agent",
+		},
+		{
+			name:   "config suppresses extra header",
+			conf:   FetcherConfig{ExtraHeaders: map[string]string{"X-Debug": "-"}},
+			header: "X-Debug",
+		},
+		{
+			name:       "request suppresses configured header",
+			conf:       FetcherConfig{ExtraHeaders: map[string]string{"X-Institution": "orf-2025"}},
+			reqHeaders: map[string][]string{"X-Institution": {"-"}},
+			header:     "X-Institution",
+		},
+		{
+			name:       "request suppresses User-Agent",
+			conf:       FetcherConfig{UserAgent: "EarthStation/1.0"},
+			reqHeaders: map[string][]string{"User-Agent": {"-"}},
+			header:     "User-Agent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fetchHeaders(t, tt.conf, tt.reqHeaders)
+			if _, ok := got[tt.header]; ok {
+				t.Errorf("Expected %s to be suppressed, got %q", tt.header, got.Get(tt.header))
+			}
+		})
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	merged := mergeConfig(defaultConfig(), Config{
+		Fetcher: FetcherConfig{ExtraHeaders: map[string]string{"X-Institution": "orf-2025"}},
+	})
+
+	if merged.Fetcher.UserAgent != defaultConfig().Fetcher.UserAgent {
+		t.Errorf("Expected default User-Agent to be kept, got %q", merged.Fetcher.UserAgent)
+	}
+	if merged.Fetcher.ExtraHeaders["X-Institution"] != "orf-2025" {
+		t.Errorf("Expected extra headers from file, got %v", merged.Fetcher.ExtraHeaders)
+	}
+}
+
+func TestFetchRequestUsesMethodAndBody(t *testing.T) {
+	req, err := newFetchRequest(context.Background(), FetcherConfig{},
+		CrawlRequest{Method: http.MethodPost, URL: "https://example.com", Body: []byte("hello")})
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	if req.Method != http.MethodPost || req.ContentLength != 5 {
+		t.Errorf("Expected POST with a 5-byte body, got %s with %d bytes", req.Method, req.ContentLength)
+	}
+
+	req, err = newFetchRequest(context.Background(), FetcherConfig{}, CrawlRequest{URL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	if req.Method != http.MethodGet {
+		t.Errorf("Expected GET for recursive links, got %s", req.Method)
+	}
+}
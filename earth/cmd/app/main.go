@@ -3,45 +3,52 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"earth/bpsocket"
 )
 
-// DTNJsonRequest DTN経由で受信するリクエスト構造体
-type DTNJsonRequest struct {
-	RequestID string              `json:"request_id"`
-	Method    string              `json:"method"`
-	URL       string              `json:"url"`
-	Headers   map[string][]string `json:"headers"`
-	Body      string              `json:"body"` // Base64エンコード
-	Version   int                 `json:"version"`
-}
-
 // CrawlRequest 内部処理用のクロールリクエスト構造体
 type CrawlRequest struct {
 	RequestID string
+	Method    string // 空の場合はGET（再帰リンク）
 	URL       string
+	Headers   map[string][]string // リクエスト固有のヘッダー（再帰リンクの場合はnil）
+	Body      []byte              // リクエスト本文（デコード済み）
 	Depth     int
 }
 
 // BpResponse HTTPレスポンスに必要な情報を格納する構造体
+// JSONの形式はバックエンドのgateway.DTNJsonResponseと同じ（/testdata/dtn/response.jsonで両方のテストが確認する）
 type BpResponse struct {
+	Version       int                 `json:"version"`
 	RequestID     string              `json:"request_id"`
 	StatusCode    int                 `json:"status_code"`
 	Headers       map[string][]string `json:"headers"`
 	Body          string              `json:"body"` // Base64エンコード
 	ContentType   string              `json:"content_type,omitempty"`
 	ContentLength int64               `json:"content_length,omitempty"`
-	Depth         int                 `json:"-"` // 内部管理用 (JSONには含めない)
+	FinalURL      string              `json:"final_url,omitempty"` // リダイレクトをたどった後のURL
+	// ETag・LastModified 転送先のバリデーター（バックエンドがキャッシュを検証し直すときに使う）
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// SuggestedTTL 地上局が勧めるキャッシュの期間（秒、0は指定なし）
+	SuggestedTTL int64 `json:"suggested_ttl,omitempty"`
+	Depth        int   `json:"-"` // 内部管理用 (JSONには含めない)
+	Probe        bool  `json:"-"` // プローブへの応答（短い有効期間で送る）
 }
 
 // 共通リソース
@@ -55,99 +62,260 @@ var (
 func main() {
 	log.Println("=== Earth Station with BP Socket Gateway ===")
 
+	conf := LoadConfig()
+
+	// 日次リンクバジェット
+	budget := NewLinkBudget(conf.LinkBudget.DailyLimitBytes, conf.LinkBudget.StatePath)
+
+	// Ctrl+C・SIGTERMで終了する（リンクバジェットの記録は終了するまで一定間隔で書き出す）
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go budget.FlushEvery(ctx, budgetFlushInterval)
+
 	// BP Socket設定
 	const (
-		localNodeNum   = 150 // Earth node
-		localSvcNum    = 1   // Receive on ipn:150.1
-		sendFromSvcNum = 2   // Send from ipn:150.2
-		remoteNodeNum  = 149 // Space node
-		remoteSvcNum   = 1   // Send to ipn:149.1
+		localNodeNum = 150         // Earth node
+		localSvcNum  = 1           // Send/Receive on ipn:150.1
+		remoteEID    = "ipn:149.1" // Space node
 	)
 
-	// BP Socket Receiverの初期化
-	receiver, err := bpsocket.NewBpReceiver(localNodeNum, localSvcNum)
-	if err != nil {
-		log.Fatalf("Failed to create BP receiver: %v", err)
+	// BP Socket Endpointの初期化（送受信で1つのサービス番号を使う、障害時はsuperviseReceiverが作り直す）
+	newEndpoint := func() (*bpsocket.BpEndpoint, error) {
+		receiverOpts := append([]bpsocket.ReceiverOption{
+			bpsocket.WithMaxConsecutiveErrors(conf.Receiver.MaxConsecutiveErrors),
+			bpsocket.WithSourceAllowlist(conf.Receiver.AllowedSources...),
+		}, conf.Auth.receiverOptions()...)
+		endpointOpts := append([]bpsocket.EndpointOption{
+			bpsocket.WithSenderOptions(append(conf.Auth.senderOptions(), conf.SendRetry.senderOptions()...)...),
+			bpsocket.WithReceiverOptions(receiverOpts...),
+		}, conf.Keepalive.endpointOptions(remoteEID)...)
+		endpointOpts = append(endpointOpts, conf.bundleSizeOptions()...)
+		return bpsocket.NewBpEndpoint(localNodeNum, localSvcNum, endpointOpts...)
 	}
-	defer receiver.Close()
-
-	// BP Socket Senderの初期化
-	sender, err := bpsocket.NewBpSender(localNodeNum, sendFromSvcNum, remoteNodeNum, remoteSvcNum)
+	endpoint, err := newEndpoint()
 	if err != nil {
-		log.Fatalf("Failed to create BP sender: %v", err)
+		if hint := socketErrorHint(err); hint != "" {
+			log.Printf("💡 %s", hint)
+		}
+		log.Fatalf("Failed to create BP endpoint: %v", err)
 	}
-	defer sender.Close()
+	link := newStationLink(endpoint, remoteEID)
+	defer link.Close()
+
+	// ステータスエンドポイント（リンクバジェットとキープアライブのリンク状態）
+	startStatusServer(conf.StatusAddr, newStatusHandler(budget, link))
 
 	// パイプライン用チャネルの作成
 	urlChan := make(chan CrawlRequest, 100)
 	bpResChan := make(chan BpResponse, 100)
 	sendChan := make(chan BpResponse, 100)
 
-	var wg sync.WaitGroup
-
-	// 受信ループを開始
-	receiver.Start()
-
-	// --- 1. Recv Stage (BP Socketから連続受信) ---
-	wg.Add(1)
+	// --- 1. Recv Stage (BP Socketから連続受信、障害時は再接続) ---
+	recvDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		recvStageBpSocket(receiver.GetDataChannel(), urlChan)
+		defer close(recvDone)
+		if err := superviseReceiver(endpoint, link.reconnect(newEndpoint), urlChan, conf.Receiver.MaxReconnects); err != nil {
+			// 受信できない状態で動き続けても意味がないため、ソケットを閉じて停止する
+			log.Printf("❌ BP endpoint could not be recovered: %v", err)
+			link.Close()
+			if err := budget.Flush(); err != nil {
+				log.Printf("⚠️  Link budget state save error: %v", err)
+			}
+			os.Exit(1)
+		}
 	}()
 
 	// --- 2. Fetch Stage (HTTPリクエスト実行) ---
 	const fetchWorkers = 5
 	for i := 0; i < fetchWorkers; i++ {
-		wg.Add(1)
 		go func() {
-			defer wg.Done()
-			fetchWorkerBpSocket(urlChan, bpResChan)
+			fetchWorkerBpSocket(urlChan, bpResChan, conf.Fetcher)
 		}()
 	}
 
 	// --- 3. Save & Recurse Stage (再帰処理とsendChanへの転送) ---
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
 		saveAndRecurseWorkerBpSocket(bpResChan, urlChan, sendChan)
 	}()
 
 	// --- 4. Send Stage (BP Socketで送信) ---
 	const sendWorkers = 3
 	for i := 0; i < sendWorkers; i++ {
-		wg.Add(1)
 		go func(workerID int) {
-			defer wg.Done()
-			sendWorkerBpSocket(sendChan, sender, workerID)
+			sendWorkerBpSocket(sendChan, link, budget, workerID)
 		}(i)
 	}
 
 	log.Println("Earth Station is running with BP Socket... (Ctrl+C to exit)")
-	wg.Wait()
+
+	// Ctrl+C・SIGTERMで新しいバンドルの受信を停止し、受信済みのリクエストをパイプラインへ渡してから終了する
+	<-ctx.Done()
+
+	log.Println("🛑 Shutting down: draining buffered bundles...")
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+	defer cancel()
+	if err := link.Drain(drainCtx); err != nil {
+		log.Printf("⚠️  Drain incomplete: %v", err)
+	}
+	<-recvDone
+	// 終了を待つ間に送った分も状態ファイルに残す
+	if err := budget.Flush(); err != nil {
+		log.Printf("⚠️  Link budget state save error: %v", err)
+	}
+	log.Println("Earth Station stopped")
+}
+
+// shutdownDrainTimeout 終了時に受信済みのバンドルをパイプラインへ渡し終えるまで待つ時間
+const shutdownDrainTimeout = 10 * time.Second
+
+// socketErrorHint ソケットを作成できなかった原因に応じた対処方法を返す（原因が分類できない場合は空）
+func socketErrorHint(err error) string {
+	switch {
+	case errors.Is(err, bpsocket.ErrModuleNotLoaded):
+		return "The bp kernel module is not loaded (sudo insmod bp.ko) or ION is not running"
+	case errors.Is(err, bpsocket.ErrAddressInUse):
+		return "Another process is already bound to ipn:150.1; is another earth station running?"
+	case errors.Is(err, bpsocket.ErrInterrupted):
+		return "Socket creation was interrupted by a signal; restart the earth station"
+	}
+	return ""
 }
 
 // recvStageBpSocket: BP Socketから連続的にバンドルを受信してURLを抽出
-func recvStageBpSocket(dataChan <-chan []byte, urlChan chan<- CrawlRequest) {
-	for data := range dataChan {
-		log.Printf(">>> Recv Stage: Received bundle (%d bytes)", len(data))
+func recvStageBpSocket(dataChan <-chan *bpsocket.Bundle, urlChan chan<- CrawlRequest) {
+	for bundle := range dataChan {
+		log.Printf(">>> Recv Stage: Received bundle (%d bytes)", len(bundle.Data))
+
+		// パース結果はバッファを参照しないため、URLチャネルへ渡す前にバッファを返却する
+		var reqs []CrawlRequest
+		if err := bundle.Err(); err != nil {
+			reqs = []CrawlRequest{bundleErrorRequest(bundle.Data, err)}
+		} else {
+			reqs = parseCrawlRequests(bundle.Data)
+		}
+		bundle.Release()
 
-		// JSONをパース
-		targetURL, reqID, err := bpsocket.ParseDTNRequest(data)
-		if err != nil {
-			log.Printf("⚠️  Parse error: %v", err)
-			// エラーレスポンスを生成
-			errorURL := fmt.Sprintf("error://invalid-request/%s", url.QueryEscape(err.Error()))
-			urlChan <- CrawlRequest{RequestID: reqID, URL: errorURL, Depth: 0}
-			continue
+		for _, req := range reqs {
+			urlChan <- req
+		}
+	}
+}
+
+// parseCrawlRequests バンドルペイロードからクロールリクエストを作成
+// バックエンドが複数のリクエストを1つのバンドル（request_batch）にまとめた場合は、リクエストごとに分ける
+func parseCrawlRequests(data []byte) []CrawlRequest {
+	parts, batch, err := bpsocket.SplitRequestBatch(data)
+	if !batch {
+		return []CrawlRequest{parseCrawlRequest(data)}
+	}
+	if err != nil {
+		return []CrawlRequest{bundleErrorRequest(data, err)}
+	}
+
+	log.Printf(">>> Recv Stage: Unpacking request batch (%d requests)", len(parts))
+	reqs := make([]CrawlRequest, len(parts))
+	for i, part := range parts {
+		reqs[i] = parseCrawlRequest(part)
+	}
+	return reqs
+}
+
+// bundleErrorRequest 正しく受信できなかったバンドルに対するエラー応答用のリクエストを作成
+// 切り詰められたバンドルは実際のサイズをエラーURLに含め、送信側が分割送信に切り替えられるようにする
+func bundleErrorRequest(data []byte, err error) CrawlRequest {
+	reqID := bpsocket.ExtractRequestID(data)
+	log.Printf("⚠️  Bundle error (ID: %q): %v", reqID, err)
+
+	var truncErr *bpsocket.TruncatedError
+	if errors.As(err, &truncErr) {
+		errorURL := fmt.Sprintf("error://bundle-too-large/%d?limit=%d", truncErr.Size, truncErr.Limit)
+		return CrawlRequest{RequestID: reqID, URL: errorURL, Depth: 0}
+	}
+
+	errorURL := fmt.Sprintf("error://invalid-request/%s", url.QueryEscape(err.Error()))
+	return CrawlRequest{RequestID: reqID, URL: errorURL, Depth: 0}
+}
+
+// parseCrawlRequest バンドルペイロードからクロールリクエストを作成（不正なリクエストはエラーURLに変換）
+func parseCrawlRequest(data []byte) CrawlRequest {
+	// JSONをパース
+	dtnReq, err := bpsocket.ParseDTNRequestFull(data)
+	if err != nil {
+		log.Printf("⚠️  Parse error: %v", err)
+		// エラーレスポンスを生成（request_idが読めた場合は応答に含める）
+		var reqID string
+		if dtnReq != nil {
+			reqID = dtnReq.RequestID
 		}
+		errorURL := fmt.Sprintf("error://invalid-request/%s", url.QueryEscape(err.Error()))
+		return CrawlRequest{RequestID: reqID, URL: errorURL, Depth: 0}
+	}
+
+	log.Printf("🔄 NEW REQUEST: %s %s (ID: %s)", dtnReq.Method, dtnReq.URL, dtnReq.RequestID)
+	return CrawlRequest{
+		RequestID: dtnReq.RequestID,
+		Method:    dtnReq.Method,
+		URL:       dtnReq.URL,
+		Headers:   dtnReq.Headers, // 外部サイトへのリクエストで設定のヘッダーより優先される
+		Body:      dtnReq.DecodedBody,
+		Depth:     0,
+	}
+}
 
-		log.Printf("🔄 NEW REQUEST: %s (ID: %s)", targetURL, reqID)
-		urlChan <- CrawlRequest{RequestID: reqID, URL: targetURL, Depth: 0}
+// errorResponse エラーURL（error://<種別>/<詳細>）に対応するエラーレスポンスを作成
+func errorResponse(reqID, errorURL string) BpResponse {
+	status := http.StatusBadRequest
+	message := "Error: Invalid or incomplete HTTP request"
+	headers := map[string][]string{"Content-Type": {"text/plain"}}
+
+	if u, err := url.Parse(errorURL); err == nil && u.Host == "bundle-too-large" {
+		size := strings.TrimPrefix(u.Path, "/")
+		status = http.StatusRequestEntityTooLarge
+		message = fmt.Sprintf("Error: Request bundle too large (%s bytes, limit %s bytes); resend using fragmentation",
+			size, u.Query().Get("limit"))
+		headers["X-Bundle-Size"] = []string{size}
+	}
+	if u, err := url.Parse(errorURL); err == nil && u.Host == "response-too-large" {
+		size := strings.TrimPrefix(u.Path, "/")
+		status = http.StatusBadGateway
+		message = fmt.Sprintf("Error: Response too large for the DTN link (%s bytes, limit %s bytes)",
+			size, u.Query().Get("limit"))
+		headers["X-Bundle-Size"] = []string{size}
+	}
+
+	return BpResponse{
+		Version:       bpsocket.DTNProtocolVersion,
+		RequestID:     reqID,
+		StatusCode:    status,
+		Headers:       headers,
+		Body:          base64.StdEncoding.EncodeToString([]byte(message)),
+		ContentType:   "text/plain",
+		ContentLength: int64(len(message)),
+		Depth:         0,
+	}
+}
+
+// probeURL バックエンドがDTNのリンクを確かめるために送るプローブのURL（バックエンドのgateway.probeURLと同じ）
+// サイトを取得せずにすぐ200で応答する
+const probeURL = "status://earth"
+
+// probeResponse プローブへの応答を作成
+func probeResponse(reqID string) BpResponse {
+	const message = "ok"
+	return BpResponse{
+		Version:       bpsocket.DTNProtocolVersion,
+		RequestID:     reqID,
+		StatusCode:    http.StatusOK,
+		Headers:       map[string][]string{"Content-Type": {"text/plain"}, "X-Original-URL": {probeURL}},
+		Body:          base64.StdEncoding.EncodeToString([]byte(message)),
+		ContentType:   "text/plain",
+		ContentLength: int64(len(message)),
+		Probe:         true,
 	}
 }
 
 // fetchWorkerBpSocket: HTTPリクエストを実行
-func fetchWorkerBpSocket(urlChan <-chan CrawlRequest, bpResChan chan<- BpResponse) {
+func fetchWorkerBpSocket(urlChan <-chan CrawlRequest, bpResChan chan<- BpResponse, fetcherConf FetcherConfig) {
 	client := http.Client{Timeout: 30 * time.Second}
 
 	for reqInfo := range urlChan {
@@ -157,17 +325,16 @@ func fetchWorkerBpSocket(urlChan <-chan CrawlRequest, bpResChan chan<- BpRespons
 
 		// エラーURLの検出
 		if strings.HasPrefix(targetURL, "error://") {
-			errRes := BpResponse{
-				RequestID:     reqID,
-				StatusCode:    400,
-				Headers:       map[string][]string{"Content-Type": {"text/plain"}},
-				Body:          base64.StdEncoding.EncodeToString([]byte("Error: Invalid or incomplete HTTP request")),
-				ContentType:   "text/plain",
-				ContentLength: int64(len("Error: Invalid or incomplete HTTP request")),
-				Depth:         0,
-			}
+			errRes := errorResponse(reqID, targetURL)
 			bpResChan <- errRes
-			log.Printf("❌ Sent 400 Bad Request for: %s", targetURL)
+			log.Printf("❌ Sent %d %s for: %s", errRes.StatusCode, http.StatusText(errRes.StatusCode), targetURL)
+			continue
+		}
+
+		// プローブは取得せずにすぐ応答する（再訪問チェックの前に処理し、何度でも応答する）
+		if targetURL == probeURL {
+			bpResChan <- probeResponse(reqID)
+			log.Printf("🩺 Answered probe (ID: %s)", reqID)
 			continue
 		}
 
@@ -183,7 +350,7 @@ func fetchWorkerBpSocket(urlChan <-chan CrawlRequest, bpResChan chan<- BpRespons
 		log.Printf("🕸️  Fetching: %s", targetURL)
 
 		// HTTPリクエストの実行
-		req, err := http.NewRequestWithContext(context.Background(), "GET", targetURL, nil)
+		req, err := newFetchRequest(context.Background(), fetcherConf, reqInfo)
 		if err != nil {
 			log.Printf("⚠️  Request creation error (%s): %v", targetURL, err)
 			continue
@@ -203,12 +370,16 @@ func fetchWorkerBpSocket(urlChan <-chan CrawlRequest, bpResChan chan<- BpRespons
 		}
 
 		bpRes := BpResponse{
+			Version:       bpsocket.DTNProtocolVersion,
 			RequestID:     reqID,
 			StatusCode:    resp.StatusCode,
 			Headers:       resp.Header,
 			Body:          base64.StdEncoding.EncodeToString(bodyBytes),
 			ContentType:   resp.Header.Get("Content-Type"),
 			ContentLength: resp.ContentLength,
+			FinalURL:      resp.Request.URL.String(),
+			ETag:          resp.Header.Get("ETag"),
+			LastModified:  resp.Header.Get("Last-Modified"),
 			Depth:         depth,
 		}
 		bpRes.Headers["X-Original-URL"] = []string{targetURL}
@@ -251,18 +422,68 @@ func saveAndRecurseWorkerBpSocket(bpResChan <-chan BpResponse, urlChan chan<- Cr
 	close(sendChan)
 }
 
+// レスポンスのバンドル有効期間
+// 対話的な応答は利用者が待っている間に届かなければ意味がないため早めに破棄し、プリフェッチは長く保持する
+// プローブの応答は期限内に届かなければリンクの確認に使えないため、さらに短くする
+const (
+	interactiveBundleLifetime = time.Hour
+	prefetchBundleLifetime    = 72 * time.Hour
+	probeBundleLifetime       = time.Minute
+)
+
+// sendOptionsForDepth レスポンスの深さに応じたバンドルの優先度と有効期間を返す
+func sendOptionsForDepth(depth int) bpsocket.SendOptions {
+	if depth == 0 {
+		return bpsocket.SendOptions{Lifetime: interactiveBundleLifetime, Priority: bpsocket.PriorityExpedited}
+	}
+	return bpsocket.SendOptions{Lifetime: prefetchBundleLifetime, Priority: bpsocket.PriorityBulk}
+}
+
+// sendOptionsForResponse レスポンスのバンドルの優先度と有効期間を返す（プローブの応答は短い有効期間で急ぎ送る）
+func sendOptionsForResponse(bpRes BpResponse) bpsocket.SendOptions {
+	if bpRes.Probe {
+		return bpsocket.SendOptions{Lifetime: probeBundleLifetime, Priority: bpsocket.PriorityExpedited}
+	}
+	return sendOptionsForDepth(bpRes.Depth)
+}
+
 // sendWorkerBpSocket: BP Socketでレスポンスを送信
-func sendWorkerBpSocket(bpResChan <-chan BpResponse, sender *bpsocket.BpSender, workerID int) {
+func sendWorkerBpSocket(bpResChan <-chan BpResponse, sender responseSender, budget *LinkBudget, workerID int) {
 	for bpRes := range bpResChan {
+		// リンクバジェット超過時はプリフェッチ・再帰リンクのレスポンスを送信しない
+		if !budget.Allow(bpRes.Depth) {
+			log.Printf("⏸️  [Worker %d] Link budget exceeded, skipping prefetch response (ID: %s, Depth: %d)",
+				workerID, bpRes.RequestID, bpRes.Depth)
+			continue
+		}
+
 		log.Printf("🚀 [Worker %d] Sending response (ID: %s, Status: %d)", workerID, bpRes.RequestID, bpRes.StatusCode)
 
+		// 送信バイト数を記録するため、ここでJSONに変換してからSenderに渡す
+		payload, err := json.Marshal(bpRes)
+		if err != nil {
+			log.Printf("❌ [Worker %d] JSON marshal error: %v", workerID, err)
+			continue
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		err := sender.Send(ctx, bpRes)
+		err = sender.SendWithOptions(ctx, json.RawMessage(payload), sendOptionsForResponse(bpRes))
+		if errors.Is(err, bpsocket.ErrBundleTooLarge) {
+			// リンクの最大バンドルサイズを超えるレスポンスは届けられないため、代わりにエラーを返す
+			log.Printf("⚠️  [Worker %d] Response of %d bytes exceeds max bundle size %d (ID: %s)",
+				workerID, len(payload), sender.MaxBundleSize(), bpRes.RequestID)
+			errorURL := fmt.Sprintf("error://response-too-large/%d?limit=%d", len(payload), sender.MaxBundleSize())
+			payload, err = json.Marshal(errorResponse(bpRes.RequestID, errorURL))
+			if err == nil {
+				err = sender.SendWithOptions(ctx, json.RawMessage(payload), sendOptionsForDepth(bpRes.Depth))
+			}
+		}
 		cancel()
 
 		if err != nil {
 			log.Printf("❌ [Worker %d] Send error: %v", workerID, err)
 		} else {
+			budget.Record(len(payload))
 			log.Printf("✅ [Worker %d] Response sent successfully (ID: %s)", workerID, bpRes.RequestID)
 		}
 	}
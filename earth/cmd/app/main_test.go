@@ -0,0 +1,249 @@
+// main_test.go - 受信バンドルからクロールリクエストへの変換のテスト
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"earth/bpsocket"
+)
+
+func TestTruncatedBundleProducesTooLargeResponse(t *testing.T) {
+	prefix := []byte(`{"request_id":"big-1","url":"https://example.com","body":"AAAA`)
+	req := bundleErrorRequest(prefix, &bpsocket.TruncatedError{Size: 5000000, Limit: 4194304})
+
+	if req.RequestID != "big-1" {
+		t.Errorf("Expected request ID from the truncated prefix, got %q", req.RequestID)
+	}
+
+	res := errorResponse(req.RequestID, req.URL)
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d", res.StatusCode)
+	}
+	if got := res.Headers["X-Bundle-Size"]; len(got) != 1 || got[0] != "5000000" {
+		t.Errorf("Expected X-Bundle-Size header, got %v", got)
+	}
+	body, _ := base64.StdEncoding.DecodeString(res.Body)
+	if !strings.Contains(string(body), "5000000") || !strings.Contains(string(body), "4194304") {
+		t.Errorf("Expected body to mention the size and limit, got %q", body)
+	}
+}
+
+// limitedSender 最大バンドルサイズを超えるペイロードを拒否するresponseSender
+type limitedSender struct {
+	limit int
+	sent  []BpResponse
+}
+
+func (s *limitedSender) SendWithOptions(ctx context.Context, data interface{}, opts bpsocket.SendOptions) error {
+	payload := data.(json.RawMessage)
+	if len(payload) > s.limit {
+		return fmt.Errorf("%w: %d bytes", bpsocket.ErrBundleTooLarge, len(payload))
+	}
+	var res BpResponse
+	if err := json.Unmarshal(payload, &res); err != nil {
+		return err
+	}
+	s.sent = append(s.sent, res)
+	return nil
+}
+
+func (s *limitedSender) MaxBundleSize() int { return s.limit }
+
+func TestOversizedResponseIsReplacedWithError(t *testing.T) {
+	sender := &limitedSender{limit: 4096}
+	budget := newTestBudget(t, 0, &fakeClock{t: time.Now()})
+
+	resChan := make(chan BpResponse, 2)
+	resChan <- BpResponse{RequestID: "small", StatusCode: http.StatusOK, Body: "aGVsbG8="}
+	resChan <- BpResponse{RequestID: "big", StatusCode: http.StatusOK, Body: strings.Repeat("A", 8192)}
+	close(resChan)
+	sendWorkerBpSocket(resChan, sender, budget, 1)
+
+	if len(sender.sent) != 2 {
+		t.Fatalf("Expected 2 responses to be sent, got %d", len(sender.sent))
+	}
+	if res := sender.sent[0]; res.RequestID != "small" || res.StatusCode != http.StatusOK {
+		t.Errorf("Expected the small response unchanged, got %+v", res)
+	}
+	res := sender.sent[1]
+	if res.RequestID != "big" || res.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Expected a 502 for the oversized response, got %d (ID: %s)", res.StatusCode, res.RequestID)
+	}
+	body, _ := base64.StdEncoding.DecodeString(res.Body)
+	if !strings.Contains(string(body), "limit 4096") {
+		t.Errorf("Expected body to mention the limit, got %q", body)
+	}
+}
+
+func TestInvalidRequestProducesBadRequest(t *testing.T) {
+	req := parseCrawlRequest([]byte(`{"request_id":"r1"}`))
+	if !strings.HasPrefix(req.URL, "error://invalid-request/") {
+		t.Fatalf("Expected an error URL, got %q", req.URL)
+	}
+	if req.RequestID != "r1" {
+		t.Errorf("Expected the request ID to be kept for the error response, got %q", req.RequestID)
+	}
+
+	if res := errorResponse(req.RequestID, req.URL); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", res.StatusCode)
+	}
+}
+
+func TestParseCrawlRequestCarriesMethodAndBody(t *testing.T) {
+	req := parseCrawlRequest([]byte(`{"request_id":"r1","method":"POST","url":"https://example.com","body":"aGVsbG8="}`))
+	if req.Method != "POST" || string(req.Body) != "hello" {
+		t.Errorf("Expected method and decoded body to be carried over, got %+v", req)
+	}
+}
+
+func TestRequestBatchIsUnpacked(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", "dtn", "request_batch.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	// 受信ステージはペイロードのバッファを返却してからリクエストを渡すため、分けたリクエストはバッファを参照しない
+	reqs := parseCrawlRequests(data)
+	clear(data)
+
+	if len(reqs) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(reqs))
+	}
+	for i, want := range []CrawlRequest{
+		{RequestID: "6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b", Method: "GET", URL: "https://example.com/page"},
+		{RequestID: "0b7e4d1a-3c5f-4a2b-8e9d-7f6a5b4c3d2e", Method: "GET", URL: "https://example.com/style.css"},
+	} {
+		if reqs[i].RequestID != want.RequestID || reqs[i].Method != want.Method || reqs[i].URL != want.URL {
+			t.Errorf("request %d: expected %+v, got %+v", i, want, reqs[i])
+		}
+	}
+	if got := reqs[0].Headers["Accept-Language"]; len(got) != 1 || got[0] != "ja" {
+		t.Errorf("Expected the headers to be kept, got %v", reqs[0].Headers)
+	}
+
+	// まとめたリクエストのうち不正なものだけをエラーにする
+	reqs = parseCrawlRequests([]byte(`{"version":1,"type":"request_batch","requests":[{"request_id":"r1","url":"https://example.com"},{"request_id":"r2"}]}`))
+	if len(reqs) != 2 || reqs[0].URL != "https://example.com" || reqs[1].RequestID != "r2" || !strings.HasPrefix(reqs[1].URL, "error://invalid-request/") {
+		t.Errorf("Expected only the invalid request to fail, got %+v", reqs)
+	}
+
+	// まとめていないリクエストは1つのリクエストのまま
+	if reqs := parseCrawlRequests([]byte(`{"request_id":"r1","url":"https://example.com"}`)); len(reqs) != 1 || reqs[0].URL != "https://example.com" {
+		t.Errorf("Expected a single request, got %+v", reqs)
+	}
+}
+
+// TestOriginalURLArrivesIntact バックエンドはクライアントが求めたURLのまま送る（testdata/dtn/request_https.json、バックエンドのテストでも確認する）
+// 地上局はスキーム・ホスト・クエリを変えずに取得する
+func TestOriginalURLArrivesIntact(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", "dtn", "request_https.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	req := parseCrawlRequest(data)
+	if req.URL != "https://example.com/a?b=c" || req.RequestID != "3c1d9e7a-5b2f-4c8d-a6e1-9f0b2c3d4e5f" {
+		t.Errorf("Expected the original URL, got %q (ID: %q)", req.URL, req.RequestID)
+	}
+}
+
+func TestSendOptionsForDepth(t *testing.T) {
+	interactive := sendOptionsForDepth(0)
+	if interactive.Priority != bpsocket.PriorityExpedited || interactive.Lifetime != interactiveBundleLifetime {
+		t.Errorf("Unexpected options for interactive response: %+v", interactive)
+	}
+
+	prefetch := sendOptionsForDepth(2)
+	if prefetch.Priority != bpsocket.PriorityBulk || prefetch.Lifetime != prefetchBundleLifetime {
+		t.Errorf("Unexpected options for prefetch response: %+v", prefetch)
+	}
+}
+
+func TestProbeIsAnsweredWithoutFetching(t *testing.T) {
+	urlChan := make(chan CrawlRequest, 2)
+	resChan := make(chan BpResponse, 2)
+	// 再訪問チェックで2回目のプローブを落とさない
+	urlChan <- CrawlRequest{RequestID: "probe-1", URL: probeURL}
+	urlChan <- CrawlRequest{RequestID: "probe-2", URL: probeURL}
+	close(urlChan)
+	fetchWorkerBpSocket(urlChan, resChan, FetcherConfig{})
+
+	for _, id := range []string{"probe-1", "probe-2"} {
+		res := <-resChan
+		if res.RequestID != id || res.StatusCode != http.StatusOK || res.Headers["X-Original-URL"][0] != probeURL {
+			t.Errorf("Unexpected probe response %+v", res)
+		}
+		opts := sendOptionsForResponse(res)
+		if opts.Lifetime != probeBundleLifetime || opts.Priority != bpsocket.PriorityExpedited {
+			t.Errorf("Unexpected options for a probe response: %+v", opts)
+		}
+	}
+}
+
+func TestSocketErrorHint(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("failed to create BP socket: %w", bpsocket.ErrModuleNotLoaded), "kernel module"},
+		{fmt.Errorf("bind failed ipn:150.1: %w", bpsocket.ErrAddressInUse), "already bound"},
+		{bpsocket.ErrInterrupted, "interrupted"},
+		{errors.New("permission denied"), ""},
+	}
+	for _, tc := range cases {
+		hint := socketErrorHint(tc.err)
+		if tc.want == "" && hint != "" || !strings.Contains(hint, tc.want) {
+			t.Errorf("socketErrorHint(%v) = %q, expected it to mention %q", tc.err, hint, tc.want)
+		}
+	}
+}
+
+// TestBpResponseContract 地上局が送るレスポンスの形式（リポジトリ直下のtestdata/dtn/response.json、バックエンドのテストでも確認する）
+func TestBpResponseContract(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("..", "..", "..", "testdata", "dtn", "response.json"))
+	if err != nil {
+		t.Fatalf("failed to read the shared protocol file: %v", err)
+	}
+	got, err := json.Marshal(BpResponse{
+		Version:    bpsocket.DTNProtocolVersion,
+		RequestID:  "6f9a1c2e-8b4d-4e3f-9a7b-1c2d3e4f5a6b",
+		StatusCode: http.StatusOK,
+		Headers: map[string][]string{
+			"Content-Type":   {"text/html; charset=utf-8"},
+			"Etag":           {`"v1"`},
+			"Last-Modified":  {"Mon, 12 Oct 2026 09:00:00 GMT"},
+			"X-Original-URL": {"https://example.com/page"},
+		},
+		Body:          base64.StdEncoding.EncodeToString([]byte("<h1>hi</h1>")),
+		ContentType:   "text/html; charset=utf-8",
+		ContentLength: 11,
+		FinalURL:      "https://example.com/page",
+		ETag:          `"v1"`,
+		LastModified:  "Mon, 12 Oct 2026 09:00:00 GMT",
+		SuggestedTTL:  3600,
+		Depth:         1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("response bundle drifted from testdata/dtn/response.json:\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+// status.go - 運用者向けのステータスエンドポイント（HTTP/JSON）
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"earth/bpsocket"
+)
+
+// StationStatus /status で返すEarth局の状態
+type StationStatus struct {
+	LinkBudget BudgetStatus `json:"link_budget"`
+	LinkState  string       `json:"link_state"` // キープアライブで判定した宇宙ノードとのリンク状態
+
+	// Bundles 現在のエンドポイントの送受信統計（受信ソケットを作り直すとリセットされる）
+	Bundles *bpsocket.EndpointStats `json:"bundles,omitempty"`
+}
+
+// linkStatusSource リンク状態と送受信統計を返すもの（stationLink）
+type linkStatusSource interface {
+	LinkState() bpsocket.LinkState
+	Stats() bpsocket.EndpointStats
+}
+
+// newStatusHandler ステータスエンドポイントのハンドラーを作成する（linkがnilの場合はリンク状態をUnknownとする）
+func newStatusHandler(budget *LinkBudget, link linkStatusSource) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := StationStatus{
+			LinkBudget: budget.Status(),
+			LinkState:  bpsocket.LinkUnknown.String(),
+		}
+		if link != nil {
+			stats := link.Stats()
+			status.LinkState = link.LinkState().String()
+			status.Bundles = &stats
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status.LinkBudget.Exceeded {
+			w.Header().Set("X-Link-Budget-Exceeded", "true")
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("⚠️  Status encode error: %v", err)
+		}
+	})
+	return mux
+}
+
+// startStatusServer ステータスエンドポイントをバックグラウンドで起動する（addrが空の場合は起動しない）
+func startStatusServer(addr string, handler http.Handler) {
+	if addr == "" {
+		return
+	}
+	go func() {
+		log.Printf("📊 Status endpoint listening on %s/status", addr)
+		if err := http.ListenAndServe(addr, handler); err != nil {
+			log.Printf("⚠️  Status server error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,149 @@
+// supervisor.go - BP Socket受信ループの監視（ソケット障害時の再接続）
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"earth/bpsocket"
+)
+
+// reconnectDelay 受信ソケットを作り直すまでの待ち時間
+var reconnectDelay = 5 * time.Second
+
+// bundleSource 受信ループを持つもの（BpReceiverまたはBpEndpoint）
+type bundleSource interface {
+	Start()
+	GetDataChannel() <-chan *bpsocket.Bundle
+	Events() <-chan bpsocket.Event
+	Close() error
+}
+
+// receiverFactory 受信ソケットを作成する関数（テストではループバックに差し替える）
+type receiverFactory func() (bundleSource, error)
+
+// responseSender レスポンスバンドルの送信先
+type responseSender interface {
+	SendWithOptions(ctx context.Context, data interface{}, opts bpsocket.SendOptions) error
+	MaxBundleSize() int
+}
+
+// stationLink 宇宙ノードとの送受信に使うBpEndpoint（受信ループの再接続で差し替わる）
+type stationLink struct {
+	endpoint  atomic.Pointer[bpsocket.BpEndpoint]
+	remoteEID string
+}
+
+func newStationLink(endpoint *bpsocket.BpEndpoint, remoteEID string) *stationLink {
+	l := &stationLink{remoteEID: remoteEID}
+	l.endpoint.Store(endpoint)
+	return l
+}
+
+// SendWithOptions 現在のエンドポイントから宇宙ノードへ送信する
+func (l *stationLink) SendWithOptions(ctx context.Context, data interface{}, opts bpsocket.SendOptions) error {
+	return l.endpoint.Load().SendWithOptions(ctx, l.remoteEID, data, opts)
+}
+
+// reconnect エンドポイントを作り直し、以降の送信で新しいエンドポイントを使うようにするファクトリを返す
+func (l *stationLink) reconnect(newEndpoint func() (*bpsocket.BpEndpoint, error)) receiverFactory {
+	return func() (bundleSource, error) {
+		endpoint, err := newEndpoint()
+		if err != nil {
+			return nil, err
+		}
+		l.endpoint.Store(endpoint)
+		return endpoint, nil
+	}
+}
+
+// MaxBundleSize 現在のエンドポイントが送信できるバンドルの最大サイズを返す
+func (l *stationLink) MaxBundleSize() int {
+	return l.endpoint.Load().MaxBundleSize()
+}
+
+// LinkState 現在のエンドポイントのキープアライブが判定したリンク状態を返す
+func (l *stationLink) LinkState() bpsocket.LinkState {
+	return l.endpoint.Load().LinkState()
+}
+
+// Stats 現在のエンドポイントの送受信統計を返す
+func (l *stationLink) Stats() bpsocket.EndpointStats {
+	return l.endpoint.Load().Stats()
+}
+
+// Drain 現在のエンドポイントの受信を停止し、受信済みのバンドルを読み終えてからクローズする
+func (l *stationLink) Drain(ctx context.Context) error {
+	return l.endpoint.Load().Drain(ctx)
+}
+
+// Close 現在のエンドポイントをクローズする
+func (l *stationLink) Close() error {
+	return l.endpoint.Load().Close()
+}
+
+// superviseReceiver 受信ループを実行し、異常終了した場合は受信ソケットを作り直して再開する
+// Closeによる正常終了ではnilを、ソケットを作り直せなかった場合はエラーを返す
+func superviseReceiver(receiver bundleSource, newReceiver receiverFactory, urlChan chan<- CrawlRequest, maxReconnects int) error {
+	for {
+		err := runReceiver(receiver, urlChan)
+		if err == nil {
+			return nil
+		}
+		log.Printf("⚠️  BP receiver stopped: %v", err)
+
+		receiver, err = reconnectReceiver(newReceiver, maxReconnects)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reconnectReceiver 受信ソケットの作成を最大maxReconnects回試みる
+func reconnectReceiver(newReceiver receiverFactory, maxReconnects int) (bundleSource, error) {
+	var err error
+	for attempt := 1; attempt <= maxReconnects; attempt++ {
+		log.Printf("🔁 Reconnecting BP receiver in %v (attempt %d/%d)", reconnectDelay, attempt, maxReconnects)
+		time.Sleep(reconnectDelay)
+
+		var receiver bundleSource
+		receiver, err = newReceiver()
+		if err == nil {
+			log.Println("✅ BP receiver reconnected")
+			return receiver, nil
+		}
+		log.Printf("⚠️  Failed to recreate BP receiver: %v", err)
+	}
+	return nil, fmt.Errorf("failed to recreate BP receiver after %d attempts: %w", maxReconnects, err)
+}
+
+// runReceiver 受信ループが終了するまでバンドルを処理し、終了原因を返す（正常終了ではnil）
+func runReceiver(receiver bundleSource, urlChan chan<- CrawlRequest) error {
+	defer receiver.Close()
+
+	receiver.Start()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		recvStageBpSocket(receiver.GetDataChannel(), urlChan)
+	}()
+
+	var cause error
+	for ev := range receiver.Events() {
+		switch ev.Type {
+		case bpsocket.EventClosed:
+			cause = ev.Err
+		case bpsocket.EventRecvError:
+			// 個々のエラーはbpsocket側でログ出力済み
+		default:
+			log.Printf("⚠️  BP receiver event: %v", ev)
+		}
+	}
+	<-done
+
+	return cause
+}
@@ -0,0 +1,212 @@
+// supervisor_test.go - 受信ループの再接続のテスト
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"earth/bpsocket"
+)
+
+// brokenTransport 常に受信エラーを返すTransport（カーネルモジュールのアンロードを再現）
+type brokenTransport struct{}
+
+func (brokenTransport) SendTo(data []byte, to *bpsocket.SockaddrBP) error { return nil }
+func (brokenTransport) Recv(buf []byte) (int, *bpsocket.SockaddrBP, error) {
+	return 0, nil, errors.New("no such device")
+}
+func (brokenTransport) Close() error                    { return nil }
+func (brokenTransport) LocalAddr() *bpsocket.SockaddrBP { return bpsocket.NewSockaddrBP(150, 1) }
+
+func withoutReconnectDelay(t *testing.T) {
+	t.Helper()
+	orig := reconnectDelay
+	reconnectDelay = 0
+	t.Cleanup(func() { reconnectDelay = orig })
+}
+
+func TestSuperviseReceiverReconnectsAfterFailure(t *testing.T) {
+	withoutReconnectDelay(t)
+
+	network := bpsocket.NewLoopbackNetwork()
+	senderTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	sender := bpsocket.NewBpSenderWithTransport(senderTransport, 150, 1)
+	defer sender.Close()
+
+	broken := bpsocket.NewBpReceiverWithTransport(brokenTransport{}, bpsocket.WithMaxConsecutiveErrors(2))
+
+	reconnected := make(chan *bpsocket.BpReceiver, 1)
+	factory := func() (bundleSource, error) {
+		transport, err := network.Listen(150, 1)
+		if err != nil {
+			return nil, err
+		}
+		r := bpsocket.NewBpReceiverWithTransport(transport)
+		reconnected <- r
+		return r, nil
+	}
+
+	urlChan := make(chan CrawlRequest, 1)
+	result := make(chan error, 1)
+	go func() { result <- superviseReceiver(broken, factory, urlChan, 3) }()
+
+	var r *bpsocket.BpReceiver
+	select {
+	case r = <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Receiver was not recreated")
+	}
+
+	if err := sender.Send(context.Background(), bpsocket.DTNJsonRequest{RequestID: "r1", URL: "https://example.com"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	select {
+	case req := <-urlChan:
+		if req.RequestID != "r1" || req.URL != "https://example.com" {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Request was not received after reconnect")
+	}
+
+	r.Close()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Expected clean shutdown after Close, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("superviseReceiver did not return after Close")
+	}
+}
+
+func TestSuperviseReceiverGivesUp(t *testing.T) {
+	withoutReconnectDelay(t)
+
+	broken := bpsocket.NewBpReceiverWithTransport(brokenTransport{}, bpsocket.WithMaxConsecutiveErrors(1))
+	attempts := 0
+	factory := func() (bundleSource, error) {
+		attempts++
+		return nil, errors.New("module not loaded")
+	}
+
+	err := superviseReceiver(broken, factory, make(chan CrawlRequest, 1), 2)
+	if err == nil {
+		t.Fatal("Expected an error after exhausting reconnect attempts")
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 reconnect attempts, got %d", attempts)
+	}
+}
+
+func TestStationLinkSendsThroughReconnectedEndpoint(t *testing.T) {
+	network := bpsocket.NewLoopbackNetwork()
+	moonTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	moon := bpsocket.NewBpReceiverWithTransport(moonTransport)
+	moon.Start()
+	defer moon.Close()
+
+	newEndpoint := func() (*bpsocket.BpEndpoint, error) {
+		transport, err := network.Listen(150, 1)
+		if err != nil {
+			return nil, err
+		}
+		return bpsocket.NewBpEndpointWithTransport(transport), nil
+	}
+	first, err := newEndpoint()
+	if err != nil {
+		t.Fatalf("newEndpoint failed: %v", err)
+	}
+	link := newStationLink(first, "ipn:149.1")
+
+	// 受信ループの異常終了で古いエンドポイントがクローズされた後に再接続する
+	first.Close()
+	if _, err := link.reconnect(newEndpoint)(); err != nil {
+		t.Fatalf("reconnect failed: %v", err)
+	}
+	defer link.Close()
+
+	if err := link.SendWithOptions(context.Background(), "after-reconnect", sendOptionsForDepth(0)); err != nil {
+		t.Fatalf("Send after reconnect failed: %v", err)
+	}
+	select {
+	case bundle := <-moon.GetDataChannel():
+		if bundle.From.String() != "ipn:150.1" {
+			t.Errorf("Expected bundle from ipn:150.1, got %s", bundle.From.String())
+		}
+		bundle.Release()
+	case <-time.After(time.Second):
+		t.Fatal("Bundle was not delivered after reconnect")
+	}
+}
+
+func TestDrainPassesBufferedRequestsToPipeline(t *testing.T) {
+	network := bpsocket.NewLoopbackNetwork()
+	transport, err := network.Listen(150, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	endpoint := bpsocket.NewBpEndpointWithTransport(transport)
+	link := newStationLink(endpoint, "ipn:149.1")
+
+	moonTransport, err := network.Listen(149, 1)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	moon := bpsocket.NewBpSenderWithTransport(moonTransport, 150, 1)
+	defer moon.Close()
+
+	// URLチャネルを読まないため、受信ステージは1件目で止まり残りはデータチャネルに溜まる
+	urlChan := make(chan CrawlRequest)
+	result := make(chan error, 1)
+	go func() { result <- superviseReceiver(endpoint, nil, urlChan, 0) }()
+
+	const n = 3
+	for i := 0; i < n; i++ {
+		req := bpsocket.DTNJsonRequest{RequestID: fmt.Sprintf("r%d", i), URL: "https://example.com"}
+		if err := moon.Send(context.Background(), req); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for link.Stats().Received.Delivered < n {
+		if time.Now().After(deadline) {
+			t.Fatal("Requests were not received")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- link.Drain(context.Background()) }()
+
+	for i := 0; i < n; i++ {
+		select {
+		case req := <-urlChan:
+			if want := fmt.Sprintf("r%d", i); req.RequestID != want {
+				t.Errorf("Expected %s, got %s", want, req.RequestID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Request %d was not passed to the pipeline", i)
+		}
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("Drain failed: %v", err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Expected superviseReceiver to stop cleanly, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("superviseReceiver did not stop after Drain")
+	}
+}